@@ -12,7 +12,10 @@ Package errorutil contains common error objects and functions.
 */
 package errorutil
 
-import "strings"
+import (
+	"errors"
+	"strings"
+)
 
 /*
 AssertOk will panic on any non-nil error parameter.
@@ -57,3 +60,86 @@ Error returns all collected errors as a string.
 func (ce *CompositeError) Error() string {
 	return strings.Join(ce.Errors, "; ")
 }
+
+/*
+Error categories which can be used to map errors to a behavior (e.g. a HTTP
+status code). Errors belong to a category if they implement CategorizedError.
+*/
+var (
+	ErrNotFound    = errors.New("Not found")
+	ErrConflict    = errors.New("Conflict")
+	ErrCorruption  = errors.New("Corruption")
+	ErrQuota       = errors.New("Quota exceeded")
+	ErrInvalid     = errors.New("Invalid")
+	ErrReadOnly    = errors.New("Read only")
+	ErrInternal    = errors.New("Internal error")
+	ErrUnavailable = errors.New("Temporarily unavailable")
+)
+
+/*
+CategorizedError is an error which belongs to an error category.
+*/
+type CategorizedError interface {
+	error
+
+	/*
+		Category returns the category of this error.
+	*/
+	Category() error
+}
+
+/*
+NewCategorizedError creates a new error with a given message which belongs
+to a given category.
+*/
+func NewCategorizedError(category error, msg string) error {
+	return &categorizedError{category, msg}
+}
+
+/*
+categorizedError is a simple error with a category.
+*/
+type categorizedError struct {
+	category error
+	msg      string
+}
+
+/*
+Error returns the error message.
+*/
+func (ce *categorizedError) Error() string {
+	return ce.msg
+}
+
+/*
+Category returns the category of this error.
+*/
+func (ce *categorizedError) Category() error {
+	return ce.category
+}
+
+/*
+Category returns the category of a given error. Wrapped errors are unwrapped
+until an error with a category is found. Returns ErrInternal if no category
+could be found and nil if the given error is nil.
+*/
+func Category(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if ce, ok := e.(CategorizedError); ok && ce.Category() != nil {
+			return ce.Category()
+		}
+	}
+
+	return ErrInternal
+}
+
+/*
+IsCategory checks if a given error belongs to a given category.
+*/
+func IsCategory(err error, category error) bool {
+	return err != nil && Category(err) == category
+}
@@ -11,6 +11,7 @@ package errorutil
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 )
 
@@ -52,3 +53,35 @@ func TestCompositeError(t *testing.T) {
 		t.Error("Unexpected output:", ce.Error())
 	}
 }
+
+func TestCategorizedError(t *testing.T) {
+
+	err := NewCategorizedError(ErrNotFound, "test1")
+
+	if err.Error() != "test1" || err.(CategorizedError).Category() != ErrNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if Category(nil) != nil || IsCategory(nil, ErrInternal) {
+		t.Error("Nil error should have no category")
+		return
+	}
+
+	if Category(errors.New("test2")) != ErrInternal {
+		t.Error("Uncategorized errors should be internal errors")
+		return
+	}
+
+	if !IsCategory(err, ErrNotFound) || IsCategory(err, ErrConflict) {
+		t.Error("Unexpected category check result")
+		return
+	}
+
+	// Wrapped errors should keep their category
+
+	if !IsCategory(fmt.Errorf("wrapped: %w", err), ErrNotFound) {
+		t.Error("Wrapped error should have a category")
+		return
+	}
+}
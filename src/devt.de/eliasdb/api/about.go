@@ -26,6 +26,21 @@ Endpoint which returns an object with version information.
 	version:     : Version of the API provider
 	revision:    : Revision of the API provider
 
+/health
+
+Endpoint which returns the health of the datastore. While transaction logs are
+recovered after a crash the status is "recovering" (Return code 503) and the
+progress of each recovery is reported.
+
+	status   : Either "ok" or "recovering"
+	recovery : List of running recoveries with the following fields:
+		name              : Name of the transaction log
+		transactions      : Number of replayed transactions
+		bytes_read        : Number of bytes read from the transaction log
+		bytes_total       : Size of the transaction log
+		elapsed_seconds   : Time spent on the recovery
+		remaining_seconds : Estimated time until the recovery is finished
+
 /swagger.json
 
 Dynamically generated swagger definition file. See: http://swagger.io
@@ -0,0 +1,73 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"devt.de/eliasdb/storage/file"
+)
+
+/*
+EndpointHealth is the health endpoint URL (rooted). Handles health/
+*/
+const EndpointHealth = APIRoot + "/health/"
+
+/*
+HealthEndpointInst creates a new endpoint handler.
+*/
+func HealthEndpointInst() RestEndpointHandler {
+	return &healthEndpoint{}
+}
+
+/*
+Handler object for health operations.
+*/
+type healthEndpoint struct {
+	*DefaultEndpointHandler
+}
+
+/*
+HandleGET returns the health of the datastore.
+*/
+func (h *healthEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	recovery := make([]map[string]interface{}, 0)
+
+	for _, rp := range file.RecoveryStatus() {
+		recovery = append(recovery, map[string]interface{}{
+			"name":              rp.Name,
+			"transactions":      rp.Transactions,
+			"bytes_read":        rp.BytesRead,
+			"bytes_total":       rp.BytesTotal,
+			"elapsed_seconds":   rp.Elapsed().Seconds(),
+			"remaining_seconds": rp.Remaining().Seconds(),
+		})
+	}
+
+	data := map[string]interface{}{
+		"status":   "ok",
+		"recovery": recovery,
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	if len(recovery) > 0 {
+		data["status"] = "recovering"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
@@ -45,6 +45,7 @@ GeneralEndpointMap contains general endpoints which should always be available
 */
 var GeneralEndpointMap = map[string]RestEndpointInst{
 	EndpointAbout:   AboutEndpointInst,
+	EndpointHealth:  HealthEndpointInst,
 	EndpointSwagger: SwaggerEndpointInst,
 }
 
@@ -16,11 +16,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 	"testing"
 
 	"devt.de/common/httputil"
+	"devt.de/eliasdb/storage/file"
 	"devt.de/eliasdb/version"
 )
 
@@ -129,6 +131,24 @@ func TestEndpointHandling(t *testing.T) {
 		return
 	}
 
+	// Test health endpoint
+
+	if res := sendTestRequest(queryURL+"/db/health", "GET", nil); res != `
+{
+  "recovery": [],
+  "status": "ok"
+}`[1:] {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	// Check the health while a transaction log is recovered
+
+	if err := testRecoveryHealth(queryURL); err != nil {
+		t.Error(err)
+		return
+	}
+
 	if res := sendTestRequest(queryURL+"/db/swagger.json", "GET", nil); res != `
 {
   "basePath": "/db",
@@ -136,6 +156,23 @@ func TestEndpointHandling(t *testing.T) {
     "Error": {
       "description": "A human readable error mesage.",
       "type": "string"
+    },
+    "Health": {
+      "properties": {
+        "recovery": {
+          "description": "List of running transaction log recoveries.",
+          "items": {
+            "description": "Progress of a transaction log recovery.",
+            "type": "object"
+          },
+          "type": "array"
+        },
+        "status": {
+          "description": "Status of the datastore (ok or recovering).",
+          "type": "string"
+        }
+      },
+      "type": "object"
     }
   },
   "host": "localhost:9090",
@@ -186,6 +223,36 @@ func TestEndpointHandling(t *testing.T) {
         },
         "summary": "Return information about the REST API provider."
       }
+    },
+    "/health": {
+      "get": {
+        "description": "Returns the status of the datastore and the progress of running transaction log recoveries.",
+        "produces": [
+          "text/plain",
+          "application/json"
+        ],
+        "responses": {
+          "200": {
+            "description": "Health object",
+            "schema": {
+              "$ref": "#/definitions/Health"
+            }
+          },
+          "503": {
+            "description": "Health object while transaction logs are recovered",
+            "schema": {
+              "$ref": "#/definitions/Health"
+            }
+          },
+          "default": {
+            "description": "Error response",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        },
+        "summary": "Return the health of the datastore."
+      }
     }
   },
   "produces": [
@@ -203,6 +270,63 @@ func TestEndpointHandling(t *testing.T) {
 	stopServer(hs, wg)
 }
 
+/*
+testRecoveryHealth recovers a transaction log and queries the health endpoint
+during the recovery.
+*/
+func testRecoveryHealth(queryURL string) error {
+	var res string
+
+	dbdir := "resttestdb"
+
+	os.RemoveAll(dbdir)
+	os.Mkdir(dbdir, 0770)
+	defer os.RemoveAll(dbdir)
+
+	// Write a transaction log with a single empty transaction
+
+	tlog := append(append([]byte{}, file.TransactionLogHeader...), make([]byte, 8)...)
+
+	if err := ioutil.WriteFile(dbdir+"/test."+file.LogFileSuffix, tlog, 0660); err != nil {
+		return err
+	}
+
+	oldLogRecovery := file.LogRecovery
+	file.LogRecovery = func(v ...interface{}) {
+		if res == "" {
+			res = sendTestRequest(queryURL+"/db/health", "GET", nil)
+		}
+	}
+	defer func() {
+		file.LogRecovery = oldLogRecovery
+	}()
+
+	sf, err := file.NewDefaultStorageFile(dbdir+"/test", false)
+	if err != nil {
+		return err
+	}
+
+	if err := sf.Close(); err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(res, `
+{
+  "recovery": [
+    {
+      "bytes_read": 2,
+      "bytes_total": 10,
+      "elapsed_seconds": `[1:]) ||
+		!strings.Contains(res, `"name": "resttestdb/test.tlg",`) ||
+		!strings.Contains(res, `"transactions": 0`) ||
+		!strings.HasSuffix(res, `"status": "recovering"
+}`) {
+		return fmt.Errorf("Unexpected response: %v", res)
+	}
+
+	return nil
+}
+
 /*
 Send a request to a HTTP test server
 */
@@ -73,6 +73,72 @@ func (a *aboutEndpoint) SwaggerDefs(s map[string]interface{}) {
 	}
 }
 
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (h *healthEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	// Add query paths
+
+	s["paths"].(map[string]interface{})["/health"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the health of the datastore.",
+			"description": "Returns the status of the datastore and the progress of running transaction log recoveries.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "Health object",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Health",
+					},
+				},
+				"503": map[string]interface{}{
+					"description": "Health object while transaction logs are recovered",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Health",
+					},
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add health object to definition
+
+	s["definitions"].(map[string]interface{})["Health"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"status": map[string]interface{}{
+				"description": "Status of the datastore (ok or recovering).",
+				"type":        "string",
+			},
+			"recovery": map[string]interface{}{
+				"description": "List of running transaction log recoveries.",
+				"type":        "array",
+				"items": map[string]interface{}{
+					"description": "Progress of a transaction log recovery.",
+					"type":        "object",
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
+
 /*
 EndpointSwagger is the swagger endpoint URL (rooted). Handles swagger.json/
 */
@@ -0,0 +1,138 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"devt.de/eliasdb/api"
+)
+
+/*
+EndpointArchive is the partition archive endpoint URL (rooted). Handles everything under archive/...
+*/
+const EndpointArchive = api.APIRoot + APIv1 + "/archive/"
+
+/*
+ArchiveEndpointInst creates a new endpoint handler.
+*/
+func ArchiveEndpointInst() api.RestEndpointHandler {
+	return &archiveEndpoint{}
+}
+
+/*
+Handler object for partition archive operations.
+*/
+type archiveEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a REST call to list all archived partitions.
+*/
+func (ae *archiveEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	data := api.GM.ArchivedPartitions()
+
+	if data == nil {
+		data = []string{}
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
+
+/*
+HandlePOST handles a REST call to archive a partition.
+*/
+func (ae *archiveEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 1, 1, "Need a partition") {
+		return
+	}
+
+	if err := api.GM.ArchivePartition(resources[0]); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ae *archiveEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/archive"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all archived partitions.",
+			"description": "The archive endpoint returns the names of all partitions which were sealed into readonly archives.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of partition names.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/archive/{partition}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Archive a partition.",
+			"description": "The data of the partition is sealed into compressed readonly archives. The partition can still be queried but all write operations are rejected.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to archive.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The partition was archived.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,37 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import "testing"
+
+func TestArchiveEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointArchive
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", nil)
+	if st != "400 Bad Request" || res != "Need a partition" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// The test graph is held in memory which cannot be archived
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST", nil)
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Graph storage does not support archiving)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
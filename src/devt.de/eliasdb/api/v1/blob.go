@@ -89,6 +89,72 @@ info of every member as a key-value map:
 Returns the latest cluster related log messages. A DELETE call will clear
 the current log.
 
+Partition archive endpoint
+
+/archive/<partition>
+
+A POST request seals a partition into compressed readonly archives. The data
+of an archived partition can still be queried (reading is slower) but all
+write operations are rejected. Archiving is only available for disk based
+storage. A GET request to /archive returns a list of all archived partitions.
+
+Federated query endpoint
+
+/federation/<partition>?q=<query>
+
+A GET request runs an EQL query on this instance and on remote EliasDB
+instances (e.g. one database per region). Remote instances are queried through
+their query endpoint and the rows of all instances are joined into a single
+result. The optional parameter remotes is a comma separated list of the remote
+instances which should be queried (default is all remote instances). This
+instance is not queried if the parameter local is set to false. The result has
+the same structure as the result of the query endpoint with an additional
+list which contains the instance of each row:
+
+	{
+		header    : <Result header>,
+		rows      : [ <Result rows> ],
+		sources   : [ <Sources of the result rows> ],
+		instances : [ <Instance of each row - local for this instance> ]
+	}
+
+A GET request to /federation returns a list of all remote instances.
+
+Bulk delete endpoint
+
+/delete/<partition>/<node kind>
+
+The bulk delete endpoint deletes all nodes of a kind which match an EQL where
+clause. Nodes are deleted server-side in batched transactions. Deleting is a
+two step process. A POST request without a confirmation token is a dry run
+and should have the following datastructure:
+
+	{
+	    where : <EQL where clause e.g. name = "foo">
+	}
+
+The return data contains the number of matching nodes and a confirmation token:
+
+	{
+	    count : <number of matching nodes>,
+	    token : <confirmation token>
+	}
+
+The nodes are deleted by sending the same request with the confirmation token:
+
+	{
+	    where : <EQL where clause>,
+	    token : <confirmation token>
+	}
+
+A token can only be used once and expires after a few minutes. The request
+is rejected if the number of matching nodes changed since the dry run. The
+return data contains the number of deleted nodes:
+
+	{
+	    deleted : <number of deleted nodes>
+	}
+
 Graph request enpoint
 
 /graph
@@ -125,6 +191,20 @@ A list of edges:
 
 	[ { <attr> : <value> }, ... ]
 
+By default all elements of a request are stored in a single transaction and
+nothing is stored if one element fails. If the parameter partial is set to true
+then every node and edge is committed in its own transaction (nodes before
+edges). Failing elements do not prevent other elements from being stored and
+the response details the outcome of every element:
+
+	{
+		nodes     : [ { key : <key>, kind : <kind>, success : <true/false>,
+		                error : <error message if not successful> }, ... ],
+		edges     : [ <Outcome of every edge> ],
+		succeeded : <Number of stored elements>,
+		failed    : <Number of failed elements>
+	}
+
 GET requests can be used to query single or a series of nodes. The endpoints
 support the limit and offset parameters for lists:
 
@@ -162,6 +242,89 @@ header is not set.
 	]
 
 
+Import endpoint
+
+/import/<partition>
+
+The import endpoint maps an arbitrary JSON document to nodes and edges using a
+mapping template. A POST request should have the following datastructure:
+
+	{
+	    template : {
+	        nodes : [
+	            {
+	                path  : <JSONPath which selects the elements to map>,
+	                kind  : <node kind>,
+	                key   : <JSONPath of the node key (relative to the element)>,
+	                attrs : { <attr> : <JSONPath of the value>, ... },
+	                edges : [
+	                    {
+	                        path        : <JSONPath which selects edge elements (default is @)>,
+	                        kind        : <edge kind>,
+	                        key         : <JSONPath of the edge key (default is <node key>-<target key>)>,
+	                        role        : <role of the mapped node>,
+	                        cascading   : <cascading flag of the mapped node>,
+	                        target_kind : <kind of the target node>,
+	                        target_key  : <JSONPath of the target node key>,
+	                        target_role : <role of the target node>,
+	                        target_cascading : <cascading flag of the target node>,
+	                        attrs       : { <attr> : <JSONPath of the value>, ... }
+	                    },
+	                    ...
+	                ]
+	            },
+	            ...
+	        ]
+	    },
+	    data : <JSON document>
+	}
+
+JSONPath expressions start with $ (the document root) or @ (the currently
+mapped element) and support child access (.name or ['name']), array
+indices ([0]) and wildcards (.* or [*]). All mapped nodes and edges are stored
+in a single transaction. The return data is a key-value map:
+
+	{
+	    nodes : <number of imported nodes>,
+	    edges : <number of imported edges>
+	}
+
+The request body may be compressed with any registered compression codec. The
+codec is detected through the header of the compressed data.
+
+Invariants endpoint
+
+/invariants
+
+The invariants endpoint manages declarative graph invariants. An invariant
+requires that every node of a kind has between min and max edges which match
+a (partial) traversal spec. Invariants are checked whenever a node of the kind
+or an edge which is connected to it changes. Violations are recorded as nodes
+of kind InvariantViolation in the partition of the violating node and are
+removed once the violation is resolved. A PUT request declares an invariant
+and should have the following datastructure:
+
+	{
+	    name : <name of the invariant>,
+	    kind : <node kind>,
+	    spec : <traversal spec e.g. order:PlacedBy:customer:Customer>,
+	    min  : <minimum number of matching edges>,
+	    max  : <maximum number of matching edges (-1 for no limit)>
+	}
+
+A GET request returns all invariants. Each invariant has in addition the
+number of checks and the number of found violations.
+
+A DELETE request removes an invariant:
+
+/invariants/<name>
+
+A POST request checks all existing nodes of a partition and returns a list
+of violation nodes:
+
+/invariants/<partition>
+
+
 Index query endpoint
 
 /index
@@ -207,6 +370,16 @@ The return data is a list of node keys:
 
 	[ <node key1>, <node key2>, ... ]
 
+A fuzzy search finds all nodes where an attribute contains a word which
+differs by at most a given number of edits (default 1, at most 2) from a
+certain word. The full text index of the node kind must support fuzzy
+lookups (see graph.Manager.SetIndexOptions). A request url which runs a new
+fuzzy search should be of the following form:
+
+/index/<partition>/n/<node kind>?fuzzy=<word>&distance=<distance>&attr=<attribute>
+
+The return data is a map which maps node key to a list of word positions.
+
 General database information endpoint
 
 /info
@@ -258,6 +431,179 @@ The return data is a result object:
 	    rows    : [ [ <col1>, <col2>, ... ] ],
 	    sources : [ [ <src col1>, <src col2>, ... ] ],
 	}
+
+If the query groups its result (e.g. with grouping(2)) the result object
+contains the returned rows also grouped by the node at the grouping traversal
+step:
+
+	    groups  : [ {
+	        kind    : <kind of the grouping node>,
+	        key     : <key of the grouping node>,
+	        rows    : [ [ <col1>, <col2>, ... ] ],
+	        sources : [ [ <src col1>, <src col2>, ... ] ],
+	    } ]
+
+Large results can be written directly to a configured cloud storage bucket
+instead of being returned to the client. A POST request with the target and
+object parameters writes the result of a query or a cached result (see the
+export endpoint for the codec parameters):
+
+/query/<partition>?q=<query>&target=<target>&object=<object name>
+
+/query/<partition>?rid=<result id>&target=<target>&object=<object name>
+
+The written object contains the full result object. The return data is a
+key-value map:
+
+	{
+	    target : <cloud storage target>,
+	    object : <object name>,
+	    rows   : <number of written rows>,
+	    size   : <size of the written object in bytes>
+	}
+
+Export endpoint
+
+/export/<partition>?target=<target>&object=<object name>
+
+A POST request writes the contents of a partition as JSON directly to an object
+in a configured cloud storage bucket (see the CloudTargets configuration option).
+The data is uploaded in parts while it is produced so exports of any size
+do not need to pass through the client. The export has the following format:
+
+	{
+		nodes : [ { <attr> : <value> }, ... ],
+		edges : [ { <attr> : <value> }, ... ]
+	}
+
+The export can be compressed with any registered compression codec (e.g. gzip,
+flate or zlib) by adding the codec and optionally the level parameter:
+
+/export/<partition>?target=<target>&object=<object name>&codec=<codec>&level=<level>
+
+Compressed objects start with a header which identifies the used codec. The
+same codec parameters are supported when query results are written to a cloud
+storage target (see the query endpoint). The return data is a key-value map:
+
+	{
+	    target : <cloud storage target>,
+	    object : <object name>,
+	    size   : <size of the written object in bytes>
+	}
+
+A compatibility export which can be imported by the previous major version
+(e.g. to roll back after an upgrade) can be written by adding the compat
+parameter:
+
+/export/<partition>?target=<target>&object=<object name>&compat=true
+
+Attribute values which are not plain JSON values are mapped to their JSON
+representation and values which cannot be represented are dropped. The return
+data contains an additional list of warnings with one entry for every mapped
+or dropped value. Compatibility exports cannot be compressed.
+
+Node kind statistics endpoint
+
+/stats/<partition>/<node kind>
+
+The stats endpoint returns statistics of a node kind. Statistics are collected
+on the first GET request and are refreshed automatically once enough nodes of
+the kind have been changed. A POST request refreshes the statistics immediately.
+The query planner uses the statistics to choose the value index lookup which
+is expected to return the fewest nodes.
+
+The return data is a key-value map:
+
+	{
+	    partition : <partition>,
+	    kind      : <node kind>,
+	    count     : <number of nodes>,
+	    mutations : <number of node changes since the statistics were collected>,
+	    refreshed : <time when the statistics were collected>,
+	    attrs     : { <attr> : { count : <number of nodes>, distinct : <estimated number of distinct values> }, ... }
+	}
+
+Memory usage endpoint
+
+/memory
+
+The memory endpoint returns an estimate of the memory which is held by the
+datastore. Sizes are in bytes.
+
+The return data is a key-value map:
+
+	{
+	    runtime : {
+	        alloc        : <allocated heap bytes>,
+	        sys          : <bytes obtained from the operating system>,
+	        heap_objects : <number of allocated heap objects>,
+	        num_gc       : <number of completed GC cycles>,
+	    },
+	    graph   : {
+	        map_cache      : <bytes held by cached maps of the main database>,
+	        key_index      : <bytes held by the node key index>,
+	        key_index_keys : <number of keys in the node key index>,
+	        kind_stats     : <bytes held by node kind statistics>,
+	    },
+	    storage : {
+	        records       : <bytes of storage records held in memory>,
+	        trans_records : <bytes of storage records held for transactions>,
+	        cache_objects : <number of objects in storage caches>,
+	        cache_bytes   : <bytes of records of objects in storage caches>,
+	    },
+	    query   : {
+	        cached_results : <number of cached query results>,
+	    }
+	}
+
+The storage section is only returned for disk based storage.
+
+Size report endpoint
+
+/size
+
+The size endpoint returns the number of bytes which are used by the nodes,
+edges and index entries of each kind. Sizes are estimated from the allocated
+storage slots and are only available for disk based storage. All stored data
+is visited so this can be an expensive operation.
+
+The return data is a key-value map:
+
+	{
+	    <kind> : {
+	        nodes      : <bytes used by nodes>,
+	        node_index : <bytes used by node index entries>,
+	        edges      : <bytes used by edges>,
+	        edge_index : <bytes used by edge index entries>,
+	        total      : <total bytes of the kind>,
+	    },
+	    ...
+	}
+
+Active queries endpoint
+
+/queries
+
+The queries endpoint returns all queries which are currently executed.
+
+The return data is a list of key-value maps:
+
+	[
+	    {
+	        id      : <query id>,
+	        query   : <query text>,
+	        part    : <queried partition>,
+	        client  : <address of the client which issued the query>,
+	        elapsed : <seconds since the query was started>,
+	        rows    : <number of rows produced so far>,
+	    },
+	    ...
+	]
+
+/queries/<id>
+
+A DELETE request cancels a running query. The query fails with an error
+once it is cancelled.
 */
 package v1
 
@@ -356,7 +702,7 @@ func (be *blobEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resou
 	loc, err := sm.Insert(buf.Bytes())
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -400,7 +746,7 @@ func (be *blobEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resour
 		buf.ReadFrom(r.Body)
 
 		if err := sm.Update(loc, buf.Bytes()); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), blobErrorStatus(err))
 			return
 		}
 
@@ -431,7 +777,7 @@ func (be *blobEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, res
 	if sm != nil {
 
 		if err := sm.Free(loc); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), blobErrorStatus(err))
 			return
 		}
 
@@ -439,6 +785,19 @@ func (be *blobEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, res
 	}
 }
 
+/*
+blobErrorStatus returns the HTTP status code for an error of a blob operation.
+The data id is given by the client - a missing slot means that the requested
+data does not exist.
+*/
+func blobErrorStatus(err error) int {
+	if err == storage.ErrSlotNotFound {
+		return http.StatusNotFound
+	}
+
+	return errorStatus(err)
+}
+
 /*
 SwaggerDefs is used to describe the endpoint in swagger.
 */
@@ -121,7 +121,7 @@ func TestBlob(t *testing.T) {
 
 	st, _, res = sendTestRequest(queryURL+"1", "PUT", []byte{0x0b, 0x0c})
 
-	if st != "500 Internal Server Error" || res != "Slot not found (mystorage/mypart.blob - Location:1)" {
+	if st != "404 Not Found" || res != "Slot not found (mystorage/mypart.blob - Location:1)" {
 		t.Error("Unexpected response:", st, res)
 		return
 	}
@@ -146,7 +146,7 @@ func TestBlob(t *testing.T) {
 
 	st, _, res = sendTestRequest(queryURL+"1", "DELETE", nil)
 
-	if st != "500 Internal Server Error" || res != "Slot not found (mystorage/mypart.blob - Location:1)" {
+	if st != "404 Not Found" || res != "Slot not found (mystorage/mypart.blob - Location:1)" {
 		t.Error("Unexpected response:", st, res)
 		return
 	}
@@ -0,0 +1,161 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/graph"
+)
+
+/*
+EndpointDefinitions is the definitions endpoint URL (rooted). Handles everything under definitions/...
+*/
+const EndpointDefinitions = api.APIRoot + APIv1 + "/definitions/"
+
+/*
+DefinitionsEndpointInst creates a new endpoint handler.
+*/
+func DefinitionsEndpointInst() api.RestEndpointHandler {
+	return &definitionsEndpoint{}
+}
+
+/*
+Handler object for definitions documents.
+*/
+type definitionsEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a REST call to return the current definitions document.
+*/
+func (de *definitionsEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(api.GM.Definitions())
+}
+
+/*
+HandlePUT handles a REST call to apply a definitions document.
+*/
+func (de *definitionsEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	prune := r.URL.Query().Get("prune") == "true"
+	dryRun := r.URL.Query().Get("dryrun") == "true"
+
+	defs, err := graph.ParseDefinitions(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	changes, err := api.GM.ApplyDefinitions(defs, prune, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(changes)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (de *definitionsEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/definitions"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the current definitions document.",
+			"description": "The definitions endpoint returns a document which describes all declared integrity rules. The document can be kept under version control and applied to another database.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A definitions document.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+		"put": map[string]interface{}{
+			"summary":     "Apply a definitions document.",
+			"description": "The definitions endpoint can be used to make the declared integrity rules match a definitions document. Applying the same document more than once has no further effect.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "definitions",
+					"in":          "body",
+					"description": "Definitions document with a version and a list of invariants.",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+				map[string]interface{}{
+					"name":        "prune",
+					"in":          "query",
+					"description": "Remove all definitions which are not part of the document.",
+					"required":    false,
+					"type":        "boolean",
+				},
+				map[string]interface{}{
+					"name":        "dryrun",
+					"in":          "query",
+					"description": "Only report the changes without applying them.",
+					"required":    false,
+					"type":        "boolean",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The created, updated, removed and unchanged definitions.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"testing"
+
+	"devt.de/eliasdb/api"
+)
+
+func TestDefinitionsEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointDefinitions
+
+	defs := []byte(`{ "version" : 1, "invariants" : [
+  { "name" : "defOwner", "kind" : "DefTest", "spec" : ":Owner::", "min" : 1 } ] }`)
+
+	st, _, res := sendTestRequest(queryURL+"?dryrun=true", "PUT", defs)
+	if st != "200 OK" || res != `
+{
+  "created": [
+    "invariant:defOwner"
+  ],
+  "updated": [],
+  "removed": [],
+  "unchanged": []
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if len(api.GM.Invariants()) != 0 {
+		t.Error("Unexpected invariants:", api.GM.Invariants())
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", defs)
+	if st != "200 OK" || len(api.GM.Invariants()) != 1 {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", defs)
+	if st != "200 OK" || res != `
+{
+  "created": [],
+  "updated": [],
+  "removed": [],
+  "unchanged": [
+    "invariant:defOwner"
+  ]
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != `
+{
+  "version": 1,
+  "invariants": [
+    {
+      "name": "defOwner",
+      "kind": "DefTest",
+      "spec": ":Owner::",
+      "min": 1,
+      "max": -1
+    }
+  ]
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Remove all definitions
+
+	st, _, res = sendTestRequest(queryURL+"?prune=true", "PUT", []byte(`{ "version" : 1 }`))
+	if st != "200 OK" || len(api.GM.Invariants()) != 0 {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", []byte(`{ "version" : 1, "indexes" : [] }`))
+	if st != "400 Bad Request" || res != `GraphError: Invalid data (Invalid definitions: json: unknown field "indexes")` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", []byte(`{ "version" : 1, "invariants" : [ { "name" : "a b" } ] }`))
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Invalid invariant a b: Name must be alphanumeric)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
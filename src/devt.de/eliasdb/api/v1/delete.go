@@ -0,0 +1,325 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"devt.de/common/stringutil"
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/eql"
+	"devt.de/eliasdb/graph"
+)
+
+/*
+EndpointDelete is the bulk delete endpoint URL (rooted). Handles everything under delete/...
+*/
+const EndpointDelete = api.APIRoot + APIv1 + "/delete/"
+
+/*
+DeleteBatchSize is the number of nodes which are removed in a single transaction.
+*/
+var DeleteBatchSize = 1000
+
+/*
+DeleteTokenTimeout is the time after which an unused confirmation token expires.
+*/
+var DeleteTokenTimeout = 5 * time.Minute
+
+/*
+deleteToken is a confirmation token which was issued by a dry run.
+*/
+type deleteToken struct {
+	part    string    // Partition of the dry run
+	kind    string    // Node kind of the dry run
+	where   string    // Where clause of the dry run
+	count   int       // Number of matching nodes during the dry run
+	expires time.Time // Expiry time of the token
+}
+
+/*
+deleteTokens holds all issued confirmation tokens.
+*/
+var deleteTokens = make(map[string]*deleteToken)
+
+/*
+deleteTokensLock protects the deleteTokens map.
+*/
+var deleteTokensLock = &sync.Mutex{}
+
+/*
+DeleteEndpointInst creates a new endpoint handler.
+*/
+func DeleteEndpointInst() api.RestEndpointHandler {
+	return &deleteEndpoint{}
+}
+
+/*
+Handler object for bulk delete operations.
+*/
+type deleteEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+deleteRequest is the expected body of a bulk delete request.
+*/
+type deleteRequest struct {
+	Where string `json:"where"` // Where clause which selects the nodes
+	Token string `json:"token"` // Confirmation token of a previous dry run
+}
+
+/*
+HandlePOST handles a REST call to delete all nodes of a kind which match a
+where clause. A request without a confirmation token is a dry run which only
+counts the matching nodes and issues a token.
+*/
+func (de *deleteEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 2, 2, "Need a partition and a node kind") {
+		return
+	}
+
+	req := &deleteRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "Could not decode request body: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if req.Where == "" {
+		http.Error(w, "Need a where clause", http.StatusBadRequest)
+		return
+	}
+
+	part, kind := resources[0], resources[1]
+
+	keys, err := de.matchingKeys(r, part, kind, req.Where)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	var ret map[string]interface{}
+
+	if req.Token == "" {
+
+		// Dry run - count matching nodes and issue a confirmation token
+
+		ret = map[string]interface{}{
+			"count": len(keys),
+			"token": issueDeleteToken(part, kind, req.Where, len(keys)),
+		}
+
+	} else {
+
+		if !checkDeleteToken(w, req.Token, part, kind, req.Where, len(keys)) {
+			return
+		}
+
+		deleted, err := deleteNodes(part, kind, keys)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Could not delete all nodes (%v deleted): %v",
+				deleted, err.Error()), errorStatus(err))
+			return
+		}
+
+		ret = map[string]interface{}{
+			"deleted": deleted,
+		}
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	json.NewEncoder(w).Encode(ret)
+}
+
+/*
+matchingKeys returns the keys of all nodes of a kind which match a where clause.
+*/
+func (de *deleteEndpoint) matchingKeys(r *http.Request, part string, kind string,
+	where string) ([]string, error) {
+
+	res, err := eql.RunQueryContext(r.Context(), r.RemoteAddr,
+		stringutil.CreateDisplayString(part)+" delete query", part,
+		fmt.Sprintf("get %v where %v show key", kind, where), api.GM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, res.RowCount())
+
+	for _, row := range res.Rows() {
+		keys = append(keys, fmt.Sprint(row[0]))
+	}
+
+	return keys, nil
+}
+
+/*
+issueDeleteToken creates a new confirmation token for a dry run. Expired
+tokens are removed.
+*/
+func issueDeleteToken(part string, kind string, where string, count int) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	token := hex.EncodeToString(b)
+	now := time.Now()
+
+	deleteTokensLock.Lock()
+	defer deleteTokensLock.Unlock()
+
+	for t, dt := range deleteTokens {
+		if now.After(dt.expires) {
+			delete(deleteTokens, t)
+		}
+	}
+
+	deleteTokens[token] = &deleteToken{part, kind, where, count, now.Add(DeleteTokenTimeout)}
+
+	return token
+}
+
+/*
+checkDeleteToken checks and consumes a confirmation token. Writes an error and
+returns false if the token is not valid for the given request or if the
+number of matching nodes changed since the dry run.
+*/
+func checkDeleteToken(w http.ResponseWriter, token string, part string, kind string,
+	where string, count int) bool {
+
+	deleteTokensLock.Lock()
+	dt, ok := deleteTokens[token]
+	delete(deleteTokens, token)
+	deleteTokensLock.Unlock()
+
+	if !ok || time.Now().After(dt.expires) {
+		http.Error(w, "Unknown or expired confirmation token", http.StatusBadRequest)
+		return false
+
+	} else if dt.part != part || dt.kind != kind || dt.where != where {
+		http.Error(w, "Confirmation token was issued for a different request", http.StatusBadRequest)
+		return false
+
+	} else if dt.count != count {
+		http.Error(w, fmt.Sprintf("Number of matching nodes changed since the dry run (%v -> %v) - "+
+			"request a new confirmation token", dt.count, count), http.StatusConflict)
+		return false
+	}
+
+	return true
+}
+
+/*
+deleteNodes removes the given nodes in batched transactions. Returns the number
+of deleted nodes.
+*/
+func deleteNodes(part string, kind string, keys []string) (int, error) {
+	deleted := 0
+
+	for len(keys) > 0 {
+		batch := keys
+
+		if DeleteBatchSize > 0 && len(batch) > DeleteBatchSize {
+			batch = keys[:DeleteBatchSize]
+		}
+
+		trans := graph.NewGraphTrans(api.GM)
+
+		for _, key := range batch {
+			if err := trans.RemoveNode(part, key, kind); err != nil {
+				return deleted, err
+			}
+		}
+
+		if err := trans.Commit(); err != nil {
+			return deleted, err
+		}
+
+		deleted += len(batch)
+		keys = keys[len(batch):]
+	}
+
+	return deleted, nil
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (de *deleteEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/delete/{partition}/{kind}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Delete all nodes of a kind which match a where clause.",
+			"description": "A request without a confirmation token is a dry run which returns the number of matching nodes and a confirmation token. A request with the token deletes the matching nodes in batched transactions.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to delete from.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "kind",
+					"in":          "path",
+					"description": "Kind of the nodes to delete.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "request",
+					"in":          "body",
+					"description": "Object with an EQL where clause and an optional confirmation token.",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The number of matching nodes and a confirmation token or the number of deleted nodes.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
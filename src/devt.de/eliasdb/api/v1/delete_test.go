@@ -0,0 +1,143 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/graph/data"
+)
+
+func TestDeleteEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointDelete
+
+	for i := 0; i < 5; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint("item", i))
+		node.SetAttr("kind", "Item")
+		node.SetAttr("tag", fmt.Sprint("g", i%2))
+		api.GM.StoreNode("deltest", node)
+	}
+
+	st, _, res := sendTestRequest(queryURL+"deltest", "POST", nil)
+	if st != "400 Bad Request" || res != "Need a partition and a node kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"deltest/Item", "POST", []byte("{"))
+	if st != "400 Bad Request" || res != "Could not decode request body: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"deltest/Item", "POST", []byte("{}"))
+	if st != "400 Bad Request" || res != "Need a where clause" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"deltest/Item", "POST", []byte(`{ "where" : "tag =" }`))
+	if st != "400 Bad Request" || !strings.Contains(res, "Invalid construct") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	dryRun := func(where string) (float64, string) {
+		st, _, res := sendTestRequest(queryURL+"deltest/Item", "POST",
+			[]byte(fmt.Sprintf(`{ "where" : %q }`, where)))
+
+		var ret map[string]interface{}
+
+		if st != "200 OK" || json.Unmarshal([]byte(res), &ret) != nil {
+			t.Error("Unexpected response:", st, res)
+			return 0, ""
+		}
+
+		return ret["count"].(float64), ret["token"].(string)
+	}
+
+	// A dry run does not delete anything
+
+	count, token := dryRun(`tag = "g0"`)
+	if count != 3 || token == "" {
+		t.Error("Unexpected result:", count, token)
+		return
+	}
+
+	if c := api.GM.NodeCount("Item"); c != 5 {
+		t.Error("Unexpected node count:", c)
+		return
+	}
+
+	// Tokens are bound to the request they were issued for
+
+	st, _, res = sendTestRequest(queryURL+"deltest/Item", "POST",
+		[]byte(fmt.Sprintf(`{ "where" : "tag = 'g1'", "token" : %q }`, token)))
+	if st != "400 Bad Request" || res != "Confirmation token was issued for a different request" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Tokens can only be used once
+
+	st, _, res = sendTestRequest(queryURL+"deltest/Item", "POST",
+		[]byte(fmt.Sprintf(`{ "where" : "tag = \"g0\"", "token" : %q }`, token)))
+	if st != "400 Bad Request" || res != "Unknown or expired confirmation token" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Changes after the dry run invalidate the token
+
+	_, token = dryRun(`tag = "g0"`)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "item5")
+	node.SetAttr("kind", "Item")
+	node.SetAttr("tag", "g0")
+	api.GM.StoreNode("deltest", node)
+
+	st, _, res = sendTestRequest(queryURL+"deltest/Item", "POST",
+		[]byte(fmt.Sprintf(`{ "where" : "tag = \"g0\"", "token" : %q }`, token)))
+	if st != "409 Conflict" || res != "Number of matching nodes changed since the dry run (3 -> 4) - request a new confirmation token" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Delete the nodes in several batches
+
+	oldBatchSize := DeleteBatchSize
+	DeleteBatchSize = 3
+	defer func() {
+		DeleteBatchSize = oldBatchSize
+	}()
+
+	count, token = dryRun(`tag = "g0"`)
+
+	st, _, res = sendTestRequest(queryURL+"deltest/Item", "POST",
+		[]byte(fmt.Sprintf(`{ "where" : "tag = \"g0\"", "token" : %q }`, token)))
+	if st != "200 OK" || res != `{
+  "deleted": 4
+}` || count != 4 {
+		t.Error("Unexpected response:", st, res, count)
+		return
+	}
+
+	if c := api.GM.NodeCount("Item"); c != 2 {
+		t.Error("Unexpected node count:", c)
+		return
+	}
+}
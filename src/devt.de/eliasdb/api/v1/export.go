@@ -0,0 +1,282 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/cloudstore"
+	"devt.de/eliasdb/compress"
+	"devt.de/eliasdb/graph"
+)
+
+/*
+EndpointExport is the export endpoint URL (rooted). Handles everything under export/...
+*/
+const EndpointExport = api.APIRoot + APIv1 + "/export/"
+
+/*
+ExportEndpointInst creates a new endpoint handler.
+*/
+func ExportEndpointInst() api.RestEndpointHandler {
+	return &exportEndpoint{}
+}
+
+/*
+Handler object for exports to cloud storage targets.
+*/
+type exportEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandlePOST handles a REST call to export a partition to a cloud storage target.
+*/
+func (ee *exportEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 1, 1, "Need a partition") {
+		return
+	}
+
+	if !checkMemoryLimit(w) {
+		return
+	}
+
+	part := resources[0]
+	target := r.URL.Query().Get("target")
+	object := r.URL.Query().Get("object")
+
+	if target == "" {
+		http.Error(w, "Missing cloud storage target (target parameter)", http.StatusBadRequest)
+		return
+	}
+
+	// Check if the export should be readable by the previous major version
+
+	compat := r.URL.Query().Get("compat") == "true"
+
+	if compat && r.URL.Query().Get("codec") != "" {
+		http.Error(w, "Compressed exports cannot be written in compatibility mode", http.StatusBadRequest)
+		return
+	}
+
+	warnings := []string{}
+
+	size, ok := writeToCloudTarget(w, r, target, object, func(out io.Writer) error {
+		if compat {
+			var err error
+
+			if warnings, err = graph.ExportPartitionCompat(out, part, api.GM); warnings == nil {
+				warnings = []string{}
+			}
+
+			return err
+		}
+
+		return graph.ExportPartition(out, part, api.GM)
+	})
+
+	if !ok {
+		return
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	res := map[string]interface{}{
+		"target": target,
+		"object": object,
+		"size":   size,
+	}
+
+	if compat {
+		res["warnings"] = warnings
+	}
+
+	ret := json.NewEncoder(w)
+	ret.Encode(res)
+}
+
+/*
+writeToCloudTarget writes the data of a given function to an object of a
+registered cloud storage target. The data is compressed if the request selects
+a compression codec (codec and level parameter). Errors are written to the
+response. Returns the size of the written object and if the object was
+written successfully.
+*/
+func writeToCloudTarget(w http.ResponseWriter, r *http.Request, targetName string,
+	object string, writeFunc func(io.Writer) error) (int64, bool) {
+
+	codec := r.URL.Query().Get("codec")
+	level := compress.DefaultLevel
+
+	if l := r.URL.Query().Get("level"); l != "" {
+		var err error
+
+		if level, err = strconv.Atoi(l); err != nil {
+			http.Error(w, "Invalid parameter value: level should be a number", http.StatusBadRequest)
+			return 0, false
+		}
+	}
+
+	if codec != "" {
+
+		// Check codec and level before anything is written
+
+		if _, err := compress.NewWriter(ioutil.Discard, codec, level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return 0, false
+		}
+	}
+
+	target, ok := cloudstore.GetTarget(targetName)
+	if !ok {
+		http.Error(w, "Unknown cloud storage target: "+targetName, http.StatusBadRequest)
+		return 0, false
+	} else if object == "" {
+		http.Error(w, "Missing object name (object parameter)", http.StatusBadRequest)
+		return 0, false
+	}
+
+	cw, err := cloudstore.NewWriter(target, object)
+	if err != nil {
+		http.Error(w, "Could not write to cloud storage target: "+err.Error(), http.StatusBadGateway)
+		return 0, false
+	}
+
+	err = writeCompressed(cw, codec, level, writeFunc)
+
+	if err != nil {
+		cw.Abort()
+
+		if _, ok := err.(*cloudstore.Error); ok {
+			http.Error(w, "Could not write to cloud storage target: "+err.Error(), http.StatusBadGateway)
+		} else {
+			http.Error(w, err.Error(), errorStatus(err))
+		}
+
+		return 0, false
+	}
+
+	if err := cw.Close(); err != nil {
+		http.Error(w, "Could not write to cloud storage target: "+err.Error(), http.StatusBadGateway)
+		return 0, false
+	}
+
+	return cw.Size(), true
+}
+
+/*
+writeCompressed writes the data of a given function with a given compression
+codec. The data is written as is if no codec is given.
+*/
+func writeCompressed(out io.Writer, codec string, level int, writeFunc func(io.Writer) error) error {
+	if codec == "" {
+		return writeFunc(out)
+	}
+
+	cw, err := compress.NewWriter(out, codec, level)
+
+	if err == nil {
+		if err = writeFunc(cw); err == nil {
+			err = cw.Close()
+		}
+	}
+
+	return err
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ee *exportEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/export/{partition}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Export a partition to a cloud storage target.",
+			"description": "The export endpoint writes the contents of a partition as JSON directly to an object in a configured cloud storage bucket. The data is uploaded in parts while it is produced.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to export.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "target",
+					"in":          "query",
+					"description": "Name of the configured cloud storage target.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "object",
+					"in":          "query",
+					"description": "Name of the object which should be written.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "codec",
+					"in":          "query",
+					"description": "Compression codec which should be used.",
+					"required":    false,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "level",
+					"in":          "query",
+					"description": "Compression level of the codec.",
+					"required":    false,
+					"type":        "integer",
+				},
+				map[string]interface{}{
+					"name":        "compat",
+					"in":          "query",
+					"description": "Write an export which can be imported by the previous major version. Values which the previous version does not understand are mapped or dropped with a warning. Cannot be combined with a codec.",
+					"required":    false,
+					"type":        "boolean",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The target, object name and size of the written object. Compatibility exports also list all warnings.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"devt.de/eliasdb/cloudstore"
+	"devt.de/eliasdb/compress"
+)
+
+/*
+newTestCloudTarget creates a storage service which supports multipart uploads
+and registers it as cloud storage target. Completed objects are stored in the
+given map.
+*/
+func newTestCloudTarget(name string, objects map[string]string) *httptest.Server {
+	parts := make(map[string]string)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if _, ok := q["uploads"]; ok {
+			fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>1</UploadId></InitiateMultipartUploadResult>")
+		} else if r.Method == "PUT" {
+			parts[q.Get("partNumber")] = string(body)
+			w.Header().Set("ETag", q.Get("partNumber"))
+		} else if r.Method == "POST" {
+			complete := &struct {
+				Parts []struct {
+					ETag string
+				} `xml:"Part"`
+			}{}
+			xml.Unmarshal(body, complete)
+
+			objects[r.URL.Path] = ""
+			for _, p := range complete.Parts {
+				objects[r.URL.Path] += parts[p.ETag]
+			}
+		}
+	}))
+
+	cloudstore.RegisterTarget(name, &cloudstore.Target{Endpoint: server.URL,
+		Bucket: "bucket", PartSize: 100})
+
+	return server
+}
+
+func TestExportEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointExport
+
+	objects := make(map[string]string)
+	server := newTestCloudTarget("testtarget", objects)
+	defer server.Close()
+	defer cloudstore.RegisterTarget("testtarget", nil)
+
+	st, _, res := sendTestRequest(queryURL, "POST", nil)
+	if st != "400 Bad Request" || res != "Need a partition" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main", "POST", nil)
+	if st != "400 Bad Request" || res != "Missing cloud storage target (target parameter)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?target=foo", "POST", nil)
+	if st != "400 Bad Request" || res != "Unknown cloud storage target: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?target=testtarget", "POST", nil)
+	if st != "400 Bad Request" || res != "Missing object name (object parameter)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?target=testtarget&object=dump.json", "POST", nil)
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var dump map[string][]map[string]interface{}
+
+	if err := json.Unmarshal([]byte(objects["/bucket/dump.json"]), &dump); err != nil ||
+		len(dump["nodes"]) == 0 || res != fmt.Sprintf(`
+{
+  "object": "dump.json",
+  "size": %v,
+  "target": "testtarget"
+}`[1:], len(objects["/bucket/dump.json"])) {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Write a compressed export
+
+	st, _, res = sendTestRequest(queryURL+"main?target=testtarget&object=dump.gz&codec=foo", "POST", nil)
+	if st != "400 Bad Request" || res != "Unknown compression codec: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?target=testtarget&object=dump.gz&codec=gzip&level=x", "POST", nil)
+	if st != "400 Bad Request" || res != "Invalid parameter value: level should be a number" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?target=testtarget&object=dump.gz&codec=gzip&level=42", "POST", nil)
+	if st != "400 Bad Request" || res != "gzip: invalid compression level: 42" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?target=testtarget&object=dump.gz&codec=gzip&level=9", "POST", nil)
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	cr, err := compress.NewReader(bytes.NewBufferString(objects["/bucket/dump.gz"]))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var cdump map[string][]map[string]interface{}
+
+	if err := json.NewDecoder(cr).Decode(&cdump); err != nil ||
+		len(cdump["nodes"]) != len(dump["nodes"]) || len(cdump["edges"]) != len(dump["edges"]) ||
+		len(objects["/bucket/dump.gz"]) >= len(objects["/bucket/dump.json"]) {
+		t.Error("Unexpected result:", cdump, err)
+		return
+	}
+
+	// Write an export for the previous major version
+
+	st, _, res = sendTestRequest(queryURL+"main?target=testtarget&object=dump.gz&codec=gzip&compat=true", "POST", nil)
+	if st != "400 Bad Request" || res != "Compressed exports cannot be written in compatibility mode" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?target=testtarget&object=compat.json&compat=true", "POST", nil)
+	if st != "200 OK" || res != fmt.Sprintf(`
+{
+  "object": "compat.json",
+  "size": %v,
+  "target": "testtarget",
+  "warnings": []
+}`[1:], len(objects["/bucket/compat.json"])) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var compatDump map[string][]map[string]interface{}
+
+	if err := json.Unmarshal([]byte(objects["/bucket/compat.json"]), &compatDump); err != nil ||
+		len(compatDump["nodes"]) != len(dump["nodes"]) || len(compatDump["edges"]) != len(dump["edges"]) {
+		t.Error("Unexpected result:", compatDump, err)
+		return
+	}
+
+	// Write a query result to the target
+
+	queryURL = "http://localhost" + TESTPORT + EndpointQuery
+
+	st, _, res = sendTestRequest(queryURL+"main?q=get+Author&target=testtarget&object=authors.json", "GET", nil)
+	if st != "400 Bad Request" || res != "Results can only be written to a cloud storage target with a POST request" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?q=get+Author", "POST", nil)
+	if st != "400 Bad Request" || res != "Missing cloud storage target (target parameter)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main?q=get+Author&target=testtarget&object=authors.json", "POST", nil)
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var result map[string]interface{}
+
+	if err := json.Unmarshal([]byte(objects["/bucket/authors.json"]), &result); err != nil ||
+		len(result["rows"].([]interface{})) != 3 || result["header"] == nil || res != fmt.Sprintf(`
+{
+  "object": "authors.json",
+  "rows": 3,
+  "size": %v,
+  "target": "testtarget"
+}`[1:], len(objects["/bucket/authors.json"])) {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Errors of the storage service are reported
+
+	cloudstore.RegisterTarget("failtarget", &cloudstore.Target{Endpoint: "http://localhost:1", Bucket: "bucket"})
+	defer cloudstore.RegisterTarget("failtarget", nil)
+
+	st, _, res = sendTestRequest(queryURL+"main?q=get+Author&target=failtarget&object=authors.json", "POST", nil)
+	if st != "502 Bad Gateway" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/federation"
+)
+
+/*
+EndpointFederation is the federated query endpoint URL (rooted). Handles everything under federation/...
+*/
+const EndpointFederation = api.APIRoot + APIv1 + "/federation/"
+
+/*
+FederationEndpointInst creates a new endpoint handler.
+*/
+func FederationEndpointInst() api.RestEndpointHandler {
+	return &federationEndpoint{}
+}
+
+/*
+Handler object for federated queries.
+*/
+type federationEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a REST call to list all remote instances or to run a
+federated query.
+*/
+func (fe *federationEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	// List all remote instances if no partition was given
+
+	if len(resources) == 0 {
+		data := federation.Remotes()
+
+		if data == nil {
+			data = []string{}
+		}
+
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	// Check parameters
+
+	if !checkResources(w, resources, 1, 1, "Need a partition") {
+		return
+	}
+
+	if !checkMemoryLimit(w) {
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "Missing query (q parameter)", http.StatusBadRequest)
+		return
+	}
+
+	var remotes []string
+
+	if rs := r.URL.Query().Get("remotes"); rs != "" {
+		remotes = strings.Split(rs, ",")
+	}
+
+	gm := api.GM
+
+	if r.URL.Query().Get("local") == "false" {
+		gm = nil
+	}
+
+	res, err := federation.RunQuery(resources[0], query, gm, remotes...)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := resultData(res, 0, res.Rows(), res.RowSources())
+
+	instances := res.RowInstances()
+
+	if instances == nil {
+		instances = []string{}
+	}
+
+	data["instances"] = instances
+
+	json.NewEncoder(w).Encode(data)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (fe *federationEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/federation"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all remote instances.",
+			"description": "The federation endpoint returns the names of all remote EliasDB instances which can be queried.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of remote instance names.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/federation/{partition}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Run a federated EQL query.",
+			"description": "The query is run on this instance and on remote instances. The rows of all instances are joined into a single result.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to query.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "q",
+					"in":          "query",
+					"description": "URL encoded query to execute.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "remotes",
+					"in":          "query",
+					"description": "Comma separated list of remote instances to query (default is all remote instances).",
+					"required":    false,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "local",
+					"in":          "query",
+					"description": "Flag if this instance should be queried (default is true).",
+					"required":    false,
+					"type":        "boolean",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The joined result of all queried instances.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/federation"
+	"devt.de/eliasdb/graph/data"
+)
+
+func TestFederationEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointFederation
+
+	for i := 0; i < 2; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint("fed", i))
+		node.SetAttr("kind", "Fed")
+		api.GM.StoreNode("fedtest", node)
+	}
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Use this instance also as remote instance
+
+	federation.RegisterRemote("self", &federation.Remote{Endpoint: "http://localhost" + TESTPORT})
+	defer federation.RegisterRemote("self", nil)
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != `[
+  "self"
+]` {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"fedtest", "GET", nil)
+	if st != "400 Bad Request" || res != "Missing query (q parameter)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	q := url.QueryEscape("get Fed show key")
+
+	st, _, res = sendTestRequest(queryURL+"fedtest?q="+q, "GET", nil)
+	if st != "200 OK" || res != `
+{
+  "header": {
+    "data": [
+      "1:n:key"
+    ],
+    "format": [
+      "auto"
+    ],
+    "labels": [
+      "Fed Key"
+    ],
+    "primary_kind": "Fed"
+  },
+  "instances": [
+    "local",
+    "local",
+    "self",
+    "self"
+  ],
+  "rows": [
+    [
+      "fed0"
+    ],
+    [
+      "fed1"
+    ],
+    [
+      "fed0"
+    ],
+    [
+      "fed1"
+    ]
+  ],
+  "sources": [
+    [
+      "n:Fed:fed0"
+    ],
+    [
+      "n:Fed:fed1"
+    ],
+    [
+      "n:Fed:fed0"
+    ],
+    [
+      "n:Fed:fed1"
+    ]
+  ]
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"fedtest?local=false&remotes=self&q="+q, "GET", nil)
+	if st != "200 OK" || res != `
+{
+  "header": {
+    "data": [
+      "1:n:key"
+    ],
+    "format": [
+      "auto"
+    ],
+    "labels": [
+      "Fed Key"
+    ],
+    "primary_kind": "Fed"
+  },
+  "instances": [
+    "self",
+    "self"
+  ],
+  "rows": [
+    [
+      "fed0"
+    ],
+    [
+      "fed1"
+    ]
+  ],
+  "sources": [
+    [
+      "n:Fed:fed0"
+    ],
+    [
+      "n:Fed:fed1"
+    ]
+  ]
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"fedtest?remotes=other&q="+q, "GET", nil)
+	if st != "404 Not Found" || res != "Unknown remote instance (other)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -57,6 +57,13 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		return
 	}
 
+	// Get the format of numeric attribute values
+
+	numbers, ok := requestNumberOptions(w, r)
+	if !ok {
+		return
+	}
+
 	if len(resources) == 3 {
 
 		// Iterate over a list of nodes
@@ -77,9 +84,19 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 				return
 			}
 
-			it, err := api.GM.NodeKeyIterator(resources[0], resources[2])
+			// Get cursor parameter; a cursor continues a previous list
+
+			var it *graph.NodeKeyIterator
+			var err error
+
+			if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+				it, err = api.GM.NodeKeyIteratorAt(resources[0], resources[2], cursor)
+			} else {
+				it, err = api.GM.NodeKeyIterator(resources[0], resources[2])
+			}
+
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				http.Error(w, err.Error(), errorStatus(err))
 				return
 			} else if it == nil {
 				http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
@@ -97,7 +114,7 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 					}
 
 					if it.Next(); it.LastError != nil {
-						http.Error(w, it.LastError.Error(), http.StatusInternalServerError)
+						http.Error(w, it.LastError.Error(), errorStatus(it.LastError))
 						return
 					}
 				}
@@ -126,30 +143,34 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 				key := it.Next()
 
 				if it.LastError != nil {
-					http.Error(w, it.LastError.Error(), http.StatusInternalServerError)
+					http.Error(w, it.LastError.Error(), errorStatus(it.LastError))
 					return
 				}
 
 				node, err := api.GM.FetchNode(resources[0], key, resources[2])
 
 				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
+					http.Error(w, err.Error(), errorStatus(err))
 					return
 				}
 
 				data = append(data, node.Data())
 			}
 
-			// Set total count header
+			// Set total count header and a cursor for the next page
 
 			w.Header().Add(HTTPHeaderTotalCount, strconv.FormatUint(api.GM.NodeCount(resources[2]), 10))
 
+			if it.HasNext() {
+				w.Header().Add(HTTPHeaderCursor, it.Bookmark())
+			}
+
 			// Write data
 
 			w.Header().Set("content-type", "application/json; charset=utf-8")
 
 			ret := json.NewEncoder(w)
-			ret.Encode(data)
+			ret.Encode(numbers.apply(data))
 
 		} else {
 			http.Error(w, "Entity type must be n (nodes) when requesting all items", http.StatusBadRequest)
@@ -167,7 +188,7 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 			node, err := api.GM.FetchNode(resources[0], resources[3], resources[2])
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				http.Error(w, err.Error(), errorStatus(err))
 				return
 			} else if node == nil {
 				http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
@@ -181,7 +202,7 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 			edge, err := api.GM.FetchEdge(resources[0], resources[3], resources[2])
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				http.Error(w, err.Error(), errorStatus(err))
 				return
 			} else if edge == nil {
 				http.Error(w, "Unknown partition or edge kind", http.StatusBadRequest)
@@ -196,7 +217,7 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		w.Header().Set("content-type", "application/json; charset=utf-8")
 
 		ret := json.NewEncoder(w)
-		ret.Encode(data)
+		ret.Encode(numbers.apply(data))
 
 	} else {
 
@@ -205,7 +226,7 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 			node, err := api.GM.FetchNodePart(resources[0], resources[3], resources[2], []string{"key", "kind"})
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				http.Error(w, err.Error(), errorStatus(err))
 				return
 			} else if node == nil {
 				http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
@@ -216,7 +237,7 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 				resources[2], resources[4], true)
 
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				http.Error(w, err.Error(), errorStatus(err))
 				return
 			}
 
@@ -246,7 +267,7 @@ func (ge *graphEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 			w.Header().Set("content-type", "application/json; charset=utf-8")
 
 			ret := json.NewEncoder(w)
-			ret.Encode(data)
+			ret.Encode(numbers.apply(data))
 
 		} else {
 			http.Error(w, "Entity type must be n (nodes) when requesting traversal results", http.StatusBadRequest)
@@ -347,6 +368,13 @@ func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	// Commit every element in its own transaction if requested
+
+	if r.URL.Query().Get("partial") == "true" {
+		ge.handlePartialGraphRequest(w, resources[0], nDataList, eDataList, transFuncNode, transFuncEdge)
+		return
+	}
+
 	// Create a transaction
 
 	trans := graph.NewGraphTrans(api.GM)
@@ -382,11 +410,84 @@ func (ge *graphEndpoint) handleGraphRequest(w http.ResponseWriter, r *http.Reque
 	// Commit transaction
 
 	if err := trans.Commit(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 }
 
+/*
+handlePartialGraphRequest handles a graph query REST call in partial-success
+mode. Every node and edge is committed in its own transaction. Elements which
+fail do not prevent other elements from being committed. The response details
+the outcome of every element.
+*/
+func (ge *graphEndpoint) handlePartialGraphRequest(w http.ResponseWriter, part string,
+	nDataList []map[string]interface{}, eDataList []map[string]interface{},
+	transFuncNode func(trans *graph.Trans, part string, node data.Node) error,
+	transFuncEdge func(trans *graph.Trans, part string, edge data.Edge) error) {
+
+	succeeded := 0
+	failed := 0
+
+	result := func(key string, kind string, err error) map[string]interface{} {
+		res := map[string]interface{}{
+			"key":     key,
+			"kind":    kind,
+			"success": err == nil,
+		}
+
+		if err != nil {
+			res["error"] = err.Error()
+			failed++
+		} else {
+			succeeded++
+		}
+
+		return res
+	}
+
+	nResults := make([]map[string]interface{}, 0, len(nDataList))
+	eResults := make([]map[string]interface{}, 0, len(eDataList))
+
+	// Nodes are committed first so edges can refer to them
+
+	for _, ndata := range nDataList {
+		node := data.NewGraphNodeFromMap(ndata)
+		trans := graph.NewGraphTrans(api.GM)
+
+		err := transFuncNode(trans, part, node)
+		if err == nil {
+			err = trans.Commit()
+		}
+
+		nResults = append(nResults, result(node.Key(), node.Kind(), err))
+	}
+
+	for _, edata := range eDataList {
+		edge := data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(edata))
+		trans := graph.NewGraphTrans(api.GM)
+
+		err := transFuncEdge(trans, part, edge)
+		if err == nil {
+			err = trans.Commit()
+		}
+
+		eResults = append(eResults, result(edge.Key(), edge.Kind(), err))
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(map[string]interface{}{
+		"nodes":     nResults,
+		"edges":     eResults,
+		"succeeded": succeeded,
+		"failed":    failed,
+	})
+}
+
 /*
 SwaggerDefs is used to describe the endpoint in swagger.
 */
@@ -443,6 +544,18 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 			"format":      "integer",
 		},
 	}
+	optionalQueryParams = append(optionalQueryParams, numberSwaggerParams...)
+
+	cursorParam := []map[string]interface{}{
+		map[string]interface{}{
+			"name": "cursor",
+			"in":   "query",
+			"description": "Cursor token of a previous request (X-Cursor header) " +
+				"to continue the list after its last item.",
+			"required": false,
+			"type":     "string",
+		},
+	}
 
 	keyParam := []map[string]interface{}{
 		map[string]interface{}{
@@ -463,6 +576,7 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 			"type":        "string",
 		},
 	}
+	travParam = append(travParam, numberSwaggerParams...)
 
 	graphPost := []map[string]interface{}{
 		map[string]interface{}{
@@ -510,6 +624,18 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 		},
 	}
 
+	partialParam := []map[string]interface{}{
+		map[string]interface{}{
+			"name": "partial",
+			"in":   "query",
+			"description": "Flag if every node and edge should be committed in its own transaction. " +
+				"Failing elements do not prevent other elements from being stored and the " +
+				"response contains the outcome of every element.",
+			"required": false,
+			"type":     "boolean",
+		},
+	}
+
 	defaultError := map[string]interface{}{
 		"description": "Error response",
 		"schema": map[string]interface{}{
@@ -531,10 +657,10 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(partitionParams, graphPost...),
+			"parameters": append(append(partitionParams, graphPost...), partialParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
-					"description": "No data is returned when data is created.",
+					"description": "No data is returned when data is created (except in partial mode).",
 				},
 				"default": defaultError,
 			},
@@ -555,10 +681,10 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(append(partitionParams, entityParams...), entitiesPost...),
+			"parameters": append(append(append(partitionParams, entityParams...), entitiesPost...), partialParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
-					"description": "No data is returned when data is created.",
+					"description": "No data is returned when data is created (except in partial mode).",
 				},
 				"default": defaultError,
 			},
@@ -571,12 +697,13 @@ func (ge *graphEndpoint) SwaggerDefs(s map[string]interface{}) {
 		"get": map[string]interface{}{
 			"summary": "The graph endpoint is the main entry point to request data.",
 			"description": "GET requests can be used to query a series of nodes. " +
-				"The X-Total-Count header contains the total number of nodes which were found.",
+				"The X-Total-Count header contains the total number of nodes which were found. " +
+				"The X-Cursor header contains a cursor token for the next page if there are more nodes.",
 			"produces": []string{
 				"text/plain",
 				"application/json",
 			},
-			"parameters": append(defaultParams, optionalQueryParams...),
+			"parameters": append(append(defaultParams, optionalQueryParams...), cursorParam...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "The return data is a list of objects",
@@ -13,6 +13,7 @@ package v1
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"devt.de/common/datautil"
@@ -273,6 +274,41 @@ func TestGraphQuery(t *testing.T) {
 		return
 	}
 
+	// Test paging with cursors
+
+	var pages []string
+
+	st, h, res = sendTestRequest(queryURL+"/main/n/Song?limit=4", "GET", nil)
+
+	for st == "200 OK" {
+		var page []map[string]interface{}
+
+		json.Unmarshal([]byte(res), &page)
+
+		for _, n := range page {
+			pages = append(pages, fmt.Sprint(n["key"]))
+		}
+
+		cursor := h.Get(HTTPHeaderCursor)
+		if cursor == "" {
+			break
+		}
+
+		st, h, res = sendTestRequest(queryURL+"/main/n/Song?limit=4&cursor="+cursor, "GET", nil)
+	}
+
+	if st != "200 OK" || fmt.Sprint(pages) != "[StrangeSong1 FightSong4 DeadSong2 LoveSong3 MyOnlySong3 "+
+		"Aria1 Aria2 Aria3 Aria4]" {
+		t.Error("Unexpected response:", st, pages)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"/main/n/Song?cursor=123", "GET", nil)
+	if st != "400 Bad Request" || !strings.HasPrefix(res, "GraphError: Invalid data (Invalid bookmark: ") {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
 	// Test error cases
 
 	msm := gmMSM.StorageManager("main"+"Song"+graph.StorageSuffixNodes,
@@ -697,7 +733,7 @@ func TestGraphOperation(t *testing.T) {
 
 	st, _, res = sendTestRequest(queryURL+"main/e", "POST", []byte(jsonString))
 
-	if st != "500 Internal Server Error" ||
+	if st != "400 Bad Request" ||
 		res != "GraphError: Invalid data (Can't find edge endpoint: foo (graphtest))" {
 		t.Error("Unexpected response:", st, res)
 		return
@@ -857,3 +893,103 @@ func TestGraphOperation(t *testing.T) {
 		return
 	}
 }
+
+func TestGraphOperationPartial(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointGraph
+
+	// The second node and the second edge are invalid
+
+	st, _, res := sendTestRequest(queryURL+"partialtest?partial=true", "POST", []byte(`{
+  "nodes" : [
+    { "key" : "a", "kind" : "PartialNode" },
+    { "key" : "b" },
+    { "key" : "c", "kind" : "PartialNode" }
+  ],
+  "edges" : [
+    { "key" : "e1", "kind" : "PartialEdge",
+      "end1key" : "a", "end1kind" : "PartialNode", "end1role" : "r1", "end1cascading" : false,
+      "end2key" : "c", "end2kind" : "PartialNode", "end2role" : "r2", "end2cascading" : false },
+    { "key" : "e2", "kind" : "PartialEdge",
+      "end1key" : "a", "end1kind" : "PartialNode", "end1role" : "r1", "end1cascading" : false,
+      "end2key" : "b", "end2kind" : "PartialNode", "end2role" : "r2", "end2cascading" : false }
+  ]
+}`))
+
+	if st != "200 OK" || res != `
+{
+  "edges": [
+    {
+      "key": "e1",
+      "kind": "PartialEdge",
+      "success": true
+    },
+    {
+      "error": "GraphError: Invalid data (Can't find edge endpoint: b (PartialNode))",
+      "key": "e2",
+      "kind": "PartialEdge",
+      "success": false
+    }
+  ],
+  "failed": 2,
+  "nodes": [
+    {
+      "key": "a",
+      "kind": "PartialNode",
+      "success": true
+    },
+    {
+      "error": "GraphError: Invalid data (Node is missing a kind value)",
+      "key": "b",
+      "kind": "",
+      "success": false
+    },
+    {
+      "key": "c",
+      "kind": "PartialNode",
+      "success": true
+    }
+  ],
+  "succeeded": 3
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if c := api.GM.NodeCount("PartialNode"); c != 2 {
+		t.Error("Unexpected node count:", c)
+		return
+	}
+
+	if c := api.GM.EdgeCount("PartialEdge"); c != 1 {
+		t.Error("Unexpected edge count:", c)
+		return
+	}
+
+	// Lists of nodes or edges are supported as well
+
+	st, _, res = sendTestRequest(queryURL+"partialtest/n?partial=true", "DELETE", []byte(`[
+  { "key" : "c", "kind" : "PartialNode" }
+]`))
+
+	if st != "200 OK" || res != `
+{
+  "edges": [],
+  "failed": 0,
+  "nodes": [
+    {
+      "key": "c",
+      "kind": "PartialNode",
+      "success": true
+    }
+  ],
+  "succeeded": 1
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if c := api.GM.EdgeCount("PartialEdge"); c != 0 {
+		t.Error("Unexpected edge count:", c)
+		return
+	}
+}
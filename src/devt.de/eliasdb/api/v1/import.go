@@ -0,0 +1,158 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/compress"
+	"devt.de/eliasdb/graph"
+)
+
+/*
+EndpointImport is the import endpoint URL (rooted). Handles everything under import/...
+*/
+const EndpointImport = api.APIRoot + APIv1 + "/import/"
+
+/*
+ImportEndpointInst creates a new endpoint handler.
+*/
+func ImportEndpointInst() api.RestEndpointHandler {
+	return &importEndpoint{}
+}
+
+/*
+Handler object for template based imports.
+*/
+type importEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+importRequest is the expected body of an import request.
+*/
+type importRequest struct {
+	Template *graph.ImportTemplate `json:"template"` // Mapping template
+	Data     interface{}           `json:"data"`     // JSON document to import
+}
+
+/*
+HandlePOST handles a REST call to import a JSON document using a mapping template.
+*/
+func (ie *importEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 1, 1, "Need a partition") {
+		return
+	}
+
+	req := &importRequest{}
+
+	// The request body may be compressed with any registered codec
+
+	body, err := compress.NewReader(r.Body)
+	if err != nil {
+		http.Error(w, "Could not decode request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := json.NewDecoder(body).Decode(req); err != nil {
+		http.Error(w, "Could not decode request body: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if req.Template == nil {
+		http.Error(w, "Need a mapping template", http.StatusBadRequest)
+		return
+	}
+
+	// Map the document in a single transaction
+
+	trans := graph.NewGraphTrans(api.GM)
+
+	nodes, edges, err := graph.ImportJSON(trans, resources[0], req.Data, req.Template)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := trans.Commit(); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(map[string]interface{}{
+		"nodes": nodes,
+		"edges": edges,
+	})
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ie *importEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/import/{partition}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Import a JSON document using a mapping template.",
+			"description": "The import endpoint maps an arbitrary JSON document to nodes and edges using JSONPath expressions in a mapping template. All mapped nodes and edges are stored in a single transaction.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to store the data in.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "request",
+					"in":          "body",
+					"description": "Object with a mapping template and the JSON document to import.",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The number of imported nodes and edges.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
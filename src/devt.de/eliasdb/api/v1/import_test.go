@@ -0,0 +1,122 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"bytes"
+	"testing"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/compress"
+)
+
+func TestImportEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointImport
+
+	st, _, res := sendTestRequest(queryURL, "POST", nil)
+	if st != "400 Bad Request" || res != "Need a partition" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"importtest", "POST", []byte("{"))
+	if st != "400 Bad Request" || res != "Could not decode request body: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"importtest", "POST", []byte(`{ "data" : {} }`))
+	if st != "400 Bad Request" || res != "Need a mapping template" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"importtest", "POST", []byte(`{
+  "template" : { "nodes" : [ { "path" : "$.users[*]", "kind" : "User", "key" : "@.foo" } ] },
+  "data" : { "users" : [ { "id" : "u1" } ] }
+}`))
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Could not find key of node of kind User at @.foo)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"importtest", "POST", []byte(`{
+  "template" : { "nodes" : [ { "path" : "$.users[*]", "kind" : "User", "key" : "@.id",
+    "edges" : [ { "kind" : "Follows", "target_kind" : "User", "target_key" : "@.id" } ] } ] },
+  "data" : { "users" : [ { "id" : "u1" } ], "groups" : [ { "id" : "g1" } ] }
+}`))
+	if st != "400 Bad Request" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"importtest", "POST", []byte(`{
+  "template" : { "nodes" : [
+    { "path" : "$.users[*]", "kind" : "User", "key" : "@.id", "attrs" : { "name" : "@.name" },
+      "edges" : [ { "path" : "@.follows[*]", "kind" : "Follows", "role" : "follower",
+        "target_kind" : "User", "target_key" : "@", "target_role" : "followed" } ] }
+  ] },
+  "data" : { "users" : [
+    { "id" : "u1", "name" : "Fred", "follows" : [ "u2" ] },
+    { "id" : "u2", "name" : "Bob" }
+  ] }
+}`))
+	if st != "200 OK" || res != `
+{
+  "edges": 1,
+  "nodes": 2
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, err := api.GM.FetchNode("importtest", "u1", "User"); err != nil || n.Attr("name") != "Fred" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if e, err := api.GM.FetchEdge("importtest", "u1-u2", "Follows"); err != nil || e == nil {
+		t.Error("Unexpected result:", e, err)
+		return
+	}
+
+	// Import a compressed request body
+
+	var buf bytes.Buffer
+
+	cw, _ := compress.NewWriter(&buf, "zlib", compress.DefaultLevel)
+	cw.Write([]byte(`{
+  "template" : { "nodes" : [ { "path" : "$.users[*]", "kind" : "User", "key" : "@.id", "attrs" : { "name" : "@.name" } } ] },
+  "data" : { "users" : [ { "id" : "u3", "name" : "Hans" } ] }
+}`))
+	cw.Close()
+
+	st, _, res = sendTestRequest(queryURL+"importtest", "POST", buf.Bytes())
+	if st != "200 OK" || res != `
+{
+  "edges": 0,
+  "nodes": 1
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, err := api.GM.FetchNode("importtest", "u3", "User"); err != nil || n.Attr("name") != "Hans" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"importtest", "POST", []byte{0x66, 0x43, 0x99, 0x00})
+	if st != "400 Bad Request" || res != "Could not decode request body: Unknown compression codec id: 153" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -13,6 +13,7 @@ package v1
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"devt.de/eliasdb/api"
 	"devt.de/eliasdb/graph"
@@ -50,6 +51,10 @@ func (ie *indexEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		return
 	}
 
+	if !checkMemoryLimit(w) {
+		return
+	}
+
 	if resources[1] != "n" && resources[1] != "e" {
 		http.Error(w, "Entity type must be n (nodes) or e (edges)", http.StatusBadRequest)
 		return
@@ -66,6 +71,7 @@ func (ie *indexEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 	phrase := r.URL.Query().Get("phrase")
 	word := r.URL.Query().Get("word")
 	value := r.URL.Query().Get("value")
+	fuzzy := r.URL.Query().Get("fuzzy")
 
 	// Get the index query object
 
@@ -78,7 +84,7 @@ func (ie *indexEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 	}
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	} else if iq == nil {
 		http.Error(w, "Unknown partition or node kind", http.StatusBadRequest)
@@ -105,15 +111,29 @@ func (ie *indexEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		if len(data.([]string)) == 0 {
 			data = []string{}
 		}
+	case fuzzy != "":
+		distance := 1
+
+		if d := r.URL.Query().Get("distance"); d != "" {
+			if distance, err = strconv.Atoi(d); err != nil {
+				http.Error(w, "Invalid distance: "+d, http.StatusBadRequest)
+				return
+			}
+		}
+
+		data, err = iq.LookupFuzzy(attr, fuzzy, distance)
+		if len(data.(map[string][]uint64)) == 0 {
+			data = map[string][]uint64{}
+		}
 	default:
-		http.Error(w, "Query string for either phrase, word or value is required", http.StatusBadRequest)
+		http.Error(w, "Query string for either phrase, word, value or fuzzy is required", http.StatusBadRequest)
 		return
 	}
 
 	// Check if there was an error
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -133,7 +153,7 @@ func (ie *indexEndpoint) SwaggerDefs(s map[string]interface{}) {
 	s["paths"].(map[string]interface{})["/v1/index/{partition}/{entity_type}/{kind}"] = map[string]interface{}{
 		"get": map[string]interface{}{
 			"summary":     "Run index searches on the EliasDB datastore.",
-			"description": "The query endpoint should be used to run index searches for either a word, phrase or a whole value. All queries must specify a kind and an node/edge attribute.",
+			"description": "The query endpoint should be used to run index searches for either a word, phrase, a whole value or similar words. All queries must specify a kind and an node/edge attribute.",
 			"produces": []string{
 				"text/plain",
 				"application/json",
@@ -189,6 +209,20 @@ func (ie *indexEndpoint) SwaggerDefs(s map[string]interface{}) {
 					"required":    false,
 					"type":        "string",
 				},
+				map[string]interface{}{
+					"name":        "fuzzy",
+					"in":          "query",
+					"description": "Word to search for in fuzzy queries which also find misspelled words.",
+					"required":    false,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "distance",
+					"in":          "query",
+					"description": "Maximum edit distance (0-2) of words found by fuzzy queries (default 1).",
+					"required":    false,
+					"type":        "integer",
+				},
 			},
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
@@ -46,7 +46,7 @@ func TestIndexQuery(t *testing.T) {
 	}
 
 	st, _, res = sendTestRequest(queryURL+"//main/n/Song?attr=1", "GET", nil)
-	if st != "400 Bad Request" || res != "Query string for either phrase, word or value is required" {
+	if st != "400 Bad Request" || res != "Query string for either phrase, word, value or fuzzy is required" {
 		t.Error("Unexpected response:", st, res)
 		return
 	}
@@ -112,6 +112,18 @@ func TestIndexQuery(t *testing.T) {
 		return
 	}
 
+	st, _, res = sendTestRequest(queryURL+"//main/n/Song?attr=name&fuzzy=Aria", "GET", nil)
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Index does not support fuzzy lookups)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"//main/n/Song?attr=name&fuzzy=Aria&distance=x", "GET", nil)
+	if st != "400 Bad Request" || res != "Invalid distance: x" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
 	msm := gmMSM.StorageManager("main"+"Song"+graph.StorageSuffixNodesIndex,
 		true).(*storage.MemoryStorageManager)
 
@@ -0,0 +1,268 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/graph"
+)
+
+/*
+EndpointInvariants is the invariants endpoint URL (rooted). Handles everything under invariants/...
+*/
+const EndpointInvariants = api.APIRoot + APIv1 + "/invariants/"
+
+/*
+InvariantsEndpointInst creates a new endpoint handler.
+*/
+func InvariantsEndpointInst() api.RestEndpointHandler {
+	return &invariantsEndpoint{}
+}
+
+/*
+Handler object for graph invariants.
+*/
+type invariantsEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a REST call to list all declared invariants and their metrics.
+*/
+func (ie *invariantsEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	data := make([]map[string]interface{}, 0)
+
+	for _, inv := range api.GM.Invariants() {
+		item := map[string]interface{}{
+			"name":       inv.Name,
+			"kind":       inv.Kind,
+			"spec":       inv.Spec,
+			"min":        inv.Min,
+			"max":        inv.Max,
+			"checks":     0,
+			"violations": 0,
+		}
+
+		if stats := api.GM.InvariantStats(inv.Name); stats != nil {
+			item["checks"] = stats.Checks
+			item["violations"] = stats.Violations
+		}
+
+		data = append(data, item)
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
+
+/*
+HandlePUT handles a REST call to declare an invariant.
+*/
+func (ie *invariantsEndpoint) HandlePUT(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	inv := &graph.Invariant{Max: -1}
+
+	if err := json.NewDecoder(r.Body).Decode(inv); err != nil {
+		http.Error(w, "Could not decode request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.GM.SetInvariant(inv); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+}
+
+/*
+HandlePOST handles a REST call to check all nodes of a partition against all
+declared invariants.
+*/
+func (ie *invariantsEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 1, 1, "Need a partition") {
+		return
+	}
+
+	violations, err := api.GM.CheckInvariants(resources[0])
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := make([]map[string]interface{}, 0, len(violations))
+
+	for _, v := range violations {
+		data = append(data, v.Data())
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
+
+/*
+HandleDELETE handles a REST call to remove an invariant.
+*/
+func (ie *invariantsEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 1, 1, "Need an invariant name") {
+		return
+	}
+
+	inv, err := api.GM.RemoveInvariant(resources[0])
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	} else if inv == nil {
+		http.Error(w, "Unknown invariant", http.StatusNotFound)
+		return
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (ie *invariantsEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/invariants"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all declared invariants.",
+			"description": "The invariants endpoint returns all declared invariants together with the number of checks and found violations.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of invariants.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+		"put": map[string]interface{}{
+			"summary":     "Declare an invariant.",
+			"description": "The invariants endpoint can be used to declare an invariant which is checked on every mutation. An existing invariant with the same name is replaced.",
+			"consumes": []string{
+				"application/json",
+			},
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "invariant",
+					"in":          "body",
+					"description": "Invariant with name, node kind, traversal spec and the allowed minimum and maximum number of matching edges.",
+					"required":    true,
+					"schema": map[string]interface{}{
+						"type": "object",
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The invariant was declared.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/invariants/{id}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Check all nodes of a partition.",
+			"description": "The invariants endpoint can be used to check all nodes of a partition against all declared invariants. Violations are recorded as nodes of kind InvariantViolation.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "id",
+					"in":          "path",
+					"description": "Partition to check.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of violation nodes.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Remove an invariant.",
+			"description": "The invariants endpoint can be used to remove a declared invariant. Recorded violations are kept.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "id",
+					"in":          "path",
+					"description": "Name of the invariant.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The invariant was removed.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/graph"
+	"devt.de/eliasdb/graph/data"
+)
+
+func TestInvariantsEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointInvariants
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "t1")
+	node.SetAttr(data.NodeKind, "InvTest")
+
+	api.GM.StoreNode("invtest", node)
+
+	st, _, res := sendTestRequest(queryURL, "PUT", []byte("{"))
+	if st != "400 Bad Request" || res != "Could not decode request body: unexpected EOF" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", []byte(`{ "name" : "a b" }`))
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Invalid invariant a b: Name must be alphanumeric)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "PUT", []byte(`{ "name" : "hasOwner", "kind" : "InvTest",
+  "spec" : ":Owner::", "min" : 1 }`))
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"invtest", "POST", nil)
+
+	var violations []map[string]interface{}
+	json.Unmarshal([]byte(res), &violations)
+
+	if st != "200 OK" || len(violations) != 1 || violations[0]["key"] != "hasOwner:InvTest:t1" ||
+		violations[0]["kind"] != graph.InvariantViolationKind {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != `
+[
+  {
+    "checks": 1,
+    "kind": "InvTest",
+    "max": -1,
+    "min": 1,
+    "name": "hasOwner",
+    "spec": ":Owner::",
+    "violations": 1
+  }
+]`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"a b", "POST", nil)
+	if st != "400 Bad Request" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", nil)
+	if st != "400 Bad Request" || res != "Need a partition" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"hasOwner", "DELETE", nil)
+	if st != "200 OK" || res != "" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"hasOwner", "DELETE", nil)
+	if st != "404 Not Found" || res != "Unknown invariant" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "DELETE", nil)
+	if st != "400 Bad Request" || res != "Need an invariant name" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/graph"
+)
+
+/*
+EndpointJournal is the journal endpoint URL (rooted). Handles everything under journal/...
+*/
+const EndpointJournal = api.APIRoot + APIv1 + "/journal/"
+
+/*
+JournalEndpointInst creates a new endpoint handler.
+*/
+func JournalEndpointInst() api.RestEndpointHandler {
+	return &journalEndpoint{}
+}
+
+/*
+Handler object for the journal of committed changes.
+*/
+type journalEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a REST call to list the records of the journal.
+*/
+func (je *journalEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var since int64
+
+	if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+		var err error
+
+		if since, err = strconv.ParseInt(sinceParam, 10, 64); err != nil {
+			http.Error(w, fmt.Sprint("Invalid parameter value for since: ", sinceParam),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := api.GM.JournalRecords(since)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := make([]map[string]interface{}, 0, len(records))
+
+	for _, r := range records {
+		data = append(data, map[string]interface{}{
+			"time":    r.Time,
+			"changes": journalChanges(r.Log),
+		})
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
+
+/*
+HandlePOST handles a REST call to recover the graph database to a point in time.
+*/
+func (je *journalEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 1, 1, "Need a point in time") {
+		return
+	}
+
+	ts, err := strconv.ParseInt(resources[0], 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprint("Invalid point in time: ", resources[0]), http.StatusBadRequest)
+		return
+	}
+
+	log, err := api.GM.RecoverToTime(ts)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(journalChanges(log))
+}
+
+/*
+journalChanges returns a readable description of each change in a log.
+*/
+func journalChanges(log graph.TransLog) []string {
+	changes := make([]string, 0, len(log))
+
+	for _, e := range log {
+		changes = append(changes, e.String())
+	}
+
+	return changes
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (je *journalEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/journal"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the records of the journal.",
+			"description": "The journal endpoint returns all committed changes together with their commit time (Unix time in nanoseconds).",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "since",
+					"in":          "query",
+					"description": "Only return records which were committed after this time.",
+					"required":    false,
+					"type":        "integer",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of journal records.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/journal/{time}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary":     "Recover the datastore to a point in time.",
+			"description": "All changes of the journal which were committed after the given time are reverted. The recovery itself is recorded in the journal.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "time",
+					"in":          "path",
+					"description": "Point in time (Unix time in nanoseconds).",
+					"required":    true,
+					"type":        "integer",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The changes which were made by the recovery.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/graph"
+	"devt.de/eliasdb/graph/data"
+)
+
+func TestJournalEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointJournal
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+	if st != "400 Bad Request" || res != "GraphError: Invalid data (Graph manager has no journal)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	dir, _ := ioutil.TempDir("", "journaltest")
+	defer os.RemoveAll(dir)
+
+	journal, err := graph.OpenJournal(filepath.Join(dir, "journal"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer journal.Close()
+
+	api.GM.SetJournal(journal)
+	defer api.GM.SetJournal(nil)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "j1")
+	node.SetAttr("kind", "JournalTest")
+	api.GM.StoreNode("main", node)
+
+	records, _ := journal.Records(0)
+	checkpoint := records[0].Time
+
+	api.GM.RemoveNode("main", "j1", "JournalTest")
+
+	records, _ = journal.Records(checkpoint)
+
+	st, _, res = sendTestRequest(queryURL+fmt.Sprint("?since=", checkpoint), "GET", nil)
+	if st != "200 OK" || res != fmt.Sprintf(`
+[
+  {
+    "changes": [
+      "Deleted node j1 (JournalTest) in main"
+    ],
+    "time": %v
+  }
+]`[1:], records[0].Time) {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"?since=foo", "GET", nil)
+	if st != "400 Bad Request" || res != "Invalid parameter value for since: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "POST", nil)
+	if st != "400 Bad Request" || res != "Need a point in time" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"foo", "POST", nil)
+	if st != "400 Bad Request" || res != "Invalid point in time: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+fmt.Sprint(checkpoint), "POST", nil)
+	if st != "200 OK" || res != `
+[
+  "Created node j1 (JournalTest) in main"
+]`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if n, _ := api.GM.FetchNode("main", "j1", "JournalTest"); n == nil {
+		t.Error("Node should have been recovered")
+		return
+	}
+
+	api.GM.RemoveNode("main", "j1", "JournalTest")
+}
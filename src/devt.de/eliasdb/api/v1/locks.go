@@ -0,0 +1,248 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+EndpointLocks is the lock contention profile endpoint URL (rooted). Handles everything under locks/...
+*/
+const EndpointLocks = api.APIRoot + APIv1 + "/locks/"
+
+/*
+LocksEndpointInst creates a new endpoint handler.
+*/
+func LocksEndpointInst() api.RestEndpointHandler {
+	return &locksEndpoint{}
+}
+
+/*
+Handler object for lock contention profiles.
+*/
+type locksEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a REST call to retrieve the lock contention profile.
+*/
+func (le *locksEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 0, 1, "") {
+		return
+	}
+
+	ops := make([]map[string]interface{}, 0)
+
+	for _, s := range lockprof.Profile() {
+		if len(resources) == 0 || resources[0] == s.Lock {
+			ops = append(ops, lockStatsData(s))
+		}
+	}
+
+	totals := make(map[string]interface{})
+
+	for name, s := range lockprof.Totals() {
+		if len(resources) == 0 || resources[0] == name {
+			totals[name] = lockStatsData(s)
+		}
+	}
+
+	data := map[string]interface{}{
+		"enabled":    lockprof.Enabled(),
+		"totals":     totals,
+		"operations": ops,
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
+
+/*
+HandleDELETE handles a REST call to reset the lock contention profile.
+*/
+func (le *locksEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if len(resources) > 0 {
+		http.Error(w, "Invalid resource specification: "+strings.Join(resources, "/"),
+			http.StatusBadRequest)
+		return
+	}
+
+	lockprof.Reset()
+}
+
+/*
+lockStatsData converts the stats of a lock into a key-value map.
+*/
+func lockStatsData(s lockprof.Stats) map[string]interface{} {
+	ret := map[string]interface{}{
+		"lock":         s.Lock,
+		"acquisitions": s.Acquisitions,
+		"contended":    s.Contended,
+		"wait_ms":      float64(s.Wait.Nanoseconds()) / 1e6,
+		"max_wait_ms":  float64(s.MaxWait.Nanoseconds()) / 1e6,
+	}
+
+	if s.Op != "" {
+		ret["operation"] = s.Op
+	}
+
+	return ret
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (le *locksEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	params := []map[string]interface{}{
+		map[string]interface{}{
+			"name":        "lock",
+			"in":          "path",
+			"description": "Lock to select (graph, storage or htree).",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	responses := map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "Lock contention profile.",
+			"schema": map[string]interface{}{
+				"$ref": "#/definitions/LockProfile",
+			},
+		},
+		"default": map[string]interface{}{
+			"description": "Error response",
+			"schema": map[string]interface{}{
+				"$ref": "#/definitions/Error",
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/locks"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the lock contention profile.",
+			"description": "The profile contains for each lock and operation how often the lock was acquired, how often it was held by someone else and how long the operation had to wait for it. Locks are only profiled if the EnableLockProfiling option is set.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": responses,
+		},
+		"delete": map[string]interface{}{
+			"summary":     "Reset the lock contention profile.",
+			"description": "All recorded stats are removed.",
+			"produces": []string{
+				"text/plain",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The profile was reset.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/locks/{lock}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the contention profile of a single lock.",
+			"description": "The profile only contains the stats of the selected lock.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": params,
+			"responses":  responses,
+		},
+	}
+
+	// Add lock profile to definitions
+
+	lockStats := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"lock": map[string]interface{}{
+				"description": "Name of the lock.",
+				"type":        "string",
+			},
+			"operation": map[string]interface{}{
+				"description": "Operation which acquired the lock (not part of totals).",
+				"type":        "string",
+			},
+			"acquisitions": map[string]interface{}{
+				"description": "Number of times the lock was acquired.",
+				"type":        "integer",
+			},
+			"contended": map[string]interface{}{
+				"description": "Number of times the lock was held by someone else.",
+				"type":        "integer",
+			},
+			"wait_ms": map[string]interface{}{
+				"description": "Total time in milliseconds spent waiting for the lock.",
+				"type":        "number",
+			},
+			"max_wait_ms": map[string]interface{}{
+				"description": "Longest time in milliseconds spent waiting for the lock.",
+				"type":        "number",
+			},
+		},
+	}
+
+	s["definitions"].(map[string]interface{})["LockProfile"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"enabled": map[string]interface{}{
+				"description": "Flag if locks are profiled.",
+				"type":        "boolean",
+			},
+			"totals": map[string]interface{}{
+				"description":          "Stats of each lock summed up over all operations.",
+				"type":                 "object",
+				"additionalProperties": lockStats,
+			},
+			"operations": map[string]interface{}{
+				"description": "Stats of each lock and operation (longest total waiting time first).",
+				"type":        "array",
+				"items":       lockStats,
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/lockprof"
+)
+
+func TestLocksEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointLocks
+
+	lockprof.Enable(true)
+	defer func() {
+		lockprof.Enable(false)
+		lockprof.Reset()
+	}()
+
+	// Fetching a node acquires the lock of the graph manager
+
+	api.GM.FetchNode("main", "000", "Song")
+
+	st, _, res := sendTestRequest(queryURL+"graph", "GET", nil)
+
+	var profile map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &profile); err != nil || st != "200 OK" {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	totals := profile["totals"].(map[string]interface{})
+	ops := profile["operations"].([]interface{})
+
+	if profile["enabled"] != true || len(totals) != 1 || totals["graph"] == nil || len(ops) == 0 {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	found := false
+
+	for _, op := range ops {
+		if op.(map[string]interface{})["operation"] == "FetchNodePart" {
+			found = op.(map[string]interface{})["acquisitions"].(float64) > 0
+		}
+	}
+
+	if !found {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	// Reset the profile
+
+	st, _, res = sendTestRequest(queryURL, "DELETE", nil)
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	lockprof.Enable(false)
+
+	st, _, res = sendTestRequest(queryURL, "GET", nil)
+	if st != "200 OK" || res != `
+{
+  "enabled": false,
+  "operations": [],
+  "totals": {}
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Test error cases
+
+	st, _, res = sendTestRequest(queryURL+"graph/foo", "GET", nil)
+	if st != "400 Bad Request" || res != "Invalid resource specification: foo" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"graph", "DELETE", nil)
+	if st != "400 Bad Request" || res != "Invalid resource specification: graph" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
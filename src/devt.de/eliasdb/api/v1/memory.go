@@ -0,0 +1,132 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"devt.de/eliasdb/api"
+)
+
+/*
+EndpointMemory is the memory usage endpoint URL (rooted). Handles everything under memory/...
+*/
+const EndpointMemory = api.APIRoot + APIv1 + "/memory/"
+
+/*
+MemoryEndpointInst creates a new endpoint handler.
+*/
+func MemoryEndpointInst() api.RestEndpointHandler {
+	return &memoryEndpoint{}
+}
+
+/*
+Handler object for memory usage queries.
+*/
+type memoryEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a memory usage REST call.
+*/
+func (me *memoryEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	var ms runtime.MemStats
+
+	data := make(map[string]interface{})
+
+	// Get Go runtime totals
+
+	runtime.ReadMemStats(&ms)
+
+	data["runtime"] = map[string]interface{}{
+		"alloc":        ms.Alloc,
+		"sys":          ms.Sys,
+		"heap_objects": ms.HeapObjects,
+		"num_gc":       ms.NumGC,
+	}
+
+	// Get memory which is held by the graph manager and its storage
+
+	mu := api.GM.MemoryUsage()
+
+	data["graph"] = map[string]interface{}{
+		"map_cache":      mu.MapCache,
+		"key_index":      mu.KeyIndex,
+		"key_index_keys": mu.KeyIndexKeys,
+		"kind_stats":     mu.KindStats,
+	}
+
+	if mu.Storage != nil {
+		data["storage"] = map[string]interface{}{
+			"records":       mu.Storage.Records,
+			"trans_records": mu.Storage.TransRecords,
+			"cache_objects": mu.Storage.CacheObjects,
+			"cache_bytes":   mu.Storage.CacheBytes,
+		}
+	}
+
+	// Get the number of cached query results
+
+	var results uint64
+
+	if ResultCache != nil {
+		results = ResultCache.Size()
+	}
+
+	data["query"] = map[string]interface{}{
+		"cached_results": results,
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (me *memoryEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/memory"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return memory usage information.",
+			"description": "The memory endpoint returns an estimate of the memory which is held by caches, transactions, query results and index structures as well as Go runtime totals.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A key-value map.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,52 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMemoryEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointMemory
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	var mem map[string]map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &mem); err != nil || st != "200 OK" {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	if mem["runtime"]["alloc"].(float64) == 0 || mem["graph"]["map_cache"].(float64) == 0 {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	// The test graph is held in memory
+
+	if _, ok := mem["storage"]; ok {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	if _, ok := mem["query"]["cached_results"]; !ok {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL, "DELETE", nil)
+	if st != "405 Method Not Allowed" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
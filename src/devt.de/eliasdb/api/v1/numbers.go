@@ -0,0 +1,186 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+/*
+Possible formats of numeric attribute values in JSON responses
+*/
+const (
+	NumberFormatNumber = "number" // Write all numbers as JSON numbers
+	NumberFormatString = "string" // Write all numbers as JSON strings
+	NumberFormatSafe   = "safe"   // Write integers as strings if JavaScript cannot represent them exactly
+)
+
+/*
+maxSafeInteger is the largest integer which JavaScript can represent exactly
+*/
+const maxSafeInteger = 1<<53 - 1
+
+/*
+NumberFormat is the default format of numeric attribute values in responses of
+the graph and query endpoints. Clients can choose a different format with the
+numbers query parameter.
+*/
+var NumberFormat = NumberFormatNumber
+
+/*
+FloatPrecision is the default number of digits after the decimal point of
+floating point attribute values in responses of the graph and query endpoints.
+Clients can choose a different precision with the precision query parameter.
+A value of -1 writes the smallest number of digits which represents the value
+exactly.
+*/
+var FloatPrecision = -1
+
+/*
+numberOptions controls how numeric values of a response are written.
+*/
+type numberOptions struct {
+	format    string // Format of numeric values
+	precision int    // Digits after the decimal point of floating point values
+}
+
+/*
+requestNumberOptions returns the number options of a request. Writes an error
+and returns false if the request has invalid parameters.
+*/
+func requestNumberOptions(w http.ResponseWriter, r *http.Request) (*numberOptions, bool) {
+
+	format := r.URL.Query().Get("numbers")
+	if format == "" {
+		format = NumberFormat
+	}
+
+	if format != NumberFormatNumber && format != NumberFormatString && format != NumberFormatSafe {
+		http.Error(w, "Invalid parameter value: numbers should be number, string or safe",
+			http.StatusBadRequest)
+		return nil, false
+	}
+
+	precision, ok := queryParamPosNum(w, r, "precision")
+	if !ok {
+		return nil, false
+	} else if r.URL.Query().Get("precision") == "" {
+		precision = FloatPrecision
+	}
+
+	return &numberOptions{format, precision}, true
+}
+
+/*
+apply returns a copy of a given response value in which all numeric values
+are converted according to the number options. The given value is not
+modified.
+*/
+func (no *numberOptions) apply(val interface{}) interface{} {
+
+	if no.format == NumberFormatNumber && no.precision < 0 {
+		return val
+	}
+
+	return no.convert(reflect.ValueOf(val))
+}
+
+/*
+convert converts all numeric values of a given reflected value.
+*/
+func (no *numberOptions) convert(v reflect.Value) interface{} {
+
+	switch v.Kind() {
+
+	case reflect.Invalid:
+		return nil
+
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return v.Interface()
+		}
+		return no.convert(v.Elem())
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		if no.format == NumberFormatString ||
+			(no.format == NumberFormatSafe && (i > maxSafeInteger || i < -maxSafeInteger)) {
+			return strconv.FormatInt(i, 10)
+		}
+		return i
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i := v.Uint()
+		if no.format == NumberFormatString || (no.format == NumberFormatSafe && i > maxSafeInteger) {
+			return strconv.FormatUint(i, 10)
+		}
+		return i
+
+	case reflect.Float32, reflect.Float64:
+		s := strconv.FormatFloat(v.Float(), 'f', no.precision, v.Type().Bits())
+		if no.format == NumberFormatString {
+			return s
+		}
+		return json.Number(s)
+
+	case reflect.Map:
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+
+		ret := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			ret[k.String()] = no.convert(v.MapIndex(k))
+		}
+		return ret
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return v.Interface()
+		} else if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface() // Byte slices are written as base64 strings
+		}
+
+		ret := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			ret[i] = no.convert(v.Index(i))
+		}
+		return ret
+	}
+
+	return v.Interface()
+}
+
+/*
+numberSwaggerParams describes the query parameters for the format of numeric
+values in swagger.
+*/
+var numberSwaggerParams = []map[string]interface{}{
+	map[string]interface{}{
+		"name": "numbers",
+		"in":   "query",
+		"description": "Format of numeric values: number, string or safe (integers " +
+			"which JavaScript cannot represent exactly are returned as strings).",
+		"required": false,
+		"type":     "string",
+	},
+	map[string]interface{}{
+		"name":        "precision",
+		"in":          "query",
+		"description": "Number of digits after the decimal point of floating point values.",
+		"required":    false,
+		"type":        "number",
+		"format":      "integer",
+	},
+}
@@ -0,0 +1,148 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"testing"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/graph/data"
+)
+
+func TestNumberFormats(t *testing.T) {
+	graphURL := "http://localhost" + TESTPORT + EndpointGraph
+	queryURL := "http://localhost" + TESTPORT + EndpointQuery
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "a")
+	node.SetAttr("kind", "Num")
+	node.SetAttr("big", int64(1<<60))
+	node.SetAttr("small", 42)
+	node.SetAttr("float", 3.14159)
+
+	if err := api.GM.StoreNode("numtest", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	st, _, res := sendTestRequest(graphURL+"numtest/n/Num/a", "GET", nil)
+	if st != "200 OK" || res != `
+{
+  "big": 1152921504606846976,
+  "float": 3.14159,
+  "key": "a",
+  "kind": "Num",
+  "small": 42
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(graphURL+"numtest/n/Num/a?numbers=safe", "GET", nil)
+	if st != "200 OK" || res != `
+{
+  "big": "1152921504606846976",
+  "float": 3.14159,
+  "key": "a",
+  "kind": "Num",
+  "small": 42
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(graphURL+"numtest/n/Num?numbers=string&precision=2", "GET", nil)
+	if st != "200 OK" || res != `
+[
+  {
+    "big": "1152921504606846976",
+    "float": "3.14",
+    "key": "a",
+    "kind": "Num",
+    "small": "42"
+  }
+]`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Test the configured defaults
+
+	NumberFormat = NumberFormatSafe
+	FloatPrecision = 1
+
+	defer func() {
+		NumberFormat = NumberFormatNumber
+		FloatPrecision = -1
+	}()
+
+	st, _, res = sendTestRequest(queryURL+"numtest?q=get+Num+show+Num:big,Num:float", "GET", nil)
+	if st != "200 OK" || res != `
+{
+  "header": {
+    "data": [
+      "1:n:big",
+      "1:n:float"
+    ],
+    "format": [
+      "auto",
+      "auto"
+    ],
+    "labels": [
+      "Big",
+      "Float"
+    ],
+    "primary_kind": "Num"
+  },
+  "rows": [
+    [
+      "1152921504606846976",
+      3.1
+    ]
+  ],
+  "sources": [
+    [
+      "n:Num:a",
+      "n:Num:a"
+    ]
+  ]
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(graphURL+"numtest/n/Num/a?numbers=number&precision=3", "GET", nil)
+	if st != "200 OK" || res != `
+{
+  "big": 1152921504606846976,
+  "float": 3.142,
+  "key": "a",
+  "kind": "Num",
+  "small": 42
+}`[1:] {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Test invalid parameters
+
+	st, _, res = sendTestRequest(graphURL+"numtest/n/Num/a?numbers=hex", "GET", nil)
+	if st != "400 Bad Request" || res != "Invalid parameter value: numbers should be number, string or safe" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"numtest?q=get+Num&precision=-1", "GET", nil)
+	if st != "400 Bad Request" || res != "Invalid parameter value: precision should be a positive integer number" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/eql"
+)
+
+/*
+EndpointQueries is the active queries endpoint URL (rooted). Handles everything under queries/...
+*/
+const EndpointQueries = api.APIRoot + APIv1 + "/queries/"
+
+/*
+QueriesEndpointInst creates a new endpoint handler.
+*/
+func QueriesEndpointInst() api.RestEndpointHandler {
+	return &queriesEndpoint{}
+}
+
+/*
+Handler object for active queries.
+*/
+type queriesEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a REST call to list all currently executed queries.
+*/
+func (qe *queriesEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	data := make([]map[string]interface{}, 0)
+
+	for _, aq := range eql.ActiveQueries() {
+		data = append(data, map[string]interface{}{
+			"id":      aq.ID,
+			"query":   aq.Query,
+			"part":    aq.Part,
+			"client":  aq.Client,
+			"elapsed": aq.Elapsed().Seconds(),
+			"rows":    aq.Rows(),
+		})
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
+
+/*
+HandleDELETE handles a REST call to cancel a currently executed query.
+*/
+func (qe *queriesEndpoint) HandleDELETE(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 1, 1, "Need a query id") {
+		return
+	}
+
+	if !eql.KillQuery(resources[0]) {
+		http.Error(w, "Unknown query id", http.StatusNotFound)
+		return
+	}
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (qe *queriesEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/queries"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return all currently executed queries.",
+			"description": "The queries endpoint returns the id, text, partition, client, elapsed time in seconds and number of produced rows of all currently executed queries.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A list of active queries.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/queries/{id}"] = map[string]interface{}{
+		"delete": map[string]interface{}{
+			"summary":     "Cancel a currently executed query.",
+			"description": "The queries endpoint can be used to cancel a running query. The query fails with an error once it is cancelled.",
+			"produces": []string{
+				"text/plain",
+			},
+			"parameters": []map[string]interface{}{
+				map[string]interface{}{
+					"name":        "id",
+					"in":          "path",
+					"description": "Id of the query.",
+					"required":    true,
+					"type":        "string",
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The query was cancelled.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"devt.de/eliasdb/eql"
+	"devt.de/eliasdb/graph/data"
+)
+
+func TestQueriesEndpoint(t *testing.T) {
+	queriesURL := "http://localhost" + TESTPORT + EndpointQueries
+	queryURL := "http://localhost" + TESTPORT + EndpointQuery
+
+	st, _, res := sendTestRequest(queriesURL, "GET", nil)
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queriesURL+"foo", "DELETE", nil)
+	if st != "404 Not Found" || res != "Unknown query id" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queriesURL, "DELETE", nil)
+	if st != "400 Bad Request" || res != "Need a query id" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	// Kill a running query through the endpoint
+
+	var active []map[string]interface{}
+	var killStatus string
+
+	eql.RegisterScoreFunc("killer", func(node data.Node, relevance float64) float64 {
+		if active == nil {
+			_, _, res := sendTestRequest(queriesURL, "GET", nil)
+			json.Unmarshal([]byte(res), &active)
+
+			if len(active) == 1 {
+				killStatus, _, _ = sendTestRequest(queriesURL+active[0]["id"].(string), "DELETE", nil)
+			}
+		}
+		return 1
+	})
+	defer eql.RegisterScoreFunc("killer", nil)
+
+	st, _, res = sendTestRequest(queryURL+"main?q=get+Song+show+key,+@score(1,+killer)", "GET", nil)
+	if st != "400 Bad Request" ||
+		res != "EQL error in Main query: Query was cancelled (context canceled)" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	if len(active) != 1 || killStatus != "200 OK" ||
+		active[0]["query"] != "get Song show key, @score(1, killer)" ||
+		active[0]["part"] != "main" || active[0]["client"] == "" ||
+		active[0]["rows"] != float64(0) {
+		t.Error("Unexpected active queries:", active, killStatus)
+		return
+	}
+
+	st, _, res = sendTestRequest(queriesURL, "GET", nil)
+	if st != "200 OK" || res != "[]" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
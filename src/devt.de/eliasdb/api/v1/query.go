@@ -13,7 +13,9 @@ package v1
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"devt.de/common/datautil"
@@ -79,6 +81,18 @@ func (eq *queryEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		return
 	}
 
+	if !checkMemoryLimit(w) {
+		return
+	}
+
+	// Results are only written to cloud storage targets by POST requests
+
+	if r.URL.Query().Get("target") != "" {
+		http.Error(w, "Results can only be written to a cloud storage target with a POST request",
+			http.StatusBadRequest)
+		return
+	}
+
 	// Get limit parameter; -1 if not set
 
 	limit, ok := queryParamPosNum(w, r, "limit")
@@ -93,6 +107,60 @@ func (eq *queryEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		return
 	}
 
+	// Get the format of numeric values
+
+	numbers, ok := requestNumberOptions(w, r)
+	if !ok {
+		return
+	}
+
+	if res, resID, ok := eq.result(w, r, resources[0]); ok {
+		eq.writeResultData(w, res, resID, offset, limit, numbers)
+	}
+}
+
+/*
+HandlePOST handles a REST call which writes all rows of a search query result
+to a cloud storage target.
+*/
+func (eq *queryEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 1, 1, "Need a partition") {
+		return
+	}
+
+	if !checkMemoryLimit(w) {
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+
+	if target == "" {
+		http.Error(w, "Missing cloud storage target (target parameter)", http.StatusBadRequest)
+		return
+	}
+
+	// Get the format of numeric values
+
+	numbers, ok := requestNumberOptions(w, r)
+	if !ok {
+		return
+	}
+
+	if res, resID, ok := eq.result(w, r, resources[0]); ok {
+		eq.writeResultToTarget(w, r, res, resID, target, r.URL.Query().Get("object"), numbers)
+	}
+}
+
+/*
+result returns the result of a request. The result is either taken from the
+result cache (rid parameter) or a new query is run (q parameter) and its
+result is stored in the cache. Returns false if an error was written.
+*/
+func (eq *queryEndpoint) result(w http.ResponseWriter, r *http.Request, part string) (eql.SearchResult, string, bool) {
+
 	// See if a result id was given
 
 	resID := r.URL.Query().Get("rid")
@@ -101,29 +169,27 @@ func (eq *queryEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 		res, ok := ResultCache.Get(resID)
 		if !ok {
 			http.Error(w, "Unknown result id (rid parameter)", http.StatusBadRequest)
-			return
+			return nil, "", false
 		}
 
-		eq.writeResultData(w, res.(eql.SearchResult), resID, offset, limit)
-		return
+		return res.(eql.SearchResult), resID, true
 	}
 
 	// Run the query
 
 	query := r.URL.Query().Get("q")
-	part := resources[0]
 
 	if query == "" {
 		http.Error(w, "Missing query (q parameter)", http.StatusBadRequest)
-		return
+		return nil, "", false
 	}
 
-	res, err := eql.RunQuery(stringutil.CreateDisplayString(part)+" query",
-		part, query, api.GM)
+	res, err := eql.RunQueryContext(r.Context(), r.RemoteAddr,
+		stringutil.CreateDisplayString(part)+" query", part, query, api.GM)
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		http.Error(w, err.Error(), errorStatus(err))
+		return nil, "", false
 	}
 
 	// Store the result in the cache
@@ -132,31 +198,60 @@ func (eq *queryEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resou
 
 	ResultCache.Put(resID, res)
 
-	eq.writeResultData(w, res, resID, offset, limit)
+	return res, resID, true
+}
+
+/*
+writeResultToTarget writes all rows of a result to an object of a cloud storage
+target. The client receives only the location and size of the written object.
+*/
+func (eq *queryEndpoint) writeResultToTarget(w http.ResponseWriter, r *http.Request,
+	res eql.SearchResult, resID string, target string, object string, numbers *numberOptions) {
+
+	size, ok := writeToCloudTarget(w, r, target, object, func(out io.Writer) error {
+		return json.NewEncoder(out).Encode(numbers.apply(resultData(res, 0, res.Rows(), res.RowSources())))
+	})
+
+	if !ok {
+		return
+	}
+
+	// Set response header values
+
+	w.Header().Add(HTTPHeaderTotalCount, fmt.Sprint(res.RowCount()))
+	w.Header().Add(HTTPHeaderCacheID, resID)
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(map[string]interface{}{
+		"target": target,
+		"object": object,
+		"rows":   res.RowCount(),
+		"size":   size,
+	})
 }
 
 /*
 writeResultData writes result data for the client.
 */
 func (eq *queryEndpoint) writeResultData(w http.ResponseWriter, res eql.SearchResult,
-	resID string, offset int, limit int) {
+	resID string, offset int, limit int, numbers *numberOptions) {
 
 	// Write out the data
 
-	header := res.Header()
-
 	ret := json.NewEncoder(w)
 
-	data := make(map[string]interface{})
+	var data map[string]interface{}
 
 	if limit == -1 && offset == -1 {
-		data["rows"] = res.Rows()
-		data["sources"] = res.RowSources()
+		data = resultData(res, 0, res.Rows(), res.RowSources())
 
 	} else {
 
 		rows := res.Rows()
 		srcs := res.RowSources()
+		start := 0
 
 		if offset > 0 {
 
@@ -167,6 +262,7 @@ func (eq *queryEndpoint) writeResultData(w http.ResponseWriter, res eql.SearchRe
 
 			rows = rows[offset:]
 			srcs = srcs[offset:]
+			start = offset
 		}
 
 		if limit != -1 && limit < len(rows) {
@@ -174,12 +270,38 @@ func (eq *queryEndpoint) writeResultData(w http.ResponseWriter, res eql.SearchRe
 			srcs = srcs[:limit]
 		}
 
-		data["rows"] = rows
-		data["sources"] = srcs
+		data = resultData(res, start, rows, srcs)
+	}
+
+	// Set response header values
+
+	w.Header().Add(HTTPHeaderTotalCount, fmt.Sprint(res.RowCount()))
+	w.Header().Add(HTTPHeaderCacheID, resID)
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret.Encode(numbers.apply(data))
+}
+
+/*
+resultData returns the given rows of a result together with the result header.
+The rows start at a given offset of the result. Grouped results also contain
+the given rows grouped by their grouping node.
+*/
+func resultData(res eql.SearchResult, offset int, rows [][]interface{}, srcs [][]string) map[string]interface{} {
+
+	data := make(map[string]interface{})
+
+	data["rows"] = rows
+	data["sources"] = srcs
+
+	if gres, ok := res.(eql.GroupedSearchResult); ok && gres.GroupStep() > 0 {
+		data["groups"] = resultGroups(gres, offset, rows, srcs)
 	}
 
 	// Write out result header
 
+	header := res.Header()
 	dataHeader := make(map[string]interface{})
 
 	data["header"] = dataHeader
@@ -189,14 +311,46 @@ func (eq *queryEndpoint) writeResultData(w http.ResponseWriter, res eql.SearchRe
 	dataHeader["data"] = header.Data()
 	dataHeader["primary_kind"] = header.PrimaryKind()
 
-	// Set response header values
+	return data
+}
 
-	w.Header().Add(HTTPHeaderTotalCount, fmt.Sprint(res.RowCount()))
-	w.Header().Add(HTTPHeaderCacheID, resID)
+/*
+resultGroups groups the given rows of a result by their grouping node. Groups
+are ordered by the first row which they contain.
+*/
+func resultGroups(res eql.GroupedSearchResult, offset int, rows [][]interface{},
+	srcs [][]string) []map[string]interface{} {
 
-	w.Header().Set("content-type", "application/json; charset=utf-8")
+	groups := make([]map[string]interface{}, 0)
+	lookup := make(map[string]map[string]interface{})
+
+	for i, row := range rows {
+		src := res.RowGroup(offset + i)
+
+		group, ok := lookup[src]
+		if !ok {
+			var kind, key string
+
+			if ss := strings.SplitN(src, ":", 3); len(ss) == 3 {
+				kind, key = ss[1], ss[2]
+			}
 
-	ret.Encode(data)
+			group = map[string]interface{}{
+				"kind":    kind,
+				"key":     key,
+				"rows":    make([][]interface{}, 0),
+				"sources": make([][]string, 0),
+			}
+
+			lookup[src] = group
+			groups = append(groups, group)
+		}
+
+		group["rows"] = append(group["rows"].([][]interface{}), row)
+		group["sources"] = append(group["sources"].([][]string), srcs[i])
+	}
+
+	return groups
 }
 
 /*
@@ -214,7 +368,7 @@ func (eq *queryEndpoint) SwaggerDefs(s map[string]interface{}) {
 				"text/plain",
 				"application/json",
 			},
-			"parameters": []map[string]interface{}{
+			"parameters": append([]map[string]interface{}{
 				map[string]interface{}{
 					"name":        "partition",
 					"in":          "path",
@@ -253,7 +407,7 @@ func (eq *queryEndpoint) SwaggerDefs(s map[string]interface{}) {
 					"type":        "number",
 					"format":      "integer",
 				},
-			},
+			}, numberSwaggerParams...),
 			"responses": map[string]interface{}{
 				"200": map[string]interface{}{
 					"description": "A query result",
@@ -269,6 +423,77 @@ func (eq *queryEndpoint) SwaggerDefs(s map[string]interface{}) {
 				},
 			},
 		},
+		"post": map[string]interface{}{
+			"summary":     "Write the result of an EQL query to a cloud storage target.",
+			"description": "All rows of a query result are written as a result object directly to an object in a configured cloud storage bucket instead of being returned. The result is stored in the result cache like the result of a GET request.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": append([]map[string]interface{}{
+				map[string]interface{}{
+					"name":        "partition",
+					"in":          "path",
+					"description": "Partition to query.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "q",
+					"in":          "query",
+					"description": "URL encoded query to execute.",
+					"required":    false,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "rid",
+					"in":          "query",
+					"description": "Result id to retrieve from the result cache.",
+					"required":    false,
+					"type":        "number",
+					"format":      "integer",
+				},
+				map[string]interface{}{
+					"name":        "target",
+					"in":          "query",
+					"description": "Name of the configured cloud storage target.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "object",
+					"in":          "query",
+					"description": "Name of the object which should be written.",
+					"required":    true,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "codec",
+					"in":          "query",
+					"description": "Compression codec which should be used.",
+					"required":    false,
+					"type":        "string",
+				},
+				map[string]interface{}{
+					"name":        "level",
+					"in":          "query",
+					"description": "Compression level of the codec.",
+					"required":    false,
+					"type":        "integer",
+				},
+			}, numberSwaggerParams...),
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "The target, object name, number of rows and size of the written object.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
 	}
 
 	// Add QueryResult to definitions
@@ -336,6 +561,32 @@ func (eq *queryEndpoint) SwaggerDefs(s map[string]interface{}) {
 					},
 				},
 			},
+			"groups": map[string]interface{}{
+				"description": "Rows of the query result grouped by the node at the traversal step of the grouping directive (only present for grouped results).",
+				"type":        "array",
+				"items": map[string]interface{}{
+					"description": "A group of rows which share the same node at the grouping traversal step.",
+					"type":        "object",
+					"properties": map[string]interface{}{
+						"kind": map[string]interface{}{
+							"description": "Kind of the grouping node.",
+							"type":        "string",
+						},
+						"key": map[string]interface{}{
+							"description": "Key of the grouping node.",
+							"type":        "string",
+						},
+						"rows": map[string]interface{}{
+							"description": "Rows of the group.",
+							"type":        "array",
+						},
+						"sources": map[string]interface{}{
+							"description": "Data sources of the rows of the group.",
+							"type":        "array",
+						},
+					},
+				},
+			},
 		},
 	}
 
@@ -10,7 +10,13 @@
 
 package v1
 
-import "testing"
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"devt.de/eliasdb/memlimit"
+)
 
 func TestQueryPagination(t *testing.T) {
 	queryURL := "http://localhost" + TESTPORT + EndpointQuery
@@ -68,19 +74,19 @@ func TestQueryPagination(t *testing.T) {
   ],
   "sources": [
     [
-      "n:Song:DeadSong2",
-      "n:Song:DeadSong2",
-      "n:Song:DeadSong2"
+      "n:Song:Aria3",
+      "n:Song:Aria3",
+      "n:Song:Aria3"
     ],
     [
-      "n:Song:LoveSong3",
-      "n:Song:LoveSong3",
-      "n:Song:LoveSong3"
+      "n:Song:Aria4",
+      "n:Song:Aria4",
+      "n:Song:Aria4"
     ],
     [
-      "n:Song:MyOnlySong3",
-      "n:Song:MyOnlySong3",
-      "n:Song:MyOnlySong3"
+      "n:Song:DeadSong2",
+      "n:Song:DeadSong2",
+      "n:Song:DeadSong2"
     ]
   ]
 }`[1:] {
@@ -160,9 +166,9 @@ func TestQueryPagination(t *testing.T) {
   ],
   "sources": [
     [
-      "n:Song:Aria1",
-      "n:Song:Aria1",
-      "n:Song:Aria1"
+      "n:Song:FightSong4",
+      "n:Song:FightSong4",
+      "n:Song:FightSong4"
     ]
   ]
 }`[1:] {
@@ -185,6 +191,42 @@ func TestQueryPagination(t *testing.T) {
 	}
 }
 
+func TestQueryGrouping(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointQuery
+
+	st, _, res := sendTestRequest(queryURL+"main?q=get+Song+traverse+:::Author+end+show+key,+2:n:name+"+
+		"with+grouping(2),+ordering(ascending+key)&offset=3&limit=3", "GET", nil)
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	var data map[string]interface{}
+
+	if err := json.Unmarshal([]byte(res), &data); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if out, _ := json.Marshal(data["groups"]); string(out) != `[`+
+		`{"key":"000","kind":"Author","rows":[["Aria4","John"]],"sources":[["n:Song:Aria4","n:Author:000"]]},`+
+		`{"key":"123","kind":"Author","rows":[["DeadSong2","Mike"],["FightSong4","Mike"]],`+
+		`"sources":[["n:Song:DeadSong2","n:Author:123"],["n:Song:FightSong4","n:Author:123"]]}]` {
+		t.Error("Unexpected result:", string(out))
+		return
+	}
+
+	// Results without grouping have no groups
+
+	_, _, res = sendTestRequest(queryURL+"main?q=get+Song", "GET", nil)
+
+	if strings.Contains(res, "groups") {
+		t.Error("Unexpected response:", res)
+		return
+	}
+}
+
 func TestQuery(t *testing.T) {
 	queryURL := "http://localhost" + TESTPORT + EndpointQuery
 
@@ -302,20 +344,35 @@ func TestQuery(t *testing.T) {
   ],
   "sources": [
     [
-      "n:Song:StrangeSong1",
-      "n:Song:StrangeSong1",
-      "n:Song:StrangeSong1"
+      "n:Song:Aria1",
+      "n:Song:Aria1",
+      "n:Song:Aria1"
     ],
     [
-      "n:Song:FightSong4",
-      "n:Song:FightSong4",
-      "n:Song:FightSong4"
+      "n:Song:Aria2",
+      "n:Song:Aria2",
+      "n:Song:Aria2"
+    ],
+    [
+      "n:Song:Aria3",
+      "n:Song:Aria3",
+      "n:Song:Aria3"
+    ],
+    [
+      "n:Song:Aria4",
+      "n:Song:Aria4",
+      "n:Song:Aria4"
     ],
     [
       "n:Song:DeadSong2",
       "n:Song:DeadSong2",
       "n:Song:DeadSong2"
     ],
+    [
+      "n:Song:FightSong4",
+      "n:Song:FightSong4",
+      "n:Song:FightSong4"
+    ],
     [
       "n:Song:LoveSong3",
       "n:Song:LoveSong3",
@@ -327,24 +384,9 @@ func TestQuery(t *testing.T) {
       "n:Song:MyOnlySong3"
     ],
     [
-      "n:Song:Aria1",
-      "n:Song:Aria1",
-      "n:Song:Aria1"
-    ],
-    [
-      "n:Song:Aria2",
-      "n:Song:Aria2",
-      "n:Song:Aria2"
-    ],
-    [
-      "n:Song:Aria3",
-      "n:Song:Aria3",
-      "n:Song:Aria3"
-    ],
-    [
-      "n:Song:Aria4",
-      "n:Song:Aria4",
-      "n:Song:Aria4"
+      "n:Song:StrangeSong1",
+      "n:Song:StrangeSong1",
+      "n:Song:StrangeSong1"
     ]
   ]
 }`[1:] {
@@ -352,3 +394,36 @@ func TestQuery(t *testing.T) {
 		return
 	}
 }
+
+func TestQueryMemoryLimit(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointQuery
+
+	oldHeapSize := memlimit.HeapSize
+	memlimit.HeapSize = func() uint64 {
+		return 1000
+	}
+	defer func() {
+		memlimit.HeapSize = oldHeapSize
+		memlimit.SetLimit(0)
+	}()
+
+	memlimit.SetLimit(10)
+	memlimit.Update()
+
+	st, h, res := sendTestRequest(queryURL+"/main?q=get+Song", "GET", nil)
+
+	if st != "503 Service Unavailable" || res != "Soft memory limit exceeded - try again later" ||
+		h.Get("Retry-After") != "10" {
+		t.Error("Unexpected response:", st, h, res)
+		return
+	}
+
+	memlimit.SetLimit(0)
+
+	st, _, _ = sendTestRequest(queryURL+"/main?q=get+Song", "GET", nil)
+
+	if st != "200 OK" {
+		t.Error("Unexpected response:", st)
+		return
+	}
+}
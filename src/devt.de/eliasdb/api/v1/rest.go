@@ -15,7 +15,9 @@ import (
 	"strconv"
 	"strings"
 
+	"devt.de/common/errorutil"
 	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/memlimit"
 )
 
 /*
@@ -28,6 +30,11 @@ HTTPHeaderTotalCount is a special header value containing the total count of obj
 */
 const HTTPHeaderTotalCount = "X-Total-Count"
 
+/*
+HTTPHeaderCursor is a special header value containing a cursor token for the next page of a list.
+*/
+const HTTPHeaderCursor = "X-Cursor"
+
 /*
 HTTPHeaderCacheID is a special header value containing a cache ID for a quick follow up query.
 */
@@ -43,6 +50,19 @@ var V1EndpointMap = map[string]api.RestEndpointInst{
 	EndpointGraph:        GraphEndpointInst,
 	EndpointInfoQuery:    InfoEndpointInst,
 	EndpointClusterQuery: ClusterEndpointInst,
+	EndpointStats:        StatsEndpointInst,
+	EndpointMemory:       MemoryEndpointInst,
+	EndpointSize:         SizeEndpointInst,
+	EndpointQueries:      QueriesEndpointInst,
+	EndpointImport:       ImportEndpointInst,
+	EndpointExport:       ExportEndpointInst,
+	EndpointInvariants:   InvariantsEndpointInst,
+	EndpointDefinitions:  DefinitionsEndpointInst,
+	EndpointJournal:      JournalEndpointInst,
+	EndpointDelete:       DeleteEndpointInst,
+	EndpointArchive:      ArchiveEndpointInst,
+	EndpointFederation:   FederationEndpointInst,
+	EndpointLocks:        LocksEndpointInst,
 }
 
 // Helper functions
@@ -83,3 +103,40 @@ func queryParamPosNum(w http.ResponseWriter, r *http.Request, param string) (int
 
 	return num, true
 }
+
+/*
+checkMemoryLimit checks if an expensive request can be processed. Writes a
+retryable error and returns false if the soft memory limit is exceeded.
+*/
+func checkMemoryLimit(w http.ResponseWriter) bool {
+	if err := memlimit.Check(); err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(memlimit.RetryAfter.Seconds())))
+		http.Error(w, err.Error(), errorStatus(err))
+		return false
+	}
+
+	return true
+}
+
+/*
+errorStatus returns the HTTP status code for a given error. The status code
+is determined by the category of the error.
+*/
+func errorStatus(err error) int {
+	switch errorutil.Category(err) {
+	case errorutil.ErrNotFound:
+		return http.StatusNotFound
+	case errorutil.ErrConflict:
+		return http.StatusConflict
+	case errorutil.ErrInvalid:
+		return http.StatusBadRequest
+	case errorutil.ErrReadOnly:
+		return http.StatusForbidden
+	case errorutil.ErrQuota:
+		return http.StatusInsufficientStorage
+	case errorutil.ErrUnavailable:
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
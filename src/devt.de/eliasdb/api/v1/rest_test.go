@@ -13,6 +13,7 @@ package v1
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"io/ioutil"
 	"net/http"
@@ -22,12 +23,15 @@ import (
 	"sync"
 	"testing"
 
+	"devt.de/common/errorutil"
 	"devt.de/common/httputil"
 	"devt.de/eliasdb/api"
 	"devt.de/eliasdb/eql"
 	"devt.de/eliasdb/graph"
 	"devt.de/eliasdb/graph/data"
 	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/storage"
 )
 
 const TESTPORT = ":9090"
@@ -78,6 +82,45 @@ func TestSwaggerDefs(t *testing.T) {
 	}
 }
 
+func TestErrorStatus(t *testing.T) {
+
+	for category, status := range map[error]int{
+		errorutil.ErrNotFound:   http.StatusNotFound,
+		errorutil.ErrConflict:   http.StatusConflict,
+		errorutil.ErrInvalid:    http.StatusBadRequest,
+		errorutil.ErrReadOnly:   http.StatusForbidden,
+		errorutil.ErrQuota:      http.StatusInsufficientStorage,
+		errorutil.ErrCorruption: http.StatusInternalServerError,
+		errorutil.ErrInternal:   http.StatusInternalServerError,
+	} {
+		if res := errorStatus(errorutil.NewCategorizedError(category, "test")); res != status {
+			t.Error("Unexpected status for", category, ":", res)
+			return
+		}
+	}
+
+	// Errors which are not known to be caused by a client are internal errors
+
+	for _, err := range []error{storage.ErrSlotNotFound,
+		util.NewRuleError([]error{errors.New("test")})} {
+
+		if res := errorStatus(err); res != http.StatusInternalServerError {
+			t.Error("Unexpected status for", err, ":", res)
+			return
+		}
+	}
+
+	if res := errorStatus(util.NewRuleError([]error{&util.GraphError{Type: util.ErrInvalidData}})); res != http.StatusBadRequest {
+		t.Error("Unexpected status:", res)
+		return
+	}
+
+	if res := blobErrorStatus(storage.ErrSlotNotFound); res != http.StatusNotFound {
+		t.Error("Unexpected status:", res)
+		return
+	}
+}
+
 /*
 Send a request to a HTTP test server
 */
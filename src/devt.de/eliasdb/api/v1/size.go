@@ -0,0 +1,103 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"devt.de/eliasdb/api"
+)
+
+/*
+EndpointSize is the size report endpoint URL (rooted). Handles everything under size/...
+*/
+const EndpointSize = api.APIRoot + APIv1 + "/size/"
+
+/*
+SizeEndpointInst creates a new endpoint handler.
+*/
+func SizeEndpointInst() api.RestEndpointHandler {
+	return &sizeEndpoint{}
+}
+
+/*
+Handler object for size report queries.
+*/
+type sizeEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a size report REST call.
+*/
+func (se *sizeEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+
+	report, err := api.GM.SizeReport()
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := make(map[string]interface{})
+
+	for kind, sr := range report {
+		data[kind] = map[string]interface{}{
+			"nodes":      sr.Nodes,
+			"node_index": sr.NodeIndex,
+			"edges":      sr.Edges,
+			"edge_index": sr.EdgeIndex,
+			"total":      sr.Total(),
+		}
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *sizeEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	s["paths"].(map[string]interface{})["/v1/size"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return the size of the stored data of each kind.",
+			"description": "The size endpoint returns the number of bytes which are used by the nodes, edges and index entries of each kind. All stored data is visited so this can be an expensive operation.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "A map of kinds to size information.",
+				},
+				"default": map[string]interface{}{
+					"description": "Error response",
+					"schema": map[string]interface{}{
+						"$ref": "#/definitions/Error",
+					},
+				},
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
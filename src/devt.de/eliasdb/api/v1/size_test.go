@@ -0,0 +1,49 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSizeEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointSize
+
+	st, _, res := sendTestRequest(queryURL, "GET", nil)
+
+	var sizes map[string]map[string]uint64
+
+	if err := json.Unmarshal([]byte(res), &sizes); err != nil || st != "200 OK" {
+		t.Error("Unexpected response:", st, res, err)
+		return
+	}
+
+	// The test graph is held in memory so no sizes can be reported
+
+	if len(sizes) == 0 {
+		t.Error("Unexpected response:", res)
+		return
+	}
+
+	for kind, sr := range sizes {
+		if len(sr) != 5 || sr["total"] != 0 {
+			t.Error("Unexpected response:", kind, sr)
+			return
+		}
+	}
+
+	st, _, res = sendTestRequest(queryURL, "DELETE", nil)
+	if st != "405 Method Not Allowed" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"devt.de/eliasdb/api"
+	"devt.de/eliasdb/graph"
+)
+
+/*
+EndpointStats is the node kind statistics endpoint URL (rooted). Handles everything under stats/...
+*/
+const EndpointStats = api.APIRoot + APIv1 + "/stats/"
+
+/*
+StatsEndpointInst creates a new endpoint handler.
+*/
+func StatsEndpointInst() api.RestEndpointHandler {
+	return &statsEndpoint{}
+}
+
+/*
+Handler object for node kind statistics.
+*/
+type statsEndpoint struct {
+	*api.DefaultEndpointHandler
+}
+
+/*
+HandleGET handles a REST call to retrieve node kind statistics.
+*/
+func (se *statsEndpoint) HandleGET(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.handleStats(w, resources, api.GM.KindStats)
+}
+
+/*
+HandlePOST handles a REST call to refresh node kind statistics.
+*/
+func (se *statsEndpoint) HandlePOST(w http.ResponseWriter, r *http.Request, resources []string) {
+	se.handleStats(w, resources, api.GM.RefreshKindStats)
+}
+
+/*
+handleStats gets node kind statistics with a given function and writes them
+to the response.
+*/
+func (se *statsEndpoint) handleStats(w http.ResponseWriter, resources []string,
+	getStats func(string, string) (*graph.KindStats, error)) {
+
+	// Check parameters
+
+	if !checkResources(w, resources, 2, 2, "Need a partition and a node kind") {
+		return
+	}
+
+	stats, err := getStats(resources[0], resources[1])
+
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	} else if stats == nil {
+		http.Error(w, "Unknown partition or node kind", http.StatusNotFound)
+		return
+	}
+
+	attrs := make(map[string]interface{})
+
+	for attr, as := range stats.Attrs {
+		attrs[attr] = map[string]interface{}{
+			"count":    as.Count,
+			"distinct": as.Distinct,
+		}
+	}
+
+	data := map[string]interface{}{
+		"partition": stats.Part,
+		"kind":      stats.Kind,
+		"count":     stats.Count,
+		"mutations": stats.Mutations,
+		"refreshed": stats.Refreshed.Format(time.RFC3339),
+		"attrs":     attrs,
+	}
+
+	// Write data
+
+	w.Header().Set("content-type", "application/json; charset=utf-8")
+
+	ret := json.NewEncoder(w)
+	ret.Encode(data)
+}
+
+/*
+SwaggerDefs is used to describe the endpoint in swagger.
+*/
+func (se *statsEndpoint) SwaggerDefs(s map[string]interface{}) {
+
+	params := []map[string]interface{}{
+		map[string]interface{}{
+			"name":        "partition",
+			"in":          "path",
+			"description": "Partition to select.",
+			"required":    true,
+			"type":        "string",
+		},
+		map[string]interface{}{
+			"name":        "kind",
+			"in":          "path",
+			"description": "Node kind to select.",
+			"required":    true,
+			"type":        "string",
+		},
+	}
+
+	responses := map[string]interface{}{
+		"200": map[string]interface{}{
+			"description": "Statistics of the node kind.",
+			"schema": map[string]interface{}{
+				"$ref": "#/definitions/KindStats",
+			},
+		},
+		"default": map[string]interface{}{
+			"description": "Error response",
+			"schema": map[string]interface{}{
+				"$ref": "#/definitions/Error",
+			},
+		},
+	}
+
+	s["paths"].(map[string]interface{})["/v1/stats/{partition}/{kind}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":     "Return statistics of a node kind.",
+			"description": "Statistics are collected on first request and refreshed automatically once enough nodes have been changed.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": params,
+			"responses":  responses,
+		},
+		"post": map[string]interface{}{
+			"summary":     "Refresh the statistics of a node kind.",
+			"description": "The statistics of the node kind are collected immediately.",
+			"produces": []string{
+				"text/plain",
+				"application/json",
+			},
+			"parameters": params,
+			"responses":  responses,
+		},
+	}
+
+	// Add stats object to definition
+
+	s["definitions"].(map[string]interface{})["KindStats"] = map[string]interface{}{
+		"description": "Statistics of a node kind.",
+		"type":        "object",
+		"properties": map[string]interface{}{
+			"partition": map[string]interface{}{
+				"description": "Partition of the nodes.",
+				"type":        "string",
+			},
+			"kind": map[string]interface{}{
+				"description": "Kind of the nodes.",
+				"type":        "string",
+			},
+			"count": map[string]interface{}{
+				"description": "Number of nodes.",
+				"type":        "number",
+			},
+			"mutations": map[string]interface{}{
+				"description": "Number of node changes since the statistics were collected.",
+				"type":        "number",
+			},
+			"refreshed": map[string]interface{}{
+				"description": "Time when the statistics were collected.",
+				"type":        "string",
+			},
+			"attrs": map[string]interface{}{
+				"description": "Number of nodes which have an attribute and estimated number of distinct values for each attribute.",
+				"type":        "object",
+			},
+		},
+	}
+
+	// Add generic error object to definition
+
+	s["definitions"].(map[string]interface{})["Error"] = map[string]interface{}{
+		"description": "A human readable error mesage.",
+		"type":        "string",
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStatsEndpoint(t *testing.T) {
+	queryURL := "http://localhost" + TESTPORT + EndpointStats
+
+	st, _, res := sendTestRequest(queryURL+"main", "GET", nil)
+	if st != "400 Bad Request" || res != "Need a partition and a node kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/Foo", "GET", nil)
+	if st != "404 Not Found" || res != "Unknown partition or node kind" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/Foo-", "GET", nil)
+	if st != "400 Bad Request" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+
+	for _, method := range []string{"GET", "POST"} {
+		st, _, res = sendTestRequest(queryURL+"main/Author", method, nil)
+
+		var stats map[string]interface{}
+
+		if err := json.Unmarshal([]byte(res), &stats); err != nil || st != "200 OK" {
+			t.Error("Unexpected response:", st, res, err)
+			return
+		}
+
+		if stats["partition"] != "main" || stats["kind"] != "Author" ||
+			stats["count"] != float64(3) || stats["mutations"] != float64(0) {
+			t.Error("Unexpected response:", res)
+			return
+		}
+
+		name := stats["attrs"].(map[string]interface{})["name"].(map[string]interface{})
+
+		if name["count"] != float64(3) || name["distinct"] != float64(3) {
+			t.Error("Unexpected response:", res)
+			return
+		}
+	}
+
+	st, _, res = sendTestRequest(queryURL+"main/Author", "DELETE", nil)
+	if st != "405 Method Not Allowed" {
+		t.Error("Unexpected response:", st, res)
+		return
+	}
+}
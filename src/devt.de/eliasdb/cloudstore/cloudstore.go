@@ -0,0 +1,367 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package cloudstore writes data directly to buckets of cloud storage services.
+
+Data is uploaded with the multipart upload API of Amazon S3 so objects of
+arbitrary size can be written without knowing their size in advance. Only
+one part is held in memory at any time. Any storage service with an S3
+compatible API can be used - e.g. Google Cloud Storage with HMAC keys and the
+endpoint https://storage.googleapis.com.
+
+Targets are registered under a name so they can be referred to without
+exposing their credentials:
+
+	cloudstore.RegisterTarget("backup", &cloudstore.Target{
+		Endpoint:  "https://s3.amazonaws.com",
+		Region:    "eu-west-1",
+		Bucket:    "mybucket",
+		AccessKey: "...",
+		SecretKey: "...",
+	})
+
+	target, _ := cloudstore.GetTarget("backup")
+
+	w, err := cloudstore.NewWriter(target, "dump.json")
+	...
+	err = w.Close()
+*/
+package cloudstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+DefaultPartSize is the default size of uploaded parts. S3 requires all parts
+except the last one to be at least 5 MiB.
+*/
+const DefaultPartSize = 8 * 1024 * 1024
+
+/*
+DefaultRegion is the region which is used if a target does not specify one.
+*/
+const DefaultRegion = "us-east-1"
+
+/*
+DefaultRequestTimeout is the time limit for a single request to a storage
+service (including the upload of a part).
+*/
+const DefaultRequestTimeout = 5 * time.Minute
+
+/*
+HTTPClient is the client which is used for all requests.
+*/
+var HTTPClient = &http.Client{Timeout: DefaultRequestTimeout}
+
+/*
+Target is a bucket of a cloud storage service.
+*/
+type Target struct {
+	Endpoint  string `json:"endpoint"`   // URL of the storage service
+	Region    string `json:"region"`     // Region of the bucket (default is us-east-1)
+	Bucket    string `json:"bucket"`     // Name of the bucket
+	Prefix    string `json:"prefix"`     // Prefix for all object names
+	AccessKey string `json:"access_key"` // Access key id
+	SecretKey string `json:"secret_key"` // Secret access key
+	PartSize  int    `json:"part_size"`  // Size of uploaded parts (default is DefaultPartSize)
+}
+
+/*
+NewTargetFromConfig creates a new target from a config map which uses the
+JSON names of the target fields.
+*/
+func NewTargetFromConfig(config map[string]interface{}) (*Target, error) {
+	target := &Target{}
+
+	data, err := json.Marshal(config)
+	if err == nil {
+		err = json.Unmarshal(data, target)
+	}
+
+	if err == nil && (target.Endpoint == "" || target.Bucket == "") {
+		err = fmt.Errorf("Target needs an endpoint and a bucket")
+	}
+
+	return target, err
+}
+
+/*
+targets holds all registered targets.
+*/
+var targets = make(map[string]*Target)
+
+/*
+targetsLock protects the target registry.
+*/
+var targetsLock = &sync.RWMutex{}
+
+/*
+RegisterTarget registers a target under a given name. A nil target removes
+a registered target.
+*/
+func RegisterTarget(name string, target *Target) {
+	targetsLock.Lock()
+	defer targetsLock.Unlock()
+
+	if target == nil {
+		delete(targets, name)
+	} else {
+		targets[name] = target
+	}
+}
+
+/*
+GetTarget returns a registered target.
+*/
+func GetTarget(name string) (*Target, bool) {
+	targetsLock.RLock()
+	defer targetsLock.RUnlock()
+
+	target, ok := targets[name]
+
+	return target, ok
+}
+
+/*
+Error is an error which was returned by a storage service.
+*/
+type Error struct {
+	Status  int    // HTTP status of the response
+	Code    string `xml:"Code"`    // Error code of the service
+	Message string `xml:"Message"` // Error message of the service
+}
+
+/*
+Error returns a human-readable string representation of this error.
+*/
+func (e *Error) Error() string {
+	return fmt.Sprintf("Storage service error %v (%v): %v", e.Status, e.Code, e.Message)
+}
+
+/*
+Writer uploads all written data as a single object. Data is buffered and
+uploaded in parts. The object becomes visible once the writer is closed.
+*/
+type Writer struct {
+	target   *Target  // Target of the upload
+	object   string   // Name of the uploaded object
+	uploadID string   // Id of the multipart upload
+	partSize int      // Size of uploaded parts
+	buf      []byte   // Data which has not been uploaded yet
+	etags    []string // ETags of all uploaded parts
+	size     int64    // Number of written bytes
+	err      error    // First error which occurred
+}
+
+/*
+NewWriter starts a new upload of an object to a given target.
+*/
+func NewWriter(target *Target, object string) (*Writer, error) {
+
+	partSize := target.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+
+	w := &Writer{target, target.Prefix + object, "", partSize,
+		make([]byte, 0, partSize), nil, 0, nil}
+
+	body, err := w.request("POST", map[string]string{"uploads": ""}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+
+	if err = xml.Unmarshal(body, res); err == nil && res.UploadID == "" {
+		err = fmt.Errorf("No upload id in response")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not start upload: %v", err)
+	}
+
+	w.uploadID = res.UploadID
+
+	return w, nil
+}
+
+/*
+Write writes data to the object. All data is uploaded in parts of the
+configured part size.
+*/
+func (w *Writer) Write(p []byte) (int, error) {
+	var n int
+
+	for w.err == nil && len(p) > 0 {
+		toCopy := w.partSize - len(w.buf)
+		if toCopy > len(p) {
+			toCopy = len(p)
+		}
+
+		w.buf = append(w.buf, p[:toCopy]...)
+		p = p[toCopy:]
+		n += toCopy
+
+		if len(w.buf) == w.partSize {
+			w.err = w.uploadPart()
+		}
+	}
+
+	w.size += int64(n)
+
+	return n, w.err
+}
+
+/*
+Size returns the number of bytes which were written.
+*/
+func (w *Writer) Size() int64 {
+	return w.size
+}
+
+/*
+Close uploads all remaining data and completes the upload. The upload is
+aborted if an error occurs.
+*/
+func (w *Writer) Close() error {
+
+	// Always upload at least one part
+
+	if w.err == nil && (len(w.buf) > 0 || len(w.etags) == 0) {
+		w.err = w.uploadPart()
+	}
+
+	if w.err != nil {
+		w.Abort()
+		return w.err
+	}
+
+	var body bytes.Buffer
+
+	body.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range w.etags {
+		fmt.Fprintf(&body, "<Part><PartNumber>%v</PartNumber><ETag>", i+1)
+		xml.EscapeText(&body, []byte(etag))
+		body.WriteString("</ETag></Part>")
+	}
+	body.WriteString("</CompleteMultipartUpload>")
+
+	_, w.err = w.request("POST", map[string]string{"uploadId": w.uploadID}, body.Bytes(), nil)
+
+	return w.err
+}
+
+/*
+Abort aborts the upload. All uploaded parts are discarded.
+*/
+func (w *Writer) Abort() error {
+	if w.err == nil {
+		w.err = fmt.Errorf("Upload was aborted")
+	}
+
+	_, err := w.request("DELETE", map[string]string{"uploadId": w.uploadID}, nil, nil)
+
+	return err
+}
+
+/*
+uploadPart uploads all buffered data as the next part.
+*/
+func (w *Writer) uploadPart() error {
+	header := make(http.Header)
+
+	_, err := w.request("PUT", map[string]string{
+		"partNumber": fmt.Sprint(len(w.etags) + 1),
+		"uploadId":   w.uploadID,
+	}, w.buf, header)
+
+	if err == nil {
+		w.etags = append(w.etags, header.Get("ETag"))
+		w.buf = w.buf[:0]
+	}
+
+	return err
+}
+
+/*
+request sends a signed request for the object of this writer. The headers of
+the response are copied into a given header object. Returns the body of the
+response.
+*/
+func (w *Writer) request(method string, query map[string]string, body []byte,
+	resHeader http.Header) ([]byte, error) {
+
+	u, err := url.Parse(strings.TrimRight(w.target.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path += "/" + w.target.Bucket + "/" + w.object
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	region := w.target.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+
+	SignRequest(req, body, region, w.target.AccessKey, w.target.SecretKey, time.Now().UTC())
+
+	res, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// S3 may report errors with a successful status code
+
+	if res.StatusCode/100 != 2 || bytes.HasPrefix(bytes.TrimSpace(
+		bytes.TrimPrefix(resBody, []byte(xml.Header))), []byte("<Error>")) {
+
+		serr := &Error{Status: res.StatusCode}
+		if xml.Unmarshal(resBody, serr) != nil || serr.Message == "" {
+			serr.Message = strings.TrimSpace(string(resBody))
+		}
+
+		return nil, serr
+	}
+
+	for k, v := range res.Header {
+		if resHeader != nil {
+			resHeader[k] = v
+		}
+	}
+
+	return resBody, nil
+}
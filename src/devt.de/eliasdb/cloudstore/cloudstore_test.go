@@ -0,0 +1,252 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cloudstore
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+testStore is a minimal storage service which supports multipart uploads.
+*/
+type testStore struct {
+	objects  map[string]string            // Completed objects
+	uploads  map[string]map[string]string // Parts of running uploads
+	requests []string                     // Log of all requests
+	failPart bool                         // Flag if part uploads should fail
+	lock     sync.Mutex
+}
+
+func newTestStore() (*testStore, *httptest.Server) {
+	ts := &testStore{make(map[string]string), make(map[string]map[string]string), nil, false, sync.Mutex{}}
+	return ts, httptest.NewServer(ts)
+}
+
+func (ts *testStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	q := r.URL.Query()
+	body, _ := ioutil.ReadAll(r.Body)
+
+	ts.requests = append(ts.requests, r.Method+" "+r.URL.Path)
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=mykey/") ||
+		r.Header.Get("x-amz-content-sha256") != sha256Hex(body) {
+
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>")
+		return
+	}
+
+	_, isInit := q["uploads"]
+
+	switch {
+
+	case r.Method == "POST" && isInit:
+		id := fmt.Sprint("upload", len(ts.uploads)+len(ts.objects))
+		ts.uploads[id] = make(map[string]string)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%v</UploadId></InitiateMultipartUploadResult>", id)
+
+	case r.Method == "PUT":
+		if ts.failPart {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "<Error><Code>InternalError</Code><Message>Part failed</Message></Error>")
+			return
+		}
+		etag := fmt.Sprintf(`"etag%v"`, q.Get("partNumber"))
+		ts.uploads[q.Get("uploadId")][etag] = string(body)
+		w.Header().Set("ETag", etag)
+
+	case r.Method == "POST":
+		parts := ts.uploads[q.Get("uploadId")]
+		complete := &struct {
+			Parts []struct {
+				ETag string
+			} `xml:"Part"`
+		}{}
+		xml.Unmarshal(body, complete)
+
+		var obj string
+		for _, p := range complete.Parts {
+			obj += parts[p.ETag]
+		}
+
+		ts.objects[r.URL.Path] = obj
+		delete(ts.uploads, q.Get("uploadId"))
+
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+
+	case r.Method == "DELETE":
+		delete(ts.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	ts, server := newTestStore()
+	defer server.Close()
+
+	target := &Target{server.URL, "", "mybucket", "exports/", "mykey", "mysecret", 4}
+
+	w, err := NewWriter(target, "my dump.txt")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fmt.Fprint(w, "Hello ")
+	fmt.Fprint(w, "World!")
+
+	if len(ts.requests) != 4 {
+		t.Error("Unexpected requests:", ts.requests)
+		return
+	}
+
+	if err := w.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if w.Size() != 12 || ts.objects["/mybucket/exports/my dump.txt"] != "Hello World!" || len(ts.uploads) != 0 {
+		t.Error("Unexpected result:", w.Size(), ts.objects, ts.uploads)
+		return
+	}
+
+	// Empty objects are uploaded as a single empty part
+
+	w, _ = NewWriter(target, "empty")
+
+	if err := w.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if obj, ok := ts.objects["/mybucket/exports/empty"]; !ok || obj != "" {
+		t.Error("Unexpected result:", ts.objects)
+		return
+	}
+
+	// Failed uploads are aborted
+
+	w, _ = NewWriter(target, "failed")
+
+	ts.failPart = true
+
+	if _, err := fmt.Fprint(w, "Hello World!"); err == nil ||
+		err.Error() != "Storage service error 500 (InternalError): Part failed" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := fmt.Fprint(w, "Hello"); err == nil {
+		t.Error("Writer should keep the first error")
+		return
+	}
+
+	if err := w.Close(); err == nil || len(ts.uploads) != 0 {
+		t.Error("Unexpected result:", err, ts.uploads)
+		return
+	}
+
+	if _, ok := ts.objects["/mybucket/exports/failed"]; ok {
+		t.Error("Failed upload should not produce an object")
+		return
+	}
+
+	ts.failPart = false
+
+	w, _ = NewWriter(target, "aborted")
+
+	fmt.Fprint(w, "Hello World!")
+
+	if err := w.Abort(); err != nil || len(ts.uploads) != 0 {
+		t.Error("Unexpected result:", err, ts.uploads)
+		return
+	}
+
+	if err := w.Close(); err == nil || err.Error() != "Upload was aborted" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Test errors of the storage service
+
+	target.SecretKey = ""
+	target.AccessKey = "foo"
+
+	if _, err := NewWriter(target, "foo"); err == nil ||
+		err.Error() != "Storage service error 403 (AccessDenied): Access Denied" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	target.Endpoint = "foo:bar"
+
+	if _, err := NewWriter(target, "foo"); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestTargets(t *testing.T) {
+
+	if _, ok := GetTarget("test"); ok {
+		t.Error("Unexpected target")
+		return
+	}
+
+	target, err := NewTargetFromConfig(map[string]interface{}{
+		"endpoint":  "https://storage.googleapis.com",
+		"bucket":    "mybucket",
+		"part_size": 5242880.0,
+	})
+
+	if err != nil || fmt.Sprint(target) != "&{https://storage.googleapis.com  mybucket    5242880}" {
+		t.Error("Unexpected result:", target, err)
+		return
+	}
+
+	RegisterTarget("test", target)
+
+	if res, ok := GetTarget("test"); !ok || res != target {
+		t.Error("Unexpected result:", res, ok)
+		return
+	}
+
+	RegisterTarget("test", nil)
+
+	if _, ok := GetTarget("test"); ok {
+		t.Error("Unexpected target")
+		return
+	}
+
+	if _, err := NewTargetFromConfig(map[string]interface{}{
+		"endpoint": "https://storage.googleapis.com",
+	}); err == nil || err.Error() != "Target needs an endpoint and a bucket" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := NewTargetFromConfig(map[string]interface{}{
+		"endpoint": 1,
+	}); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
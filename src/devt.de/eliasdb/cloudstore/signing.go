@@ -0,0 +1,168 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cloudstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+SignRequest signs a request for the S3 API with AWS Signature Version 4. All
+headers of the request are signed - headers which are added after signing
+invalidate the signature.
+*/
+func SignRequest(req *http.Request, body []byte, region string, accessKey string,
+	secretKey string, t time.Time) {
+
+	payloadHash := sha256Hex(body)
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// Build canonical headers
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	names := []string{"host"}
+
+	for k, v := range req.Header {
+		name := strings.ToLower(k)
+
+		vals := make([]string, len(v))
+		for i, val := range v {
+			vals[i] = strings.Join(strings.Fields(val), " ")
+		}
+
+		headers[name] = strings.Join(vals, ",")
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	// Build canonical query
+
+	query := make(map[string]string)
+	for k, v := range req.URL.Query() {
+		query[k] = strings.Join(v, ",")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%v/%v,SignedHeaders=%v,Signature=%v",
+		accessKey, scope, signedHeaders, signature))
+}
+
+/*
+canonicalQuery returns the canonical form of given query parameters.
+*/
+func canonicalQuery(query map[string]string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	params := make([]string, len(keys))
+	for i, k := range keys {
+		params[i] = escape(k, true) + "=" + escape(query[k], true)
+	}
+
+	return strings.Join(params, "&")
+}
+
+/*
+escapePath escapes a path as required for signing. Slashes are not escaped.
+*/
+func escapePath(path string) string {
+	return escape(path, false)
+}
+
+/*
+escape URI-encodes all characters apart from unreserved characters. Slashes
+are only encoded if requested.
+*/
+func escape(s string, encodeSlash bool) string {
+	var ret strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+
+			ret.WriteByte(c)
+
+		} else {
+
+			fmt.Fprintf(&ret, "%%%02X", c)
+		}
+	}
+
+	return ret.String()
+}
+
+/*
+sha256Hex returns the hex encoded SHA256 hash of given data.
+*/
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+hmacSHA256 returns the HMAC-SHA256 of given data.
+*/
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,516 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cluster
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"devt.de/eliasdb/cluster/manager"
+	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/storage"
+)
+
+/*
+backupFreezeTimeout is the time after which a frozen member resumes accepting
+writes even if the backup was not released.
+*/
+var backupFreezeTimeout = 2 * time.Minute
+
+/*
+backupDrainTimeout is the time a backup waits for pending transfers to complete.
+*/
+var backupDrainTimeout = 30 * time.Second
+
+/*
+backupPollInterval is the interval in which the transfer state of the members
+is checked during a backup.
+*/
+var backupPollInterval = 100 * time.Millisecond
+
+/*
+ClusterBackup is the header of a snapshot of all data in a cluster which was
+taken at a consistent point in time. A backup is a gob stream which consists
+of a ClusterBackup followed by a BackupChunk for each member.
+*/
+type ClusterBackup struct {
+	Created time.Time         // Time when the backup was taken
+	Members []string          // Cluster members at the time of the backup
+	MainDB  map[string]string // Main database
+}
+
+/*
+BackupChunk is the data of a single member in a cluster backup. Records which
+were already part of a previous chunk are not repeated.
+*/
+type BackupChunk struct {
+	Member  string                    // Member of the data
+	Roots   map[string]map[int]uint64 // Root values for each storage
+	Records []*BackupRecord           // Stored data
+}
+
+/*
+BackupRecord is a single stored value of a cluster backup.
+*/
+type BackupRecord struct {
+	Store string // Storage name
+	Loc   uint64 // Cluster location
+	Ver   uint64 // Version of the data
+	Data  []byte // Stored data
+}
+
+/*
+memberBackup is the data of a single member which is collected during a backup.
+*/
+type memberBackup struct {
+	Roots   map[string]map[int]uint64 // Root values for each storage
+	Records []*BackupRecord           // Stored data
+}
+
+/*
+isClientWrite checks if a given request type modifies data.
+*/
+func isClientWrite(rt RequestType) bool {
+	return rt == RTSetMain || rt == RTSetRoot || rt == RTInsert || rt == RTUpdate ||
+		rt == RTFree
+}
+
+// Coordinator
+// ===========
+
+/*
+Backup writes a snapshot of all data in the cluster to a given writer. All
+members stop accepting writes until every pending replication has been
+processed. The data of all members is then written one member after another
+into a single artifact which can be restored with Restore. All members must
+be available.
+*/
+func (ds *DistributedStorage) Backup(w io.Writer) error {
+
+	distTable, err := ds.DistributionTable()
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprint(ds.MemberManager.Name(), "-", time.Now().UnixNano())
+	members := distTable.Members()
+	args := map[DataRequestArg]interface{}{
+		RPBackupID: id,
+	}
+
+	// Freeze all members - release them in any case once we are done
+
+	var frozen []string
+
+	defer func() {
+		for _, member := range frozen {
+			if _, err := ds.sendDataRequest(member, &DataRequest{RTBackupRelease,
+				args, nil, false}); err != nil {
+
+				manager.LogDebug(ds.Name(), "(Backup): ",
+					fmt.Sprintf("Could not release member %v: %v", member, err))
+			}
+		}
+	}()
+
+	for _, member := range members {
+		if _, err := ds.sendDataRequest(member, &DataRequest{RTBackupFreeze,
+			args, nil, false}); err != nil {
+
+			return fmt.Errorf("Could not freeze member %v for backup: %v", member, err)
+		}
+		frozen = append(frozen, member)
+	}
+
+	// Wait until all replication requests have been processed - this is the
+	// consistent point of the backup
+
+	if err := ds.drainTransfers(members, args); err != nil {
+		return err
+	}
+
+	// Write the data
+
+	mainDB, err := ds.sendDataRequest(members[0], &DataRequest{RTGetMain, nil, nil, false})
+	if err != nil {
+		return err
+	}
+
+	enc := gob.NewEncoder(w)
+
+	if err := enc.Encode(&ClusterBackup{time.Now(), members,
+		mainDB.(map[string]string)}); err != nil {
+		return err
+	}
+
+	// Only the versions of written records are kept in memory
+
+	versions := make(map[string]uint64)
+
+	for _, member := range members {
+
+		res, err := ds.sendDataRequest(member, &DataRequest{RTBackupFetch, args, nil, false})
+		if err != nil {
+			return fmt.Errorf("Could not fetch backup data from member %v: %v", member, err)
+		}
+
+		mb := res.(*memberBackup)
+		chunk := &BackupChunk{member, mb.Roots, nil}
+
+		// Replicas should be identical at this point - a newer version
+		// of an already written record is written again and replaces the
+		// previous one on restore
+
+		for _, rec := range mb.Records {
+			key := fmt.Sprint(rec.Store, "#", rec.Loc)
+
+			if ver, ok := versions[key]; !ok || ver < rec.Ver {
+				versions[key] = rec.Ver
+				chunk.Records = append(chunk.Records, rec)
+			}
+		}
+
+		if err := enc.Encode(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+drainTransfers waits until no member has pending transfer requests.
+*/
+func (ds *DistributedStorage) drainTransfers(members []string,
+	args map[DataRequestArg]interface{}) error {
+
+	deadline := time.Now().Add(backupDrainTimeout)
+
+	for {
+		var pending uint64
+
+		for _, member := range members {
+			res, err := ds.sendDataRequest(member, &DataRequest{RTBackupStatus, args, nil, false})
+			if err != nil {
+				return err
+			}
+			pending += res.(uint64)
+		}
+
+		if pending == 0 {
+			return nil
+		} else if time.Now().After(deadline) {
+			return fmt.Errorf("Backup failed: %v pending transfer requests could not be processed",
+				pending)
+		}
+
+		time.Sleep(backupPollInterval)
+	}
+}
+
+/*
+Restore restores a backup which was written by Backup. Each stored value is
+written to the members which are responsible for its cluster location.
+Existing values are overwritten. The root values of all members are merged -
+if members have different values for a root then the value of the first
+member in the backup is used. The cluster must be operational.
+*/
+func (ds *DistributedStorage) Restore(r io.Reader) error {
+	var backup ClusterBackup
+
+	distTable, err := ds.DistributionTable()
+	if err != nil {
+		return err
+	}
+
+	dec := gob.NewDecoder(r)
+
+	if err := dec.Decode(&backup); err != nil {
+		return fmt.Errorf("Could not read backup: %v", err)
+	}
+
+	// Restore the data on all responsible members - one chunk at a time
+
+	roots := make(map[string]map[int]uint64)
+
+	for {
+		var chunk BackupChunk
+
+		if err := dec.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("Could not read backup: %v", err)
+		}
+
+		for _, rec := range chunk.Records {
+			primary, replicas := distTable.LocationHome(rec.Loc)
+
+			for _, member := range append([]string{primary}, replicas...) {
+				if err := ds.restoreRecord(member, rec); err != nil {
+					return err
+				}
+			}
+		}
+
+		for store, vals := range chunk.Roots {
+			if _, ok := roots[store]; !ok {
+				roots[store] = make(map[int]uint64)
+			}
+
+			for root, val := range vals {
+				if _, ok := roots[store][root]; !ok {
+					roots[store][root] = val
+				}
+			}
+		}
+	}
+
+	// Restore main database and root values on all members
+
+	for _, member := range distTable.Members() {
+
+		if _, err := ds.sendDataRequest(member, &DataRequest{RTSetMain, nil,
+			backup.MainDB, true}); err != nil {
+			return err
+		}
+
+		for store, vals := range roots {
+			for root, val := range vals {
+				if _, err := ds.sendDataRequest(member, &DataRequest{RTSetRoot,
+					map[DataRequestArg]interface{}{
+						RPStoreName: store,
+						RPRoot:      root,
+					}, val, true}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+restoreRecord writes a single backup record to a given member.
+*/
+func (ds *DistributedStorage) restoreRecord(member string, rec *BackupRecord) error {
+	args := map[DataRequestArg]interface{}{
+		RPStoreName: rec.Store,
+		RPLoc:       rec.Loc,
+	}
+
+	exists, err := ds.sendDataRequest(member, &DataRequest{RTExists, args, nil, false})
+
+	if err == nil && exists.(bool) {
+
+		// Remove an existing value first so the restored value is stored
+		// regardless of its version
+
+		_, err = ds.sendDataRequest(member, &DataRequest{RTFree, args, nil, true})
+	}
+
+	if err == nil {
+		_, err = ds.sendDataRequest(member, &DataRequest{RTInsert, args, rec.Data, true})
+	}
+
+	return err
+}
+
+// Member
+// ======
+
+/*
+handleBackupFreezeRequest stops this member from accepting writes of clients
+and flushes the local storage.
+*/
+func (ms *memberStorage) handleBackupFreezeRequest(distTable *DistributionTable, request *DataRequest, response *interface{}) error {
+	id := request.Args[RPBackupID].(string)
+
+	ms.backupStateLock.Lock()
+
+	if ms.backupID != "" {
+		ms.backupStateLock.Unlock()
+		return fmt.Errorf("Member %v is already frozen by backup %v",
+			ms.ds.MemberManager.Name(), ms.backupID)
+	}
+
+	ms.backupID = id
+
+	ms.backupStateLock.Unlock()
+
+	// Wait for all running writes to finish and block new ones
+
+	ms.backupLock.Lock()
+
+	ms.backupStateLock.Lock()
+	ms.backupTimer = time.AfterFunc(backupFreezeTimeout, func() {
+		manager.LogDebug(ms.ds.MemberManager.Name(), "(Backup): ",
+			fmt.Sprintf("Releasing freeze of backup %v after timeout", id))
+
+		ms.releaseBackup(id)
+	})
+	ms.backupStateLock.Unlock()
+
+	return ms.gs.FlushAll()
+}
+
+/*
+handleBackupStatusRequest returns the number of pending transfer requests of
+this member and triggers the transfer worker.
+*/
+func (ms *memberStorage) handleBackupStatusRequest(distTable *DistributionTable, request *DataRequest, response *interface{}) error {
+	var pending uint64
+
+	if err := ms.checkBackup(request); err != nil {
+		return err
+	}
+
+	it := hash.NewHTreeIterator(ms.at.transfer)
+
+	for it.HasNext() {
+		if _, val := it.Next(); val != nil {
+			pending++
+		}
+	}
+
+	if it.LastError != nil {
+		return it.LastError
+	}
+
+	if pending > 0 {
+		go ms.transferWorker()
+	}
+
+	*response = pending
+
+	return nil
+}
+
+/*
+handleBackupFetchRequest returns all locally stored data and the root values
+of all local storages.
+*/
+func (ms *memberStorage) handleBackupFetchRequest(distTable *DistributionTable, request *DataRequest, response *interface{}) error {
+
+	if err := ms.checkBackup(request); err != nil {
+		return err
+	}
+
+	mb := &memberBackup{make(map[string]map[int]uint64), nil}
+
+	// Collect the root values of each storage - storages might not hold
+	// any records on this member
+
+	for _, smname := range ms.dataStorageNames() {
+		sm := ms.dataStorage(smname, false)
+		if sm == nil {
+			continue
+		}
+
+		roots := make(map[int]uint64)
+
+		for i := 1; i < storage.TotalRoots; i++ {
+			if val := sm.Root(i); val != 0 {
+				roots[i] = val
+			}
+		}
+
+		if len(roots) > 0 {
+			mb.Roots[smname] = roots
+		}
+	}
+
+	it := hash.NewHTreeIterator(ms.at.translation)
+
+	for it.HasNext() {
+		key, val := it.Next()
+
+		tr, ok := val.(*translationRec)
+		if !ok {
+			continue
+		}
+
+		smname := strings.Split(string(key[len(transPrefix):]), "#")[0]
+		cloc, _ := strconv.ParseUint(string(key[len(fmt.Sprint(transPrefix, smname, "#")):]), 10, 64)
+
+		sm := ms.dataStorage(smname, false)
+		if sm == nil {
+			continue
+		}
+
+		var data []byte
+
+		if err := sm.Fetch(tr.loc, &data); err != nil {
+			return err
+		}
+
+		mb.Records = append(mb.Records, &BackupRecord{smname, cloc, tr.ver, data})
+	}
+
+	if it.LastError != nil {
+		return it.LastError
+	}
+
+	*response = mb
+
+	return nil
+}
+
+/*
+handleBackupReleaseRequest lets this member accept writes again.
+*/
+func (ms *memberStorage) handleBackupReleaseRequest(distTable *DistributionTable, request *DataRequest, response *interface{}) error {
+
+	if err := ms.checkBackup(request); err != nil {
+		return err
+	}
+
+	ms.releaseBackup(request.Args[RPBackupID].(string))
+
+	return nil
+}
+
+/*
+checkBackup checks that this member was frozen by the backup of a given request.
+*/
+func (ms *memberStorage) checkBackup(request *DataRequest) error {
+	ms.backupStateLock.Lock()
+	defer ms.backupStateLock.Unlock()
+
+	if id := request.Args[RPBackupID].(string); ms.backupID != id || ms.backupTimer == nil {
+		return fmt.Errorf("Member %v is not frozen by backup %v",
+			ms.ds.MemberManager.Name(), id)
+	}
+
+	return nil
+}
+
+/*
+releaseBackup releases the freeze of a given backup.
+*/
+func (ms *memberStorage) releaseBackup(id string) {
+	ms.backupStateLock.Lock()
+	defer ms.backupStateLock.Unlock()
+
+	if ms.backupID != id || ms.backupTimer == nil {
+		return
+	}
+
+	ms.backupTimer.Stop()
+	ms.backupTimer = nil
+	ms.backupID = ""
+
+	ms.backupLock.Unlock()
+}
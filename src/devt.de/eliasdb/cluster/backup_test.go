@@ -0,0 +1,269 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"testing"
+	"time"
+
+	"devt.de/eliasdb/cluster/manager"
+)
+
+func TestClusterBackup(t *testing.T) {
+
+	manager.FreqHousekeeping = 5
+	defer func() { manager.FreqHousekeeping = 1000 }()
+
+	// Create a cluster with 3 members and a replication factor of 2
+
+	cluster3, _ := createCluster(3, 2)
+
+	for i, dd := range cluster3 {
+		dd.Start()
+		defer dd.Close()
+
+		if i > 0 {
+			err := dd.MemberManager.JoinCluster(cluster3[0].MemberManager.Name(), cluster3[0].MemberManager.NetAddr())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+
+	sm := cluster3[0].StorageManager("test", true)
+
+	var locs []uint64
+
+	for _, val := range []string{"test1", "test2", "test3"} {
+		loc, err := sm.Insert(val)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	sm.SetRoot(2, 42)
+
+	// Root values of storages without any records are kept
+
+	smroots := cluster3[0].StorageManager("roots", true)
+	smroots.SetRoot(3, 9)
+
+	cluster3[0].MainDB()["key"] = "value"
+	cluster3[0].FlushMain()
+
+	// Take a backup from any member
+
+	var buf bytes.Buffer
+
+	if err := cluster3[1].Backup(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The backup consists of a header and a chunk for each member
+
+	var backup ClusterBackup
+
+	dec := gob.NewDecoder(bytes.NewReader(buf.Bytes()))
+
+	if err := dec.Decode(&backup); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(backup.Members) != 3 || backup.MainDB["key"] != "value" {
+		t.Error("Unexpected backup:", backup)
+		return
+	}
+
+	var chunks, records int
+	var rootsFound bool
+
+	for {
+		var chunk BackupChunk
+
+		if err := dec.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Error(err)
+			return
+		}
+
+		chunks++
+		records += len(chunk.Records)
+		rootsFound = rootsFound || chunk.Roots["test"][2] == 42
+	}
+
+	if chunks != 3 || records != 3 || !rootsFound {
+		t.Error("Unexpected backup:", chunks, records, rootsFound)
+		return
+	}
+
+	// All members accept writes again
+
+	if err := sm.Update(locs[0], "test1changed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := sm.Free(locs[1]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm.SetRoot(2, 7)
+	smroots.SetRoot(3, 1)
+
+	cluster3[0].MainDB()["key"] = "value2"
+	cluster3[0].FlushMain()
+
+	// Restore the backup
+
+	if err := cluster3[2].Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, exp := range []string{"test1", "test2", "test3"} {
+		var res string
+
+		if err := sm.Fetch(locs[i], &res); err != nil || res != exp {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+
+	if res := sm.Root(2); res != 42 {
+		t.Error("Unexpected root:", res)
+		return
+	}
+
+	if res := smroots.Root(3); res != 9 {
+		t.Error("Unexpected root:", res)
+		return
+	}
+
+	if res := cluster3[1].MainDB()["key"]; res != "value" {
+		t.Error("Unexpected main db value:", res)
+		return
+	}
+
+	if err := cluster3[0].Restore(bytes.NewReader([]byte("bla"))); err == nil {
+		t.Error("Restoring an invalid backup should fail")
+		return
+	}
+}
+
+func TestClusterBackupFreeze(t *testing.T) {
+
+	manager.FreqHousekeeping = 5
+	defer func() { manager.FreqHousekeeping = 1000 }()
+
+	cluster2, ms := createCluster(2, 2)
+
+	for i, dd := range cluster2 {
+		dd.Start()
+		defer dd.Close()
+
+		if i > 0 {
+			err := dd.MemberManager.JoinCluster(cluster2[0].MemberManager.Name(), cluster2[0].MemberManager.NetAddr())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+
+	sm := cluster2[1].StorageManager("test", true)
+
+	args := map[DataRequestArg]interface{}{
+		RPBackupID: "123",
+	}
+
+	if _, err := cluster2[0].sendDataRequest(ms[0].ds.MemberManager.Name(),
+		&DataRequest{RTBackupFreeze, args, nil, false}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A member can only be frozen once
+
+	if _, err := cluster2[0].sendDataRequest(ms[0].ds.MemberManager.Name(),
+		&DataRequest{RTBackupFreeze, map[DataRequestArg]interface{}{
+			RPBackupID: "456",
+		}, nil, false}); err == nil ||
+		err.Error() != "Member TestClusterMember-0 is already frozen by backup 123" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := cluster2[0].sendDataRequest(ms[0].ds.MemberManager.Name(),
+		&DataRequest{RTBackupFetch, map[DataRequestArg]interface{}{
+			RPBackupID: "456",
+		}, nil, false}); err == nil ||
+		err.Error() != "Member TestClusterMember-0 is not frozen by backup 456" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Writes are blocked until the member is released
+
+	done := make(chan bool)
+
+	go func() {
+		sm.SetRoot(1, 5)
+		done <- true
+	}()
+
+	select {
+	case <-done:
+		t.Error("Write should be blocked")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if _, err := cluster2[0].sendDataRequest(ms[0].ds.MemberManager.Name(),
+		&DataRequest{RTBackupRelease, args, nil, false}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	<-done
+
+	if res := sm.Root(1); res != 5 {
+		t.Error("Unexpected root:", res)
+		return
+	}
+
+	// A forgotten freeze is released after a timeout
+
+	backupFreezeTimeout = 10 * time.Millisecond
+	defer func() { backupFreezeTimeout = 2 * time.Minute }()
+
+	if _, err := cluster2[0].sendDataRequest(ms[0].ds.MemberManager.Name(),
+		&DataRequest{RTBackupFreeze, args, nil, false}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm.SetRoot(1, 6)
+
+	if res := sm.Root(1); res != 6 {
+		t.Error("Unexpected root:", res)
+		return
+	}
+}
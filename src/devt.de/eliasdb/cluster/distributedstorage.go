@@ -459,6 +459,53 @@ func (ds *DistributedStorage) FlushAll() error {
 	return ds.localFlushHandler()
 }
 
+/*
+StorageManagerNames returns the names of all existing storage managers in
+alphabetical order. Returns nil if the names could not be retrieved.
+*/
+func (ds *DistributedStorage) StorageManagerNames() []string {
+
+	distTable, distTableErr := ds.DistributionTable()
+
+	if distTableErr != nil {
+		return nil
+	}
+
+	// Every storage manager has root values - root ids always go to member 1
+
+	member := distTable.Members()[0]
+
+	request := &DataRequest{RTGetStorageManagers, nil, nil, false}
+
+	names, err := ds.sendDataRequest(member, request)
+
+	if err != nil {
+
+		// Cycle through all replicating members if there was an error
+
+		for _, rmember := range distTable.Replicas(member) {
+			names, err = ds.sendDataRequest(rmember, request)
+
+			if err == nil {
+				break
+			}
+		}
+	}
+
+	ret, _ := names.([]string)
+
+	return ret
+}
+
+/*
+DeleteStorageManager is not supported by a cluster. The data of a storage
+manager is spread over the local storage managers and translation tables of
+all members.
+*/
+func (ds *DistributedStorage) DeleteStorageManager(smname string) error {
+	return fmt.Errorf("Cannot delete %v: Storage managers cannot be deleted in a cluster", smname)
+}
+
 /*
 StorageManager gets a storage manager with a certain name. A non-exisClusterting StorageManager
 is not created automatically if the create flag is set to false.
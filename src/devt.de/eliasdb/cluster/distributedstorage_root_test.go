@@ -11,6 +11,7 @@
 package cluster
 
 import (
+	"fmt"
 	"math"
 	"testing"
 
@@ -139,3 +140,63 @@ Roots: 0=0 1=0 2=0 3=0 4=0 5=10 6=0 7=0 8=14 9=0
 		return
 	}
 }
+
+func TestStorageManagerNames(t *testing.T) {
+
+	// Setup a cluster
+
+	manager.FreqHousekeeping = 5
+	defer func() { manager.FreqHousekeeping = 1000 }()
+
+	// Create a cluster with 3 members and a replication factor of 2
+
+	cluster3, ms := createCluster(3, 2)
+
+	for i, dd := range cluster3 {
+		dd.Start()
+		defer dd.Close()
+
+		if i > 0 {
+			err := dd.MemberManager.JoinCluster(cluster3[0].MemberManager.Name(), cluster3[0].MemberManager.NetAddr())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+
+	if res := fmt.Sprint(cluster3[1].StorageManagerNames()); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	cluster3[1].StorageManager("test2", true).SetRoot(1, 5)
+	cluster3[2].StorageManager("test1", true).SetRoot(1, 6)
+
+	for _, m := range ms {
+		m.transferWorker()
+	}
+
+	if res := fmt.Sprint(cluster3[2].StorageManagerNames()); res != "[test1 test2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Names are retrieved from a replicating member if member 1 fails
+
+	manager.MemberErrors = make(map[string]error)
+	defer func() { manager.MemberErrors = nil }()
+
+	manager.MemberErrors[cluster3[0].MemberManager.Name()] = &testNetError{}
+
+	if res := fmt.Sprint(cluster3[2].StorageManagerNames()); res != "[test1 test2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := cluster3[2].DeleteStorageManager("test1"); err == nil || err.Error() !=
+		"Cannot delete test1: Storage managers cannot be deleted in a cluster" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
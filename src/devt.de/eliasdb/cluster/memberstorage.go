@@ -17,6 +17,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"devt.de/common/sortutil"
 	"devt.de/eliasdb/cluster/manager"
@@ -50,6 +51,11 @@ type memberStorage struct {
 	rebalanceLock    *sync.Mutex // Lock for the rebalance task
 	rebalanceRunning bool        // Flag to indicate that the rebalance task is running
 	rebalanceCounter int
+
+	backupLock      *sync.RWMutex // Lock which blocks client writes during a backup
+	backupStateLock *sync.Mutex   // Lock for the backup state
+	backupID        string        // Id of the backup which froze this member
+	backupTimer     *time.Timer   // Timer which releases a forgotten freeze
 }
 
 /*
@@ -64,7 +70,8 @@ func newMemberStorage(ds *DistributedStorage, gs graphstorage.Storage) (*memberS
 		return nil, err
 	}
 
-	return &memberStorage{ds, gs, at, &sync.Mutex{}, false, &sync.Mutex{}, false, 0}, nil
+	return &memberStorage{ds, gs, at, &sync.Mutex{}, false, &sync.Mutex{}, false, 0,
+		&sync.RWMutex{}, &sync.Mutex{}, "", nil}, nil
 }
 
 /*
@@ -84,6 +91,13 @@ func (ms *memberStorage) handleDataRequest(request interface{}, response *interf
 
 	dr := request.(*DataRequest)
 
+	// Writes of clients are blocked while a backup is taken
+
+	if !dr.Transfer && isClientWrite(dr.RequestType) {
+		ms.backupLock.RLock()
+		defer ms.backupLock.RUnlock()
+	}
+
 	switch dr.RequestType {
 	case RTGetMain:
 		*response = ms.gs.MainDB()
@@ -91,6 +105,9 @@ func (ms *memberStorage) handleDataRequest(request interface{}, response *interf
 	case RTSetMain:
 		err = ms.handleSetMainRequest(distTable, dr, response)
 
+	case RTGetStorageManagers:
+		*response = ms.dataStorageNames()
+
 	case RTSetRoot:
 		err = ms.handleSetRootRequest(distTable, dr, response)
 
@@ -115,6 +132,18 @@ func (ms *memberStorage) handleDataRequest(request interface{}, response *interf
 	case RTRebalance:
 		err = ms.handleRebalanceRequest(distTable, dr, response)
 
+	case RTBackupFreeze:
+		err = ms.handleBackupFreezeRequest(distTable, dr, response)
+
+	case RTBackupStatus:
+		err = ms.handleBackupStatusRequest(distTable, dr, response)
+
+	case RTBackupFetch:
+		err = ms.handleBackupFetchRequest(distTable, dr, response)
+
+	case RTBackupRelease:
+		err = ms.handleBackupReleaseRequest(distTable, dr, response)
+
 	default:
 		err = fmt.Errorf("Unknown request type")
 	}
@@ -644,6 +673,22 @@ func (ms *memberStorage) dataStorage(dsname string, create bool) storage.Manager
 	return ms.gs.StorageManager(LocalStoragePrefix+dsname, create)
 }
 
+/*
+dataStorageNames returns the names of all local storage managers which hold
+cluster data.
+*/
+func (ms *memberStorage) dataStorageNames() []string {
+	var ret []string
+
+	for _, smname := range ms.gs.StorageManagerNames() {
+		if strings.HasPrefix(smname, LocalStoragePrefix) {
+			ret = append(ret, smname[len(LocalStoragePrefix):])
+		}
+	}
+
+	return ret
+}
+
 /*
 dump dumps the contents of a particular member storage manager as escaped strings.
 (Works only for MemoryStorageManagers.)
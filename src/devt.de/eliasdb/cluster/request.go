@@ -18,6 +18,7 @@ func init() {
 
 	gob.Register(&DataRequest{})
 	gob.Register(make(map[string]string))
+	gob.Register(&memberBackup{})
 }
 
 /*
@@ -35,6 +36,10 @@ const (
 	RTGetMain RequestType = "GetMain"
 	RTSetMain             = "SetMain"
 
+	// Storage managers
+
+	RTGetStorageManagers = "GetStorageManagers"
+
 	// Roots
 
 	RTGetRoot = "GetRoot"
@@ -63,6 +68,13 @@ const (
 	// Rebalance data
 
 	RTRebalance = "Rebalance"
+
+	// Cluster backup
+
+	RTBackupFreeze  = "BackupFreeze"
+	RTBackupStatus  = "BackupStatus"
+	RTBackupFetch   = "BackupFetch"
+	RTBackupRelease = "BackupRelease"
 )
 
 /*
@@ -79,6 +91,7 @@ const (
 	RPVer                      = "Ver"       // Version of data
 	RPRoot                     = "Root"      // Root id
 	RPSrc                      = "Src"       // Request source member
+	RPBackupID                 = "BackupID"  // Id of a running backup
 )
 
 /*
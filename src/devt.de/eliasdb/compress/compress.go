@@ -0,0 +1,324 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package compress contains a registry of compression codecs.
+
+All points which compress data select their codec by name from this registry.
+Compressed streams start with a small header which contains the identifier of
+the used codec so a reader can always find the matching decompressor - even
+if the default codec changes later on. Streams without a header are read as
+uncompressed data.
+
+The codecs gzip, flate and zlib of the Go standard library are always
+available. Other codecs (e.g. zstd) can be plugged in by implementing the
+Codec interface:
+
+	compress.RegisterCodec(myZstdCodec)
+
+	w, err := compress.NewWriter(out, "zstd", 19)
+	...
+	err = w.Close()
+
+	r, err := compress.NewReader(in)
+*/
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+/*
+DefaultLevel selects the default compression level of a codec
+*/
+const DefaultLevel = -1
+
+/*
+Identifiers of the built-in codecs. Identifiers are stored in stream headers
+and must never change once data was written with them.
+*/
+const (
+	IDNone  = 0
+	IDGzip  = 1
+	IDFlate = 2
+	IDZlib  = 3
+	IDZstd  = 4 // Reserved for a zstd codec
+)
+
+/*
+Header is the magic number which starts a compressed stream
+*/
+var Header = []byte{0x66, 0x43}
+
+/*
+HeaderSize is the size of the stream header (magic number and codec id)
+*/
+const HeaderSize = 4
+
+/*
+Common compression related errors
+*/
+var (
+	ErrCodecExists = errors.New("Codec already registered")
+	ErrInvalidName = errors.New("Codec name must not be empty")
+)
+
+/*
+Codec is a compression codec.
+*/
+type Codec interface {
+
+	/*
+		Name returns the unique name of the codec.
+	*/
+	Name() string
+
+	/*
+		ID returns the unique identifier of the codec which is stored in
+		stream headers.
+	*/
+	ID() uint16
+
+	/*
+		NewWriter returns a writer which compresses data with a given level
+		(DefaultLevel selects the default level of the codec).
+	*/
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+
+	/*
+		NewReader returns a reader which decompresses data.
+	*/
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+/*
+codecs holds all registered codecs by name
+*/
+var codecs = make(map[string]Codec)
+
+/*
+codecIDs holds all registered codecs by id
+*/
+var codecIDs = make(map[uint16]Codec)
+
+/*
+codecsLock protects the codec maps
+*/
+var codecsLock = &sync.RWMutex{}
+
+/*
+RegisterCodec registers a new codec. Names and identifiers of codecs must be
+unique.
+*/
+func RegisterCodec(c Codec) error {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+
+	if c.Name() == "" {
+		return ErrInvalidName
+	} else if _, ok := codecs[c.Name()]; ok {
+		return fmt.Errorf("%v: %v", ErrCodecExists, c.Name())
+	} else if _, ok := codecIDs[c.ID()]; ok {
+		return fmt.Errorf("%v: %v", ErrCodecExists, c.ID())
+	}
+
+	codecs[c.Name()] = c
+	codecIDs[c.ID()] = c
+
+	return nil
+}
+
+/*
+GetCodec returns a registered codec by name.
+*/
+func GetCodec(name string) (Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	c, ok := codecs[name]
+
+	return c, ok
+}
+
+/*
+GetCodecByID returns a registered codec by its identifier.
+*/
+func GetCodecByID(id uint16) (Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	c, ok := codecIDs[id]
+
+	return c, ok
+}
+
+/*
+Codecs returns the names of all registered codecs.
+*/
+func Codecs() []string {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	ret := make([]string, 0, len(codecs))
+
+	for name := range codecs {
+		ret = append(ret, name)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+NewWriter returns a writer which compresses data with a given codec and level.
+The stream header is written before any data.
+*/
+func NewWriter(w io.Writer, codecName string, level int) (io.WriteCloser, error) {
+	c, ok := GetCodec(codecName)
+	if !ok {
+		return nil, fmt.Errorf("Unknown compression codec: %v", codecName)
+	}
+
+	header := make([]byte, HeaderSize)
+	copy(header, Header)
+	binary.LittleEndian.PutUint16(header[len(Header):], c.ID())
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return c.NewWriter(w, level)
+}
+
+/*
+NewReader returns a reader which decompresses data. The codec is selected
+through the stream header. Data without a stream header is returned as is.
+*/
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(HeaderSize)
+
+	if err != nil || !bytes.Equal(header[:len(Header)], Header) {
+		return ioutil.NopCloser(br), nil
+	}
+
+	id := binary.LittleEndian.Uint16(header[len(Header):])
+
+	c, ok := GetCodecByID(id)
+	if !ok {
+		return nil, fmt.Errorf("Unknown compression codec id: %v", id)
+	}
+
+	br.Discard(HeaderSize)
+
+	return c.NewReader(br)
+}
+
+/*
+funcCodec is a codec which is defined by functions.
+*/
+type funcCodec struct {
+	name      string                                               // Name of the codec
+	id        uint16                                               // Identifier of the codec
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error) // Writer constructor
+	newReader func(r io.Reader) (io.ReadCloser, error)             // Reader constructor
+}
+
+/*
+Name returns the unique name of the codec.
+*/
+func (fc *funcCodec) Name() string {
+	return fc.name
+}
+
+/*
+ID returns the unique identifier of the codec.
+*/
+func (fc *funcCodec) ID() uint16 {
+	return fc.id
+}
+
+/*
+NewWriter returns a writer which compresses data with a given level.
+*/
+func (fc *funcCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return fc.newWriter(w, level)
+}
+
+/*
+NewReader returns a reader which decompresses data.
+*/
+func (fc *funcCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return fc.newReader(r)
+}
+
+/*
+nopWriteCloser is a WriteCloser which does nothing on close.
+*/
+type nopWriteCloser struct {
+	io.Writer
+}
+
+/*
+Close does nothing.
+*/
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+/*
+Register the built-in codecs
+*/
+func init() {
+	RegisterCodec(&funcCodec{"none", IDNone,
+		func(w io.Writer, level int) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(r), nil
+		}})
+
+	RegisterCodec(&funcCodec{"gzip", IDGzip,
+		func(w io.Writer, level int) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		}})
+
+	RegisterCodec(&funcCodec{"flate", IDFlate,
+		func(w io.Writer, level int) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		}})
+
+	RegisterCodec(&funcCodec{"zlib", IDZlib,
+		func(w io.Writer, level int) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, level)
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		}})
+}
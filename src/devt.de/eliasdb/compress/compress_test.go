@@ -0,0 +1,198 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package compress
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	data := []byte(strings.Repeat("EliasDB compresses data. ", 100))
+
+	if res := fmt.Sprint(Codecs()); res != "[flate gzip none zlib]" {
+		t.Error("Unexpected codecs:", res)
+		return
+	}
+
+	for _, name := range Codecs() {
+		for _, level := range []int{DefaultLevel, 1, 9} {
+			var buf bytes.Buffer
+
+			w, err := NewWriter(&buf, name, level)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			w.Write(data)
+
+			if err := w.Close(); err != nil {
+				t.Error(err)
+				return
+			}
+
+			if name != "none" && buf.Len() >= len(data) {
+				t.Error("Data was not compressed:", name, buf.Len())
+				return
+			}
+
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if res, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(res, data) {
+				t.Error("Unexpected result:", name, level, string(res), err)
+				return
+			}
+		}
+	}
+
+	// Data without header is read as is
+
+	for _, in := range []string{"", "a", "abcdef"} {
+		r, err := NewReader(bytes.NewBufferString(in))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if res, err := ioutil.ReadAll(r); err != nil || string(res) != in {
+			t.Error("Unexpected result:", string(res), err)
+			return
+		}
+	}
+
+	// Test error cases
+
+	if _, err := NewWriter(&bytes.Buffer{}, "foo", DefaultLevel); err == nil ||
+		err.Error() != "Unknown compression codec: foo" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := NewWriter(&bytes.Buffer{}, "gzip", 42); err == nil {
+		t.Error("Invalid level should fail")
+		return
+	}
+
+	if _, err := NewReader(bytes.NewBuffer([]byte{0x66, 0x43, 0x99, 0x00, 0x01})); err == nil ||
+		err.Error() != "Unknown compression codec id: 153" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+/*
+testCodec is a custom codec which inverts all bytes.
+*/
+type testCodec struct {
+	name string
+	id   uint16
+}
+
+func (tc *testCodec) Name() string {
+	return tc.name
+}
+
+func (tc *testCodec) ID() uint16 {
+	return tc.id
+}
+
+func (tc *testCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return &invertWriter{w}, nil
+}
+
+func (tc *testCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(&invertReader{r}), nil
+}
+
+type invertWriter struct {
+	w io.Writer
+}
+
+func (iw *invertWriter) Write(p []byte) (int, error) {
+	out := make([]byte, len(p))
+	for i, b := range p {
+		out[i] = ^b
+	}
+	return iw.w.Write(out)
+}
+
+func (iw *invertWriter) Close() error {
+	return nil
+}
+
+type invertReader struct {
+	r io.Reader
+}
+
+func (ir *invertReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] = ^p[i]
+	}
+	return n, err
+}
+
+func TestRegisterCodec(t *testing.T) {
+
+	if err := RegisterCodec(&testCodec{"", 100}); err != ErrInvalidName {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := RegisterCodec(&testCodec{"gzip", 100}); err == nil ||
+		err.Error() != "Codec already registered: gzip" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := RegisterCodec(&testCodec{"invert", IDGzip}); err == nil ||
+		err.Error() != "Codec already registered: 1" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := RegisterCodec(&testCodec{"invert", 100}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if c, ok := GetCodecByID(100); !ok || c.Name() != "invert" {
+		t.Error("Unexpected result:", c, ok)
+		return
+	}
+
+	var buf bytes.Buffer
+
+	w, _ := NewWriter(&buf, "invert", DefaultLevel)
+	w.Write([]byte("test"))
+	w.Close()
+
+	if res := fmt.Sprintf("%x", buf.Bytes()); res != "66436400"+"8b9a8c8b" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	r, _ := NewReader(&buf)
+
+	if res, err := ioutil.ReadAll(r); err != nil || string(res) != "test" {
+		t.Error("Unexpected result:", string(res), err)
+		return
+	}
+}
@@ -57,10 +57,16 @@ import (
 	"devt.de/common/timeutil"
 	"devt.de/eliasdb/api"
 	"devt.de/eliasdb/api/v1"
+	"devt.de/eliasdb/cloudstore"
 	"devt.de/eliasdb/cluster"
 	"devt.de/eliasdb/cluster/manager"
+	"devt.de/eliasdb/federation"
 	"devt.de/eliasdb/graph"
 	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/memlimit"
+	"devt.de/eliasdb/storage"
+	"devt.de/eliasdb/storage/file"
 	"devt.de/eliasdb/version"
 )
 
@@ -95,6 +101,30 @@ const (
 	ClusterStateInfoFile     = "ClusterStateInfoFile"
 	ClusterConfigFile        = "ClusterConfigFile"
 	ClusterLogHistory        = "ClusterLogHistory"
+	NodeCacheSize            = "NodeCacheSize"
+	CloudTargets             = "CloudTargets"
+	ScrubIntervalSeconds     = "ScrubIntervalSeconds"
+	SoftMemoryLimitMB        = "SoftMemoryLimitMB"
+	StorageCacheMaxMB        = "StorageCacheMaxMB"
+	StorageCachePolicy       = "StorageCachePolicy"
+	FederationRemotes        = "FederationRemotes"
+	StorageCacheProfileSize  = "StorageCacheProfileSize"
+	StorageCacheWarmUp       = "StorageCacheWarmUp"
+	StorageSharedCacheSize   = "StorageSharedCacheSize"
+	NodeCacheMissTTLSeconds  = "NodeCacheMissTTLSeconds"
+	StorageCacheWriteBack    = "StorageCacheWriteBack"
+	StorageReadAheadRecords  = "StorageReadAheadRecords"
+	EnableLockProfiling      = "EnableLockProfiling"
+	StorageCacheQuotas       = "StorageCacheQuotas"
+	JSONNumberFormat         = "JSONNumberFormat"
+	JSONFloatPrecision       = "JSONFloatPrecision"
+	DefinitionsFile          = "DefinitionsFile"
+	DefinitionsPrune         = "DefinitionsPrune"
+	JournalFile              = "JournalFile"
+	StorageQuotaMB           = "StorageQuotaMB"
+	StorageCacheMinObjects   = "StorageCacheMinObjects"
+	StorageCacheMaxObjects   = "StorageCacheMaxObjects"
+	StorageFlushParallelism  = "StorageFlushParallelism"
 )
 
 /*
@@ -120,6 +150,30 @@ var DefaultConfig = map[string]interface{}{
 	ClusterStateInfoFile:     "cluster.stateinfo",
 	ClusterConfigFile:        "cluster.config.json",
 	ClusterLogHistory:        100.0,
+	NodeCacheSize:            0.0,
+	CloudTargets:             map[string]interface{}{},
+	ScrubIntervalSeconds:     0.0,
+	SoftMemoryLimitMB:        0.0,
+	StorageCacheMaxMB:        0.0,
+	StorageCachePolicy:       "lru",
+	FederationRemotes:        map[string]interface{}{},
+	StorageCacheProfileSize:  0.0,
+	StorageCacheWarmUp:       false,
+	StorageSharedCacheSize:   0.0,
+	NodeCacheMissTTLSeconds:  0.0,
+	StorageCacheWriteBack:    0.0,
+	StorageReadAheadRecords:  0.0,
+	EnableLockProfiling:      false,
+	StorageCacheQuotas:       map[string]interface{}{},
+	JSONNumberFormat:         "number",
+	JSONFloatPrecision:       -1.0,
+	DefinitionsFile:          "",
+	DefinitionsPrune:         false,
+	JournalFile:              "",
+	StorageQuotaMB:           0.0,
+	StorageCacheMinObjects:   0.0,
+	StorageCacheMaxObjects:   0.0,
+	StorageFlushParallelism:  0.0,
 }
 
 /*
@@ -189,11 +243,146 @@ func main() {
 
 		ensurePath(loc)
 
+		// Report the progress if transaction logs need to be recovered
+
+		file.LogRecovery = func(v ...interface{}) {
+			print(v...)
+		}
+
+		// Report storage files which cannot be opened or versioned
+
+		graphstorage.LogStorage = func(v ...interface{}) {
+			print(v...)
+		}
+
+		storage.LogVersions = func(v ...interface{}) {
+			print(v...)
+		}
+
+		// Check free slot information in the background if requested
+
+		if interval, _ := Config[ScrubIntervalSeconds].(float64); interval > 0 {
+			print(fmt.Sprintf("Scrubbing free slot information every %v seconds", interval))
+
+			graphstorage.ScrubInterval = time.Duration(interval) * time.Second
+
+			storage.LogScrub = func(v ...interface{}) {
+				print(v...)
+			}
+		}
+
+		// Limit the size of the object caches if requested
+
+		if limit, _ := Config[StorageCacheMaxMB].(float64); limit > 0 {
+			print(fmt.Sprintf("Limiting object caches to %vMB per storage file", limit))
+
+			graphstorage.CacheMaxBytes = uint64(limit * 1024 * 1024)
+		}
+
+		// Adapt the size of the object caches if requested
+
+		if max, _ := Config[StorageCacheMaxObjects].(float64); max > 0 {
+			min, _ := Config[StorageCacheMinObjects].(float64)
+
+			if min > max {
+				fatal("Invalid object cache bounds: ", min, " - ", max)
+				return
+			}
+
+			print(fmt.Sprintf("Adapting object caches between %v and %v objects per storage file", min, max))
+
+			graphstorage.CacheMinObjects = int(min)
+			graphstorage.CacheMaxObjects = int(max)
+		}
+
+		// Limit the disk usage of the datastore if requested
+
+		if quota, _ := Config[StorageQuotaMB].(float64); quota > 0 {
+			print(fmt.Sprintf("Limiting disk usage of the datastore to %vMB", quota))
+
+			graphstorage.DiskQuota = uint64(quota * 1024 * 1024)
+		}
+
+		// Select the eviction policy of the object caches
+
+		if policy := config(StorageCachePolicy); policy != "lru" {
+			if _, ok := storage.CachePolicies[policy]; !ok {
+				fatal("Unknown storage cache policy:", policy)
+				return
+			}
+
+			print(fmt.Sprintf("Using %v policy for object caches", policy))
+
+			graphstorage.CachePolicy = policy
+		}
+
+		// Record the most frequently accessed records of the object caches
+
+		if size, _ := Config[StorageCacheProfileSize].(float64); size > 0 {
+			graphstorage.CacheProfileSize = int(size)
+		}
+
+		// Share one object cache between all storage files
+
+		if size, _ := Config[StorageSharedCacheSize].(float64); size > 0 {
+			print(fmt.Sprintf("Using a shared object cache for %v objects", size))
+
+			graphstorage.SharedCacheSize = int(size)
+		}
+
+		// Buffer updates in the object caches
+
+		if size, _ := Config[StorageCacheWriteBack].(float64); size > 0 {
+			print(fmt.Sprintf("Buffering up to %v updates in the object cache of each storage file", size))
+
+			graphstorage.CacheWriteBack = int(size)
+		}
+
+		// Limit the number of storage files which are written concurrently
+
+		if n, _ := Config[StorageFlushParallelism].(float64); n > 0 {
+			storage.FlushParallelism = int(n)
+		}
+
+		// Read ahead during sequential scans of the storage files
+
+		if records, _ := Config[StorageReadAheadRecords].(float64); records > 0 {
+			graphstorage.ReadAheadRecords = int(records)
+		}
+
+		// Give partitions their own object caches
+
+		quotas, _ := Config[StorageCacheQuotas].(map[string]interface{})
+
+		for part, quota := range quotas {
+			size, ok := quota.(float64)
+			if !ok || size < 1 {
+				fatal("Invalid cache quota for partition ", part, ": ", quota)
+				return
+			}
+
+			print(fmt.Sprintf("Using an object cache for %v objects for partition %v", size, part))
+
+			graphstorage.PartitionCacheQuotas[part] = int(size)
+		}
+
 		gs, err = graphstorage.NewDiskGraphStorage(loc, Config[EnableReadOnly].(bool))
 		if err != nil {
 			fatal(err)
 			return
 		}
+
+		// Preload the recorded records into the object caches
+
+		if warmUp, _ := Config[StorageCacheWarmUp].(bool); warmUp {
+			n, err := gs.(*graphstorage.DiskGraphStorage).WarmUpCaches()
+			if err != nil {
+				fatal("Failed to warm up object caches:", err)
+				return
+			}
+
+			print(fmt.Sprintf("Preloaded %v records into object caches", n))
+		}
 	}
 
 	// Check if clustering is enabled
@@ -256,6 +445,38 @@ func main() {
 	api.GS = gs
 	api.GM = graph.NewGraphManager(gs)
 
+	if size := int(Config[NodeCacheSize].(float64)); size > 0 {
+		print(fmt.Sprintf("Enabling node cache for %v nodes", size))
+		api.GM.SetNodeCacheSize(size)
+
+		if ttl, _ := Config[NodeCacheMissTTLSeconds].(float64); ttl > 0 {
+			api.GM.SetNodeCacheMissTTL(time.Duration(ttl * float64(time.Second)))
+		}
+	}
+
+	// Record the time which is spent waiting for locks
+
+	if enable, _ := Config[EnableLockProfiling].(bool); enable {
+		print("Enabling lock contention profiling")
+
+		lockprof.Enable(true)
+	}
+
+	// Shed load and shrink caches if the memory usage gets too high
+
+	if limit, _ := Config[SoftMemoryLimitMB].(float64); limit > 0 {
+		print(fmt.Sprintf("Enabling soft memory limit of %v MB", limit))
+
+		memlimit.LogLimit = func(v ...interface{}) {
+			print(v...)
+		}
+
+		memlimit.SetLimit(uint64(limit * 1024 * 1024))
+		memlimit.StartMonitor(time.Second)
+
+		defer memlimit.StopMonitor()
+	}
+
 	defer func() {
 
 		print("Closing datastore")
@@ -268,6 +489,39 @@ func main() {
 		os.RemoveAll(basepath + config(LockFile))
 	}()
 
+	// Record all committed changes in a journal
+
+	if journalFile := config(JournalFile); journalFile != "" {
+		print("Recording changes in journal: ", journalFile)
+
+		journal, err := graph.OpenJournal(basepath + journalFile)
+		if err != nil {
+			fatal("Could not open journal: ", err)
+			return
+		}
+
+		api.GM.SetJournal(journal)
+
+		defer journal.Close()
+	}
+
+	// Apply the definitions document
+
+	if defsFile := config(DefinitionsFile); defsFile != "" {
+		print("Applying definitions from: ", defsFile)
+
+		prune, _ := Config[DefinitionsPrune].(bool)
+
+		changes, err := applyDefinitionsFile(api.GM, basepath+defsFile, prune)
+		if err != nil {
+			fatal("Could not apply definitions: ", err)
+			return
+		}
+
+		print(fmt.Sprintf("Definitions applied: %v created, %v updated, %v removed, %v unchanged",
+			len(changes.Created), len(changes.Updated), len(changes.Removed), len(changes.Unchanged)))
+	}
+
 	// Handle command line
 
 	if !handleCommandLine(api.GM) {
@@ -279,6 +533,47 @@ func main() {
 	api.APIHost = config(HTTPSHost) + ":" + config(HTTPSPort)
 	v1.ResultCacheMaxSize, _ = strconv.ParseUint(config(ResultCacheMaxSize), 10, 0)
 	v1.ResultCacheMaxAge, _ = strconv.ParseInt(config(ResultCacheMaxAgeSeconds), 10, 0)
+	v1.NumberFormat = config(JSONNumberFormat)
+
+	if precision, ok := Config[JSONFloatPrecision].(float64); ok {
+		v1.FloatPrecision = int(precision)
+	}
+
+	// Register cloud storage targets for exports and query results
+
+	targets, _ := Config[CloudTargets].(map[string]interface{})
+
+	for name, tconfig := range targets {
+		tmap, _ := tconfig.(map[string]interface{})
+
+		target, err := cloudstore.NewTargetFromConfig(tmap)
+		if err != nil {
+			fatal("Invalid cloud storage target ", name, ": ", err)
+			return
+		}
+
+		print("Registering cloud storage target: ", name)
+
+		cloudstore.RegisterTarget(name, target)
+	}
+
+	// Register remote instances for federated queries
+
+	remotes, _ := Config[FederationRemotes].(map[string]interface{})
+
+	for name, rconfig := range remotes {
+		rmap, _ := rconfig.(map[string]interface{})
+
+		remote, err := federation.NewRemoteFromConfig(rmap)
+		if err != nil {
+			fatal("Invalid federation remote ", name, ": ", err)
+			return
+		}
+
+		print("Registering federation remote: ", name)
+
+		federation.RegisterRemote(name, remote)
+	}
 
 	// Check if HTTPS key and certificate are in place
 
@@ -216,6 +216,26 @@ Nothing to dump from a memory only datastore
 		return
 	}
 
+	// Compatibility exports drop unexportable attributes with a warning
+
+	printLog = []string{}
+
+	if err = handleJSONCompatExport(gm, "main", "test_export.json"); err != nil {
+		t.Error(err)
+		return
+	}
+	xout, err = ioutil.ReadFile("test_export.json")
+	if strings.Contains(string(xout), `"test"`) || len(printLog) != 1 ||
+		printLog[0] != "Warning: Node 123 (bla) attribute test: dropped func() data.Node value" {
+		t.Error("Unexpected output:", string(xout), printLog)
+		return
+	}
+
+	if err = handleJSONCompatExport(gm, "main", invalidFileName); err == nil {
+		t.Error("Invalid filename should cause an error")
+		return
+	}
+
 	// Error when reading a node
 
 	msm := gs.StorageManager("main"+"bla"+graph.StorageSuffixNodes, false).(*storage.MemoryStorageManager)
@@ -368,6 +388,42 @@ Nothing to dump from a memory only datastore
 	}
 }
 
+func TestApplyDefinitionsFile(t *testing.T) {
+	gs := graphstorage.NewMemoryGraphStorage("test")
+	gm := graph.NewGraphManager(gs)
+
+	defer os.RemoveAll("test_defs.json")
+
+	ioutil.WriteFile("test_defs.json", []byte(`{
+  "version" : 1,
+  "invariants" : [ { "name" : "hasOwner", "kind" : "Car", "spec" : ":Owner::", "min" : 1 } ]
+}`), 0660)
+
+	changes, err := applyDefinitionsFile(gm, "test_defs.json", true)
+	if err != nil || fmt.Sprint(changes) != "&{[invariant:hasOwner] [] [] []}" {
+		t.Error("Unexpected result:", changes, err)
+		return
+	}
+
+	if invs := gm.Invariants(); len(invs) != 1 || invs[0].Max != -1 {
+		t.Error("Unexpected invariants:", invs)
+		return
+	}
+
+	ioutil.WriteFile("test_defs.json", []byte(`{ "version" : 1, "invariants" : [ { "name" : "a b" } ] }`), 0660)
+
+	if _, err := applyDefinitionsFile(gm, "test_defs.json", true); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid invariant a b: Name must be alphanumeric)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := applyDefinitionsFile(gm, invalidFileName, true); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
 func TestCommandLineParameter(t *testing.T) {
 
 	// Test normal usage
@@ -376,6 +432,8 @@ func TestCommandLineParameter(t *testing.T) {
 	if out != `
 Usage of  eliasdb  [options]
   -?	Show this help message
+  -compat
+    	Dump data which can be imported by the previous major version
   -dumpdb string
     	Dump the contents of a partition to a JSON file and exit
   -import string
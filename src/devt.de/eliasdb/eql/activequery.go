@@ -0,0 +1,143 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package eql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"devt.de/eliasdb/eql/interpreter"
+	"devt.de/eliasdb/graph"
+)
+
+/*
+ActiveQuery is a query which is currently executed.
+*/
+type ActiveQuery struct {
+	ID      string    // Unique id of the query
+	Query   string    // Query text
+	Part    string    // Partition which is queried
+	Client  string    // Client which issued the query
+	Started time.Time // Time when the query was started
+
+	rows   uint64             // Number of produced rows (accessed atomically)
+	cancel context.CancelFunc // Function to cancel the query
+}
+
+/*
+Rows returns the number of rows which were produced so far.
+*/
+func (aq *ActiveQuery) Rows() uint64 {
+	return atomic.LoadUint64(&aq.rows)
+}
+
+/*
+Elapsed returns the time which has passed since the query was started.
+*/
+func (aq *ActiveQuery) Elapsed() time.Duration {
+	return time.Since(aq.Started)
+}
+
+/*
+addRow counts a produced row.
+*/
+func (aq *ActiveQuery) addRow() {
+	atomic.AddUint64(&aq.rows, 1)
+}
+
+/*
+activeQueries holds all currently executed queries.
+*/
+var activeQueries = make(map[string]*ActiveQuery)
+
+/*
+activeQueriesLock protects the map of currently executed queries.
+*/
+var activeQueriesLock = &sync.Mutex{}
+
+/*
+activeQueryCounter is used to generate unique query ids.
+*/
+var activeQueryCounter uint64
+
+/*
+ActiveQueries returns all currently executed queries ordered by their start
+time.
+*/
+func ActiveQueries() []*ActiveQuery {
+	activeQueriesLock.Lock()
+	defer activeQueriesLock.Unlock()
+
+	ret := make([]*ActiveQuery, 0, len(activeQueries))
+
+	for _, aq := range activeQueries {
+		ret = append(ret, aq)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Started.Before(ret[j].Started)
+	})
+
+	return ret
+}
+
+/*
+KillQuery cancels a currently executed query. Returns false if no query with
+the given id is executed.
+*/
+func KillQuery(id string) bool {
+	activeQueriesLock.Lock()
+	defer activeQueriesLock.Unlock()
+
+	aq, ok := activeQueries[id]
+	if ok {
+		aq.cancel()
+	}
+
+	return ok
+}
+
+/*
+RunQueryContext runs a search query against a given graph database. The query
+is listed as active query while it is executed and can be cancelled via
+KillQuery or the given context.
+*/
+func RunQueryContext(ctx context.Context, client string, name string, part string,
+	query string, gm *graph.Manager) (SearchResult, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	aq := &ActiveQuery{
+		ID:      fmt.Sprint(atomic.AddUint64(&activeQueryCounter, 1)),
+		Query:   query,
+		Part:    part,
+		Client:  client,
+		Started: time.Now(),
+		cancel:  cancel,
+	}
+
+	activeQueriesLock.Lock()
+	activeQueries[aq.ID] = aq
+	activeQueriesLock.Unlock()
+
+	defer func() {
+		activeQueriesLock.Lock()
+		delete(activeQueries, aq.ID)
+		activeQueriesLock.Unlock()
+	}()
+
+	return runQuery(ctx, aq, name, part, query, gm, interpreter.NewDefaultNodeInfo(gm))
+}
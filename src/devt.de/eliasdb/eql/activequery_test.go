@@ -0,0 +1,89 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package eql
+
+import (
+	"context"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+)
+
+func TestActiveQueries(t *testing.T) {
+	gm, _ := songGraph()
+
+	res, err := RunQueryContext(context.Background(), "testclient", "test", "main",
+		"get Author with ordering(ascending key)", gm)
+
+	if err != nil || len(res.Rows()) != 3 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if aqs := ActiveQueries(); len(aqs) != 0 {
+		t.Error("Unexpected active queries:", aqs)
+		return
+	}
+
+	if KillQuery("foo") {
+		t.Error("Unknown query should not be killed")
+		return
+	}
+
+	// Kill the query while it is running
+
+	var seen []*ActiveQuery
+	var seenRows uint64
+
+	RegisterScoreFunc("killer", func(node data.Node, relevance float64) float64 {
+		if seen == nil {
+			seen = ActiveQueries()
+
+			if len(seen) == 1 {
+				seenRows = seen[0].Rows()
+				KillQuery(seen[0].ID)
+			}
+		}
+		return 1
+	})
+	defer RegisterScoreFunc("killer", nil)
+
+	_, err = RunQueryContext(context.Background(), "testclient", "test", "main",
+		"get Song show key, @score(1, killer)", gm)
+
+	if err == nil || err.Error() != "EQL error in test: Query was cancelled (context canceled)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if len(seen) != 1 || seen[0].Client != "testclient" || seen[0].Part != "main" ||
+		seen[0].Query != "get Song show key, @score(1, killer)" || seenRows != 0 ||
+		seen[0].Elapsed() <= 0 {
+		t.Error("Unexpected active query:", seen)
+		return
+	}
+
+	if aqs := ActiveQueries(); len(aqs) != 0 {
+		t.Error("Unexpected active queries:", aqs)
+		return
+	}
+
+	// Cancel the query via its context
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err = RunQueryContext(ctx, "testclient", "test", "main", "get Song", gm); err == nil ||
+		err.Error() != "EQL error in test: Query was cancelled (context canceled)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
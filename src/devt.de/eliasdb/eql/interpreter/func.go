@@ -18,9 +18,12 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"devt.de/common/datautil"
 	"devt.de/eliasdb/eql/parser"
+	"devt.de/eliasdb/graph"
 	"devt.de/eliasdb/graph/data"
 )
 
@@ -37,7 +40,9 @@ type FuncWhere func(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
 Runtime map for where related functions
 */
 var whereFunc = map[string]FuncWhere{
-	"count": whereCount,
+	"count":    whereCount,
+	"hasLabel": whereHasLabel,
+	"tsagg":    whereTsagg,
 }
 
 /*
@@ -60,6 +65,82 @@ func whereCount(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
 	return len(nodes), err
 }
 
+/*
+whereHasLabel checks if a label is attached to a node.
+*/
+func whereHasLabel(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+	node data.Node, edge data.Edge) (interface{}, error) {
+
+	// Check parameters
+
+	if len(astNode.Children) != 2 {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			"HasLabel function requires 1 parameter: label", astNode)
+	}
+
+	label := astNode.Children[1].Token.Val
+
+	return rtp.gm.HasNodeLabel(rtp.part, node.Key(), node.Kind(), label)
+}
+
+/*
+whereTsagg aggregates the measurements of a time series in a time range.
+*/
+func whereTsagg(astNode *parser.ASTNode, rtp *eqlRuntimeProvider,
+	node data.Node, edge data.Edge) (interface{}, error) {
+
+	// Check parameters
+
+	if len(astNode.Children) != 4 {
+		return nil, rtp.newRuntimeError(ErrInvalidConstruct,
+			"Tsagg function requires 3 parameters: aggregation function, start time, end time", astNode)
+	}
+
+	fn := astNode.Children[1].Token.Val
+
+	from, to, err := parseTimeRange(astNode.Children[2].Token.Val, astNode.Children[3].Token.Val)
+	if err != nil {
+		return nil, err
+	}
+
+	return aggregateTimeSeries(rtp, node, fn, from, to)
+}
+
+/*
+parseTimeRange parses the start and end time of a time range.
+*/
+func parseTimeRange(fromVal string, toVal string) (time.Time, time.Time, error) {
+	now := time.Now()
+
+	from, err := graph.ParseTimeSeriesTime(fromVal, now)
+	if err != nil {
+		return from, from, err
+	}
+
+	to, err := graph.ParseTimeSeriesTime(toVal, now)
+
+	return from, to, err
+}
+
+/*
+aggregateTimeSeries aggregates the measurements of a time series node in a
+time range. Returns nil for nodes which are not time series nodes.
+*/
+func aggregateTimeSeries(rtp *eqlRuntimeProvider, node data.Node, fn string,
+	from time.Time, to time.Time) (interface{}, error) {
+
+	if node.Kind() != graph.TimeSeriesKind {
+		return nil, nil
+	}
+
+	ts, err := rtp.gm.TimeSeries(rtp.part, node.Key())
+	if err != nil || ts == nil {
+		return nil, err
+	}
+
+	return ts.Aggregate(fn, from, to)
+}
+
 // Show related functions
 // ======================
 
@@ -69,6 +150,8 @@ Runtime map for show related functions
 var showFunc = map[string]FuncShowInst{
 	"count":  showCountInst,
 	"objget": showObjgetInst,
+	"score":  showScoreInst,
+	"tsagg":  showTsaggInst,
 }
 
 /*
@@ -200,3 +283,189 @@ func (so *showObjget) eval(node data.Node, edge data.Edge) (interface{}, string,
 
 	return val, "n:" + node.Kind() + ":" + node.Key(), nil
 }
+
+// Show Score
+// ----------
+
+/*
+ScoreFunc calculates a ranking score for a given node. The relevance parameter
+is the index relevance of the node (number of occurrences of a searched word)
+or 0 if no index lookup was requested.
+*/
+type ScoreFunc func(node data.Node, relevance float64) float64
+
+/*
+scoreFuncs holds all registered scoring functions.
+*/
+var scoreFuncs = make(map[string]ScoreFunc)
+
+/*
+scoreFuncsLock protects the map of registered scoring functions.
+*/
+var scoreFuncsLock = &sync.RWMutex{}
+
+/*
+RegisterScoreFunc registers a scoring function which can be used in show
+clauses via @score. An existing function with the same name is replaced.
+A nil function removes the registration.
+*/
+func RegisterScoreFunc(name string, f ScoreFunc) {
+	scoreFuncsLock.Lock()
+	defer scoreFuncsLock.Unlock()
+
+	if f == nil {
+		delete(scoreFuncs, name)
+		return
+	}
+
+	scoreFuncs[name] = f
+}
+
+/*
+showScoreInst creates a new showScore object.
+*/
+func showScoreInst(astNode *parser.ASTNode, rtp *eqlRuntimeProvider) (FuncShow, string, string, error) {
+	var attr, word string
+
+	// Check parameters
+
+	if len(astNode.Children) != 3 && len(astNode.Children) != 5 {
+		return nil, "", "", errors.New("Score function requires 2 or 4 parameters: traversal step, scoring function [, index attribute, index word]")
+	}
+
+	pos := astNode.Children[1].Token.Val
+	scorer := astNode.Children[2].Token.Val
+
+	scoreFuncsLock.RLock()
+	sf, ok := scoreFuncs[scorer]
+	scoreFuncsLock.RUnlock()
+
+	if !ok {
+		return nil, "", "", errors.New("Unknown scoring function: " + scorer)
+	}
+
+	if len(astNode.Children) == 5 {
+		attr = astNode.Children[3].Token.Val
+		word = astNode.Children[4].Token.Val
+	}
+
+	return &showScore{rtp, sf, scorer, attr, word, make(map[string]map[string][]uint64)},
+		pos + ":n:score", "Score", nil
+}
+
+/*
+showScore calculates a score for a node using a registered scoring function.
+*/
+type showScore struct {
+	rtp       *eqlRuntimeProvider
+	sf        ScoreFunc
+	scorer    string
+	attr      string
+	word      string
+	relevance map[string]map[string][]uint64 // Cached index lookups per node kind
+}
+
+/*
+name returns the name of the function.
+*/
+func (ss *showScore) name() string {
+	return "score"
+}
+
+/*
+eval calculates the score of a node.
+*/
+func (ss *showScore) eval(node data.Node, edge data.Edge) (interface{}, string, error) {
+	var relevance float64
+
+	// Fetch the full node so the scoring function can access all attributes
+
+	fullNode, err := ss.rtp.gm.FetchNode(ss.rtp.part, node.Key(), node.Kind())
+	if err != nil {
+		return nil, "", err
+	} else if fullNode == nil {
+		fullNode = node
+	}
+
+	if ss.attr != "" {
+
+		// Lookup the word in the index - results are cached for each node kind
+
+		wordPos, ok := ss.relevance[node.Kind()]
+
+		if !ok {
+			iq, err := ss.rtp.gm.NodeIndexQuery(ss.rtp.part, node.Kind())
+			if err != nil {
+				return nil, "", err
+			}
+
+			if iq != nil {
+				if wordPos, err = iq.LookupWord(ss.attr, ss.word); err != nil {
+					return nil, "", err
+				}
+			}
+
+			ss.relevance[node.Kind()] = wordPos
+		}
+
+		relevance = float64(len(wordPos[node.Key()]))
+	}
+
+	return ss.sf(fullNode, relevance), "n:" + node.Kind() + ":" + node.Key(), nil
+}
+
+// Show Tsagg
+// ----------
+
+/*
+showTsaggInst creates a new showTsagg object.
+*/
+func showTsaggInst(astNode *parser.ASTNode, rtp *eqlRuntimeProvider) (FuncShow, string, string, error) {
+
+	// Check parameters
+
+	if len(astNode.Children) != 5 {
+		return nil, "", "", errors.New("Tsagg function requires 4 parameters: traversal step, aggregation function, start time, end time")
+	}
+
+	pos := astNode.Children[1].Token.Val
+	fn := astNode.Children[2].Token.Val
+
+	if _, ok := graph.TimeSeriesAggregates[fn]; !ok {
+		return nil, "", "", errors.New("Unknown aggregation function: " + fn)
+	}
+
+	from, to, err := parseTimeRange(astNode.Children[3].Token.Val, astNode.Children[4].Token.Val)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return &showTsagg{rtp, fn, from, to}, pos + ":n:key", strings.ToUpper(fn[:1]) + fn[1:], nil
+}
+
+/*
+showTsagg aggregates the measurements of a time series in a time range.
+*/
+type showTsagg struct {
+	rtp  *eqlRuntimeProvider
+	fn   string
+	from time.Time
+	to   time.Time
+}
+
+/*
+name returns the name of the function.
+*/
+func (st *showTsagg) name() string {
+	return "tsagg"
+}
+
+/*
+eval aggregates the measurements of a time series node.
+*/
+func (st *showTsagg) eval(node data.Node, edge data.Edge) (interface{}, string, error) {
+
+	res, err := aggregateTimeSeries(st.rtp, node, st.fn, st.from, st.to)
+
+	return res, "n:" + node.Kind() + ":" + node.Key(), err
+}
@@ -10,7 +10,14 @@
 
 package interpreter
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"devt.de/eliasdb/graph"
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
 
 func TestFunctions(t *testing.T) {
 	gm, _ := songGraphGroups()
@@ -104,3 +111,171 @@ Mike, 4
 		return
 	}
 }
+
+func TestScoreFunction(t *testing.T) {
+	gm, _ := songGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	RegisterScoreFunc("ranking", func(node data.Node, relevance float64) float64 {
+		return float64(node.Attr("ranking").(int)) + 100*relevance
+	})
+	defer RegisterScoreFunc("ranking", nil)
+
+	if _, err := getResult("get Song show key, @score(1, ranking) with ordering(descending score)", `
+Labels: Song Key, Score
+Format: auto, auto
+Data: 1:n:key, 1:func:score()
+MyOnlySong3, 19
+Aria4, 18
+Aria1, 8
+DeadSong2, 6
+StrangeSong1, 5
+Aria3, 4
+FightSong4, 3
+Aria2, 2
+LoveSong3, 1
+`[1:], rt, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Index relevance should be combined with the node attributes
+
+	if _, err := getResult("get Song show key, @score(1, ranking, name, lovesong3) with ordering(descending score)", `
+Labels: Song Key, Score
+Format: auto, auto
+Data: 1:n:key, 1:func:score()
+LoveSong3, 101
+MyOnlySong3, 19
+Aria4, 18
+Aria1, 8
+DeadSong2, 6
+StrangeSong1, 5
+Aria3, 4
+FightSong4, 3
+Aria2, 2
+`[1:], rt, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get Song show key, @score(1, unknown)", "", rt, true); err.Error() !=
+		"EQL error in test: Invalid construct (Unknown scoring function: unknown) (Line:1 Pos:20)" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get Song show key, @score(1)", "", rt, true); err.Error() !=
+		"EQL error in test: Invalid construct (Score function requires 2 or 4 parameters: traversal step, scoring function [, index attribute, index word]) (Line:1 Pos:20)" {
+		t.Error(err)
+		return
+	}
+}
+
+func TestTsaggFunction(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := graph.NewGraphManager(mgs)
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	start := time.Unix(1500000000, 0)
+
+	for i, name := range []string{"cpu", "mem"} {
+		ts, _ := gm.CreateTimeSeries("main", name, time.Minute)
+
+		for j := 0; j < 10; j++ {
+			ts.Append(graph.TimeSeriesPoint{Time: start.Add(time.Duration(j) * 30 * time.Second), Value: float64((i + 1) * j)})
+		}
+	}
+
+	if _, err := getResult(`get TimeSeries show key, @tsagg(1, avg, 1500000000000, "2017-07-14T02:42:00Z"), @tsagg(1, max, 0, "now")`, `
+Labels: Timeseries Key, Avg, Max
+Format: auto, auto, auto
+Data: 1:n:key, 1:func:tsagg(), 1:func:tsagg()
+cpu, 1.5, 9
+mem, 3, 18
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult(`get TimeSeries where @tsagg(sum, 0, "now") > 50 show key`, `
+Labels: Timeseries Key
+Format: auto
+Data: 1:n:key
+mem
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Test parsing and runtime errors
+
+	if _, err := getResult("get TimeSeries show key, @tsagg(1, avg)", "", rt, true); err.Error() !=
+		"EQL error in test: Invalid construct (Tsagg function requires 4 parameters: traversal step, aggregation function, start time, end time) (Line:1 Pos:26)" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get TimeSeries show key, @tsagg(1, median, 0, 1)", "", rt, true); err.Error() !=
+		"EQL error in test: Invalid construct (Unknown aggregation function: median) (Line:1 Pos:26)" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get TimeSeries where @tsagg(avg, 0) > 1", "", rt, true); err.Error() !=
+		"EQL error in test: Invalid construct (Tsagg function requires 3 parameters: aggregation function, start time, end time) (Line:1 Pos:22)" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get TimeSeries where @tsagg(avg, 0, yesterday) > 1", "", rt, true); err.Error() !=
+		"GraphError: Invalid data (Invalid time: yesterday)" {
+		t.Error(err)
+		return
+	}
+}
+
+func TestHasLabelFunction(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := graph.NewGraphManager(mgs)
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	for _, key := range []string{"a", "b", "c"} {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Author")
+		gm.StoreNode("main", node)
+	}
+
+	gm.AddNodeLabels("main", "a", "Author", "vip", "early")
+	gm.AddNodeLabels("main", "c", "Author", "vip")
+
+	if _, err := getResult(`get Author where @hasLabel("vip") show key`, `
+Labels: Author Key
+Format: auto
+Data: 1:n:key
+a
+c
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult(`get Author where @hasLabel(vip) and not @hasLabel(early) show key`, `
+Labels: Author Key
+Format: auto
+Data: 1:n:key
+c
+`[1:], rt, true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Test parsing errors
+
+	if _, err := getResult("get Author where @hasLabel()", "", rt, true); err.Error() !=
+		"EQL error in test: Invalid construct (HasLabel function requires 1 parameter: label) (Line:1 Pos:18)" {
+		t.Error(err)
+		return
+	}
+}
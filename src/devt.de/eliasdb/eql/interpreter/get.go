@@ -11,8 +11,12 @@
 package interpreter
 
 import (
+	"math"
+	"strconv"
+
 	"devt.de/eliasdb/eql/parser"
 	"devt.de/eliasdb/graph"
+	"devt.de/eliasdb/graph/data"
 )
 
 // Runtime provider for GET queries
@@ -42,8 +46,8 @@ NewGetRuntimeProvider creates a new GetRuntimeProvider object. This provider
 can interpret GET queries.
 */
 func NewGetRuntimeProvider(name string, part string, gm *graph.Manager, ni NodeInfo) *GetRuntimeProvider {
-	return &GetRuntimeProvider{&eqlRuntimeProvider{name, part, gm, ni, "", false, nil, "",
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+	return &GetRuntimeProvider{&eqlRuntimeProvider{name, part, gm, ni, "", false, nil, "", nil,
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
 }
 
 /*
@@ -82,7 +86,80 @@ func (rt *getRuntime) Validate() error {
 
 	initErr := rt.rtp.init(startKind, rt.node.Children[1:])
 
-	if rt.rtp.groupScope == "" {
+	// Attributes of start nodes which are known from the used index
+
+	indexAttrs := make(map[string]interface{})
+
+	if prefix, ok := rt.keyPrefix(); ok && rt.rtp.groupScope == "" {
+
+		// Start keys can be provided by the ordered node key index
+
+		keys, err := rt.rtp.gm.NodeKeysByPrefix(rt.rtp.part, startKind, prefix, 0)
+
+		if err != nil {
+			return err
+		} else if keys == nil {
+			return rt.rtp.newRuntimeError(ErrUnknownNodeKind, startKind, rt.node.Children[0])
+		}
+
+		nodePtr := 0
+
+		rt.rtp.nextStartKey = func() (string, error) {
+			if nodePtr < len(keys) {
+				nodePtr++
+				return keys[nodePtr-1], nil
+			}
+
+			return "", nil
+		}
+
+	} else if attr, value, ok := rt.valueLookup(startKind); ok && rt.rtp.groupScope == "" {
+
+		// Start keys can be provided by an index of the node kind
+
+		keys, err := rt.lookupValue(startKind, attr, value, indexAttrs)
+
+		if err != nil {
+			return err
+		} else if keys == nil {
+			return rt.rtp.newRuntimeError(ErrUnknownNodeKind, startKind, rt.node.Children[0])
+		}
+
+		nodePtr := 0
+
+		rt.rtp.nextStartKey = func() (string, error) {
+			if nodePtr < len(keys) {
+				nodePtr++
+				return keys[nodePtr-1], nil
+			}
+
+			return "", nil
+		}
+
+	} else if attr, min, max, ok := rt.rangeLookup(startKind); ok && rt.rtp.groupScope == "" {
+
+		// Start keys can be provided by the range index of an attribute
+
+		keys, err := rt.rtp.gm.NodeKeysByRange(rt.rtp.part, startKind, attr, min, max)
+
+		if err != nil {
+			return err
+		} else if keys == nil {
+			return rt.rtp.newRuntimeError(ErrUnknownNodeKind, startKind, rt.node.Children[0])
+		}
+
+		nodePtr := 0
+
+		rt.rtp.nextStartKey = func() (string, error) {
+			if nodePtr < len(keys) {
+				nodePtr++
+				return keys[nodePtr-1], nil
+			}
+
+			return "", nil
+		}
+
+	} else if rt.rtp.groupScope == "" {
 
 		// Start keys can be provided by a simple node key iterator
 
@@ -129,9 +206,304 @@ func (rt *getRuntime) Validate() error {
 		}
 	}
 
+	if rt.isIndexOnly(indexAttrs) {
+		rt.rtp.indexAttrs = indexAttrs
+	}
+
 	return initErr
 }
 
+/*
+isIndexOnly checks if a query can be answered without fetching nodes from
+storage. This is the case if the query has no traversals and all required
+attributes of the start nodes are provided by the used index.
+*/
+func (rt *getRuntime) isIndexOnly(indexAttrs map[string]interface{}) bool {
+
+	if rt.rtp.groupScope != "" || len(rt.rtp.traversals) > 0 || len(rt.rtp.attrsNodes) == 0 {
+		return false
+	}
+
+	for attr := range rt.rtp.attrsNodes[0] {
+		if _, ok := indexAttrs[attr]; !ok && attr != "" &&
+			attr != data.NodeKey && attr != data.NodeKind {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+keyPrefix returns the key prefix if the where clause of the query is a simple
+condition on the node key (e.g. get Song where key beginswith "Aria").
+*/
+func (rt *getRuntime) keyPrefix() (string, bool) {
+	where := rt.rtp.where
+
+	if where == nil || len(where.Children) != 1 {
+		return "", false
+	}
+
+	cond := where.Children[0]
+
+	if cond.Name != parser.NodeBEGINSWITH || len(cond.Children) != 2 {
+		return "", false
+	}
+
+	attr, ok1 := cond.Children[0].Runtime.(*valueRuntime)
+	val, ok2 := cond.Children[1].Runtime.(*valueRuntime)
+
+	if ok1 && ok2 && attr.isNodeAttrValue && attr.condVal == data.NodeKey &&
+		attr.nestedValuePath == nil && !val.isNodeAttrValue && !val.isEdgeAttrValue {
+
+		return val.condVal, true
+	}
+
+	return "", false
+}
+
+/*
+valueLookup returns attribute and value if the where clause of the query is a
+simple equality condition which can be answered by the value index (e.g.
+get Song where name = "Aria1" or get Song where published = true). The value
+is either a string or a boolean. Numeric values are excluded since they are
+compared by their numeric value. If several equality conditions are combined
+by "and" the condition on the attribute with the most distinct values (i.e.
+the fewest expected start nodes) is used according to the collected node kind
+statistics - without statistics the first condition is used. All other
+conditions are checked by the where clause.
+*/
+func (rt *getRuntime) valueLookup(kind string) (string, interface{}, bool) {
+	where := rt.rtp.where
+
+	if where == nil || len(where.Children) != 1 {
+		return "", nil, false
+	}
+
+	var attrs []string
+	var values []interface{}
+	var visit func(cond *parser.ASTNode)
+
+	visit = func(cond *parser.ASTNode) {
+
+		if cond.Name == parser.NodeAND {
+			for _, child := range cond.Children {
+				visit(child)
+			}
+			return
+		}
+
+		if attr, value, ok := valueCondition(cond); ok {
+			attrs = append(attrs, attr)
+			values = append(values, value)
+		}
+	}
+
+	visit(where.Children[0])
+
+	if len(attrs) == 0 {
+		return "", nil, false
+	}
+
+	best := 0
+
+	if len(attrs) > 1 {
+		if stats := rt.rtp.gm.CachedKindStats(rt.rtp.part, kind); stats != nil {
+			bestSel := 2.0
+
+			for i, attr := range attrs {
+				sel := 0.0
+
+				if as, ok := stats.Attrs[attr]; ok {
+					sel = as.Selectivity(stats.Count)
+				}
+
+				if sel < bestSel {
+					best, bestSel = i, sel
+				}
+			}
+		}
+	}
+
+	return attrs[best], values[best], true
+}
+
+/*
+valueCondition returns attribute and value if a given condition is an
+equality condition which can be answered by the value index (see valueLookup).
+*/
+func valueCondition(cond *parser.ASTNode) (string, interface{}, bool) {
+
+	if cond.Name != parser.NodeEQ || len(cond.Children) != 2 {
+		return "", nil, false
+	}
+
+	attr, ok1 := cond.Children[0].Runtime.(*valueRuntime)
+	val, ok2 := cond.Children[1].Runtime.(*valueRuntime)
+
+	if !ok1 || !ok2 || !attr.isNodeAttrValue || attr.condVal == data.NodeKey ||
+		attr.condVal == data.NodeKind || attr.nestedValuePath != nil {
+
+		return "", nil, false
+	}
+
+	switch cond.Children[1].Name {
+
+	case parser.NodeTRUE:
+		return attr.condVal, true, true
+
+	case parser.NodeFALSE:
+		return attr.condVal, false, true
+
+	case parser.NodeVALUE:
+		if !val.isNodeAttrValue && !val.isEdgeAttrValue {
+			if _, err := strconv.ParseFloat(val.condVal, 64); err != nil {
+				return attr.condVal, val.condVal, true
+			}
+		}
+	}
+
+	return "", nil, false
+}
+
+/*
+rangeLookup returns attribute and range of values if the where clause of the
+query compares an attribute which has a range index with numbers or
+timestamps (e.g. get Person where age > 30 or get Event where time >=
+"2016-05-17" and time < "2016-05-18"). Conditions which are combined by "and"
+narrow down the range. The range includes its bounds since the found nodes are
+checked by the where clause.
+*/
+func (rt *getRuntime) rangeLookup(kind string) (string, float64, float64, bool) {
+	where := rt.rtp.where
+
+	if where == nil || len(where.Children) != 1 {
+		return "", 0, 0, false
+	}
+
+	attrs := rt.rtp.gm.RangeIndexes(kind)
+	if len(attrs) == 0 {
+		return "", 0, 0, false
+	}
+
+	var attr string
+	var visit func(cond *parser.ASTNode)
+
+	min, max := math.Inf(-1), math.Inf(1)
+
+	visit = func(cond *parser.ASTNode) {
+
+		if cond.Name == parser.NodeAND {
+			for _, child := range cond.Children {
+				visit(child)
+			}
+			return
+		}
+
+		op := cond.Name
+
+		if (op != parser.NodeGT && op != parser.NodeGEQ && op != parser.NodeLT &&
+			op != parser.NodeLEQ) || len(cond.Children) != 2 {
+			return
+		}
+
+		// The attribute can be on either side of the comparison
+
+		attrNode, valNode := cond.Children[0], cond.Children[1]
+
+		if val, ok := attrNode.Runtime.(*valueRuntime); ok && !val.isNodeAttrValue {
+			attrNode, valNode = valNode, attrNode
+			op = map[string]string{parser.NodeGT: parser.NodeLT, parser.NodeGEQ: parser.NodeLEQ,
+				parser.NodeLT: parser.NodeGT, parser.NodeLEQ: parser.NodeGEQ}[op]
+		}
+
+		a, ok1 := attrNode.Runtime.(*valueRuntime)
+		v, ok2 := valNode.Runtime.(*valueRuntime)
+
+		if !ok1 || !ok2 || !a.isNodeAttrValue || a.nestedValuePath != nil ||
+			!containsString(attrs, a.condVal) || (attr != "" && attr != a.condVal) ||
+			valNode.Name != parser.NodeVALUE || v.isNodeAttrValue || v.isEdgeAttrValue {
+			return
+		}
+
+		// Bounds are numbers or timestamps
+
+		num, err := strconv.ParseFloat(v.condVal, 64)
+
+		if err != nil {
+			t, ok := data.ValueTime(v.condVal)
+			if !ok {
+				return
+			}
+
+			num, _ = data.ValueNumber(t)
+		}
+
+		attr = a.condVal
+
+		if op == parser.NodeGT || op == parser.NodeGEQ {
+			min = math.Max(min, num)
+		} else {
+			max = math.Min(max, num)
+		}
+	}
+
+	visit(where.Children[0])
+
+	return attr, min, max, attr != ""
+}
+
+/*
+lookupValue looks up the keys of all start nodes which have a given attribute
+value. The value index of the attribute is used if it exists - otherwise the
+full text index of the node kind is used. Attributes whose value is provided by
+the used index are added to a given map. Returns nil if the node kind does not
+exist in the partition.
+*/
+func (rt *getRuntime) lookupValue(kind string, attr string, value interface{},
+	indexAttrs map[string]interface{}) ([]string, error) {
+
+	if containsString(rt.rtp.gm.ValueIndexes(kind), attr) {
+
+		// The value index is case sensitive but does not store the type
+		// of a value - nodes which were found by a boolean value need to
+		// be fetched and checked.
+
+		keys, err := rt.rtp.gm.NodeKeysByValue(rt.rtp.part, kind, attr, value)
+
+		if _, ok := value.(string); ok && err == nil {
+			indexAttrs[attr] = value
+		}
+
+		return keys, err
+	}
+
+	iq, err := rt.rtp.gm.NodeIndexQuery(rt.rtp.part, kind)
+	if err != nil || iq == nil {
+		return nil, err
+	}
+
+	keys, err := iq.LookupValue(attr, data.ValueString(value))
+	if err != nil {
+		return nil, err
+	} else if keys == nil {
+		keys = []string{}
+	}
+
+	// A case insensitive index might return more nodes than requested -
+	// these need to be fetched and checked. The index does not store the
+	// type of a value - nodes which were found by a boolean value need to
+	// be fetched and checked as well.
+
+	if _, ok := value.(string); ok && rt.rtp.gm.IndexOptions(kind).CaseSensitive {
+		indexAttrs[attr] = value
+	}
+
+	return keys, nil
+}
+
 /*
 Eval evaluate this runtime component.
 */
@@ -165,6 +537,10 @@ func (rt *getRuntime) gaterResult() (interface{}, error) {
 			return nil, err
 		}
 
+		if rt.rtp.rowHook != nil {
+			rt.rtp.rowHook()
+		}
+
 		// More on to the next row
 
 		more, err = rt.rtp.next()
@@ -176,3 +552,16 @@ func (rt *getRuntime) gaterResult() (interface{}, error) {
 
 	return res, err
 }
+
+/*
+containsString checks if a list of strings contains a given string.
+*/
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -11,6 +11,7 @@
 package interpreter
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -34,6 +35,7 @@ type withFlags struct {
 	notnullCol   []int  // Columns which must not be null
 	uniqueCol    []int  // Columns which will only contain unique values
 	uniqueColCnt []bool // Flag if unique values should be counted
+	groupStep    int    // Traversal step whose nodes group the result (0 for no grouping)
 }
 
 const (
@@ -65,6 +67,7 @@ type eqlRuntimeProvider struct {
 
 	primaryKind  string                 // Primary node kind
 	nextStartKey func() (string, error) // Function to get the next start key
+	indexAttrs   map[string]interface{} // Start node attributes provided by an index (nil if nodes must be fetched)
 
 	traversals []*parser.ASTNode // Array of all top level query traversals
 	where      *parser.ASTNode   // First where clause
@@ -83,6 +86,24 @@ type eqlRuntimeProvider struct {
 
 	_attrsNodesFetch [][]string // Internal copy of attrsNodes better suited for fetchPart calls
 	_attrsEdgesFetch [][]string // Internal copy of attrsEdges better suited for fetchPart calls
+
+	ctx     context.Context // Context which can cancel the query evaluation (may be nil)
+	rowHook func()          // Function which is called for every result row (may be nil)
+}
+
+/*
+SetContext sets a context which cancels the query evaluation once it is done.
+*/
+func (p *eqlRuntimeProvider) SetContext(ctx context.Context) {
+	p.ctx = ctx
+}
+
+/*
+SetRowHook sets a function which is called for every row which is added to
+the search result.
+*/
+func (p *eqlRuntimeProvider) SetRowHook(hook func()) {
+	p.rowHook = hook
 }
 
 /*
@@ -98,7 +119,7 @@ func (p *eqlRuntimeProvider) init(startKind string,
 	// Clear any with flags
 
 	p.withFlags = &withFlags{make([]byte, 0), make([]int, 0), make([]int, 0),
-		make([]int, 0), make([]bool, 0)}
+		make([]int, 0), make([]bool, 0), 0}
 
 	// Reinitialise datastructures
 
@@ -121,6 +142,7 @@ func (p *eqlRuntimeProvider) init(startKind string,
 	p.colFunc = make([]FuncShow, 0)
 
 	p.primaryKind = ""
+	p.indexAttrs = nil
 
 	p.specs = append(p.specs, startKind)
 	p.attrsNodes = append(p.attrsNodes, make(map[string]string))
@@ -354,6 +376,21 @@ func (p *eqlRuntimeProvider) initWithFlags(withNode *parser.ASTNode,
 				}
 			}
 
+		} else if child.Name == parser.NodeGROUPING {
+
+			if len(child.Children) != 1 {
+				return p.newRuntimeError(ErrInvalidConstruct,
+					"Grouping requires a single traversal step", child)
+			}
+
+			step, err := strconv.Atoi(child.Children[0].Token.Val)
+			if err != nil || step < 1 || step > len(p.specs) {
+				return p.newRuntimeError(ErrInvalidConstruct,
+					"Invalid traversal step for grouping: "+child.Children[0].Token.Val, child)
+			}
+
+			p.withFlags.groupStep = step
+
 		} else {
 			return p.newRuntimeError(ErrInvalidConstruct, child.Token.Val, child)
 		}
@@ -592,6 +629,14 @@ It is assumed that all traversal specs and query attrs have been filled.
 */
 func (p *eqlRuntimeProvider) next() (bool, error) {
 
+	// Stop if the query was cancelled
+
+	if p.ctx != nil {
+		if err := p.ctx.Err(); err != nil {
+			return false, &RuntimeError{p.name, ErrQueryCancelled, err.Error(), nil, 0, 0}
+		}
+	}
+
 	// Create fetch lists if it is the first next() call
 
 	if p._attrsNodesFetch == nil {
@@ -654,14 +699,35 @@ func (p *eqlRuntimeProvider) next() (bool, error) {
 		return false, err
 	}
 
-	// Fetch node - always require the key attribute
-	// to make sure we get a node back if it exists
+	var node data.Node
 
-	node, err := p.gm.FetchNodePart(p.part, startKey, p.specs[0],
-		append(p._attrsNodesFetch[0], "key"))
+	if p.indexAttrs != nil {
 
-	if err != nil || node == nil {
-		return false, err
+		// All required attributes are provided by an index - the node
+		// does not need to be fetched from storage
+
+		gn := data.NewGraphNode()
+
+		gn.SetAttr(data.NodeKey, startKey)
+		gn.SetAttr(data.NodeKind, p.specs[0])
+
+		for attr, val := range p.indexAttrs {
+			gn.SetAttr(attr, val)
+		}
+
+		node = gn
+
+	} else {
+
+		// Fetch node - always require the key attribute
+		// to make sure we get a node back if it exists
+
+		node, err = p.gm.FetchNodePart(p.part, startKey, p.specs[0],
+			append(p._attrsNodesFetch[0], "key"))
+
+		if err != nil || node == nil {
+			return false, err
+		}
 	}
 
 	// Decide if this node should be added
@@ -699,15 +765,21 @@ func (p *eqlRuntimeProvider) next() (bool, error) {
 			p.rowEdge[0] = nil
 		}
 
-		// Give the new source to the children and let them evaluate
+		// Give the new source to the children and let them evaluate - the
+		// most selective traversal is evaluated first
 
-		for _, child := range p.traversals {
+		for _, child := range orderTraversals(p, node.Kind(), p.traversals) {
 			childRuntime := child.Runtime.(*traversalRuntime)
 
 			if err := childRuntime.newSource(node); err == ErrEmptyTraversal {
 
-				// If an empty traversal error comes back advance until
-				// there is an element or the end
+				// If an empty traversal error comes back discard the
+				// results of all traversals and advance until there is
+				// an element or the end
+
+				for _, child := range p.traversals {
+					child.Runtime.(*traversalRuntime).reset()
+				}
 
 				p.rowNode[0] = nil
 				p.rowEdge[0] = nil
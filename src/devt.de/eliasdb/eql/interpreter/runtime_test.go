@@ -16,6 +16,7 @@ import (
 	"strings"
 	"testing"
 
+	"devt.de/common/errorutil"
 	"devt.de/eliasdb/eql/parser"
 	"devt.de/eliasdb/graph"
 	"devt.de/eliasdb/graph/data"
@@ -171,6 +172,11 @@ func TestErrors(t *testing.T) {
 		return
 	}
 
+	if !errorutil.IsCategory(err, errorutil.ErrInvalid) {
+		t.Error("Unexpected error category:", errorutil.Category(err))
+		return
+	}
+
 	delete(generalProviderMap, parser.NodeGET)
 
 	// Test validation errors
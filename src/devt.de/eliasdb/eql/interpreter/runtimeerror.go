@@ -14,6 +14,7 @@ import (
 	"errors"
 	"fmt"
 
+	"devt.de/common/errorutil"
 	"devt.de/eliasdb/eql/parser"
 )
 
@@ -49,6 +50,14 @@ func (re *RuntimeError) Error() string {
 	return ret
 }
 
+/*
+Category returns the category of this error. Runtime errors are always caused
+by invalid queries.
+*/
+func (re *RuntimeError) Category() error {
+	return errorutil.ErrInvalid
+}
+
 /*
 Runtime related error types
 */
@@ -62,6 +71,7 @@ var (
 	ErrInvalidWhere     = errors.New("Invalid where clause")
 	ErrInvalidColData   = errors.New("Invalid column data spec")
 	ErrEmptyTraversal   = errors.New("Empty traversal")
+	ErrQueryCancelled   = errors.New("Query was cancelled")
 )
 
 /*
@@ -79,3 +89,11 @@ Error returns a human-readable string representation of this error.
 func (re *ResultError) Error() string {
 	return fmt.Sprintf("EQL result error in %s: %v (%v)", re.Source, re.Type, re.Detail)
 }
+
+/*
+Category returns the category of this error. Result errors are always caused
+by invalid queries.
+*/
+func (re *ResultError) Category() error {
+	return errorutil.ErrInvalid
+}
@@ -72,6 +72,7 @@ type SearchResult struct {
 
 	Source [][]string      // Special string holding the data source (node / edge) for each column
 	Data   [][]interface{} // Data which is held by this search result
+	Group  []string        // Source of the node which groups each row (only set if the result is grouped)
 }
 
 /*
@@ -90,7 +91,7 @@ func newSearchResult(rtp *eqlRuntimeProvider) *SearchResult {
 	}
 
 	return &SearchResult{rtp.name, rtp.withFlags, SearchHeader{rtp.primaryKind, rtp.colLabels, rtp.colFormat,
-		cdl}, rtp.colFunc, make([][]string, 0), make([][]interface{}, 0)}
+		cdl}, rtp.colFunc, make([][]string, 0), make([][]interface{}, 0), nil}
 }
 
 /*
@@ -189,6 +190,17 @@ func (sr *SearchResult) addRow(rowNodes []data.Node, rowEdges []data.Edge) error
 		}
 	}
 
+	// The source of the grouping node is kept as an extra source column
+	// until all rows have been filtered and ordered
+
+	if step := sr.withFlags.groupStep; step > 0 {
+		if n := rowNodes[step-1]; n != nil {
+			src = append(src, "n:"+n.Kind()+":"+n.Key())
+		} else {
+			src = append(src, "")
+		}
+	}
+
 	sr.Source = append(sr.Source, src)
 	sr.Data = append(sr.Data, row)
 
@@ -219,7 +231,7 @@ func (sr *SearchResult) finish() {
 
 			for _, nn := range sr.withFlags.notnullCol {
 				if row[nn] == nil {
-					sr.Data = append(sr.Data[:i], sr.Data[i+1:]...)
+					sr.removeRow(i)
 					cont = true
 					break
 				}
@@ -234,7 +246,7 @@ func (sr *SearchResult) finish() {
 			for j, u := range sr.withFlags.uniqueCol {
 				if _, ok := uniqueMaps[j][fmt.Sprint(row[u])]; ok {
 					uniqueMaps[j][fmt.Sprint(row[u])]++
-					sr.Data = append(sr.Data[:i], sr.Data[i+1:]...)
+					sr.removeRow(i)
 					break
 				} else {
 					uniqueMaps[j][fmt.Sprint(row[u])] = 1
@@ -254,14 +266,39 @@ func (sr *SearchResult) finish() {
 		}
 	}
 
-	// Apply ordering
+	// Apply ordering - all ordering columns are applied at once (the first
+	// column has the highest priority). Rows which are equal in all ordering
+	// columns are ordered by their sources so the result order is stable.
+
+	if len(sr.withFlags.ordering) > 0 {
+		ascending := make([]bool, len(sr.withFlags.ordering))
 
-	for i, ordering := range sr.withFlags.ordering {
+		for i, ordering := range sr.withFlags.ordering {
+			ascending[i] = ordering == withOrderingAscending
+		}
 
-		sort.Stable(&SearchResultRowComparator{ordering == withOrderingAscending,
-			sr.withFlags.orderingCol[i], sr.Data})
+		sort.Stable(&SearchResultRowComparator{Ascending: ascending,
+			Columns: sr.withFlags.orderingCol, Data: sr.Data, Source: sr.Source})
 	}
 
+	// Move the sources of the grouping nodes out of the row sources
+
+	if sr.withFlags.groupStep > 0 {
+		sr.Group = make([]string, len(sr.Source))
+
+		for i, src := range sr.Source {
+			sr.Group[i] = src[len(src)-1]
+			sr.Source[i] = src[:len(src)-1]
+		}
+	}
+}
+
+/*
+removeRow removes a row and its sources from the result.
+*/
+func (sr *SearchResult) removeRow(i int) {
+	sr.Data = append(sr.Data[:i], sr.Data[i+1:]...)
+	sr.Source = append(sr.Source[:i], sr.Source[i+1:]...)
 }
 
 /*
@@ -307,6 +344,50 @@ func (sr *SearchResult) RowSources() [][]string {
 	return sr.Source
 }
 
+/*
+GroupStep returns the traversal step whose nodes group the result rows. Returns
+0 if the result is not grouped.
+*/
+func (sr *SearchResult) GroupStep() int {
+	return sr.withFlags.groupStep
+}
+
+/*
+RowGroup returns the source of the node which groups a result row.
+Format is n:<kind>:<key> or an empty string if the row has no node at the
+grouping traversal step.
+*/
+func (sr *SearchResult) RowGroup(line int) string {
+	if sr.Group == nil {
+		return ""
+	}
+	return sr.Group[line]
+}
+
+/*
+Groups returns the rows of the result grouped by the node at the grouping
+traversal step. Groups are ordered by the first row which they contain.
+*/
+func (sr *SearchResult) Groups() []*SearchResultGroup {
+	var groups []*SearchResultGroup
+
+	lookup := make(map[string]*SearchResultGroup)
+
+	for i, g := range sr.Group {
+		sg, ok := lookup[g]
+		if !ok {
+			sg = &SearchResultGroup{g, nil, nil}
+			lookup[g] = sg
+			groups = append(groups, sg)
+		}
+
+		sg.Source = append(sg.Source, sr.Source[i])
+		sg.Data = append(sg.Data, sr.Data[i])
+	}
+
+	return groups
+}
+
 /*
 String returns a string representation of this search result.
 */
@@ -349,12 +430,51 @@ func (sr *SearchResult) String() string {
 // ==============
 
 /*
-SearchResultRowComparator is a comparator object used for sorting the result
+SearchResultGroup is a group of result rows which share the same node at the
+grouping traversal step.
+*/
+type SearchResultGroup struct {
+	Node   string          // Source of the grouping node (n:<kind>:<key>)
+	Source [][]string      // Sources of the rows of the group
+	Data   [][]interface{} // Rows of the group
+}
+
+/*
+Kind returns the kind of the grouping node.
+*/
+func (g *SearchResultGroup) Kind() string {
+	if ss := strings.SplitN(g.Node, ":", 3); len(ss) == 3 {
+		return ss[1]
+	}
+	return ""
+}
+
+/*
+Key returns the key of the grouping node.
+*/
+func (g *SearchResultGroup) Key() string {
+	if ss := strings.SplitN(g.Node, ":", 3); len(ss) == 3 {
+		return ss[2]
+	}
+	return ""
+}
+
+/*
+SearchResultRowComparator is a comparator object used for sorting the result.
+Rows are compared column by column in the given order. Rows which are equal
+in all columns are compared by their sources.
+
+The comparator sorted originally by a single column (Ascening and Column).
+These fields are still supported and are used if no Columns are given - new
+code should use Ascending and Columns.
 */
 type SearchResultRowComparator struct {
-	Ascening bool            // Sort should be ascending
-	Column   int             // Column to sort
-	Data     [][]interface{} // Data to sort
+	Ascening  bool            // Sort should be ascending (Deprecated: use Ascending)
+	Column    int             // Column to sort (Deprecated: use Columns)
+	Data      [][]interface{} // Data to sort
+	Ascending []bool          // Sort direction of each column
+	Columns   []int           // Columns to sort (first column has highest priority)
+	Source    [][]string      // Sources of the data to sort
 }
 
 func (c SearchResultRowComparator) Len() int {
@@ -362,29 +482,47 @@ func (c SearchResultRowComparator) Len() int {
 }
 
 func (c SearchResultRowComparator) Less(i, j int) bool {
-	c1 := c.Data[i][c.Column]
-	c2 := c.Data[j][c.Column]
-
-	num1, err := strconv.ParseFloat(fmt.Sprint(c1), 64)
-	if err == nil {
-		num2, err := strconv.ParseFloat(fmt.Sprint(c2), 64)
-		if err == nil {
-			if c.Ascening {
-				return num1 < num2
+	columns, ascending := c.Columns, c.Ascending
+
+	if len(columns) == 0 {
+		columns, ascending = []int{c.Column}, []bool{c.Ascening}
+	}
+
+	for k, col := range columns {
+
+		if res := compareColumnValues(c.Data[i][col], c.Data[j][col]); res != 0 {
+			if ascending[k] {
+				return res < 0
 			}
-			return num1 > num2
+			return res > 0
 		}
 	}
 
-	if c.Ascening {
-		return fmt.Sprintf("%v", c1) < fmt.Sprintf("%v", c2)
+	// Use the row sources as tie breaker
+
+	if c.Source != nil {
+		return strings.Join(c.Source[i], ",") < strings.Join(c.Source[j], ",")
 	}
 
-	return fmt.Sprintf("%v", c1) > fmt.Sprintf("%v", c2)
+	return false
 }
 
 func (c SearchResultRowComparator) Swap(i, j int) {
 	c.Data[i], c.Data[j] = c.Data[j], c.Data[i]
+
+	if c.Source != nil {
+		c.Source[i], c.Source[j] = c.Source[j], c.Source[i]
+	}
+}
+
+/*
+compareColumnValues compares two column values. Values are compared by their
+type (e.g. numbers as numbers and timestamps as timestamps) if possible
+otherwise as strings. Returns -1 if c1 is smaller, 1 if c1 is greater and 0
+if both values are equal.
+*/
+func compareColumnValues(c1 interface{}, c2 interface{}) int {
+	return data.CompareValues(c1, c2)
 }
 
 // Testing functions
@@ -13,6 +13,7 @@ package interpreter
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"testing"
 
 	"devt.de/eliasdb/eql/parser"
@@ -268,6 +269,26 @@ Labels: Name, Name, Number
 Format: auto, auto, auto
 Data: 1:n:name, 2:n:name, 2:e:number
 Mike, StrangeSong1, 1
+Hans, MyOnlySong3, 3
+Mike, LoveSong3, 3
+Mike, FightSong4, 4
+Mike, DeadSong2, 2
+John, Aria4, 4
+John, Aria3, 3
+John, Aria2, 2
+John, Aria1, 1
+`[1:], rt, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Test multiple ordering columns - the first column has the highest priority
+
+	if _, err := getResult("get Author traverse :Wrote::Song end show 1:n:name, 2:n:name, 2:e:number with ordering(ascending Wrote:number, descending Song:name)", `
+Labels: Name, Name, Number
+Format: auto, auto, auto
+Data: 1:n:name, 2:n:name, 2:e:number
+Mike, StrangeSong1, 1
 John, Aria1, 1
 Mike, DeadSong2, 2
 John, Aria2, 2
@@ -281,6 +302,32 @@ John, Aria4, 4
 		return
 	}
 
+	// Test that rows which are equal in all ordering columns are ordered by their sources
+
+	res, err := getResult("get Author traverse :Wrote::Song end show 1:n:name, 2:e:number with ordering(ascending Wrote:number)", `
+Labels: Name, Number
+Format: auto, auto
+Data: 1:n:name, 2:e:number
+John, 1
+Mike, 1
+John, 2
+Mike, 2
+John, 3
+Mike, 3
+Hans, 3
+John, 4
+Mike, 4
+`[1:], rt, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if src := fmt.Sprint(res.RowSource(6)); src != "[n:Author:456 e:Wrote:MyOnlySong3]" {
+		t.Error("Unexpected row source:", src)
+		return
+	}
+
 	// Test empty traversal flag
 
 	if _, err := getResult("get Author traverse :::Song where name = '123' end with nulltraversal(true)", `
@@ -341,6 +388,105 @@ John (4)
 	}
 }
 
+func TestWithGrouping(t *testing.T) {
+	gm, _ := songGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Group songs by their author
+
+	res, err := getResult("get Song traverse :::Author end show name, 2:n:name with grouping(2), ordering(ascending 1:n:name)", `
+Labels: Song Name, Name
+Format: auto, auto
+Data: 1:n:name, 2:n:name
+Aria1, John
+Aria2, John
+Aria3, John
+Aria4, John
+DeadSong2, Mike
+FightSong4, Mike
+LoveSong3, Mike
+MyOnlySong3, Hans
+StrangeSong1, Mike
+`[1:], rt, false)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.GroupStep() != 2 || res.RowGroup(4) != "n:Author:123" ||
+		fmt.Sprint(res.RowSource(4)) != "[n:Song:DeadSong2 n:Author:123]" {
+		t.Error("Unexpected result:", res.GroupStep(), res.RowGroup(4), res.RowSource(4))
+		return
+	}
+
+	groups := res.Groups()
+
+	if len(groups) != 3 {
+		t.Error("Unexpected result:", groups)
+		return
+	}
+
+	if groups[0].Kind() != "Author" || groups[0].Key() != "000" || len(groups[0].Data) != 4 {
+		t.Error("Unexpected result:", groups[0])
+		return
+	}
+
+	if groups[1].Key() != "123" || fmt.Sprint(groups[1].Data) !=
+		"[[DeadSong2 Mike] [FightSong4 Mike] [LoveSong3 Mike] [StrangeSong1 Mike]]" ||
+		fmt.Sprint(groups[1].Source[3]) != "[n:Song:StrangeSong1 n:Author:123]" {
+		t.Error("Unexpected result:", groups[1])
+		return
+	}
+
+	if groups[2].Key() != "456" || fmt.Sprint(groups[2].Data) != "[[MyOnlySong3 Hans]]" {
+		t.Error("Unexpected result:", groups[2])
+		return
+	}
+
+	// Rows of partial traversals are grouped together
+
+	gm, _ = songGraphGroups()
+	rt = NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	res, err = getResult("get Author where name = 'Mike' traverse :::Song traverse :::group end end show 2:n:name, 3:n:key with grouping(3), nulltraversal(true), ordering(ascending 2:n:name)", `
+Labels: Name, Key
+Format: auto, auto
+Data: 2:n:name, 3:n:key
+DeadSong2, <not set>
+FightSong4, <not set>
+LoveSong3, Best
+StrangeSong1, Best
+`[1:], rt, false)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if groups = res.Groups(); len(groups) != 2 || groups[0].Key() != "" || groups[0].Kind() != "" ||
+		len(groups[0].Data) != 2 || groups[1].Key() != "Best" || len(groups[1].Data) != 2 {
+		t.Error("Unexpected result:", groups)
+		return
+	}
+
+	// Results without grouping have no groups
+
+	res, err = getResult("get Author show name with ordering(ascending name)", `
+Labels: Author Name
+Format: auto
+Data: 1:n:name
+Hans
+John
+Mike
+`[1:], rt, false)
+
+	if err != nil || res.GroupStep() != 0 || res.RowGroup(0) != "" || len(res.Groups()) != 0 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
+
 func TestWithFlagsErrors(t *testing.T) {
 	gm, _ := songGraph()
 	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
@@ -374,6 +520,43 @@ func TestWithFlagsErrors(t *testing.T) {
 		t.Error(err)
 		return
 	}
+
+	if _, err := getResult("get Author traverse ::: end with grouping(3)", "", rt, false); err.Error() !=
+		"EQL error in test: Invalid construct (Invalid traversal step for grouping: 3) (Line:1 Pos:34)" {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get Author traverse ::: end with grouping(1, 2)", "", rt, false); err.Error() !=
+		"EQL error in test: Invalid construct (Grouping requires a single traversal step) (Line:1 Pos:34)" {
+		t.Error(err)
+		return
+	}
+}
+
+func TestSearchResultRowComparator(t *testing.T) {
+	rows := func() [][]interface{} {
+		return [][]interface{}{{"b", 2}, {"a", 10}, {"c", 2}}
+	}
+
+	// The single column fields are used if no columns are given
+
+	d := rows()
+	sort.Stable(&SearchResultRowComparator{Ascening: false, Column: 1, Data: d})
+
+	if res := fmt.Sprint(d); res != "[[a 10] [b 2] [c 2]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	d = rows()
+	sort.Stable(&SearchResultRowComparator{Ascending: []bool{true, false},
+		Columns: []int{1, 0}, Data: d})
+
+	if res := fmt.Sprint(d); res != "[[c 2] [b 2] [a 10]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
 }
 
 /*
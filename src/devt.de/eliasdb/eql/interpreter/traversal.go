@@ -11,6 +11,7 @@
 package interpreter
 
 import (
+	"sort"
 	"strings"
 
 	"devt.de/eliasdb/eql/parser"
@@ -251,17 +252,90 @@ func (rt *traversalRuntime) Eval() (interface{}, error) {
 		rt.rtp.rowEdge[rt.specIndex] = rowEdge
 	}
 
-	// Give the new source to the children and let them evaluate
+	// Give the new source to the children and let them evaluate - the most
+	// selective traversal is evaluated first
 
+	var kind string
+	if rowNode != nil {
+		kind = rowNode.Kind()
+	}
+
+	for _, child := range orderTraversals(rt.rtp, kind, rt.node.Children[1:]) {
+		childRuntime := child.Runtime.(*traversalRuntime)
+
+		if err := childRuntime.newSource(rt.rtp.rowNode[rt.specIndex]); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+/*
+reset discards the nodes of the last traversal result of this traversal
+runtime component and all its children.
+*/
+func (rt *traversalRuntime) reset() {
 	for _, child := range rt.node.Children[1:] {
 		if child.Name == parser.NodeTRAVERSE {
-			childRuntime := child.Runtime.(*traversalRuntime)
+			child.Runtime.(*traversalRuntime).reset()
+		}
+	}
+
+	rt.nodes = nil
+	rt.edges = nil
+	rt.curptr = 0
+}
 
-			if err := childRuntime.newSource(rt.rtp.rowNode[rt.specIndex]); err != nil {
-				return nil, err
+/*
+orderTraversals returns the traversals of a given list of nodes in the order
+in which they should be evaluated for a source node of a given kind.
+Traversals with a low estimated fan-out are evaluated first so empty
+traversals are found early. The estimate is based on the edge kind statistics
+of the queried partition - the original order is kept if no statistics have
+been collected.
+*/
+func orderTraversals(rtp *eqlRuntimeProvider, kind string, nodes []*parser.ASTNode) []*parser.ASTNode {
+	var traversals []*parser.ASTNode
+	var fanOuts []float64
+
+	for _, node := range nodes {
+		if node.Name == parser.NodeTRAVERSE {
+			fanOut := -1.0
+
+			if kind != "" {
+				fanOut = rtp.gm.TraversalFanOut(rtp.part, kind,
+					node.Runtime.(*traversalRuntime).spec)
 			}
+
+			traversals = append(traversals, node)
+			fanOuts = append(fanOuts, fanOut)
 		}
 	}
 
-	return nil, nil
+	if len(traversals) < 2 {
+		return traversals
+	}
+
+	for _, fanOut := range fanOuts {
+		if fanOut < 0 {
+			return traversals
+		}
+	}
+
+	ordered := make([]int, len(traversals))
+	for i := range ordered {
+		ordered[i] = i
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return fanOuts[ordered[i]] < fanOuts[ordered[j]]
+	})
+
+	ret := make([]*parser.ASTNode, len(traversals))
+	for i, j := range ordered {
+		ret[i] = traversals[j]
+	}
+
+	return ret
 }
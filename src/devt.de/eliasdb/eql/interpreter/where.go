@@ -15,6 +15,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"devt.de/eliasdb/eql/parser"
 	"devt.de/eliasdb/graph/data"
@@ -87,7 +88,7 @@ func (rt *whereItemRuntime) stringOp(node data.Node, edge data.Edge, op func(str
 		return nil, err
 	}
 
-	return op(fmt.Sprint(res1), fmt.Sprint(res2)), nil
+	return op(data.ValueString(res1), data.ValueString(res2)), nil
 }
 
 /*
@@ -141,18 +142,30 @@ func (rt *whereItemRuntime) numOp(node data.Node, edge data.Edge, op func(float6
 		return tokenVal + "=" + opVal
 	}
 
-	// Parse the values to numbers
+	// A string which is used together with a timestamp is parsed as a timestamp
 
-	res1Str := fmt.Sprint(res1)
-	res1Num, err := strconv.ParseFloat(res1Str, 64)
-	if err != nil {
-		return nil, rt.rtp.newRuntimeError(ErrNotANumber, errDetail(rt.astNode.Children[0].Token.Val, res1Str), rt.astNode.Children[0])
+	_, isTime1 := res1.(time.Time)
+	_, isTime2 := res2.(time.Time)
+
+	if isTime1 || isTime2 {
+		if t, ok := data.ValueTime(res1); ok {
+			res1 = t
+		}
+		if t, ok := data.ValueTime(res2); ok {
+			res2 = t
+		}
 	}
 
-	res2Str := fmt.Sprint(res2)
-	res2Num, err := strconv.ParseFloat(res2Str, 64)
-	if err != nil {
-		return nil, rt.rtp.newRuntimeError(ErrNotANumber, errDetail(rt.astNode.Children[1].Token.Val, res2Str), rt.astNode.Children[1])
+	// Convert the values to numbers
+
+	res1Num, ok := data.ValueNumber(res1)
+	if !ok {
+		return nil, rt.rtp.newRuntimeError(ErrNotANumber, errDetail(rt.astNode.Children[0].Token.Val, fmt.Sprint(res1)), rt.astNode.Children[0])
+	}
+
+	res2Num, ok := data.ValueNumber(res2)
+	if !ok {
+		return nil, rt.rtp.newRuntimeError(ErrNotANumber, errDetail(rt.astNode.Children[1].Token.Val, fmt.Sprint(res2)), rt.astNode.Children[1])
 	}
 
 	return op(res1Num, res2Num), nil
@@ -236,14 +249,15 @@ func toBool(res interface{}) bool {
 	switch res := res.(type) {
 
 	default:
+		if num, ok := data.ValueNumber(res); ok {
+			return num > 0
+		}
+
 		return res != nil
 
 	case bool:
 		return res
 
-	case float64:
-		return res > 0
-
 	case string:
 
 		// Try to convert the string into a number
@@ -257,19 +271,42 @@ func toBool(res interface{}) bool {
 	}
 }
 
+/*
+equals is a helper function to compare two values. Null values are only equal
+to other null values and booleans are compared as booleans. All other values
+are compared as timestamps or numbers if possible and otherwise as strings.
+*/
 func equals(res1 interface{}, res2 interface{}) bool {
 
-	// Try to convert the string into a number
+	if res1 == nil || res2 == nil {
+		return res1 == nil && res2 == nil
+	}
 
-	num1, err := strconv.ParseFloat(fmt.Sprint(res1), 64)
-	if err == nil {
-		num2, err := strconv.ParseFloat(fmt.Sprint(res2), 64)
-		if err == nil {
-			return num1 == num2
-		}
+	if b1, ok := res1.(bool); ok {
+		return equalsBool(b1, res2)
+	} else if b2, ok := res2.(bool); ok {
+		return equalsBool(b2, res1)
+	}
+
+	return data.CompareValues(res1, res2) == 0
+}
+
+/*
+equalsBool compares a boolean with an abstract value. A string is equal to a
+boolean if it is its literal representation (e.g. "true").
+*/
+func equalsBool(b bool, res interface{}) bool {
+
+	switch res := res.(type) {
+
+	case bool:
+		return b == res
+
+	case string:
+		return res == strconv.FormatBool(b)
 	}
 
-	return fmt.Sprintf("%v", res1) == fmt.Sprintf("%v", res2)
+	return false
 }
 
 // Where runtime
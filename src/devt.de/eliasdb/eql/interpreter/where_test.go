@@ -14,11 +14,13 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"devt.de/eliasdb/eql/parser"
 	"devt.de/eliasdb/graph"
 	"devt.de/eliasdb/graph/data"
 	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/graph/util"
 )
 
 func TestDataQueries(t *testing.T) {
@@ -122,6 +124,323 @@ Data: 1:n:key, 1:func:objget()
 
 }
 
+func TestKeyPrefixQueries(t *testing.T) {
+	gm, _ := simpleList()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	if err := runSearch("get mynode where key beginswith 4", `
+Labels: Mynode Key, Mynode Name, Ranking
+Format: auto, auto, auto
+Data: 1:n:key, 1:n:name, 1:n:ranking
+456, Node1, 3.5
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "4567")
+	node.SetAttr("kind", "mynode")
+	node.SetAttr("name", "Node3")
+	gm.StoreNode("main", node)
+
+	if err := runSearch("get mynode where key beginswith '45' show key", `
+Labels: Mynode Key
+Format: auto
+Data: 1:n:key
+456
+4567
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Prefix values which are attribute names are not treated as literals
+
+	if err := runSearch("get mynode where key beginswith name show key", `
+Labels: Mynode Key
+Format: auto
+Data: 1:n:key
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get mynode where key beginswith val:name show key", `
+Labels: Mynode Key
+Format: auto
+Data: 1:n:key
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get foo where key beginswith 4", "", rt); err == nil ||
+		err.Error() != "EQL error in test: Unknown node kind (foo) (Line:1 Pos:5)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestIndexOnlyQueries(t *testing.T) {
+	gm, _ := songGraph()
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Queries which only need key and kind are answered from the key index
+
+	if err := runSearch("get Author where key beginswith 1 show key, kind", `
+Labels: Author Key, Author Kind
+Format: auto, auto
+Data: 1:n:key, 1:n:kind
+123, Author
+`[1:], rt); err != nil || rt.indexAttrs == nil {
+		t.Error(err, rt.indexAttrs)
+		return
+	}
+
+	// The case insensitive value index provides start keys but nodes still
+	// need to be checked
+
+	if err := runSearch("get Author where name = 'mike' show key", `
+Labels: Author Key
+Format: auto
+Data: 1:n:key
+`[1:], rt); err != nil || rt.indexAttrs != nil {
+		t.Error(err, rt.indexAttrs)
+		return
+	}
+
+	if err := runSearch("get Author where name = 'Mike' show key, name", `
+Labels: Author Key, Author Name
+Format: auto, auto
+Data: 1:n:key, 1:n:name
+123, Mike
+`[1:], rt); err != nil || rt.indexAttrs != nil {
+		t.Error(err, rt.indexAttrs)
+		return
+	}
+
+	util.CaseSensitiveWordIndex = true
+	defer func() {
+		util.CaseSensitiveWordIndex = false
+	}()
+
+	gm, _ = songGraph()
+	rt = NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	if err := runSearch("get Author where name = 'Mike' show key, name", `
+Labels: Author Key, Author Name
+Format: auto, auto
+Data: 1:n:key, 1:n:name
+123, Mike
+`[1:], rt); err != nil || rt.indexAttrs["name"] != "Mike" {
+		t.Error(err, rt.indexAttrs)
+		return
+	}
+
+	// Attributes which are not provided by the index require a fetch
+
+	if err := runSearch("get Song where name = 'Aria1' show key, ranking", `
+Labels: Song Key, Ranking
+Format: auto, auto
+Data: 1:n:key, 1:n:ranking
+Aria1, 8
+`[1:], rt); err != nil || rt.indexAttrs != nil {
+		t.Error(err, rt.indexAttrs)
+		return
+	}
+
+	// Traversals require a fetch
+
+	if err := runSearch("get Author where name = 'Hans' traverse :::Song end show 1:n:key, 2:n:key", `
+Labels: Key, Key
+Format: auto, auto
+Data: 1:n:key, 2:n:key
+456, MyOnlySong3
+`[1:], rt); err != nil || rt.indexAttrs != nil {
+		t.Error(err, rt.indexAttrs)
+		return
+	}
+
+	if err := runSearch("get foo where name = 'Hans'", "", rt); err == nil ||
+		err.Error() != "EQL error in test: Unknown node kind (foo) (Line:1 Pos:5)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestBooleanAndNullLiterals(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for key, active := range map[string]interface{}{"a": true, "b": false, "c": "true", "d": nil, "e": "TRUE"} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Flag")
+		node.SetAttr("active", active)
+		node.SetAttr("name", "<nil>")
+		gm.StoreNode("main", node)
+	}
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Booleans are compared as booleans - strings are equal if they are the
+	// literal representation of a boolean
+
+	if err := runSearch("get Flag where active = true show key, active", `
+Labels: Flag Key, Active
+Format: auto, auto
+Data: 1:n:key, 1:n:active
+a, true
+c, true
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get Flag where active = FALSE show key", `
+Labels: Flag Key
+Format: auto
+Data: 1:n:key
+b
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get Flag where false = active or active = 1 show key", `
+Labels: Flag Key
+Format: auto
+Data: 1:n:key
+b
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Null is only equal to missing values
+
+	if err := runSearch("get Flag where active = null show key", `
+Labels: Flag Key
+Format: auto
+Data: 1:n:key
+d
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get Flag where name = null or active != null show key", `
+Labels: Flag Key
+Format: auto
+Data: 1:n:key
+a
+b
+c
+e
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get Flag where active in [false, null] show key", `
+Labels: Flag Key
+Format: auto
+Data: 1:n:key
+b
+d
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Boolean values are looked up in the value index but nodes always need
+	// to be checked since the index does not store the type of a value
+
+	util.CaseSensitiveWordIndex = true
+	defer func() {
+		util.CaseSensitiveWordIndex = false
+	}()
+
+	if err := runSearch("get Flag where active = true show key", `
+Labels: Flag Key
+Format: auto
+Data: 1:n:key
+a
+c
+`[1:], rt); err != nil || rt.indexAttrs != nil {
+		t.Error(err, rt.indexAttrs)
+		return
+	}
+}
+
+func TestTypedValues(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	created := time.Date(2016, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	for i, count := range []interface{}{int64(9), int64(10), 100.5, "2", int64(1) << 60} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "Item")
+		node.SetAttr("count", count)
+		node.SetAttr("created", created.Add(time.Duration(i)*time.Hour))
+		gm.StoreNode("main", node)
+	}
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Numbers are compared and sorted as numbers
+
+	if _, err := getResult("get Item where count > 5 show key, count with ordering(ascending count)", `
+Labels: Item Key, Count
+Format: auto, auto
+Data: 1:n:key, 1:n:count
+0, 9
+1, 10
+2, 100.5
+4, 1152921504606846976
+`[1:], rt, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get Item where count = 1152921504606846976 or count = 10.0 show key", `
+Labels: Item Key
+Format: auto
+Data: 1:n:key
+1
+4
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Timestamps can be compared with strings and are sorted by time
+
+	if _, err := getResult("get Item where created >= '2016-05-17T12:30:00Z' show key, created with ordering(descending created)", `
+Labels: Item Key, Created
+Format: auto, auto
+Data: 1:n:key, 1:n:created
+4, 2016-05-17 14:30:00 +0000 UTC
+3, 2016-05-17 13:30:00 +0000 UTC
+2, 2016-05-17 12:30:00 +0000 UTC
+`[1:], rt, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get Item where created = '2016-05-17T11:30:00Z' or created like '^2016-05-17T10' show key", `
+Labels: Item Key
+Format: auto
+Data: 1:n:key
+0
+1
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
 func TestWhere(t *testing.T) {
 	gm, _ := simpleGraph()
 	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
@@ -787,3 +1106,223 @@ func regexList() (*graph.Manager, *graphstorage.MemoryGraphStorage) {
 
 	return gm, mgs.(*graphstorage.MemoryGraphStorage)
 }
+
+func TestValueIndexLookup(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for key, status := range map[string]interface{}{"a": "active", "b": "Active", "c": "inactive", "d": true, "e": "true"} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Task")
+		node.SetAttr("status", status)
+		gm.StoreNode("main", node)
+	}
+
+	if err := gm.CreateValueIndex("Task", "status"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// The value index is case sensitive and provides the looked up value
+
+	if err := runSearch("get Task where status = 'active' show key, status", `
+Labels: Task Key, Status
+Format: auto, auto
+Data: 1:n:key, 1:n:status
+a, active
+`[1:], rt); err != nil || rt.indexAttrs["status"] != "active" {
+		t.Error(err, rt.indexAttrs)
+		return
+	}
+
+	// Nodes which were found by a boolean value need to be checked
+
+	if err := runSearch("get Task where status = true show key", `
+Labels: Task Key
+Format: auto
+Data: 1:n:key
+d
+e
+`[1:], rt); err != nil || rt.indexAttrs != nil {
+		t.Error(err, rt.indexAttrs)
+		return
+	}
+
+	if err := runSearch("get Task where status = 'done' show key", `
+Labels: Task Key
+Format: auto
+Data: 1:n:key
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	rt = NewGetRuntimeProvider("test", "other", gm, NewDefaultNodeInfo(gm))
+
+	if err := runSearch("get Task where status = 'active'", "", rt); err == nil ||
+		err.Error() != "EQL error in test: Unknown node kind (Task) (Line:1 Pos:5)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestValueIndexLookupStats(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for i := 0; i < 20; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint("t", i))
+		node.SetAttr("kind", "Task")
+		node.SetAttr("status", []string{"open", "done"}[i%2])
+		node.SetAttr("owner", fmt.Sprint("u", i))
+		gm.StoreNode("main", node)
+	}
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	lookup := func(query string) (string, interface{}) {
+		ast, err := parser.ParseWithRuntime("test", query, rt)
+		if err != nil {
+			t.Error(err)
+			return "", nil
+		}
+
+		if err := ast.Runtime.Validate(); err != nil {
+			t.Error(err)
+			return "", nil
+		}
+
+		attr, value, _ := ast.Runtime.(*getRuntime).valueLookup("Task")
+
+		return attr, value
+	}
+
+	// Without statistics the first condition is used - statistics are
+	// collected in the background
+
+	if attr, value := lookup("get Task where status = 'open' and owner = 'u3'"); attr != "status" || value != "open" {
+		t.Error("Unexpected result:", attr, value)
+		return
+	}
+
+	gm.RefreshKindStats("main", "Task")
+
+	// With statistics the condition which matches the fewest nodes is used
+
+	if attr, value := lookup("get Task where status = 'open' and owner = 'u3'"); attr != "owner" || value != "u3" {
+		t.Error("Unexpected result:", attr, value)
+		return
+	}
+
+	if attr, value := lookup("get Task where status = 'open' and (owner = 'u3' or owner = 'u5')"); attr != "status" || value != "open" {
+		t.Error("Unexpected result:", attr, value)
+		return
+	}
+
+	if err := runSearch("get Task where status = 'done' and owner = 'u3' show key", `
+Labels: Task Key
+Format: auto
+Data: 1:n:key
+t3
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := runSearch("get Task where status = 'open' and owner = 'u3' show key", `
+Labels: Task Key
+Format: auto
+Data: 1:n:key
+`[1:], rt); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestRangeIndexLookup(t *testing.T) {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	created := time.Date(2016, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	for i, age := range []interface{}{int64(42), 30, "25", 30.5, "unknown"} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint("p", i))
+		node.SetAttr("kind", "Person")
+		node.SetAttr("age", age)
+		node.SetAttr("created", created.Add(time.Duration(i)*24*time.Hour))
+		gm.StoreNode("main", node)
+	}
+
+	rt := NewGetRuntimeProvider("test", "main", gm, NewDefaultNodeInfo(gm))
+
+	// Without a range index all nodes are checked
+
+	if err := runSearch("get Person where age > 30 show key", "", rt); err == nil ||
+		err.Error() != "EQL error in test: Value of operand is not a number (age=unknown) (Line:1 Pos:18)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	gm.CreateRangeIndex("Person", "age")
+	gm.CreateRangeIndex("Person", "created")
+
+	// The range index provides start keys ordered by value - nodes without
+	// a number value are not visited
+
+	if _, err := getResult("get Person where age > 30 show key, age", `
+Labels: Person Key, Age
+Format: auto, auto
+Data: 1:n:key, 1:n:age
+p3, 30.5
+p0, 42
+`[1:], rt, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := getResult("get Person where 30 >= age and age >= '25' show key", `
+Labels: Person Key
+Format: auto
+Data: 1:n:key
+p2
+p1
+`[1:], rt, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Other conditions are checked on the found nodes
+
+	if _, err := getResult("get Person where age < 40 and key != 'p1' show key", `
+Labels: Person Key
+Format: auto
+Data: 1:n:key
+p2
+p3
+`[1:], rt, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Timestamps can be used as bounds
+
+	if _, err := getResult("get Person where created >= '2016-05-18' and created < '2016-05-20T10:30:00Z' show key", `
+Labels: Person Key
+Format: auto
+Data: 1:n:key
+p1
+p2
+`[1:], rt, false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Conditions which are combined by or cannot use the index
+
+	if err := runSearch("get Person where age > 30 or age < 20 show key", "", rt); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -81,6 +81,7 @@ const (
 	TokenNULLTRAVERSAL
 	TokenFILTERING
 	TokenORDERING
+	TokenGROUPING
 	TokenWHERE
 	TokenTRAVERSE
 	TokenEND
@@ -123,6 +124,7 @@ const (
 	NodeORDERING      = "ordering"
 	NodeFILTERING     = "filtering"
 	NodeNULLTRAVERSAL = "nulltraversal"
+	NodeGROUPING      = "grouping"
 
 	// Special tokens - always handled in a denotation function
 
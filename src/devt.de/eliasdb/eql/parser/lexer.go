@@ -79,6 +79,7 @@ var keywordMap = map[string]LexTokenID{
 	"filtering":     TokenFILTERING,
 	"ordering":      TokenORDERING,
 	"nulltraversal": TokenNULLTRAVERSAL,
+	"grouping":      TokenGROUPING,
 	"where":         TokenWHERE,
 	"traverse":      TokenTRAVERSE,
 	"end":           TokenEND,
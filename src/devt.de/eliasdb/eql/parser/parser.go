@@ -101,6 +101,7 @@ func init() {
 		TokenORDERING:      &ASTNode{NodeORDERING, nil, nil, nil, 0, ndWithFunc, nil},
 		TokenFILTERING:     &ASTNode{NodeFILTERING, nil, nil, nil, 0, ndWithFunc, nil},
 		TokenNULLTRAVERSAL: &ASTNode{NodeNULLTRAVERSAL, nil, nil, nil, 0, ndWithFunc, nil},
+		TokenGROUPING:      &ASTNode{NodeGROUPING, nil, nil, nil, 0, ndWithFunc, nil},
 
 		// Special tokens - always handled in a denotation function
 
@@ -13,6 +13,8 @@ package parser
 import (
 	"fmt"
 	"testing"
+
+	"devt.de/common/errorutil"
 )
 
 /*
@@ -443,7 +445,7 @@ get
 	}
 
 	input = `
-get song where true // 'div' show bla wIth orderinG(ASCending aa,Descending bb), FILTERING(ISNOTNULL test2,UNIQUE test3, uniquecount test3), nulltraversal(true)`
+get song where true // 'div' show bla wIth orderinG(ASCending aa,Descending bb), FILTERING(ISNOTNULL test2,UNIQUE test3, uniquecount test3), nulltraversal(true), grouping(2)`
 	expectedOutput = `
 get
   value: "song"
@@ -468,6 +470,8 @@ get
         value: "test3"
     nulltraversal
       true
+    grouping
+      value: "2"
 `[1:]
 
 	if res, err := Parse("mytest", input); err != nil || fmt.Sprint(res) != expectedOutput {
@@ -482,6 +486,9 @@ func TestParserErrorCases(t *testing.T) {
 		"Parse error in mytest: Unexpected end" {
 		t.Error("Unexpected result", res, err)
 		return
+	} else if !errorutil.IsCategory(err, errorutil.ErrInvalid) {
+		t.Error("Unexpected error category:", errorutil.Category(err))
+		return
 	}
 
 	if res, err := ParseWithRuntime("mytest", "GET r\"aa", &TestRuntimeProvider{}); err.Error() !=
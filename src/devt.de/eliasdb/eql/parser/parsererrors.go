@@ -13,6 +13,8 @@ package parser
 import (
 	"errors"
 	"fmt"
+
+	"devt.de/common/errorutil"
 )
 
 /*
@@ -52,6 +54,14 @@ func (pe *Error) Error() string {
 	return ret
 }
 
+/*
+Category returns the category of this error. Parser errors are always caused
+by invalid input.
+*/
+func (pe *Error) Category() error {
+	return errorutil.ErrInvalid
+}
+
 /*
 Parser related error types
 */
@@ -14,11 +14,13 @@ Package eql contains the main API for EQL.
 package eql
 
 import (
+	"context"
 	"strings"
 
 	"devt.de/eliasdb/eql/interpreter"
 	"devt.de/eliasdb/eql/parser"
 	"devt.de/eliasdb/graph"
+	"devt.de/eliasdb/graph/data"
 )
 
 /*
@@ -26,6 +28,17 @@ GroupNodeKind is a special node kind representing groups
 */
 const GroupNodeKind = interpreter.GroupNodeKind
 
+/*
+RegisterScoreFunc registers a scoring function which can be used in show
+clauses via @score(<step>, <name> [, <index attribute>, <index word>]). The
+function receives the full node and its index relevance (number of occurrences
+of the given word in the given attribute). Results can be ranked by ordering
+on the score column. A nil function removes the registration.
+*/
+func RegisterScoreFunc(name string, f func(node data.Node, relevance float64) float64) {
+	interpreter.RegisterScoreFunc(name, f)
+}
+
 /*
 RunQuery runs a search query against a given graph database.
 */
@@ -38,7 +51,22 @@ RunQueryWithNodeInfo runs a search query against a given graph database. Using
 a given NodeInfo object to retrieve rendering information.
 */
 func RunQueryWithNodeInfo(name string, part string, query string, gm *graph.Manager, ni interpreter.NodeInfo) (SearchResult, error) {
-	var rtp parser.RuntimeProvider
+	return runQuery(nil, nil, name, part, query, gm, ni)
+}
+
+/*
+runQuery runs a search query against a given graph database. The query is
+cancelled once a given context is done. A given active query is notified
+about every produced row.
+*/
+func runQuery(ctx context.Context, aq *ActiveQuery, name string, part string,
+	query string, gm *graph.Manager, ni interpreter.NodeInfo) (SearchResult, error) {
+
+	var rtp interface {
+		parser.RuntimeProvider
+		SetContext(ctx context.Context)
+		SetRowHook(hook func())
+	}
 
 	word := strings.ToLower(parser.FirstWord(query))
 
@@ -57,6 +85,14 @@ func RunQueryWithNodeInfo(name string, part string, query string, gm *graph.Mana
 		}
 	}
 
+	if ctx != nil {
+		rtp.SetContext(ctx)
+	}
+
+	if aq != nil {
+		rtp.SetRowHook(aq.addRow)
+	}
+
 	ast, err := parser.ParseWithRuntime(name, query, rtp)
 	if err != nil {
 		return nil, err
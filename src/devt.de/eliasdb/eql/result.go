@@ -79,3 +79,24 @@ type SearchResult interface {
 	*/
 	String() string
 }
+
+/*
+GroupedSearchResult models an EQL search result whose rows can be grouped by
+the node at a traversal step (see the grouping directive of the with clause).
+*/
+type GroupedSearchResult interface {
+	SearchResult
+
+	/*
+		GroupStep returns the traversal step whose nodes group the result rows.
+		Returns 0 if the result is not grouped.
+	*/
+	GroupStep() int
+
+	/*
+		RowGroup returns the source of the node which groups a result row.
+		Format is n:<kind>:<key> or an empty string if the row has no node at
+		the grouping traversal step.
+	*/
+	RowGroup(line int) string
+}
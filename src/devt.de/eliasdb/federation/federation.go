@@ -0,0 +1,528 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package federation runs EQL queries across several independent EliasDB
+instances (e.g. one database per region).
+
+Remote instances are registered under a name:
+
+	federation.RegisterRemote("eu", &federation.Remote{
+		Endpoint: "https://eu.example.com:9090",
+	})
+
+A federated query is run on the local graph database and on all requested
+remote instances in parallel. Remote instances are queried through their REST
+API. The rows of all instances are joined locally into a single result - the
+instance which produced a row can be looked up with RowInstance(). All
+instances must produce results with the same columns. The rows of each
+instance are kept in the order in which the instance returned them.
+
+	res, err := federation.RunQuery("main", "get Person", gm)
+*/
+package federation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"devt.de/common/errorutil"
+	"devt.de/eliasdb/eql"
+	"devt.de/eliasdb/graph"
+)
+
+/*
+LocalInstance is the instance name of rows which were produced by the local
+graph database.
+*/
+const LocalInstance = "local"
+
+/*
+QueryPath is the path of the query endpoint of remote instances.
+*/
+const QueryPath = "/db/v1/query/"
+
+/*
+DefaultRequestTimeout is the time limit for a query on a remote instance.
+*/
+const DefaultRequestTimeout = time.Minute
+
+/*
+HTTPClient is the client which is used for all requests to remote instances.
+*/
+var HTTPClient = &http.Client{Timeout: DefaultRequestTimeout}
+
+/*
+Remote is a remote EliasDB instance.
+*/
+type Remote struct {
+	Endpoint  string `json:"endpoint"`  // URL of the instance (e.g. https://host:9090)
+	Partition string `json:"partition"` // Partition which is queried instead of the requested one (optional)
+}
+
+/*
+NewRemoteFromConfig creates a new remote instance from a config map which
+uses the JSON names of the remote fields.
+*/
+func NewRemoteFromConfig(config map[string]interface{}) (*Remote, error) {
+	remote := &Remote{}
+
+	data, err := json.Marshal(config)
+	if err == nil {
+		err = json.Unmarshal(data, remote)
+	}
+
+	if err == nil {
+		if u, perr := url.Parse(remote.Endpoint); perr != nil || u.Scheme == "" || u.Host == "" {
+			err = fmt.Errorf("Remote needs an endpoint URL")
+		}
+	}
+
+	return remote, err
+}
+
+/*
+remotes holds all registered remote instances.
+*/
+var remotes = make(map[string]*Remote)
+
+/*
+remotesLock protects the remote registry.
+*/
+var remotesLock = &sync.RWMutex{}
+
+/*
+RegisterRemote registers a remote instance under a given name. A nil remote
+removes a registered remote.
+*/
+func RegisterRemote(name string, remote *Remote) {
+	remotesLock.Lock()
+	defer remotesLock.Unlock()
+
+	if remote == nil {
+		delete(remotes, name)
+	} else {
+		remotes[name] = remote
+	}
+}
+
+/*
+GetRemote returns a registered remote instance.
+*/
+func GetRemote(name string) (*Remote, bool) {
+	remotesLock.RLock()
+	defer remotesLock.RUnlock()
+
+	remote, ok := remotes[name]
+
+	return remote, ok
+}
+
+/*
+Remotes returns the names of all registered remote instances.
+*/
+func Remotes() []string {
+	remotesLock.RLock()
+	defer remotesLock.RUnlock()
+
+	var ret []string
+
+	for name := range remotes {
+		ret = append(ret, name)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+Federation related error types
+*/
+var (
+	ErrUnknownRemote      = errors.New("Unknown remote instance")
+	ErrRemoteFailed       = errors.New("Remote instance failed")
+	ErrIncompatibleResult = errors.New("Incompatible result")
+)
+
+/*
+Error is an error which occurred while querying a remote instance.
+*/
+type Error struct {
+	Type   error  // Error type (to be used for equal checks)
+	Remote string // Name of the remote instance
+	Status int    // HTTP status of the response (0 if no response was received)
+	Detail string // Details of the error
+}
+
+/*
+Error returns a human-readable string representation of this error.
+*/
+func (e *Error) Error() string {
+	ret := fmt.Sprintf("%v (%v", e.Type, e.Remote)
+
+	if e.Status != 0 {
+		ret += fmt.Sprintf(" - %v", e.Status)
+	}
+
+	ret += ")"
+
+	if e.Detail != "" {
+		ret += ": " + e.Detail
+	}
+
+	return ret
+}
+
+/*
+Category returns the category of this error. Errors which were caused by an
+invalid request to a remote instance are invalid. All other errors of remote
+instances are considered temporary.
+*/
+func (e *Error) Category() error {
+	switch {
+	case e.Type == ErrUnknownRemote:
+		return errorutil.ErrNotFound
+	case e.Type == ErrIncompatibleResult:
+		return errorutil.ErrInvalid
+	case e.Status >= 400 && e.Status < 500:
+		return errorutil.ErrInvalid
+	}
+	return errorutil.ErrUnavailable
+}
+
+/*
+RunQuery runs a query on the local graph database and on a given list of
+remote instances. All registered remote instances are queried if no list is
+given. The local graph database is not queried if the given graph manager is
+nil.
+*/
+func RunQuery(part string, query string, gm *graph.Manager, remoteNames ...string) (*Result, error) {
+
+	if len(remoteNames) == 0 {
+		remoteNames = Remotes()
+	}
+
+	instances := remoteNames
+
+	if gm != nil {
+		instances = append([]string{LocalInstance}, remoteNames...)
+	}
+
+	// Check all remote instances before any query is started
+
+	instanceRemotes := make([]*Remote, len(instances))
+
+	for i, instance := range instances {
+		if instance != LocalInstance || gm == nil {
+			var ok bool
+
+			if instanceRemotes[i], ok = GetRemote(instance); !ok {
+				return nil, &Error{ErrUnknownRemote, instance, 0, ""}
+			}
+		}
+	}
+
+	results := make([]*Result, len(instances))
+	errs := make([]error, len(instances))
+
+	var wg sync.WaitGroup
+
+	for i, instance := range instances {
+		wg.Add(1)
+
+		go func(i int, instance string, remote *Remote) {
+			defer wg.Done()
+
+			if remote == nil {
+				results[i], errs[i] = runLocalQuery(part, query, gm)
+			} else {
+				results[i], errs[i] = runRemoteQuery(instance, remote, part, query)
+			}
+		}(i, instance, instanceRemotes[i])
+	}
+
+	wg.Wait()
+
+	// Join all results
+
+	var ret *Result
+
+	for i, res := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		if ret == nil {
+			ret = &Result{res.header, nil, nil, nil}
+
+		} else if !ret.header.compatible(res.header) {
+			return nil, &Error{ErrIncompatibleResult, instances[i], 0, fmt.Sprintf(
+				"Result columns %v differ from %v", res.header.data, ret.header.data)}
+		}
+
+		for range res.rows {
+			ret.instances = append(ret.instances, instances[i])
+		}
+
+		ret.rows = append(ret.rows, res.rows...)
+		ret.sources = append(ret.sources, res.sources...)
+	}
+
+	if ret == nil {
+		ret = &Result{&resultHeader{}, nil, nil, nil}
+	}
+
+	return ret, nil
+}
+
+/*
+runLocalQuery runs a query on the local graph database.
+*/
+func runLocalQuery(part string, query string, gm *graph.Manager) (*Result, error) {
+
+	res, err := eql.RunQuery("federated query", part, query, gm)
+	if err != nil {
+		return nil, err
+	}
+
+	h := res.Header()
+
+	return &Result{&resultHeader{h.PrimaryKind(), h.Labels(), h.Format(), h.Data()},
+		res.Rows(), res.RowSources(), nil}, nil
+}
+
+/*
+runRemoteQuery runs a query on a remote instance.
+*/
+func runRemoteQuery(name string, remote *Remote, part string, query string) (*Result, error) {
+
+	if remote.Partition != "" {
+		part = remote.Partition
+	}
+
+	u := strings.TrimSuffix(remote.Endpoint, "/") + QueryPath + url.PathEscape(part) +
+		"?q=" + url.QueryEscape(query)
+
+	resp, err := HTTPClient.Get(u)
+	if err != nil {
+		return nil, &Error{ErrRemoteFailed, name, 0, err.Error()}
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &Error{ErrRemoteFailed, name, 0, err.Error()}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{ErrRemoteFailed, name, resp.StatusCode, strings.TrimSpace(string(body))}
+	}
+
+	res := &struct {
+		Header struct {
+			PrimaryKind string   `json:"primary_kind"`
+			Labels      []string `json:"labels"`
+			Format      []string `json:"format"`
+			Data        []string `json:"data"`
+		} `json:"header"`
+		Rows    [][]interface{} `json:"rows"`
+		Sources [][]string      `json:"sources"`
+	}{}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	if err := dec.Decode(res); err != nil {
+		return nil, &Error{ErrRemoteFailed, name, resp.StatusCode, "Could not decode response: " + err.Error()}
+	}
+
+	if len(res.Rows) != len(res.Sources) {
+		return nil, &Error{ErrRemoteFailed, name, resp.StatusCode, "Number of rows and sources differ"}
+	}
+
+	// Convert numbers so rows look like the rows of a local result
+
+	for _, row := range res.Rows {
+		for i, col := range row {
+			if n, ok := col.(json.Number); ok {
+				if v, err := n.Int64(); err == nil {
+					row[i] = v
+				} else {
+					row[i], _ = n.Float64()
+				}
+			}
+		}
+	}
+
+	h := res.Header
+
+	return &Result{&resultHeader{h.PrimaryKind, h.Labels, h.Format, h.Data},
+		res.Rows, res.Sources, nil}, nil
+}
+
+/*
+Result is the joined result of a federated query.
+*/
+type Result struct {
+	header    *resultHeader   // Header of the result
+	rows      [][]interface{} // Rows of all instances
+	sources   [][]string      // Sources of all rows
+	instances []string        // Instance of each row
+}
+
+/*
+Header returns a data structure describing the result header.
+*/
+func (r *Result) Header() eql.SearchResultHeader {
+	return r.header
+}
+
+/*
+RowCount returns the number of rows of the result.
+*/
+func (r *Result) RowCount() int {
+	return len(r.rows)
+}
+
+/*
+Row returns a row of the result.
+*/
+func (r *Result) Row(line int) []interface{} {
+	return r.rows[line]
+}
+
+/*
+Rows returns all result rows.
+*/
+func (r *Result) Rows() [][]interface{} {
+	return r.rows
+}
+
+/*
+RowSource returns the sources of a result row.
+*/
+func (r *Result) RowSource(line int) []string {
+	return r.sources[line]
+}
+
+/*
+RowSources returns the sources of a result.
+*/
+func (r *Result) RowSources() [][]string {
+	return r.sources
+}
+
+/*
+RowInstance returns the name of the instance which produced a result row.
+*/
+func (r *Result) RowInstance(line int) string {
+	return r.instances[line]
+}
+
+/*
+RowInstances returns the names of the instances which produced the result rows.
+*/
+func (r *Result) RowInstances() []string {
+	return r.instances
+}
+
+/*
+String returns a string representation of this search result.
+*/
+func (r *Result) String() string {
+	var buf bytes.Buffer
+
+	buf.WriteString("Labels: ")
+	buf.WriteString(strings.Join(r.header.labels, ", "))
+	buf.WriteString("\n")
+
+	buf.WriteString("Format: ")
+	buf.WriteString(strings.Join(r.header.format, ", "))
+	buf.WriteString("\n")
+
+	buf.WriteString("Data: ")
+	buf.WriteString(strings.Join(r.header.data, ", "))
+	buf.WriteString("\n")
+
+	for i, row := range r.rows {
+		buf.WriteString(r.instances[i])
+		buf.WriteString(": ")
+
+		for j, col := range row {
+
+			if col != nil {
+				buf.WriteString(fmt.Sprint(col))
+			} else {
+				buf.WriteString("<not set>")
+			}
+			if j < len(row)-1 {
+				buf.WriteString(", ")
+			}
+		}
+		buf.WriteString("\n")
+	}
+
+	return buf.String()
+}
+
+/*
+resultHeader is the header of a federated query result.
+*/
+type resultHeader struct {
+	primaryKind string   // Primary kind of the result
+	labels      []string // Column labels
+	format      []string // Column formats
+	data        []string // Column data
+}
+
+/*
+PrimaryKind returns the primary kind of a search result.
+*/
+func (h *resultHeader) PrimaryKind() string {
+	return h.primaryKind
+}
+
+/*
+Labels returns all column labels of a search result.
+*/
+func (h *resultHeader) Labels() []string {
+	return h.labels
+}
+
+/*
+Format returns all column format definitions of a search result.
+*/
+func (h *resultHeader) Format() []string {
+	return h.format
+}
+
+/*
+Data returns the data which is displayed in each column of a search result.
+*/
+func (h *resultHeader) Data() []string {
+	return h.data
+}
+
+/*
+compatible checks if the rows of two results can be joined.
+*/
+func (h *resultHeader) compatible(other *resultHeader) bool {
+	return fmt.Sprint(h.data) == fmt.Sprint(other.data)
+}
@@ -0,0 +1,260 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package federation
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"devt.de/common/errorutil"
+	"devt.de/eliasdb/eql"
+	"devt.de/eliasdb/graph"
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+/*
+newTestGraph creates a graph with a given number of Person nodes.
+*/
+func newTestGraph(prefix string, count int) *graph.Manager {
+	gm := graph.NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for i := 0; i < count; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(prefix, i))
+		node.SetAttr("kind", "Person")
+		node.SetAttr("name", fmt.Sprint(prefix, "name", i))
+		node.SetAttr("age", 20+i)
+		gm.StoreNode("main", node)
+	}
+
+	return gm
+}
+
+/*
+newTestInstance creates a minimal remote instance which answers queries on a
+given graph like the query endpoint of the REST API.
+*/
+func newTestInstance(gm *graph.Manager) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if !strings.HasPrefix(r.URL.Path, QueryPath) {
+			http.Error(w, "Unknown path", http.StatusNotFound)
+			return
+		}
+
+		res, err := eql.RunQuery("test", strings.TrimPrefix(r.URL.Path, QueryPath), r.URL.Query().Get("q"), gm)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"header": map[string]interface{}{
+				"labels":       res.Header().Labels(),
+				"format":       res.Header().Format(),
+				"data":         res.Header().Data(),
+				"primary_kind": res.Header().PrimaryKind(),
+			},
+			"rows":    res.Rows(),
+			"sources": res.RowSources(),
+		})
+	}))
+}
+
+func TestFederatedQuery(t *testing.T) {
+	gm := newTestGraph("local", 2)
+
+	eu := newTestInstance(newTestGraph("eu", 3))
+	defer eu.Close()
+
+	us := newTestInstance(newTestGraph("us", 1))
+	defer us.Close()
+
+	if _, err := NewRemoteFromConfig(map[string]interface{}{"endpoint": "foo"}); err == nil ||
+		err.Error() != "Remote needs an endpoint URL" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	remote, err := NewRemoteFromConfig(map[string]interface{}{"endpoint": eu.URL + "/"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	RegisterRemote("eu", remote)
+	defer RegisterRemote("eu", nil)
+
+	RegisterRemote("us", &Remote{Endpoint: us.URL})
+	defer RegisterRemote("us", nil)
+
+	if res := fmt.Sprint(Remotes()); res != "[eu us]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Query all instances
+
+	res, err := RunQuery("main", "get Person where age < 22 show key, age", gm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res.String() != `
+Labels: Person Key, Age
+Format: auto, auto
+Data: 1:n:key, 1:n:age
+local: local0, 20
+local: local1, 21
+eu: eu0, 20
+eu: eu1, 21
+us: us0, 20
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res.RowCount() != 5 || res.Header().PrimaryKind() != "Person" ||
+		fmt.Sprint(res.RowSource(2)) != "[n:Person:eu0 n:Person:eu0]" ||
+		res.RowInstance(4) != "us" || len(res.RowInstances()) != 5 ||
+		fmt.Sprint(res.Row(4)) != "[us0 20]" || len(res.Rows()) != 5 || len(res.RowSources()) != 5 {
+		t.Error("Unexpected result:", res.RowCount(), res.RowSource(2), res.RowInstance(4))
+		return
+	}
+
+	// Query only some remote instances
+
+	res, err = RunQuery("main", "get Person show key", nil, "us")
+	if err != nil || res.RowCount() != 1 || res.RowInstance(0) != "us" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Remote instances can map partitions
+
+	RegisterRemote("us", &Remote{Endpoint: us.URL, Partition: "main"})
+
+	res, err = RunQuery("other", "get Person show key", nil, "us")
+	if err != nil || res.RowCount() != 1 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Test error cases
+
+	if _, err := RunQuery("main", "get Person show key", gm, "asia"); err == nil ||
+		err.Error() != "Unknown remote instance (asia)" || errorutil.Category(err) != errorutil.ErrNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// No instance is queried if one of the remote instances is unknown
+
+	var requests int32
+
+	counted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer counted.Close()
+
+	RegisterRemote("counted", &Remote{Endpoint: counted.URL})
+	defer RegisterRemote("counted", nil)
+
+	if _, err := RunQuery("main", "get Person show key", nil, "counted", "asia"); err == nil ||
+		err.Error() != "Unknown remote instance (asia)" || atomic.LoadInt32(&requests) != 0 {
+		t.Error("Unexpected result:", err, requests)
+		return
+	}
+
+	if HTTPClient.Timeout != DefaultRequestTimeout {
+		t.Error("Unexpected timeout:", HTTPClient.Timeout)
+		return
+	}
+
+	if _, err := RunQuery("main", "foo Person", nil, "eu"); err == nil ||
+		!strings.HasPrefix(err.Error(), "Remote instance failed (eu - 400): EQL error in test: Invalid construct (Unknown query type: foo)") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	RegisterRemote("broken", &Remote{Endpoint: eu.URL + "/foo"})
+	defer RegisterRemote("broken", nil)
+
+	if _, err := RunQuery("main", "get Person", nil, "broken"); err == nil ||
+		err.Error() != "Remote instance failed (broken - 404): Unknown path" || errorutil.Category(err) != errorutil.ErrInvalid {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	us.Close()
+
+	if _, err := RunQuery("main", "get Person", nil, "us"); err == nil ||
+		!strings.HasPrefix(err.Error(), "Remote instance failed (us): ") ||
+		errorutil.Category(err) != errorutil.ErrUnavailable {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestFederatedQueryIncompatibleResults(t *testing.T) {
+	gm := newTestGraph("local", 2)
+
+	eu := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "header" : { "labels" : ["Key"], "format" : ["auto"], "data" : ["1:n:name"] },
+  "rows" : [ [ "foo" ] ], "sources" : [ ] }`)
+	}))
+	defer eu.Close()
+
+	RegisterRemote("eu", &Remote{Endpoint: eu.URL})
+	defer RegisterRemote("eu", nil)
+
+	if _, err := RunQuery("main", "get Person show key", nil, "eu"); err == nil ||
+		err.Error() != "Remote instance failed (eu - 200): Number of rows and sources differ" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	eu.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{ "header" : { "labels" : ["Key"], "format" : ["auto"], "data" : ["1:n:name"] },
+  "rows" : [ [ "foo" ] ], "sources" : [ [ "n:Person:foo" ] ] }`)
+	})
+
+	if _, err := RunQuery("main", "get Person show key", gm, "eu"); err == nil ||
+		err.Error() != "Incompatible result (eu): Result columns [1:n:name] differ from [1:n:key]" ||
+		errorutil.Category(err) != errorutil.ErrInvalid {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	eu.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{`)
+	})
+
+	if _, err := RunQuery("main", "get Person show key", gm, "eu"); err == nil ||
+		err.Error() != "Remote instance failed (eu - 200): Could not decode response: unexpected EOF" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	RegisterRemote("eu", nil)
+
+	if res, err := RunQuery("main", "get Person", nil); err != nil || res.RowCount() != 0 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+
+	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+ArchivePartition seals a partition into compressed readonly archive segments.
+The data of an archived partition can still be read but it can no longer be
+changed. Archiving requires a graph storage which implements
+graphstorage.ArchiveStorage.
+*/
+func (gm *Manager) ArchivePartition(part string) error {
+
+	if err := gm.checkPartitionName(part); err != nil {
+		return err
+	}
+
+	as, ok := gm.gs.(graphstorage.ArchiveStorage)
+	if !ok {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: "Graph storage does not support archiving",
+		}
+	}
+
+	// Take writer lock
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "ArchivePartition")
+	defer gm.mutex.Unlock()
+
+	if parts := gm.getMainDBMap(MainDBParts); parts == nil {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprint("Unknown partition ", part)}
+	} else if _, ok := parts[part]; !ok {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: fmt.Sprint("Unknown partition ", part)}
+	}
+
+	if gm.IsArchivedPartition(part) {
+		return nil
+	}
+
+	// Write all pending changes before the storage files are replaced
+
+	if err := gm.gs.FlushAll(); err != nil {
+		return err
+	}
+
+	var smnames []string
+
+	for _, kind := range gm.NodeKinds() {
+		smnames = append(smnames, part+kind+StorageSuffixNodes, part+kind+StorageSuffixNodesIndex)
+	}
+
+	for _, kind := range gm.EdgeKinds() {
+		smnames = append(smnames, part+kind+StorageSuffixEdges, part+kind+StorageSuffixEdgesIndex)
+	}
+
+	smnames = append(smnames, part+StorageSuffixLabels)
+
+	for _, smname := range smnames {
+		if err := as.ArchiveStorageManager(smname); err != nil {
+			return err
+		}
+	}
+
+	// Record the archived partition
+
+	archived := gm.getMainDBMap(MainDBArchivedParts)
+	if archived == nil {
+		archived = make(map[string]string)
+	}
+
+	archived[part] = ""
+
+	gm.storeMainDBMap(MainDBArchivedParts, archived)
+
+	return gm.gs.FlushMain()
+}
+
+/*
+ArchivedPartitions returns all archived partitions.
+*/
+func (gm *Manager) ArchivedPartitions() []string {
+	return gm.mainStringList(MainDBArchivedParts)
+}
+
+/*
+IsArchivedPartition checks if a given partition was archived.
+*/
+func (gm *Manager) IsArchivedPartition(part string) bool {
+	archived := gm.getMainDBMap(MainDBArchivedParts)
+
+	if archived != nil {
+		_, ok := archived[part]
+		return ok
+	}
+
+	return false
+}
+
+/*
+checkWritablePartition checks if a given partition name is valid and if the
+partition can be changed.
+*/
+func (gm *Manager) checkWritablePartition(part string) error {
+	if err := gm.checkPartitionName(part); err != nil {
+		return err
+	}
+
+	if gm.IsArchivedPartition(part) {
+		return &util.GraphError{
+			Type:   util.ErrReadOnly,
+			Detail: fmt.Sprintf("Partition %v is archived", part),
+		}
+	}
+
+	if gm.IsSnapshot() {
+		return &util.GraphError{
+			Type:   util.ErrReadOnly,
+			Detail: "Snapshots cannot be changed",
+		}
+	}
+
+	if dgs, ok := gm.gs.(*graphstorage.DiskGraphStorage); ok && dgs.IsReadOnly() {
+		return &util.GraphError{
+			Type:   util.ErrReadOnly,
+			Detail: "Storage is readonly",
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/storage"
+)
+
+func TestArchivePartition(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	if err := gm.ArchivePartition("main"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Graph storage does not support archiving)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir7, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = NewGraphManager(dgs)
+
+	storeArchiveTestData := func(part string) {
+		for i := 0; i < 10; i++ {
+			node := data.NewGraphNode()
+			node.SetAttr("key", fmt.Sprint("song", i))
+			node.SetAttr("kind", "Song")
+			node.SetAttr("name", fmt.Sprint("Song ", i))
+			gm.StoreNode(part, node)
+		}
+
+		node := data.NewGraphNode()
+		node.SetAttr("key", "author")
+		node.SetAttr("kind", "Author")
+		gm.StoreNode(part, node)
+
+		edge := data.NewGraphEdge()
+		edge.SetAttr("key", "wrote")
+		edge.SetAttr("kind", "Wrote")
+		edge.SetAttr(data.EdgeEnd1Key, "author")
+		edge.SetAttr(data.EdgeEnd1Kind, "Author")
+		edge.SetAttr(data.EdgeEnd1Role, "Author")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, "song1")
+		edge.SetAttr(data.EdgeEnd2Kind, "Song")
+		edge.SetAttr(data.EdgeEnd2Role, "Song")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		gm.StoreEdge(part, edge)
+	}
+
+	storeArchiveTestData("main")
+	storeArchiveTestData("hist")
+
+	if err := gm.ArchivePartition("my part"); err == nil {
+		t.Error("Invalid partition names should be rejected")
+		return
+	}
+
+	if err := gm.ArchivePartition("foo"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown partition foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.ArchivePartition("hist"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Archiving twice does nothing
+
+	if err := gm.ArchivePartition("hist"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(gm.ArchivedPartitions()); res != "[hist]" ||
+		!gm.IsArchivedPartition("hist") || gm.IsArchivedPartition("main") {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The data files of the partition were replaced
+
+	smname := GraphManagerTestDBDir7 + "/histSong" + StorageSuffixNodes
+
+	if storage.DataFileExist(smname) || !storage.ArchiveFileExist(smname) ||
+		!storage.DataFileExist(GraphManagerTestDBDir7+"/mainSong"+StorageSuffixNodes) {
+		t.Error("Unexpected storage files")
+		return
+	}
+
+	checkArchive := func(gm *Manager) bool {
+
+		node, err := gm.FetchNode("hist", "song3", "Song")
+		if err != nil || node == nil || node.Attr("name") != "Song 3" {
+			t.Error("Unexpected result:", node, err)
+			return false
+		}
+
+		nodes, _, err := gm.Traverse("hist", "author", "Author", "Author:Wrote:Song:Song", true)
+		if err != nil || len(nodes) != 1 || nodes[0].Key() != "song1" {
+			t.Error("Unexpected result:", nodes, err)
+			return false
+		}
+
+		iq, err := gm.NodeIndexQuery("hist", "Song")
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+
+		if res, err := iq.LookupValue("name", "Song 5"); err != nil || fmt.Sprint(res) != "[song5]" {
+			t.Error("Unexpected result:", res, err)
+			return false
+		}
+
+		// Writes are rejected
+
+		node = data.NewGraphNode()
+		node.SetAttr("key", "song11")
+		node.SetAttr("kind", "Song")
+
+		if err := gm.StoreNode("hist", node); err == nil ||
+			err.Error() != "GraphError: Failed write to readonly storage (Partition hist is archived)" {
+			t.Error("Unexpected result:", err)
+			return false
+		}
+
+		if _, err := gm.RemoveNode("hist", "song1", "Song"); err == nil {
+			t.Error("Removing a node from an archived partition should fail")
+			return false
+		}
+
+		if _, err := gm.RemoveEdge("hist", "wrote", "Wrote"); err == nil {
+			t.Error("Removing an edge from an archived partition should fail")
+			return false
+		}
+
+		trans := NewGraphTrans(gm)
+
+		if err := trans.StoreNode("hist", node); err == nil {
+			t.Error("Storing a node in an archived partition should fail")
+			return false
+		}
+
+		// Other partitions can still be changed
+
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+			return false
+		}
+
+		return true
+	}
+
+	if !checkArchive(gm) {
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The archive is still available after reopening the storage
+
+	dgs, err = graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir7, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = NewGraphManager(dgs)
+
+	if !checkArchive(gm) {
+		return
+	}
+
+	if sr, err := gm.SizeReport(); err != nil || sr["Song"].Nodes == 0 {
+		t.Error("Unexpected result:", sr, err)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestReadOnlyDiskStorage(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir14, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := NewGraphManager(dgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "Song")
+	node.SetAttr("name", "Aria1")
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.Close()
+
+	dgs, err = graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir14, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = NewGraphManager(dgs)
+
+	if n, err := gm.FetchNode("main", "123", "Song"); err != nil || n.Attr("name") != "Aria1" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	node.SetAttr("name", "Aria2")
+
+	if err := gm.StoreNode("main", node); err == nil ||
+		err.Error() != "GraphError: Failed write to readonly storage (Storage is readonly)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.StoreNode("other", node); err == nil ||
+		err.Error() != "GraphError: Failed write to readonly storage (Storage is readonly)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
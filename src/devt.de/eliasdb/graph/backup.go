@@ -0,0 +1,65 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+Backup writes a consistent copy of the graph storage to a given directory
+while the database stays online. Other operations wait until the copy is
+complete. The copy can be opened like any other graph storage. Backups
+require a graph storage which implements graphstorage.BackupStorage.
+*/
+func (gm *Manager) Backup(targetDir string) error {
+	return gm.backup(targetDir, false)
+}
+
+/*
+IncrementalBackup writes all changes since the last full or incremental
+backup to a given directory. A full backup is required after the graph
+storage was opened. Increments are applied to a full backup with
+graphstorage.RestoreBackup.
+*/
+func (gm *Manager) IncrementalBackup(targetDir string) error {
+	return gm.backup(targetDir, true)
+}
+
+/*
+backup writes a full or an incremental backup.
+*/
+func (gm *Manager) backup(targetDir string, incremental bool) error {
+
+	bs, ok := gm.gs.(graphstorage.BackupStorage)
+	if !ok {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: "Graph storage does not support backups",
+		}
+	}
+
+	// Take writer lock - every change is flushed before the writer lock
+	// is released so all storage managers are in a consistent state. The
+	// writer lock is needed since backups record their state in the graph
+	// storage.
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "Backup")
+	defer gm.mutex.Unlock()
+
+	if incremental {
+		return bs.IncrementalBackup(targetDir)
+	}
+
+	return bs.Backup(targetDir)
+}
@@ -0,0 +1,297 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestBackup(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	if err := gm.Backup("foo"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Graph storage does not support backups)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir8, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = NewGraphManager(dgs)
+
+	for i := 0; i < 10; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint("song", i))
+		node.SetAttr("kind", "Song")
+		node.SetAttr("name", fmt.Sprint("Song ", i))
+		gm.StoreNode("main", node)
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "author")
+	node.SetAttr("kind", "Author")
+	gm.StoreNode("archived", node)
+
+	if err := gm.ArchivePartition("archived"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.Backup(GraphManagerTestDBDir9); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The database stays writable after the backup
+
+	node = data.NewGraphNode()
+	node.SetAttr("key", "song10")
+	node.SetAttr("kind", "Song")
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Open the backup
+
+	dgs, err = graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir9, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = NewGraphManager(dgs)
+
+	if c := gm.NodeCount("Song"); c != 10 {
+		t.Error("Unexpected node count:", c)
+		return
+	}
+
+	if n, err := gm.FetchNode("main", "song5", "Song"); err != nil || n.Attr("name") != "Song 5" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := gm.FetchNode("archived", "author", "Author"); err != nil || n == nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if !gm.IsArchivedPartition("archived") {
+		t.Error("Partition should still be archived")
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestIncrementalBackup(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	if err := gm.IncrementalBackup("foo"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Graph storage does not support backups)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if !RunDiskStorageTests {
+		return
+	}
+
+	backupDir := GraphManagerTestDBDir11
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir10, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = NewGraphManager(dgs)
+
+	storeSong := func(part string, key string, name string) {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Song")
+		node.SetAttr("name", name)
+		gm.StoreNode(part, node)
+	}
+
+	for i := 0; i < 10; i++ {
+		storeSong("main", fmt.Sprint("song", i), fmt.Sprint("Song ", i))
+	}
+
+	storeSong("old", "oldsong", "Old song")
+
+	dgs.StorageManager("extra", true).Insert("test")
+
+	if err := gm.IncrementalBackup(backupDir + "/inc0"); err == nil ||
+		err.Error() != "GraphError: Invalid data (A full backup is required before an incremental backup)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.Backup(backupDir + "/full"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// First increment changes and adds nodes and archives a partition
+
+	storeSong("main", "song1", "Song 1 changed")
+	storeSong("main", "song10", "Song 10")
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "author")
+	node.SetAttr("kind", "Author")
+	gm.StoreNode("main", node)
+
+	if err := gm.ArchivePartition("old"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.IncrementalBackup(backupDir + "/inc1"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Second increment removes a node and a storage manager
+
+	if _, err := gm.RemoveNode("main", "song2", "Song"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dgs.DeleteStorageManager("extra"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.IncrementalBackup(backupDir + "/inc2"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Changes after the last increment are not restored
+
+	storeSong("main", "song11", "Song 11")
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Increments must be applied in order
+
+	if err := graphstorage.RestoreBackup(backupDir+"/restore1", backupDir+"/full",
+		backupDir+"/inc2"); err == nil || !strings.Contains(err.Error(), "does not follow backup") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := graphstorage.RestoreBackup(backupDir+"/restore2", backupDir+"/inc1"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Not a full backup: "+backupDir+"/inc1)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := graphstorage.RestoreBackup(backupDir+"/restore1", backupDir+"/full"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Restore directory already exists: "+backupDir+"/restore1)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := graphstorage.RestoreBackup(backupDir+"/restore3", backupDir+"/full",
+		backupDir+"/inc1", backupDir+"/inc2"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs, err = graphstorage.NewDiskGraphStorage(backupDir+"/restore3", false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = NewGraphManager(dgs)
+
+	if n, err := gm.FetchNode("main", "song1", "Song"); err != nil || n.Attr("name") != "Song 1 changed" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	for _, key := range []string{"song2", "song11"} {
+		if n, err := gm.FetchNode("main", key, "Song"); err != nil || n != nil {
+			t.Error("Unexpected result:", n, err)
+			return
+		}
+	}
+
+	if n, err := gm.FetchNode("main", "song10", "Song"); err != nil || n == nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := gm.FetchNode("main", "author", "Author"); err != nil || n == nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if n, err := gm.FetchNode("old", "oldsong", "Song"); err != nil || n.Attr("name") != "Old song" {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if !gm.IsArchivedPartition("old") {
+		t.Error("Partition should be archived")
+		return
+	}
+
+	if c := gm.NodeCount("Song"); c != 11 {
+		t.Error("Unexpected node count:", c)
+		return
+	}
+
+	for _, smname := range dgs.StorageManagerNames() {
+		if smname == "extra" {
+			t.Error("Deleted storage manager was restored")
+			return
+		}
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+ExportCheckpointInterval is the number of nodes and edges which are written
+by a resumable export between two checkpoints
+*/
+var ExportCheckpointInterval = 1000
+
+/*
+iteratorBookmark is the persistent position of a node key iterator.
+*/
+type iteratorBookmark struct {
+	Part string                      // Partition of the iterated nodes
+	Kind string                      // Kind of the iterated nodes
+	Pos  *hash.HTreeIteratorPosition // Position of the iterator
+}
+
+/*
+exportBookmark is the persistent position of a resumable export.
+*/
+type exportBookmark struct {
+	Part    string                      // Exported partition
+	Edges   bool                        // Flag if all nodes were written
+	Kind    string                      // Kind of the nodes or edges which are written
+	Pos     *hash.HTreeIteratorPosition // Position in the nodes or edges of the kind
+	Written bool                        // Flag if a node or edge was already written to the current list
+}
+
+/*
+encodeBookmark encodes a bookmark as a URL safe string token.
+*/
+func encodeBookmark(bm interface{}) string {
+	var buf bytes.Buffer
+
+	gob.NewEncoder(&buf).Encode(bm)
+
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes())
+}
+
+/*
+decodeBookmark decodes a bookmark from a string token.
+*/
+func decodeBookmark(token string, bm interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+
+	if err == nil {
+		err = gob.NewDecoder(bytes.NewReader(b)).Decode(bm)
+	}
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrInvalidData, Detail: "Invalid bookmark: " + err.Error()}
+	}
+
+	return nil
+}
+
+/*
+Bookmark returns a token for the position of the iterator after the last
+returned node key. The token can be stored and the iteration can be continued
+later with NodeKeyIteratorAt - also after the database was restarted. Nodes
+which are stored or removed in the meantime may or may not be visited.
+*/
+func (it *NodeKeyIterator) Bookmark() string {
+	return encodeBookmark(&iteratorBookmark{it.part, it.kind, it.it.Position()})
+}
+
+/*
+NodeKeyIteratorAt iterates node keys of a certain kind starting after the
+position of a bookmark (see NodeKeyIterator.Bookmark).
+*/
+func (gm *Manager) NodeKeyIteratorAt(part string, kind string, bookmark string) (*NodeKeyIterator, error) {
+	var bm iteratorBookmark
+
+	if err := decodeBookmark(bookmark, &bm); err != nil {
+		return nil, err
+	} else if bm.Part != part || bm.Kind != kind {
+		return nil, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprintf("Bookmark belongs to partition %v and kind %v", bm.Part, bm.Kind)}
+	}
+
+	return gm.nodeKeyIteratorAt(part, kind, bm.Pos)
+}
+
+/*
+ExportPartitionResumable writes the contents of a partition as JSON to a given
+writer (see ExportPartition). After every ExportCheckpointInterval written
+nodes and edges the output is flushed and a checkpoint function is called with
+a bookmark token. An interrupted export can be continued by calling this
+function with the last bookmark - the output is the remaining part of the
+export and needs to be appended to the output which was written up to the
+checkpoint. An empty bookmark starts a new export.
+*/
+func ExportPartitionResumable(out io.Writer, part string, gm *Manager, bookmark string,
+	checkpoint func(bookmark string) error) error {
+
+	bm := &exportBookmark{part, false, "", nil, false}
+	outFile := bufio.NewWriter(out)
+
+	if bookmark == "" {
+		outFile.WriteString(`{
+  "nodes" : [`)
+
+	} else if err := decodeBookmark(bookmark, bm); err != nil {
+		return err
+
+	} else if bm.Part != part {
+		return &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprintf("Bookmark belongs to partition %v", bm.Part)}
+	}
+
+	count := 0
+
+	writeEntity := func(entity data.Node) error {
+		if bm.Written {
+			outFile.WriteString(",")
+		}
+
+		outFile.WriteString("\n    {\n")
+		writeExportAttrs(outFile, entity.Data())
+		outFile.WriteString("    }")
+
+		bm.Written = true
+
+		if count++; checkpoint != nil && count%ExportCheckpointInterval == 0 {
+			if err := outFile.Flush(); err != nil {
+				return err
+			}
+
+			return checkpoint(encodeBookmark(bm))
+		}
+
+		return nil
+	}
+
+	for _, edges := range []bool{false, true} {
+
+		if bm.Edges && !edges {
+			continue // All nodes were already written
+		}
+
+		if edges && !bm.Edges {
+			outFile.WriteString(`
+  ],
+  "edges" : [`)
+
+			bm = &exportBookmark{part, true, "", nil, false}
+		}
+
+		kinds := gm.NodeKinds()
+		if edges {
+			kinds = gm.EdgeKinds()
+		}
+
+		// Find the kind of the bookmark
+
+		start := 0
+
+		if bm.Kind != "" {
+			for start < len(kinds) && kinds[start] != bm.Kind {
+				start++
+			}
+
+			if start == len(kinds) {
+				return &util.GraphError{Type: util.ErrInvalidData,
+					Detail: fmt.Sprintf("Unknown kind in bookmark: %v", bm.Kind)}
+			}
+		}
+
+		for _, kind := range kinds[start:] {
+
+			if kind != bm.Kind {
+				bm.Kind = kind
+				bm.Pos = nil
+			}
+
+			var tree *hash.HTree
+			var err error
+
+			if edges {
+				tree, err = gm.getEdgeStorageHTree(part, kind, false)
+			} else {
+				tree, _, err = gm.getNodeStorageHTree(part, kind, false)
+			}
+
+			if err != nil {
+				return err
+			} else if tree == nil {
+
+				// The partition has no nodes or edges of this kind
+
+				continue
+			}
+
+			// Edge storages also contain the attribute values of the edges
+
+			it := tree.IteratorPrefixAt([]byte(PrefixNSAttrs), bm.Pos)
+
+			for it.HasNext() {
+				var entity data.Node
+
+				lockprof.RLock(gm.mutex, lockprof.LockGraph, "ExportPartitionResumable")
+				k, _ := it.Next()
+				gm.mutex.RUnlock()
+
+				if it.LastError != nil {
+					return &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+				}
+
+				key := string(k[len(PrefixNSAttrs):])
+
+				if edges {
+					entity, err = gm.FetchEdge(part, key, kind)
+				} else {
+					entity, err = gm.FetchNode(part, key, kind)
+				}
+
+				if err != nil {
+					return err
+				}
+
+				bm.Pos = it.Position()
+
+				if entity != nil {
+					if err := writeEntity(entity); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	outFile.WriteString(`
+  ]
+}
+`)
+
+	return outFile.Flush()
+}
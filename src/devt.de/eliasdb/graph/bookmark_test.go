@@ -0,0 +1,202 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestNodeKeyIteratorBookmark(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	for i := 0; i < 100; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "Person")
+		gm.StoreNode("main", node)
+	}
+
+	seen := make(map[string]bool)
+
+	it, _ := gm.NodeKeyIterator("main", "Person")
+
+	for it.HasNext() {
+		for i := 0; i < 7 && it.HasNext(); i++ {
+			key := it.Next()
+
+			if seen[key] {
+				t.Error("Key was returned twice:", key)
+				return
+			}
+
+			seen[key] = true
+		}
+
+		var err error
+
+		if it, err = gm.NodeKeyIteratorAt("main", "Person", it.Bookmark()); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if len(seen) != 100 {
+		t.Error("Unexpected number of keys:", len(seen))
+		return
+	}
+
+	// Bookmarks must match the iterated nodes
+
+	if _, err := gm.NodeKeyIteratorAt("main", "Car", it.Bookmark()); err == nil ||
+		err.Error() != "GraphError: Invalid data (Bookmark belongs to partition main and kind Person)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := gm.NodeKeyIteratorAt("main", "Person", "abc"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid bookmark: unexpected EOF)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestExportPartitionResumable(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	for i := 0; i < 30; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "Person")
+		gm.StoreNode("main", node)
+
+		if i > 0 {
+			edge := data.NewGraphEdge()
+			edge.SetAttr("key", fmt.Sprint(i))
+			edge.SetAttr("kind", "Knows")
+			edge.SetAttr(data.EdgeEnd1Key, fmt.Sprint(i-1))
+			edge.SetAttr(data.EdgeEnd1Kind, "Person")
+			edge.SetAttr(data.EdgeEnd1Role, "friend")
+			edge.SetAttr(data.EdgeEnd1Cascading, false)
+			edge.SetAttr(data.EdgeEnd2Key, fmt.Sprint(i))
+			edge.SetAttr(data.EdgeEnd2Kind, "Person")
+			edge.SetAttr(data.EdgeEnd2Role, "friend")
+			edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+			if err := gm.StoreEdge("main", edge); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+
+	defer func() { ExportCheckpointInterval = 1000 }()
+	ExportCheckpointInterval = 4
+
+	// Interrupt the export after every third checkpoint
+
+	var out, buf bytes.Buffer
+	var bookmark string
+
+	errInterrupted := errors.New("Interrupted")
+
+	for i := 0; i < 100; i++ {
+		checkpoints := 0
+
+		err := ExportPartitionResumable(&buf, "main", gm, bookmark, func(bm string) error {
+			out.Write(buf.Bytes())
+			buf.Reset()
+			bookmark = bm
+
+			if checkpoints++; checkpoints == 3 {
+				return errInterrupted
+			}
+			return nil
+		})
+
+		// Output which was written after the last checkpoint is lost
+
+		buf.Reset()
+
+		if err == nil {
+			break
+		} else if err != errInterrupted {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Complete the export
+
+	if err := ExportPartitionResumable(&out, "main", gm, bookmark, nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res map[string][]map[string]interface{}
+
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		t.Error("Unexpected result:", out.String(), err)
+		return
+	}
+
+	if len(res["nodes"]) != 30 || len(res["edges"]) != 29 {
+		t.Error("Unexpected result:", len(res["nodes"]), len(res["edges"]))
+		return
+	}
+
+	keys := make([]string, 0, 30)
+	for _, n := range res["nodes"] {
+		keys = append(keys, n["key"].(string))
+	}
+	sort.Strings(keys)
+
+	for i := 1; i < len(keys); i++ {
+		if keys[i] == keys[i-1] {
+			t.Error("Node was exported twice:", keys[i])
+			return
+		}
+	}
+
+	// An uninterrupted export is the same as a full export
+
+	out.Reset()
+
+	if err := ExportPartitionResumable(&out, "main", gm, "", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res2 map[string][]map[string]interface{}
+
+	if err := json.Unmarshal(out.Bytes(), &res2); err != nil ||
+		len(res2["nodes"]) != 30 || len(res2["edges"]) != 29 {
+		t.Error("Unexpected result:", out.String(), err)
+		return
+	}
+
+	// Bookmarks must match the partition
+
+	if err := ExportPartitionResumable(&out, "other", gm, bookmark, nil); err == nil ||
+		err.Error() != "GraphError: Invalid data (Bookmark belongs to partition main)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
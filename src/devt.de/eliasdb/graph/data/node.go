@@ -16,6 +16,7 @@ import (
 	"fmt"
 	"sort"
 	"strconv"
+	"time"
 )
 
 /*
@@ -212,6 +213,13 @@ func createIndexMap(gn *graphNode, attFilter func(attr string) bool) map[string]
 
 				ret[attr] = st
 
+			} else if _, ok := val.(time.Time); ok {
+
+				// Timestamps are indexed in the same format which is used
+				// to compare them with strings
+
+				ret[attr] = ValueString(val)
+
 			} else if st, ok := val.(fmt.Stringer); ok {
 
 				// Value has a proper string representation - use that
@@ -13,7 +13,8 @@ package data
 import "devt.de/common/datautil"
 
 /*
-NodeCompare compares node attributes.
+NodeCompare compares node attributes. Attribute values are compared by
+content.
 */
 func NodeCompare(node1 Node, node2 Node, attrs []string) bool {
 
@@ -30,7 +31,7 @@ func NodeCompare(node1 Node, node2 Node, attrs []string) bool {
 	}
 
 	for _, attr := range attrs {
-		if node1.Attr(attr) != node2.Attr(attr) {
+		if !valuesEqual(node1.Attr(attr), node2.Attr(attr)) {
 			return false
 		}
 	}
@@ -0,0 +1,209 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package data
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+
+	// Timestamps can be stored as attribute values
+
+	gob.Register(time.Time{})
+}
+
+/*
+TimeFormats are the formats which are tried when a string is compared with
+a timestamp.
+*/
+var TimeFormats = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+/*
+ValueString returns the string representation of an attribute value.
+Timestamps are represented in RFC3339 format and byte slices by their
+content.
+*/
+func ValueString(v interface{}) string {
+
+	switch tv := v.(type) {
+	case string:
+		return tv
+	case time.Time:
+		return tv.Format(time.RFC3339Nano)
+	case []byte:
+		return string(tv)
+	}
+
+	return fmt.Sprint(v)
+}
+
+/*
+ValueNumber returns the numeric value of an attribute value. Timestamps are
+converted to seconds since the epoch and strings are parsed. Returns false
+if the value is not a number.
+*/
+func ValueNumber(v interface{}) (float64, bool) {
+
+	switch tv := v.(type) {
+	case nil, bool:
+		return 0, false
+	case string:
+		num, err := strconv.ParseFloat(tv, 64)
+		return num, err == nil
+	case time.Time:
+		return float64(tv.UnixNano()) / float64(time.Second), true
+	}
+
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+
+	return 0, false
+}
+
+/*
+ValueTime returns the timestamp of an attribute value. Strings are parsed
+using the formats in TimeFormats. Returns false if the value is not a
+timestamp.
+*/
+func ValueTime(v interface{}) (time.Time, bool) {
+
+	switch tv := v.(type) {
+	case time.Time:
+		return tv, true
+	case string:
+		for _, f := range TimeFormats {
+			if t, err := time.Parse(f, tv); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+/*
+CompareValues compares two attribute values. Timestamps are compared as
+timestamps, numbers (also numbers in strings) as numbers, booleans as
+booleans and byte slices by their content. All other values are compared by
+their string representation. Returns -1 if v1 is smaller, 1 if v1 is greater
+and 0 if both values are equal.
+*/
+func CompareValues(v1 interface{}, v2 interface{}) int {
+
+	_, isTime1 := v1.(time.Time)
+	_, isTime2 := v2.(time.Time)
+
+	if isTime1 || isTime2 {
+		if t1, ok := ValueTime(v1); ok {
+			if t2, ok := ValueTime(v2); ok {
+				if t1.Before(t2) {
+					return -1
+				} else if t1.After(t2) {
+					return 1
+				}
+				return 0
+			}
+		}
+	}
+
+	// Integers are compared exactly - a float64 cannot represent all int64 values
+
+	if i1, ok := valueInt(v1); ok {
+		if i2, ok := valueInt(v2); ok {
+			if i1 < i2 {
+				return -1
+			} else if i1 > i2 {
+				return 1
+			}
+			return 0
+		}
+	}
+
+	if num1, ok := ValueNumber(v1); ok {
+		if num2, ok := ValueNumber(v2); ok {
+			if num1 < num2 {
+				return -1
+			} else if num1 > num2 {
+				return 1
+			}
+			return 0
+		}
+	}
+
+	if b1, ok := v1.(bool); ok {
+		if b2, ok := v2.(bool); ok {
+			if b1 == b2 {
+				return 0
+			} else if !b1 {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	if b1, ok := v1.([]byte); ok {
+		if b2, ok := v2.([]byte); ok {
+			return bytes.Compare(b1, b2)
+		}
+	}
+
+	return strings.Compare(ValueString(v1), ValueString(v2))
+}
+
+/*
+valueInt returns the value of a signed integer or a string which contains a
+signed integer.
+*/
+func valueInt(v interface{}) (int64, bool) {
+
+	if s, ok := v.(string); ok {
+		i, err := strconv.ParseInt(s, 10, 64)
+		return i, err == nil
+	}
+
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	}
+
+	return 0, false
+}
+
+/*
+valuesEqual checks if two attribute values are equal. Timestamps are equal if
+they represent the same time instant.
+*/
+func valuesEqual(v1 interface{}, v2 interface{}) bool {
+
+	if t1, ok := v1.(time.Time); ok {
+		t2, ok := v2.(time.Time)
+		return ok && t1.Equal(t2)
+	}
+
+	return reflect.DeepEqual(v1, v2)
+}
@@ -0,0 +1,167 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValueString(t *testing.T) {
+	ts := time.Date(2016, 5, 17, 10, 30, 0, 5, time.UTC)
+
+	for _, test := range []struct {
+		val      interface{}
+		expected string
+	}{
+		{"abc", "abc"},
+		{int64(5), "5"},
+		{0.5, "0.5"},
+		{true, "true"},
+		{ts, "2016-05-17T10:30:00.000000005Z"},
+		{[]byte("abc"), "abc"},
+		{nil, "<nil>"},
+	} {
+		if res := ValueString(test.val); res != test.expected {
+			t.Error("Unexpected result:", res, test.expected)
+			return
+		}
+	}
+}
+
+func TestValueNumberAndTime(t *testing.T) {
+	ts := time.Date(2016, 5, 17, 10, 30, 0, 500000000, time.UTC)
+
+	for _, test := range []struct {
+		val      interface{}
+		expected float64
+		ok       bool
+	}{
+		{"1.5", 1.5, true},
+		{"abc", 0, false},
+		{int64(5), 5, true},
+		{uint8(5), 5, true},
+		{float32(0.5), 0.5, true},
+		{ts, 1463481000.5, true},
+		{true, 0, false},
+		{nil, 0, false},
+		{[]byte("5"), 0, false},
+	} {
+		if res, ok := ValueNumber(test.val); res != test.expected || ok != test.ok {
+			t.Error("Unexpected result:", test.val, res, ok)
+			return
+		}
+	}
+
+	if res, ok := ValueTime("2016-05-17"); !ok || !res.Equal(time.Date(2016, 5, 17, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Unexpected result:", res, ok)
+		return
+	}
+
+	if res, ok := ValueTime(ts); !ok || res != ts {
+		t.Error("Unexpected result:", res, ok)
+		return
+	}
+
+	if _, ok := ValueTime("17/05/2016"); ok {
+		t.Error("Unexpected result")
+		return
+	}
+
+	if _, ok := ValueTime(5); ok {
+		t.Error("Unexpected result")
+		return
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	ts := time.Date(2016, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		val1     interface{}
+		val2     interface{}
+		expected int
+	}{
+
+		// Numbers are not compared lexicographically
+
+		{int64(9), int64(10), -1},
+		{"9", int64(10), -1},
+		{9.5, "10", -1},
+		{uint(10), 9.5, 1},
+		{int64(5), 5.0, 0},
+
+		// Large integers are compared exactly
+
+		{int64(1)<<60 + 1, int64(1) << 60, 1},
+		{"1152921504606846977", int64(1)<<60 + 1, 0},
+
+		// Timestamps can be compared with strings
+
+		{ts, ts.Add(time.Second), -1},
+		{ts, "2016-05-17", 1},
+		{"2016-05-17T10:30:00Z", ts, 0},
+		{ts, "abc", -1},
+
+		{false, true, -1},
+		{true, true, 0},
+		{[]byte{1, 2}, []byte{1, 3}, -1},
+		{"b", "a", 1},
+		{"abc", int64(5), 1},
+		{nil, "a", -1},
+	} {
+		if res := CompareValues(test.val1, test.val2); res != test.expected {
+			t.Error("Unexpected result:", test.val1, test.val2, res, test.expected)
+			return
+		}
+	}
+}
+
+func TestNodeCompareTypedValues(t *testing.T) {
+	ts := time.Date(2016, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	gn1 := NewGraphNode()
+	gn1.SetAttr("blob", []byte{1, 2})
+	gn1.SetAttr("created", ts)
+
+	gn2 := NewGraphNode()
+	gn2.SetAttr("blob", []byte{1, 2})
+	gn2.SetAttr("created", ts.In(time.FixedZone("test", 3600)))
+
+	if !NodeCompare(gn1, gn2, nil) {
+		t.Error("Unexpected compare result")
+		return
+	}
+
+	gn2.SetAttr("blob", []byte{1, 3})
+
+	if NodeCompare(gn1, gn2, nil) {
+		t.Error("Unexpected compare result")
+		return
+	}
+
+	gn1.SetAttr("created", "2016-05-17T10:30:00Z")
+
+	if NodeCompare(gn1, gn2, []string{"created"}) {
+		t.Error("Unexpected compare result")
+		return
+	}
+
+	if res := gn2.IndexMap()["created"]; res != "2016-05-17T11:30:00+01:00" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := NodeClone(gn2).Attr("created").(time.Time); !res.Equal(ts) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"devt.de/eliasdb/graph/util"
+)
+
+/*
+DefinitionsVersion is the version of the definitions document format
+*/
+const DefinitionsVersion = 1
+
+/*
+Definitions is a declarative document which describes the integrity rules
+of a graph database. A definitions document can be kept under version control
+and applied to a database to reproduce an environment. The JSON form of the
+document is:
+
+	{
+		"version" : 1,
+		"invariants" : [ { "name" : <name>, "kind" : <kind>, "spec" : <spec>,
+		                   "min" : <min>, "max" : <max> }, ... ]
+	}
+
+The max value of an invariant defaults to -1 (no limit).
+*/
+type Definitions struct {
+	Version    int          `json:"version"`    // Version of the document format
+	Invariants []*Invariant `json:"invariants"` // Declared invariants
+}
+
+/*
+DefinitionChanges lists the changes which were made when definitions were
+applied. Every entry has the form <type>:<name> (e.g. invariant:hasOwner).
+*/
+type DefinitionChanges struct {
+	Created   []string `json:"created"`   // Definitions which were created
+	Updated   []string `json:"updated"`   // Definitions which were changed
+	Removed   []string `json:"removed"`   // Definitions which were removed
+	Unchanged []string `json:"unchanged"` // Definitions which were already up to date
+}
+
+/*
+ParseDefinitions reads a definitions document in JSON. Unknown fields are
+rejected so misspelled or unsupported definitions are not silently ignored.
+*/
+func ParseDefinitions(r io.Reader) (*Definitions, error) {
+	var doc struct {
+		Version    int               `json:"version"`
+		Invariants []json.RawMessage `json:"invariants"`
+	}
+
+	defError := func(detail string) error {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: "Invalid definitions: " + detail,
+		}
+	}
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&doc); err != nil {
+		return nil, defError(err.Error())
+	} else if doc.Version < 1 || doc.Version > DefinitionsVersion {
+		return nil, defError(fmt.Sprintf("Unsupported version %v", doc.Version))
+	}
+
+	defs := &Definitions{doc.Version, make([]*Invariant, 0, len(doc.Invariants))}
+
+	for _, raw := range doc.Invariants {
+		inv := &Invariant{Max: -1}
+
+		if err := json.Unmarshal(raw, inv); err != nil {
+			return nil, defError(err.Error())
+		}
+
+		defs.Invariants = append(defs.Invariants, inv)
+	}
+
+	return defs, nil
+}
+
+/*
+Definitions returns a definitions document which describes the current
+integrity rules of the graph database.
+*/
+func (gm *Manager) Definitions() *Definitions {
+	invs := gm.Invariants()
+
+	if invs == nil {
+		invs = []*Invariant{}
+	}
+
+	return &Definitions{DefinitionsVersion, invs}
+}
+
+/*
+ApplyDefinitions makes the integrity rules of the graph database match a given
+definitions document. Applying the same document more than once has no further
+effect. Existing definitions which are not part of the document are only
+removed if the prune flag is set. All definitions are checked before anything
+is changed. If the dryRun flag is set the changes are only reported.
+*/
+func (gm *Manager) ApplyDefinitions(defs *Definitions, prune bool, dryRun bool) (*DefinitionChanges, error) {
+
+	changes := &DefinitionChanges{[]string{}, []string{}, []string{}, []string{}}
+
+	// Check all definitions before anything is changed
+
+	names := make(map[string]bool)
+
+	for _, inv := range defs.Invariants {
+		if err := validateInvariant(inv); err != nil {
+			return nil, err
+		} else if names[inv.Name] {
+			return nil, &util.GraphError{
+				Type:   util.ErrInvalidData,
+				Detail: fmt.Sprintf("Invalid definitions: Duplicate invariant %v", inv.Name),
+			}
+		}
+
+		names[inv.Name] = true
+	}
+
+	existing := make(map[string]*Invariant)
+
+	for _, inv := range gm.Invariants() {
+		existing[inv.Name] = inv
+	}
+
+	for _, inv := range defs.Invariants {
+		entry := "invariant:" + inv.Name
+
+		if old, ok := existing[inv.Name]; ok && *old == *inv {
+			changes.Unchanged = append(changes.Unchanged, entry)
+			continue
+		} else if ok {
+			changes.Updated = append(changes.Updated, entry)
+		} else {
+			changes.Created = append(changes.Created, entry)
+		}
+
+		if !dryRun {
+			if err := gm.SetInvariant(inv); err != nil {
+				return changes, err
+			}
+		}
+	}
+
+	if prune {
+		for _, inv := range gm.Invariants() {
+			if names[inv.Name] {
+				continue
+			}
+
+			changes.Removed = append(changes.Removed, "invariant:"+inv.Name)
+
+			if !dryRun {
+				if _, err := gm.RemoveInvariant(inv.Name); err != nil {
+					return changes, err
+				}
+			}
+		}
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,130 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestDefinitions(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	gm.SetInvariant(&Invariant{"old", "Order", ":PlacedBy::", 0, 1})
+	gm.SetInvariant(&Invariant{"hasOwner", "Car", ":Owner::", 1, 1})
+
+	defs, err := ParseDefinitions(strings.NewReader(`{
+  "version" : 1,
+  "invariants" : [
+    { "name" : "hasOwner", "kind" : "Car", "spec" : ":Owner::", "min" : 1, "max" : 1 },
+    { "name" : "hasCustomer", "kind" : "Order", "spec" : ":PlacedBy::Customer", "min" : 1 }
+  ]
+}`))
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if defs.Invariants[1].Max != -1 {
+		t.Error("Unexpected default max value:", defs.Invariants[1])
+		return
+	}
+
+	// A dry run reports the changes without applying them
+
+	changes, err := gm.ApplyDefinitions(defs, true, true)
+	if err != nil || fmt.Sprint(changes) !=
+		"&{[invariant:hasCustomer] [] [invariant:old] [invariant:hasOwner]}" {
+		t.Error("Unexpected result:", changes, err)
+		return
+	}
+
+	if len(gm.Invariants()) != 2 || gm.Invariants()[1].Name != "old" {
+		t.Error("Unexpected invariants:", gm.Invariants())
+		return
+	}
+
+	// Apply without pruning
+
+	changes, err = gm.ApplyDefinitions(defs, false, false)
+	if err != nil || fmt.Sprint(changes) !=
+		"&{[invariant:hasCustomer] [] [] [invariant:hasOwner]}" {
+		t.Error("Unexpected result:", changes, err)
+		return
+	}
+
+	// Applying the same definitions again changes nothing
+
+	defs.Invariants[0].Max = 2
+
+	changes, err = gm.ApplyDefinitions(defs, true, false)
+	if err != nil || fmt.Sprint(changes) !=
+		"&{[] [invariant:hasOwner] [invariant:old] [invariant:hasCustomer]}" {
+		t.Error("Unexpected result:", changes, err)
+		return
+	}
+
+	changes, err = gm.ApplyDefinitions(defs, true, false)
+	if err != nil || fmt.Sprint(changes) !=
+		"&{[] [] [] [invariant:hasOwner invariant:hasCustomer]}" {
+		t.Error("Unexpected result:", changes, err)
+		return
+	}
+
+	// The current definitions can be exported
+
+	if res := gm.Definitions(); res.Version != DefinitionsVersion || len(res.Invariants) != 2 ||
+		fmt.Sprint(res.Invariants[1]) != "&{hasOwner Car :Owner:: 1 2}" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Invalid definitions are not applied
+
+	defs.Invariants = append(defs.Invariants, &Invariant{"a b", "Car", ":::", 0, -1})
+
+	if _, err = gm.ApplyDefinitions(defs, true, false); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid invariant a b: Name must be alphanumeric)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	defs.Invariants[2] = &Invariant{"hasOwner", "Car", ":::", 0, -1}
+
+	if _, err = gm.ApplyDefinitions(defs, true, false); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid definitions: Duplicate invariant hasOwner)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err = ParseDefinitions(strings.NewReader(`{ "version" : 1, "indexes" : [] }`)); err == nil ||
+		err.Error() != `GraphError: Invalid data (Invalid definitions: json: unknown field "indexes")` {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err = ParseDefinitions(strings.NewReader(`{ "version" : 2 }`)); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid definitions: Unsupported version 2)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err = ParseDefinitions(strings.NewReader(`{ "version" : 1, "invariants" : [ 1 ] }`)); err == nil ||
+		!strings.HasPrefix(err.Error(), "GraphError: Invalid data (Invalid definitions: json: cannot unmarshal") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,303 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"devt.de/eliasdb/graph/data"
+)
+
+/*
+EdgeKindStats contains statistics about the edges of a certain kind which
+connect nodes of a source kind with nodes of a target kind in a partition.
+Edges are counted in both directions.
+*/
+type EdgeKindStats struct {
+	Part       string // Partition of the edges
+	SourceKind string // Kind of the source nodes
+	EdgeKind   string // Kind of the edges
+	TargetKind string // Kind of the target nodes
+	Count      uint64 // Number of edges
+	Sources    uint64 // Number of nodes of the source kind
+}
+
+/*
+FanOut returns the average number of edges per source node.
+*/
+func (s *EdgeKindStats) FanOut() float64 {
+	if s.Sources == 0 {
+		return 0
+	}
+	return float64(s.Count) / float64(s.Sources)
+}
+
+/*
+edgeStatsCollector collects and maintains edge kind statistics. Statistics of
+a partition are collected on first request and are then kept up-to-date with
+every node and edge mutation.
+*/
+type edgeStatsCollector struct {
+	gm    *Manager                        // Manager which is used to collect the statistics
+	edges map[string]map[[3]string]uint64 // Edge counts for each partition
+	nodes map[string]map[string]uint64    // Node counts for each partition
+	mutex *sync.Mutex                     // Mutex to protect the collector
+}
+
+/*
+newEdgeStatsCollector creates a new statistics collector.
+*/
+func newEdgeStatsCollector(gm *Manager) *edgeStatsCollector {
+	return &edgeStatsCollector{gm, make(map[string]map[[3]string]uint64),
+		make(map[string]map[string]uint64), &sync.Mutex{}}
+}
+
+/*
+get returns the statistics for a given partition. Statistics are collected if
+they are not available.
+*/
+func (c *edgeStatsCollector) get(part string) ([]*EdgeKindStats, error) {
+	c.mutex.Lock()
+	_, ok := c.edges[part]
+	c.mutex.Unlock()
+
+	if !ok {
+		if err := c.refresh(part); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ret := make([]*EdgeKindStats, 0, len(c.edges[part]))
+
+	for k, count := range c.edges[part] {
+		if count > 0 {
+			ret = append(ret, &EdgeKindStats{part, k[0], k[1], k[2], count, c.nodes[part][k[0]]})
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SourceKind != ret[j].SourceKind {
+			return ret[i].SourceKind < ret[j].SourceKind
+		} else if ret[i].EdgeKind != ret[j].EdgeKind {
+			return ret[i].EdgeKind < ret[j].EdgeKind
+		}
+		return ret[i].TargetKind < ret[j].TargetKind
+	})
+
+	return ret, nil
+}
+
+/*
+refresh collects the statistics for a given partition by traversing from
+every node of the partition.
+*/
+func (c *edgeStatsCollector) refresh(part string) error {
+	edges := make(map[[3]string]uint64)
+	nodes := make(map[string]uint64)
+
+	for _, kind := range c.gm.NodeKinds() {
+
+		it, err := c.gm.NodeKeyIterator(part, kind)
+		if err != nil {
+			return err
+		} else if it == nil {
+			continue
+		}
+
+		for it.HasNext() {
+			key := it.Next()
+
+			if it.LastError != nil {
+				return it.LastError
+			}
+
+			nodes[kind]++
+
+			_, traversed, err := c.gm.TraverseMulti(part, key, kind, ":::", false)
+			if err != nil {
+				return err
+			}
+
+			for _, edge := range traversed {
+				edges[[3]string{kind, edge.Kind(), edge.End2Kind()}]++
+			}
+		}
+	}
+
+	c.mutex.Lock()
+	c.edges[part] = edges
+	c.nodes[part] = nodes
+	c.mutex.Unlock()
+
+	return nil
+}
+
+/*
+fanOut estimates the number of nodes which are reached from a node of a given
+kind with a given traversal spec. Roles are not considered by the estimate.
+Returns -1 if no statistics are available for the partition.
+*/
+func (c *edgeStatsCollector) fanOut(part string, kind string, spec string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	edges, ok := c.edges[part]
+	if !ok {
+		return -1
+	}
+
+	sources := c.nodes[part][kind]
+	if sources == 0 {
+		return 0
+	}
+
+	sspec := strings.Split(spec, ":")
+	if len(sspec) != 4 {
+		return -1
+	}
+
+	var count uint64
+
+	for k, ecount := range edges {
+		if k[0] == kind && (sspec[1] == "" || sspec[1] == k[1]) &&
+			(sspec[3] == "" || sspec[3] == k[2]) {
+			count += ecount
+		}
+	}
+
+	return float64(count) / float64(sources)
+}
+
+/*
+updateNode updates the node count of a node kind.
+*/
+func (c *edgeStatsCollector) updateNode(part string, kind string, delta int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if nodes, ok := c.nodes[part]; ok {
+		nodes[kind] = addDelta(nodes[kind], delta)
+	}
+}
+
+/*
+updateEdge updates the edge counts of a given edge in both directions.
+*/
+func (c *edgeStatsCollector) updateEdge(part string, edge data.Edge, delta int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if edges, ok := c.edges[part]; ok {
+		k1 := [3]string{edge.End1Kind(), edge.Kind(), edge.End2Kind()}
+		k2 := [3]string{edge.End2Kind(), edge.Kind(), edge.End1Kind()}
+
+		edges[k1] = addDelta(edges[k1], delta)
+		edges[k2] = addDelta(edges[k2], delta)
+	}
+}
+
+/*
+addDelta adds a delta to a counter. The counter never drops below 0.
+*/
+func addDelta(count uint64, delta int) uint64 {
+	if delta < 0 && count < uint64(-delta) {
+		return 0
+	}
+	return uint64(int64(count) + int64(delta))
+}
+
+/*
+EdgeKindStats returns statistics about the edges in a partition. There is one
+entry for every combination of source node kind, edge kind and target node
+kind. The statistics are collected on first request and are then kept
+up-to-date.
+*/
+func (gm *Manager) EdgeKindStats(part string) ([]*EdgeKindStats, error) {
+	return gm.edgeStats.get(part)
+}
+
+/*
+RefreshEdgeKindStats collects the statistics about the edges in a partition
+immediately.
+*/
+func (gm *Manager) RefreshEdgeKindStats(part string) ([]*EdgeKindStats, error) {
+	if err := gm.edgeStats.refresh(part); err != nil {
+		return nil, err
+	}
+	return gm.edgeStats.get(part)
+}
+
+/*
+TraversalFanOut estimates the average number of nodes which are reached from
+a node of a given kind with a given traversal spec. The estimate is based on
+the edge kind statistics of the partition. Returns -1 if no statistics have
+been collected for the partition.
+*/
+func (gm *Manager) TraversalFanOut(part string, kind string, spec string) float64 {
+	return gm.edgeStats.fanOut(part, kind, spec)
+}
+
+// System rule SystemRuleUpdateEdgeKindStats
+// =========================================
+
+/*
+SystemRuleUpdateEdgeKindStats is a system rule which keeps edge kind
+statistics up-to-date.
+*/
+type SystemRuleUpdateEdgeKindStats struct {
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (r *SystemRuleUpdateEdgeKindStats) Name() string {
+	return "system.updateedgekindstats"
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (r *SystemRuleUpdateEdgeKindStats) Handles() []int {
+	return []int{EventNodeCreated, EventNodeDeleted, EventEdgeCreated,
+		EventEdgeUpdated, EventEdgeDeleted}
+}
+
+/*
+Handle handles an event.
+*/
+func (r *SystemRuleUpdateEdgeKindStats) Handle(gm *Manager, trans *Trans, event int, ed ...interface{}) error {
+	part := ed[0].(string)
+
+	switch event {
+	case EventNodeCreated:
+		gm.edgeStats.updateNode(part, ed[1].(data.Node).Kind(), 1)
+
+	case EventNodeDeleted:
+		gm.edgeStats.updateNode(part, ed[1].(data.Node).Kind(), -1)
+
+	case EventEdgeCreated:
+		gm.edgeStats.updateEdge(part, ed[1].(data.Edge), 1)
+
+	case EventEdgeUpdated:
+		gm.edgeStats.updateEdge(part, ed[2].(data.Edge), -1)
+		gm.edgeStats.updateEdge(part, ed[1].(data.Edge), 1)
+
+	case EventEdgeDeleted:
+		gm.edgeStats.updateEdge(part, ed[1].(data.Edge), -1)
+	}
+
+	return nil
+}
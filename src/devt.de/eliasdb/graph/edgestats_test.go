@@ -0,0 +1,182 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestEdgeKindStats(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	storeNode := func(key string, kind string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", kind)
+		gm.StoreNode("main", node)
+		return node
+	}
+
+	storeEdge := func(key string, kind string, node1 data.Node, node2 data.Node) data.Edge {
+		edge := data.NewGraphEdge()
+
+		edge.SetAttr("key", key)
+		edge.SetAttr("kind", kind)
+
+		edge.SetAttr(data.EdgeEnd1Key, node1.Key())
+		edge.SetAttr(data.EdgeEnd1Kind, node1.Kind())
+		edge.SetAttr(data.EdgeEnd1Role, "src")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+
+		edge.SetAttr(data.EdgeEnd2Key, node2.Key())
+		edge.SetAttr(data.EdgeEnd2Kind, node2.Kind())
+		edge.SetAttr(data.EdgeEnd2Role, "dst")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+		if err := gm.StoreEdge("main", edge); err != nil {
+			t.Error(err)
+		}
+
+		return edge
+	}
+
+	a1 := storeNode("a1", "Author")
+	a2 := storeNode("a2", "Author")
+	s1 := storeNode("s1", "Song")
+	s2 := storeNode("s2", "Song")
+	s3 := storeNode("s3", "Song")
+	l1 := storeNode("l1", "Label")
+
+	storeEdge("e1", "Wrote", a1, s1) // Update of an existing edge
+	storeEdge("e2", "Wrote", a1, s2)
+	storeEdge("e3", "Wrote", a2, s3)
+	storeEdge("e4", "Signed", l1, a1)
+
+	// No statistics are available before they have been requested
+
+	if res := gm.TraversalFanOut("main", "Author", ":Wrote::Song"); res != -1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	statsString := func(stats []*EdgeKindStats) string {
+		var ret string
+		for _, s := range stats {
+			ret += fmt.Sprintf("%v-%v-%v:%v/%v\n", s.SourceKind, s.EdgeKind,
+				s.TargetKind, s.Count, s.Sources)
+		}
+		return ret
+	}
+
+	stats, err := gm.EdgeKindStats("main")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := statsString(stats); res != `
+Author-Signed-Label:1/2
+Author-Wrote-Song:3/2
+Label-Signed-Author:1/1
+Song-Wrote-Author:3/3
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := stats[1].FanOut(); res != 1.5 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm.TraversalFanOut("main", "Author", ":Wrote::Song"); res != 1.5 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm.TraversalFanOut("main", "Author", ":::"); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm.TraversalFanOut("main", "Label", ":Wrote::"); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm.TraversalFanOut("main", "Foo", ":::"); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm.TraversalFanOut("main", "Author", "foo"); res != -1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Statistics are kept up-to-date
+
+	s4 := storeNode("s4", "Song")
+	storeEdge("e5", "Wrote", a2, s4)
+	storeEdge("e6", "Wrote", a2, s1)
+	storeEdge("e1", "Wrote", a1, s1) // Update of an existing edge
+
+	if _, err := gm.RemoveEdge("main", "e4", "Signed"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats, _ = gm.EdgeKindStats("main")
+
+	if res := statsString(stats); res != `
+Author-Wrote-Song:5/2
+Song-Wrote-Author:5/4
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := gm.RemoveNode("main", "s2", "Song"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats, _ = gm.EdgeKindStats("main")
+
+	if res := statsString(stats); res != `
+Author-Wrote-Song:4/2
+Song-Wrote-Author:4/3
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Refreshing gives the same result
+
+	stats, err = gm.RefreshEdgeKindStats("main")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := statsString(stats); res != `
+Author-Wrote-Song:4/2
+Song-Wrote-Author:4/3
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
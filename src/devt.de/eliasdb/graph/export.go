@@ -0,0 +1,311 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+/*
+ExportPartition writes the contents of a partition as JSON to a given writer.
+The data has the following format:
+
+	{
+		nodes : [ { <attr> : <value> }, ... ]
+		edges : [ { <attr> : <value> }, ... ]
+	}
+*/
+func ExportPartition(out io.Writer, part string, gm *Manager) error {
+	return exportPartition(out, part, gm, nil)
+}
+
+/*
+ExportPartitionCompat writes the contents of a partition as JSON to a given
+writer using only features which are understood by the previous major version
+of EliasDB. Attribute values which are not plain JSON values are mapped to
+their JSON representation (e.g. timestamps become strings) and values which
+cannot be represented are dropped. Returns a warning for every mapped or
+dropped value.
+*/
+func ExportPartitionCompat(out io.Writer, part string, gm *Manager) ([]string, error) {
+	var warnings []string
+
+	err := exportPartition(out, part, gm, func(entity string, data map[string]interface{},
+		attr string, v interface{}) (interface{}, bool) {
+
+		cv, mapped, ok := compatValue(v)
+
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%v %v (%v) attribute %v: dropped %T value",
+				entity, data["key"], data["kind"], attr, v))
+		} else if mapped {
+			warnings = append(warnings, fmt.Sprintf("%v %v (%v) attribute %v: mapped %T value",
+				entity, data["key"], data["kind"], attr, v))
+		}
+
+		return cv, ok
+	})
+
+	return warnings, err
+}
+
+/*
+maxCompatInt is the largest integer which can be represented exactly by a JSON
+number in previous versions
+*/
+const maxCompatInt = 1 << 53
+
+/*
+compatValue converts an attribute value to a plain JSON value (nil, bool,
+string, number, list or map). Returns the converted value, if the value had to
+be mapped and if the value can be represented at all.
+*/
+func compatValue(v interface{}) (interface{}, bool, bool) {
+
+	switch tv := v.(type) {
+	case nil, bool, string, float64, float32:
+		return v, false, true
+
+	case []interface{}:
+		ret := make([]interface{}, 0, len(tv))
+		mapped := false
+
+		for _, e := range tv {
+			ce, m, ok := compatValue(e)
+			if !ok {
+				return nil, false, false
+			}
+			ret = append(ret, ce)
+			mapped = mapped || m
+		}
+
+		return ret, mapped, true
+
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(tv))
+		mapped := false
+
+		for k, e := range tv {
+			ce, m, ok := compatValue(e)
+			if !ok {
+				return nil, false, false
+			}
+			ret[k] = ce
+			mapped = mapped || m
+		}
+
+		return ret, mapped, true
+	}
+
+	// Integers are kept as long as they can be represented exactly
+
+	rv := reflect.ValueOf(v)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i := rv.Int(); i > maxCompatInt || i < -maxCompatInt {
+			return fmt.Sprint(i), true, true
+		}
+		return v, false, true
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i := rv.Uint(); i > maxCompatInt {
+			return fmt.Sprint(i), true, true
+		}
+		return v, false, true
+	}
+
+	// All other values are mapped to their JSON representation
+
+	jv, err := json.Marshal(v)
+	if err != nil {
+		return nil, false, false
+	}
+
+	var ret interface{}
+
+	if err := json.Unmarshal(jv, &ret); err != nil {
+		return nil, false, false
+	}
+
+	return ret, true, true
+}
+
+/*
+exportPartition writes the contents of a partition as JSON to a given writer.
+An optional convert function can change or drop attribute values before they
+are written.
+*/
+func exportPartition(out io.Writer, part string, gm *Manager,
+	convert func(entity string, data map[string]interface{}, attr string, v interface{}) (interface{}, bool)) error {
+
+	// Use a map to unique found edge keys
+
+	edgeKeys := make(map[string]string)
+
+	// Buffer all writes - the first write error is returned by Flush
+
+	outFile := bufio.NewWriter(out)
+
+	writeData := func(entity string, data map[string]interface{}) {
+
+		if convert != nil {
+			cdata := make(map[string]interface{}, len(data))
+
+			// Convert attributes in a stable order so warnings are reproducible
+
+			attrs := make([]string, 0, len(data))
+			for k := range data {
+				attrs = append(attrs, k)
+			}
+			sort.Strings(attrs)
+
+			for _, k := range attrs {
+				if cv, ok := convert(entity, data, k, data[k]); ok {
+					cdata[k] = cv
+				}
+			}
+
+			data = cdata
+		}
+
+		writeExportAttrs(outFile, data)
+	}
+
+	// Iterate over all available node kinds
+
+	outFile.WriteString(`{
+  "nodes" : [
+`)
+
+	kinds := gm.NodeKinds()
+	for ik, kind := range kinds {
+
+		// Loop over all available kinds
+
+		it, err := gm.NodeKeyIterator(part, kind)
+		if err != nil {
+			return err
+		} else if it == nil {
+
+			// The partition has no nodes of this kind
+
+			continue
+		}
+
+		// Iterate over all node keys
+
+		for i := 0; it.HasNext(); i++ {
+			key := it.Next()
+
+			if it.LastError != nil {
+				return it.LastError
+			}
+
+			node, err := gm.FetchNode(part, key, kind)
+			if err != nil {
+				return err
+			}
+
+			// Fetch all connected relationships and store their key and kind
+
+			_, edges, err := gm.TraverseMulti(part, key, kind, ":::", false)
+			if err != nil {
+				return err
+			}
+
+			for _, edge := range edges {
+				edgeKeys[edge.Kind()+edge.Key()] = edge.Kind()
+			}
+
+			// Write out JSON object
+
+			outFile.WriteString("    {\n")
+
+			writeData("Node", node.Data())
+
+			if it.HasNext() || ik < len(kinds)-1 {
+				outFile.WriteString("    },\n")
+			} else {
+				outFile.WriteString("    }\n")
+			}
+		}
+	}
+
+	outFile.WriteString(`  ],
+  "edges" : [
+`)
+
+	// Iterate over all available edge kinds
+
+	ie := 0
+	for key, kind := range edgeKeys {
+		key = key[len(kind):]
+
+		edge, err := gm.FetchEdge(part, key, kind)
+		if err != nil {
+			return err
+		}
+
+		// Write out JSON object
+
+		outFile.WriteString("    {\n")
+
+		writeData("Edge", edge.Data())
+
+		if ie < len(edgeKeys)-1 {
+			outFile.WriteString("    },\n")
+		} else {
+			outFile.WriteString("    }\n")
+		}
+
+		ie++
+	}
+
+	outFile.WriteString(`  ]
+}
+`)
+
+	return outFile.Flush()
+}
+
+/*
+writeExportAttrs writes the attributes of a node or edge as JSON.
+*/
+func writeExportAttrs(outFile *bufio.Writer, data map[string]interface{}) {
+	nk := 0
+	for k, v := range data {
+
+		// JSON encode value - ignore values which cannot be JSON encoded
+
+		jv, err := json.Marshal(v)
+
+		// Encoding errors result in a null value
+
+		if err != nil {
+			jv = []byte("null")
+		}
+
+		// Write out the node attributes
+
+		outFile.WriteString(fmt.Sprintf("      \"%s\" : %s", k, jv))
+		if nk < len(data)-1 {
+			outFile.WriteString(",")
+		}
+		outFile.WriteString("\n")
+		nk++
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"devt.de/common/testutil"
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestExportPartition(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "Person")
+	gm.StoreNode("main", node)
+
+	var buf bytes.Buffer
+
+	if err := ExportPartition(&buf, "main", gm); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res map[string][]map[string]interface{}
+
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil ||
+		fmt.Sprint(res) != "map[edges:[] nodes:[map[key:123 kind:Person]]]" {
+		t.Error("Unexpected result:", buf.String(), err)
+		return
+	}
+
+	// Write errors are returned
+
+	if err := ExportPartition(&testutil.ErrorTestingBuffer{RemainingSize: 5}, "main", gm); err == nil ||
+		err.Error() != "Buffer is full at: 5" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestExportPartitionCompat(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "Person")
+	node.SetAttr("age", 42)
+	node.SetAttr("big", int64(1)<<60)
+	node.SetAttr("born", time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC))
+	node.SetAttr("func", func() {})
+	node.SetAttr("list", []interface{}{"a", 1, uint64(1) << 60})
+	node.SetAttr("map", map[string]interface{}{"a": []string{"b"}})
+	node.SetAttr("bad", []interface{}{"a", func() {}})
+	gm.StoreNode("main", node)
+
+	var buf bytes.Buffer
+
+	warnings, err := ExportPartitionCompat(&buf, "main", gm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res map[string][]map[string]interface{}
+
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil ||
+		fmt.Sprint(res) != "map[edges:[] nodes:[map[age:42 big:1152921504606846976 "+
+			"born:2016-01-02T03:04:05Z key:123 kind:Person list:[a 1 1152921504606846976] map:map[a:[b]]]]]" {
+		t.Error("Unexpected result:", buf.String(), err)
+		return
+	}
+
+	// Large numbers are written as strings
+
+	if !strings.Contains(buf.String(), `"big" : "1152921504606846976"`) {
+		t.Error("Unexpected result:", buf.String())
+		return
+	}
+
+	if res := strings.Join(warnings, "\n"); res != `
+Node 123 (Person) attribute bad: dropped []interface {} value
+Node 123 (Person) attribute big: mapped int64 value
+Node 123 (Person) attribute born: mapped time.Time value
+Node 123 (Person) attribute func: dropped func() value
+Node 123 (Person) attribute list: mapped []interface {} value
+Node 123 (Person) attribute map: mapped map[string]interface {} value`[1:] {
+		t.Error("Unexpected warnings:", res)
+		return
+	}
+
+	// Plain values do not produce warnings
+
+	node = data.NewGraphNode()
+	node.SetAttr("key", "123")
+	node.SetAttr("kind", "Person")
+	gm.StoreNode("main", node)
+
+	buf.Reset()
+
+	if warnings, err := ExportPartitionCompat(&buf, "main", gm); err != nil || len(warnings) != 0 {
+		t.Error("Unexpected result:", warnings, err)
+		return
+	}
+}
@@ -0,0 +1,539 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	"devt.de/common/stringutil"
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+EarthRadius is the mean radius of the earth in meters which is used to
+calculate distances
+*/
+const EarthRadius = 6371008.8
+
+/*
+CreateGeoIndex creates a geo index for the nodes of a kind. The latitude and
+longitude of a node (in degrees) are read from the given attributes. Nodes
+without a valid location are not indexed. The index stores the locations as
+z-order curve codes (interleaved bits of latitude and longitude) in an
+ordered index so nearby nodes can be found by scanning a few key ranges. A
+kind has at most one geo index - an existing index is replaced. The index is
+built from the existing nodes of all partitions and is maintained whenever
+nodes are stored or removed.
+*/
+func (gm *Manager) CreateGeoIndex(kind string, latAttr string, lonAttr string) error {
+
+	for _, attr := range []string{latAttr, lonAttr} {
+		if attr == "" || attr == data.NodeKey || attr == data.NodeKind || latAttr == lonAttr {
+			return &util.GraphError{
+				Type:   util.ErrInvalidData,
+				Detail: fmt.Sprintf("Attribute %v cannot be used for a geo index", attr),
+			}
+		}
+	}
+
+	return gm.changeGeoIndex(kind, []string{latAttr, lonAttr})
+}
+
+/*
+DropGeoIndex removes the geo index of a node kind.
+*/
+func (gm *Manager) DropGeoIndex(kind string) error {
+	return gm.changeGeoIndex(kind, nil)
+}
+
+/*
+GeoIndex returns the latitude and longitude attributes of the geo index of a
+node kind. Returns empty strings if the kind has no geo index.
+*/
+func (gm *Manager) GeoIndex(kind string) (string, string) {
+	if attrs := gm.geoIdx.forKind(kind); len(attrs) == 2 {
+		return attrs[0], attrs[1]
+	}
+
+	return "", ""
+}
+
+/*
+NodesNear returns all nodes of a kind in a partition which are within a given
+radius (in meters) of a location. The nodes are ordered by distance (nodes
+with the same distance are ordered by key). The kind must have a geo index.
+*/
+func (gm *Manager) NodesNear(part string, kind string, lat float64, lon float64,
+	radius float64) ([]data.Node, error) {
+
+	if len(gm.geoIdx.forKind(kind)) != 2 {
+		return nil, &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Node kind %v has no geo index", kind),
+		}
+	} else if !validLocation(lat, lon) || math.IsNaN(radius) || radius < 0 {
+		return nil, &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Invalid location %v, %v or radius %v", lat, lon, radius),
+		}
+	}
+
+	tree, err := gm.getGeoIndexTree(part, kind, false)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	// Take reader lock
+
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "NodesNear")
+
+	keys, dists, err := gm.lookupGeoIndex(tree, lat, lon, radius)
+
+	gm.mutex.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]data.Node, 0, len(keys))
+
+	for _, key := range keys {
+
+		node, err := gm.FetchNode(part, key, kind)
+		if err != nil {
+			return nil, err
+		} else if node != nil {
+			ret = append(ret, node)
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if dists[ret[i].Key()] != dists[ret[j].Key()] {
+			return dists[ret[i].Key()] < dists[ret[j].Key()]
+		}
+		return ret[i].Key() < ret[j].Key()
+	})
+
+	return ret, nil
+}
+
+/*
+lookupGeoIndex looks up the keys and distances of all nodes which are within
+a given radius of a location. The bounding box of the circle is covered by z-order
+curve cells which are at least as big as the box - every cell is a range of
+the ordered index.
+*/
+func (gm *Manager) lookupGeoIndex(tree *hash.BTree, lat float64, lon float64,
+	radius float64) ([]string, map[string]float64, error) {
+
+	var keys []string
+
+	dists := make(map[string]float64)
+	dLat := radius / EarthRadius * 180 / math.Pi
+
+	minLat, maxLat := lat-dLat, lat+dLat
+	boxes := [][]float64{}
+
+	if minLat <= -90 || maxLat >= 90 {
+
+		// The circle contains a pole - all longitudes need to be searched
+
+		boxes = append(boxes, []float64{math.Max(minLat, -90), math.Min(maxLat, 90), -180, 180})
+
+	} else {
+
+		dLon := dLat / math.Cos(math.Max(math.Abs(minLat), math.Abs(maxLat))*math.Pi/180)
+		minLon, maxLon := lon-dLon, lon+dLon
+
+		if dLon >= 180 {
+			boxes = append(boxes, []float64{minLat, maxLat, -180, 180})
+		} else if minLon < -180 {
+			boxes = append(boxes, []float64{minLat, maxLat, minLon + 360, 180},
+				[]float64{minLat, maxLat, -180, maxLon})
+		} else if maxLon > 180 {
+			boxes = append(boxes, []float64{minLat, maxLat, minLon, 180},
+				[]float64{minLat, maxLat, -180, maxLon - 360})
+		} else {
+			boxes = append(boxes, []float64{minLat, maxLat, minLon, maxLon})
+		}
+	}
+
+	cells := make(map[uint64]int)
+
+	for _, box := range boxes {
+
+		// Find the smallest cells which are at least as big as the box
+
+		level := 32
+		for level > 0 && (180/math.Exp2(float64(level)) < box[1]-box[0] ||
+			360/math.Exp2(float64(level)) < box[3]-box[2]) {
+			level--
+		}
+
+		shift := uint(32 - level)
+
+		minLatQ, minLonQ := geoQuantize(box[0], box[2])
+		maxLatQ, maxLonQ := geoQuantize(box[1], box[3])
+
+		for latQ := uint64(minLatQ >> shift); latQ <= uint64(maxLatQ>>shift); latQ++ {
+			for lonQ := uint64(minLonQ >> shift); lonQ <= uint64(maxLonQ>>shift); lonQ++ {
+				cells[geoInterleave(uint32(latQ<<shift), uint32(lonQ<<shift))] = level
+			}
+		}
+	}
+
+	for start, level := range cells {
+		var to []byte
+
+		from := make([]byte, 8)
+		binary.BigEndian.PutUint64(from, start)
+
+		if level > 0 {
+			to = make([]byte, 8)
+			binary.BigEndian.PutUint64(to, start+1<<uint(64-2*level))
+
+			if start+1<<uint(64-2*level) == 0 {
+				to = nil // The last cell reaches to the end of the index
+			}
+		}
+
+		it := hash.NewBTreeRangeIterator(tree, from, to)
+
+		for it.HasNext() {
+			k, v := it.Next()
+			loc := v.([]float64)
+
+			if dist := geoDistance(lat, lon, loc[0], loc[1]); dist <= radius {
+				key := string(k[8:])
+
+				if _, ok := dists[key]; !ok {
+					keys = append(keys, key)
+				}
+
+				dists[key] = dist
+			}
+		}
+
+		if it.LastError != nil {
+			return nil, nil, &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+		}
+	}
+
+	return keys, dists, nil
+}
+
+/*
+changeGeoIndex replaces the geo index of a node kind. The index is removed if
+no attributes are given.
+*/
+func (gm *Manager) changeGeoIndex(kind string, attrs []string) error {
+
+	if !stringutil.IsAlphaNumeric(kind) {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Node kind %v is not alphanumeric - can only contain [a-zA-Z0-9_]", kind),
+		}
+	}
+
+	// Take writer lock
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "changeGeoIndex")
+	defer gm.mutex.Unlock()
+
+	oldAttrs := gm.geoIdx.forKind(kind)
+
+	if fmt.Sprint(oldAttrs) == fmt.Sprint(attrs) {
+		return nil
+	}
+
+	if err := gm.buildGeoIndex(kind, attrs); err != nil {
+		return err
+	}
+
+	return gm.storeIndexedAttrs(gm.geoIdx, kind, attrs)
+}
+
+/*
+buildGeoIndex removes all entries of the geo index of a node kind and adds
+the entries of all existing nodes using the given attributes. It is assumed
+that the caller holds the writer lock.
+*/
+func (gm *Manager) buildGeoIndex(kind string, attrs []string) error {
+	var parts []string
+
+	err := func() error {
+
+		for _, part := range gm.Partitions() {
+
+			sm := gm.gs.StorageManager(part+kind+StorageSuffixNodes, false)
+			if sm == nil {
+				continue
+			}
+
+			tree, err := gm.getGeoIndexTree(part, kind, true)
+			if err != nil {
+				return err
+			}
+
+			parts = append(parts, part)
+
+			// Remove all existing entries
+
+			ikeys, _, err := tree.GetRange(nil, nil)
+			if err != nil {
+				return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+			}
+
+			for _, ikey := range ikeys {
+				if _, err := tree.Remove(ikey); err != nil {
+					return util.NewWritingError(err)
+				}
+			}
+
+			if len(attrs) == 0 {
+				continue
+			}
+
+			attrTree, err := gm.getHTree(sm, RootIDNodeHTree)
+			if err != nil {
+				return err
+			}
+
+			valTree, err := gm.getHTree(sm, RootIDNodeHTreeSecond)
+			if err != nil {
+				return err
+			}
+
+			it := attrTree.IteratorPrefix([]byte(PrefixNSAttrs))
+
+			for it.HasNext() {
+				k, _ := it.Next()
+
+				if it.LastError != nil {
+					break
+				}
+
+				key := string(k[len(PrefixNSAttrs):])
+
+				node, err := gm.readNode(key, kind, attrs, attrTree, valTree)
+				if err != nil {
+					return err
+				} else if node == nil {
+					continue
+				}
+
+				if ikey, loc := geoIndexEntry(node.Attr(attrs[0]), node.Attr(attrs[1]), key); ikey != nil {
+					if _, err := tree.Put(ikey, loc); err != nil {
+						return util.NewWritingError(err)
+					}
+				}
+			}
+
+			if it.LastError != nil {
+				return &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+			}
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		for _, part := range parts {
+			gm.rollbackNodeIndex(part, kind)
+		}
+
+		return err
+	}
+
+	for _, part := range parts {
+		if err := gm.flushNodeIndex(part, kind); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+updateGeoIndex updates the geo index of a node kind after a node was written
+or deleted (the node is nil). A partial node might only contain one of the
+location attributes - the other one is read from the node storage. It is
+assumed that the caller holds the writer lock.
+*/
+func (gm *Manager) updateGeoIndex(part string, key string, kind string, node data.Node,
+	oldnode data.Node, partial bool) error {
+
+	attrs := gm.geoIdx.forKind(kind)
+	if len(attrs) != 2 {
+		return nil
+	}
+
+	var vals, oldvals [2]interface{}
+
+	for i, attr := range attrs {
+		if node != nil {
+			vals[i] = node.Attr(attr)
+		}
+		if oldnode != nil {
+			oldvals[i] = oldnode.Attr(attr)
+		}
+	}
+
+	if node != nil && partial && (vals[0] == nil || vals[1] == nil) {
+
+		if vals[0] == nil && vals[1] == nil {
+			return nil
+		}
+
+		// The missing attribute was not changed
+
+		attTree, valTree, err := gm.getNodeStorageHTree(part, kind, false)
+		if err != nil {
+			return err
+		}
+
+		stored, err := gm.readNode(key, kind, attrs, attTree, valTree)
+		if err != nil {
+			return err
+		}
+
+		for i, attr := range attrs {
+			if vals[i] == nil && stored != nil {
+				vals[i] = stored.Attr(attr)
+				oldvals[i] = vals[i]
+			}
+		}
+	}
+
+	ikey, loc := geoIndexEntry(vals[0], vals[1], key)
+	oldikey, _ := geoIndexEntry(oldvals[0], oldvals[1], key)
+
+	if ikey == nil && oldikey == nil {
+		return nil
+	}
+
+	tree, err := gm.getGeoIndexTree(part, kind, true)
+	if err != nil {
+		return err
+	}
+
+	if oldikey != nil && string(oldikey) != string(ikey) {
+		if _, err := tree.Remove(oldikey); err != nil {
+			return util.NewWritingError(err)
+		}
+	}
+
+	if ikey != nil {
+		if _, err := tree.Put(ikey, loc); err != nil {
+			return util.NewWritingError(err)
+		}
+	}
+
+	return nil
+}
+
+/*
+geoIndexEntry returns the key and value of the geo index entry of a node
+location. Returns nil if the location is not valid.
+*/
+func geoIndexEntry(latVal interface{}, lonVal interface{}, key string) ([]byte, []float64) {
+
+	lat, ok1 := data.ValueNumber(latVal)
+	lon, ok2 := data.ValueNumber(lonVal)
+
+	if !ok1 || !ok2 || !validLocation(lat, lon) {
+		return nil, nil
+	}
+
+	ikey := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(ikey, geoInterleave(geoQuantize(lat, lon)))
+
+	return append(ikey, key...), []float64{lat, lon}
+}
+
+/*
+validLocation checks if latitude and longitude are valid.
+*/
+func validLocation(lat float64, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+/*
+geoQuantize maps latitude and longitude to 32 bit integers.
+*/
+func geoQuantize(lat float64, lon float64) (uint32, uint32) {
+	quantize := func(val float64) uint32 {
+		return uint32(math.Min(val*math.Exp2(32), math.Exp2(32)-1))
+	}
+
+	return quantize((lat + 90) / 180), quantize((lon + 180) / 360)
+}
+
+/*
+geoInterleave interleaves the bits of quantized latitude and longitude to a
+z-order curve code. Codes of locations which are close to each other tend to
+have a common prefix.
+*/
+func geoInterleave(lat uint32, lon uint32) uint64 {
+	spread := func(v uint32) uint64 {
+		x := uint64(v)
+		x = (x | x<<16) & 0x0000FFFF0000FFFF
+		x = (x | x<<8) & 0x00FF00FF00FF00FF
+		x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+		x = (x | x<<2) & 0x3333333333333333
+		x = (x | x<<1) & 0x5555555555555555
+		return x
+	}
+
+	return spread(lat)<<1 | spread(lon)
+}
+
+/*
+geoDistance calculates the great circle distance in meters between two
+locations using the haversine formula.
+*/
+func geoDistance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	rad := math.Pi / 180
+
+	dLat := (lat2 - lat1) * rad / 2
+	dLon := (lon2 - lon1) * rad / 2
+
+	a := math.Sin(dLat)*math.Sin(dLat) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon)*math.Sin(dLon)
+
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+/*
+getGeoIndexTree gets the tree which stores the geo index of a node kind. The
+tree is kept in the storage of the full text index.
+*/
+func (gm *Manager) getGeoIndexTree(part string, kind string, create bool) (*hash.BTree, error) {
+
+	// Check if the partition name is valid
+
+	if err := gm.checkPartitionName(part); err != nil {
+		return nil, err
+	}
+
+	sm := gm.gs.StorageManager(part+kind+StorageSuffixNodesIndex, create)
+	if sm == nil {
+		return nil, nil
+	}
+
+	return gm.getBTree(sm, RootIDNodeBTreeSecond)
+}
@@ -0,0 +1,353 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestGeoIndex(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	newCity := func(key string, lat interface{}, lon interface{}) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "City")
+		node.SetAttr("lat", lat)
+		node.SetAttr("lon", lon)
+		return node
+	}
+
+	near := func(gm *Manager, part string, lat float64, lon float64, radius float64) string {
+		res, err := gm.NodesNear(part, "City", lat, lon, radius)
+		if err != nil {
+			return err.Error()
+		}
+		keys := make([]string, 0, len(res))
+		for _, node := range res {
+			keys = append(keys, node.Key())
+		}
+		return fmt.Sprint(keys)
+	}
+
+	// The index is built from existing nodes
+
+	gm.StoreNode("main", newCity("london", 51.5074, -0.1278))
+	gm.StoreNode("main", newCity("paris", 48.8566, 2.3522))
+	gm.StoreNode("main", newCity("brussels", "50.8503", "4.3517"))
+	gm.StoreNode("main", newCity("berlin", 52.52, 13.405))
+	gm.StoreNode("main", newCity("nowhere", 95, 0))
+	gm.StoreNode("main", newCity("unknown", "abc", nil))
+	gm.StoreNode("other", newCity("london", 51.5074, -0.1278))
+
+	if res := near(gm, "main", 51.5, 0, 1000); res !=
+		"GraphError: Invalid data (Node kind City has no geo index)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := gm.CreateGeoIndex("City", "lat", "lon"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Nodes are ordered by distance
+
+	if res := near(gm, "main", 51.5074, -0.1278, 400000); res != "[london brussels paris]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(gm, "main", 50.8503, 4.3517, 300000); res != "[brussels paris]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(gm, "main", 50.8503, 4.3517, 0); res != "[brussels]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(gm, "main", 0, 0, 2e7); res != "[paris brussels london berlin]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(gm, "other", 51.5, 0, 100000); res != "[london]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(gm, "third", 51.5, 0, 100000); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The index is updated when nodes are stored, updated and removed
+
+	gm.StoreNode("main", newCity("nowhere", 51.4545, -2.5879))
+
+	update := data.NewGraphNode()
+	update.SetAttr(data.NodeKey, "london")
+	update.SetAttr(data.NodeKind, "City")
+	update.SetAttr("name", "London")
+
+	gm.UpdateNode("main", update)
+
+	update = data.NewGraphNode()
+	update.SetAttr(data.NodeKey, "berlin")
+	update.SetAttr(data.NodeKind, "City")
+	update.SetAttr("lon", 3.0573)
+	update.SetAttr("lat", 50.6292)
+
+	gm.UpdateNode("main", update)
+
+	update = data.NewGraphNode()
+	update.SetAttr(data.NodeKey, "paris")
+	update.SetAttr(data.NodeKind, "City")
+	update.SetAttr("lon", 13.405)
+
+	gm.UpdateNode("main", update)
+
+	gm.RemoveNode("main", "brussels", "City")
+
+	if res := near(gm, "main", 51.5074, -0.1278, 400000); res != "[london nowhere berlin]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(gm, "main", 48.8566, 13.405, 1000); res != "[paris]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Transactions update the index
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newCity("brussels", 50.8503, 4.3517))
+	trans.RemoveNode("main", "nowhere", "City")
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := near(gm, "main", 51.5074, -0.1278, 400000); res != "[london berlin brussels]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Indexes are persisted
+
+	gm2 := NewGraphManager(mgs)
+
+	if lat, lon := gm2.GeoIndex("City"); lat != "lat" || lon != "lon" {
+		t.Error("Unexpected result:", lat, lon)
+		return
+	}
+
+	if res := near(gm2, "main", 50.6292, 3.0573, 100000); res != "[berlin brussels]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Replacing the index removes the old entries
+
+	if err := gm2.CreateGeoIndex("City", "lon", "lat"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := near(gm2, "main", 3.0573, 50.6292, 200000); res != "[berlin brussels]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(gm2, "main", 50.6292, 3.0573, 100000); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Dropping the index removes all entries
+
+	if err := gm2.DropGeoIndex("City"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	tree, _ := gm2.getGeoIndexTree("main", "City", false)
+
+	if keys, _, _ := tree.GetRange(nil, nil); len(keys) != 0 {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	if lat, lon := NewGraphManager(mgs).GeoIndex("City"); lat != "" || lon != "" {
+		t.Error("Unexpected result:", lat, lon)
+		return
+	}
+
+	// Check errors
+
+	if err := gm.CreateGeoIndex("City", "lat", "lat"); err == nil || err.Error() !=
+		"GraphError: Invalid data (Attribute lat cannot be used for a geo index)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.CreateGeoIndex("City", "lat", data.NodeKind); err == nil || err.Error() !=
+		"GraphError: Invalid data (Attribute kind cannot be used for a geo index)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.CreateGeoIndex("Ci ty", "lat", "lon"); err == nil || err.Error() !=
+		"GraphError: Invalid data (Node kind Ci ty is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	gm.CreateGeoIndex("City", "lat", "lon")
+
+	if res := near(gm, "main", 91, 0, 1000); res !=
+		"GraphError: Invalid data (Invalid location 91, 0 or radius 1000)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(gm, "main", 0, 0, -1); res !=
+		"GraphError: Invalid data (Invalid location 0, 0 or radius -1)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestGeoIndexBoundaries(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	if err := gm.CreateGeoIndex("Place", "lat", "lon"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	places := map[string][]float64{
+		"fiji":     {-17.7134, 178.065},
+		"samoa":    {-13.759, -172.1046},
+		"north1":   {89.9, 0},
+		"north2":   {89.9, 180},
+		"north3":   {90, -45},
+		"south":    {-90, 0},
+		"origin":   {0, 0},
+		"east":     {0, 0.001},
+		"corner":   {90, 180},
+		"antipode": {0, -180},
+	}
+
+	for key, loc := range places {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Place")
+		node.SetAttr("lat", loc[0])
+		node.SetAttr("lon", loc[1])
+		gm.StoreNode("main", node)
+	}
+
+	near := func(lat float64, lon float64, radius float64) string {
+		res, err := gm.NodesNear("main", "Place", lat, lon, radius)
+		if err != nil {
+			return err.Error()
+		}
+		keys := make([]string, 0, len(res))
+		for _, node := range res {
+			keys = append(keys, node.Key())
+		}
+		return fmt.Sprint(keys)
+	}
+
+	// Circles which cross the dateline
+
+	if res := near(-17.7134, 178.065, 1200000); res != "[fiji samoa]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(-13.759, -172.1046, 1200000); res != "[samoa fiji]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(0, 180, 1000); res != "[antipode]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Circles which contain a pole
+
+	if res := near(89.95, 90, 20000); res != "[corner north3 north1 north2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(-89.99, 120, 2000); res != "[south]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Small circles
+
+	if res := near(0, 0, 100); res != "[origin]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := near(0, 0.0005, 100); res != "[east origin]" && res != "[origin east]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The whole earth
+
+	if res, _ := gm.NodesNear("main", "Place", 0, 0, math.Pi*EarthRadius); len(res) != len(places) {
+		t.Error("Unexpected result:", len(res))
+		return
+	}
+
+	if res, _ := gm.NodesNear("main", "Place", 0, 0, math.Inf(1)); len(res) != len(places) {
+		t.Error("Unexpected result:", len(res))
+		return
+	}
+}
+
+func TestGeoIndexDistance(t *testing.T) {
+
+	// London to Paris is about 344 km
+
+	if dist := geoDistance(51.5074, -0.1278, 48.8566, 2.3522); math.Abs(dist-343560) > 1000 {
+		t.Error("Unexpected distance:", dist)
+		return
+	}
+
+	if dist := geoDistance(0, 179.5, 0, -179.5); math.Abs(dist-111195) > 100 {
+		t.Error("Unexpected distance:", dist)
+		return
+	}
+
+	if res := geoInterleave(0xFFFFFFFF, 0); res != 0xAAAAAAAAAAAAAAAA {
+		t.Error(fmt.Sprintf("Unexpected result: %x", res))
+		return
+	}
+}
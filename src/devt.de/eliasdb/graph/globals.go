@@ -23,13 +23,55 @@ Node iterator
 
 All available node keys in a partition of a given kind can be iterated by using
 a NodeKeyIterator. The manager can produce these with the NodeKeyIterator()
-function.
+function. Nodes with a common key prefix can be fetched in key order with
+FetchNodesByKeyPrefix() which uses an ordered in-memory index of node keys.
 
 Fulltext search
 
 All nodes and edges in the datastore are indexed. The index can be queried
 using a IndexQuery object. The manager can produce these with the NodeIndexQuery()
-or EdgeIndexQuery function.
+or EdgeIndexQuery function. SetIndexOptions() controls how the words of the
+full text index of a node kind are extracted (case folding, stemming and the
+tokenizer) and if similar words can be found with fuzzy lookups. Changing the
+options rebuilds the index. Attributes of a node kind can also have a value
+index which is created with CreateValueIndex(). A value index finds the nodes
+which have an exact attribute value with NodeKeysByValue() and is used by EQL
+queries which look for an attribute value. Numeric attributes (including
+timestamps) can have a range index which is created with CreateRangeIndex().
+A range index keeps the nodes ordered by the attribute value and finds the
+nodes within a range of values with NodeKeysByRange(). It is used by EQL
+queries which compare an attribute with a number or a timestamp. A node kind
+can have a geo index over a latitude and a longitude attribute which is
+created with CreateGeoIndex(). NodesNear() uses it to find the nodes within a
+radius around a location.
+
+Partition archival
+
+A partition which holds only historical data can be sealed with
+ArchivePartition(). All storage files of the partition are replaced by
+compressed readonly archive segments. Archived partitions can still be queried
+(reading is slower since data needs to be decompressed) but all write
+operations are rejected.
+
+Time series
+
+Measurements can be stored with CreateTimeSeries(). A time series is a node
+of kind TimeSeries which is connected to bucket nodes which in turn are
+connected to measurement nodes. Each bucket holds the measurements of a fixed
+time interval. A DownsampleJob aggregates a time series into a coarser series
+and can remove old measurements. Time ranges can be aggregated in EQL queries
+with the @tsagg function.
+
+Schemas
+
+The nodes of a kind can be described by a Schema which declares required
+attributes, attribute types and the edge kinds which may be connected to the
+nodes. Schemas are declared with SetSchema() and are checked whenever nodes or
+edges are stored. Violations are reported as ErrSchemaViolation errors. A
+schema can also declare attributes whose values must be unique among the nodes
+of the kind in a partition. The owners of unique values are kept in a
+constraint index in the node storage which is checked and updated together
+with the nodes.
 
 Transactions
 
@@ -45,9 +87,27 @@ Rules
 (Use with caution)
 
 Graph rules provide automatic operations which help to keep the graph consistent.
-Rules trigger on global graph events. The rules SystemRuleDeleteNodeEdges and
-SystemRuleUpdateNodeStats are automatically loaded when a new Manager is created.
-See the code for further details.
+Rules trigger on global graph events. The rules SystemRuleDeleteNodeEdges,
+SystemRuleDeleteNodeLabels, SystemRuleUpdateNodeStats, SystemRuleRefreshKindStats,
+SystemRuleUpdateEdgeKindStats and SystemRuleCheckInvariants are automatically
+loaded when a new Manager is created.
+
+Applications can hook their own logic into node and edge events (e.g. audit
+trails or derived edges) by registering a Rule with RegisterRule(). A rule
+can be created from a handler function and an event mask with NewRule():
+
+	rule := graph.NewRule("audit", graph.EventMaskNode, func(gm *graph.Manager,
+		trans *graph.Trans, event int, ed ...interface{}) error {
+
+		part, node := ed[0].(string), ed[1].(data.Node)
+		...
+		return nil
+	})
+
+	err := gm.RegisterRule(rule)
+
+A rule writes its changes to the given transaction. The event data is
+described for each event constant. See the Rule interface for further details.
 
 Graph databases
 
@@ -83,6 +143,9 @@ Each node kind database stores:
 	PrefixNSEdge + node key + spec -> map[edge key]edgeinfo{other node key, other node kind}]
 	(connection from one node to another via a spec)
 
+	PrefixNSUnique + attr num + value hash -> node key
+	(owner of a value of an attribute which must be unique)
+
 Edges database
 
 Each edge kind database stores:
@@ -93,6 +156,16 @@ Each edge kind database stores:
 	PrefixNSAttr + edge key + attr num -> value
 	(attribute value of a certain edge)
 
+Label database
+
+Each partition has a label index which stores:
+
+	PrefixLBNode + node kind + 0x00 + node key -> map[label]<empty string>
+	(labels of a certain node)
+
+	PrefixLBLabel + label -> map[node kind + 0x00 + node key]<empty string>
+	(nodes which have a certain label)
+
 Index database
 
 The text index managed by util/indexmanager.go. IndexQuery provides access to
@@ -133,6 +206,11 @@ MainDBParts is the MainDB entry key for partition information
 */
 const MainDBParts = MainDBEntryPrefix + "part"
 
+/*
+MainDBArchivedParts is the MainDB entry key for archived partitions
+*/
+const MainDBArchivedParts = MainDBEntryPrefix + "apart"
+
 /*
 MainDBNodeAttrs is the MainDB entry key for a list of node attributes
 */
@@ -158,6 +236,36 @@ MainDBEdgeCount is the MainDB entry key for an edge count
 */
 const MainDBEdgeCount = MainDBEntryPrefix + "ecnt"
 
+/*
+MainDBInvariants is the MainDB entry key for declared invariants
+*/
+const MainDBInvariants = MainDBEntryPrefix + "inv"
+
+/*
+MainDBSchemas is the MainDB entry key for declared node kind schemas
+*/
+const MainDBSchemas = MainDBEntryPrefix + "schema"
+
+/*
+MainDBValueIndexes is the MainDB entry key for attributes which have a value index
+*/
+const MainDBValueIndexes = MainDBEntryPrefix + "vidx"
+
+/*
+MainDBRangeIndexes is the MainDB entry key for attributes which have a range index
+*/
+const MainDBRangeIndexes = MainDBEntryPrefix + "ridx"
+
+/*
+MainDBGeoIndexes is the MainDB entry key for attributes which have a geo index
+*/
+const MainDBGeoIndexes = MainDBEntryPrefix + "gidx"
+
+/*
+MainDBIndexOptions is the MainDB entry key for full text index options of node kinds
+*/
+const MainDBIndexOptions = MainDBEntryPrefix + "iopt"
+
 // Root IDs for StorageManagers
 // ============================
 
@@ -171,6 +279,16 @@ RootIDNodeHTreeSecond is the root ID for the HTree holding secondary information
 */
 const RootIDNodeHTreeSecond = 3
 
+/*
+RootIDNodeBTree is the root ID for the BTree holding ordered information
+*/
+const RootIDNodeBTree = 4
+
+/*
+RootIDNodeBTreeSecond is the root ID for the BTree holding secondary ordered information
+*/
+const RootIDNodeBTreeSecond = 5
+
 // Suffixes for StorageManagers
 // ============================
 
@@ -194,6 +312,11 @@ StorageSuffixEdgesIndex is the suffix for an edge index
 */
 const StorageSuffixEdgesIndex = ".edgeidx"
 
+/*
+StorageSuffixLabels is the suffix for the label index of a partition
+*/
+const StorageSuffixLabels = ".labels"
+
 // PREFIXES for Node storage
 // =========================
 
@@ -221,6 +344,24 @@ PrefixNSEdge is the prefix for storing a link from a node (and a spec) to an edg
 */
 const PrefixNSEdge = string(0x04)
 
+/*
+PrefixNSUnique is the prefix for storing the owner of a unique attribute value
+*/
+const PrefixNSUnique = string(0x05)
+
+// PREFIXES for the label index
+// ============================
+
+/*
+PrefixLBNode is the prefix for storing the labels of a node
+*/
+const PrefixLBNode = string(0x01)
+
+/*
+PrefixLBLabel is the prefix for storing the nodes which have a certain label
+*/
+const PrefixLBLabel = string(0x02)
+
 // Graph events
 //=============
 
@@ -265,3 +406,56 @@ EventEdgeDeleted is thrown when an edge gets deleted.
 Parameters: partition of deleted edge, deleted edge
 */
 const EventEdgeDeleted = 0x06
+
+// Graph event masks
+//==================
+
+/*
+EventMaskNodeCreated is the event mask for EventNodeCreated
+*/
+const EventMaskNodeCreated = 1 << EventNodeCreated
+
+/*
+EventMaskNodeUpdated is the event mask for EventNodeUpdated
+*/
+const EventMaskNodeUpdated = 1 << EventNodeUpdated
+
+/*
+EventMaskNodeDeleted is the event mask for EventNodeDeleted
+*/
+const EventMaskNodeDeleted = 1 << EventNodeDeleted
+
+/*
+EventMaskEdgeCreated is the event mask for EventEdgeCreated
+*/
+const EventMaskEdgeCreated = 1 << EventEdgeCreated
+
+/*
+EventMaskEdgeUpdated is the event mask for EventEdgeUpdated
+*/
+const EventMaskEdgeUpdated = 1 << EventEdgeUpdated
+
+/*
+EventMaskEdgeDeleted is the event mask for EventEdgeDeleted
+*/
+const EventMaskEdgeDeleted = 1 << EventEdgeDeleted
+
+/*
+EventMaskNode is the event mask for all node events
+*/
+const EventMaskNode = EventMaskNodeCreated | EventMaskNodeUpdated | EventMaskNodeDeleted
+
+/*
+EventMaskEdge is the event mask for all edge events
+*/
+const EventMaskEdge = EventMaskEdgeCreated | EventMaskEdgeUpdated | EventMaskEdgeDeleted
+
+/*
+EventMaskAll is the event mask for all events
+*/
+const EventMaskAll = EventMaskNode | EventMaskEdge
+
+/*
+SystemRulePrefix is the name prefix of system rules
+*/
+const SystemRulePrefix = "system."
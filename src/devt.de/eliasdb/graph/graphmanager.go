@@ -25,11 +25,23 @@ import (
 Manager data structure
 */
 type Manager struct {
-	gs       graphstorage.Storage         // Graph storage of this graph manager
-	gr       *graphRulesManager           // Manager for graph rules
-	nm       *util.NamesManager           // Manager object which manages name encodings
-	mapCache map[string]map[string]string // Cache which caches maps stored in the main database
-	mutex    *sync.RWMutex                // Mutex to protect atomic graph operations
+	gs         graphstorage.Storage         // Graph storage of this graph manager
+	gr         *graphRulesManager           // Manager for graph rules
+	nm         *util.NamesManager           // Manager object which manages name encodings
+	mapCache   map[string]map[string]string // Cache which caches maps stored in the main database
+	mapLock    *sync.Mutex                  // Mutex to protect the map cache
+	keyIndex   *nodeKeyIndex                // Ordered index of node keys
+	stats      *kindStatsCollector          // Collector for node kind statistics
+	edgeStats  *edgeStatsCollector          // Collector for edge kind statistics
+	invariants *invariantChecker            // Checker for declared invariants
+	schemas    *schemaRegistry              // Registry of declared node kind schemas
+	valueIdx   *indexRegistry               // Registry of attributes which have a value index
+	rangeIdx   *indexRegistry               // Registry of attributes which have a range index
+	geoIdx     *indexRegistry               // Registry of attributes which have a geo index
+	indexOpts  *indexOptionsRegistry        // Registry of full text index options
+	nodeCache  *nodeCache                   // Read-through cache for nodes (nil if disabled)
+	journal    *Journal                     // Journal of committed changes (nil if disabled)
+	mutex      *sync.RWMutex                // Mutex to protect atomic graph operations
 }
 
 /*
@@ -39,7 +51,11 @@ func NewGraphManager(gs graphstorage.Storage) *Manager {
 	gm := createGraphManager(gs)
 
 	gm.SetGraphRule(&SystemRuleDeleteNodeEdges{})
+	gm.SetGraphRule(&SystemRuleDeleteNodeLabels{})
 	gm.SetGraphRule(&SystemRuleUpdateNodeStats{})
+	gm.SetGraphRule(&SystemRuleRefreshKindStats{})
+	gm.SetGraphRule(&SystemRuleUpdateEdgeKindStats{})
+	gm.SetGraphRule(&SystemRuleCheckInvariants{})
 
 	return gm
 }
@@ -75,8 +91,18 @@ func createGraphManager(gs graphstorage.Storage) *Manager {
 	}
 
 	gm := &Manager{gs, &graphRulesManager{nil, make(map[string]Rule),
-		make(map[int]map[string]Rule)}, util.NewNamesManager(mdb),
-		make(map[string]map[string]string), &sync.RWMutex{}}
+		make(map[int]map[string]Rule), &sync.RWMutex{}}, util.NewNamesManager(mdb),
+		make(map[string]map[string]string), &sync.Mutex{}, newNodeKeyIndex(),
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, &sync.RWMutex{}}
+
+	gm.stats = newKindStatsCollector(gm)
+	gm.edgeStats = newEdgeStatsCollector(gm)
+	gm.invariants = newInvariantChecker(gm.getMainDBMap(MainDBInvariants))
+	gm.schemas = newSchemaRegistry(gm.getMainDBMap(MainDBSchemas))
+	gm.valueIdx = newIndexRegistry("value", MainDBValueIndexes, gm.getMainDBMap(MainDBValueIndexes))
+	gm.rangeIdx = newIndexRegistry("range", MainDBRangeIndexes, gm.getMainDBMap(MainDBRangeIndexes))
+	gm.geoIdx = newIndexRegistry("geo", MainDBGeoIndexes, gm.getMainDBMap(MainDBGeoIndexes))
+	gm.indexOpts = newIndexOptionsRegistry(gm.getMainDBMap(MainDBIndexOptions))
 
 	gm.gr.gm = gm
 
@@ -91,12 +117,29 @@ func (gm *Manager) Name() string {
 }
 
 /*
-SetGraphRule sets a GraphRule.
+SetGraphRule sets a GraphRule. An existing rule with the same name is
+replaced. Use RegisterRule to add application rules.
 */
 func (gm *Manager) SetGraphRule(rule Rule) {
 	gm.gr.SetGraphRule(rule)
 }
 
+/*
+RegisterRule registers an application graph rule which is called on node and
+edge events (see Rule). The name of the rule must be unique and must not start
+with the prefix of system rules.
+*/
+func (gm *Manager) RegisterRule(rule Rule) error {
+	return gm.gr.RegisterRule(rule)
+}
+
+/*
+UnregisterRule removes an application graph rule.
+*/
+func (gm *Manager) UnregisterRule(name string) error {
+	return gm.gr.UnregisterRule(name)
+}
+
 /*
 GraphRules returns a list of all available graph rules.
 */
@@ -113,7 +156,7 @@ func (gm *Manager) NodeIndexQuery(part string, kind string) (IndexQuery, error)
 		return nil, err
 	}
 
-	return util.NewIndexManager(iht), nil
+	return gm.nodeIndexManager(iht, kind), nil
 }
 
 /*
@@ -20,6 +20,7 @@ import (
 	"devt.de/eliasdb/graph/data"
 	"devt.de/eliasdb/graph/util"
 	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/lockprof"
 )
 
 /*
@@ -65,7 +66,7 @@ func (gm *Manager) FetchNodeEdgeSpecs(part string, key string, kind string) ([]s
 
 	// Take reader lock
 
-	gm.mutex.RLock()
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "FetchNodeEdgeSpecs")
 	defer gm.mutex.RUnlock()
 
 	specsNodeKey := PrefixNSSpecs + key
@@ -174,7 +175,7 @@ func (gm *Manager) Traverse(part string, key string, kind string,
 
 	// Take reader lock
 
-	gm.mutex.RLock()
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "Traverse")
 	defer gm.mutex.RUnlock()
 
 	sspec := strings.Split(spec, ":")
@@ -277,7 +278,7 @@ func (gm *Manager) Traverse(part string, key string, kind string,
 				return nil, nil, err
 			}
 
-			node, err := gm.readNode(v.TargetNodeKey, v.TargetNodeKind, nil, attht, valht)
+			node, err := gm.readCachedNode(part, v.TargetNodeKey, v.TargetNodeKind, nil, attht, valht)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -311,7 +312,7 @@ func (gm *Manager) FetchEdgePart(part string, key string, kind string,
 
 	// Take reader lock
 
-	gm.mutex.RLock()
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "FetchEdgePart")
 	defer gm.mutex.RUnlock()
 
 	// Read the edge from the datastore
@@ -329,7 +330,9 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 
 	// Check if the edge can be stored
 
-	if err := gm.checkEdge(edge); err != nil {
+	if err := gm.checkWritablePartition(part); err != nil {
+		return err
+	} else if err := gm.checkEdge(edge); err != nil {
 		return err
 	}
 
@@ -382,7 +385,7 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 
 	// Take writer lock
 
-	gm.mutex.Lock()
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "StoreEdge")
 	defer gm.mutex.Unlock()
 
 	// Write edge to the datastore
@@ -459,7 +462,12 @@ func (gm *Manager) StoreEdge(part string, edge data.Edge) error {
 
 	gm.flushNodeStorage(part, edge.End2Kind())
 
-	return gm.flushEdgeStorage(part, edge.Kind())
+	if err := gm.flushEdgeStorage(part, edge.Kind()); err != nil {
+		return err
+	}
+
+	return gm.journalOperation(&TransLogEntry{event, part, nil, nil,
+		data.NewGraphEdgeFromNode(data.NodeClone(edge)), oldedge}, trans)
 }
 
 /*
@@ -599,6 +607,10 @@ RemoveEdge removes a single edge from a partition of the graph.
 */
 func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge, error) {
 
+	if err := gm.checkWritablePartition(part); err != nil {
+		return nil, err
+	}
+
 	// Get the HTrees which stores the edges and the edge index
 
 	iht, err := gm.getEdgeIndexHTree(part, kind, true)
@@ -613,7 +625,7 @@ func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge,
 
 	// Take writer lock
 
-	gm.mutex.Lock()
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "RemoveEdge")
 	defer gm.mutex.Unlock()
 
 	// Delete the node from the datastore
@@ -679,7 +691,11 @@ func (gm *Manager) RemoveEdge(part string, key string, kind string) (data.Edge,
 
 		gm.flushNodeStorage(part, edge.End2Kind())
 
-		return edge, gm.flushEdgeStorage(part, edge.Kind())
+		if err := gm.flushEdgeStorage(part, edge.Kind()); err != nil {
+			return edge, err
+		}
+
+		return edge, gm.journalOperation(&TransLogEntry{EventEdgeDeleted, part, nil, nil, nil, edge}, trans)
 	}
 
 	return nil, nil
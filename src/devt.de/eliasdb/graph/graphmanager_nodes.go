@@ -17,6 +17,7 @@ import (
 	"devt.de/eliasdb/graph/data"
 	"devt.de/eliasdb/graph/util"
 	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/lockprof"
 )
 
 func init() {
@@ -42,6 +43,16 @@ func (gm *Manager) NodeCount(kind string) uint64 {
 NodeKeyIterator iterates node keys of a certain kind.
 */
 func (gm *Manager) NodeKeyIterator(part string, kind string) (*NodeKeyIterator, error) {
+	return gm.nodeKeyIteratorAt(part, kind, nil)
+}
+
+/*
+nodeKeyIteratorAt iterates node keys of a certain kind starting after a given
+iterator position.
+*/
+func (gm *Manager) nodeKeyIteratorAt(part string, kind string,
+	pos *hash.HTreeIteratorPosition) (*NodeKeyIterator, error) {
+
 	// Get the HTrees which stores the node
 
 	tree, _, err := gm.getNodeStorageHTree(part, kind, false)
@@ -49,7 +60,7 @@ func (gm *Manager) NodeKeyIterator(part string, kind string) (*NodeKeyIterator,
 		return nil, err
 	}
 
-	it := hash.NewHTreeIterator(tree)
+	it := hash.NewHTreeIteratorAt(tree, pos)
 	if it.LastError != nil {
 		return nil, &util.GraphError{
 			Type:   util.ErrReading,
@@ -57,7 +68,7 @@ func (gm *Manager) NodeKeyIterator(part string, kind string) (*NodeKeyIterator,
 		}
 	}
 
-	return &NodeKeyIterator{gm, it, nil}, nil
+	return &NodeKeyIterator{gm, part, kind, it, nil}, nil
 }
 
 /*
@@ -82,12 +93,75 @@ func (gm *Manager) FetchNodePart(part string, key string, kind string,
 
 	// Take reader lock
 
-	gm.mutex.RLock()
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "FetchNodePart")
 	defer gm.mutex.RUnlock()
 
 	// Read the node from the datastore
 
-	return gm.readNode(key, kind, attrs, attht, valht)
+	return gm.readCachedNode(part, key, kind, attrs, attht, valht)
+}
+
+/*
+NodeKeysByPrefix returns the keys of all nodes of a certain kind which start
+with a given prefix. The keys are returned in ascending order. A limit of 0
+returns all matching keys.
+*/
+func (gm *Manager) NodeKeysByPrefix(part string, kind string, prefix string,
+	limit int) ([]string, error) {
+
+	// Get the HTree which stores the node keys
+
+	tree, _, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	// Take reader lock
+
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "NodeKeysByPrefix")
+	defer gm.mutex.RUnlock()
+
+	return gm.keyIndex.prefixKeys(part, kind, prefix, limit, tree)
+}
+
+/*
+FetchNodesByKeyPrefix fetches all nodes of a certain kind whose keys start with
+a given prefix. This allows efficient range scans over hierarchical key
+schemes. The nodes are returned in ascending key order. A limit of 0 returns
+all matching nodes.
+*/
+func (gm *Manager) FetchNodesByKeyPrefix(part string, kind string, prefix string,
+	limit int) ([]data.Node, error) {
+
+	// Get the HTrees which stores the node
+
+	attht, valht, err := gm.getNodeStorageHTree(part, kind, false)
+	if err != nil || attht == nil || valht == nil {
+		return nil, err
+	}
+
+	// Take reader lock
+
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "FetchNodesByKeyPrefix")
+	defer gm.mutex.RUnlock()
+
+	keys, err := gm.keyIndex.prefixKeys(part, kind, prefix, limit, attht)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]data.Node, 0, len(keys))
+
+	for _, key := range keys {
+		node, err := gm.readCachedNode(part, key, kind, nil, attht, valht)
+		if err != nil {
+			return nil, err
+		} else if node != nil {
+			res = append(res, node)
+		}
+	}
+
+	return res, nil
 }
 
 /*
@@ -202,7 +276,11 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 
 	// Check if the node can be stored
 
-	if err := gm.checkNode(node); err != nil {
+	if err := gm.checkWritablePartition(part); err != nil {
+		return err
+	} else if err := gm.checkNode(node); err != nil {
+		return err
+	} else if err := gm.schemas.checkNode(node, onlyUpdate); err != nil {
 		return err
 	}
 
@@ -220,27 +298,72 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 
 	// Take writer lock
 
-	gm.mutex.Lock()
+	if onlyUpdate {
+		lockprof.Lock(gm.mutex, lockprof.LockGraph, "UpdateNode")
+	} else {
+		lockprof.Lock(gm.mutex, lockprof.LockGraph, "StoreNode")
+	}
 	defer gm.mutex.Unlock()
 
+	// An update which inserts a new node must have all required attributes
+
+	if onlyUpdate && gm.schemas.hasRequired(node.Kind()) {
+		if exists, err := attht.Exists([]byte(PrefixNSAttrs + node.Key())); err != nil {
+			return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		} else if !exists {
+			if err := gm.schemas.checkNode(node, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Check unique attribute values before anything is written
+
+	if err := gm.checkUniqueValues(node, valht); err != nil {
+		return err
+	}
+
+	// An update only returns the previous values of the updated attributes -
+	// the journal needs the complete node
+
+	var journalNode, journalOldNode data.Node
+
+	if gm.journal != nil && onlyUpdate {
+		if journalOldNode, err = gm.readNode(node.Key(), node.Kind(), nil, attht, valht); err != nil {
+			return err
+		} else if journalOldNode != nil {
+			journalNode = data.NodeMerge(journalOldNode, node)
+		}
+	}
+
 	// Write the node to the datastore
 
+	gm.invalidateCachedNode(part, node.Key(), node.Kind())
+
 	oldnode, err := gm.writeNode(node, onlyUpdate, attht, valht, nodeAttributeFilter)
 	if err != nil {
 		return err
+	} else if err := gm.updateUniqueValues(node.Key(), node.Kind(), node, oldnode, onlyUpdate, valht); err != nil {
+		return err
+	}
+
+	if journalNode == nil {
+		journalNode, journalOldNode = data.NodeClone(node), oldnode
 	}
 
 	// Increase node count if the node was inserted and write the changes
 	// to the index.
 
 	if oldnode == nil {
+		gm.keyIndex.add(part, node.Kind(), node.Key())
+
 		currentCount := gm.NodeCount(node.Kind())
 		if err := gm.writeNodeCount(node.Kind(), currentCount+1, true); err != nil {
 			return err
 		}
 
 		if iht != nil {
-			err := util.NewIndexManager(iht).Index(node.Key(), node.IndexMap())
+			err := gm.nodeIndexManager(iht, node.Kind()).Index(node.Key(), node.IndexMap())
 			if err != nil {
 
 				// The node was written at this point and the model is
@@ -252,7 +375,7 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 
 	} else if iht != nil {
 
-		err := util.NewIndexManager(iht).Reindex(node.Key(), node.IndexMap(),
+		err := gm.nodeIndexManager(iht, node.Kind()).Reindex(node.Key(), node.IndexMap(),
 			oldnode.IndexMap())
 
 		if err != nil {
@@ -264,6 +387,10 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 		}
 	}
 
+	if err := gm.updateAttrIndexes(part, node.Key(), node.Kind(), node, oldnode, onlyUpdate); err != nil {
+		return err
+	}
+
 	// Execute rules
 
 	trans := NewGraphTrans(gm)
@@ -288,7 +415,11 @@ func (gm *Manager) storeOrUpdateNode(part string, node data.Node, onlyUpdate boo
 
 	gm.flushNodeIndex(part, node.Kind())
 
-	return gm.flushNodeStorage(part, node.Kind())
+	if err := gm.flushNodeStorage(part, node.Kind()); err != nil {
+		return err
+	}
+
+	return gm.journalOperation(&TransLogEntry{event, part, journalNode, journalOldNode, nil, nil}, trans)
 }
 
 /*
@@ -335,7 +466,7 @@ func (gm *Manager) writeNode(node data.Node, onlyUpdate bool, attrTree *hash.HTr
 
 		oldval, err := valTree.Put([]byte(keyAttrPrefix+encattr), val)
 		if err != nil {
-			return nil, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+			return nil, util.NewWritingError(err)
 		}
 
 		// Build up old node
@@ -399,7 +530,7 @@ func (gm *Manager) writeNode(node data.Node, onlyUpdate bool, attrTree *hash.HTr
 		// Do not try cleanup in case we updated a node - we would do more
 		// harm than good.
 
-		return nil, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		return nil, util.NewWritingError(err)
 	}
 
 	// Remove deleted keys
@@ -421,7 +552,7 @@ func (gm *Manager) writeNode(node data.Node, onlyUpdate bool, attrTree *hash.HTr
 
 				oldval, err := valTree.Remove([]byte(keyAttrPrefix + encattrold))
 				if err != nil {
-					return nil, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+					return nil, util.NewWritingError(err)
 				}
 
 				oldnode.SetAttr(gm.nm.Decode32(encattrold), oldval)
@@ -439,6 +570,10 @@ RemoveNode removes a single node from a partition of the graph.
 */
 func (gm *Manager) RemoveNode(part string, key string, kind string) (data.Node, error) {
 
+	if err := gm.checkWritablePartition(part); err != nil {
+		return nil, err
+	}
+
 	// Get the HTree which stores the node index and node kind
 
 	iht, err := gm.getNodeIndexHTree(part, kind, false)
@@ -453,27 +588,37 @@ func (gm *Manager) RemoveNode(part string, key string, kind string) (data.Node,
 
 	// Take writer lock
 
-	gm.mutex.Lock()
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "RemoveNode")
 	defer gm.mutex.Unlock()
 
 	// Delete the node from the datastore
 
+	gm.invalidateCachedNode(part, key, kind)
+
 	node, err := gm.deleteNode(key, kind, attTree, valTree)
 	if err != nil {
 		return node, err
+	} else if err := gm.updateUniqueValues(key, kind, nil, node, false, valTree); err != nil {
+		return node, err
 	}
 
 	// Update the index
 
 	if node != nil {
 
+		gm.keyIndex.remove(part, kind, key)
+
 		if iht != nil {
-			err := util.NewIndexManager(iht).Deindex(key, node.IndexMap())
+			err := gm.nodeIndexManager(iht, kind).Deindex(key, node.IndexMap())
 			if err != nil {
 				return node, err
 			}
 		}
 
+		if err := gm.updateAttrIndexes(part, key, kind, nil, node, false); err != nil {
+			return node, err
+		}
+
 		// Decrease the node count
 
 		currentCount := gm.NodeCount(kind)
@@ -498,7 +643,11 @@ func (gm *Manager) RemoveNode(part string, key string, kind string) (data.Node,
 
 		gm.flushNodeIndex(part, kind)
 
-		return node, gm.flushNodeStorage(part, kind)
+		if err := gm.flushNodeStorage(part, kind); err != nil {
+			return node, err
+		}
+
+		return node, gm.journalOperation(&TransLogEntry{EventNodeDeleted, part, nil, node, nil, nil}, trans)
 	}
 
 	return nil, nil
@@ -519,7 +668,7 @@ func (gm *Manager) deleteNode(key string, kind string, attrTree *hash.HTree,
 
 	attrList, err := attrTree.Remove([]byte(keyAttrs))
 	if err != nil {
-		return nil, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+		return nil, util.NewWritingError(err)
 	} else if attrList == nil {
 		return nil, nil
 	}
@@ -540,7 +689,7 @@ func (gm *Manager) deleteNode(key string, kind string, attrTree *hash.HTree,
 
 		val, err := valTree.Remove([]byte(keyAttrPrefix + encattr))
 		if err != nil {
-			return node, &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+			return node, util.NewWritingError(err)
 		}
 
 		node.SetAttr(attr, val)
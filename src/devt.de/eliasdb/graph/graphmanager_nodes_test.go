@@ -16,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"devt.de/eliasdb/graph/data"
 	"devt.de/eliasdb/graph/graphstorage"
@@ -350,6 +351,76 @@ func TestSimpleNodeUpdate(t *testing.T) {
 	dgs.Close()
 }
 
+func TestTypedNodeValues(t *testing.T) {
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir2, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm := newGraphManagerNoRules(dgs)
+
+	created := time.Date(2016, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	node1 := data.NewGraphNode()
+	node1.SetAttr("key", "typed")
+	node1.SetAttr("kind", "typedkind")
+	node1.SetAttr("count", int64(1)<<60+1)
+	node1.SetAttr("ratio", 0.25)
+	node1.SetAttr("active", true)
+	node1.SetAttr("created", created)
+	node1.SetAttr("blob", []byte{0, 1, 2})
+
+	if err := gm.StoreNode("main", node1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.Close()
+
+	dgs2, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir2, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = newGraphManagerNoRules(dgs2)
+
+	n, err := gm.FetchNode("main", "typed", "typedkind")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// All values keep their type
+
+	if res := fmt.Sprintf("%T %T %T %T %T", n.Attr("count"), n.Attr("ratio"),
+		n.Attr("active"), n.Attr("created"), n.Attr("blob")); res != "int64 float64 bool time.Time []uint8" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if !data.NodeCompare(node1, n, nil) {
+		t.Error("Nodes should match:", node1, n)
+		return
+	}
+
+	// Timestamps can be found in the full-text index
+
+	iq, _ := gm.NodeIndexQuery("main", "typedkind")
+
+	if res, err := iq.LookupValue("created", "2016-05-17T10:30:00Z"); fmt.Sprint(res) != "[typed]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	dgs2.Close()
+}
+
 func TestSimpleNodeStorageErrorCases(t *testing.T) {
 	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
 
@@ -774,3 +845,86 @@ func testVersionPanic(t *testing.T, gs graphstorage.Storage) {
 
 	newGraphManagerNoRules(gs)
 }
+
+func TestFetchNodesByKeyPrefix(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := newGraphManagerNoRules(mgs)
+
+	if res, err := gm.FetchNodesByKeyPrefix("main", "Log", "2024/", 0); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	storeLog := func(key string) {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Log")
+		node.SetAttr("msg", "Entry "+key)
+		gm.StoreNode("main", node)
+	}
+
+	storeLog("2024/05/02")
+	storeLog("2024/04/30")
+	storeLog("2023/12/31")
+	storeLog("2024/05/01")
+
+	keysOf := func(nodes []data.Node) string {
+		var keys []string
+		for _, n := range nodes {
+			keys = append(keys, n.Key())
+		}
+		return fmt.Sprint(keys)
+	}
+
+	res, err := gm.FetchNodesByKeyPrefix("main", "Log", "2024/", 0)
+	if err != nil || keysOf(res) != "[2024/04/30 2024/05/01 2024/05/02]" {
+		t.Error("Unexpected result:", keysOf(res), err)
+		return
+	}
+
+	if res[0].Attr("msg") != "Entry 2024/04/30" {
+		t.Error("Unexpected node:", res[0])
+		return
+	}
+
+	// The index is kept up-to-date after it was built
+
+	storeLog("2024/05/03")
+	gm.RemoveNode("main", "2024/05/01", "Log")
+
+	res, err = gm.FetchNodesByKeyPrefix("main", "Log", "2024/05", 0)
+	if err != nil || keysOf(res) != "[2024/05/02 2024/05/03]" {
+		t.Error("Unexpected result:", keysOf(res), err)
+		return
+	}
+
+	trans := NewGraphTrans(gm)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "2024/05/00")
+	node.SetAttr("kind", "Log")
+	trans.StoreNode("main", node)
+	trans.RemoveNode("main", "2024/05/03", "Log")
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err = gm.FetchNodesByKeyPrefix("main", "Log", "2024/05", 1)
+	if err != nil || keysOf(res) != "[2024/05/00]" {
+		t.Error("Unexpected result:", keysOf(res), err)
+		return
+	}
+
+	keys, err := gm.NodeKeysByPrefix("main", "Log", "", 0)
+	if err != nil || fmt.Sprint(keys) != "[2023/12/31 2024/04/30 2024/05/00 2024/05/02]" {
+		t.Error("Unexpected result:", keys, err)
+		return
+	}
+
+	if _, err := gm.FetchNodesByKeyPrefix("main", "Log-", "", 0); err == nil {
+		t.Error("Invalid node kind should cause an error")
+		return
+	}
+}
@@ -30,9 +30,22 @@ const GraphManagerTestDBDir1 = "gmtest1"
 const GraphManagerTestDBDir2 = "gmtest2"
 const GraphManagerTestDBDir3 = "gmtest3"
 const GraphManagerTestDBDir4 = "gmtest4"
+const GraphManagerTestDBDir5 = "gmtest5"
+const GraphManagerTestDBDir6 = "gmtest6"
+const GraphManagerTestDBDir7 = "gmtest7"
+const GraphManagerTestDBDir8 = "gmtest8"
+const GraphManagerTestDBDir9 = "gmtest9"
+const GraphManagerTestDBDir10 = "gmtest10"
+const GraphManagerTestDBDir11 = "gmtest11"
+const GraphManagerTestDBDir12 = "gmtest12"
+const GraphManagerTestDBDir13 = "gmtest13"
+const GraphManagerTestDBDir14 = "gmtest14"
 
 var DBDIRS = []string{GraphManagerTestDBDir1, GraphManagerTestDBDir2,
-	GraphManagerTestDBDir3, GraphManagerTestDBDir4}
+	GraphManagerTestDBDir3, GraphManagerTestDBDir4, GraphManagerTestDBDir5,
+	GraphManagerTestDBDir6, GraphManagerTestDBDir7, GraphManagerTestDBDir8,
+	GraphManagerTestDBDir9, GraphManagerTestDBDir10, GraphManagerTestDBDir11,
+	GraphManagerTestDBDir12, GraphManagerTestDBDir13, GraphManagerTestDBDir14}
 
 const InvlaidFileName = "**" + string(0x0)
 
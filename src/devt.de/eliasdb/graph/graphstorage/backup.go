@@ -0,0 +1,276 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"devt.de/common/fileutil"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/storage"
+)
+
+/*
+FilenameBackupInfo is the filename for the info file of a backup
+*/
+var FilenameBackupInfo = "backup.info"
+
+/*
+backupInfo describes a full or an incremental backup.
+*/
+type backupInfo struct {
+	ID       string   // Id of the backup
+	Base     string   // Id of the backup which is extended by an increment ("" for full backups)
+	Archived []string // Storage managers which were archived since the base backup
+	Deleted  []string // Storage managers which were deleted since the base backup
+}
+
+/*
+writeBackupInfo writes the info file of a new backup. The backup becomes the
+base for the next increment.
+*/
+func (dgs *DiskGraphStorage) writeBackupInfo(targetDir string, base string) error {
+	info := &backupInfo{fmt.Sprint(time.Now().UnixNano()), base, dgs.archived, dgs.deleted}
+
+	data, err := json.Marshal(info)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(targetDir, FilenameBackupInfo), data, 0660)
+	}
+
+	if err == nil {
+		dgs.backup = info.ID
+		dgs.archived = nil
+		dgs.deleted = nil
+	}
+
+	return err
+}
+
+/*
+readBackupInfo reads the info file of a backup.
+*/
+func readBackupInfo(dir string) (*backupInfo, error) {
+	info := &backupInfo{}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, FilenameBackupInfo))
+	if err == nil {
+		err = json.Unmarshal(data, info)
+	}
+
+	return info, err
+}
+
+/*
+IncrementalBackup writes all changes since the last full or incremental
+backup to a given directory. The directory is created if it does not exist.
+Only records which were modified since the last backup are written. Modified
+records are only tracked while the graph storage is open - a full backup is
+required after the graph storage was opened. The same consistency rules as for
+Backup apply.
+*/
+func (dgs *DiskGraphStorage) IncrementalBackup(targetDir string) error {
+
+	if dgs.backup == "" {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: "A full backup is required before an incremental backup",
+		}
+	}
+
+	if err := os.MkdirAll(targetDir, 0770); err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	// The name storage is small and always copied
+
+	var err error
+
+	if !dgs.readonly {
+		err = dgs.mainDB.Flush()
+	}
+
+	if err == nil {
+		err = storage.CopyFile(dgs.name+"/"+FilenameNameDB, filepath.Join(targetDir, FilenameNameDB))
+	}
+
+	// Write the modified records of all open storage managers
+
+	for smname, sm := range dgs.storagemanagers {
+		if err != nil {
+			break
+		}
+
+		if cdsm, ok := sm.(*storage.CachedDiskStorageManager); ok {
+			err = cdsm.IncrementalBackup(filepath.Join(targetDir,
+				fmt.Sprintf("%v.%v", smname, storage.FileSuffixIncrement)))
+		}
+	}
+
+	// Copy archives which were created since the last backup
+
+	for _, smname := range dgs.archived {
+		if err != nil {
+			break
+		}
+
+		arcname := fmt.Sprintf("%v.%v", smname, storage.FileSuffixArchive)
+		err = storage.CopyFile(dgs.name+"/"+arcname, filepath.Join(targetDir, arcname))
+	}
+
+	if err == nil {
+		err = dgs.writeBackupInfo(targetDir, dgs.backup)
+	}
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+RestoreBackup restores a graph storage from a full backup and a chain of
+incremental backups. The increments must be given in the order in which they
+were created. The graph storage is restored into a new directory which can
+then be opened as a graph storage.
+*/
+func RestoreBackup(targetDir string, backupDir string, increments ...string) error {
+
+	if res, _ := fileutil.PathExists(targetDir); res {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprint("Restore directory already exists: ", targetDir),
+		}
+	}
+
+	info, err := readBackupInfo(backupDir)
+	if err != nil {
+		return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	} else if info.Base != "" {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprint("Not a full backup: ", backupDir),
+		}
+	}
+
+	if err = os.MkdirAll(targetDir, 0770); err == nil {
+		err = copyBackupFiles(backupDir, targetDir)
+	}
+
+	for _, incDir := range increments {
+		var incInfo *backupInfo
+
+		if err != nil {
+			break
+		}
+
+		if incInfo, err = readBackupInfo(incDir); err != nil {
+			break
+		} else if incInfo.Base != info.ID {
+			return &util.GraphError{
+				Type:   util.ErrInvalidData,
+				Detail: fmt.Sprintf("Increment %v does not follow backup %v", incDir, info.ID),
+			}
+		}
+
+		if err = applyBackupIncrement(incDir, incInfo, targetDir); err == nil {
+			info = incInfo
+		}
+	}
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+copyBackupFiles copies all files of a full backup.
+*/
+func copyBackupFiles(backupDir string, targetDir string) error {
+
+	files, err := filepath.Glob(backupDir + "/*")
+
+	for _, f := range files {
+		if err != nil {
+			break
+		}
+
+		if base := filepath.Base(f); base != FilenameBackupInfo {
+			err = storage.CopyFile(f, filepath.Join(targetDir, base))
+		}
+	}
+
+	return err
+}
+
+/*
+applyBackupIncrement applies an incremental backup to a restored graph storage.
+*/
+func applyBackupIncrement(incDir string, info *backupInfo, targetDir string) error {
+
+	err := storage.CopyFile(filepath.Join(incDir, FilenameNameDB), filepath.Join(targetDir, FilenameNameDB))
+
+	// Remove deleted storage managers - they might have been created again
+	// in which case their data is restored from archives and increments
+
+	for _, smname := range info.Deleted {
+		if err != nil {
+			break
+		}
+
+		filename := filepath.Join(targetDir, smname)
+
+		if err = storage.RemoveDataFiles(filename); err == nil && storage.ArchiveFileExist(filename) {
+			err = os.Remove(fmt.Sprintf("%v.%v", filename, storage.FileSuffixArchive))
+		}
+	}
+
+	// Replace archived storage managers with their archives
+
+	for _, smname := range info.Archived {
+		if err != nil {
+			break
+		}
+
+		arcname := fmt.Sprintf("%v.%v", smname, storage.FileSuffixArchive)
+
+		if err = storage.CopyFile(filepath.Join(incDir, arcname), filepath.Join(targetDir, arcname)); err == nil {
+			err = storage.RemoveDataFiles(filepath.Join(targetDir, smname))
+		}
+	}
+
+	// Write the modified records of all storage managers
+
+	var files []string
+
+	if err == nil {
+		files, err = filepath.Glob(fmt.Sprintf("%v/*.%v", incDir, storage.FileSuffixIncrement))
+	}
+
+	for _, f := range files {
+		if err != nil {
+			break
+		}
+
+		smname := strings.TrimSuffix(filepath.Base(f), "."+storage.FileSuffixIncrement)
+		err = storage.ApplyIncrement(filepath.Join(targetDir, smname), f)
+	}
+
+	return err
+}
@@ -19,7 +19,10 @@ package graphstorage
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"devt.de/common/datautil"
 	"devt.de/common/fileutil"
@@ -32,26 +35,147 @@ FilenameNameDB is the filename for the name storage file
 */
 var FilenameNameDB = "names.pm"
 
+/*
+LogStorage is called with errors which occurred while opening storage managers
+*/
+var LogStorage storage.ScrubLogger = func(v ...interface{}) {}
+
+/*
+ScrubInterval is the interval in which the free slot information of all
+storage managers is checked in the background. No check is done if the
+interval is 0.
+*/
+var ScrubInterval time.Duration
+
+/*
+CacheMaxBytes is the maximum size of the records which are held in the object
+cache of each storage manager. The size of the cache is not limited if the
+value is 0.
+*/
+var CacheMaxBytes uint64
+
+/*
+CachePolicy is the name of the policy which decides which objects are evicted
+from the object cache of each storage manager (see storage.CachePolicies).
+*/
+var CachePolicy = "lru"
+
+/*
+CacheProfileSize is the number of the most frequently accessed records of
+each storage manager which are recorded in an access profile when the storage
+is closed. The access profiles can be used to warm up the object caches
+after a restart with WarmUpCaches(). No profiles are written if the value is 0.
+*/
+var CacheProfileSize int
+
+/*
+SharedCacheSize is the max number of objects in an object cache which is
+shared by all storage managers of a graph storage. Each storage manager has
+its own object cache if the value is 0.
+*/
+var SharedCacheSize int
+
+/*
+CacheWriteBack is the max number of modified records which are held in the
+object cache of each storage manager before they are written in one batch.
+All modified records are also written when the storage is flushed. Updates
+are written immediately if the value is 0.
+*/
+var CacheWriteBack int
+
+/*
+ReadAheadRecords is the number of records which are read ahead in one large
+read once a sequential scan of a storage file is detected (e.g. when all
+nodes of a kind are read). Nothing is read ahead if the value is 0.
+*/
+var ReadAheadRecords int
+
+/*
+PartitionCacheQuotas maps partition names to the max number of objects in an
+object cache which is shared by all storage managers of the partition. A
+partition with a quota can only evict objects of its own storage managers so
+a busy partition cannot push the objects of other partitions out of their
+caches. Storage managers of partitions without a quota use the normal object
+caches.
+*/
+var PartitionCacheQuotas = make(map[string]int)
+
+/*
+DiskQuota is the max number of bytes which all files of a graph storage may
+take up on disk. Inserts fail with an error of the category
+errorutil.ErrQuota once the limit is reached. The disk usage is not limited
+if the value is 0.
+*/
+var DiskQuota uint64
+
+/*
+CacheMinObjects and CacheMaxObjects are the bounds of an adaptive object cache
+size. The object cache of each storage manager is grown or shrunk between
+these bounds depending on its hit rate and the memory pressure (see
+storage.CachedDiskStorageManager.StartAdaptiveSize). The object caches have a
+fixed size if CacheMaxObjects is 0.
+*/
+var (
+	CacheMinObjects int
+	CacheMaxObjects int
+)
+
+/*
+cacheSize is the max number of objects in the object cache of each storage
+manager.
+*/
+const cacheSize = 100000
+
 /*
 DiskGraphStorage data structure
 */
 type DiskGraphStorage struct {
-	name            string                        // Name of the graph storage
-	readonly        bool                          // Flag for readonly mode
-	mainDB          *datautil.PersistentStringMap // Database storing names
-	storagemanagers map[string]storage.Manager    // Map of StorageManagers
+	name            string                          // Name of the graph storage
+	readonly        bool                            // Flag for readonly mode
+	mainDB          *datautil.PersistentStringMap   // Database storing names
+	storagemanagers map[string]storage.Manager      // Map of StorageManagers
+	sharedCache     *storage.SharedCache            // Object cache of all StorageManagers (may be nil)
+	partitionCaches map[string]*storage.SharedCache // Object caches of partitions with a quota
+	backup          string                          // Id of the last backup ("" if there was none)
+	archived        []string                        // Storage managers which were archived since the last backup
+	deleted         []string                        // Storage managers which were deleted since the last backup
+	quota           *storage.Quota                  // Limit for the disk usage (nil if there is none)
 }
 
 /*
-NewDiskGraphStorage creates a new DiskGraphStorage instance.
+NewDiskGraphStorage creates a new DiskGraphStorage instance. In readonly mode
+the storage is only opened if it exists and nothing is written to its files -
+all storage managers are opened readonly without transaction logs and
+lockfiles. This allows several readers to share a snapshot of the storage
+files. The snapshot should be taken from a flushed storage since pending
+transactions are not recovered in readonly mode.
 */
 func NewDiskGraphStorage(name string, readonly bool) (Storage, error) {
 
-	dgs := &DiskGraphStorage{name, readonly, nil, make(map[string]storage.Manager)}
+	dgs := &DiskGraphStorage{name, readonly, nil, make(map[string]storage.Manager), nil,
+		make(map[string]*storage.SharedCache), "", nil, nil, nil}
+
+	if DiskQuota > 0 {
+		dgs.quota = storage.NewQuota(name, DiskQuota)
+	}
+
+	if SharedCacheSize > 0 {
+		dgs.sharedCache = storage.NewSharedCache(SharedCacheSize)
+	}
+
+	for part, quota := range PartitionCacheQuotas {
+		if quota > 0 {
+			dgs.partitionCaches[part] = storage.NewSharedCache(quota)
+		}
+	}
 
 	// Load the graph storage if the storage directory already exists if not try to create it
 
 	if res, _ := fileutil.PathExists(name); !res {
+		if readonly {
+			return nil, &util.GraphError{Type: util.ErrOpening, Detail: "Storage does not exist: " + name}
+		}
+
 		if err := os.Mkdir(name, 0770); err != nil {
 			return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
 		}
@@ -87,6 +211,13 @@ func (dgs *DiskGraphStorage) Name() string {
 	return dgs.name
 }
 
+/*
+IsReadOnly returns if the storage was opened in readonly mode.
+*/
+func (dgs *DiskGraphStorage) IsReadOnly() bool {
+	return dgs.readonly
+}
+
 /*
 MainDB returns the main database.
 */
@@ -134,7 +265,9 @@ func (dgs *DiskGraphStorage) FlushMain() error {
 
 /*
 StorageManager gets a storage manager with a certain name. A non-existing
-StorageManager is created automatically if the create flag is set to true.
+StorageManager is created automatically if the create flag is set to true
+and the storage is not readonly. Returns nil if an archived storage manager
+cannot be opened (the error is given to LogStorage).
 */
 func (dgs *DiskGraphStorage) StorageManager(smname string, create bool) storage.Manager {
 
@@ -145,15 +278,312 @@ func (dgs *DiskGraphStorage) StorageManager(smname string, create bool) storage.
 	// Create storage manager object either if we may create or if the
 	// database already exists
 
-	if !ok && (create || storage.DataFileExist(filename)) {
-		dsm := storage.NewDiskStorageManager(dgs.name+"/"+smname, dgs.readonly, false, false, false)
-		sm = storage.NewCachedDiskStorageManager(dsm, 100000)
+	// Archived storage managers are opened readonly
+
+	if !ok && storage.ArchiveFileExist(filename) {
+		asm, err := storage.NewArchiveStorageManager(filename)
+		if err != nil {
+			LogStorage(fmt.Sprintf("Could not open archive %v: %v", filename, err))
+			return nil
+		}
+
+		sm = asm
+		dgs.storagemanagers[smname] = sm
+
+	} else if !ok && ((create && !dgs.readonly) || storage.DataFileExist(filename)) {
+
+		// Readonly storage managers neither need transaction logs nor
+		// lockfiles since they never write to their files
+
+		dsm := storage.NewDiskStorageManager(dgs.name+"/"+smname, dgs.readonly, false,
+			dgs.readonly, dgs.readonly)
+
+		if ScrubInterval > 0 {
+			dsm.StartScrubber(ScrubInterval, !dgs.readonly)
+		}
+
+		if ReadAheadRecords > 0 {
+			dsm.SetReadAhead(ReadAheadRecords)
+		}
+
+		if dgs.quota != nil {
+			dsm.SetQuota(dgs.quota)
+		}
+
+		var cdsm *storage.CachedDiskStorageManager
+
+		if sc := dgs.partitionCache(smname); sc != nil {
+			cdsm = storage.NewSharedCachedDiskStorageManager(dsm, sc)
+		} else if dgs.sharedCache != nil {
+			cdsm = storage.NewSharedCachedDiskStorageManager(dsm, dgs.sharedCache)
+		} else {
+			cdsm = storage.NewCachedDiskStorageManager(dsm, cacheSize)
+		}
+
+		cdsm.SetMaxBytes(CacheMaxBytes)
+
+		if CacheMaxObjects > 0 {
+			cdsm.StartAdaptiveSize(CacheMinObjects, CacheMaxObjects, 0)
+		}
+
+		if newPolicy, ok := storage.CachePolicies[CachePolicy]; ok {
+			cdsm.SetCachePolicy(newPolicy(cacheSize))
+		}
+
+		if !dgs.readonly {
+			cdsm.SetAccessProfileSize(CacheProfileSize)
+
+			if CacheWriteBack > 0 {
+				cdsm.SetWriteBack(CacheWriteBack)
+			}
+		}
+
+		sm = cdsm
 		dgs.storagemanagers[smname] = sm
 	}
 
 	return sm
 }
 
+/*
+partitionCache returns the object cache of the partition of a given storage
+manager. Storage manager names start with the name of their partition - the
+longest matching partition name is used. Returns nil if the partition has no
+cache quota.
+*/
+func (dgs *DiskGraphStorage) partitionCache(smname string) *storage.SharedCache {
+	var ret *storage.SharedCache
+	var match string
+
+	for part, sc := range dgs.partitionCaches {
+		if strings.HasPrefix(smname, part) && len(part) > len(match) {
+			ret = sc
+			match = part
+		}
+	}
+
+	return ret
+}
+
+/*
+StorageManagerNames returns the names of all existing storage managers in
+alphabetical order. Storage managers which are not open are found through
+their data files or archives.
+*/
+func (dgs *DiskGraphStorage) StorageManagerNames() []string {
+	names := make(map[string]bool)
+
+	for smname := range dgs.storagemanagers {
+		names[smname] = true
+	}
+
+	// Errors can only be caused by a malformed pattern
+
+	files, _ := filepath.Glob(fmt.Sprintf("%v/*.%v.0", dgs.name, storage.FileSuffixPhysicalSlots))
+	archives, _ := filepath.Glob(fmt.Sprintf("%v/*.%v", dgs.name, storage.FileSuffixArchive))
+
+	for _, f := range append(files, archives...) {
+		smname := strings.TrimSuffix(filepath.Base(f), ".0")
+		names[smname[:strings.LastIndex(smname, ".")]] = true
+	}
+
+	ret := make([]string, 0, len(names))
+
+	for smname := range names {
+		ret = append(ret, smname)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+DeleteStorageManager closes a storage manager and removes all its files
+(data files, archive and access profile). Nothing happens if the storage
+manager does not exist.
+*/
+func (dgs *DiskGraphStorage) DeleteStorageManager(smname string) error {
+
+	// Fail operation when readonly
+
+	if dgs.readonly {
+		return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot delete " + smname}
+	}
+
+	filename := dgs.name + "/" + smname
+
+	sm, ok := dgs.storagemanagers[smname]
+
+	if !ok && !storage.DataFileExist(filename) && !storage.ArchiveFileExist(filename) {
+		return nil
+	}
+
+	var err error
+
+	if ok {
+		delete(dgs.storagemanagers, smname)
+		err = sm.Close()
+	}
+
+	if err == nil {
+		err = storage.RemoveDataFiles(filename)
+	}
+
+	if err == nil && storage.ArchiveFileExist(filename) {
+		err = os.Remove(fmt.Sprintf("%v.%v", filename, storage.FileSuffixArchive))
+	}
+
+	// Record the deletion for the next incremental backup
+
+	for i, archived := range dgs.archived {
+		if archived == smname {
+			dgs.archived = append(dgs.archived[:i], dgs.archived[i+1:]...)
+			break
+		}
+	}
+
+	dgs.deleted = append(dgs.deleted, smname)
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+ArchiveStorageManager replaces a storage manager with a readonly compressed
+archive. The data files of the storage manager are removed once the archive
+has been written. Nothing happens if the storage manager does not exist or
+was already archived.
+*/
+func (dgs *DiskGraphStorage) ArchiveStorageManager(smname string) error {
+
+	// Fail operation when readonly
+
+	if dgs.readonly {
+		return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot archive " + smname}
+	}
+
+	cdsm, ok := dgs.StorageManager(smname, false).(*storage.CachedDiskStorageManager)
+	if !ok {
+		return nil
+	}
+
+	filename := dgs.name + "/" + smname
+
+	err := cdsm.Flush()
+
+	if err == nil {
+		err = storage.CreateArchive(cdsm.DiskStorageManager())
+	}
+
+	if err == nil {
+		delete(dgs.storagemanagers, smname)
+		dgs.archived = append(dgs.archived, smname)
+
+		if err = cdsm.Close(); err == nil {
+			err = storage.RemoveDataFiles(filename)
+		}
+	}
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+Backup writes a copy of all storage files to a given directory. The directory
+is created if it does not exist. The storage stays writable - each storage
+manager is flushed and copied while its changes are blocked. The caller must
+make sure that no changes spanning several storage managers are pending to
+get a consistent copy of the whole graph storage (e.g. by holding the lock of
+the graph manager). Changes after the backup can be saved with
+IncrementalBackup.
+*/
+func (dgs *DiskGraphStorage) Backup(targetDir string) error {
+
+	if err := os.MkdirAll(targetDir, 0770); err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	// Copy the name storage
+
+	var err error
+
+	if !dgs.readonly {
+		err = dgs.mainDB.Flush()
+	}
+
+	if err == nil {
+		err = storage.CopyFile(dgs.name+"/"+FilenameNameDB, filepath.Join(targetDir, FilenameNameDB))
+	}
+
+	// Copy open storage managers while they are blocked
+
+	copied := make([]string, 0, len(dgs.storagemanagers))
+
+	for smname, sm := range dgs.storagemanagers {
+		if err != nil {
+			break
+		}
+
+		if cdsm, ok := sm.(*storage.CachedDiskStorageManager); ok {
+			err = cdsm.Backup(targetDir)
+			copied = append(copied, smname+".")
+		}
+	}
+
+	// Copy all remaining files (archives, access profiles and files of
+	// storage managers which are not open) - lockfiles are skipped
+
+	var files []string
+
+	if err == nil {
+		files, err = filepath.Glob(dgs.name + "/*")
+	}
+
+	for _, f := range files {
+		if err != nil {
+			break
+		}
+
+		base := filepath.Base(f)
+
+		if base == FilenameNameDB || base == FilenameBackupInfo ||
+			strings.HasSuffix(base, "."+storage.FileSiffixLockfile) {
+			continue
+		}
+
+		isCopied := false
+		for _, prefix := range copied {
+			if strings.HasPrefix(base, prefix) && !strings.HasSuffix(base, "."+storage.FileSuffixProfile) {
+				isCopied = true
+				break
+			}
+		}
+
+		if !isCopied {
+			err = storage.CopyFile(f, filepath.Join(targetDir, base))
+		}
+	}
+
+	// Record the backup so later changes can be saved in increments
+
+	if err == nil {
+		err = dgs.writeBackupInfo(targetDir, "")
+	}
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return nil
+}
+
 /*
 FlushAll writes all pending changes to the storage.
 */
@@ -170,11 +600,16 @@ func (dgs *DiskGraphStorage) FlushAll() error {
 		errors = append(errors, err.Error())
 	}
 
+	// Storage managers are flushed concurrently
+
+	flushs := make([]func() error, 0, len(dgs.storagemanagers))
+
 	for _, sm := range dgs.storagemanagers {
-		err := sm.Flush()
-		if err != nil {
-			errors = append(errors, err.Error())
-		}
+		flushs = append(flushs, sm.Flush)
+	}
+
+	if err := storage.FlushConcurrently(flushs...); err != nil {
+		errors = append(errors, err.Error())
 	}
 
 	if len(errors) > 0 {
@@ -186,6 +621,55 @@ func (dgs *DiskGraphStorage) FlushAll() error {
 	return nil
 }
 
+/*
+WarmUpCaches preloads the records of all persisted access profiles into the
+object caches of their storage managers. Returns the number of preloaded
+records.
+*/
+func (dgs *DiskGraphStorage) WarmUpCaches() (int, error) {
+
+	files, err := filepath.Glob(fmt.Sprintf("%v/*.%v", dgs.name, storage.FileSuffixProfile))
+	if err != nil {
+		return 0, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	count := 0
+
+	for _, f := range files {
+		smname := strings.TrimSuffix(filepath.Base(f), "."+storage.FileSuffixProfile)
+
+		if cdsm, ok := dgs.StorageManager(smname, false).(*storage.CachedDiskStorageManager); ok {
+			n, err := cdsm.WarmUp()
+			if err != nil {
+				return count, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+			}
+
+			count += n
+		}
+	}
+
+	return count, nil
+}
+
+/*
+MemoryUsage returns the memory which is held by all storage managers.
+*/
+func (dgs *DiskGraphStorage) MemoryUsage() *storage.MemoryUsage {
+	ret := &storage.MemoryUsage{}
+
+	for _, sm := range dgs.storagemanagers {
+		if mr, ok := sm.(storage.MemoryReporter); ok {
+			mu := mr.MemoryUsage()
+			ret.Records += mu.Records
+			ret.TransRecords += mu.TransRecords
+			ret.CacheObjects += mu.CacheObjects
+			ret.CacheBytes += mu.CacheBytes
+		}
+	}
+
+	return ret
+}
+
 /*
 Close closes the storage.
 */
@@ -193,9 +677,10 @@ func (dgs *DiskGraphStorage) Close() error {
 
 	var errors []string
 
-	err := dgs.mainDB.Flush()
-	if err != nil {
-		errors = append(errors, err.Error())
+	if !dgs.readonly {
+		if err := dgs.mainDB.Flush(); err != nil {
+			errors = append(errors, err.Error())
+		}
 	}
 
 	for _, sm := range dgs.storagemanagers {
@@ -14,18 +14,31 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"devt.de/common/datautil"
+	"devt.de/common/errorutil"
 	"devt.de/common/fileutil"
 	"devt.de/eliasdb/storage"
 )
 
 const diskGraphStorageTestDBDir = "diskgraphstoragetest1"
 const diskGraphStorageTestDBDir2 = "diskgraphstoragetest2"
-
-var dbdirs = []string{diskGraphStorageTestDBDir, diskGraphStorageTestDBDir2}
+const diskGraphStorageTestDBDir3 = "diskgraphstoragetest3"
+const diskGraphStorageTestDBDir4 = "diskgraphstoragetest4"
+const diskGraphStorageTestDBDir5 = "diskgraphstoragetest5"
+const diskGraphStorageTestDBDir6 = "diskgraphstoragetest6"
+const diskGraphStorageTestDBDir7 = "diskgraphstoragetest7"
+const diskGraphStorageTestDBDir8 = "diskgraphstoragetest8"
+const diskGraphStorageTestDBDir9 = "diskgraphstoragetest9"
+
+var dbdirs = []string{diskGraphStorageTestDBDir, diskGraphStorageTestDBDir2, diskGraphStorageTestDBDir3,
+	diskGraphStorageTestDBDir4, diskGraphStorageTestDBDir5, diskGraphStorageTestDBDir6, diskGraphStorageTestDBDir7,
+	diskGraphStorageTestDBDir8, diskGraphStorageTestDBDir9}
 
 const invalidFileName = "**" + string(0x0)
 
@@ -97,6 +110,16 @@ func TestDiskGraphStorage(t *testing.T) {
 		return
 	}
 
+	var ret string
+
+	loc, _ := sm1.Insert("test")
+	sm1.Fetch(loc, &ret)
+
+	if mu := dgsnew.(*DiskGraphStorage).MemoryUsage(); mu.CacheObjects != 1 || mu.Records == 0 {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
 	sm2 := dgsnew.StorageManager("store2.nodes", false)
 
 	if res, _ := fileutil.PathExists(diskGraphStorageTestDBDir + "/store2.nodes.db.0"); res {
@@ -158,6 +181,377 @@ func TestDiskGraphStorage(t *testing.T) {
 	}
 }
 
+func TestDiskGraphStorageWarmUp(t *testing.T) {
+	CacheProfileSize = 10
+	defer func() {
+		CacheProfileSize = 0
+	}()
+
+	dgs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir3, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var ret string
+
+	for _, smname := range []string{"store1.nodes", "store2.nodes"} {
+		sm := dgs.StorageManager(smname, true)
+
+		for i := 0; i < 3; i++ {
+			loc, _ := sm.Insert(fmt.Sprint("test", i))
+			sm.Fetch(loc, &ret)
+		}
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs, err = NewDiskGraphStorage(diskGraphStorageTestDBDir3, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, err := dgs.(*DiskGraphStorage).WarmUpCaches(); n != 6 || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if mu := dgs.(*DiskGraphStorage).MemoryUsage(); mu.CacheObjects != 6 {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskGraphStorageSharedCache(t *testing.T) {
+	SharedCacheSize = 4
+	defer func() {
+		SharedCacheSize = 0
+	}()
+
+	dgs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir4, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, smname := range []string{"store1.nodes", "store2.nodes"} {
+		sm := dgs.StorageManager(smname, true)
+
+		for i := 0; i < 3; i++ {
+			sm.Insert(fmt.Sprint("test", i))
+		}
+
+		if sc := sm.(*storage.CachedDiskStorageManager).SharedCache(); sc == nil || sc != dgs.(*DiskGraphStorage).sharedCache {
+			t.Error("Storage manager should use the shared cache")
+			return
+		}
+	}
+
+	if mu := dgs.(*DiskGraphStorage).MemoryUsage(); mu.CacheObjects != 4 {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskGraphStoragePartitionCacheQuotas(t *testing.T) {
+	PartitionCacheQuotas = map[string]int{"main": 2, "main2": 3}
+	defer func() {
+		PartitionCacheQuotas = make(map[string]int)
+	}()
+
+	dgs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir5, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var sms []*storage.CachedDiskStorageManager
+
+	for _, smname := range []string{"mainSong.nodes", "mainAuthor.nodes", "main2Song.nodes", "otherSong.nodes"} {
+		sm := dgs.StorageManager(smname, true).(*storage.CachedDiskStorageManager)
+
+		for i := 0; i < 5; i++ {
+			sm.Insert(fmt.Sprint("test", i))
+		}
+
+		sms = append(sms, sm)
+	}
+
+	// Storage managers of a partition share the quota of the partition
+
+	if sms[0].SharedCache() != sms[1].SharedCache() || sms[0].SharedCache().MaxObjects() != 2 ||
+		sms[0].SharedCache().Objects() != 2 {
+		t.Error("Unexpected cache:", sms[0].SharedCache())
+		return
+	}
+
+	// The longest matching partition name is used
+
+	if sms[2].SharedCache() == sms[0].SharedCache() || sms[2].SharedCache().Objects() != 3 {
+		t.Error("Unexpected cache:", sms[2].SharedCache())
+		return
+	}
+
+	// Partitions without a quota use the normal object caches
+
+	if sms[3].SharedCache() != nil || sms[3].MemoryUsage().CacheObjects != 5 {
+		t.Error("Unexpected cache:", sms[3].SharedCache())
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskGraphStorageDiskQuota(t *testing.T) {
+	DiskQuota = 1
+	defer func() {
+		DiskQuota = 0
+	}()
+
+	dgs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir6, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sm := dgs.StorageManager("main.nodes", true)
+
+	// The files of the graph storage already exceed the quota
+
+	_, err = sm.Insert("test")
+	if !errorutil.IsCategory(err, errorutil.ErrQuota) ||
+		!strings.HasPrefix(err.Error(), "Quota exceeded (ByteDiskStorageFile:diskgraphstoragetest6/main.nodes") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if usage, err := dgs.(*DiskGraphStorage).quota.Usage(); err != nil ||
+		usage < dgs.(*DiskGraphStorage).quota.Limit() {
+		t.Error("Unexpected usage:", usage, err)
+		return
+	}
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskGraphStorageReadOnly(t *testing.T) {
+
+	if _, err := NewDiskGraphStorage(diskGraphStorageTestDBDir7, true); err == nil ||
+		err.Error() != "GraphError: Failed to open graph storage (Storage does not exist: diskgraphstoragetest7)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dgs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir7, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc, _ := dgs.StorageManager("main.nodes", true).Insert("test")
+	dgs.MainDB()["test"] = "val"
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Record the files of the storage
+
+	listFiles := func() string {
+		var ret []string
+
+		files, _ := filepath.Glob(diskGraphStorageTestDBDir7 + "/*")
+		for _, f := range files {
+			info, _ := os.Stat(f)
+			ret = append(ret, fmt.Sprint(f, info.Size(), info.ModTime()))
+		}
+
+		return strings.Join(ret, "\n")
+	}
+
+	before := listFiles()
+
+	dgs, err = NewDiskGraphStorage(diskGraphStorageTestDBDir7, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !dgs.(*DiskGraphStorage).IsReadOnly() || dgs.MainDB()["test"] != "val" {
+		t.Error("Unexpected storage:", dgs.MainDB())
+		return
+	}
+
+	sm := dgs.StorageManager("main.nodes", true)
+
+	var res string
+
+	if err := sm.Fetch(loc, &res); err != nil || res != "test" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := sm.Insert("test2"); err != storage.ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Storage managers are not created
+
+	if sm := dgs.StorageManager("main2.nodes", true); sm != nil {
+		t.Error("Unexpected result:", sm)
+		return
+	}
+
+	dgs.MainDB()["test"] = "val2"
+
+	if err := dgs.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Nothing was written
+
+	if after := listFiles(); after != before {
+		t.Error("Unexpected files:", before, after)
+		return
+	}
+}
+
+func TestDiskGraphStorageDeleteStorageManager(t *testing.T) {
+
+	dgs, err := NewDiskGraphStorage(diskGraphStorageTestDBDir9, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, smname := range []string{"main.nodes", "main.edges", "main.nodeidx"} {
+		dgs.StorageManager(smname, true).Insert("test")
+	}
+
+	if err := dgs.(*DiskGraphStorage).ArchiveStorageManager("main.nodeidx"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dgs.Close()
+
+	// Storage managers which are not open are found through their files
+
+	dgs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir9, false)
+
+	if res := fmt.Sprint(dgs.StorageManagerNames()); res != "[main.edges main.nodeidx main.nodes]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	dgs.StorageManager("main.nodes", false)
+	dgs.StorageManager("main.test", true)
+
+	if res := fmt.Sprint(dgs.StorageManagerNames()); res != "[main.edges main.nodeidx main.nodes main.test]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Delete open storage managers, archives and storage managers which do
+	// not exist
+
+	for _, smname := range []string{"main.nodes", "main.nodeidx", "main.test", "main.foo"} {
+		if err := dgs.DeleteStorageManager(smname); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if res := fmt.Sprint(dgs.StorageManagerNames()); res != "[main.edges]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if files, _ := filepath.Glob(diskGraphStorageTestDBDir9 + "/main.[nt]*"); len(files) != 0 {
+		t.Error("Unexpected files:", files)
+		return
+	}
+
+	if res := fmt.Sprint(dgs.(*DiskGraphStorage).deleted); res != "[main.nodes main.nodeidx main.test]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if sm := dgs.StorageManager("main.nodes", false); sm != nil {
+		t.Error("Unexpected result:", sm)
+		return
+	}
+
+	dgs.Close()
+
+	// Nothing can be deleted in readonly mode
+
+	dgs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir9, true)
+
+	if err := dgs.DeleteStorageManager("main.edges"); err == nil || err.Error() !=
+		"GraphError: Failed write to readonly storage (Cannot delete main.edges)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res := fmt.Sprint(dgs.StorageManagerNames()); res != "[main.edges]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	dgs.Close()
+
+	// Archives which cannot be opened are reported
+
+	var logged []interface{}
+
+	LogStorage = func(v ...interface{}) {
+		logged = append(logged, v...)
+	}
+	defer func() {
+		LogStorage = func(v ...interface{}) {}
+	}()
+
+	dgs, _ = NewDiskGraphStorage(diskGraphStorageTestDBDir9, false)
+	defer dgs.Close()
+
+	ioutil.WriteFile(diskGraphStorageTestDBDir9+"/main.bad."+storage.FileSuffixArchive, []byte("bad"), 0660)
+
+	if sm := dgs.StorageManager("main.bad", true); sm != nil {
+		t.Error("Unexpected result:", sm)
+		return
+	}
+
+	if len(logged) != 1 || !strings.HasPrefix(fmt.Sprint(logged[0]),
+		"Could not open archive "+diskGraphStorageTestDBDir9+"/main.bad:") {
+		t.Error("Unexpected result:", logged)
+		return
+	}
+}
+
 func TestDiskGraphStorageErrors(t *testing.T) {
 	_, err := NewDiskGraphStorage(invalidFileName, false)
 	if err == nil {
@@ -187,7 +581,7 @@ func TestDiskGraphStorageErrors(t *testing.T) {
 	FilenameNameDB = old
 
 	dgs := &DiskGraphStorage{invalidFileName, false, nil,
-		make(map[string]storage.Manager)}
+		make(map[string]storage.Manager), nil, nil, "", nil, nil, nil}
 	pm, _ := datautil.NewPersistentStringMap(invalidFileName)
 	dgs.mainDB = pm
 
@@ -196,6 +590,11 @@ func TestDiskGraphStorageErrors(t *testing.T) {
 	storage.MsmRetFlush = errors.New("TestError")
 	storage.MsmRetClose = errors.New("TestError")
 
+	defer func() {
+		storage.MsmRetFlush = nil
+		storage.MsmRetClose = nil
+	}()
+
 	if err := dgs.RollbackMain(); err == nil {
 		t.Error("Unexpected flush result")
 		return
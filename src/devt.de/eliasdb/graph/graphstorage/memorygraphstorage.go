@@ -10,7 +10,17 @@
 
 package graphstorage
 
-import "devt.de/eliasdb/storage"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"devt.de/common/datautil"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/storage"
+)
 
 /*
 MgsRetClose is the return value on successful close
@@ -93,6 +103,39 @@ func (mgs *MemoryGraphStorage) StorageManager(smname string, create bool) storag
 	return sm
 }
 
+/*
+StorageManagerNames returns the names of all existing storage managers in
+alphabetical order.
+*/
+func (mgs *MemoryGraphStorage) StorageManagerNames() []string {
+	ret := make([]string, 0, len(mgs.storagemanagers))
+
+	for smname := range mgs.storagemanagers {
+		ret = append(ret, smname)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+DeleteStorageManager closes a storage manager and removes all its data.
+Nothing happens if the storage manager does not exist.
+*/
+func (mgs *MemoryGraphStorage) DeleteStorageManager(smname string) error {
+
+	if sm, ok := mgs.storagemanagers[smname]; ok {
+		delete(mgs.storagemanagers, smname)
+
+		if err := sm.Close(); err != nil {
+			return &util.GraphError{Type: util.ErrClosing, Detail: err.Error()}
+		}
+	}
+
+	return nil
+}
+
 /*
 FlushAll writes all pending changes to the storage.
 */
@@ -106,3 +149,75 @@ Close closes the storage.
 func (mgs *MemoryGraphStorage) Close() error {
 	return MgsRetClose
 }
+
+/*
+Save writes the main database and all storage managers to a given directory.
+The directory is created if it does not exist. Each storage manager is
+written to its own file (see storage.MemoryStorageManager.Save).
+*/
+func (mgs *MemoryGraphStorage) Save(dir string) error {
+
+	err := os.MkdirAll(dir, 0770)
+
+	if err == nil {
+		var mainDB *datautil.PersistentStringMap
+
+		if mainDB, err = datautil.NewPersistentStringMap(filepath.Join(dir, FilenameNameDB)); err == nil {
+			mainDB.Data = mgs.mainDB
+			err = mainDB.Flush()
+		}
+	}
+
+	for smname, sm := range mgs.storagemanagers {
+		if err != nil {
+			break
+		}
+
+		if msm, ok := sm.(*storage.MemoryStorageManager); ok {
+			err = msm.Save(filepath.Join(dir, fmt.Sprintf("%v.%v", smname, storage.FileSuffixMemory)))
+		}
+	}
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: err.Error()}
+	}
+
+	return nil
+}
+
+/*
+LoadMemoryGraphStorage creates a new MemoryGraphStorage instance from a
+directory which was written by Save.
+*/
+func LoadMemoryGraphStorage(name string, dir string) (Storage, error) {
+	mgs := NewMemoryGraphStorage(name).(*MemoryGraphStorage)
+
+	mainDB, err := datautil.LoadPersistentStringMap(filepath.Join(dir, FilenameNameDB))
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	mgs.mainDB = mainDB.Data
+
+	files, err := filepath.Glob(filepath.Join(dir, "*."+storage.FileSuffixMemory))
+
+	for _, f := range files {
+		if err != nil {
+			break
+		}
+
+		smname := strings.TrimSuffix(filepath.Base(f), "."+storage.FileSuffixMemory)
+
+		msm := storage.NewMemoryStorageManager(mgs.name + "/" + smname)
+
+		if err = msm.Load(f); err == nil {
+			mgs.storagemanagers[smname] = msm
+		}
+	}
+
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	return mgs, nil
+}
@@ -10,7 +10,12 @@
 
 package graphstorage
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/hash"
+)
 
 func TestMemoryGraphStorage(t *testing.T) {
 	mstore := NewMemoryGraphStorage("mytest")
@@ -50,4 +55,96 @@ func TestMemoryGraphStorage(t *testing.T) {
 		t.Error("Unexpected result", res2)
 		return
 	}
+
+	mstore.StorageManager("001", true)
+
+	if res := fmt.Sprint(mstore.StorageManagerNames()); res != "[001 123]" {
+		t.Error("Unexpected result", res)
+		return
+	}
+
+	if err := mstore.DeleteStorageManager("123"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := mstore.DeleteStorageManager("456"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(mstore.StorageManagerNames()); res != "[001]" {
+		t.Error("Unexpected result", res)
+		return
+	}
+
+	if res := mstore.StorageManager("123", false); res != nil {
+		t.Error("Unexpected result", res)
+		return
+	}
+}
+
+func TestMemoryGraphStorageSaveLoad(t *testing.T) {
+	mstore := NewMemoryGraphStorage("mytest")
+
+	mstore.MainDB()["test1"] = "testvalue1"
+
+	sm := mstore.StorageManager("main.nodes", true)
+
+	htree, _ := hash.NewHTree(sm)
+	sm.SetRoot(2, htree.Location())
+
+	for i := 0; i < 100; i++ {
+		htree.Put([]byte{byte(i)}, i)
+	}
+
+	if err := mstore.(*MemoryGraphStorage).Save(diskGraphStorageTestDBDir8); err != nil {
+		t.Error(err)
+		return
+	}
+
+	mstore2, err := LoadMemoryGraphStorage("mytest2", diskGraphStorageTestDBDir8)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if mstore2.Name() != "mytest2" || mstore2.MainDB()["test1"] != "testvalue1" {
+		t.Error("Unexpected storage:", mstore2.Name(), mstore2.MainDB())
+		return
+	}
+
+	sm2 := mstore2.StorageManager("main.nodes", false)
+
+	htree2, err := hash.LoadHTree(sm2, sm2.Root(2))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		if res, err := htree2.Get([]byte{byte(i)}); err != nil || res != i {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+
+	// Loaded trees can be changed
+
+	htree2.Put([]byte{200}, 200)
+
+	if res, err := htree2.Get([]byte{200}); err != nil || res != 200 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := LoadMemoryGraphStorage("mytest3", diskGraphStorageTestDBDir8+"x"); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := mstore.(*MemoryGraphStorage).Save(invalidFileName); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
 }
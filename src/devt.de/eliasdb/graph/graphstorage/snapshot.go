@@ -0,0 +1,175 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graphstorage
+
+import (
+	"fmt"
+	"sort"
+
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/storage"
+)
+
+/*
+StorageSnapshot is a readonly graph storage which contains snapshots of all
+storage managers of a graph storage. Storage managers which are created after
+the snapshot was taken are not part of the snapshot.
+*/
+type StorageSnapshot struct {
+	name            string                     // Name of the graph storage
+	mainDB          map[string]string          // Copy of the main database
+	storagemanagers map[string]storage.Manager // Map of StorageManagers
+	snapshots       []storage.Manager          // Snapshots which are closed with the storage
+}
+
+/*
+newStorageSnapshot creates snapshots of a given set of storage managers.
+Storage managers which do not support snapshots (e.g. archives) must be
+readonly - they are used directly.
+*/
+func newStorageSnapshot(name string, mainDB map[string]string,
+	storagemanagers map[string]storage.Manager) (*StorageSnapshot, error) {
+
+	ss := &StorageSnapshot{name, make(map[string]string, len(mainDB)),
+		make(map[string]storage.Manager, len(storagemanagers)), nil}
+
+	for k, v := range mainDB {
+		ss.mainDB[k] = v
+	}
+
+	for smname, sm := range storagemanagers {
+		if s, ok := sm.(storage.Snapshotter); ok {
+			snap, err := s.Snapshot()
+			if err != nil {
+				ss.Close()
+				return nil, &util.GraphError{
+					Type:   util.ErrOpening,
+					Detail: fmt.Sprintf("Could not take snapshot of %v: %v", smname, err),
+				}
+			}
+
+			ss.snapshots = append(ss.snapshots, snap)
+			sm = snap
+		}
+
+		ss.storagemanagers[smname] = sm
+	}
+
+	return ss, nil
+}
+
+/*
+Name returns the name of the graph storage of the snapshot.
+*/
+func (ss *StorageSnapshot) Name() string {
+	return ss.name
+}
+
+/*
+MainDB returns the main database as it was at the time of the snapshot.
+*/
+func (ss *StorageSnapshot) MainDB() map[string]string {
+	return ss.mainDB
+}
+
+/*
+RollbackMain is not supported by a snapshot.
+*/
+func (ss *StorageSnapshot) RollbackMain() error {
+	return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot rollback main db of a snapshot"}
+}
+
+/*
+FlushMain is not supported by a snapshot.
+*/
+func (ss *StorageSnapshot) FlushMain() error {
+	return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot flush main db of a snapshot"}
+}
+
+/*
+FlushAll is a NOP for a snapshot.
+*/
+func (ss *StorageSnapshot) FlushAll() error {
+	return nil
+}
+
+/*
+StorageManager gets the snapshot of a storage manager with a certain name.
+Storage managers are never created.
+*/
+func (ss *StorageSnapshot) StorageManager(smname string, create bool) storage.Manager {
+	return ss.storagemanagers[smname]
+}
+
+/*
+StorageManagerNames returns the names of all storage managers of the snapshot
+in alphabetical order.
+*/
+func (ss *StorageSnapshot) StorageManagerNames() []string {
+	ret := make([]string, 0, len(ss.storagemanagers))
+
+	for smname := range ss.storagemanagers {
+		ret = append(ret, smname)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+DeleteStorageManager is not supported by a snapshot.
+*/
+func (ss *StorageSnapshot) DeleteStorageManager(smname string) error {
+	return &util.GraphError{Type: util.ErrReadOnly, Detail: "Cannot delete " + smname + " of a snapshot"}
+}
+
+/*
+Close closes the snapshot. The storage managers of the snapshot can no longer
+be used.
+*/
+func (ss *StorageSnapshot) Close() error {
+	for _, snap := range ss.snapshots {
+		snap.Close()
+	}
+
+	ss.snapshots = nil
+	ss.storagemanagers = make(map[string]storage.Manager)
+
+	return nil
+}
+
+/*
+Snapshot returns a readonly snapshot of the graph storage. All storage
+managers of the graph storage are opened. Records which are changed while the
+snapshot is open are copied into memory before they are changed. Archiving a
+storage manager closes its snapshots. The caller must make sure that there are
+no pending changes and that no changes are made while the snapshot is taken.
+*/
+func (dgs *DiskGraphStorage) Snapshot() (*StorageSnapshot, error) {
+
+	// Open all storage managers - snapshots cannot see storage managers
+	// which are opened later
+
+	for _, smname := range dgs.StorageManagerNames() {
+		dgs.StorageManager(smname, false)
+	}
+
+	return newStorageSnapshot(dgs.name, dgs.mainDB.Data, dgs.storagemanagers)
+}
+
+/*
+Snapshot returns a readonly snapshot of the graph storage. The snapshot holds
+a copy of all stored objects.
+*/
+func (mgs *MemoryGraphStorage) Snapshot() (*StorageSnapshot, error) {
+	return newStorageSnapshot(mgs.name, mgs.mainDB, mgs.storagemanagers)
+}
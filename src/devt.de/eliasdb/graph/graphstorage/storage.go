@@ -49,8 +49,68 @@ type Storage interface {
 	*/
 	StorageManager(smname string, create bool) storage.Manager
 
+	/*
+		StorageManagerNames returns the names of all existing storage managers
+		in alphabetical order.
+	*/
+	StorageManagerNames() []string
+
+	/*
+		DeleteStorageManager closes a storage manager and removes all its data.
+		Nothing happens if the storage manager does not exist.
+	*/
+	DeleteStorageManager(smname string) error
+
 	/*
 		Close closes the storage.
 	*/
 	Close() error
 }
+
+/*
+ArchiveStorage is implemented by graph storages which can seal storage
+managers into readonly archives.
+*/
+type ArchiveStorage interface {
+
+	/*
+		ArchiveStorageManager replaces a storage manager with a readonly
+		compressed archive. The archived data can still be read but it
+		can no longer be changed.
+	*/
+	ArchiveStorageManager(smname string) error
+}
+
+/*
+BackupStorage is implemented by graph storages which can write a copy of
+their data while they stay writable.
+*/
+type BackupStorage interface {
+
+	/*
+		Backup writes a copy of all storage files to a given directory.
+		The copy can be opened as a graph storage.
+	*/
+	Backup(targetDir string) error
+
+	/*
+		IncrementalBackup writes all changes since the last backup to a
+		given directory. The increment can be applied to a copy of the last
+		backup with RestoreBackup.
+	*/
+	IncrementalBackup(targetDir string) error
+}
+
+/*
+SnapshotStorage is implemented by graph storages which can provide readonly
+snapshots of their data.
+*/
+type SnapshotStorage interface {
+
+	/*
+		Snapshot returns a readonly graph storage which sees the data as it
+		was at the time of the call. Later changes are not visible in the
+		snapshot. The snapshot must be closed once it is no longer needed.
+	*/
+	Snapshot() (*StorageSnapshot, error)
+}
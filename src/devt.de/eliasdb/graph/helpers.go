@@ -126,7 +126,7 @@ func (gm *Manager) checkEdge(edge data.Edge) error {
 		return &util.GraphError{Type: util.ErrInvalidData, Detail: "Edge is missing a cascading value for end2"}
 	}
 
-	return nil
+	return gm.schemas.checkEdge(edge)
 }
 
 /*
@@ -364,6 +364,11 @@ func (gm *Manager) flushEdgeIndex(part string, kind string) error {
 rollbackNodeStorage rollbacks a node storage.
 */
 func (gm *Manager) rollbackNodeStorage(part string, kind string) error {
+
+	// The key index might contain keys which are no longer in the storage
+
+	gm.keyIndex.invalidate(part, kind)
+
 	if sm := gm.gs.StorageManager(part+kind+StorageSuffixNodes, false); sm != nil {
 		if err := sm.Rollback(); err != nil {
 			return &util.GraphError{Type: util.ErrRollback, Detail: err.Error()}
@@ -443,10 +448,80 @@ func (gm *Manager) getHTree(sm storage.Manager, slot int) (*hash.HTree, error) {
 	return htree, err
 }
 
+/*
+getMultiHTree returns a MultiHTree from a given storage.Manager with a given root slot.
+*/
+func (gm *Manager) getMultiHTree(sm storage.Manager, slot int) (*hash.MultiHTree, error) {
+	var tree *hash.MultiHTree
+	var err error
+
+	loc := sm.Root(slot)
+
+	if loc == 0 {
+
+		// Create a new MultiHTree and store its location
+
+		tree, err = hash.NewMultiHTree(sm)
+
+		if err != nil {
+			err = &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+		} else {
+			sm.SetRoot(slot, tree.Location())
+		}
+
+	} else {
+
+		// Load existing MultiHTree
+
+		tree, err = hash.LoadMultiHTree(sm, loc)
+		if err != nil {
+			err = &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+		}
+	}
+
+	return tree, err
+}
+
+/*
+getBTree returns a BTree from a given storage.Manager with a given root slot.
+*/
+func (gm *Manager) getBTree(sm storage.Manager, slot int) (*hash.BTree, error) {
+	var tree *hash.BTree
+	var err error
+
+	loc := sm.Root(slot)
+
+	if loc == 0 {
+
+		// Create a new BTree and store its location
+
+		tree, err = hash.NewBTree(sm)
+
+		if err != nil {
+			err = &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+		} else {
+			sm.SetRoot(slot, tree.Location())
+		}
+
+	} else {
+
+		// Load existing BTree
+
+		tree, err = hash.LoadBTree(sm, loc)
+		if err != nil {
+			err = &util.GraphError{Type: util.ErrAccessComponent, Detail: err.Error()}
+		}
+	}
+
+	return tree, err
+}
+
 /*
 getMainDBMap gets a map from the main database.
 */
 func (gm *Manager) getMainDBMap(key string) map[string]string {
+	gm.mapLock.Lock()
+	defer gm.mapLock.Unlock()
 
 	// First try to cache
 
@@ -471,6 +546,9 @@ storeMainDBMap stores a map in the main database. The map is stored as a gob byt
 Once it has been decoded it is cached for read operations.
 */
 func (gm *Manager) storeMainDBMap(key string, mapval map[string]string) {
+	gm.mapLock.Lock()
+	defer gm.mapLock.Unlock()
+
 	gm.mapCache[key] = mapval
 	gm.gs.MainDB()[key] = mapToString(mapval)
 }
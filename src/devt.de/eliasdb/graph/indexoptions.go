@@ -0,0 +1,245 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"devt.de/common/stringutil"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+indexOptionsRegistry holds the full text index options of node kinds.
+*/
+type indexOptionsRegistry struct {
+	opts  map[string]*util.IndexOptions // Index options by node kind
+	mutex *sync.Mutex                   // Mutex to protect the registry
+}
+
+/*
+newIndexOptionsRegistry creates a new registry and loads all index options
+which are stored in a given main database map.
+*/
+func newIndexOptionsRegistry(stored map[string]string) *indexOptionsRegistry {
+	r := &indexOptionsRegistry{make(map[string]*util.IndexOptions), &sync.Mutex{}}
+
+	for kind, val := range stored {
+		opts := &util.IndexOptions{}
+
+		if err := json.Unmarshal([]byte(val), opts); err == nil {
+			r.opts[kind] = opts
+		}
+	}
+
+	return r
+}
+
+/*
+forKind returns the index options of a given node kind (nil if the kind uses
+the default options).
+*/
+func (r *indexOptionsRegistry) forKind(kind string) *util.IndexOptions {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.opts[kind]
+}
+
+/*
+IndexOptions returns the options of the full text index of a node kind.
+*/
+func (gm *Manager) IndexOptions(kind string) *util.IndexOptions {
+	if opts := gm.indexOpts.forKind(kind); opts != nil {
+		ret := *opts
+		return &ret
+	}
+
+	return util.DefaultIndexOptions()
+}
+
+/*
+SetIndexOptions sets the options of the full text index of a node kind. The
+options control case folding, stemming, the tokenizer which splits values
+into words and if fuzzy lookups are supported. The index is rebuilt from the
+existing nodes of all partitions. The default options are restored if no
+options are given.
+*/
+func (gm *Manager) SetIndexOptions(kind string, opts *util.IndexOptions) error {
+
+	if !stringutil.IsAlphaNumeric(kind) {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Node kind %v is not alphanumeric - can only contain [a-zA-Z0-9_]", kind),
+		}
+	}
+
+	if opts != nil {
+		if _, err := util.LookupTokenizer(opts.Tokenizer); err != nil {
+			return err
+		}
+
+		o := *opts
+		opts = &o
+	}
+
+	// Take writer lock
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "SetIndexOptions")
+	defer gm.mutex.Unlock()
+
+	if err := gm.rebuildNodeIndex(kind, opts); err != nil {
+		return err
+	}
+
+	gm.indexOpts.mutex.Lock()
+	if opts != nil {
+		gm.indexOpts.opts[kind] = opts
+	} else {
+		delete(gm.indexOpts.opts, kind)
+	}
+	gm.indexOpts.mutex.Unlock()
+
+	stored := make(map[string]string)
+	for k, v := range gm.getMainDBMap(MainDBIndexOptions) {
+		if k != kind {
+			stored[k] = v
+		}
+	}
+
+	if opts != nil {
+		val, _ := json.Marshal(opts)
+		stored[kind] = string(val)
+	}
+
+	gm.storeMainDBMap(MainDBIndexOptions, stored)
+
+	return gm.gs.FlushMain()
+}
+
+/*
+nodeIndexManager returns an index manager for the full text index of a node
+kind.
+*/
+func (gm *Manager) nodeIndexManager(iht *hash.HTree, kind string) *util.IndexManager {
+	return util.NewIndexManagerWithOptions(iht, gm.indexOpts.forKind(kind))
+}
+
+/*
+rebuildNodeIndex removes all entries of the full text index of a node kind
+and indexes all existing nodes with the given options. It is assumed that
+the caller holds the writer lock.
+*/
+func (gm *Manager) rebuildNodeIndex(kind string, opts *util.IndexOptions) error {
+	var parts []string
+
+	err := func() error {
+
+		for _, part := range gm.Partitions() {
+
+			sm := gm.gs.StorageManager(part+kind+StorageSuffixNodes, false)
+			if sm == nil {
+				continue
+			}
+
+			iht, err := gm.getNodeIndexHTree(part, kind, true)
+			if err != nil {
+				return err
+			}
+
+			parts = append(parts, part)
+
+			// Remove all existing entries
+
+			var ikeys [][]byte
+
+			it := hash.NewHTreeIterator(iht)
+
+			for it.HasNext() {
+				k, _ := it.Next()
+				ikeys = append(ikeys, k)
+			}
+
+			if it.LastError != nil {
+				return &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+			}
+
+			for _, ikey := range ikeys {
+				if _, err := iht.Remove(ikey); err != nil {
+					return util.NewWritingError(err)
+				}
+			}
+
+			// Index all nodes
+
+			attrTree, err := gm.getHTree(sm, RootIDNodeHTree)
+			if err != nil {
+				return err
+			}
+
+			valTree, err := gm.getHTree(sm, RootIDNodeHTreeSecond)
+			if err != nil {
+				return err
+			}
+
+			im := util.NewIndexManagerWithOptions(iht, opts)
+
+			nit := attrTree.IteratorPrefix([]byte(PrefixNSAttrs))
+
+			for nit.HasNext() {
+				k, _ := nit.Next()
+
+				if nit.LastError != nil {
+					break
+				}
+
+				key := string(k[len(PrefixNSAttrs):])
+
+				node, err := gm.readNode(key, kind, nil, attrTree, valTree)
+				if err != nil {
+					return err
+				} else if node == nil {
+					continue
+				}
+
+				if err := im.Index(key, node.IndexMap()); err != nil {
+					return err
+				}
+			}
+
+			if nit.LastError != nil {
+				return &util.GraphError{Type: util.ErrReading, Detail: nit.LastError.Error()}
+			}
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		for _, part := range parts {
+			gm.rollbackNodeIndex(part, kind)
+		}
+
+		return err
+	}
+
+	for _, part := range parts {
+		if err := gm.flushNodeIndex(part, kind); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/graph/util"
+)
+
+func TestIndexOptions(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	newBook := func(key string, title string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Book")
+		node.SetAttr("title", title)
+		return node
+	}
+
+	lookup := func(gm *Manager, word string) string {
+		iq, err := gm.NodeIndexQuery("main", "Book")
+		if err != nil {
+			return err.Error()
+		}
+		res, err := iq.LookupWord("title", word)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprint(res)
+	}
+
+	fuzzy := func(gm *Manager, word string, distance int) string {
+		iq, _ := gm.NodeIndexQuery("main", "Book")
+		res, err := iq.LookupFuzzy("title", word, distance)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprint(res)
+	}
+
+	gm.StoreNode("main", newBook("b1", "Connecting Graphs"))
+	gm.StoreNode("main", newBook("b2", "A graph database"))
+
+	if res := lookup(gm, "graph"); res != "map[b2:[2]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fuzzy(gm, "grahp", 1); res != "GraphError: Invalid data (Index does not support fuzzy lookups)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm.IndexOptions("Book"); fmt.Sprint(res) != "&{false false default false}" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Changing the options rebuilds the index
+
+	if err := gm.SetIndexOptions("Book", &util.IndexOptions{Stemming: true, Fuzzy: true}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := lookup(gm, "graph"); res != "map[b1:[2] b2:[2]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm, "connected"); res != "map[b1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fuzzy(gm, "grahp", 1); res != "map[b1:[2] b2:[2]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// New nodes and transactions use the options
+
+	gm.StoreNode("main", newBook("b3", "Graphing databases"))
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newBook("b4", "Databased"))
+	trans.RemoveNode("main", "b2", "Book")
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := lookup(gm, "database"); res != "map[b3:[2] b4:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fuzzy(gm, "datbase", 1); res != "map[b3:[2] b4:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Options are persisted
+
+	gm2 := NewGraphManager(mgs)
+
+	if res := gm2.IndexOptions("Book"); fmt.Sprint(res) != "&{false true  true}" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm2, "graphs"); res != "map[b1:[2] b3:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Default options can be restored
+
+	if err := gm2.SetIndexOptions("Book", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := lookup(gm2, "graph"); res != "map[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm2, "graphs"); res != "map[b1:[2]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := NewGraphManager(mgs).IndexOptions("Book"); res.Stemming {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Check errors
+
+	if err := gm.SetIndexOptions("Book", &util.IndexOptions{Tokenizer: "foo"}); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown tokenizer foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.SetIndexOptions("Bo ok", nil); err == nil || err.Error() !=
+		"GraphError: Invalid data (Node kind Bo ok is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -28,6 +28,13 @@ type IndexQuery interface {
 	*/
 	LookupWord(attr, word string) (map[string][]uint64, error)
 
+	/*
+		LookupFuzzy finds all nodes where an attribute contains a word which
+		differs by at most a given number of edits from a certain word. This
+		call returns a map which maps node key to a list of word positions.
+	*/
+	LookupFuzzy(attr, word string, distance int) (map[string][]uint64, error)
+
 	/*
 		LookupValue finds all nodes where an attribute has a certain value.
 		This call returns a list of node keys.
@@ -0,0 +1,190 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"devt.de/common/stringutil"
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+indexRegistry holds the attributes of node kinds which have an index of a
+certain type.
+*/
+type indexRegistry struct {
+	name      string              // Name of the index type
+	mainDBKey string              // MainDB entry key which stores the indexed attributes
+	attrs     map[string][]string // Indexed attributes by node kind
+	mutex     *sync.Mutex         // Mutex to protect the registry
+}
+
+/*
+newIndexRegistry creates a new index registry and loads all indexed
+attributes which are stored in a given main database map.
+*/
+func newIndexRegistry(name string, mainDBKey string, stored map[string]string) *indexRegistry {
+	r := &indexRegistry{name, mainDBKey, make(map[string][]string), &sync.Mutex{}}
+
+	for kind, val := range stored {
+		var attrs []string
+
+		if err := json.Unmarshal([]byte(val), &attrs); err == nil {
+			r.attrs[kind] = attrs
+		}
+	}
+
+	return r
+}
+
+/*
+forKind returns the indexed attributes of a given node kind.
+*/
+func (r *indexRegistry) forKind(kind string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.attrs[kind]
+}
+
+/*
+sorted returns a sorted copy of the indexed attributes of a given node kind.
+*/
+func (r *indexRegistry) sorted(kind string) []string {
+	ret := append([]string(nil), r.forKind(kind)...)
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+checkIndexed returns an error if an attribute of a node kind is not indexed.
+*/
+func (r *indexRegistry) checkIndexed(kind string, attr string) error {
+
+	if !containsString(r.forKind(kind), attr) {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Attribute %v of node kind %v has no %v index", attr, kind, r.name),
+		}
+	}
+
+	return nil
+}
+
+/*
+changeIndexedAttr adds or removes an indexed attribute of a node kind. The
+given build function adds or removes the index entries of all existing nodes
+of the kind. The indexed attributes are stored in the main database.
+*/
+func (gm *Manager) changeIndexedAttr(r *indexRegistry, kind string, attr string, add bool,
+	build func(kind string, attr string, add bool) error) error {
+
+	if !stringutil.IsAlphaNumeric(kind) {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Node kind %v is not alphanumeric - can only contain [a-zA-Z0-9_]", kind),
+		}
+	} else if attr == "" || attr == data.NodeKey || attr == data.NodeKind {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Attribute %v cannot have a %v index", attr, r.name),
+		}
+	}
+
+	// Take writer lock
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "changeIndexedAttr")
+	defer gm.mutex.Unlock()
+
+	attrs := r.forKind(kind)
+
+	if containsString(attrs, attr) == add {
+		return nil
+	}
+
+	if err := build(kind, attr, add); err != nil {
+		return err
+	}
+
+	// Build a new list so readers of the old list are not affected
+
+	var newAttrs []string
+
+	for _, a := range attrs {
+		if a != attr {
+			newAttrs = append(newAttrs, a)
+		}
+	}
+
+	if add {
+		newAttrs = append(newAttrs, attr)
+	}
+
+	return gm.storeIndexedAttrs(r, kind, newAttrs)
+}
+
+/*
+storeIndexedAttrs replaces the indexed attributes of a node kind and stores
+them in the main database.
+*/
+func (gm *Manager) storeIndexedAttrs(r *indexRegistry, kind string, newAttrs []string) error {
+
+	r.mutex.Lock()
+	if len(newAttrs) > 0 {
+		r.attrs[kind] = newAttrs
+	} else {
+		delete(r.attrs, kind)
+	}
+	r.mutex.Unlock()
+
+	stored := make(map[string]string)
+	for k, v := range gm.getMainDBMap(r.mainDBKey) {
+		if k != kind {
+			stored[k] = v
+		}
+	}
+
+	if len(newAttrs) > 0 {
+		val, _ := json.Marshal(newAttrs)
+		stored[kind] = string(val)
+	}
+
+	gm.storeMainDBMap(r.mainDBKey, stored)
+
+	return gm.gs.FlushMain()
+}
+
+/*
+updateAttrIndexes updates the value, range and geo indexes of a node kind after a
+node was written or deleted (the node is nil). It is assumed that the caller
+holds the writer lock.
+*/
+func (gm *Manager) updateAttrIndexes(part string, key string, kind string, node data.Node,
+	oldnode data.Node, partial bool) error {
+
+	if err := gm.updateValueIndex(part, key, kind, node, oldnode, partial); err != nil {
+		return err
+	}
+
+	if err := gm.updateRangeIndex(part, key, kind, node, oldnode, partial); err != nil {
+		return err
+	}
+
+	return gm.updateGeoIndex(part, key, kind, node, oldnode, partial)
+}
@@ -0,0 +1,494 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"devt.de/common/stringutil"
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+InvariantViolationKind is the node kind which is used to record invariant
+violations. Violation nodes are stored in the partition of the violating node.
+*/
+const InvariantViolationKind = "InvariantViolation"
+
+/*
+Invariant is a declarative integrity rule for nodes of a certain kind. Every
+node of the kind must have between Min and Max edges which match a given
+traversal spec (e.g. an invariant with kind Order, spec
+order:PlacedBy:customer:Customer, min 1 and max 1 means that every Order has
+exactly one PlacedBy edge to a Customer).
+*/
+type Invariant struct {
+	Name string `json:"name"` // Name of the invariant
+	Kind string `json:"kind"` // Node kind which is checked
+	Spec string `json:"spec"` // Traversal spec of the counted edges (may be partial)
+	Min  int    `json:"min"`  // Minimum number of matching edges
+	Max  int    `json:"max"`  // Maximum number of matching edges (-1 for no limit)
+}
+
+/*
+InvariantStats contains the metrics of an invariant.
+*/
+type InvariantStats struct {
+	Checks     uint64 // Number of nodes which were checked
+	Violations uint64 // Number of checks which found a violation
+}
+
+/*
+invariantChecker holds the declared invariants and their metrics.
+*/
+type invariantChecker struct {
+	invariants map[string]*Invariant      // Declared invariants
+	stats      map[string]*InvariantStats // Metrics for each invariant
+	mutex      *sync.Mutex                // Mutex to protect the checker
+}
+
+/*
+newInvariantChecker creates a new invariant checker and loads all invariants
+which are stored in a given main database map.
+*/
+func newInvariantChecker(stored map[string]string) *invariantChecker {
+	c := &invariantChecker{make(map[string]*Invariant), make(map[string]*InvariantStats),
+		&sync.Mutex{}}
+
+	for name, val := range stored {
+		inv := &Invariant{}
+
+		if err := json.Unmarshal([]byte(val), inv); err == nil {
+			c.invariants[name] = inv
+			c.stats[name] = &InvariantStats{}
+		}
+	}
+
+	return c
+}
+
+/*
+forKind returns all invariants for a given node kind.
+*/
+func (c *invariantChecker) forKind(kind string) []*Invariant {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var ret []*Invariant
+
+	for _, inv := range c.invariants {
+		if inv.Kind == kind {
+			ret = append(ret, inv)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+/*
+record records the result of a check.
+*/
+func (c *invariantChecker) record(inv *Invariant, violated bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if stats, ok := c.stats[inv.Name]; ok {
+		stats.Checks++
+
+		if violated {
+			stats.Violations++
+		}
+	}
+}
+
+/*
+check checks all invariants of a given node and records violations in a given
+transaction. Violations of a node which does not exist (anymore) are removed.
+Returns all violation nodes which were recorded.
+*/
+func (c *invariantChecker) check(gm *Manager, trans *Trans, part string,
+	key string, kind string, exists bool) ([]data.Node, error) {
+
+	var ret []data.Node
+
+	for _, inv := range c.forKind(kind) {
+
+		if !exists {
+			if err := c.clearViolation(gm, trans, part, inv, key, kind); err != nil {
+				return ret, err
+			}
+			continue
+		}
+
+		_, edges, err := gm.TraverseMulti(part, key, kind, inv.Spec, false)
+		if err != nil {
+			return ret, err
+		}
+
+		count := len(edges)
+		violated := count < inv.Min || (inv.Max != -1 && count > inv.Max)
+
+		c.record(inv, violated)
+
+		if !violated {
+			err = c.clearViolation(gm, trans, part, inv, key, kind)
+		} else {
+			var node data.Node
+
+			if node, err = c.storeViolation(gm, trans, part, inv, key, kind, count); err == nil {
+				ret = append(ret, node)
+			}
+		}
+
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+storeViolation records a violation of an invariant. An existing violation
+node is only rewritten if the number of matching edges has changed.
+*/
+func (c *invariantChecker) storeViolation(gm *Manager, trans *Trans, part string,
+	inv *Invariant, key string, kind string, count int) (data.Node, error) {
+
+	vkey := violationKey(inv, key, kind)
+	tkey := trans.createKey(part, vkey, InvariantViolationKind)
+
+	if _, ok := trans.removeNodes[tkey]; !ok {
+		if node, ok := trans.storeNodes[tkey]; ok && fmt.Sprint(node.Attr("count")) == fmt.Sprint(count) {
+			return node, nil
+		}
+
+		existing, err := gm.FetchNode(part, vkey, InvariantViolationKind)
+		if err != nil {
+			return nil, err
+		} else if _, ok := trans.storeNodes[tkey]; !ok && existing != nil &&
+			fmt.Sprint(existing.Attr("count")) == fmt.Sprint(count) {
+			return existing, nil
+		}
+	}
+
+	node := data.NewGraphNode()
+
+	node.SetAttr(data.NodeKey, vkey)
+	node.SetAttr(data.NodeKind, InvariantViolationKind)
+	node.SetAttr(data.NodeName, fmt.Sprintf("%v violated by %v %v", inv.Name, kind, key))
+	node.SetAttr("invariant", inv.Name)
+	node.SetAttr("node_key", key)
+	node.SetAttr("node_kind", kind)
+	node.SetAttr("spec", inv.Spec)
+	node.SetAttr("count", count)
+	node.SetAttr("min", inv.Min)
+	node.SetAttr("max", inv.Max)
+	node.SetAttr("detected", time.Now().Unix())
+
+	return node, trans.StoreNode(part, node)
+}
+
+/*
+clearViolation removes a recorded violation of an invariant.
+*/
+func (c *invariantChecker) clearViolation(gm *Manager, trans *Trans, part string,
+	inv *Invariant, key string, kind string) error {
+
+	vkey := violationKey(inv, key, kind)
+
+	delete(trans.storeNodes, trans.createKey(part, vkey, InvariantViolationKind))
+
+	existing, err := gm.FetchNode(part, vkey, InvariantViolationKind)
+	if err != nil || existing == nil {
+		return err
+	}
+
+	return trans.RemoveNode(part, vkey, InvariantViolationKind)
+}
+
+/*
+violationKey returns the key of the violation node of a given invariant and node.
+*/
+func violationKey(inv *Invariant, key string, kind string) string {
+	return inv.Name + ":" + kind + ":" + key
+}
+
+/*
+validateInvariant checks that an invariant is well formed.
+*/
+func validateInvariant(inv *Invariant) error {
+
+	invError := func(detail string) error {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Invalid invariant %v: %v", inv.Name, detail),
+		}
+	}
+
+	if inv.Name == "" || !stringutil.IsAlphaNumeric(inv.Name) {
+		return invError("Name must be alphanumeric")
+	} else if inv.Kind == "" || !stringutil.IsAlphaNumeric(inv.Kind) {
+		return invError("Kind must be alphanumeric")
+	} else if inv.Kind == InvariantViolationKind {
+		return invError("Cannot check violation nodes")
+	} else if len(strings.Split(inv.Spec, ":")) != 4 {
+		return invError("Invalid spec " + inv.Spec)
+	} else if inv.Min < 0 || inv.Max < -1 || (inv.Max != -1 && inv.Max < inv.Min) {
+		return invError(fmt.Sprintf("Invalid range %v - %v", inv.Min, inv.Max))
+	}
+
+	return nil
+}
+
+/*
+SetInvariant declares an invariant. An existing invariant with the same name
+is replaced. Invariants are checked on every mutation of a node of their kind
+or of an edge which is connected to such a node. Existing nodes are only
+checked by CheckInvariants.
+*/
+func (gm *Manager) SetInvariant(inv *Invariant) error {
+
+	if err := validateInvariant(inv); err != nil {
+		return err
+	}
+
+	val, err := json.Marshal(inv)
+	if err != nil {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Invalid invariant %v: %v", inv.Name, err.Error()),
+		}
+	}
+
+	gm.invariants.mutex.Lock()
+
+	ninv := *inv
+	gm.invariants.invariants[inv.Name] = &ninv
+	gm.invariants.stats[inv.Name] = &InvariantStats{}
+
+	gm.invariants.mutex.Unlock()
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "SetInvariant")
+	defer gm.mutex.Unlock()
+
+	stored := make(map[string]string)
+	for k, v := range gm.getMainDBMap(MainDBInvariants) {
+		stored[k] = v
+	}
+	stored[inv.Name] = string(val)
+
+	gm.storeMainDBMap(MainDBInvariants, stored)
+
+	return gm.gs.FlushMain()
+}
+
+/*
+RemoveInvariant removes a declared invariant. Recorded violations of the
+invariant are not removed. Returns the removed invariant or nil if it did
+not exist.
+*/
+func (gm *Manager) RemoveInvariant(name string) (*Invariant, error) {
+
+	gm.invariants.mutex.Lock()
+
+	inv, ok := gm.invariants.invariants[name]
+	delete(gm.invariants.invariants, name)
+	delete(gm.invariants.stats, name)
+
+	gm.invariants.mutex.Unlock()
+
+	if !ok {
+		return nil, nil
+	}
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "RemoveInvariant")
+	defer gm.mutex.Unlock()
+
+	stored := make(map[string]string)
+	for k, v := range gm.getMainDBMap(MainDBInvariants) {
+		if k != name {
+			stored[k] = v
+		}
+	}
+
+	gm.storeMainDBMap(MainDBInvariants, stored)
+
+	return inv, gm.gs.FlushMain()
+}
+
+/*
+Invariants returns all declared invariants ordered by name.
+*/
+func (gm *Manager) Invariants() []*Invariant {
+	gm.invariants.mutex.Lock()
+	defer gm.invariants.mutex.Unlock()
+
+	var ret []*Invariant
+
+	for _, inv := range gm.invariants.invariants {
+		ninv := *inv
+		ret = append(ret, &ninv)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+/*
+InvariantStats returns the metrics of an invariant or nil if the invariant
+does not exist.
+*/
+func (gm *Manager) InvariantStats(name string) *InvariantStats {
+	gm.invariants.mutex.Lock()
+	defer gm.invariants.mutex.Unlock()
+
+	stats, ok := gm.invariants.stats[name]
+	if !ok {
+		return nil
+	}
+
+	ret := *stats
+
+	return &ret
+}
+
+/*
+CheckInvariants checks all nodes of a partition against all declared invariants.
+Violations are recorded as nodes of kind InvariantViolationKind and resolved
+violations are removed. Returns all violation nodes.
+*/
+func (gm *Manager) CheckInvariants(part string) ([]data.Node, error) {
+	var ret []data.Node
+
+	if err := gm.checkPartitionName(part); err != nil {
+		return nil, err
+	}
+
+	trans := NewGraphTrans(gm)
+	kinds := make(map[string]bool)
+
+	for _, inv := range gm.Invariants() {
+
+		if kinds[inv.Kind] {
+			continue
+		}
+
+		kinds[inv.Kind] = true
+
+		it, err := gm.NodeKeyIterator(part, inv.Kind)
+		if err != nil {
+			return nil, err
+		} else if it == nil {
+			continue
+		}
+
+		var keys []string
+
+		for it.HasNext() {
+			key := it.Next()
+
+			if it.LastError != nil {
+				return nil, it.LastError
+			}
+
+			keys = append(keys, key)
+		}
+
+		for _, key := range keys {
+
+			violations, err := gm.invariants.check(gm, trans, part, key, inv.Kind, true)
+			if err != nil {
+				return nil, err
+			}
+
+			ret = append(ret, violations...)
+		}
+	}
+
+	return ret, trans.Commit()
+}
+
+// System rule SystemRuleCheckInvariants
+// =====================================
+
+/*
+SystemRuleCheckInvariants is a system rule which checks declared invariants
+whenever nodes or edges are changed.
+*/
+type SystemRuleCheckInvariants struct {
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (r *SystemRuleCheckInvariants) Name() string {
+	return "system.checkinvariants"
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (r *SystemRuleCheckInvariants) Handles() []int {
+	return []int{EventNodeCreated, EventNodeUpdated, EventNodeDeleted,
+		EventEdgeCreated, EventEdgeDeleted}
+}
+
+/*
+Handle handles an event.
+*/
+func (r *SystemRuleCheckInvariants) Handle(gm *Manager, trans *Trans, event int, ed ...interface{}) error {
+	part := ed[0].(string)
+
+	if event == EventEdgeCreated || event == EventEdgeDeleted {
+		edge := ed[1].(data.Edge)
+
+		for _, end := range [][]string{{edge.End1Key(), edge.End1Kind()},
+			{edge.End2Key(), edge.End2Kind()}} {
+
+			if len(gm.invariants.forKind(end[1])) == 0 {
+				continue
+			}
+
+			node, err := gm.FetchNode(part, end[0], end[1])
+			if err == nil {
+				_, err = gm.invariants.check(gm, trans, part, end[0], end[1], node != nil)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	node := ed[1].(data.Node)
+
+	_, err := gm.invariants.check(gm, trans, part, node.Key(), node.Kind(),
+		event != EventNodeDeleted)
+
+	return err
+}
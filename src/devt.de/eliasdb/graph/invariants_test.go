@@ -0,0 +1,202 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestInvariants(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	newNode := func(key string, kind string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, kind)
+		return node
+	}
+
+	newEdge := func(key string, order string) data.Edge {
+		edge := data.NewGraphEdge()
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, "PlacedBy")
+		edge.SetAttr(data.EdgeEnd1Key, order)
+		edge.SetAttr(data.EdgeEnd1Kind, "Order")
+		edge.SetAttr(data.EdgeEnd1Role, "order")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, "c1")
+		edge.SetAttr(data.EdgeEnd2Kind, "Customer")
+		edge.SetAttr(data.EdgeEnd2Role, "customer")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		return edge
+	}
+
+	violation := func(order string) data.Node {
+		res, err := gm.FetchNode("main", "placedBy:Order:"+order, InvariantViolationKind)
+		if err != nil {
+			t.Error(err)
+		}
+		return res
+	}
+
+	gm.StoreNode("main", newNode("c1", "Customer"))
+	gm.StoreNode("main", newNode("o1", "Order"))
+
+	if err := gm.SetInvariant(&Invariant{"placedBy", "Order", "order:PlacedBy:customer:Customer", 1, 1}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Existing nodes are only checked on demand
+
+	if res := violation("o1"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, err := gm.CheckInvariants("main"); err != nil || len(res) != 1 ||
+		res[0].Attr("node_key") != "o1" || res[0].Attr("count") != 0 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := violation("o1"); res == nil || res.Name() != "placedBy violated by Order o1" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Adding an edge resolves the violation
+
+	if err := gm.StoreEdge("main", newEdge("e1", "o1")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := violation("o1"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// A second edge violates the maximum
+
+	gm.StoreEdge("main", newEdge("e2", "o1"))
+
+	if res := violation("o1"); res == nil || fmt.Sprint(res.Attr("count")) != "2" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	gm.RemoveEdge("main", "e2", "PlacedBy")
+
+	if res := violation("o1"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// New nodes are checked and a violation within a transaction is resolved
+	// before it is written
+
+	gm.StoreNode("main", newNode("o2", "Order"))
+
+	if res := violation("o2"); res == nil {
+		t.Error("Violation expected")
+		return
+	}
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newNode("o3", "Order"))
+	trans.StoreEdge("main", newEdge("e3", "o3"))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := violation("o3"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Removing a node removes its violations
+
+	gm.RemoveNode("main", "o2", "Order")
+
+	if res := violation("o2"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := gm.InvariantStats("placedBy"); res == nil || res.Violations != 4 || res.Checks != 7 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Invariants are persisted
+
+	gm2 := NewGraphManager(mgs)
+
+	if res := gm2.Invariants(); len(res) != 1 || fmt.Sprint(*res[0]) !=
+		"{placedBy Order order:PlacedBy:customer:Customer 1 1}" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, err := gm2.RemoveInvariant("placedBy"); err != nil || res == nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := gm2.RemoveInvariant("placedBy"); err != nil || res != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := NewGraphManager(mgs).Invariants(); len(res) != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Test error cases
+
+	if err := gm.SetInvariant(&Invariant{"a b", "Order", ":::", 0, -1}); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid invariant a b: Name must be alphanumeric)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.SetInvariant(&Invariant{"a", InvariantViolationKind, ":::", 0, -1}); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid invariant a: Cannot check violation nodes)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.SetInvariant(&Invariant{"a", "Order", "::", 0, -1}); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid invariant a: Invalid spec ::)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.SetInvariant(&Invariant{"a", "Order", ":::", 2, 1}); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid invariant a: Invalid range 2 - 1)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := gm.CheckInvariants("a b"); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
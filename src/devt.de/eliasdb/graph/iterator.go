@@ -13,6 +13,7 @@ package graph
 import (
 	"devt.de/eliasdb/graph/util"
 	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/lockprof"
 )
 
 /*
@@ -20,6 +21,8 @@ NodeKeyIterator can be used to iterate node keys of a certain node kind.
 */
 type NodeKeyIterator struct {
 	gm        *Manager            // GraphManager which created the iterator
+	part      string              // Partition of the iterated nodes
+	kind      string              // Kind of the iterated nodes
 	it        *hash.HTreeIterator // Internal HTree iterator
 	LastError error               // Last encountered error
 }
@@ -31,7 +34,7 @@ func (it *NodeKeyIterator) Next() string {
 
 	// Take reader lock
 
-	it.gm.mutex.RLock()
+	lockprof.RLock(it.gm.mutex, lockprof.LockGraph, "Next")
 	defer it.gm.mutex.RUnlock()
 
 	k, _ := it.it.Next()
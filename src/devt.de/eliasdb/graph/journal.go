@@ -0,0 +1,439 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+JournalRecord is a set of changes which were committed together.
+*/
+type JournalRecord struct {
+	Time int64    // Commit time of the changes (Unix time in nanoseconds)
+	Log  TransLog // Committed changes
+}
+
+/*
+journalRecord is the stored form of a journal record.
+*/
+type journalRecord struct {
+	Time    int64           // Commit time of the changes
+	Changes []*journalEntry // Committed changes
+}
+
+/*
+journalEntry is the stored form of a single change. Nodes and edges are
+stored as attribute maps.
+*/
+type journalEntry struct {
+	Event int                    // Graph event of the change
+	Part  string                 // Partition of the changed node or edge
+	New   map[string]interface{} // Stored node or edge
+	Old   map[string]interface{} // Previous version of the node or edge
+}
+
+/*
+Journal is a durable append-only log of all changes which were committed to a
+graph database. Every record has a commit time which can be used to recover
+the database to an earlier point in time (see Manager.RecoverToTime). Each
+record is written with a length prefix and synced to disk before the commit
+returns. An incomplete record at the end of the journal (e.g. after a crash)
+is removed when the journal is opened. A record which cannot be decoded
+is reported as corruption. The journal keeps an index of the commit times and
+file positions of all records so only the requested records are read.
+*/
+type Journal struct {
+	filename string            // File of the journal
+	file     *os.File          // Open journal file
+	last     int64             // Commit time of the last record
+	size     int64             // Size of the journal file
+	index    []journalPosition // Commit times and positions of all records
+	mutex    *sync.Mutex       // Mutex to protect the journal
+}
+
+/*
+journalPosition is the commit time and the file position of a journal record.
+*/
+type journalPosition struct {
+	Time   int64 // Commit time of the record
+	Offset int64 // Position of the record in the journal file
+}
+
+/*
+OpenJournal opens a journal file. The file is created if it does not exist.
+*/
+func OpenJournal(filename string) (*Journal, error) {
+	var index []journalPosition
+
+	size, err := readJournal(filename, 0, func(offset int64, rec *journalRecord) {
+		index = append(index, journalPosition{rec.Time, offset})
+	})
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	// Remove an incomplete record from the end of the file
+
+	if err = file.Truncate(size); err == nil {
+		_, err = file.Seek(size, io.SeekStart)
+	}
+
+	if err != nil {
+		file.Close()
+		return nil, &util.GraphError{Type: util.ErrOpening, Detail: err.Error()}
+	}
+
+	j := &Journal{filename, file, 0, size, index, &sync.Mutex{}}
+
+	if len(index) > 0 {
+		j.last = index[len(index)-1].Time
+	}
+
+	return j, nil
+}
+
+/*
+Close closes the journal.
+*/
+func (j *Journal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file = nil
+
+	return err
+}
+
+/*
+Records returns all records of the journal whose commit time is after a given
+time. A time of 0 returns all records.
+*/
+func (j *Journal) Records(since int64) ([]*JournalRecord, error) {
+	var ret []*JournalRecord
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	// Commit times always increase - find the first requested record
+
+	i := sort.Search(len(j.index), func(i int) bool {
+		return j.index[i].Time > since
+	})
+
+	if i == len(j.index) {
+		return nil, nil
+	}
+
+	_, err := readJournal(j.filename, j.index[i].Offset, func(offset int64, rec *journalRecord) {
+		ret = append(ret, rec.journalRecord())
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+/*
+write appends the changes of a commit to the journal. Commit times always
+increase even if the clock of the system goes backwards.
+*/
+func (j *Journal) write(log TransLog) error {
+	var buf bytes.Buffer
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file == nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: "Journal is closed"}
+	}
+
+	ts := time.Now().UnixNano()
+	if ts <= j.last {
+		ts = j.last + 1
+	}
+
+	rec := &journalRecord{ts, make([]*journalEntry, 0, len(log))}
+
+	for _, e := range log {
+		entry := &journalEntry{e.Event, e.Part, nil, nil}
+
+		if e.Node != nil {
+			entry.New = e.Node.Data()
+		} else if e.Edge != nil {
+			entry.New = e.Edge.Data()
+		}
+
+		if e.OldNode != nil {
+			entry.Old = e.OldNode.Data()
+		} else if e.OldEdge != nil {
+			entry.Old = e.OldEdge.Data()
+		}
+
+		rec.Changes = append(rec.Changes, entry)
+	}
+
+	buf.Write(make([]byte, 4))
+
+	err := gob.NewEncoder(&buf).Encode(rec)
+
+	if err == nil {
+		b := buf.Bytes()
+		binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+
+		if _, err = j.file.Write(b); err == nil {
+			err = j.file.Sync()
+		}
+	}
+
+	if err != nil {
+		return &util.GraphError{Type: util.ErrWriting, Detail: "Could not write journal: " + err.Error()}
+	}
+
+	j.index = append(j.index, journalPosition{ts, j.size})
+	j.size += int64(buf.Len())
+	j.last = ts
+
+	return nil
+}
+
+/*
+readJournal reads all complete records of a journal file from a given
+position. The given function is called for every record. Returns the size of
+the file which is taken up by complete records. An incomplete record at the
+end of the file is ignored - a record which cannot be decoded is an error.
+*/
+func readJournal(filename string, offset int64, f func(offset int64, rec *journalRecord)) (int64, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, err
+		}
+		return 0, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+
+	if err != nil {
+		return 0, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	in := bufio.NewReader(file)
+	header := make([]byte, 4)
+
+	for {
+		var rec journalRecord
+
+		if _, err := io.ReadFull(in, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return offset, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		}
+
+		// A record which is longer than the rest of the file was not
+		// completely written
+
+		n := int64(binary.BigEndian.Uint32(header))
+
+		if n > fi.Size()-offset-4 {
+			break
+		}
+
+		b := make([]byte, n)
+
+		if _, err := io.ReadFull(in, b); err != nil {
+			return offset, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		} else if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&rec); err != nil {
+			return offset, &util.GraphError{Type: util.ErrJournalCorrupt,
+				Detail: fmt.Sprintf("Record at position %v: %v", offset, err)}
+		}
+
+		f(offset, &rec)
+
+		offset += n + 4
+	}
+
+	return offset, nil
+}
+
+/*
+journalRecord returns the changes of a stored journal record.
+*/
+func (rec *journalRecord) journalRecord() *JournalRecord {
+	log := make(TransLog, 0, len(rec.Changes))
+
+	for _, c := range rec.Changes {
+		e := &TransLogEntry{c.Event, c.Part, nil, nil, nil, nil}
+
+		if c.Event >= EventEdgeCreated {
+			if c.New != nil {
+				e.Edge = data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(c.New))
+			}
+			if c.Old != nil {
+				e.OldEdge = data.NewGraphEdgeFromNode(data.NewGraphNodeFromMap(c.Old))
+			}
+		} else {
+			if c.New != nil {
+				e.Node = data.NewGraphNodeFromMap(c.New)
+			}
+			if c.Old != nil {
+				e.OldNode = data.NewGraphNodeFromMap(c.Old)
+			}
+		}
+
+		log = append(log, e)
+	}
+
+	return &JournalRecord{rec.Time, log}
+}
+
+/*
+SetJournal sets a journal which records all committed changes of the graph
+database. A nil value stops the journaling.
+*/
+func (gm *Manager) SetJournal(j *Journal) {
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "SetJournal")
+	defer gm.mutex.Unlock()
+
+	gm.journal = j
+}
+
+/*
+JournalRecords returns all records of the journal of the graph database whose
+commit time is after a given time (Unix time in nanoseconds).
+*/
+func (gm *Manager) JournalRecords(since int64) ([]*JournalRecord, error) {
+
+	if gm.journal == nil {
+		return nil, &util.GraphError{Type: util.ErrInvalidData, Detail: "Graph manager has no journal"}
+	}
+
+	return gm.journal.Records(since)
+}
+
+/*
+journalChanges writes committed changes to the journal if one was set.
+*/
+func (gm *Manager) journalChanges(log TransLog) error {
+	if gm.journal == nil || len(log) == 0 {
+		return nil
+	}
+
+	return gm.journal.write(log)
+}
+
+/*
+journalOperation writes a single graph operation together with the changes of
+the graph rules which were triggered by it to the journal.
+*/
+func (gm *Manager) journalOperation(entry *TransLogEntry, trans *Trans) error {
+	return gm.journalChanges(append(TransLog{entry}, trans.Log()...))
+}
+
+/*
+RecoverToTime reverts all changes of the journal which were committed after a
+given point in time (Unix time in nanoseconds). The recovery is a new commit
+which is also recorded in the journal - it can be reverted itself by
+recovering to the time before the recovery. Returns the log of the recovery.
+*/
+func (gm *Manager) RecoverToTime(ts int64) (TransLog, error) {
+
+	if gm.journal == nil {
+		return nil, &util.GraphError{Type: util.ErrInvalidData, Detail: "Graph manager has no journal"}
+	}
+
+	records, err := gm.journal.Records(ts)
+	if err != nil {
+		return nil, err
+	}
+
+	var log TransLog
+
+	for _, r := range records {
+		log = append(log, r.Log...)
+	}
+
+	trans, err := log.Undo(gm)
+
+	if err == nil {
+		err = trans.Commit()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return trans.Log(), nil
+}
+
+/*
+ReplayJournal applies all changes of a journal which were committed after a
+given start time and up to a given end time (Unix time in nanoseconds). It can
+be used to bring a restored backup to a specific point in time. The changes
+of every journal record are applied in a separate commit.
+*/
+func (gm *Manager) ReplayJournal(j *Journal, from int64, until int64) error {
+
+	records, err := j.Records(from)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+
+		if r.Time > until {
+			break
+		}
+
+		trans, err := r.Log.Redo(gm)
+
+		if err == nil {
+			err = trans.Commit()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
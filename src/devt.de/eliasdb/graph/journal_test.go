@@ -0,0 +1,311 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestJournal(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	if _, err := gm.RecoverToTime(0); err == nil ||
+		err.Error() != "GraphError: Invalid data (Graph manager has no journal)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	os.MkdirAll(GraphManagerTestDBDir13, 0770)
+	journalFile := filepath.Join(GraphManagerTestDBDir13, "journal")
+
+	j, err := OpenJournal(journalFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.SetJournal(j)
+
+	newNode := func(key string, name string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Song")
+		node.SetAttr(data.NodeName, name)
+		node.SetAttr("ranking", 5)
+		return node
+	}
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr(data.NodeKey, "e1")
+	edge.SetAttr(data.NodeKind, "Link")
+	edge.SetAttr(data.EdgeEnd1Key, "a")
+	edge.SetAttr(data.EdgeEnd1Kind, "Song")
+	edge.SetAttr(data.EdgeEnd1Role, "from")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "c")
+	edge.SetAttr(data.EdgeEnd2Kind, "Song")
+	edge.SetAttr(data.EdgeEnd2Role, "to")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	gm.StoreNode("main", newNode("a", "Song A"))
+	gm.StoreNode("main", newNode("b", "Song B"))
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newNode("c", "Song C"))
+	trans.StoreEdge("main", edge)
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	records, err := j.Records(0)
+	if err != nil || len(records) != 3 {
+		t.Error("Unexpected result:", records, err)
+		return
+	}
+
+	checkpoint := records[2].Time
+
+	// Make some mistakes
+
+	update := data.NewGraphNode()
+	update.SetAttr(data.NodeKey, "a")
+	update.SetAttr(data.NodeKind, "Song")
+	update.SetAttr(data.NodeName, "Song A changed")
+
+	if err := gm.UpdateNode("main", update); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := gm.RemoveNode("main", "b", "Song"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	trans = NewGraphTrans(gm)
+	trans.RemoveNode("main", "c", "Song")
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Check the journal after it was opened again
+
+	j.Close()
+
+	j, err = OpenJournal(journalFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm.SetJournal(j)
+
+	records, err = j.Records(checkpoint)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(records[0].Log, records[1].Log, records[2].Log); res !=
+		"[Updated node a (Song) in main] [Deleted node b (Song) in main] "+
+			"[Deleted node c (Song) in main Deleted edge e1 (Link) in main]" {
+		t.Error("Unexpected journal:", res)
+		return
+	}
+
+	if old := records[0].Log[0].OldNode; old.Attr(data.NodeName) != "Song A" ||
+		old.Attr("ranking") != 5 {
+		t.Error("Unexpected previous version:", old)
+		return
+	}
+
+	// Recover to the checkpoint
+
+	log, err := gm.RecoverToTime(checkpoint)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(log) != 4 {
+		t.Error("Unexpected recovery log:", log)
+		return
+	}
+
+	if node, _ := gm.FetchNode("main", "a", "Song"); node.Attr(data.NodeName) != "Song A" ||
+		node.Attr("ranking") != 5 {
+		t.Error("Unexpected node:", node)
+		return
+	}
+
+	for _, key := range []string{"b", "c"} {
+		if node, _ := gm.FetchNode("main", key, "Song"); node == nil {
+			t.Error("Node should have been recovered:", key)
+			return
+		}
+	}
+
+	if edge, _ := gm.FetchEdge("main", "e1", "Link"); edge == nil {
+		t.Error("Edge should have been recovered")
+		return
+	}
+
+	// The recovery was recorded in the journal
+
+	if records, _ = j.Records(0); len(records) != 7 {
+		t.Error("Unexpected number of records:", len(records))
+		return
+	}
+
+	j.Close()
+
+	// An incomplete record is removed when the journal is opened
+
+	f, _ := os.OpenFile(journalFile, os.O_WRONLY|os.O_APPEND, 0660)
+	f.Write([]byte{0, 0, 1, 0, 42})
+	f.Close()
+
+	j, err = OpenJournal(journalFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer j.Close()
+
+	if records, _ = j.Records(0); len(records) != 7 {
+		t.Error("Unexpected number of records:", len(records))
+		return
+	}
+
+	// Replay the journal up to the checkpoint on an empty database
+
+	gm2 := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage2"))
+
+	if err := gm2.ReplayJournal(j, 0, checkpoint); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if cnt := gm2.NodeCount("Song"); cnt != 3 {
+		t.Error("Unexpected node count:", cnt)
+		return
+	}
+
+	if cnt := gm2.EdgeCount("Link"); cnt != 1 {
+		t.Error("Unexpected edge count:", cnt)
+		return
+	}
+
+	j.Close()
+
+	if err := j.write(nil); err == nil || err.Error() !=
+		"GraphError: Could not write graph information (Journal is closed)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestJournalCorruption(t *testing.T) {
+	os.MkdirAll(GraphManagerTestDBDir13, 0770)
+	journalFile := filepath.Join(GraphManagerTestDBDir13, "journal2")
+	os.Remove(journalFile)
+
+	j, err := OpenJournal(journalFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, fmt.Sprint(i))
+		node.SetAttr(data.NodeKind, "Song")
+
+		if err := j.write(TransLog{&TransLogEntry{EventNodeCreated, "main", node, nil, nil, nil}}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	records, _ := j.Records(0)
+	if len(records) != 3 {
+		t.Error("Unexpected number of records:", len(records))
+		return
+	}
+
+	// Only the records after the given time are read
+
+	if records, _ = j.Records(records[0].Time); len(records) != 2 ||
+		records[0].Log[0].Node.Key() != "1" || records[1].Log[0].Node.Key() != "2" {
+		t.Error("Unexpected records:", records)
+		return
+	}
+
+	if records, _ = j.Records(j.last); len(records) != 0 {
+		t.Error("Unexpected records:", records)
+		return
+	}
+
+	j.Close()
+
+	// A length which is larger than the rest of the file is an incomplete record
+
+	f, _ := os.OpenFile(journalFile, os.O_WRONLY|os.O_APPEND, 0660)
+	f.Write([]byte{0xff, 0xff, 0xff, 0xff, 42})
+	f.Close()
+
+	if j, err = OpenJournal(journalFile); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if records, _ = j.Records(0); len(records) != 3 {
+		t.Error("Unexpected number of records:", len(records))
+		return
+	}
+
+	secondRecord := j.index[1].Offset
+	j.Close()
+
+	fi, _ := os.Stat(journalFile)
+	size := fi.Size()
+
+	// A record in the middle of the journal which cannot be decoded is an error
+
+	f, _ = os.OpenFile(journalFile, os.O_WRONLY, 0660)
+	f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, secondRecord+4)
+	f.Close()
+
+	if _, err = OpenJournal(journalFile); err == nil ||
+		!strings.HasPrefix(err.Error(), fmt.Sprintf("GraphError: Corrupted journal (Record at position %v:",
+			secondRecord)) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// The journal was not truncated
+
+	if fi, _ = os.Stat(journalFile); fi.Size() != size {
+		t.Error("Unexpected journal size:", fi.Size(), size)
+		return
+	}
+}
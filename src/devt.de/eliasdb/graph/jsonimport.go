@@ -0,0 +1,342 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+)
+
+/*
+ImportTemplate describes how an arbitrary JSON document is mapped to nodes and
+edges. Values are selected with JSONPath expressions. Absolute expressions
+start with $ (the document root) and relative expressions start with @ (the
+element which is currently mapped). Supported are child access (.name or
+['name']), array indices ([0]) and wildcards (.* or [*]).
+*/
+type ImportTemplate struct {
+	Nodes []*NodeMapping `json:"nodes"` // Mappings of document elements to nodes
+}
+
+/*
+NodeMapping maps document elements to nodes of a kind.
+*/
+type NodeMapping struct {
+	Path  string            `json:"path"`  // Selects the elements which are mapped to nodes
+	Kind  string            `json:"kind"`  // Kind of the nodes
+	Key   string            `json:"key"`   // Selects the node key (relative to the element)
+	Attrs map[string]string `json:"attrs"` // Selects node attributes (relative to the element)
+	Edges []*EdgeMapping    `json:"edges"` // Mappings of edges from the nodes
+}
+
+/*
+EdgeMapping maps elements of a mapped node to edges between the node and a
+target node. The target node must exist or be part of the same import.
+*/
+type EdgeMapping struct {
+	Path            string            `json:"path"`             // Selects the elements which are mapped to edges (relative to the node element - default is @)
+	Kind            string            `json:"kind"`             // Kind of the edges
+	Key             string            `json:"key"`              // Selects the edge key (relative to the edge element - default is <node key>-<target key>)
+	Role            string            `json:"role"`             // Role of the mapped node
+	Cascading       bool              `json:"cascading"`        // Flag if deleting the mapped node deletes the target node
+	TargetKind      string            `json:"target_kind"`      // Kind of the target node
+	TargetKey       string            `json:"target_key"`       // Selects the target node key (relative to the edge element)
+	TargetRole      string            `json:"target_role"`      // Role of the target node
+	TargetCascading bool              `json:"target_cascading"` // Flag if deleting the target node deletes the mapped node
+	Attrs           map[string]string `json:"attrs"`            // Selects edge attributes (relative to the edge element)
+}
+
+/*
+ImportJSON maps a decoded JSON document to nodes and edges using a given
+template and stores them in a given transaction. Returns the number of mapped
+nodes and edges. The transaction must be committed by the caller.
+*/
+func ImportJSON(trans *Trans, part string, doc interface{}, template *ImportTemplate) (int, int, error) {
+	var nodeCount, edgeCount int
+
+	for _, nm := range template.Nodes {
+
+		elems, err := evalJSONPath(doc, doc, nm.Path)
+		if err != nil {
+			return nodeCount, edgeCount, err
+		}
+
+		for _, elem := range elems {
+
+			key, err := importKey(doc, elem, nm.Key, "node of kind "+nm.Kind)
+			if err != nil {
+				return nodeCount, edgeCount, err
+			}
+
+			node := data.NewGraphNode()
+
+			node.SetAttr(data.NodeKey, key)
+			node.SetAttr(data.NodeKind, nm.Kind)
+
+			if err := importAttrs(node, doc, elem, nm.Attrs); err != nil {
+				return nodeCount, edgeCount, err
+			}
+
+			if err := trans.StoreNode(part, node); err != nil {
+				return nodeCount, edgeCount, err
+			}
+
+			nodeCount++
+
+			for _, em := range nm.Edges {
+
+				count, err := importEdges(trans, part, doc, elem, node, em)
+				edgeCount += count
+
+				if err != nil {
+					return nodeCount, edgeCount, err
+				}
+			}
+		}
+	}
+
+	return nodeCount, edgeCount, nil
+}
+
+/*
+importEdges maps the elements of a node element to edges.
+*/
+func importEdges(trans *Trans, part string, doc interface{}, elem interface{},
+	node data.Node, em *EdgeMapping) (int, error) {
+
+	var count int
+
+	path := em.Path
+	if path == "" {
+		path = "@"
+	}
+
+	items, err := evalJSONPath(doc, elem, path)
+	if err != nil {
+		return count, err
+	}
+
+	for _, item := range items {
+
+		targetKey, err := importKey(doc, item, em.TargetKey, "target node of edge kind "+em.Kind)
+		if err != nil {
+			return count, err
+		}
+
+		key := fmt.Sprint(node.Key(), "-", targetKey)
+
+		if em.Key != "" {
+			if key, err = importKey(doc, item, em.Key, "edge of kind "+em.Kind); err != nil {
+				return count, err
+			}
+		}
+
+		edge := data.NewGraphEdge()
+
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, em.Kind)
+
+		edge.SetAttr(data.EdgeEnd1Key, node.Key())
+		edge.SetAttr(data.EdgeEnd1Kind, node.Kind())
+		edge.SetAttr(data.EdgeEnd1Role, em.Role)
+		edge.SetAttr(data.EdgeEnd1Cascading, em.Cascading)
+
+		edge.SetAttr(data.EdgeEnd2Key, targetKey)
+		edge.SetAttr(data.EdgeEnd2Kind, em.TargetKind)
+		edge.SetAttr(data.EdgeEnd2Role, em.TargetRole)
+		edge.SetAttr(data.EdgeEnd2Cascading, em.TargetCascading)
+
+		if err := importAttrs(edge, doc, item, em.Attrs); err != nil {
+			return count, err
+		}
+
+		if err := trans.StoreEdge(part, edge); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+/*
+importKey selects a key value. It is an error if the key cannot be found.
+*/
+func importKey(doc interface{}, elem interface{}, path string, desc string) (string, error) {
+
+	vals, err := evalJSONPath(doc, elem, path)
+	if err != nil {
+		return "", err
+	} else if len(vals) == 0 || vals[0] == nil {
+		return "", &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Could not find key of %v at %v", desc, path),
+		}
+	}
+
+	return fmt.Sprint(vals[0]), nil
+}
+
+/*
+importAttrs sets selected attributes on a node. Attributes which cannot be
+found are not set.
+*/
+func importAttrs(node data.Node, doc interface{}, elem interface{}, attrs map[string]string) error {
+
+	for attr, path := range attrs {
+
+		vals, err := evalJSONPath(doc, elem, path)
+		if err != nil {
+			return err
+		}
+
+		if len(vals) > 0 && vals[0] != nil {
+			node.SetAttr(attr, vals[0])
+		}
+	}
+
+	return nil
+}
+
+/*
+evalJSONPath evaluates a JSONPath expression on a given document and returns
+all selected values.
+*/
+func evalJSONPath(doc interface{}, elem interface{}, path string) ([]interface{}, error) {
+	var res []interface{}
+
+	pathError := func(detail string) error {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Invalid JSONPath %v: %v", path, detail),
+		}
+	}
+
+	if strings.HasPrefix(path, "$") {
+		res = []interface{}{doc}
+	} else if strings.HasPrefix(path, "@") {
+		res = []interface{}{elem}
+	} else {
+		return nil, pathError("Must start with $ or @")
+	}
+
+	rest := path[1:]
+
+	for rest != "" {
+		var sel string
+		var index = -1
+
+		if rest[0] == '.' {
+
+			// Child access .name
+
+			rest = rest[1:]
+
+			i := strings.IndexAny(rest, ".[")
+			if i == -1 {
+				i = len(rest)
+			}
+
+			sel, rest = rest[:i], rest[i:]
+
+			if sel == "" {
+				return nil, pathError("Missing name")
+			}
+
+		} else if rest[0] == '[' {
+
+			// Child access ['name'], array index [0] or wildcard [*]
+
+			i := strings.Index(rest, "]")
+			if i == -1 {
+				return nil, pathError("Missing ]")
+			}
+
+			sel, rest = rest[1:i], rest[i+1:]
+
+			if len(sel) > 1 && (sel[0] == '\'' || sel[0] == '"') && sel[len(sel)-1] == sel[0] {
+				sel = sel[1 : len(sel)-1]
+			} else if sel != "*" {
+				var err error
+
+				if index, err = strconv.Atoi(sel); err != nil || index < 0 {
+					return nil, pathError("Invalid index " + sel)
+				}
+			}
+
+		} else {
+
+			return nil, pathError("Unexpected character " + rest[:1])
+		}
+
+		var next []interface{}
+
+		for _, val := range res {
+			next = append(next, selectJSONChildren(val, sel, index)...)
+		}
+
+		res = next
+	}
+
+	return res, nil
+}
+
+/*
+selectJSONChildren selects the children of a JSON value. Selection is either
+via index (if >= 0), name or wildcard (*). Lists which are accessed by name
+are selected element-wise.
+*/
+func selectJSONChildren(val interface{}, sel string, index int) []interface{} {
+	var res []interface{}
+
+	switch v := val.(type) {
+
+	case map[string]interface{}:
+
+		if sel == "*" && index == -1 {
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+
+			sort.Strings(keys)
+
+			for _, k := range keys {
+				res = append(res, v[k])
+			}
+
+		} else if child, ok := v[sel]; ok && index == -1 {
+			res = append(res, child)
+		}
+
+	case []interface{}:
+
+		if index >= 0 {
+			if index < len(v) {
+				res = append(res, v[index])
+			}
+		} else if sel == "*" {
+			res = append(res, v...)
+		} else {
+			for _, item := range v {
+				res = append(res, selectJSONChildren(item, sel, index)...)
+			}
+		}
+	}
+
+	return res
+}
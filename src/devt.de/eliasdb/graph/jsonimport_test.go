@@ -0,0 +1,254 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestEvalJSONPath(t *testing.T) {
+	var doc interface{}
+
+	json.Unmarshal([]byte(`{
+  "store" : {
+    "books" : [
+      { "title" : "Book1", "authors" : [ "a1", "a2" ] },
+      { "title" : "Book2", "authors" : [ "a3" ] }
+    ],
+    "owner" : { "name" : "Fred", "age" : 42 }
+  }
+}`), &doc)
+
+	testPath := func(elem interface{}, path string) string {
+		res, err := evalJSONPath(doc, elem, path)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprint(res)
+	}
+
+	if res := testPath(nil, "$.store.books[*].title"); res != "[Book1 Book2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "$['store'].books.title"); res != "[Book1 Book2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "$.store.books[1].authors[0]"); res != "[a3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "$.store.books[5]"); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "$.store.owner.*"); res != "[42 Fred]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "$.store.books[*].authors[*]"); res != "[a1 a2 a3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(map[string]interface{}{"a": "b"}, "@.a"); res != "[b]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "$.store.owner.name.foo"); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "store"); res != "GraphError: Invalid data (Invalid JSONPath store: Must start with $ or @)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "$.store[foo]"); res != "GraphError: Invalid data (Invalid JSONPath $.store[foo]: Invalid index foo)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "$.store[1"); res != "GraphError: Invalid data (Invalid JSONPath $.store[1: Missing ])" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "$..store"); res != "GraphError: Invalid data (Invalid JSONPath $..store: Missing name)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := testPath(nil, "$x"); res != "GraphError: Invalid data (Invalid JSONPath $x: Unexpected character x)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestImportJSON(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	var doc interface{}
+	var template *ImportTemplate
+
+	json.Unmarshal([]byte(`{
+  "orders" : [
+    { "id" : 1, "customer" : { "cid" : "c1", "name" : "Fred" },
+      "items" : [ { "sku" : "s1", "qty" : 2 }, { "sku" : "s2", "qty" : 1 } ] },
+    { "id" : 2, "customer" : { "cid" : "c2", "name" : "Bob" },
+      "items" : [ { "sku" : "s1", "qty" : 5 } ] }
+  ],
+  "products" : [ { "sku" : "s1", "label" : "Pen" }, { "sku" : "s2" } ]
+}`), &doc)
+
+	json.Unmarshal([]byte(`{
+  "nodes" : [
+    { "path" : "$.products[*]", "kind" : "Product", "key" : "@.sku",
+      "attrs" : { "name" : "@.label" } },
+    { "path" : "$.orders[*].customer", "kind" : "Customer", "key" : "@.cid",
+      "attrs" : { "name" : "@.name" } },
+    { "path" : "$.orders[*]", "kind" : "Order", "key" : "@.id",
+      "edges" : [
+        { "kind" : "PlacedBy", "role" : "order", "target_kind" : "Customer",
+          "target_key" : "@.customer.cid", "target_role" : "customer" },
+        { "path" : "@.items[*]", "kind" : "Contains", "role" : "order",
+          "target_kind" : "Product", "target_key" : "@.sku", "target_role" : "product",
+          "cascading" : true, "attrs" : { "quantity" : "@.qty" } }
+      ] }
+  ]
+}`), &template)
+
+	trans := NewGraphTrans(gm)
+
+	nodes, edges, err := ImportJSON(trans, "main", doc, template)
+	if err != nil || nodes != 6 || edges != 5 {
+		t.Error("Unexpected result:", nodes, edges, err)
+		return
+	}
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := gm.FetchNode("main", "s1", "Product"); res.Attr("name") != "Pen" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := gm.FetchNode("main", "s2", "Product"); res.Attr("name") != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := gm.FetchNode("main", "c2", "Customer"); res.Attr("name") != "Bob" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := gm.FetchEdge("main", "1-s2", "Contains"); res.String() != `
+GraphEdge:
+              key : 1-s2
+             kind : Contains
+    end1cascading : true
+          end1key : 1
+         end1kind : Order
+         end1role : order
+    end2cascading : false
+          end2key : s2
+         end2kind : Product
+         end2role : product
+         quantity : 1
+`[1:] {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _, _ := gm.TraverseMulti("main", "2", "Order", ":::Customer", false); len(res) != 1 ||
+		res[0].Key() != "c2" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Test error cases
+
+	template = nil
+	json.Unmarshal([]byte(`{ "nodes" : [ { "path" : "$.orders[*]", "kind" : "Order", "key" : "@.foo" } ] }`), &template)
+
+	if _, _, err := ImportJSON(NewGraphTrans(gm), "main", doc, template); err == nil ||
+		err.Error() != "GraphError: Invalid data (Could not find key of node of kind Order at @.foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	template = nil
+	json.Unmarshal([]byte(`{ "nodes" : [ { "path" : "$.orders[*]", "kind" : "Order", "key" : "@.id",
+	  "edges" : [ { "kind" : "PlacedBy", "target_kind" : "Customer", "target_key" : "@.foo" } ] } ] }`), &template)
+
+	if _, _, err := ImportJSON(NewGraphTrans(gm), "main", doc, template); err == nil ||
+		err.Error() != "GraphError: Invalid data (Could not find key of target node of edge kind PlacedBy at @.foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	template = nil
+	json.Unmarshal([]byte(`{ "nodes" : [ { "path" : "orders", "kind" : "Order", "key" : "@.id" } ] }`), &template)
+
+	if _, _, err := ImportJSON(NewGraphTrans(gm), "main", doc, template); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid JSONPath orders: Must start with $ or @)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	template = nil
+	json.Unmarshal([]byte(`{ "nodes" : [ { "path" : "$.orders[*]", "kind" : "Order", "key" : "@.id",
+	  "attrs" : { "a" : "foo" } } ] }`), &template)
+
+	if _, _, err := ImportJSON(NewGraphTrans(gm), "main", doc, template); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid JSONPath foo: Must start with $ or @)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	template = nil
+	json.Unmarshal([]byte(`{ "nodes" : [ { "path" : "$.orders[*]", "kind" : "Order", "key" : "@.id",
+	  "edges" : [ { "path" : "foo", "kind" : "PlacedBy" } ] } ] }`), &template)
+
+	if _, _, err := ImportJSON(NewGraphTrans(gm), "main", doc, template); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid JSONPath foo: Must start with $ or @)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	template = nil
+	json.Unmarshal([]byte(`{ "nodes" : [ { "path" : "$.orders[*]", "kind" : "Order", "key" : "@.id",
+	  "edges" : [ { "kind" : "PlacedBy", "target_kind" : "Customer", "target_key" : "@.customer.cid",
+	  "key" : "@.foo" } ] } ] }`), &template)
+
+	if _, _, err := ImportJSON(NewGraphTrans(gm), "main", doc, template); err == nil ||
+		err.Error() != "GraphError: Invalid data (Could not find key of edge of kind PlacedBy at @.foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
@@ -0,0 +1,182 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/hash"
+)
+
+/*
+nodeKeyIndex is an ordered in-memory index of node keys. The index of a
+partition and node kind is built from the node storage on first use and is
+kept up-to-date on node inserts and removals.
+*/
+type nodeKeyIndex struct {
+	keys  map[string][]string // Sorted node keys for each partition and node kind
+	mutex *sync.Mutex         // Mutex to protect the index
+}
+
+/*
+newNodeKeyIndex creates a new empty node key index.
+*/
+func newNodeKeyIndex() *nodeKeyIndex {
+	return &nodeKeyIndex{make(map[string][]string), &sync.Mutex{}}
+}
+
+/*
+add adds a node key to the index. Nothing happens if the index for the given
+partition and node kind has not been built yet.
+*/
+func (ki *nodeKeyIndex) add(part string, kind string, key string) {
+	ki.mutex.Lock()
+	defer ki.mutex.Unlock()
+
+	keys, ok := ki.keys[part+"#"+kind]
+	if !ok {
+		return
+	}
+
+	i := sort.SearchStrings(keys, key)
+	if i < len(keys) && keys[i] == key {
+		return
+	}
+
+	keys = append(keys, "")
+	copy(keys[i+1:], keys[i:])
+	keys[i] = key
+
+	ki.keys[part+"#"+kind] = keys
+}
+
+/*
+remove removes a node key from the index.
+*/
+func (ki *nodeKeyIndex) remove(part string, kind string, key string) {
+	ki.mutex.Lock()
+	defer ki.mutex.Unlock()
+
+	keys, ok := ki.keys[part+"#"+kind]
+	if !ok {
+		return
+	}
+
+	i := sort.SearchStrings(keys, key)
+	if i == len(keys) || keys[i] != key {
+		return
+	}
+
+	ki.keys[part+"#"+kind] = append(keys[:i], keys[i+1:]...)
+}
+
+/*
+invalidate drops the index of a given partition and node kind. The index is
+rebuilt on the next lookup.
+*/
+func (ki *nodeKeyIndex) invalidate(part string, kind string) {
+	ki.mutex.Lock()
+	defer ki.mutex.Unlock()
+
+	delete(ki.keys, part+"#"+kind)
+}
+
+/*
+memoryUsage returns the number of indexed keys and the estimated number of
+bytes which are held by the index.
+*/
+func (ki *nodeKeyIndex) memoryUsage() (uint64, uint64) {
+	ki.mutex.Lock()
+	defer ki.mutex.Unlock()
+
+	var count, size uint64
+
+	for skey, keys := range ki.keys {
+		size += memString(skey) + memStringOverhead + memMapEntryOverhead
+
+		for _, key := range keys {
+			size += memString(key)
+		}
+
+		count += uint64(len(keys))
+	}
+
+	return count, size
+}
+
+/*
+prefixKeys returns all keys which start with a given prefix in ascending
+order. A limit of 0 returns all matching keys. The index is built from the
+given HTree if necessary.
+*/
+func (ki *nodeKeyIndex) prefixKeys(part string, kind string, prefix string,
+	limit int, tree *hash.HTree) ([]string, error) {
+
+	ki.mutex.Lock()
+	defer ki.mutex.Unlock()
+
+	keys, ok := ki.keys[part+"#"+kind]
+
+	if !ok {
+		var err error
+
+		if keys, err = readNodeKeys(tree); err != nil {
+			return nil, err
+		}
+
+		ki.keys[part+"#"+kind] = keys
+	}
+
+	res := make([]string, 0)
+
+	for i := sort.SearchStrings(keys, prefix); i < len(keys) &&
+		strings.HasPrefix(keys[i], prefix); i++ {
+
+		if limit > 0 && len(res) == limit {
+			break
+		}
+
+		res = append(res, keys[i])
+	}
+
+	return res, nil
+}
+
+/*
+readNodeKeys reads all node keys from a node attribute HTree and returns them
+in ascending order.
+*/
+func readNodeKeys(tree *hash.HTree) ([]string, error) {
+	keys := make([]string, 0)
+
+	it := tree.IteratorPrefix([]byte(PrefixNSAttrs))
+
+	for it.HasNext() {
+		k, _ := it.Next()
+
+		if it.LastError != nil {
+			break
+		}
+
+		keys = append(keys, string(k[len(PrefixNSAttrs):]))
+	}
+
+	if it.LastError != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+	}
+
+	sort.Strings(keys)
+
+	return keys, nil
+}
@@ -0,0 +1,440 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+
+	"devt.de/eliasdb/graph/data"
+)
+
+/*
+DefaultStatsRefreshMutations is the minimum number of mutations on a node kind
+which trigger an automatic refresh of its statistics.
+*/
+const DefaultStatsRefreshMutations = 1000
+
+/*
+DefaultStatsRefreshRatio is the ratio of mutations to the number of nodes of a
+node kind which triggers an automatic refresh of its statistics.
+*/
+const DefaultStatsRefreshRatio = 0.2
+
+/*
+distinctPrecision is the number of hash bits which select a register of the
+estimator for distinct attribute values. The estimator uses 2^distinctPrecision
+registers - the standard error of the estimate is 1.04/sqrt(2^distinctPrecision).
+*/
+const distinctPrecision = 10
+
+/*
+KindStats contains statistics about the nodes of a certain kind in a partition.
+*/
+type KindStats struct {
+	Part      string                // Partition of the nodes
+	Kind      string                // Kind of the nodes
+	Count     uint64                // Number of nodes
+	Attrs     map[string]*AttrStats // Statistics for each attribute
+	Mutations uint64                // Number of mutations since the statistics were collected
+	Refreshed time.Time             // Time when the statistics were collected
+}
+
+/*
+AttrStats contains statistics about a single node attribute.
+*/
+type AttrStats struct {
+	Count    uint64 // Number of nodes which have the attribute
+	Distinct uint64 // Estimated number of distinct values of the attribute
+}
+
+/*
+Selectivity returns the estimated fraction of nodes of a kind which have a
+certain value of the attribute.
+*/
+func (s *AttrStats) Selectivity(count uint64) float64 {
+	if s.Distinct == 0 || count == 0 {
+		return 0
+	}
+
+	return float64(s.Count) / float64(s.Distinct) / float64(count)
+}
+
+/*
+kindStatsCollector collects and caches node kind statistics. Statistics
+are refreshed automatically in the background once enough mutations have
+happened on a node kind.
+*/
+type kindStatsCollector struct {
+	gm           *Manager              // Manager which is used to collect the statistics
+	stats        map[string]*KindStats // Collected statistics
+	mutations    map[string]uint64     // Mutations since the last refresh
+	refreshing   map[string]bool       // Flags for running refreshes
+	minMutations uint64                // Minimum mutations for an automatic refresh
+	ratio        float64               // Ratio of mutations to nodes for an automatic refresh
+	wg           *sync.WaitGroup       // Waitgroup for running refreshes
+	mutex        *sync.Mutex           // Mutex to protect the collector
+}
+
+/*
+newKindStatsCollector creates a new statistics collector.
+*/
+func newKindStatsCollector(gm *Manager) *kindStatsCollector {
+	return &kindStatsCollector{gm, make(map[string]*KindStats), make(map[string]uint64),
+		make(map[string]bool), DefaultStatsRefreshMutations, DefaultStatsRefreshRatio,
+		&sync.WaitGroup{}, &sync.Mutex{}}
+}
+
+/*
+recordMutation records a mutation of a node kind and schedules a refresh of its
+statistics if necessary.
+*/
+func (c *kindStatsCollector) recordMutation(part string, kind string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	skey := part + "#" + kind
+
+	c.mutations[skey]++
+
+	if c.minMutations == 0 || c.refreshing[skey] {
+		return
+	}
+
+	threshold := c.minMutations
+
+	if stats, ok := c.stats[skey]; ok {
+		if t := uint64(float64(stats.Count) * c.ratio); t > threshold {
+			threshold = t
+		}
+	}
+
+	if c.mutations[skey] >= threshold {
+		c.scheduleRefresh(part, kind)
+	}
+}
+
+/*
+scheduleRefresh starts a refresh of the statistics of a given partition and
+node kind in the background. The collector mutex must be held.
+*/
+func (c *kindStatsCollector) scheduleRefresh(part string, kind string) {
+	c.refreshing[part+"#"+kind] = true
+	c.wg.Add(1)
+
+	go func() {
+		defer c.wg.Done()
+		c.refresh(part, kind)
+	}()
+}
+
+/*
+cached returns the statistics for a given partition and node kind if they
+have been collected. Otherwise a collection is scheduled in the background
+(unless automatic refreshes are disabled) and nil is returned.
+*/
+func (c *kindStatsCollector) cached(part string, kind string) *KindStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	skey := part + "#" + kind
+
+	stats, ok := c.stats[skey]
+
+	if !ok {
+		if c.minMutations != 0 && !c.refreshing[skey] {
+			c.scheduleRefresh(part, kind)
+		}
+		return nil
+	}
+
+	ret := *stats
+	ret.Mutations = c.mutations[skey]
+
+	return &ret
+}
+
+/*
+get returns the statistics for a given partition and node kind. Statistics
+are collected if they are not available.
+*/
+func (c *kindStatsCollector) get(part string, kind string) (*KindStats, error) {
+	c.mutex.Lock()
+	stats, ok := c.stats[part+"#"+kind]
+	mutations := c.mutations[part+"#"+kind]
+	c.mutex.Unlock()
+
+	if !ok {
+		return c.refresh(part, kind)
+	}
+
+	ret := *stats
+	ret.Mutations = mutations
+
+	return &ret, nil
+}
+
+/*
+refresh collects the statistics for a given partition and node kind.
+*/
+func (c *kindStatsCollector) refresh(part string, kind string) (*KindStats, error) {
+	skey := part + "#" + kind
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.refreshing, skey)
+		c.mutex.Unlock()
+	}()
+
+	// Mutations which happen during the collection are counted
+	// towards the next refresh
+
+	c.mutex.Lock()
+	c.mutations[skey] = 0
+	c.mutex.Unlock()
+
+	stats := &KindStats{part, kind, 0, make(map[string]*AttrStats), 0, time.Now()}
+	distinct := make(map[string]distinctEstimator)
+
+	it, err := c.gm.NodeKeyIterator(part, kind)
+	if err != nil {
+		return nil, err
+	} else if it == nil {
+		return nil, nil
+	}
+
+	for it.HasNext() {
+		key := it.Next()
+
+		if it.LastError != nil {
+			return nil, it.LastError
+		}
+
+		node, err := c.gm.FetchNode(part, key, kind)
+		if err != nil {
+			return nil, err
+		} else if node == nil {
+			continue
+		}
+
+		stats.Count++
+
+		for attr, val := range node.Data() {
+			if attr == data.NodeKey || attr == data.NodeKind {
+				continue
+			}
+
+			as, ok := stats.Attrs[attr]
+			if !ok {
+				as = &AttrStats{}
+				stats.Attrs[attr] = as
+				distinct[attr] = newDistinctEstimator()
+			}
+
+			as.Count++
+
+			distinct[attr].add(fmt.Sprint(val))
+		}
+	}
+
+	for attr, as := range stats.Attrs {
+		as.Distinct = distinct[attr].estimate()
+	}
+
+	c.mutex.Lock()
+	c.stats[skey] = stats
+	stats.Mutations = c.mutations[skey]
+	c.mutex.Unlock()
+
+	ret := *stats
+
+	return &ret, nil
+}
+
+/*
+distinctEstimator estimates the number of distinct values with the HyperLogLog
+algorithm. The estimator has a fixed size independent of the number of values.
+*/
+type distinctEstimator []uint8
+
+/*
+newDistinctEstimator creates a new empty estimator.
+*/
+func newDistinctEstimator() distinctEstimator {
+	return make(distinctEstimator, 1<<distinctPrecision)
+}
+
+/*
+add adds a value to the estimator.
+*/
+func (e distinctEstimator) add(val string) {
+	h := fnv.New64a()
+	h.Write([]byte(val))
+
+	// Mix the hash bits (finalizer of MurmurHash3) - FNV does not spread
+	// short values evenly over all bits
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	// The first bits select the register which keeps the highest position
+	// of the first set bit in the remaining bits
+
+	reg := x >> (64 - distinctPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<distinctPrecision|1<<(distinctPrecision-1))) + 1
+
+	if rank > e[reg] {
+		e[reg] = rank
+	}
+}
+
+/*
+estimate returns the estimated number of distinct values which were added.
+*/
+func (e distinctEstimator) estimate() uint64 {
+	m := float64(len(e))
+
+	var sum float64
+	var zeros int
+
+	for _, rank := range e {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+
+	// Use linear counting for small numbers of values
+
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(math.Floor(est + 0.5))
+}
+
+/*
+memoryUsage returns the estimated number of bytes which are held by the
+collected statistics.
+*/
+func (c *kindStatsCollector) memoryUsage() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var size uint64
+
+	for skey, stats := range c.stats {
+
+		// Key, partition and kind strings as well as the counters and the
+		// refresh timestamp
+
+		size += memString(skey) + memString(stats.Part) + memString(stats.Kind) + 64
+
+		for attr := range stats.Attrs {
+			size += memString(attr) + memMapEntryOverhead + 16
+		}
+	}
+
+	for skey := range c.mutations {
+		size += memString(skey) + memMapEntryOverhead + 8
+	}
+
+	return size
+}
+
+/*
+KindStats returns statistics about the nodes of a certain kind in a partition.
+The statistics are collected on first request and are then refreshed
+automatically once enough mutations have happened on the node kind.
+Returns nil if the node kind does not exist in the partition.
+*/
+func (gm *Manager) KindStats(part string, kind string) (*KindStats, error) {
+	return gm.stats.get(part, kind)
+}
+
+/*
+CachedKindStats returns statistics about the nodes of a certain kind in a
+partition if they have already been collected. Otherwise nil is returned and
+the statistics are collected in the background (unless automatic refreshes
+are disabled). This function can be used by callers which must not wait for
+a collection (e.g. the query planner).
+*/
+func (gm *Manager) CachedKindStats(part string, kind string) *KindStats {
+	return gm.stats.cached(part, kind)
+}
+
+/*
+RefreshKindStats collects the statistics about the nodes of a certain kind
+in a partition immediately.
+*/
+func (gm *Manager) RefreshKindStats(part string, kind string) (*KindStats, error) {
+	return gm.stats.refresh(part, kind)
+}
+
+/*
+SetKindStatsRefresh sets when node kind statistics are refreshed automatically.
+A refresh happens if the number of mutations on a node kind reaches the given
+minimum and the given ratio of the number of nodes. A minimum of 0 disables
+automatic refreshes.
+*/
+func (gm *Manager) SetKindStatsRefresh(minMutations uint64, ratio float64) {
+	gm.stats.mutex.Lock()
+	defer gm.stats.mutex.Unlock()
+
+	gm.stats.minMutations = minMutations
+	gm.stats.ratio = ratio
+}
+
+// System rule SystemRuleRefreshKindStats
+// ======================================
+
+/*
+SystemRuleRefreshKindStats is a system rule which counts node mutations and
+schedules refreshes of node kind statistics.
+*/
+type SystemRuleRefreshKindStats struct {
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (r *SystemRuleRefreshKindStats) Name() string {
+	return "system.refreshkindstats"
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (r *SystemRuleRefreshKindStats) Handles() []int {
+	return []int{EventNodeCreated, EventNodeUpdated, EventNodeDeleted}
+}
+
+/*
+Handle handles an event.
+*/
+func (r *SystemRuleRefreshKindStats) Handle(gm *Manager, trans *Trans, event int, ed ...interface{}) error {
+	part := ed[0].(string)
+	node := ed[1].(data.Node)
+
+	gm.stats.recordMutation(part, node.Kind())
+
+	return nil
+}
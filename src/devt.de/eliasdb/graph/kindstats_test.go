@@ -0,0 +1,180 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestKindStats(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	storeNode := func(key string, name string, age int) {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Person")
+		node.SetAttr("name", name)
+		if age > 0 {
+			node.SetAttr("age", age)
+		}
+		gm.StoreNode("main", node)
+	}
+
+	if stats, err := gm.KindStats("main", "Person"); stats != nil || err != nil {
+		t.Error("Unexpected result:", stats, err)
+		return
+	}
+
+	storeNode("1", "John", 20)
+	storeNode("2", "Jane", 20)
+	storeNode("3", "John", 0)
+
+	stats, err := gm.KindStats("main", "Person")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats.Part != "main" || stats.Kind != "Person" || stats.Count != 3 ||
+		stats.Mutations != 0 || len(stats.Attrs) != 2 ||
+		*stats.Attrs["name"] != (AttrStats{3, 2}) || *stats.Attrs["age"] != (AttrStats{2, 1}) {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	// Statistics are cached until enough mutations happened
+
+	storeNode("4", "Bob", 30)
+	gm.RemoveNode("main", "1", "Person")
+
+	stats, _ = gm.KindStats("main", "Person")
+
+	if stats.Count != 3 || stats.Mutations != 2 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	gm.SetKindStatsRefresh(3, 0.5)
+
+	storeNode("5", "Alice", 40)
+
+	gm.stats.wg.Wait()
+
+	stats, _ = gm.KindStats("main", "Person")
+
+	if stats.Count != 4 || stats.Mutations != 0 || *stats.Attrs["age"] != (AttrStats{3, 3}) {
+		t.Error("Unexpected stats:", stats, stats.Attrs["age"])
+		return
+	}
+
+	// Manual refresh
+
+	gm.SetKindStatsRefresh(0, 0)
+
+	for i := 0; i < 5; i++ {
+		storeNode(fmt.Sprint("x", i), "X", 0)
+	}
+
+	gm.stats.wg.Wait()
+
+	if stats, _ = gm.KindStats("main", "Person"); stats.Count != 4 || stats.Mutations != 5 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if stats, _ = gm.RefreshKindStats("main", "Person"); stats.Count != 9 || stats.Mutations != 0 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if _, err := gm.RefreshKindStats("main", "Person-"); err == nil {
+		t.Error("Invalid node kind should cause an error")
+		return
+	}
+}
+
+func TestKindStatsCached(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "1")
+	node.SetAttr("kind", "Person")
+	node.SetAttr("name", "John")
+	gm.StoreNode("main", node)
+
+	// Statistics are collected in the background on first request
+
+	if stats := gm.CachedKindStats("main", "Person"); stats != nil {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	gm.stats.wg.Wait()
+
+	if stats := gm.CachedKindStats("main", "Person"); stats == nil || stats.Count != 1 ||
+		stats.Attrs["name"].Selectivity(stats.Count) != 1 {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	// No collection is scheduled if automatic refreshes are disabled
+
+	gm.SetKindStatsRefresh(0, 0)
+
+	if stats := gm.CachedKindStats("main", "Song"); stats != nil {
+		t.Error("Unexpected result:", stats)
+		return
+	}
+
+	gm.stats.wg.Wait()
+
+	if _, ok := gm.stats.stats["main#Song"]; ok || len(gm.stats.refreshing) != 0 {
+		t.Error("Unexpected collection")
+		return
+	}
+}
+
+func TestDistinctEstimator(t *testing.T) {
+	e := newDistinctEstimator()
+
+	if res := e.estimate(); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		e.add("a")
+		e.add("b")
+	}
+
+	if res := e.estimate(); res != 2 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The size of the estimator does not depend on the number of values
+
+	for i := 0; i < 100000; i++ {
+		e.add(fmt.Sprint(i))
+		e.add(fmt.Sprint(i))
+	}
+
+	if res := e.estimate(); len(e) != 1024 || res < 95000 || res > 105000 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -0,0 +1,350 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+labelNodeSep separates the kind and the key of a node in the label index
+*/
+const labelNodeSep = string(0x00)
+
+/*
+AddNodeLabels attaches labels to a node. Labels are independent of the node
+kind and can be used to group nodes of different kinds. The labels of all
+nodes of a partition are kept in a dedicated index.
+*/
+func (gm *Manager) AddNodeLabels(part string, key string, kind string, labels ...string) error {
+	return gm.changeNodeLabels(part, key, kind, labels, true)
+}
+
+/*
+RemoveNodeLabels detaches labels from a node.
+*/
+func (gm *Manager) RemoveNodeLabels(part string, key string, kind string, labels ...string) error {
+	return gm.changeNodeLabels(part, key, kind, labels, false)
+}
+
+/*
+NodeLabels returns the sorted labels of a node.
+*/
+func (gm *Manager) NodeLabels(part string, key string, kind string) ([]string, error) {
+
+	tree, err := gm.getLabelHTree(part, false)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	// Take reader lock
+
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "NodeLabels")
+	defer gm.mutex.RUnlock()
+
+	return gm.readLabelSet(tree, PrefixLBNode+kind+labelNodeSep+key)
+}
+
+/*
+HasNodeLabel checks if a label is attached to a node.
+*/
+func (gm *Manager) HasNodeLabel(part string, key string, kind string, label string) (bool, error) {
+
+	labels, err := gm.NodeLabels(part, key, kind)
+
+	for _, l := range labels {
+		if l == label {
+			return true, err
+		}
+	}
+
+	return false, err
+}
+
+/*
+NodesByLabel returns all nodes of a partition which have a given label. The
+nodes are sorted by kind and key.
+*/
+func (gm *Manager) NodesByLabel(part string, label string) ([]data.Node, error) {
+
+	tree, err := gm.getLabelHTree(part, false)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	// Take reader lock
+
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "NodesByLabel")
+	idents, err := gm.readLabelSet(tree, PrefixLBLabel+label)
+	gm.mutex.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]data.Node, 0, len(idents))
+
+	for _, ident := range idents {
+		kindAndKey := strings.SplitN(ident, labelNodeSep, 2)
+
+		node, err := gm.FetchNode(part, kindAndKey[1], kindAndKey[0])
+		if err != nil {
+			return nil, err
+		} else if node != nil {
+			ret = append(ret, node)
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+changeNodeLabels attaches labels to or detaches labels from a node.
+*/
+func (gm *Manager) changeNodeLabels(part string, key string, kind string, labels []string, add bool) error {
+
+	if err := gm.checkWritablePartition(part); err != nil {
+		return err
+	}
+
+	for _, label := range labels {
+		if label == "" {
+			return &util.GraphError{Type: util.ErrInvalidData, Detail: "Labels must not be empty"}
+		}
+	}
+
+	// Labels can only be attached to existing nodes
+
+	if add {
+		if node, err := gm.FetchNodePart(part, key, kind, []string{data.NodeKey}); err != nil {
+			return err
+		} else if node == nil {
+			return &util.GraphError{
+				Type:   util.ErrInvalidData,
+				Detail: fmt.Sprintf("Unknown node %v (%v)", key, kind),
+			}
+		}
+	}
+
+	tree, err := gm.getLabelHTree(part, add)
+	if err != nil || tree == nil {
+		return err
+	}
+
+	// Take writer lock
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "changeNodeLabels")
+	defer gm.mutex.Unlock()
+
+	ident := kind + labelNodeSep + key
+	nodeEntry := PrefixLBNode + ident
+
+	for _, label := range labels {
+		if err = gm.changeLabelSet(tree, nodeEntry, label, add); err == nil {
+			err = gm.changeLabelSet(tree, PrefixLBLabel+label, ident, add)
+		}
+
+		if err != nil {
+			gm.rollbackLabels(part)
+			return err
+		}
+	}
+
+	return gm.flushLabels(part)
+}
+
+/*
+deleteNodeLabels removes all labels of a deleted node. It is assumed that
+the caller holds the writer lock.
+*/
+func (gm *Manager) deleteNodeLabels(part string, key string, kind string) error {
+
+	// The partition name was already checked when the node was deleted
+
+	sm := gm.gs.StorageManager(part+StorageSuffixLabels, false)
+	if sm == nil {
+		return nil
+	}
+
+	tree, err := gm.getHTree(sm, RootIDNodeHTree)
+	if err != nil {
+		return err
+	}
+
+	ident := kind + labelNodeSep + key
+	nodeEntry := PrefixLBNode + ident
+
+	labels, err := gm.readLabelSet(tree, nodeEntry)
+
+	for _, label := range labels {
+		if err = gm.changeLabelSet(tree, nodeEntry, label, false); err == nil {
+			err = gm.changeLabelSet(tree, PrefixLBLabel+label, ident, false)
+		}
+
+		if err != nil {
+			gm.rollbackLabels(part)
+			return err
+		}
+	}
+
+	if err == nil && len(labels) > 0 {
+		err = gm.flushLabels(part)
+	}
+
+	return err
+}
+
+/*
+readLabelSet reads a sorted set of strings from the label index.
+*/
+func (gm *Manager) readLabelSet(tree *hash.HTree, entry string) ([]string, error) {
+
+	obj, err := tree.Get([]byte(entry))
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	} else if obj == nil {
+		return nil, nil
+	}
+
+	set := obj.(map[string]string)
+	ret := make([]string, 0, len(set))
+
+	for s := range set {
+		ret = append(ret, s)
+	}
+
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+/*
+changeLabelSet adds a string to or removes a string from a set of the label
+index. Empty sets are removed.
+*/
+func (gm *Manager) changeLabelSet(tree *hash.HTree, entry string, val string, add bool) error {
+
+	obj, err := tree.Get([]byte(entry))
+	if err != nil {
+		return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	set := make(map[string]string)
+	if obj != nil {
+		set = obj.(map[string]string)
+	}
+
+	if _, ok := set[val]; ok == add {
+		return nil
+	}
+
+	if add {
+		set[val] = ""
+	} else {
+		delete(set, val)
+	}
+
+	if len(set) == 0 {
+		_, err = tree.Remove([]byte(entry))
+	} else {
+		_, err = tree.Put([]byte(entry), set)
+	}
+
+	if err != nil {
+		return util.NewWritingError(err)
+	}
+
+	return nil
+}
+
+/*
+getLabelHTree gets the HTree which stores the label index of a partition.
+*/
+func (gm *Manager) getLabelHTree(part string, create bool) (*hash.HTree, error) {
+
+	// Check if the partition name is valid
+
+	if err := gm.checkPartitionName(part); err != nil {
+		return nil, err
+	}
+
+	gs := gm.gs.StorageManager(part+StorageSuffixLabels, create)
+	if gs == nil {
+		return nil, nil
+	}
+
+	return gm.getHTree(gs, RootIDNodeHTree)
+}
+
+/*
+flushLabels flushes the label index of a partition.
+*/
+func (gm *Manager) flushLabels(part string) error {
+	if sm := gm.gs.StorageManager(part+StorageSuffixLabels, false); sm != nil {
+		if err := sm.Flush(); err != nil {
+			return &util.GraphError{Type: util.ErrFlushing, Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
+/*
+rollbackLabels rollbacks the label index of a partition.
+*/
+func (gm *Manager) rollbackLabels(part string) error {
+	if sm := gm.gs.StorageManager(part+StorageSuffixLabels, false); sm != nil {
+		if err := sm.Rollback(); err != nil {
+			return &util.GraphError{Type: util.ErrRollback, Detail: err.Error()}
+		}
+	}
+	return nil
+}
+
+// System rule SystemRuleDeleteNodeLabels
+// ======================================
+
+/*
+SystemRuleDeleteNodeLabels is a system rule to remove all labels of a node
+from the label index when the node is deleted.
+*/
+type SystemRuleDeleteNodeLabels struct {
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (r *SystemRuleDeleteNodeLabels) Name() string {
+	return "system.deletenodelabels"
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (r *SystemRuleDeleteNodeLabels) Handles() []int {
+	return []int{EventNodeDeleted}
+}
+
+/*
+Handle handles an event.
+*/
+func (r *SystemRuleDeleteNodeLabels) Handle(gm *Manager, trans *Trans, event int, ed ...interface{}) error {
+	part := ed[0].(string)
+	node := ed[1].(data.Node)
+
+	return gm.deleteNodeLabels(part, node.Key(), node.Kind())
+}
@@ -0,0 +1,143 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestNodeLabels(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	nodeKeys := func(nodes []data.Node) string {
+		var ret []string
+		for _, n := range nodes {
+			ret = append(ret, n.Kind()+":"+n.Key())
+		}
+		return fmt.Sprint(ret)
+	}
+
+	// Lookups on a partition without labels
+
+	if labels, err := gm.NodeLabels("main", "a", "Author"); labels != nil || err != nil {
+		t.Error("Unexpected result:", labels, err)
+		return
+	}
+
+	if nodes, err := gm.NodesByLabel("main", "vip"); nodes != nil || err != nil {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+
+	for _, kind := range []string{"Author", "Song"} {
+		for _, key := range []string{"a", "b"} {
+			node := data.NewGraphNode()
+			node.SetAttr(data.NodeKey, key)
+			node.SetAttr(data.NodeKind, kind)
+			gm.StoreNode("main", node)
+		}
+	}
+
+	if err := gm.AddNodeLabels("main", "x", "Author", "vip"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown node x (Author))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.AddNodeLabels("main", "a", "Author", "vip", ""); err == nil ||
+		err.Error() != "GraphError: Invalid data (Labels must not be empty)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.AddNodeLabels("my main", "a", "Author", "vip"); err == nil ||
+		err.Error() != "GraphError: Invalid data (Partition name my main is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Labels are independent of the node kind
+
+	gm.AddNodeLabels("main", "a", "Author", "vip", "new")
+	gm.AddNodeLabels("main", "b", "Song", "vip")
+	gm.AddNodeLabels("main", "a", "Song", "new", "new")
+
+	if labels, err := gm.NodeLabels("main", "a", "Author"); fmt.Sprint(labels) != "[new vip]" || err != nil {
+		t.Error("Unexpected result:", labels, err)
+		return
+	}
+
+	if nodes, err := gm.NodesByLabel("main", "vip"); nodeKeys(nodes) != "[Author:a Song:b]" || err != nil {
+		t.Error("Unexpected result:", nodeKeys(nodes), err)
+		return
+	}
+
+	if nodes, err := gm.NodesByLabel("main", "new"); nodeKeys(nodes) != "[Author:a Song:a]" || err != nil {
+		t.Error("Unexpected result:", nodeKeys(nodes), err)
+		return
+	}
+
+	if ok, err := gm.HasNodeLabel("main", "b", "Song", "vip"); !ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	if ok, err := gm.HasNodeLabel("main", "b", "Song", "new"); ok || err != nil {
+		t.Error("Unexpected result:", ok, err)
+		return
+	}
+
+	// Remove labels
+
+	if err := gm.RemoveNodeLabels("main", "a", "Author", "new", "unknown"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if labels, err := gm.NodeLabels("main", "a", "Author"); fmt.Sprint(labels) != "[vip]" || err != nil {
+		t.Error("Unexpected result:", labels, err)
+		return
+	}
+
+	if nodes, err := gm.NodesByLabel("main", "new"); nodeKeys(nodes) != "[Song:a]" || err != nil {
+		t.Error("Unexpected result:", nodeKeys(nodes), err)
+		return
+	}
+
+	// Deleting a node removes its labels
+
+	if _, err := gm.RemoveNode("main", "b", "Song"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if nodes, err := gm.NodesByLabel("main", "vip"); nodeKeys(nodes) != "[Author:a]" || err != nil {
+		t.Error("Unexpected result:", nodeKeys(nodes), err)
+		return
+	}
+
+	if labels, err := gm.NodeLabels("main", "b", "Song"); labels != nil || err != nil {
+		t.Error("Unexpected result:", labels, err)
+		return
+	}
+
+	// Labels of other partitions are not affected
+
+	if nodes, err := gm.NodesByLabel("second", "vip"); nodes != nil || err != nil {
+		t.Error("Unexpected result:", nodes, err)
+		return
+	}
+}
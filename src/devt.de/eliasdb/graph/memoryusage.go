@@ -0,0 +1,72 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import "devt.de/eliasdb/storage"
+
+/*
+Estimated overhead in bytes of a string or slice value
+*/
+const memStringOverhead = 16
+
+/*
+Estimated overhead in bytes of a map entry
+*/
+const memMapEntryOverhead = 16
+
+/*
+MemoryUsage contains an estimate of the memory which is held by a graph
+manager and its storage.
+*/
+type MemoryUsage struct {
+	Storage      *storage.MemoryUsage // Memory held by the storage managers (nil if unknown)
+	MapCache     uint64               // Bytes held by cached maps of the main database
+	KeyIndex     uint64               // Bytes held by the node key index
+	KeyIndexKeys uint64               // Number of keys in the node key index
+	KindStats    uint64               // Bytes held by node kind statistics
+}
+
+/*
+MemoryUsage returns an estimate of the memory which is held by caches and
+index structures of this graph manager. Storage memory is only reported if
+the graph storage is a storage.MemoryReporter.
+*/
+func (gm *Manager) MemoryUsage() *MemoryUsage {
+	ret := &MemoryUsage{}
+
+	if mr, ok := gm.gs.(storage.MemoryReporter); ok {
+		ret.Storage = mr.MemoryUsage()
+	}
+
+	gm.mapLock.Lock()
+
+	for key, mapval := range gm.mapCache {
+		ret.MapCache += memString(key) + memMapEntryOverhead
+
+		for k, v := range mapval {
+			ret.MapCache += memString(k) + memString(v) + memMapEntryOverhead
+		}
+	}
+
+	gm.mapLock.Unlock()
+
+	ret.KeyIndexKeys, ret.KeyIndex = gm.keyIndex.memoryUsage()
+	ret.KindStats = gm.stats.memoryUsage()
+
+	return ret
+}
+
+/*
+memString returns the estimated number of bytes which are held by a string.
+*/
+func memString(s string) uint64 {
+	return uint64(len(s)) + memStringOverhead
+}
@@ -0,0 +1,81 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	mu := gm.MemoryUsage()
+
+	if mu.Storage != nil || mu.MapCache != 0 || mu.KeyIndex != 0 ||
+		mu.KeyIndexKeys != 0 || mu.KindStats != 0 {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	for _, key := range []string{"a1", "a2", "b1"} {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Person")
+		node.SetAttr("name", "Name"+key)
+		gm.StoreNode("main", node)
+	}
+
+	if mu = gm.MemoryUsage(); mu.MapCache == 0 || mu.KeyIndex != 0 || mu.KindStats == 0 {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	// Index and statistics are built on first use
+
+	gm.NodeKeysByPrefix("main", "Person", "a", 0)
+
+	kindStats := mu.KindStats
+	gm.KindStats("main", "Person")
+
+	if mu = gm.MemoryUsage(); mu.KeyIndexKeys != 3 || mu.KeyIndex == 0 ||
+		mu.KindStats <= kindStats {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir5, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = NewGraphManager(dgs)
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "1")
+	node.SetAttr("kind", "Person")
+	gm.StoreNode("main", node)
+
+	if mu = gm.MemoryUsage(); mu.Storage == nil || mu.Storage.Records == 0 {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	dgs.Close()
+}
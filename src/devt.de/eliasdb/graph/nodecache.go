@@ -0,0 +1,319 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/memlimit"
+)
+
+/*
+nodeCache is a read-through cache for fully read nodes. Nodes are stored for
+each partition, kind and key. The least recently used node is removed once the
+cache is full. Lookups of nodes which do not exist can be remembered for a
+short period. Writers must invalidate cached nodes while holding the writer
+lock of the graph manager.
+*/
+type nodeCache struct {
+	maxSize    int                      // Max number of cached nodes
+	entries    map[string]*list.Element // Cached nodes
+	lru        *list.List               // List of cache keys (most recently used first)
+	mutex      *sync.Mutex              // Mutex to protect the cache
+	shrinkerID int                      // Id of the shrinker which empties the cache
+	missTTL    time.Duration            // Time for which missing nodes are remembered (0 to disable)
+	missing    map[string]time.Time     // Expiry times of remembered missing nodes
+}
+
+/*
+nodeCacheEntry is a single entry of the node cache.
+*/
+type nodeCacheEntry struct {
+	key  string    // Cache key
+	node data.Node // Cached node
+}
+
+/*
+newNodeCache creates a new node cache which holds up to a given number of nodes.
+*/
+func newNodeCache(maxSize int, missTTL time.Duration) *nodeCache {
+	nc := &nodeCache{maxSize, make(map[string]*list.Element), list.New(), &sync.Mutex{}, 0,
+		missTTL, make(map[string]time.Time)}
+
+	// Empty the cache while the soft memory limit is exceeded
+
+	nc.shrinkerID = memlimit.AddShrinker(nc.clear)
+
+	return nc
+}
+
+/*
+SetNodeCacheSize enables a read-through cache for nodes which holds up to a
+given number of nodes. Repeatedly fetched nodes are then served without
+accessing the storage. Attribute values of fetched nodes are shared with the
+cache and should not be modified in place. A size of 0 disables the cache.
+*/
+func (gm *Manager) SetNodeCacheSize(size int) {
+
+	// Take writer lock
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "SetNodeCacheSize")
+	defer gm.mutex.Unlock()
+
+	var missTTL time.Duration
+
+	if gm.nodeCache != nil {
+		memlimit.RemoveShrinker(gm.nodeCache.shrinkerID)
+		missTTL = gm.nodeCache.missTTL
+	}
+
+	if size > 0 {
+		gm.nodeCache = newNodeCache(size, missTTL)
+	} else {
+		gm.nodeCache = nil
+	}
+}
+
+/*
+SetNodeCacheMissTTL lets the node cache remember lookups of nodes which do
+not exist for a given period. Repeated lookups of such nodes (e.g. checks
+before inserting a node) are then answered without accessing the storage.
+Remembered nodes are forgotten once they are stored. The node cache must be
+enabled with SetNodeCacheSize. A period of 0 disables this.
+*/
+func (gm *Manager) SetNodeCacheMissTTL(ttl time.Duration) {
+
+	// Take writer lock
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "SetNodeCacheMissTTL")
+	defer gm.mutex.Unlock()
+
+	if gm.nodeCache != nil {
+		gm.nodeCache.mutex.Lock()
+		defer gm.nodeCache.mutex.Unlock()
+
+		gm.nodeCache.missTTL = ttl
+		gm.nodeCache.missing = make(map[string]time.Time)
+	}
+}
+
+/*
+readCachedNode reads a given node via the node cache. The node is read from the
+datastore if the cache is disabled or does not hold the node. It is assumed
+that the caller holds the reader lock.
+*/
+func (gm *Manager) readCachedNode(part string, key string, kind string, attrs []string,
+	attrTree *hash.HTree, valTree *hash.HTree) (data.Node, error) {
+
+	if gm.nodeCache == nil {
+		return gm.readNode(key, kind, attrs, attrTree, valTree)
+	}
+
+	if node, ok := gm.nodeCache.get(part, kind, key, attrs); ok {
+		return node, nil
+	}
+
+	// Always read the full node so it can serve all future requests
+
+	node, err := gm.readNode(key, kind, nil, attrTree, valTree)
+	if err != nil {
+		return nil, err
+	} else if node == nil {
+		gm.nodeCache.putMissing(part, kind, key)
+		return nil, nil
+	}
+
+	gm.nodeCache.put(part, kind, key, node)
+
+	return projectNode(node, attrs), nil
+}
+
+/*
+invalidateCachedNode removes a given node from the node cache. It is assumed
+that the caller holds the writer lock.
+*/
+func (gm *Manager) invalidateCachedNode(part string, key string, kind string) {
+	if gm.nodeCache != nil {
+		gm.nodeCache.remove(part, kind, key)
+	}
+}
+
+/*
+get returns a copy of a cached node which only contains the given attributes.
+Returns a nil node if the node is remembered as missing.
+*/
+func (nc *nodeCache) get(part string, kind string, key string, attrs []string) (data.Node, bool) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	ckey := part + "#" + kind + "#" + key
+
+	if expiry, ok := nc.missing[ckey]; ok {
+		if time.Now().Before(expiry) {
+			return nil, true
+		}
+		delete(nc.missing, ckey)
+	}
+
+	elem, ok := nc.entries[ckey]
+	if !ok {
+		return nil, false
+	}
+
+	nc.lru.MoveToFront(elem)
+
+	return projectNode(elem.Value.(*nodeCacheEntry).node, attrs), true
+}
+
+/*
+put stores a node in the cache. The least recently used node is removed if the
+cache is full. No nodes are added while the soft memory limit is exceeded.
+*/
+func (nc *nodeCache) put(part string, kind string, key string, node data.Node) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	ckey := part + "#" + kind + "#" + key
+
+	if elem, ok := nc.entries[ckey]; ok {
+		elem.Value.(*nodeCacheEntry).node = node
+		nc.lru.MoveToFront(elem)
+		return
+	}
+
+	if memlimit.Exceeded() {
+		return
+	}
+
+	if nc.lru.Len() >= nc.maxSize {
+		oldest := nc.lru.Back()
+		nc.lru.Remove(oldest)
+		delete(nc.entries, oldest.Value.(*nodeCacheEntry).key)
+	}
+
+	nc.entries[ckey] = nc.lru.PushFront(&nodeCacheEntry{ckey, node})
+}
+
+/*
+putMissing remembers that a node does not exist. Expired entries are removed
+once the cache remembers its max number of missing nodes. No nodes are
+remembered if the cache is still full afterwards.
+*/
+func (nc *nodeCache) putMissing(part string, kind string, key string) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	if nc.missTTL == 0 || memlimit.Exceeded() {
+		return
+	}
+
+	now := time.Now()
+
+	if len(nc.missing) >= nc.maxSize {
+		for ckey, expiry := range nc.missing {
+			if !now.Before(expiry) {
+				delete(nc.missing, ckey)
+			}
+		}
+
+		if len(nc.missing) >= nc.maxSize {
+			return
+		}
+	}
+
+	nc.missing[part+"#"+kind+"#"+key] = now.Add(nc.missTTL)
+}
+
+/*
+remove removes a node from the cache.
+*/
+func (nc *nodeCache) remove(part string, kind string, key string) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	ckey := part + "#" + kind + "#" + key
+
+	delete(nc.missing, ckey)
+
+	if elem, ok := nc.entries[ckey]; ok {
+		nc.lru.Remove(elem)
+		delete(nc.entries, ckey)
+	}
+}
+
+/*
+clear removes all nodes from the cache.
+*/
+func (nc *nodeCache) clear() {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	nc.entries = make(map[string]*list.Element)
+	nc.lru.Init()
+	nc.missing = make(map[string]time.Time)
+}
+
+/*
+len returns the number of cached nodes.
+*/
+func (nc *nodeCache) len() int {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	return nc.lru.Len()
+}
+
+/*
+projectNode returns a copy of a given node which only contains the given
+attributes. All attributes are copied if no attributes are given. Returns nil
+if none of the given attributes exist - the same as reading a partial node
+from the datastore.
+*/
+func projectNode(node data.Node, attrs []string) data.Node {
+	var ret data.Node
+
+	if len(attrs) == 0 {
+		ret = data.NewGraphNode()
+
+		for attr, val := range node.Data() {
+			ret.SetAttr(attr, val)
+		}
+
+		return ret
+	}
+
+	for _, attr := range attrs {
+
+		if (attr == data.NodeKey || attr == data.NodeKind) && ret == nil {
+			ret = data.NewGraphNode()
+			continue
+		}
+
+		if val := node.Attr(attr); val != nil {
+			if ret == nil {
+				ret = data.NewGraphNode()
+			}
+			ret.SetAttr(attr, val)
+		}
+	}
+
+	if ret != nil {
+		ret.SetAttr(data.NodeKey, node.Key())
+		ret.SetAttr(data.NodeKind, node.Kind())
+	}
+
+	return ret
+}
@@ -0,0 +1,340 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"testing"
+	"time"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/memlimit"
+)
+
+func TestNodeCache(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	gm.SetNodeCacheSize(2)
+
+	storeNode := func(key string, name string) {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Person")
+		node.SetAttr("name", name)
+		gm.StoreNode("main", node)
+	}
+
+	// Change a node in the datastore without invalidating the cache
+
+	sneakyStore := func(key string, name string) {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Person")
+		node.SetAttr("name", name)
+
+		attht, valht, _ := gm.getNodeStorageHTree("main", "Person", false)
+		gm.writeNode(node, false, attht, valht, nodeAttributeFilter)
+	}
+
+	fetchName := func(key string) interface{} {
+		node, err := gm.FetchNode("main", key, "Person")
+		if err != nil || node == nil {
+			return err
+		}
+		return node.Attr("name")
+	}
+
+	storeNode("1", "John")
+	storeNode("2", "Jane")
+	storeNode("3", "Bob")
+
+	if gm.nodeCache.len() != 0 {
+		t.Error("Unexpected cache size:", gm.nodeCache.len())
+		return
+	}
+
+	if name := fetchName("1"); name != "John" || gm.nodeCache.len() != 1 {
+		t.Error("Unexpected result:", name, gm.nodeCache.len())
+		return
+	}
+
+	// Cached node is returned
+
+	sneakyStore("1", "Johnny")
+
+	if name := fetchName("1"); name != "John" {
+		t.Error("Unexpected result:", name)
+		return
+	}
+
+	// Returned nodes are copies
+
+	node, _ := gm.FetchNode("main", "1", "Person")
+	node.SetAttr("name", "Foo")
+
+	if name := fetchName("1"); name != "John" {
+		t.Error("Unexpected result:", name)
+		return
+	}
+
+	// Partial fetches are served from the cache
+
+	if node, err := gm.FetchNodePart("main", "1", "Person", []string{"name"}); err != nil ||
+		node.String() != `
+GraphNode:
+     key : 1
+    kind : Person
+    name : John
+`[1:] {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	if node, err := gm.FetchNodePart("main", "1", "Person", []string{"age"}); err != nil || node != nil {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	if node, err := gm.FetchNodePart("main", "1", "Person", []string{"key", "age"}); err != nil ||
+		node.String() != `
+GraphNode:
+     key : 1
+    kind : Person
+`[1:] {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	// Least recently used nodes are removed
+
+	fetchName("2")
+	fetchName("1")
+	fetchName("3")
+
+	if gm.nodeCache.len() != 2 {
+		t.Error("Unexpected cache size:", gm.nodeCache.len())
+		return
+	}
+
+	sneakyStore("2", "Janet")
+
+	if name := fetchName("2"); name != "Janet" {
+		t.Error("Unexpected result:", name)
+		return
+	}
+
+	// Writes invalidate cached nodes
+
+	storeNode("2", "Jane")
+
+	if name := fetchName("2"); name != "Jane" {
+		t.Error("Unexpected result:", name)
+		return
+	}
+
+	node = data.NewGraphNode()
+	node.SetAttr("key", "2")
+	node.SetAttr("kind", "Person")
+	node.SetAttr("name", "Janine")
+	gm.UpdateNode("main", node)
+
+	if name := fetchName("2"); name != "Janine" {
+		t.Error("Unexpected result:", name)
+		return
+	}
+
+	trans := NewGraphTrans(gm)
+	node.SetAttr("name", "Jenny")
+	trans.StoreNode("main", node)
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if name := fetchName("2"); name != "Jenny" {
+		t.Error("Unexpected result:", name)
+		return
+	}
+
+	gm.RemoveNode("main", "2", "Person")
+
+	if name := fetchName("2"); name != nil {
+		t.Error("Unexpected result:", name)
+		return
+	}
+
+	fetchName("1")
+
+	trans = NewGraphTrans(gm)
+	trans.RemoveNode("main", "1", "Person")
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if name := fetchName("1"); name != nil {
+		t.Error("Unexpected result:", name)
+		return
+	}
+
+	// The cache is emptied and not filled while the soft memory limit is exceeded
+
+	fetchName("2")
+
+	oldHeapSize := memlimit.HeapSize
+	memlimit.HeapSize = func() uint64 {
+		return 1000
+	}
+	defer func() {
+		memlimit.HeapSize = oldHeapSize
+		memlimit.SetLimit(0)
+	}()
+
+	memlimit.SetLimit(10)
+	memlimit.Update()
+
+	fetchName("3")
+
+	if gm.nodeCache.len() != 0 {
+		t.Error("Unexpected cache size:", gm.nodeCache.len())
+		return
+	}
+
+	memlimit.SetLimit(0)
+
+	fetchName("3")
+
+	if gm.nodeCache.len() != 1 {
+		t.Error("Unexpected cache size:", gm.nodeCache.len())
+		return
+	}
+
+	// Disable the cache
+
+	gm.SetNodeCacheSize(0)
+
+	if gm.nodeCache != nil {
+		t.Error("Cache should be disabled")
+		return
+	}
+
+	sneakyStore("3", "Bobby")
+
+	if name := fetchName("3"); name != "Bobby" {
+		t.Error("Unexpected result:", name)
+		return
+	}
+}
+
+func TestNodeCacheMissing(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	storeNode := func(key string) {
+		node := data.NewGraphNode()
+		node.SetAttr("key", key)
+		node.SetAttr("kind", "Person")
+		gm.StoreNode("main", node)
+	}
+
+	// Missing nodes are not remembered without a node cache
+
+	gm.SetNodeCacheMissTTL(time.Minute)
+
+	gm.SetNodeCacheSize(2)
+
+	storeNode("1")
+
+	if node, err := gm.FetchNode("main", "2", "Person"); node != nil || err != nil ||
+		len(gm.nodeCache.missing) != 0 {
+		t.Error("Unexpected result:", node, err, len(gm.nodeCache.missing))
+		return
+	}
+
+	gm.SetNodeCacheMissTTL(time.Minute)
+
+	// Missing nodes are remembered
+
+	for _, key := range []string{"2", "3", "4"} {
+		if node, err := gm.FetchNode("main", key, "Person"); node != nil || err != nil {
+			t.Error("Unexpected result:", node, err)
+			return
+		}
+	}
+
+	if len(gm.nodeCache.missing) != 2 {
+		t.Error("Unexpected number of missing nodes:", len(gm.nodeCache.missing))
+		return
+	}
+
+	// A remembered missing node is not read from the datastore
+
+	sneakyNode := data.NewGraphNode()
+	sneakyNode.SetAttr("key", "2")
+	sneakyNode.SetAttr("kind", "Person")
+
+	attht, valht, _ := gm.getNodeStorageHTree("main", "Person", false)
+	gm.writeNode(sneakyNode, false, attht, valht, nodeAttributeFilter)
+
+	if node, err := gm.FetchNode("main", "2", "Person"); node != nil || err != nil {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	// Remembered nodes expire
+
+	gm.nodeCache.missing["main#Person#2"] = time.Now().Add(-time.Second)
+
+	if node, err := gm.FetchNode("main", "2", "Person"); node == nil || err != nil {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	// Storing a node invalidates the remembered lookup
+
+	storeNode("3")
+
+	if node, err := gm.FetchNode("main", "3", "Person"); node == nil || err != nil {
+		t.Error("Unexpected result:", node, err)
+		return
+	}
+
+	// Expired entries make room for new entries
+
+	gm.nodeCache.missing["main#Person#x"] = time.Now().Add(time.Minute)
+	gm.nodeCache.missing["main#Person#y"] = time.Now().Add(-time.Second)
+
+	if node, err := gm.FetchNode("main", "5", "Person"); node != nil || err != nil ||
+		len(gm.nodeCache.missing) != 2 {
+		t.Error("Unexpected result:", node, err, len(gm.nodeCache.missing))
+		return
+	}
+
+	// Resizing the cache keeps the setting
+
+	gm.SetNodeCacheSize(5)
+
+	if gm.nodeCache.missTTL != time.Minute || len(gm.nodeCache.missing) != 0 {
+		t.Error("Unexpected state:", gm.nodeCache.missTTL, len(gm.nodeCache.missing))
+		return
+	}
+
+	gm.SetNodeCacheMissTTL(0)
+
+	if node, err := gm.FetchNode("main", "6", "Person"); node != nil || err != nil ||
+		len(gm.nodeCache.missing) != 0 {
+		t.Error("Unexpected result:", node, err, len(gm.nodeCache.missing))
+		return
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sync"
+
+	"devt.de/eliasdb/graph/data"
+)
+
+/*
+PipelineResult is the result of a single pipeline operation.
+*/
+type PipelineResult struct {
+	ID    int         // Id of the operation which was returned when it was submitted
+	Nodes []data.Node // Fetched or traversed nodes
+	Edges []data.Edge // Fetched or traversed edges
+	Error error       // Error of the operation
+}
+
+/*
+Pipeline data structure
+*/
+type Pipeline struct {
+	gm      *Manager      // Graph manager which executes the operations
+	workers int           // Number of concurrent workers
+	ops     []*pipelineOp // Submitted operations
+	mutex   *sync.Mutex   // Mutex to protect the list of operations
+}
+
+/*
+pipelineOp is a single operation of a pipeline.
+*/
+type pipelineOp struct {
+	part string                                   // Partition of the operation
+	kind string                                   // Node or edge kind of the operation
+	edge bool                                     // Flag if the operation starts at an edge
+	run  func() ([]data.Node, []data.Edge, error) // Function which executes the operation
+}
+
+/*
+NewGraphPipeline creates a new pipeline which resolves many independent read
+operations concurrently using a given number of workers. This can hide the
+latency of single operations if the storage is not local.
+*/
+func NewGraphPipeline(gm *Manager, workers int) *Pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pipeline{gm, workers, nil, &sync.Mutex{}}
+}
+
+/*
+FetchNode submits an operation to fetch a single node. Returns the id of the
+operation.
+*/
+func (p *Pipeline) FetchNode(part string, key string, kind string) int {
+	return p.FetchNodePart(part, key, kind, nil)
+}
+
+/*
+FetchNodePart submits an operation to fetch part of a single node. Returns
+the id of the operation.
+*/
+func (p *Pipeline) FetchNodePart(part string, key string, kind string, attrs []string) int {
+	return p.addOp(part, kind, false, func() ([]data.Node, []data.Edge, error) {
+		node, err := p.gm.FetchNodePart(part, key, kind, attrs)
+		if node == nil {
+			return nil, nil, err
+		}
+		return []data.Node{node}, nil, err
+	})
+}
+
+/*
+FetchEdge submits an operation to fetch a single edge. Returns the id of the
+operation.
+*/
+func (p *Pipeline) FetchEdge(part string, key string, kind string) int {
+	return p.FetchEdgePart(part, key, kind, nil)
+}
+
+/*
+FetchEdgePart submits an operation to fetch part of a single edge. Returns
+the id of the operation.
+*/
+func (p *Pipeline) FetchEdgePart(part string, key string, kind string, attrs []string) int {
+	return p.addOp(part, kind, true, func() ([]data.Node, []data.Edge, error) {
+		edge, err := p.gm.FetchEdgePart(part, key, kind, attrs)
+		if edge == nil {
+			return nil, nil, err
+		}
+		return nil, []data.Edge{edge}, err
+	})
+}
+
+/*
+TraverseMulti submits a traversal operation from a given node following a
+given (partial) edge spec. Returns the id of the operation.
+*/
+func (p *Pipeline) TraverseMulti(part string, key string, kind string,
+	spec string, allData bool) int {
+
+	return p.addOp(part, kind, false, func() ([]data.Node, []data.Edge, error) {
+		return p.gm.TraverseMulti(part, key, kind, spec, allData)
+	})
+}
+
+/*
+addOp adds an operation to the pipeline.
+*/
+func (p *Pipeline) addOp(part string, kind string, edge bool,
+	run func() ([]data.Node, []data.Edge, error)) int {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.ops = append(p.ops, &pipelineOp{part, kind, edge, run})
+
+	return len(p.ops) - 1
+}
+
+/*
+Run executes all submitted operations concurrently and sends their results
+to the returned channel as they complete. The channel is closed once all
+operations have completed. The pipeline is empty after this call and new
+operations can be submitted.
+*/
+func (p *Pipeline) Run() <-chan *PipelineResult {
+	return p.run(p.takeOps())
+}
+
+/*
+Execute executes all submitted operations concurrently and waits until all
+of them have completed. Returns the results ordered by operation id.
+*/
+func (p *Pipeline) Execute() []*PipelineResult {
+	ops := p.takeOps()
+	results := make([]*PipelineResult, len(ops))
+
+	for r := range p.run(ops) {
+		results[r.ID] = r
+	}
+
+	return results
+}
+
+/*
+takeOps removes all submitted operations from the pipeline and returns them.
+*/
+func (p *Pipeline) takeOps() []*pipelineOp {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ops := p.ops
+	p.ops = nil
+
+	return ops
+}
+
+/*
+run executes a list of operations concurrently.
+*/
+func (p *Pipeline) run(ops []*pipelineOp) <-chan *PipelineResult {
+
+	// Access the storage of all involved kinds before running operations
+	// concurrently - this makes sure that all storage structures exist
+
+	seen := make(map[string]bool)
+
+	for _, op := range ops {
+		if skey := fmt.Sprint(op.part, "#", op.kind, "#", op.edge); !seen[skey] {
+			seen[skey] = true
+
+			if op.edge {
+				p.gm.getEdgeStorageHTree(op.part, op.kind, true)
+			} else {
+				p.gm.getNodeStorageHTree(op.part, op.kind, false)
+			}
+		}
+	}
+
+	res := make(chan *PipelineResult, len(ops))
+	ids := make(chan int, len(ops))
+
+	for i := range ops {
+		ids <- i
+	}
+	close(ids)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < p.workers && i < len(ops); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for id := range ids {
+				nodes, edges, err := ops[id].run()
+				res <- &PipelineResult{id, nodes, edges, err}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(res)
+	}()
+
+	return res
+}
@@ -0,0 +1,147 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestPipeline(t *testing.T) {
+
+	mgs := graphstorage.NewMemoryGraphStorage("pipeline test")
+
+	gm := newGraphManagerNoRules(mgs)
+
+	for i := 0; i < 20; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint(i))
+		node.SetAttr("kind", "mynode")
+		node.SetAttr("name", fmt.Sprint("Node", i))
+
+		if err := gm.StoreNode("main", node); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if i > 0 {
+			edge := data.NewGraphEdge()
+
+			edge.SetAttr("key", fmt.Sprint("e", i))
+			edge.SetAttr("kind", "myedge")
+
+			edge.SetAttr(data.EdgeEnd1Key, "0")
+			edge.SetAttr(data.EdgeEnd1Kind, "mynode")
+			edge.SetAttr(data.EdgeEnd1Role, "root")
+			edge.SetAttr(data.EdgeEnd1Cascading, false)
+
+			edge.SetAttr(data.EdgeEnd2Key, fmt.Sprint(i))
+			edge.SetAttr(data.EdgeEnd2Kind, "mynode")
+			edge.SetAttr(data.EdgeEnd2Role, "child")
+			edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+			if err := gm.StoreEdge("main", edge); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+
+	p := NewGraphPipeline(gm, 4)
+
+	for i := 0; i < 20; i++ {
+		if id := p.FetchNode("main", fmt.Sprint(i), "mynode"); id != i {
+			t.Error("Unexpected id:", id)
+			return
+		}
+	}
+
+	idMissing := p.FetchNode("main", "99", "mynode")
+	idPart := p.FetchNodePart("main", "5", "mynode", []string{"key"})
+	idEdge := p.FetchEdge("main", "e3", "myedge")
+	idTraverse := p.TraverseMulti("main", "0", "mynode", ":::", false)
+	idError := p.FetchNode("main", "1", "my-node")
+
+	res := p.Execute()
+
+	if len(res) != 25 {
+		t.Error("Unexpected number of results:", len(res))
+		return
+	}
+
+	for i := 0; i < 20; i++ {
+		if res[i].Error != nil || len(res[i].Nodes) != 1 ||
+			res[i].Nodes[0].Attr("name") != fmt.Sprint("Node", i) {
+			t.Error("Unexpected result:", res[i])
+			return
+		}
+	}
+
+	if res[idMissing].Error != nil || res[idMissing].Nodes != nil {
+		t.Error("Unexpected result:", res[idMissing])
+		return
+	}
+
+	if r := res[idPart]; r.Error != nil || len(r.Nodes) != 1 || r.Nodes[0].Attr("name") != nil {
+		t.Error("Unexpected result:", r)
+		return
+	}
+
+	if r := res[idEdge]; r.Error != nil || len(r.Edges) != 1 || r.Edges[0].End2Key() != "3" {
+		t.Error("Unexpected result:", r)
+		return
+	}
+
+	if r := res[idTraverse]; r.Error != nil || len(r.Nodes) != 19 || len(r.Edges) != 19 {
+		t.Error("Unexpected result:", r)
+		return
+	}
+
+	if r := res[idError]; r.Error == nil || r.Error.Error() !=
+		"GraphError: Invalid data (Node kind my-node is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected result:", r.Error)
+		return
+	}
+
+	// Results can also be received as they complete
+
+	for i := 0; i < 10; i++ {
+		p.FetchNode("main", fmt.Sprint(i), "mynode")
+	}
+
+	var keys []string
+
+	for r := range p.Run() {
+		if r.Error != nil {
+			t.Error(r.Error)
+			return
+		}
+		keys = append(keys, r.Nodes[0].Key())
+	}
+
+	sort.Strings(keys)
+
+	if res := fmt.Sprint(keys); res != "[0 1 2 3 4 5 6 7 8 9]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Running an empty pipeline should return no results
+
+	if res := p.Execute(); len(res) != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -0,0 +1,316 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/binary"
+	"math"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+CreateRangeIndex creates a range index for an attribute of a node kind. A
+range index keeps the nodes ordered by the numeric value of an attribute.
+Timestamps are indexed as seconds since the epoch and strings are indexed if
+they contain a number. Other values are not indexed. The index is built from
+the existing nodes of all partitions and is maintained whenever nodes are
+stored or removed.
+*/
+func (gm *Manager) CreateRangeIndex(kind string, attr string) error {
+	return gm.changeIndexedAttr(gm.rangeIdx, kind, attr, true, gm.buildRangeIndex)
+}
+
+/*
+DropRangeIndex removes the range index of an attribute of a node kind.
+*/
+func (gm *Manager) DropRangeIndex(kind string, attr string) error {
+	return gm.changeIndexedAttr(gm.rangeIdx, kind, attr, false, gm.buildRangeIndex)
+}
+
+/*
+RangeIndexes returns the sorted attributes of a node kind which have a range
+index.
+*/
+func (gm *Manager) RangeIndexes(kind string) []string {
+	return gm.rangeIdx.sorted(kind)
+}
+
+/*
+NodeKeysByRange returns the keys of all nodes of a kind in a partition whose
+attribute has a numeric value v with min <= v <= max. The keys are ordered by
+the attribute value (nodes with the same value are ordered by key). The range
+can be unbounded by using infinite values. The attribute must have a range
+index.
+*/
+func (gm *Manager) NodeKeysByRange(part string, kind string, attr string,
+	min float64, max float64) ([]string, error) {
+
+	if err := gm.rangeIdx.checkIndexed(kind, attr); err != nil {
+		return nil, err
+	}
+
+	tree, err := gm.getRangeIndexTree(part, kind, false)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0)
+
+	if math.IsNaN(min) || math.IsNaN(max) || min > max {
+		return ret, nil
+	}
+
+	// Take reader lock
+
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "NodeKeysByRange")
+	defer gm.mutex.RUnlock()
+
+	// The upper bound of the tree range is exclusive
+
+	prefix := gm.nm.Encode32(attr, true)
+	to := make([]byte, 8)
+
+	binary.BigEndian.PutUint64(to, rangeIndexNumber(max)+1)
+
+	it := hash.NewBTreeRangeIterator(tree, []byte(prefix+string(rangeIndexValue(min))),
+		[]byte(prefix+string(to)))
+
+	for it.HasNext() {
+		_, key := it.Next()
+		ret = append(ret, key.(string))
+	}
+
+	if it.LastError != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+	}
+
+	return ret, nil
+}
+
+/*
+buildRangeIndex adds the range index entries of an attribute for all existing
+nodes of a kind or removes them. It is assumed that the caller holds the
+writer lock.
+*/
+func (gm *Manager) buildRangeIndex(kind string, attr string, add bool) error {
+	var parts []string
+
+	err := func() error {
+
+		for _, part := range gm.Partitions() {
+
+			sm := gm.gs.StorageManager(part+kind+StorageSuffixNodes, false)
+			if sm == nil {
+				continue
+			}
+
+			attrTree, err := gm.getHTree(sm, RootIDNodeHTree)
+			if err != nil {
+				return err
+			}
+
+			valTree, err := gm.getHTree(sm, RootIDNodeHTreeSecond)
+			if err != nil {
+				return err
+			}
+
+			tree, err := gm.getRangeIndexTree(part, kind, true)
+			if err != nil {
+				return err
+			}
+
+			parts = append(parts, part)
+
+			it := attrTree.IteratorPrefix([]byte(PrefixNSAttrs))
+
+			for it.HasNext() {
+				k, _ := it.Next()
+
+				if it.LastError != nil {
+					break
+				}
+
+				key := string(k[len(PrefixNSAttrs):])
+
+				node, err := gm.readNode(key, kind, []string{attr}, attrTree, valTree)
+				if err != nil {
+					return err
+				} else if node == nil {
+					continue
+				}
+
+				ikey := gm.rangeIndexKey(attr, node.Attr(attr), key)
+				if ikey == nil {
+					continue
+				}
+
+				if add {
+					_, err = tree.Put(ikey, key)
+				} else {
+					_, err = tree.Remove(ikey)
+				}
+
+				if err != nil {
+					return util.NewWritingError(err)
+				}
+			}
+
+			if it.LastError != nil {
+				return &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+			}
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		for _, part := range parts {
+			gm.rollbackNodeIndex(part, kind)
+		}
+
+		return err
+	}
+
+	for _, part := range parts {
+		if err := gm.flushNodeIndex(part, kind); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+updateRangeIndex updates the range indexes of a node kind after a node was
+written or deleted (the node is nil). Attributes which are missing on a
+partial node keep their value. It is assumed that the caller holds the writer
+lock.
+*/
+func (gm *Manager) updateRangeIndex(part string, key string, kind string, node data.Node,
+	oldnode data.Node, partial bool) error {
+
+	attrs := gm.rangeIdx.forKind(kind)
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	tree, err := gm.getRangeIndexTree(part, kind, true)
+	if err != nil {
+		return err
+	}
+
+	for _, attr := range attrs {
+		var ikey, oldikey []byte
+
+		if node != nil {
+			val := node.Attr(attr)
+
+			if val == nil && partial {
+				continue
+			}
+
+			ikey = gm.rangeIndexKey(attr, val, key)
+		}
+
+		if oldnode != nil {
+			oldikey = gm.rangeIndexKey(attr, oldnode.Attr(attr), key)
+		}
+
+		if string(ikey) == string(oldikey) {
+			continue
+		}
+
+		if oldikey != nil {
+			if _, err := tree.Remove(oldikey); err != nil {
+				return util.NewWritingError(err)
+			}
+		}
+
+		if ikey != nil {
+			if _, err := tree.Put(ikey, key); err != nil {
+				return util.NewWritingError(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+rangeIndexKey returns the key of the range index entry of an attribute value
+of a node. Returns nil if the value cannot be indexed.
+*/
+func (gm *Manager) rangeIndexKey(attr string, val interface{}, key string) []byte {
+
+	num, ok := data.ValueNumber(val)
+	if !ok || math.IsNaN(num) {
+		return nil
+	}
+
+	return []byte(gm.nm.Encode32(attr, true) + string(rangeIndexValue(num)) + key)
+}
+
+/*
+rangeIndexValue encodes a number so that the byte order of encoded numbers is
+the same as the order of the numbers.
+*/
+func rangeIndexValue(num float64) []byte {
+	ret := make([]byte, 8)
+
+	binary.BigEndian.PutUint64(ret, rangeIndexNumber(num))
+
+	return ret
+}
+
+/*
+rangeIndexNumber maps a number to an unsigned integer with the same order. The
+sign bit of positive numbers is set and all bits of negative numbers are
+inverted.
+*/
+func rangeIndexNumber(num float64) uint64 {
+
+	if num == 0 {
+		num = 0 // Negative zero is the same as zero
+	}
+
+	bits := math.Float64bits(num)
+
+	if bits&(1<<63) != 0 {
+		return ^bits
+	}
+
+	return bits | (1 << 63)
+}
+
+/*
+getRangeIndexTree gets the tree which stores the range indexes of a node kind.
+The tree is kept in the storage of the full text index so all indexes are
+flushed and rolled back together.
+*/
+func (gm *Manager) getRangeIndexTree(part string, kind string, create bool) (*hash.BTree, error) {
+
+	// Check if the partition name is valid
+
+	if err := gm.checkPartitionName(part); err != nil {
+		return nil, err
+	}
+
+	sm := gm.gs.StorageManager(part+kind+StorageSuffixNodesIndex, create)
+	if sm == nil {
+		return nil, nil
+	}
+
+	return gm.getBTree(sm, RootIDNodeBTree)
+}
@@ -0,0 +1,220 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestRangeIndex(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	newPerson := func(key string, age interface{}) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Person")
+		node.SetAttr("age", age)
+		return node
+	}
+
+	lookup := func(gm *Manager, part string, min float64, max float64) string {
+		res, err := gm.NodeKeysByRange(part, "Person", "age", min, max)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprint(res)
+	}
+
+	inf := math.Inf(1)
+
+	// The index is built from existing nodes
+
+	gm.StoreNode("main", newPerson("p1", int64(42)))
+	gm.StoreNode("main", newPerson("p2", 30.5))
+	gm.StoreNode("main", newPerson("p3", "25"))
+	gm.StoreNode("main", newPerson("p4", "abc"))
+	gm.StoreNode("main", newPerson("p5", -3))
+	gm.StoreNode("main", newPerson("p6", nil))
+	gm.StoreNode("main", newPerson("p7", int64(42)))
+
+	if res := lookup(gm, "main", 0, inf); res !=
+		"GraphError: Invalid data (Attribute age of node kind Person has no range index)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := gm.CreateRangeIndex("Person", "age"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Keys are ordered by value - bounds are inclusive
+
+	if res := lookup(gm, "main", -inf, inf); res != "[p5 p3 p2 p1 p7]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm, "main", 25, 42); res != "[p3 p2 p1 p7]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm, "main", 25.5, 41.9); res != "[p2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm, "main", 43, 20); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm, "main", math.NaN(), inf); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm, "other", -inf, inf); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The index is updated when nodes are stored, updated and removed
+
+	gm.StoreNode("main", newPerson("p4", 12))
+	gm.StoreNode("main", newPerson("p1", "old"))
+
+	update := data.NewGraphNode()
+	update.SetAttr(data.NodeKey, "p2")
+	update.SetAttr(data.NodeKind, "Person")
+	update.SetAttr("name", "Bob")
+
+	gm.UpdateNode("main", update)
+	gm.UpdateNode("main", newPerson("p3", 50))
+
+	gm.RemoveNode("main", "p7", "Person")
+
+	if res := lookup(gm, "main", -inf, inf); res != "[p5 p4 p2 p3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Transactions update the index
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newPerson("p8", 0))
+	trans.RemoveNode("main", "p5", "Person")
+	trans.UpdateNode("main", newPerson("p2", 100))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := lookup(gm, "main", -inf, inf); res != "[p8 p4 p3 p2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Indexes are persisted
+
+	gm2 := NewGraphManager(mgs)
+
+	if res := gm2.RangeIndexes("Person"); fmt.Sprint(res) != "[age]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm2, "main", 0, 12); res != "[p8 p4]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Dropping the index removes all entries
+
+	if err := gm2.DropRangeIndex("Person", "age"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	tree, _ := gm2.getRangeIndexTree("main", "Person", false)
+
+	if keys, _, _ := tree.GetRange(nil, nil); len(keys) != 0 {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	if res := NewGraphManager(mgs).RangeIndexes("Person"); len(res) != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := gm.CreateRangeIndex("Person", ""); err == nil || err.Error() !=
+		"GraphError: Invalid data (Attribute  cannot have a range index)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestRangeIndexTimestamps(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	if err := gm.CreateRangeIndex("Event", "time"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	start := time.Date(2016, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	for i := 0; i < 5; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, fmt.Sprint("e", i))
+		node.SetAttr(data.NodeKind, "Event")
+		node.SetAttr("time", start.Add(time.Duration(4-i)*time.Hour))
+		gm.StoreNode("main", node)
+	}
+
+	min, _ := data.ValueNumber(start.Add(time.Hour))
+	max, _ := data.ValueNumber(start.Add(3 * time.Hour))
+
+	if res, err := gm.NodeKeysByRange("main", "Event", "time", min, max); err != nil ||
+		fmt.Sprint(res) != "[e3 e2 e1]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
+
+func TestRangeIndexValueOrder(t *testing.T) {
+	nums := []float64{math.Inf(-1), -math.MaxFloat64, -1, -0.5,
+		-math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 0.5, 1,
+		math.MaxFloat64, math.Inf(1)}
+
+	for i := 1; i < len(nums); i++ {
+		if bytes.Compare(rangeIndexValue(nums[i-1]), rangeIndexValue(nums[i])) != -1 {
+			t.Error("Unexpected order:", nums[i-1], nums[i])
+			return
+		}
+	}
+
+	if !bytes.Equal(rangeIndexValue(math.Copysign(0, -1)), rangeIndexValue(0)) {
+		t.Error("Negative zero should be the same as zero")
+		return
+	}
+}
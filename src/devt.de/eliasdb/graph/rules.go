@@ -11,6 +11,7 @@
 package graph
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 	"sync"
@@ -26,10 +27,18 @@ type graphRulesManager struct {
 	gm       *Manager                // GraphManager which provides events
 	rules    map[string]Rule         // Map of graph rules
 	eventMap map[int]map[string]Rule // Map of events to graph rules
+	mutex    *sync.RWMutex           // Mutex to protect the maps of graph rules
 }
 
 /*
-Rule models a graph rule.
+Rule models a graph rule. A rule is called for every graph event which it
+handles (see the Event constants for the event data). Rules are called after
+the node or edge of the event has been written - they can add further changes
+to the given transaction which is committed after all rules have run. The
+given Manager can only be used for queries - it must not be used to change
+the graph. If a rule returns an error the error is returned by the operation
+which caused the event. Changes of the operation itself are not undone.
+Rules are called in the order of their names.
 */
 type Rule interface {
 
@@ -50,15 +59,87 @@ type Rule interface {
 	Handle(gm *Manager, trans *Trans, event int, data ...interface{}) error
 }
 
+/*
+RuleHandler is a function which handles a graph event (see Rule.Handle).
+*/
+type RuleHandler func(gm *Manager, trans *Trans, event int, data ...interface{}) error
+
+/*
+NewRule creates a new graph rule which calls a given handler function for all
+events of an event mask (e.g. EventMaskNodeCreated | EventMaskNodeUpdated).
+*/
+func NewRule(name string, mask int, handler RuleHandler) Rule {
+	return &funcRule{name, EventsOfMask(mask), handler}
+}
+
+/*
+EventsOfMask returns the events of an event mask.
+*/
+func EventsOfMask(mask int) []int {
+	var ret []int
+
+	for event := EventNodeCreated; event <= EventEdgeDeleted; event++ {
+		if mask&(1<<uint(event)) != 0 {
+			ret = append(ret, event)
+		}
+	}
+
+	return ret
+}
+
+/*
+funcRule is a graph rule which calls a handler function.
+*/
+type funcRule struct {
+	name    string      // Name of the rule
+	events  []int       // Handled events
+	handler RuleHandler // Handler function
+}
+
+/*
+Name returns the name of the rule.
+*/
+func (r *funcRule) Name() string {
+	return r.name
+}
+
+/*
+Handles returns a list of events which are handled by this rule.
+*/
+func (r *funcRule) Handles() []int {
+	return r.events
+}
+
+/*
+Handle handles an event.
+*/
+func (r *funcRule) Handle(gm *Manager, trans *Trans, event int, data ...interface{}) error {
+	return r.handler(gm, trans, event, data...)
+}
+
 /*
 graphEvent main event handler which receives all graph related events.
 */
 func (gr *graphRulesManager) graphEvent(trans *Trans, event int, data ...interface{}) error {
-	var errors []string
+	var errors []error
+
+	// Take a snapshot of the rules so rules can be changed while an event
+	// is handled
+
+	gr.mutex.RLock()
+
+	rules := make([]Rule, 0, len(gr.eventMap[event]))
+	for _, rule := range gr.eventMap[event] {
+		rules = append(rules, rule)
+	}
+
+	gr.mutex.RUnlock()
 
-	rules, ok := gr.eventMap[event]
+	if len(rules) > 0 {
 
-	if ok {
+		sort.Slice(rules, func(i, j int) bool {
+			return rules[i].Name() < rules[j].Name()
+		})
 
 		for _, rule := range rules {
 
@@ -73,16 +154,13 @@ func (gr *graphRulesManager) graphEvent(trans *Trans, event int, data ...interfa
 			err := rule.Handle(gmclone, trans, event, data...)
 
 			if err != nil {
-				if errors == nil {
-					errors = make([]string, 0)
-				}
-				errors = append(errors, err.Error())
+				errors = append(errors, err)
 			}
 		}
 	}
 
 	if errors != nil {
-		return &util.GraphError{Type: util.ErrRule, Detail: strings.Join(errors, ";")}
+		return util.NewRuleError(errors)
 	}
 
 	return nil
@@ -92,13 +170,22 @@ func (gr *graphRulesManager) graphEvent(trans *Trans, event int, data ...interfa
 Clone a given graph manager and insert a new RWMutex.
 */
 func (gr *graphRulesManager) cloneGraphManager() *Manager {
-	return &Manager{gr.gm.gs, gr, gr.gm.nm, gr.gm.mapCache, &sync.RWMutex{}}
+	return &Manager{gr.gm.gs, gr, gr.gm.nm, gr.gm.mapCache, gr.gm.mapLock,
+		gr.gm.keyIndex, gr.gm.stats, gr.gm.edgeStats, gr.gm.invariants, gr.gm.schemas,
+		gr.gm.valueIdx, gr.gm.rangeIdx, gr.gm.geoIdx, gr.gm.indexOpts, gr.gm.nodeCache,
+		gr.gm.journal, &sync.RWMutex{}}
 }
 
 /*
-SetGraphRule sets a GraphRule.
+SetGraphRule sets a GraphRule. An existing rule with the same name is
+replaced.
 */
 func (gr *graphRulesManager) SetGraphRule(rule Rule) {
+	gr.mutex.Lock()
+	defer gr.mutex.Unlock()
+
+	gr.removeGraphRule(rule.Name())
+
 	gr.rules[rule.Name()] = rule
 
 	for _, handledEvent := range rule.Handles() {
@@ -113,10 +200,106 @@ func (gr *graphRulesManager) SetGraphRule(rule Rule) {
 	}
 }
 
+/*
+RegisterRule registers a graph rule. The name of the rule must be unique and
+must not start with the prefix of system rules. The rule must handle at least
+one event.
+*/
+func (gr *graphRulesManager) RegisterRule(rule Rule) error {
+
+	name := rule.Name()
+
+	if name == "" || strings.HasPrefix(name, SystemRulePrefix) {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Invalid rule name: %v", name),
+		}
+	}
+
+	events := rule.Handles()
+
+	if len(events) == 0 {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Rule %v does not handle any events", name),
+		}
+	}
+
+	for _, event := range events {
+		if event < EventNodeCreated || event > EventEdgeDeleted {
+			return &util.GraphError{
+				Type:   util.ErrInvalidData,
+				Detail: fmt.Sprintf("Rule %v handles unknown event %v", name, event),
+			}
+		}
+	}
+
+	gr.mutex.Lock()
+	defer gr.mutex.Unlock()
+
+	if _, ok := gr.rules[name]; ok {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Rule %v is already registered", name),
+		}
+	}
+
+	gr.rules[name] = rule
+
+	for _, event := range events {
+
+		rules, ok := gr.eventMap[event]
+		if !ok {
+			rules = make(map[string]Rule)
+			gr.eventMap[event] = rules
+		}
+
+		rules[name] = rule
+	}
+
+	return nil
+}
+
+/*
+UnregisterRule removes a graph rule which was registered with RegisterRule.
+*/
+func (gr *graphRulesManager) UnregisterRule(name string) error {
+	gr.mutex.Lock()
+	defer gr.mutex.Unlock()
+
+	if _, ok := gr.rules[name]; !ok || strings.HasPrefix(name, SystemRulePrefix) {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Unknown rule: %v", name),
+		}
+	}
+
+	gr.removeGraphRule(name)
+
+	return nil
+}
+
+/*
+removeGraphRule removes a graph rule. It is assumed that the caller holds
+the writer lock.
+*/
+func (gr *graphRulesManager) removeGraphRule(name string) {
+	delete(gr.rules, name)
+
+	for event, rules := range gr.eventMap {
+		if delete(rules, name); len(rules) == 0 {
+			delete(gr.eventMap, event)
+		}
+	}
+}
+
 /*
 GraphRules returns a list of all available graph rules.
 */
 func (gr *graphRulesManager) GraphRules() []string {
+	gr.mutex.RLock()
+	defer gr.mutex.RUnlock()
+
 	ret := make([]string, 0, len(gr.rules))
 
 	for rule := range gr.rules {
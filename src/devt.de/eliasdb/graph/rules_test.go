@@ -350,7 +350,7 @@ func TestRulesErrors(t *testing.T) {
 	// Check that the test rule was added
 
 	if rules := fmt.Sprint(gm.GraphRules()); rules !=
-		"[system.deletenodeedges system.updatenodestats testrule]" {
+		"[system.checkinvariants system.deletenodeedges system.deletenodelabels system.refreshkindstats system.updateedgekindstats system.updatenodestats testrule]" {
 		t.Error("unexpected graph rule list:", rules)
 		return
 	}
@@ -618,3 +618,156 @@ func TestRulesErrors(t *testing.T) {
 		return
 	}
 }
+
+func TestRegisterRule(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	var calls []string
+
+	// Audit rule which records the creation and deletion of nodes
+
+	audit := NewRule("audit", EventMaskNodeCreated|EventMaskNodeDeleted,
+		func(gm *Manager, trans *Trans, event int, ed ...interface{}) error {
+			part := ed[0].(string)
+			node := ed[1].(data.Node)
+
+			if node.Kind() == "Audit" {
+				return nil
+			}
+
+			calls = append(calls, fmt.Sprint("audit ", event, " ", node.Key()))
+
+			entry := data.NewGraphNode()
+			entry.SetAttr(data.NodeKey, fmt.Sprint(len(calls)))
+			entry.SetAttr(data.NodeKind, "Audit")
+			entry.SetAttr("event", event)
+			entry.SetAttr("node", node.Key())
+
+			return trans.StoreNode(part, entry)
+		})
+
+	order := NewRule("order", EventMaskNode, func(gm *Manager, trans *Trans, event int, ed ...interface{}) error {
+		if node := ed[1].(data.Node); node.Kind() != "Audit" {
+			calls = append(calls, fmt.Sprint("order ", event, " ", node.Key()))
+		}
+		return nil
+	})
+
+	if err := gm.RegisterRule(order); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.RegisterRule(audit); err != nil {
+		t.Error(err)
+		return
+	}
+
+	node := data.NewGraphNode()
+	node.SetAttr(data.NodeKey, "123")
+	node.SetAttr(data.NodeKind, "mynode")
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := gm.RemoveNode("main", "123", "mynode"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Rules are called in the order of their names
+
+	if res := fmt.Sprint(calls); res != "[audit 1 123 order 1 123 order 2 123 audit 3 123 order 3 123]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if c := gm.NodeCount("Audit"); c != 2 {
+		t.Error("Unexpected node count:", c)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "4", "Audit"); n == nil || n.Attr("node") != "123" {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Unregistered rules are no longer called
+
+	if err := gm.UnregisterRule("audit"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	calls = nil
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(calls); res != "[order 1 123]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if c := gm.NodeCount("Audit"); c != 2 {
+		t.Error("Unexpected node count:", c)
+		return
+	}
+
+	// Check errors
+
+	if err := gm.RegisterRule(order); err == nil || err.Error() !=
+		"GraphError: Invalid data (Rule order is already registered)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.RegisterRule(NewRule("", EventMaskAll, nil)); err == nil || err.Error() !=
+		"GraphError: Invalid data (Invalid rule name: )" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.RegisterRule(NewRule("system.foo", EventMaskAll, nil)); err == nil || err.Error() !=
+		"GraphError: Invalid data (Invalid rule name: system.foo)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.RegisterRule(NewRule("foo", 1, nil)); err == nil || err.Error() !=
+		"GraphError: Invalid data (Rule foo does not handle any events)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.RegisterRule(&TestRule{}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.UnregisterRule("audit"); err == nil || err.Error() !=
+		"GraphError: Invalid data (Unknown rule: audit)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.UnregisterRule("system.deletenodeedges"); err == nil || err.Error() !=
+		"GraphError: Invalid data (Unknown rule: system.deletenodeedges)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res := fmt.Sprint(EventsOfMask(EventMaskEdge | EventMaskNodeUpdated)); res != "[2 4 5 6]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -0,0 +1,423 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"devt.de/common/stringutil"
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+Attribute types which can be declared in a schema
+*/
+const (
+	SchemaTypeString = "string" // String values
+	SchemaTypeInt    = "int"    // Integer values (also floats without fraction as produced by JSON decoding)
+	SchemaTypeFloat  = "float"  // Any number value
+	SchemaTypeBool   = "bool"   // Boolean values
+	SchemaTypeTime   = "time"   // Timestamps (time.Time)
+	SchemaTypeBytes  = "bytes"  // Byte slices
+	SchemaTypeList   = "list"   // Lists of values
+	SchemaTypeMap    = "map"    // Nested structures (map[string]interface{})
+)
+
+/*
+Schema describes the nodes of a certain kind. Nodes which are stored must
+have all required attributes and attribute values must have the declared
+types. The values of unique attributes must not be used by another node of
+the kind in the same partition (values are compared by type and content).
+Attributes which have no declared type can have any value unless the schema
+is closed. Edges which are connected to nodes of the kind must have one of
+the allowed edge kinds (if any are declared).
+*/
+type Schema struct {
+	Kind      string            `json:"kind"`      // Node kind which is described
+	Required  []string          `json:"required"`  // Attributes which every node must have
+	Unique    []string          `json:"unique"`    // Attributes whose values must be unique in a partition
+	Types     map[string]string `json:"types"`     // Types of attributes
+	Closed    bool              `json:"closed"`    // Flag if only declared attributes are allowed
+	EdgeKinds []string          `json:"edgekinds"` // Allowed edge kinds (empty for any edge kind)
+}
+
+/*
+schemaRegistry holds the declared schemas.
+*/
+type schemaRegistry struct {
+	schemas map[string]*Schema // Declared schemas by node kind
+	mutex   *sync.Mutex        // Mutex to protect the registry
+}
+
+/*
+newSchemaRegistry creates a new schema registry and loads all schemas which
+are stored in a given main database map.
+*/
+func newSchemaRegistry(stored map[string]string) *schemaRegistry {
+	r := &schemaRegistry{make(map[string]*Schema), &sync.Mutex{}}
+
+	for kind, val := range stored {
+		schema := &Schema{}
+
+		if err := json.Unmarshal([]byte(val), schema); err == nil {
+			r.schemas[kind] = schema
+		}
+	}
+
+	return r
+}
+
+/*
+forKind returns the schema of a given node kind or nil if there is none.
+*/
+func (r *schemaRegistry) forKind(kind string) *Schema {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.schemas[kind]
+}
+
+/*
+hasRequired checks if a given node kind has required attributes.
+*/
+func (r *schemaRegistry) hasRequired(kind string) bool {
+	schema := r.forKind(kind)
+
+	return schema != nil && len(schema.Required) > 0
+}
+
+/*
+checkNode checks a node against the schema of its kind. Required attributes
+are not checked for partial nodes (e.g. updates of existing nodes).
+*/
+func (r *schemaRegistry) checkNode(node data.Node, partial bool) error {
+
+	schema := r.forKind(node.Kind())
+	if schema == nil {
+		return nil
+	}
+
+	schemaError := func(detail string) error {
+		return &util.GraphError{
+			Type:   util.ErrSchemaViolation,
+			Detail: fmt.Sprintf("Node %v (%v) %v", node.Key(), node.Kind(), detail),
+		}
+	}
+
+	if !partial {
+		for _, attr := range schema.Required {
+			if node.Attr(attr) == nil {
+				return schemaError("is missing required attribute " + attr)
+			}
+		}
+	}
+
+	// Check attributes in a stable order so errors are reproducible
+
+	attrs := make([]string, 0, len(node.Data()))
+	for attr := range node.Data() {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	for _, attr := range attrs {
+		val := node.Attr(attr)
+
+		if typ, ok := schema.Types[attr]; ok {
+			if val != nil && !schemaTypeMatches(typ, val) {
+				return schemaError(fmt.Sprintf("attribute %v must be of type %v not %T", attr, typ, val))
+			}
+		} else if schema.Closed && attr != data.NodeKey && attr != data.NodeKind &&
+			!containsString(schema.Required, attr) && !containsString(schema.Unique, attr) {
+			return schemaError(fmt.Sprintf("attribute %v is not declared", attr))
+		}
+	}
+
+	return nil
+}
+
+/*
+checkEdge checks that an edge has an allowed kind for the schemas of both of
+its end nodes.
+*/
+func (r *schemaRegistry) checkEdge(edge data.Edge) error {
+
+	for _, kind := range []string{edge.End1Kind(), edge.End2Kind()} {
+		schema := r.forKind(kind)
+
+		if schema == nil || len(schema.EdgeKinds) == 0 {
+			continue
+		}
+
+		if !containsString(schema.EdgeKinds, edge.Kind()) {
+			return &util.GraphError{
+				Type: util.ErrSchemaViolation,
+				Detail: fmt.Sprintf("Edge %v (%v) is not allowed for node kind %v - allowed edge kinds: %v",
+					edge.Key(), edge.Kind(), kind, schema.EdgeKinds),
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+containsString checks if a list of strings contains a given string.
+*/
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+schemaTypeMatches checks if a value has a given schema type.
+*/
+func schemaTypeMatches(typ string, val interface{}) bool {
+
+	switch typ {
+	case SchemaTypeString:
+		_, ok := val.(string)
+		return ok
+
+	case SchemaTypeBool:
+		_, ok := val.(bool)
+		return ok
+
+	case SchemaTypeTime:
+		_, ok := val.(time.Time)
+		return ok
+
+	case SchemaTypeBytes:
+		_, ok := val.([]byte)
+		return ok
+
+	case SchemaTypeMap:
+		_, ok := val.(map[string]interface{})
+		return ok
+	}
+
+	rv := reflect.ValueOf(val)
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return typ == SchemaTypeInt || typ == SchemaTypeFloat
+
+	case reflect.Float32, reflect.Float64:
+		return typ == SchemaTypeFloat || (typ == SchemaTypeInt && rv.Float() == math.Trunc(rv.Float()))
+
+	case reflect.Slice:
+		_, isBytes := val.([]byte)
+		return typ == SchemaTypeList && !isBytes
+	}
+
+	return false
+}
+
+/*
+validateSchema checks that a schema is well formed.
+*/
+func validateSchema(schema *Schema) error {
+
+	schemaError := func(detail string) error {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Invalid schema %v: %v", schema.Kind, detail),
+		}
+	}
+
+	if schema.Kind == "" || !stringutil.IsAlphaNumeric(schema.Kind) {
+		return schemaError("Kind must be alphanumeric")
+	}
+
+	for _, attr := range schema.Required {
+		if attr == "" {
+			return schemaError("Required attribute names must not be empty")
+		}
+	}
+
+	for _, attr := range schema.Unique {
+		if attr == "" {
+			return schemaError("Unique attribute names must not be empty")
+		}
+	}
+
+	for attr, typ := range schema.Types {
+		if attr == "" {
+			return schemaError("Attribute names must not be empty")
+		}
+
+		switch typ {
+		case SchemaTypeString, SchemaTypeInt, SchemaTypeFloat, SchemaTypeBool,
+			SchemaTypeTime, SchemaTypeBytes, SchemaTypeList, SchemaTypeMap:
+		default:
+			return schemaError(fmt.Sprintf("Unknown type %v of attribute %v", typ, attr))
+		}
+	}
+
+	for _, ekind := range schema.EdgeKinds {
+		if !stringutil.IsAlphaNumeric(ekind) {
+			return schemaError("Edge kinds must be alphanumeric")
+		}
+	}
+
+	return nil
+}
+
+/*
+SetSchema declares the schema of a node kind. An existing schema of the kind
+is replaced. Schemas are checked whenever nodes or edges are stored. Existing
+nodes are not checked - except for attributes which become unique. The
+constraint index of these attributes is built from the existing nodes and the
+schema is rejected if existing nodes share a value.
+*/
+func (gm *Manager) SetSchema(schema *Schema) error {
+
+	if err := validateSchema(schema); err != nil {
+		return err
+	}
+
+	val, err := json.Marshal(schema)
+	if err != nil {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: fmt.Sprintf("Invalid schema %v: %v", schema.Kind, err.Error()),
+		}
+	}
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "SetSchema")
+	defer gm.mutex.Unlock()
+
+	var oldUnique []string
+
+	if old := gm.schemas.forKind(schema.Kind); old != nil {
+		oldUnique = old.Unique
+	}
+
+	if err := gm.changeUniqueIndex(schema.Kind, oldUnique, schema.Unique); err != nil {
+		return err
+	}
+
+	// Store a copy so later changes of the given schema have no effect
+
+	gm.schemas.mutex.Lock()
+	gm.schemas.schemas[schema.Kind] = copySchema(schema)
+	gm.schemas.mutex.Unlock()
+
+	stored := make(map[string]string)
+	for k, v := range gm.getMainDBMap(MainDBSchemas) {
+		stored[k] = v
+	}
+	stored[schema.Kind] = string(val)
+
+	gm.storeMainDBMap(MainDBSchemas, stored)
+
+	return gm.gs.FlushMain()
+}
+
+/*
+RemoveSchema removes the schema of a node kind. Returns the removed schema or
+nil if it did not exist.
+*/
+func (gm *Manager) RemoveSchema(kind string) (*Schema, error) {
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "RemoveSchema")
+	defer gm.mutex.Unlock()
+
+	schema := gm.schemas.forKind(kind)
+	if schema == nil {
+		return nil, nil
+	}
+
+	if err := gm.changeUniqueIndex(kind, schema.Unique, nil); err != nil {
+		return nil, err
+	}
+
+	gm.schemas.mutex.Lock()
+	delete(gm.schemas.schemas, kind)
+	gm.schemas.mutex.Unlock()
+
+	stored := make(map[string]string)
+	for k, v := range gm.getMainDBMap(MainDBSchemas) {
+		if k != kind {
+			stored[k] = v
+		}
+	}
+
+	gm.storeMainDBMap(MainDBSchemas, stored)
+
+	return schema, gm.gs.FlushMain()
+}
+
+/*
+Schema returns the schema of a node kind or nil if the kind has no schema.
+*/
+func (gm *Manager) Schema(kind string) *Schema {
+	if schema := gm.schemas.forKind(kind); schema != nil {
+		return copySchema(schema)
+	}
+
+	return nil
+}
+
+/*
+Schemas returns all declared schemas ordered by node kind.
+*/
+func (gm *Manager) Schemas() []*Schema {
+	gm.schemas.mutex.Lock()
+	defer gm.schemas.mutex.Unlock()
+
+	var ret []*Schema
+
+	for _, schema := range gm.schemas.schemas {
+		ret = append(ret, copySchema(schema))
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Kind < ret[j].Kind
+	})
+
+	return ret
+}
+
+/*
+copySchema returns a deep copy of a schema.
+*/
+func copySchema(schema *Schema) *Schema {
+	ret := &Schema{schema.Kind, nil, nil, nil, schema.Closed, nil}
+
+	ret.Required = append(ret.Required, schema.Required...)
+	ret.Unique = append(ret.Unique, schema.Unique...)
+	ret.EdgeKinds = append(ret.EdgeKinds, schema.EdgeKinds...)
+
+	if schema.Types != nil {
+		ret.Types = make(map[string]string, len(schema.Types))
+
+		for k, v := range schema.Types {
+			ret.Types[k] = v
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,298 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"devt.de/common/errorutil"
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/graph/util"
+)
+
+func TestSchemaNodes(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	newNode := func(key string, attrs ...interface{}) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Person")
+		for i := 0; i < len(attrs); i += 2 {
+			node.SetAttr(attrs[i].(string), attrs[i+1])
+		}
+		return node
+	}
+
+	// Nodes which were stored before the schema was declared are not checked
+
+	if err := gm.StoreNode("main", newNode("p0")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	schema := &Schema{"Person", []string{"name"}, nil, map[string]string{
+		"name": SchemaTypeString, "age": SchemaTypeInt, "born": SchemaTypeTime,
+	}, false, nil}
+
+	if err := gm.SetSchema(schema); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Changing the given schema has no effect
+
+	schema.Required = append(schema.Required, "age")
+
+	if res := fmt.Sprint(gm.Schema("Person").Required); res != "[name]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	checkError := func(err error, expected string) error {
+		if gerr, ok := err.(*util.GraphError); !ok || gerr.Type != util.ErrSchemaViolation ||
+			!errorutil.IsCategory(err, errorutil.ErrInvalid) || err.Error() != expected {
+			return fmt.Errorf("Unexpected result: %v", err)
+		}
+		return nil
+	}
+
+	if err := checkError(gm.StoreNode("main", newNode("p1")),
+		"GraphError: Schema violation (Node p1 (Person) is missing required attribute name)"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := checkError(gm.StoreNode("main", newNode("p1", "name", "Anne", "age", "42")),
+		"GraphError: Schema violation (Node p1 (Person) attribute age must be of type int not string)"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := checkError(gm.StoreNode("main", newNode("p1", "name", "Anne", "age", 41.5)),
+		"GraphError: Schema violation (Node p1 (Person) attribute age must be of type int not float64)"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Integers decoded from JSON are floats
+
+	if err := gm.StoreNode("main", newNode("p1", "name", "Anne", "age", 42.0, "other", true)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newNode("p2", "name", "Bob", "age", int64(42), "born", time.Now())); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Updates of existing nodes do not need to contain required attributes
+
+	if err := gm.UpdateNode("main", newNode("p1", "age", 43)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := checkError(gm.UpdateNode("main", newNode("p1", "born", "today")),
+		"GraphError: Schema violation (Node p1 (Person) attribute born must be of type time not string)"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := checkError(gm.UpdateNode("main", newNode("p3", "age", 43)),
+		"GraphError: Schema violation (Node p3 (Person) is missing required attribute name)"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := gm.FetchNode("main", "p1", "Person"); res.Attr("age") != 43 || res.Attr("name") != "Anne" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Transactions are checked as well
+
+	trans := NewGraphTrans(gm)
+
+	if err := checkError(trans.StoreNode("main", newNode("p3")),
+		"GraphError: Schema violation (Node p3 (Person) is missing required attribute name)"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := checkError(trans.UpdateNode("main", newNode("p3", "age", 43)),
+		"GraphError: Schema violation (Node p3 (Person) is missing required attribute name)"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := trans.UpdateNode("main", newNode("p2", "age", 44)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Closed schemas reject undeclared attributes
+
+	gm.SetSchema(&Schema{"Person", []string{"name"}, nil, map[string]string{"age": SchemaTypeFloat}, true, nil})
+
+	if err := checkError(gm.StoreNode("main", newNode("p1", "name", "Anne", "age", 42, "other", true)),
+		"GraphError: Schema violation (Node p1 (Person) attribute other is not declared)"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newNode("p1", "name", "Anne", "age", 42)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Schemas are persisted
+
+	gm2 := NewGraphManager(mgs)
+
+	if res := gm2.Schemas(); len(res) != 1 || !res[0].Closed || res[0].Types["age"] != SchemaTypeFloat {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, err := gm2.RemoveSchema("Person"); err != nil || res.Kind != "Person" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := gm2.RemoveSchema("Person"); err != nil || res != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := gm2.StoreNode("main", newNode("p4")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := NewGraphManager(mgs).Schema("Person"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestSchemaEdges(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	for _, key := range []string{"o1", "c1"} {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, map[string]string{"o1": "Order", "c1": "Customer"}[key])
+		gm.StoreNode("main", node)
+	}
+
+	newEdge := func(key string, kind string) data.Edge {
+		edge := data.NewGraphEdge()
+		edge.SetAttr(data.NodeKey, key)
+		edge.SetAttr(data.NodeKind, kind)
+		edge.SetAttr(data.EdgeEnd1Key, "o1")
+		edge.SetAttr(data.EdgeEnd1Kind, "Order")
+		edge.SetAttr(data.EdgeEnd1Role, "order")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, "c1")
+		edge.SetAttr(data.EdgeEnd2Kind, "Customer")
+		edge.SetAttr(data.EdgeEnd2Role, "customer")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+		return edge
+	}
+
+	gm.SetSchema(&Schema{"Order", nil, nil, nil, false, []string{"PlacedBy", "Contains"}})
+	gm.SetSchema(&Schema{"Customer", nil, nil, nil, false, nil})
+
+	if err := gm.StoreEdge("main", newEdge("e1", "PlacedBy")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreEdge("main", newEdge("e2", "Knows")); err == nil || err.Error() !=
+		"GraphError: Schema violation (Edge e2 (Knows) is not allowed for node kind Order - allowed edge kinds: [PlacedBy Contains])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	trans := NewGraphTrans(gm)
+
+	if err := trans.StoreEdge("main", newEdge("e2", "Knows")); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	gm.SetSchema(&Schema{"Customer", nil, nil, nil, false, []string{"Knows"}})
+
+	if err := gm.StoreEdge("main", newEdge("e3", "PlacedBy")); err == nil || err.Error() !=
+		"GraphError: Schema violation (Edge e3 (PlacedBy) is not allowed for node kind Customer - allowed edge kinds: [Knows])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	for _, test := range []struct {
+		schema   *Schema
+		expected string
+	}{
+		{&Schema{"Per son", nil, nil, nil, false, nil}, "Invalid schema Per son: Kind must be alphanumeric"},
+		{&Schema{"Person", []string{""}, nil, nil, false, nil}, "Invalid schema Person: Required attribute names must not be empty"},
+		{&Schema{"Person", nil, nil, map[string]string{"": "int"}, false, nil}, "Invalid schema Person: Attribute names must not be empty"},
+		{&Schema{"Person", nil, nil, map[string]string{"age": "integer"}, false, nil}, "Invalid schema Person: Unknown type integer of attribute age"},
+		{&Schema{"Person", nil, nil, nil, false, []string{"Pla-ced"}}, "Invalid schema Person: Edge kinds must be alphanumeric"},
+	} {
+		if err := gm.SetSchema(test.schema); err == nil || err.Error() != "GraphError: Invalid data ("+test.expected+")" {
+			t.Error("Unexpected result:", err)
+			return
+		}
+	}
+
+	for _, test := range []struct {
+		typ string
+		val interface{}
+		ok  bool
+	}{
+		{SchemaTypeString, "a", true},
+		{SchemaTypeString, 1, false},
+		{SchemaTypeInt, uint8(1), true},
+		{SchemaTypeFloat, 1, true},
+		{SchemaTypeFloat, float32(1.5), true},
+		{SchemaTypeBool, false, true},
+		{SchemaTypeBool, "false", false},
+		{SchemaTypeTime, time.Now(), true},
+		{SchemaTypeBytes, []byte("a"), true},
+		{SchemaTypeBytes, "a", false},
+		{SchemaTypeList, []interface{}{1}, true},
+		{SchemaTypeList, []string{"a"}, true},
+		{SchemaTypeList, []byte("a"), false},
+		{SchemaTypeList, "a", false},
+		{SchemaTypeMap, map[string]interface{}{}, true},
+		{SchemaTypeMap, map[string]string{}, false},
+	} {
+		if res := schemaTypeMatches(test.typ, test.val); res != test.ok {
+			t.Error("Unexpected result:", test.typ, test.val, res)
+			return
+		}
+	}
+}
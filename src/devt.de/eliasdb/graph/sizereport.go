@@ -0,0 +1,108 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/storage"
+)
+
+/*
+SizeReport contains the number of bytes which are used by the nodes, edges
+and index entries of a kind across all partitions. Sizes are estimated from
+the storage slots which are allocated for the data.
+*/
+type SizeReport struct {
+	Nodes     uint64 // Bytes used by nodes
+	NodeIndex uint64 // Bytes used by node index entries
+	Edges     uint64 // Bytes used by edges
+	EdgeIndex uint64 // Bytes used by edge index entries
+}
+
+/*
+Total returns the total number of bytes of a kind.
+*/
+func (sr *SizeReport) Total() uint64 {
+	return sr.Nodes + sr.NodeIndex + sr.Edges + sr.EdgeIndex
+}
+
+/*
+SizeReport returns the number of bytes which are used by each kind. Sizes can
+only be reported for storage managers which implement storage.SizeReporter.
+All storage slots are visited so this operation can be expensive on large
+databases.
+*/
+func (gm *Manager) SizeReport() (map[string]*SizeReport, error) {
+	var err error
+
+	// Take reader lock
+
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "SizeReport")
+	defer gm.mutex.RUnlock()
+
+	ret := make(map[string]*SizeReport)
+
+	getReport := func(kind string) *SizeReport {
+		sr, ok := ret[kind]
+		if !ok {
+			sr = &SizeReport{}
+			ret[kind] = sr
+		}
+		return sr
+	}
+
+	for _, part := range gm.Partitions() {
+
+		for _, kind := range gm.NodeKinds() {
+			sr := getReport(kind)
+
+			if sr.Nodes, err = gm.addStorageSize(sr.Nodes, part+kind+StorageSuffixNodes); err == nil {
+				sr.NodeIndex, err = gm.addStorageSize(sr.NodeIndex, part+kind+StorageSuffixNodesIndex)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, kind := range gm.EdgeKinds() {
+			sr := getReport(kind)
+
+			if sr.Edges, err = gm.addStorageSize(sr.Edges, part+kind+StorageSuffixEdges); err == nil {
+				sr.EdgeIndex, err = gm.addStorageSize(sr.EdgeIndex, part+kind+StorageSuffixEdgesIndex)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+/*
+addStorageSize adds the number of bytes which are used by a given storage
+manager to a given size.
+*/
+func (gm *Manager) addStorageSize(size uint64, smname string) (uint64, error) {
+
+	if sr, ok := gm.gs.StorageManager(smname, false).(storage.SizeReporter); ok {
+		report, err := sr.SizeReport()
+		if err != nil {
+			return size, err
+		}
+
+		size += report.SlotBytes
+	}
+
+	return size, nil
+}
@@ -0,0 +1,97 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestSizeReport(t *testing.T) {
+	storeData := func(gm *Manager) {
+		for _, key := range []string{"1", "2"} {
+			node := data.NewGraphNode()
+			node.SetAttr("key", key)
+			node.SetAttr("kind", "Person")
+			node.SetAttr("name", "Some longer name "+key)
+			gm.StoreNode("main", node)
+		}
+
+		edge := data.NewGraphEdge()
+		edge.SetAttr("key", "e1")
+		edge.SetAttr("kind", "Knows")
+		edge.SetAttr(data.EdgeEnd1Key, "1")
+		edge.SetAttr(data.EdgeEnd1Kind, "Person")
+		edge.SetAttr(data.EdgeEnd1Role, "friend")
+		edge.SetAttr(data.EdgeEnd1Cascading, false)
+		edge.SetAttr(data.EdgeEnd2Key, "2")
+		edge.SetAttr(data.EdgeEnd2Kind, "Person")
+		edge.SetAttr(data.EdgeEnd2Role, "friend")
+		edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+		if err := gm.StoreEdge("main", edge); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Sizes are not known for memory storage
+
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	storeData(gm)
+
+	report, err := gm.SizeReport()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(report) != 2 || report["Person"].Total() != 0 || report["Knows"].Total() != 0 {
+		t.Error("Unexpected size report:", report)
+		return
+	}
+
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir6, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	gm = NewGraphManager(dgs)
+
+	storeData(gm)
+
+	if report, err = gm.SizeReport(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if sr := report["Person"]; sr.Nodes == 0 || sr.NodeIndex == 0 ||
+		sr.Edges != 0 || sr.EdgeIndex != 0 {
+		t.Error("Unexpected size report:", sr)
+		return
+	}
+
+	if sr := report["Knows"]; sr.Nodes != 0 || sr.NodeIndex != 0 ||
+		sr.Edges == 0 || sr.EdgeIndex == 0 {
+		t.Error("Unexpected size report:", sr)
+		return
+	}
+
+	dgs.Close()
+}
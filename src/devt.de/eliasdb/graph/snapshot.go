@@ -0,0 +1,73 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+Snapshot returns a readonly graph manager which sees the graph as it was at
+the time of the call. Long running read operations (e.g. EQL queries,
+traversals or exports) can use the snapshot without blocking writers and
+without seeing their changes. Snapshots require a graph storage which
+implements graphstorage.SnapshotStorage. A snapshot must be released with
+ReleaseSnapshot once it is no longer needed.
+*/
+func (gm *Manager) Snapshot() (*Manager, error) {
+
+	ss, ok := gm.gs.(graphstorage.SnapshotStorage)
+	if !ok {
+		return nil, &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: "Graph storage does not support snapshots",
+		}
+	}
+
+	// Take writer lock - every change is flushed before the writer lock
+	// is released so all storage managers are in a consistent state
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "Snapshot")
+	sgs, err := ss.Snapshot()
+	gm.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return createGraphManager(sgs), nil
+}
+
+/*
+IsSnapshot checks if this graph manager works on a snapshot.
+*/
+func (gm *Manager) IsSnapshot() bool {
+	_, ok := gm.gs.(*graphstorage.StorageSnapshot)
+	return ok
+}
+
+/*
+ReleaseSnapshot releases all resources of a snapshot. The graph manager can
+no longer be used.
+*/
+func (gm *Manager) ReleaseSnapshot() error {
+
+	if !gm.IsSnapshot() {
+		return &util.GraphError{
+			Type:   util.ErrInvalidData,
+			Detail: "Graph manager does not work on a snapshot",
+		}
+	}
+
+	return gm.gs.Close()
+}
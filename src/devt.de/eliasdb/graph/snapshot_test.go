@@ -0,0 +1,174 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestSnapshot(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+
+	if !testSnapshot(t, NewGraphManager(mgs)) {
+		return
+	}
+
+	if !RunDiskStorageTests {
+		return
+	}
+
+	dgs, err := graphstorage.NewDiskGraphStorage(GraphManagerTestDBDir12, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer dgs.Close()
+
+	testSnapshot(t, NewGraphManager(dgs))
+}
+
+func testSnapshot(t *testing.T, gm *Manager) bool {
+
+	if err := gm.ReleaseSnapshot(); err == nil ||
+		err.Error() != "GraphError: Invalid data (Graph manager does not work on a snapshot)" {
+		t.Error("Unexpected result:", err)
+		return false
+	}
+
+	for i := 0; i < 10; i++ {
+		node := data.NewGraphNode()
+		node.SetAttr("key", fmt.Sprint("song", i))
+		node.SetAttr("kind", "Song")
+		node.SetAttr("name", fmt.Sprint("Song ", i))
+		gm.StoreNode("main", node)
+	}
+
+	snap, err := gm.Snapshot()
+	if err != nil {
+		t.Error(err)
+		return false
+	} else if !snap.IsSnapshot() || gm.IsSnapshot() {
+		t.Error("Unexpected result")
+		return false
+	}
+
+	// Change the graph after the snapshot was taken
+
+	node := data.NewGraphNode()
+	node.SetAttr("key", "song0")
+	node.SetAttr("kind", "Song")
+	node.SetAttr("name", "Changed song")
+	gm.StoreNode("main", node)
+
+	gm.RemoveNode("main", "song1", "Song")
+
+	node = data.NewGraphNode()
+	node.SetAttr("key", "song10")
+	node.SetAttr("kind", "Song")
+	gm.StoreNode("main", node)
+
+	node = data.NewGraphNode()
+	node.SetAttr("key", "author")
+	node.SetAttr("kind", "Author")
+	gm.StoreNode("main", node)
+
+	// The snapshot still sees the old graph
+
+	if n, err := snap.FetchNode("main", "song0", "Song"); err != nil || n.Attr("name") != "Song 0" {
+		t.Error("Unexpected result:", n, err)
+		return false
+	}
+
+	if n, err := snap.FetchNode("main", "song1", "Song"); err != nil || n == nil {
+		t.Error("Unexpected result:", n, err)
+		return false
+	}
+
+	if n, err := snap.FetchNode("main", "song10", "Song"); err != nil || n != nil {
+		t.Error("Unexpected result:", n, err)
+		return false
+	}
+
+	if n, err := snap.FetchNode("main", "author", "Author"); err != nil || n != nil {
+		t.Error("Unexpected result:", n, err)
+		return false
+	}
+
+	if res := fmt.Sprint(snap.NodeKinds(), snap.NodeCount("Song")); res != "[Song] 10" {
+		t.Error("Unexpected result:", res)
+		return false
+	}
+
+	it, err := snap.NodeKeyIterator("main", "Song")
+	if err != nil {
+		t.Error(err)
+		return false
+	}
+
+	count := 0
+	for it.HasNext() {
+		it.Next()
+		count++
+	}
+
+	if count != 10 || it.LastError != nil {
+		t.Error("Unexpected result:", count, it.LastError)
+		return false
+	}
+
+	// The graph manager sees the new graph
+
+	if n, err := gm.FetchNode("main", "song0", "Song"); err != nil || n.Attr("name") != "Changed song" {
+		t.Error("Unexpected result:", n, err)
+		return false
+	}
+
+	if res := gm.NodeCount("Song"); res != 10 {
+		t.Error("Unexpected result:", res)
+		return false
+	}
+
+	// Snapshots are readonly
+
+	if err := snap.StoreNode("main", node); err == nil ||
+		err.Error() != "GraphError: Failed write to readonly storage (Snapshots cannot be changed)" {
+		t.Error("Unexpected result:", err)
+		return false
+	}
+
+	if err := snap.ReleaseSnapshot(); err != nil {
+		t.Error(err)
+		return false
+	}
+
+	if n, err := snap.FetchNode("main", "song0", "Song"); n != nil || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return false
+	}
+
+	// New changes are no longer copied
+
+	node = data.NewGraphNode()
+	node.SetAttr("key", "song2")
+	node.SetAttr("kind", "Song")
+	node.SetAttr("name", "Changed song")
+
+	if err := gm.StoreNode("main", node); err != nil {
+		t.Error(err)
+		return false
+	}
+
+	return true
+}
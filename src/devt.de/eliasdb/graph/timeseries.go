@@ -0,0 +1,678 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+)
+
+/*
+Node and edge kinds of the time series layer
+*/
+const (
+	TimeSeriesKind       = "TimeSeries"        // Kind of series nodes
+	TimeSeriesBucketKind = "TimeSeriesBucket"  // Kind of bucket nodes
+	TimeSeriesPointKind  = "TimeSeriesPoint"   // Kind of measurement nodes
+	TimeSeriesBucketEdge = "TimeSeriesBuckets" // Kind of edges from a series to its buckets
+	TimeSeriesPointEdge  = "TimeSeriesPoints"  // Kind of edges from a bucket to its measurements
+)
+
+/*
+Attributes of the time series layer. All times are stored as unix time in
+milliseconds.
+*/
+const (
+	TimeSeriesAttrBucket      = "bucket"      // Bucket size of a series
+	TimeSeriesAttrDownsampled = "downsampled" // Time until which a series was filled by a downsampling job
+	TimeSeriesAttrSeries      = "series"      // Series of a bucket or measurement
+	TimeSeriesAttrStart       = "start"       // Start time of a bucket
+	TimeSeriesAttrTime        = "time"        // Time of a measurement
+	TimeSeriesAttrValue       = "value"       // Value of a measurement
+)
+
+/*
+Traversal specs between series, buckets and measurements
+*/
+const (
+	timeSeriesBucketSpec = "Series:" + TimeSeriesBucketEdge + ":Bucket:" + TimeSeriesBucketKind
+	timeSeriesPointSpec  = "Bucket:" + TimeSeriesPointEdge + ":Point:" + TimeSeriesPointKind
+)
+
+/*
+TimeSeriesAggregates contains all aggregation functions which can be used
+for downsampling and range queries. Aggregation functions are never called
+with an empty list of values.
+*/
+var TimeSeriesAggregates = map[string]func(values []float64) float64{
+	"avg": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"min": func(values []float64) float64 {
+		ret := values[0]
+		for _, v := range values[1:] {
+			ret = math.Min(ret, v)
+		}
+		return ret
+	},
+	"max": func(values []float64) float64 {
+		ret := values[0]
+		for _, v := range values[1:] {
+			ret = math.Max(ret, v)
+		}
+		return ret
+	},
+	"sum": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"count": func(values []float64) float64 {
+		return float64(len(values))
+	},
+}
+
+/*
+TimeSeriesPoint is a single measurement of a time series.
+*/
+type TimeSeriesPoint struct {
+	Time  time.Time // Time of the measurement
+	Value float64   // Measured value
+}
+
+/*
+TimeSeries is a convenience layer for storing measurements in the graph.
+Measurements are not connected directly to the series node. They are
+connected to bucket nodes which each cover a fixed time span. This avoids
+series nodes with a huge number of edges.
+
+	TimeSeries --TimeSeriesBuckets--> TimeSeriesBucket --TimeSeriesPoints--> TimeSeriesPoint
+
+Removing a series node removes all its buckets and measurements.
+*/
+type TimeSeries struct {
+	gm     *Manager      // Graph manager which stores the series
+	part   string        // Partition of the series
+	name   string        // Name of the series (key of the series node)
+	bucket time.Duration // Time span which is covered by a bucket
+}
+
+/*
+CreateTimeSeries creates a new time series with a given bucket size. An
+existing series with the same bucket size is returned as it is.
+*/
+func (gm *Manager) CreateTimeSeries(part string, name string, bucket time.Duration) (*TimeSeries, error) {
+
+	if bucket < time.Millisecond {
+		return nil, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprint("Bucket size of time series must be at least 1ms: ", bucket)}
+	}
+
+	ts, err := gm.TimeSeries(part, name)
+
+	if err == nil {
+		if ts == nil {
+			node := data.NewGraphNode()
+			node.SetAttr(data.NodeKey, name)
+			node.SetAttr(data.NodeKind, TimeSeriesKind)
+			node.SetAttr(TimeSeriesAttrBucket, timeSeriesMillis(bucket))
+
+			if err = gm.StoreNode(part, node); err == nil {
+				ts = &TimeSeries{gm, part, name, bucket}
+			}
+
+		} else if ts.bucket != bucket {
+			ts, err = nil, &util.GraphError{Type: util.ErrInvalidData,
+				Detail: fmt.Sprintf("Time series %v exists with a different bucket size: %v", name, ts.bucket)}
+		}
+	}
+
+	return ts, err
+}
+
+/*
+TimeSeries returns an existing time series. Returns nil if the series does
+not exist.
+*/
+func (gm *Manager) TimeSeries(part string, name string) (*TimeSeries, error) {
+
+	node, err := gm.FetchNode(part, name, TimeSeriesKind)
+	if err != nil || node == nil {
+		return nil, err
+	}
+
+	bucket := time.Duration(timeSeriesInt(node.Attr(TimeSeriesAttrBucket))) * time.Millisecond
+
+	if bucket < time.Millisecond {
+		return nil, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprint("Time series has an invalid bucket size: ", name)}
+	}
+
+	return &TimeSeries{gm, part, name, bucket}, nil
+}
+
+/*
+Name returns the name of the time series.
+*/
+func (ts *TimeSeries) Name() string {
+	return ts.name
+}
+
+/*
+Bucket returns the time span which is covered by a bucket of the time series.
+*/
+func (ts *TimeSeries) Bucket() time.Duration {
+	return ts.bucket
+}
+
+/*
+Append adds measurements to the time series. All measurements are written in
+a single transaction. An existing measurement with the same time is
+overwritten.
+*/
+func (ts *TimeSeries) Append(points ...TimeSeriesPoint) error {
+	trans := NewGraphTrans(ts.gm)
+	buckets := make(map[int64]bool)
+
+	for _, p := range points {
+		t := timeSeriesMillis(time.Duration(p.Time.UnixNano()))
+		start := ts.bucketStart(t)
+
+		bucketKey := ts.bucketKey(start)
+
+		if _, ok := buckets[start]; !ok {
+
+			bucket, err := ts.gm.FetchNodePart(ts.part, bucketKey, TimeSeriesBucketKind,
+				[]string{data.NodeKey})
+			if err != nil {
+				return err
+			}
+
+			buckets[start] = true
+
+			if bucket == nil {
+				bucket = data.NewGraphNode()
+				bucket.SetAttr(data.NodeKey, bucketKey)
+				bucket.SetAttr(data.NodeKind, TimeSeriesBucketKind)
+				bucket.SetAttr(TimeSeriesAttrSeries, ts.name)
+				bucket.SetAttr(TimeSeriesAttrStart, start)
+
+				if err := trans.StoreNode(ts.part, bucket); err != nil {
+					return err
+				}
+
+				if err := trans.StoreEdge(ts.part, newTimeSeriesEdge(bucketKey, TimeSeriesBucketEdge,
+					ts.name, TimeSeriesKind, "Series", bucketKey, TimeSeriesBucketKind, "Bucket")); err != nil {
+					return err
+				}
+			}
+		}
+
+		pointKey := fmt.Sprintf("%v#%v", ts.name, t)
+
+		point := data.NewGraphNode()
+		point.SetAttr(data.NodeKey, pointKey)
+		point.SetAttr(data.NodeKind, TimeSeriesPointKind)
+		point.SetAttr(TimeSeriesAttrSeries, ts.name)
+		point.SetAttr(TimeSeriesAttrTime, t)
+		point.SetAttr(TimeSeriesAttrValue, p.Value)
+
+		if err := trans.StoreNode(ts.part, point); err != nil {
+			return err
+		}
+
+		if err := trans.StoreEdge(ts.part, newTimeSeriesEdge(pointKey, TimeSeriesPointEdge,
+			bucketKey, TimeSeriesBucketKind, "Bucket", pointKey, TimeSeriesPointKind, "Point")); err != nil {
+			return err
+		}
+	}
+
+	return trans.Commit()
+}
+
+/*
+Range returns all measurements in the time range [from, to) ordered by time.
+*/
+func (ts *TimeSeries) Range(from time.Time, to time.Time) ([]TimeSeriesPoint, error) {
+	var ret []TimeSeriesPoint
+
+	fromMillis := timeSeriesMillis(time.Duration(from.UnixNano()))
+	toMillis := timeSeriesMillis(time.Duration(to.UnixNano()))
+
+	buckets, err := ts.buckets()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, start := range buckets {
+
+		if start+timeSeriesMillis(ts.bucket) <= fromMillis || start >= toMillis {
+			continue
+		}
+
+		points, _, err := ts.gm.Traverse(ts.part, ts.bucketKey(start), TimeSeriesBucketKind,
+			timeSeriesPointSpec, true)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range points {
+			if t := timeSeriesInt(p.Attr(TimeSeriesAttrTime)); t >= fromMillis && t < toMillis {
+				ret = append(ret, TimeSeriesPoint{timeSeriesTime(t), timeSeriesFloat(p.Attr(TimeSeriesAttrValue))})
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Time.Before(ret[j].Time)
+	})
+
+	return ret, nil
+}
+
+/*
+Aggregate aggregates all measurements in the time range [from, to) with a
+function from TimeSeriesAggregates. Returns nil if there are no measurements
+in the range (except for count which returns 0).
+*/
+func (ts *TimeSeries) Aggregate(fn string, from time.Time, to time.Time) (interface{}, error) {
+
+	agg, ok := TimeSeriesAggregates[fn]
+	if !ok {
+		return nil, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprint("Unknown aggregation function: ", fn)}
+	}
+
+	points, err := ts.Range(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(points) == 0 {
+		if fn == "count" {
+			return float64(0), nil
+		}
+		return nil, nil
+	}
+
+	values := make([]float64, len(points))
+	for i, p := range points {
+		values[i] = p.Value
+	}
+
+	return agg(values), nil
+}
+
+/*
+RemoveBefore removes all measurements before a given time. Buckets which no
+longer hold any measurements are removed as well. Returns the number of
+removed measurements.
+*/
+func (ts *TimeSeries) RemoveBefore(before time.Time) (int, error) {
+	var removed int
+
+	beforeMillis := timeSeriesMillis(time.Duration(before.UnixNano()))
+
+	buckets, err := ts.buckets()
+	if err != nil {
+		return 0, err
+	}
+
+	trans := NewGraphTrans(ts.gm)
+
+	for _, start := range buckets {
+
+		if start >= beforeMillis {
+			break
+		}
+
+		bucketKey := ts.bucketKey(start)
+
+		points, _, err := ts.gm.Traverse(ts.part, bucketKey, TimeSeriesBucketKind,
+			timeSeriesPointSpec, true)
+		if err != nil {
+			return 0, err
+		}
+
+		remaining := len(points)
+
+		for _, p := range points {
+			if timeSeriesInt(p.Attr(TimeSeriesAttrTime)) < beforeMillis {
+				if err := trans.RemoveNode(ts.part, p.Key(), p.Kind()); err != nil {
+					return 0, err
+				}
+
+				removed++
+				remaining--
+			}
+		}
+
+		if remaining == 0 {
+			if err := trans.RemoveNode(ts.part, bucketKey, TimeSeriesBucketKind); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return removed, trans.Commit()
+}
+
+/*
+buckets returns the start times of all buckets of the series in ascending order.
+*/
+func (ts *TimeSeries) buckets() ([]int64, error) {
+
+	nodes, _, err := ts.gm.Traverse(ts.part, ts.name, TimeSeriesKind, timeSeriesBucketSpec, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]int64, 0, len(nodes))
+	prefix := ts.name + "#"
+
+	for _, n := range nodes {
+		if start, err := strconv.ParseInt(strings.TrimPrefix(n.Key(), prefix), 10, 64); err == nil {
+			ret = append(ret, start)
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+
+	return ret, nil
+}
+
+/*
+bucketStart returns the start time of the bucket which contains a given time.
+*/
+func (ts *TimeSeries) bucketStart(t int64) int64 {
+	return timeSeriesMillis(time.Duration(timeSeriesTruncate(timeSeriesTime(t), ts.bucket).UnixNano()))
+}
+
+/*
+bucketKey returns the node key of a bucket.
+*/
+func (ts *TimeSeries) bucketKey(start int64) string {
+	return fmt.Sprintf("%v#%v", ts.name, start)
+}
+
+// Downsampling
+// ============
+
+/*
+DownsampleJob aggregates the measurements of a source series into a target
+series with a lower resolution.
+*/
+type DownsampleJob struct {
+	Source     string        // Name of the source series
+	Target     string        // Name of the target series
+	Resolution time.Duration // Time span which is aggregated into a single measurement
+	Func       string        // Aggregation function (see TimeSeriesAggregates)
+	Retention  time.Duration // Aggregated measurements of the source which are older than this are removed (0 keeps all measurements)
+}
+
+/*
+RunDownsampleJob runs a downsampling job. Only time spans which are complete
+at the given time are aggregated. The target series remembers until which
+time the source has been aggregated so the job can be run repeatedly. The
+target series is created if it does not exist. Returns the number of
+measurements which were written to the target series.
+*/
+func (gm *Manager) RunDownsampleJob(part string, job *DownsampleJob, now time.Time) (int, error) {
+
+	agg, ok := TimeSeriesAggregates[job.Func]
+	if !ok {
+		return 0, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprint("Unknown aggregation function: ", job.Func)}
+	} else if job.Resolution < time.Millisecond {
+		return 0, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprint("Resolution of downsampling job must be at least 1ms: ", job.Resolution)}
+	}
+
+	src, err := gm.TimeSeries(part, job.Source)
+	if err != nil {
+		return 0, err
+	} else if src == nil {
+		return 0, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprint("Unknown time series: ", job.Source)}
+	}
+
+	bucket := src.bucket
+	if job.Resolution > bucket {
+		bucket = job.Resolution
+	}
+
+	target, err := gm.CreateTimeSeries(part, job.Target, bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	targetNode, err := gm.FetchNode(part, job.Target, TimeSeriesKind)
+	if err != nil {
+		return 0, err
+	}
+
+	// Determine the time span which needs to be aggregated
+
+	var from time.Time
+
+	if progress := targetNode.Attr(TimeSeriesAttrDownsampled); progress != nil {
+		from = timeSeriesTime(timeSeriesInt(progress))
+
+	} else {
+		buckets, err := src.buckets()
+		if err != nil || len(buckets) == 0 {
+			return 0, err
+		}
+
+		from = timeSeriesTime(buckets[0])
+	}
+
+	from = timeSeriesTruncate(from, job.Resolution)
+	until := timeSeriesTruncate(now, job.Resolution)
+
+	var written []TimeSeriesPoint
+
+	if until.After(from) {
+
+		points, err := src.Range(from, until)
+		if err != nil {
+			return 0, err
+		}
+
+		for len(points) > 0 {
+			start := timeSeriesTruncate(points[0].Time, job.Resolution)
+			end := start.Add(job.Resolution)
+
+			var values []float64
+
+			for len(points) > 0 && points[0].Time.Before(end) {
+				values = append(values, points[0].Value)
+				points = points[1:]
+			}
+
+			written = append(written, TimeSeriesPoint{start, agg(values)})
+		}
+
+		if err := target.Append(written...); err != nil {
+			return 0, err
+		}
+
+		// Remember the progress of the job
+
+		progressNode := data.NewGraphNode()
+		progressNode.SetAttr(data.NodeKey, job.Target)
+		progressNode.SetAttr(data.NodeKind, TimeSeriesKind)
+		progressNode.SetAttr(TimeSeriesAttrDownsampled, timeSeriesMillis(time.Duration(until.UnixNano())))
+
+		if err := gm.UpdateNode(part, progressNode); err != nil {
+			return 0, err
+		}
+
+	} else {
+		until = from
+	}
+
+	// Remove aggregated measurements which are older than the retention time
+
+	if job.Retention > 0 {
+		before := now.Add(-job.Retention)
+
+		if before.After(until) {
+			before = until
+		}
+
+		if _, err := src.RemoveBefore(before); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(written), nil
+}
+
+// Helper functions
+// ================
+
+/*
+ParseTimeSeriesTime parses a time value for time series queries. Supported
+are unix times in milliseconds, RFC3339 timestamps, "now" and times relative
+to now (e.g. now-1h).
+*/
+func ParseTimeSeriesTime(s string, now time.Time) (time.Time, error) {
+
+	if millis, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return timeSeriesTime(millis), nil
+	}
+
+	if s == "now" {
+		return now, nil
+
+	} else if strings.HasPrefix(s, "now-") || strings.HasPrefix(s, "now+") {
+		d, err := time.ParseDuration(s[3:])
+		if err != nil {
+			return now, &util.GraphError{Type: util.ErrInvalidData,
+				Detail: fmt.Sprint("Invalid relative time: ", s)}
+		}
+
+		return now.Add(d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return now, &util.GraphError{Type: util.ErrInvalidData,
+			Detail: fmt.Sprint("Invalid time: ", s)}
+	}
+
+	return t, nil
+}
+
+/*
+newTimeSeriesEdge creates a new edge of the time series layer. Delete
+operations cascade from the first to the second end.
+*/
+func newTimeSeriesEdge(key string, kind string, end1Key string, end1Kind string,
+	end1Role string, end2Key string, end2Kind string, end2Role string) data.Edge {
+
+	edge := data.NewGraphEdge()
+
+	edge.SetAttr(data.NodeKey, key)
+	edge.SetAttr(data.NodeKind, kind)
+	edge.SetAttr(data.EdgeEnd1Key, end1Key)
+	edge.SetAttr(data.EdgeEnd1Kind, end1Kind)
+	edge.SetAttr(data.EdgeEnd1Role, end1Role)
+	edge.SetAttr(data.EdgeEnd1Cascading, true)
+	edge.SetAttr(data.EdgeEnd2Key, end2Key)
+	edge.SetAttr(data.EdgeEnd2Kind, end2Kind)
+	edge.SetAttr(data.EdgeEnd2Role, end2Role)
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	return edge
+}
+
+/*
+timeSeriesMillis converts a duration into milliseconds.
+*/
+func timeSeriesMillis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+/*
+timeSeriesTruncate rounds a time down to a multiple of a duration since the
+unix epoch.
+*/
+func timeSeriesTruncate(t time.Time, d time.Duration) time.Time {
+	size := timeSeriesMillis(d)
+	millis := timeSeriesMillis(time.Duration(t.UnixNano()))
+	start := millis / size * size
+
+	if millis < 0 && millis%size != 0 {
+		start -= size
+	}
+
+	return timeSeriesTime(start)
+}
+
+/*
+timeSeriesTime converts unix time in milliseconds into a time value.
+*/
+func timeSeriesTime(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
+
+/*
+timeSeriesInt converts an attribute value into an integer.
+*/
+func timeSeriesInt(v interface{}) int64 {
+	switch val := v.(type) {
+	case int64:
+		return val
+	case int:
+		return int64(val)
+	case float64:
+		return int64(val)
+	case string:
+		i, _ := strconv.ParseInt(val, 10, 64)
+		return i
+	}
+	return 0
+}
+
+/*
+timeSeriesFloat converts an attribute value into a float.
+*/
+func timeSeriesFloat(v interface{}) float64 {
+	switch val := v.(type) {
+	case float64:
+		return val
+	case int64:
+		return float64(val)
+	case int:
+		return float64(val)
+	case string:
+		f, _ := strconv.ParseFloat(val, 64)
+		return f
+	}
+	return 0
+}
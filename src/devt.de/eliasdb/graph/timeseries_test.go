@@ -0,0 +1,266 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestTimeSeries(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	if _, err := gm.CreateTimeSeries("main", "cpu", time.Microsecond); err == nil {
+		t.Error("Bucket sizes below 1ms should be rejected")
+		return
+	}
+
+	ts, err := gm.CreateTimeSeries("main", "cpu", time.Minute)
+	if err != nil || ts.Name() != "cpu" || ts.Bucket() != time.Minute {
+		t.Error("Unexpected result:", ts, err)
+		return
+	}
+
+	if _, err := gm.CreateTimeSeries("main", "cpu", time.Hour); err == nil ||
+		err.Error() != "GraphError: Invalid data (Time series cpu exists with a different bucket size: 1m0s)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if ts2, err := gm.CreateTimeSeries("main", "cpu", time.Minute); err != nil || ts2.Name() != "cpu" {
+		t.Error("Unexpected result:", ts2, err)
+		return
+	}
+
+	if ts2, err := gm.TimeSeries("main", "mem"); ts2 != nil || err != nil {
+		t.Error("Unexpected result:", ts2, err)
+		return
+	}
+
+	// Append measurements every 20 seconds over 5 minutes
+
+	start := time.Unix(1500000000, 0)
+
+	var points []TimeSeriesPoint
+
+	for i := 0; i < 15; i++ {
+		points = append(points, TimeSeriesPoint{start.Add(time.Duration(i) * 20 * time.Second), float64(i)})
+	}
+
+	if err := ts.Append(points[5:]...); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := ts.Append(points[:5]...); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Measurements are grouped into buckets
+
+	if c := gm.NodeCount(TimeSeriesPointKind); c != 15 {
+		t.Error("Unexpected count:", c)
+		return
+	}
+
+	if c := gm.NodeCount(TimeSeriesBucketKind); c != 5 {
+		t.Error("Unexpected count:", c)
+		return
+	}
+
+	if nodes, _, _ := gm.TraverseMulti("main", "cpu", TimeSeriesKind, ":::", false); len(nodes) != 5 {
+		t.Error("Series should only be connected to its buckets:", len(nodes))
+		return
+	}
+
+	res, err := ts.Range(start.Add(time.Minute), start.Add(2*time.Minute))
+	if err != nil || fmt.Sprint(len(res), res[0].Value, res[2].Value) != "3 3 5" ||
+		!res[0].Time.Equal(start.Add(time.Minute)) {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Overwriting a measurement does not add a new one
+
+	if err := ts.Append(TimeSeriesPoint{start, 100}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if c := gm.NodeCount(TimeSeriesPointKind); c != 15 {
+		t.Error("Unexpected count:", c)
+		return
+	}
+
+	for fn, expected := range map[string]interface{}{
+		"avg": float64(22), "min": float64(1), "max": float64(100), "sum": float64(110), "count": float64(5),
+	} {
+		if res, err := ts.Aggregate(fn, start, start.Add(100*time.Second)); err != nil || res != expected {
+			t.Error("Unexpected result:", fn, res, err)
+			return
+		}
+	}
+
+	if res, err := ts.Aggregate("avg", start.Add(time.Hour), start.Add(2*time.Hour)); err != nil || res != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := ts.Aggregate("count", start.Add(time.Hour), start.Add(2*time.Hour)); err != nil || res != float64(0) {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := ts.Aggregate("median", start, start.Add(time.Hour)); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown aggregation function: median)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Remove old measurements
+
+	if removed, err := ts.RemoveBefore(start.Add(70 * time.Second)); err != nil || removed != 4 {
+		t.Error("Unexpected result:", removed, err)
+		return
+	}
+
+	if c := gm.NodeCount(TimeSeriesPointKind); c != 11 {
+		t.Error("Unexpected count:", c)
+		return
+	}
+
+	if c := gm.NodeCount(TimeSeriesBucketKind); c != 4 {
+		t.Error("Unexpected count:", c)
+		return
+	}
+
+	// Removing the series removes all buckets and measurements
+
+	if _, err := gm.RemoveNode("main", "cpu", TimeSeriesKind); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if c := gm.NodeCount(TimeSeriesPointKind) + gm.NodeCount(TimeSeriesBucketKind); c != 0 {
+		t.Error("Unexpected count:", c)
+		return
+	}
+}
+
+func TestTimeSeriesDownsampling(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	job := &DownsampleJob{"raw", "hourly", time.Hour, "avg", 0}
+
+	if _, err := gm.RunDownsampleJob("main", job, time.Now()); err == nil ||
+		err.Error() != "GraphError: Invalid data (Unknown time series: raw)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ts, _ := gm.CreateTimeSeries("main", "raw", time.Minute)
+
+	start := time.Unix(1500000000, 0).Truncate(time.Hour)
+
+	// Nothing to do for an empty series
+
+	if n, err := gm.RunDownsampleJob("main", job, start); err != nil || n != 0 {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	var points []TimeSeriesPoint
+
+	for i := 0; i < 3*60; i++ {
+		points = append(points, TimeSeriesPoint{start.Add(time.Duration(i) * time.Minute), float64(i / 60)})
+	}
+
+	ts.Append(points...)
+
+	// Only complete hours are aggregated
+
+	if n, err := gm.RunDownsampleJob("main", job, start.Add(150*time.Minute)); err != nil || n != 2 {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	hourly, _ := gm.TimeSeries("main", "hourly")
+
+	res, _ := hourly.Range(start, start.Add(10*time.Hour))
+	if fmt.Sprint(res[0].Value, res[1].Value) != "0 1" || len(res) != 2 || !res[1].Time.Equal(start.Add(time.Hour)) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// A second run continues where the last run stopped and removes old
+	// measurements
+
+	job.Retention = 90 * time.Minute
+	job.Func = "max"
+
+	if n, err := gm.RunDownsampleJob("main", job, start.Add(190*time.Minute)); err != nil || n != 1 {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	res, _ = hourly.Range(start, start.Add(10*time.Hour))
+	if fmt.Sprint(res[0].Value, res[1].Value, res[2].Value) != "0 1 2" || len(res) != 3 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if c := gm.NodeCount(TimeSeriesPointKind); c != 3+80 {
+		t.Error("Unexpected count:", c)
+		return
+	}
+
+	job.Func = "foo"
+
+	if _, err := gm.RunDownsampleJob("main", job, time.Now()); err == nil {
+		t.Error("Unknown aggregation functions should be rejected")
+		return
+	}
+}
+
+func TestParseTimeSeriesTime(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	for input, expected := range map[string]time.Time{
+		"1500000000000":        now,
+		"now":                  now,
+		"now-1h":               now.Add(-time.Hour),
+		"now+90s":              now.Add(90 * time.Second),
+		"2017-07-14T02:40:00Z": now,
+	} {
+		if res, err := ParseTimeSeriesTime(input, now); err != nil || !res.Equal(expected) {
+			t.Error("Unexpected result:", input, res, err)
+			return
+		}
+	}
+
+	if _, err := ParseTimeSeriesTime("now-1x", now); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid relative time: now-1x)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := ParseTimeSeriesTime("yesterday", now); err == nil ||
+		err.Error() != "GraphError: Invalid data (Invalid time: yesterday)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
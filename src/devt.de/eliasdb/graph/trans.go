@@ -12,10 +12,12 @@ package graph
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"devt.de/eliasdb/graph/data"
 	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/lockprof"
 )
 
 /*
@@ -29,6 +31,8 @@ type Trans struct {
 	removeNodes map[string]data.Node // Nodes which should be removed
 	storeEdges  map[string]data.Edge // Edges which should be stored
 	removeEdges map[string]data.Edge // Edges which should be removed
+
+	log TransLog // Changes which were made by the last commit
 }
 
 /*
@@ -36,7 +40,7 @@ NewGraphTrans creates a new graph transaction.
 */
 func NewGraphTrans(gm *Manager) *Trans {
 	return &Trans{gm, false, make(map[string]data.Node), make(map[string]data.Node),
-		make(map[string]data.Edge), make(map[string]data.Edge)}
+		make(map[string]data.Edge), make(map[string]data.Edge), nil}
 }
 
 /*
@@ -50,17 +54,22 @@ func (gt *Trans) IsEmpty() bool {
 /*
 Commit writes the transaction to the graph database. An automatic rollback is done if
 any non-fatal error occurs. Failed transactions cannot be committed again.
-Serious write errors which may corrupt the database will cause a panic.
+Serious write errors which may corrupt the database will cause a panic. The
+changes of a successful commit are available through Log(). If the changes
+cannot be written to the journal (see SetJournal) an error is returned after
+the changes were committed.
 */
 func (gt *Trans) Commit() error {
 
 	// Take writer lock if we are not in a subtransaction
 
 	if !gt.subtrans {
-		gt.gm.mutex.Lock()
+		lockprof.Lock(gt.gm.mutex, lockprof.LockGraph, "Commit")
 		defer gt.gm.mutex.Unlock()
 	}
 
+	gt.log = nil
+
 	// Return if there is nothing to do
 
 	if gt.IsEmpty() {
@@ -99,6 +108,8 @@ func (gt *Trans) Commit() error {
 
 		gt.storeEdges = make(map[string]data.Edge)
 		gt.removeEdges = make(map[string]data.Edge)
+
+		gt.log = nil
 	}
 
 	// Write nodes and edges until everything has been written
@@ -123,44 +134,75 @@ func (gt *Trans) Commit() error {
 		}
 	}
 
-	// Flush changes - panic instead of error reporting since the database
+	// Flush changes - errors are reported as corruption since the database
 	// may be inconsistent
 
-	panicIfError := func(err error) {
-		if err != nil {
-			panic("Fatal GraphError:" + err.Error())
-		}
+	flushError := func(err error) error {
+		return &util.GraphError{Type: util.ErrInconsistent, Detail: err.Error()}
 	}
 
-	panicIfError(gt.gm.gs.FlushMain())
+	if err := gt.gm.gs.FlushMain(); err != nil {
+		return flushError(err)
+	}
 
 	for kkey := range nodePartsAndKinds {
 
 		partAndKind := strings.Split(kkey, "#")
 
-		panicIfError(gt.gm.flushNodeIndex(partAndKind[0], partAndKind[1]))
-		panicIfError(gt.gm.flushNodeStorage(partAndKind[0], partAndKind[1]))
+		if err := gt.gm.flushNodeIndex(partAndKind[0], partAndKind[1]); err != nil {
+			return flushError(err)
+		}
+
+		if err := gt.gm.flushNodeStorage(partAndKind[0], partAndKind[1]); err != nil {
+			return flushError(err)
+		}
 	}
 
 	for kkey := range edgePartsAndKinds {
 
 		partAndKind := strings.Split(kkey, "#")
 
-		panicIfError(gt.gm.flushEdgeIndex(partAndKind[0], partAndKind[1]))
-		panicIfError(gt.gm.flushEdgeStorage(partAndKind[0], partAndKind[1]))
+		if err := gt.gm.flushEdgeIndex(partAndKind[0], partAndKind[1]); err != nil {
+			return flushError(err)
+		}
+
+		if err := gt.gm.flushEdgeStorage(partAndKind[0], partAndKind[1]); err != nil {
+			return flushError(err)
+		}
+	}
+
+	// Record the changes in the journal - changes of subtransactions are
+	// recorded together with the operation which caused them
+
+	if !gt.subtrans {
+		return gt.gm.journalChanges(gt.log)
 	}
 
 	return nil
 }
 
 /*
-commitNodes tries to commit all transaction nodes.
+commitNodes tries to commit all transaction nodes. Nodes are written in the
+order of their transaction keys - the nodes of a partition and kind are
+written one after another and the order of the writes (and events) does not
+depend on map iteration. Index entries are written together with each node.
 */
 func (gt *Trans) commitNodes(nodePartsAndKinds map[string]string, edgePartsAndKinds map[string]string) error {
 
+	// Check unique attribute values so nothing is written if they are violated
+
+	if err := gt.checkUniqueValues(); err != nil {
+		return err
+	}
+
 	// First insert nodes
 
-	for tkey, node := range gt.storeNodes {
+	for _, tkey := range sortedNodeKeys(gt.storeNodes) {
+
+		node, ok := gt.storeNodes[tkey]
+		if !ok {
+			continue
+		}
 
 		// Get partition and kind
 
@@ -183,21 +225,27 @@ func (gt *Trans) commitNodes(nodePartsAndKinds map[string]string, edgePartsAndKi
 
 		// Write the node to the datastore
 
+		gt.gm.invalidateCachedNode(part, node.Key(), node.Kind())
+
 		oldnode, err := gt.gm.writeNode(node, false, attht, valht, nodeAttributeFilter)
 
 		if err != nil {
 			return err
+		} else if err := gt.gm.updateUniqueValues(node.Key(), node.Kind(), node, oldnode, false, valht); err != nil {
+			return err
 		}
 
 		// Increase node count if the node was inserted and write the changes
 		// to the index.
 
 		if oldnode == nil {
+			gt.gm.keyIndex.add(part, node.Kind(), node.Key())
+
 			currentCount := gt.gm.NodeCount(node.Kind())
 			gt.gm.writeNodeCount(node.Kind(), currentCount+1, false)
 
 			if iht != nil {
-				err := util.NewIndexManager(iht).Index(node.Key(), node.IndexMap())
+				err := gt.gm.nodeIndexManager(iht, node.Kind()).Index(node.Key(), node.IndexMap())
 				if err != nil {
 
 					// The node was written at this point and the model is
@@ -209,7 +257,7 @@ func (gt *Trans) commitNodes(nodePartsAndKinds map[string]string, edgePartsAndKi
 
 		} else if iht != nil {
 
-			err := util.NewIndexManager(iht).Reindex(node.Key(), node.IndexMap(),
+			err := gt.gm.nodeIndexManager(iht, node.Kind()).Reindex(node.Key(), node.IndexMap(),
 				oldnode.IndexMap())
 
 			if err != nil {
@@ -221,6 +269,10 @@ func (gt *Trans) commitNodes(nodePartsAndKinds map[string]string, edgePartsAndKi
 			}
 		}
 
+		if err := gt.gm.updateAttrIndexes(part, node.Key(), node.Kind(), node, oldnode, false); err != nil {
+			return err
+		}
+
 		// Execute rules
 
 		var event int
@@ -230,6 +282,8 @@ func (gt *Trans) commitNodes(nodePartsAndKinds map[string]string, edgePartsAndKi
 			event = EventNodeUpdated
 		}
 
+		gt.logNode(event, part, node, oldnode)
+
 		if err := gt.gm.gr.graphEvent(gt, event, part, node, oldnode); err != nil {
 			return err
 		}
@@ -239,7 +293,12 @@ func (gt *Trans) commitNodes(nodePartsAndKinds map[string]string, edgePartsAndKi
 
 	// Then remove nodes
 
-	for tkey, node := range gt.removeNodes {
+	for _, tkey := range sortedNodeKeys(gt.removeNodes) {
+
+		node, ok := gt.removeNodes[tkey]
+		if !ok {
+			continue
+		}
 
 		// Get partition and kind
 
@@ -262,23 +321,33 @@ func (gt *Trans) commitNodes(nodePartsAndKinds map[string]string, edgePartsAndKi
 
 		// Delete the node from the datastore
 
+		gt.gm.invalidateCachedNode(part, node.Key(), node.Kind())
+
 		oldnode, err := gt.gm.deleteNode(node.Key(), node.Kind(), attTree, valTree)
 		if err != nil {
 			return err
+		} else if err := gt.gm.updateUniqueValues(node.Key(), node.Kind(), nil, oldnode, false, valTree); err != nil {
+			return err
 		}
 
 		// Update the index
 
 		if oldnode != nil {
 
+			gt.gm.keyIndex.remove(part, node.Kind(), node.Key())
+
 			if iht != nil {
-				err := util.NewIndexManager(iht).Deindex(node.Key(), oldnode.IndexMap())
+				err := gt.gm.nodeIndexManager(iht, node.Kind()).Deindex(node.Key(), oldnode.IndexMap())
 
 				if err != nil {
 					return err
 				}
 			}
 
+			if err := gt.gm.updateAttrIndexes(part, node.Key(), node.Kind(), nil, oldnode, false); err != nil {
+				return err
+			}
+
 			// Decrease the node count
 
 			currentCount := gt.gm.NodeCount(node.Kind())
@@ -286,6 +355,8 @@ func (gt *Trans) commitNodes(nodePartsAndKinds map[string]string, edgePartsAndKi
 
 			// Execute rules
 
+			gt.logNode(EventNodeDeleted, part, nil, oldnode)
+
 			if err := gt.gm.gr.graphEvent(gt, EventNodeDeleted, part, oldnode); err != nil {
 				return err
 			}
@@ -298,13 +369,19 @@ func (gt *Trans) commitNodes(nodePartsAndKinds map[string]string, edgePartsAndKi
 }
 
 /*
-commitEdges tries to commit all transaction edges.
+commitEdges tries to commit all transaction edges. Edges are written in the
+order of their transaction keys (see commitNodes).
 */
 func (gt *Trans) commitEdges(nodePartsAndKinds map[string]string, edgePartsAndKinds map[string]string) error {
 
 	// First insert edges
 
-	for tkey, edge := range gt.storeEdges {
+	for _, tkey := range sortedEdgeKeys(gt.storeEdges) {
+
+		edge, ok := gt.storeEdges[tkey]
+		if !ok {
+			continue
+		}
 
 		// Get partition and kind
 
@@ -415,6 +492,8 @@ func (gt *Trans) commitEdges(nodePartsAndKinds map[string]string, edgePartsAndKi
 			event = EventEdgeUpdated
 		}
 
+		gt.logEdge(event, part, edge, oldedge)
+
 		if err := gt.gm.gr.graphEvent(gt, event, part, edge, oldedge); err != nil {
 			return err
 		}
@@ -424,7 +503,12 @@ func (gt *Trans) commitEdges(nodePartsAndKinds map[string]string, edgePartsAndKi
 
 	// Then remove edges
 
-	for tkey, edge := range gt.removeEdges {
+	for _, tkey := range sortedEdgeKeys(gt.removeEdges) {
+
+		edge, ok := gt.removeEdges[tkey]
+		if !ok {
+			continue
+		}
 
 		// Get partition and kind
 
@@ -491,6 +575,8 @@ func (gt *Trans) commitEdges(nodePartsAndKinds map[string]string, edgePartsAndKi
 
 			// Execute rules
 
+			gt.logEdge(EventEdgeDeleted, part, nil, oldedge)
+
 			if err := gt.gm.gr.graphEvent(gt, EventEdgeDeleted, part, oldedge); err != nil {
 				return err
 			}
@@ -507,10 +593,12 @@ StoreNode stores a single node in a partition of the graph. This function will
 overwrites any existing node.
 */
 func (gt *Trans) StoreNode(part string, node data.Node) error {
-	if err := gt.gm.checkPartitionName(part); err != nil {
+	if err := gt.gm.checkWritablePartition(part); err != nil {
 		return err
 	} else if err := gt.gm.checkNode(node); err != nil {
 		return err
+	} else if err := gt.gm.schemas.checkNode(node, false); err != nil {
+		return err
 	}
 
 	key := gt.createKey(part, node.Key(), node.Kind())
@@ -529,7 +617,7 @@ UpdateNode updates a single node in a partition of the graph. This function will
 only update the given values of the node.
 */
 func (gt *Trans) UpdateNode(part string, node data.Node) error {
-	if err := gt.gm.checkPartitionName(part); err != nil {
+	if err := gt.gm.checkWritablePartition(part); err != nil {
 		return err
 	} else if err := gt.gm.checkNode(node); err != nil {
 		return err
@@ -553,6 +641,12 @@ func (gt *Trans) UpdateNode(part string, node data.Node) error {
 		}
 	}
 
+	// The schema of the kind applies to the updated node
+
+	if err := gt.gm.schemas.checkNode(node, false); err != nil {
+		return err
+	}
+
 	gt.storeNodes[key] = node
 
 	return nil
@@ -562,7 +656,7 @@ func (gt *Trans) UpdateNode(part string, node data.Node) error {
 RemoveNode removes a single node from a partition of the graph.
 */
 func (gt *Trans) RemoveNode(part string, nkey string, nkind string) error {
-	if err := gt.gm.checkPartitionName(part); err != nil {
+	if err := gt.gm.checkWritablePartition(part); err != nil {
 		return err
 	}
 
@@ -586,7 +680,7 @@ StoreEdge stores a single edge in a partition of the graph. This function will
 overwrites any existing edge.
 */
 func (gt *Trans) StoreEdge(part string, edge data.Edge) error {
-	if err := gt.gm.checkPartitionName(part); err != nil {
+	if err := gt.gm.checkWritablePartition(part); err != nil {
 		return err
 	} else if err := gt.gm.checkEdge(edge); err != nil {
 		return err
@@ -607,7 +701,7 @@ func (gt *Trans) StoreEdge(part string, edge data.Edge) error {
 RemoveEdge removes a single edge from a partition of the graph.
 */
 func (gt *Trans) RemoveEdge(part string, ekey string, ekind string) error {
-	if err := gt.gm.checkPartitionName(part); err != nil {
+	if err := gt.gm.checkWritablePartition(part); err != nil {
 		return err
 	}
 
@@ -626,6 +720,36 @@ func (gt *Trans) RemoveEdge(part string, ekey string, ekind string) error {
 	return nil
 }
 
+/*
+sortedNodeKeys returns the transaction keys of a given node map in ascending order.
+*/
+func sortedNodeKeys(nodes map[string]data.Node) []string {
+	keys := make([]string, 0, len(nodes))
+
+	for key := range nodes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+/*
+sortedEdgeKeys returns the transaction keys of a given edge map in ascending order.
+*/
+func sortedEdgeKeys(edges map[string]data.Edge) []string {
+	keys := make([]string, 0, len(edges))
+
+	for key := range edges {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
 /*
 Create a key for the transaction storage.
 */
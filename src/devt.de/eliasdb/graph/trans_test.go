@@ -16,6 +16,7 @@ import (
 	"strings"
 	"testing"
 
+	"devt.de/common/errorutil"
 	"devt.de/eliasdb/graph/data"
 	"devt.de/eliasdb/graph/graphstorage"
 	"devt.de/eliasdb/storage"
@@ -571,7 +572,7 @@ func countMaps(t *testing.T, trans *Trans, nodeStore int, nodeRemove int,
 }
 
 func TestTransErrors(t *testing.T) {
-	testTransPanic(t)
+	testTransFlushError(t)
 
 	constructEdge := func(node1 data.Node, kind string, node2 data.Node) data.Edge {
 
@@ -1034,13 +1035,9 @@ func TestTransErrors(t *testing.T) {
 	delete(sm.AccessMap, 5)
 }
 
-func testTransPanic(t *testing.T) {
+func testTransFlushError(t *testing.T) {
 	defer func() {
 		graphstorage.MgsRetFlushMain = nil
-
-		if r := recover(); r == nil {
-			t.Error("Transaction with a serious write error (during flushing) did not cause a panic.")
-		}
 	}()
 
 	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
@@ -1059,5 +1056,64 @@ func testTransPanic(t *testing.T) {
 
 	graphstorage.MgsRetFlushMain = errors.New("test")
 
-	trans.Commit()
+	// A serious write error (during flushing) is reported as corruption
+
+	if err := trans.Commit(); !errorutil.IsCategory(err, errorutil.ErrCorruption) ||
+		err.Error() != "GraphError: Graph storage may be inconsistent (test)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+type orderTestRule struct {
+	events []string
+}
+
+func (r *orderTestRule) Name() string {
+	return "ordertestrule"
+}
+
+func (r *orderTestRule) Handles() []int {
+	return []int{EventNodeCreated, EventNodeDeleted}
+}
+
+func (r *orderTestRule) Handle(gm *Manager, trans *Trans, event int, ed ...interface{}) error {
+	node := ed[1].(data.Node)
+	r.events = append(r.events, fmt.Sprint(ed[0], ":", node.Kind(), ":", node.Key()))
+	return nil
+}
+
+func TestTransCommitOrder(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := newGraphManagerNoRules(mgs)
+
+	rule := &orderTestRule{}
+	gm.SetGraphRule(rule)
+
+	trans := NewGraphTrans(gm)
+
+	for _, spec := range []string{"b:1:y", "a:2:x", "b:2:x", "a:1:y", "a:1:x"} {
+		s := strings.Split(spec, ":")
+
+		node := data.NewGraphNode()
+		node.SetAttr("key", s[1])
+		node.SetAttr("kind", s[2])
+
+		if err := trans.StoreNode(s[0], node); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Nodes are grouped by partition and kind
+
+	if res := fmt.Sprint(rule.events); res != "[a:x:1 a:x:2 a:y:1 b:x:2 b:y:1]" {
+		t.Error("Unexpected commit order:", res)
+		return
+	}
 }
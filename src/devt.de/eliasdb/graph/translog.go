@@ -0,0 +1,154 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+
+	"devt.de/eliasdb/graph/data"
+)
+
+/*
+TransLogEntry describes a single change which was made by a committed
+transaction. Only the fields which belong to the event are set.
+*/
+type TransLogEntry struct {
+	Event   int       // Graph event of the change (e.g. EventNodeCreated)
+	Part    string    // Partition of the changed node or edge
+	Node    data.Node // Stored node (nil if the node was deleted)
+	OldNode data.Node // Previous version of the node (nil if the node was created)
+	Edge    data.Edge // Stored edge (nil if the edge was deleted)
+	OldEdge data.Edge // Previous version of the edge (nil if the edge was created)
+}
+
+/*
+String returns a string representation of this log entry.
+*/
+func (e *TransLogEntry) String() string {
+	switch e.Event {
+	case EventNodeCreated:
+		return fmt.Sprintf("Created node %v (%v) in %v", e.Node.Key(), e.Node.Kind(), e.Part)
+	case EventNodeUpdated:
+		return fmt.Sprintf("Updated node %v (%v) in %v", e.Node.Key(), e.Node.Kind(), e.Part)
+	case EventNodeDeleted:
+		return fmt.Sprintf("Deleted node %v (%v) in %v", e.OldNode.Key(), e.OldNode.Kind(), e.Part)
+	case EventEdgeCreated:
+		return fmt.Sprintf("Created edge %v (%v) in %v", e.Edge.Key(), e.Edge.Kind(), e.Part)
+	case EventEdgeUpdated:
+		return fmt.Sprintf("Updated edge %v (%v) in %v", e.Edge.Key(), e.Edge.Kind(), e.Part)
+	}
+	return fmt.Sprintf("Deleted edge %v (%v) in %v", e.OldEdge.Key(), e.OldEdge.Kind(), e.Part)
+}
+
+/*
+TransLog is the ordered list of all changes which were made by a committed
+transaction. The list includes changes which were made by graph rules (e.g.
+edges which were removed together with a node).
+*/
+type TransLog []*TransLogEntry
+
+/*
+Log returns the changes which were made by the last successful commit of this
+transaction. Returns nil if the transaction has not been committed or if the
+commit failed.
+*/
+func (gt *Trans) Log() TransLog {
+	return gt.log
+}
+
+/*
+logNode adds a node change to the transaction log.
+*/
+func (gt *Trans) logNode(event int, part string, node data.Node, oldnode data.Node) {
+	if node != nil {
+		node = data.NodeClone(node)
+	}
+
+	gt.log = append(gt.log, &TransLogEntry{event, part, node, oldnode, nil, nil})
+}
+
+/*
+logEdge adds an edge change to the transaction log.
+*/
+func (gt *Trans) logEdge(event int, part string, edge data.Edge, oldedge data.Edge) {
+	if edge != nil {
+		edge = data.NewGraphEdgeFromNode(data.NodeClone(edge))
+	}
+
+	gt.log = append(gt.log, &TransLogEntry{event, part, nil, nil, edge, oldedge})
+}
+
+/*
+Undo creates a new transaction which reverts all changes of this log. Created
+nodes and edges are removed, updated and deleted nodes and edges are restored
+to their previous version. The returned transaction still needs to be
+committed - its log can be used to redo the reverted changes.
+*/
+func (tl TransLog) Undo(gm *Manager) (*Trans, error) {
+	var err error
+
+	trans := NewGraphTrans(gm)
+
+	// Revert the changes in reverse order so the oldest version of a node
+	// or edge is restored
+
+	for i := len(tl) - 1; i >= 0 && err == nil; i-- {
+		e := tl[i]
+
+		switch e.Event {
+		case EventNodeCreated:
+			err = trans.RemoveNode(e.Part, e.Node.Key(), e.Node.Kind())
+		case EventNodeUpdated, EventNodeDeleted:
+			err = trans.StoreNode(e.Part, e.OldNode)
+		case EventEdgeCreated:
+			err = trans.RemoveEdge(e.Part, e.Edge.Key(), e.Edge.Kind())
+		case EventEdgeUpdated, EventEdgeDeleted:
+			err = trans.StoreEdge(e.Part, e.OldEdge)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return trans, nil
+}
+
+/*
+Redo creates a new transaction which applies all changes of this log again.
+The returned transaction still needs to be committed.
+*/
+func (tl TransLog) Redo(gm *Manager) (*Trans, error) {
+	var err error
+
+	trans := NewGraphTrans(gm)
+
+	for i := 0; i < len(tl) && err == nil; i++ {
+		e := tl[i]
+
+		switch e.Event {
+		case EventNodeCreated, EventNodeUpdated:
+			err = trans.StoreNode(e.Part, e.Node)
+		case EventNodeDeleted:
+			err = trans.RemoveNode(e.Part, e.OldNode.Key(), e.OldNode.Kind())
+		case EventEdgeCreated, EventEdgeUpdated:
+			err = trans.StoreEdge(e.Part, e.Edge)
+		case EventEdgeDeleted:
+			err = trans.RemoveEdge(e.Part, e.OldEdge.Key(), e.OldEdge.Kind())
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return trans, nil
+}
@@ -0,0 +1,193 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestTransLog(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	newNode := func(key string, name string) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Song")
+		node.SetAttr(data.NodeName, name)
+		return node
+	}
+
+	edge := data.NewGraphEdge()
+	edge.SetAttr(data.NodeKey, "e1")
+	edge.SetAttr(data.NodeKind, "Link")
+	edge.SetAttr(data.EdgeEnd1Key, "a")
+	edge.SetAttr(data.EdgeEnd1Kind, "Song")
+	edge.SetAttr(data.EdgeEnd1Role, "from")
+	edge.SetAttr(data.EdgeEnd1Cascading, false)
+	edge.SetAttr(data.EdgeEnd2Key, "b")
+	edge.SetAttr(data.EdgeEnd2Kind, "Song")
+	edge.SetAttr(data.EdgeEnd2Role, "to")
+	edge.SetAttr(data.EdgeEnd2Cascading, false)
+
+	trans := NewGraphTrans(gm)
+
+	if trans.Log() != nil {
+		t.Error("Uncommitted transaction should have no log")
+		return
+	}
+
+	trans.StoreNode("main", newNode("a", "Song A"))
+	trans.StoreNode("main", newNode("b", "Song B"))
+	trans.StoreEdge("main", edge)
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(trans.Log()); res != "[Created node a (Song) in main "+
+		"Created node b (Song) in main Created edge e1 (Link) in main]" {
+		t.Error("Unexpected log:", res)
+		return
+	}
+
+	// Update a node and remove the other node - the edge is removed by a rule
+
+	trans = NewGraphTrans(gm)
+	trans.StoreNode("main", newNode("a", "Song A changed"))
+	trans.RemoveNode("main", "b", "Song")
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	log := trans.Log()
+
+	if res := fmt.Sprint(log); res != "[Updated node a (Song) in main "+
+		"Deleted node b (Song) in main Deleted edge e1 (Link) in main]" {
+		t.Error("Unexpected log:", res)
+		return
+	}
+
+	if name := log[0].OldNode.Attr(data.NodeName); name != "Song A" {
+		t.Error("Unexpected previous version:", name)
+		return
+	}
+
+	// Undo the changes
+
+	undo, err := log.Undo(gm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := undo.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkState := func(nameA string, bExists bool) bool {
+		if n, err := gm.FetchNode("main", "a", "Song"); err != nil || n.Name() != nameA {
+			t.Error("Unexpected result:", n, err)
+			return false
+		}
+
+		if n, err := gm.FetchNode("main", "b", "Song"); err != nil || (n != nil) != bExists {
+			t.Error("Unexpected result:", n, err)
+			return false
+		}
+
+		if e, err := gm.FetchEdge("main", "e1", "Link"); err != nil || (e != nil) != bExists {
+			t.Error("Unexpected result:", e, err)
+			return false
+		}
+
+		return true
+	}
+
+	if !checkState("Song A", true) {
+		return
+	}
+
+	// Redo the changes from the original log
+
+	redo, err := log.Redo(gm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := redo.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !checkState("Song A changed", false) {
+		return
+	}
+
+	// The log of an undo can be used to redo
+
+	redo, _ = undo.Log().Undo(gm)
+
+	if err := redo.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !checkState("Song A changed", false) {
+		return
+	}
+
+	// Undo a removal
+
+	trans = NewGraphTrans(gm)
+	trans.RemoveNode("main", "a", "Song")
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n, _ := gm.FetchNode("main", "a", "Song"); n != nil {
+		t.Error("Node should have been removed")
+		return
+	}
+
+	undoTrans, _ := trans.Log().Undo(gm)
+	undoTrans.Commit()
+
+	if n, _ := gm.FetchNode("main", "a", "Song"); n == nil || n.Name() != "Song A changed" {
+		t.Error("Unexpected result:", n)
+		return
+	}
+
+	// Test error cases
+
+	invalidLog := TransLog{&TransLogEntry{EventNodeCreated, "my main", newNode("c", "Song C"), nil, nil, nil}}
+
+	if _, err := invalidLog.Undo(gm); err == nil {
+		t.Error("Undo with an invalid partition should fail")
+		return
+	}
+
+	if _, err := invalidLog.Redo(gm); err == nil {
+		t.Error("Redo with an invalid partition should fail")
+		return
+	}
+}
@@ -0,0 +1,371 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/hash"
+)
+
+/*
+uniqueKey returns the key of the constraint index entry of a unique attribute
+value.
+*/
+func (gm *Manager) uniqueKey(attr string, val interface{}) []byte {
+	return []byte(PrefixNSUnique + gm.nm.Encode32(attr, true) + string(hash.ValueHash(val)))
+}
+
+/*
+checkUniqueValues checks that the values of the unique attributes of a node
+are not used by another node. It is assumed that the caller holds the writer
+lock.
+*/
+func (gm *Manager) checkUniqueValues(node data.Node, valTree *hash.HTree) error {
+
+	schema := gm.schemas.forKind(node.Kind())
+	if schema == nil {
+		return nil
+	}
+
+	for _, attr := range schema.Unique {
+		val := node.Attr(attr)
+		if val == nil {
+			continue
+		}
+
+		owner, err := valTree.Get(gm.uniqueKey(attr, val))
+		if err != nil {
+			return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+		}
+
+		if owner != nil && owner != node.Key() {
+			return &util.GraphError{
+				Type: util.ErrSchemaViolation,
+				Detail: fmt.Sprintf("Node %v (%v) attribute %v value %v is already used by node %v",
+					node.Key(), node.Kind(), attr, data.ValueString(val), owner),
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+checkUniqueValues checks the unique attribute values of all nodes which are
+stored by a transaction before anything is written. Values which are used by
+nodes that are removed or changed in the same transaction can be taken over.
+It is assumed that the caller holds the writer lock.
+*/
+func (gt *Trans) checkUniqueValues() error {
+	claimed := make(map[string]string)
+
+	for _, tkey := range sortedNodeKeys(gt.storeNodes) {
+		node := gt.storeNodes[tkey]
+		part := strings.Split(tkey, "#")[0]
+
+		schema := gt.gm.schemas.forKind(node.Kind())
+		if schema == nil || len(schema.Unique) == 0 {
+			continue
+		}
+
+		_, valTree, err := gt.gm.getNodeStorageHTree(part, node.Kind(), false)
+		if err != nil {
+			return err
+		}
+
+		for _, attr := range schema.Unique {
+			val := node.Attr(attr)
+			if val == nil {
+				continue
+			}
+
+			ukey := gt.gm.uniqueKey(attr, val)
+			ckey := part + "#" + node.Kind() + "#" + string(ukey)
+
+			owner, ok := claimed[ckey]
+
+			if !ok && valTree != nil {
+				var res interface{}
+
+				if res, err = valTree.Get(ukey); err != nil {
+					return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+				} else if res != nil {
+					owner = res.(string)
+
+					// The owner gives up the value if it is removed or
+					// stored with another value
+
+					okey := gt.createKey(part, owner, node.Kind())
+					_, removed := gt.removeNodes[okey]
+					_, stored := gt.storeNodes[okey]
+
+					if removed || stored {
+						owner = ""
+					}
+				}
+			}
+
+			if owner != "" && owner != node.Key() {
+				return &util.GraphError{
+					Type: util.ErrSchemaViolation,
+					Detail: fmt.Sprintf("Node %v (%v) attribute %v value %v is already used by node %v",
+						node.Key(), node.Kind(), attr, data.ValueString(val), owner),
+				}
+			}
+
+			claimed[ckey] = node.Key()
+		}
+	}
+
+	return nil
+}
+
+/*
+updateUniqueValues updates the constraint index after a node was written or
+deleted (the node is nil). Attributes which are missing on a partial node
+keep their value. It is assumed that the caller holds the writer lock and
+that the values were checked with checkUniqueValues.
+*/
+func (gm *Manager) updateUniqueValues(key string, kind string, node data.Node,
+	oldnode data.Node, partial bool, valTree *hash.HTree) error {
+
+	schema := gm.schemas.forKind(kind)
+	if schema == nil {
+		return nil
+	}
+
+	for _, attr := range schema.Unique {
+		var val, oldval interface{}
+
+		if node != nil {
+			if val = node.Attr(attr); val == nil && partial {
+				continue
+			}
+		}
+
+		if oldnode != nil {
+			oldval = oldnode.Attr(attr)
+		}
+
+		if oldval != nil {
+			okey := gm.uniqueKey(attr, oldval)
+
+			if owner, err := valTree.Get(okey); err != nil {
+				return &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+			} else if owner == key {
+				if _, err := valTree.Remove(okey); err != nil {
+					return util.NewWritingError(err)
+				}
+			}
+		}
+
+		if val != nil {
+			if _, err := valTree.Put(gm.uniqueKey(attr, val), key); err != nil {
+				return util.NewWritingError(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+changeUniqueIndex changes the constraint index of a node kind in all
+partitions if the unique attributes of the kind change. The entries of
+attributes which are no longer unique are removed. The entries of attributes
+which become unique are built from the existing nodes. Nothing is changed if
+existing nodes share a value. It is assumed that the caller holds the writer
+lock.
+*/
+func (gm *Manager) changeUniqueIndex(kind string, oldUnique []string, newUnique []string) error {
+	var changed, added, parts []string
+
+	for _, attr := range oldUnique {
+		if !containsString(newUnique, attr) {
+			changed = append(changed, attr)
+		}
+	}
+
+	for _, attr := range newUnique {
+		if !containsString(oldUnique, attr) {
+			changed = append(changed, attr)
+			added = append(added, attr)
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	// Collect the new entries first so nothing is written if nodes share a value
+
+	entries := make(map[string]map[string]string)
+
+	for _, part := range gm.Partitions() {
+
+		sm := gm.gs.StorageManager(part+kind+StorageSuffixNodes, false)
+		if sm == nil {
+			continue
+		}
+
+		parts = append(parts, part)
+
+		if len(added) == 0 {
+			continue
+		}
+
+		attrTree, err := gm.getHTree(sm, RootIDNodeHTree)
+		if err != nil {
+			return err
+		}
+
+		valTree, err := gm.getHTree(sm, RootIDNodeHTreeSecond)
+		if err != nil {
+			return err
+		}
+
+		if entries[part], err = gm.collectUniqueIndexEntries(part, kind, added,
+			attrTree, valTree); err != nil {
+			return err
+		}
+	}
+
+	err := func() error {
+
+		for _, part := range parts {
+
+			sm := gm.gs.StorageManager(part+kind+StorageSuffixNodes, false)
+
+			valTree, err := gm.getHTree(sm, RootIDNodeHTreeSecond)
+			if err != nil {
+				return err
+			}
+
+			if err := gm.clearUniqueIndex(changed, valTree); err != nil {
+				return err
+			}
+
+			for ukey, key := range entries[part] {
+				if _, err := valTree.Put([]byte(ukey), key); err != nil {
+					return util.NewWritingError(err)
+				}
+			}
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		for _, part := range parts {
+			gm.rollbackNodeStorage(part, kind)
+		}
+
+		return err
+	}
+
+	for _, part := range parts {
+		if err := gm.flushNodeStorage(part, kind); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+collectUniqueIndexEntries collects the constraint index entries of the given
+attributes of all existing nodes of a kind in a partition. Returns an error if
+two nodes have the same value.
+*/
+func (gm *Manager) collectUniqueIndexEntries(part string, kind string, attrs []string,
+	attrTree *hash.HTree, valTree *hash.HTree) (map[string]string, error) {
+
+	entries := make(map[string]string)
+
+	it := attrTree.IteratorPrefix([]byte(PrefixNSAttrs))
+
+	for it.HasNext() {
+		k, _ := it.Next()
+
+		if it.LastError != nil {
+			break
+		}
+
+		node, err := gm.readNode(string(k[len(PrefixNSAttrs):]), kind, attrs, attrTree, valTree)
+		if err != nil {
+			return nil, err
+		} else if node == nil {
+			continue
+		}
+
+		for _, attr := range attrs {
+			val := node.Attr(attr)
+			if val == nil {
+				continue
+			}
+
+			ukey := string(gm.uniqueKey(attr, val))
+
+			if owner, ok := entries[ukey]; ok {
+				return nil, &util.GraphError{
+					Type: util.ErrSchemaViolation,
+					Detail: fmt.Sprintf("Nodes %v and %v (%v) in partition %v have the same value %v of unique attribute %v",
+						owner, node.Key(), node.Kind(), part, data.ValueString(val), attr),
+				}
+			}
+
+			entries[ukey] = node.Key()
+		}
+	}
+
+	if it.LastError != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+	}
+
+	return entries, nil
+}
+
+/*
+clearUniqueIndex removes all constraint index entries of the given attributes.
+*/
+func (gm *Manager) clearUniqueIndex(attrs []string, valTree *hash.HTree) error {
+	var keys [][]byte
+
+	for _, attr := range attrs {
+		it := valTree.IteratorPrefix([]byte(PrefixNSUnique + gm.nm.Encode32(attr, true)))
+
+		for it.HasNext() {
+			k, _ := it.Next()
+
+			if it.LastError != nil {
+				break
+			}
+
+			keys = append(keys, k)
+		}
+
+		if it.LastError != nil {
+			return &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+		}
+	}
+
+	for _, k := range keys {
+		if _, err := valTree.Remove(k); err != nil {
+			return util.NewWritingError(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+	"devt.de/eliasdb/graph/util"
+)
+
+func TestUniqueAttributes(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	newUser := func(key string, email interface{}) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "User")
+		node.SetAttr("email", email)
+		return node
+	}
+
+	uniqueEntries := func(part string) int {
+		_, valTree, _ := gm.getNodeStorageHTree(part, "User", false)
+		it := valTree.IteratorPrefix([]byte(PrefixNSUnique))
+		count := 0
+		for it.HasNext() {
+			it.Next()
+			count++
+		}
+		return count
+	}
+
+	isViolation := func(err error) bool {
+		gerr, ok := err.(*util.GraphError)
+		return ok && gerr.Type == util.ErrSchemaViolation
+	}
+
+	gm.StoreNode("main", newUser("u1", "a@x"))
+	gm.StoreNode("main", newUser("u2", "a@x"))
+	gm.StoreNode("main", newUser("u3", nil))
+	gm.StoreNode("other", newUser("u1", "a@x"))
+
+	schema := &Schema{"User", nil, []string{"email"}, nil, false, nil}
+
+	// Existing nodes which share a value prevent the declaration
+
+	if err := gm.SetSchema(schema); !isViolation(err) || err.Error() !=
+		"GraphError: Schema violation (Nodes u1 and u2 (User) in partition main have the same value a@x of unique attribute email)" &&
+		err.Error() != "GraphError: Schema violation (Nodes u2 and u1 (User) in partition main have the same value a@x of unique attribute email)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res := gm.Schema("User"); res != nil || uniqueEntries("main") != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	gm.StoreNode("main", newUser("u2", "b@x"))
+
+	if err := gm.SetSchema(schema); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := uniqueEntries("main"); res != 2 {
+		t.Error("Unexpected number of entries:", res)
+		return
+	}
+
+	// Values must be unique within a partition
+
+	if err := gm.StoreNode("main", newUser("u3", "a@x")); !isViolation(err) || err.Error() !=
+		"GraphError: Schema violation (Node u3 (User) attribute email value a@x is already used by node u1)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.UpdateNode("main", newUser("u3", "b@x")); !isViolation(err) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res, _ := gm.FetchNode("main", "u3", "User"); res.Attr("email") != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := gm.StoreNode("other", newUser("u2", "b@x")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Values are compared by type and content
+
+	if err := gm.StoreNode("main", newUser("u3", 5)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUser("u4", "5")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A node can be stored again with the same value
+
+	if err := gm.StoreNode("main", newUser("u1", "a@x")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Updates which do not contain the attribute keep the value
+
+	update := data.NewGraphNode()
+	update.SetAttr(data.NodeKey, "u1")
+	update.SetAttr(data.NodeKind, "User")
+	update.SetAttr("name", "Anne")
+
+	if err := gm.UpdateNode("main", update); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUser("u5", "a@x")); !isViolation(err) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Changed and removed values can be used again
+
+	if err := gm.UpdateNode("main", newUser("u1", "c@x")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUser("u5", "a@x")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := gm.RemoveNode("main", "u5", "User"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUser("u6", "a@x")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := uniqueEntries("main"); res != 5 {
+		t.Error("Unexpected number of entries:", res)
+		return
+	}
+
+	// Transactions are rejected as a whole
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newUser("u7", "d@x"))
+	trans.StoreNode("main", newUser("u8", "d@x"))
+
+	if err := trans.Commit(); !isViolation(err) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res, _ := gm.FetchNode("main", "u7", "User"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	trans = NewGraphTrans(gm)
+	trans.RemoveNode("main", "u6", "User")
+	trans.StoreNode("main", newUser("u7", "d@x"))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUser("u8", "a@x")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Nodes can take over values which are given up in the same transaction
+
+	trans = NewGraphTrans(gm)
+	trans.StoreNode("main", newUser("u3", "a@x"))
+	trans.StoreNode("main", newUser("u8", "e@x"))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUser("u9", "a@x")); !isViolation(err) || err.Error() !=
+		"GraphError: Schema violation (Node u9 (User) attribute email value a@x is already used by node u3)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Removing the schema removes the constraint index
+
+	if _, err := gm.RemoveSchema("User"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := uniqueEntries("main"); res != 0 {
+		t.Error("Unexpected number of entries:", res)
+		return
+	}
+
+	if err := gm.StoreNode("main", newUser("u9", "a@x")); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestUniqueAttributesConcurrency(t *testing.T) {
+	gm := NewGraphManager(graphstorage.NewMemoryGraphStorage("mystorage"))
+
+	if err := gm.SetSchema(&Schema{"User", nil, []string{"email"}, nil, false, nil}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+
+	stored := 0
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			node := data.NewGraphNode()
+			node.SetAttr(data.NodeKey, fmt.Sprint("u", i))
+			node.SetAttr(data.NodeKind, "User")
+			node.SetAttr("email", "a@x")
+
+			if err := gm.StoreNode("main", node); err == nil {
+				mutex.Lock()
+				stored++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if stored != 1 || gm.NodeCount("User") != 1 {
+		t.Error("Unexpected number of stored nodes:", stored, gm.NodeCount("User"))
+		return
+	}
+}
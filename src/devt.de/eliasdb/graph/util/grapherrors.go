@@ -43,8 +43,10 @@ either the names or their numbers.
 package util
 
 import (
-	"errors"
 	"fmt"
+	"strings"
+
+	"devt.de/common/errorutil"
 )
 
 /*
@@ -66,25 +68,89 @@ func (ge *GraphError) Error() string {
 	return fmt.Sprintf("GraphError: %v", ge.Type)
 }
 
+/*
+Category returns the category of this error which is determined by its type.
+*/
+func (ge *GraphError) Category() error {
+	return errorutil.Category(ge.Type)
+}
+
 /*
 Graph storage related error types
 */
 var (
-	ErrOpening         = errors.New("Failed to open graph storage")
-	ErrFlushing        = errors.New("Failed to flush changes")
-	ErrRollback        = errors.New("Failed to rollback changes")
-	ErrClosing         = errors.New("Failed to close graph storage")
-	ErrAccessComponent = errors.New("Failed to access graph storage component")
-	ErrReadOnly        = errors.New("Failed write to readonly storage")
+	ErrOpening         = errorutil.NewCategorizedError(errorutil.ErrInternal, "Failed to open graph storage")
+	ErrFlushing        = errorutil.NewCategorizedError(errorutil.ErrInternal, "Failed to flush changes")
+	ErrRollback        = errorutil.NewCategorizedError(errorutil.ErrInternal, "Failed to rollback changes")
+	ErrClosing         = errorutil.NewCategorizedError(errorutil.ErrInternal, "Failed to close graph storage")
+	ErrAccessComponent = errorutil.NewCategorizedError(errorutil.ErrInternal, "Failed to access graph storage component")
+	ErrReadOnly        = errorutil.NewCategorizedError(errorutil.ErrReadOnly, "Failed write to readonly storage")
+	ErrQuotaExceeded   = errorutil.NewCategorizedError(errorutil.ErrQuota, "Storage quota exceeded")
+	ErrInconsistent    = errorutil.NewCategorizedError(errorutil.ErrCorruption, "Graph storage may be inconsistent")
 )
 
 /*
 Graph related error types
 */
 var (
-	ErrInvalidData = errors.New("Invalid data")
-	ErrIndexError  = errors.New("Index error")
-	ErrReading     = errors.New("Could not read graph information")
-	ErrWriting     = errors.New("Could not write graph information")
-	ErrRule        = errors.New("Graph rule error")
+	ErrInvalidData     = errorutil.NewCategorizedError(errorutil.ErrInvalid, "Invalid data")
+	ErrSchemaViolation = errorutil.NewCategorizedError(errorutil.ErrInvalid, "Schema violation")
+	ErrIndexError      = errorutil.NewCategorizedError(errorutil.ErrInternal, "Index error")
+	ErrReading         = errorutil.NewCategorizedError(errorutil.ErrInternal, "Could not read graph information")
+	ErrWriting         = errorutil.NewCategorizedError(errorutil.ErrInternal, "Could not write graph information")
+	ErrRule            = errorutil.NewCategorizedError(errorutil.ErrInternal, "Graph rule error")
+	ErrJournalCorrupt  = errorutil.NewCategorizedError(errorutil.ErrCorruption, "Corrupted journal")
+)
+
+/*
+Rule error types for errors which were caused by a client (e.g. by violating an
+invariant). They have the same message as ErrRule.
+*/
+var (
+	ErrRuleInvalid  = errorutil.NewCategorizedError(errorutil.ErrInvalid, ErrRule.Error())
+	ErrRuleConflict = errorutil.NewCategorizedError(errorutil.ErrConflict, ErrRule.Error())
+	ErrRuleNotFound = errorutil.NewCategorizedError(errorutil.ErrNotFound, ErrRule.Error())
 )
+
+/*
+NewRuleError combines the errors which were returned by graph rules. The error
+has the type ErrRule (an internal error) unless all errors are invalid data,
+conflict or not found errors of the same category - in this case the error has
+the matching client error type (e.g. ErrRuleInvalid).
+*/
+func NewRuleError(errs []error) *GraphError {
+	var details []string
+
+	errType := map[error]error{
+		errorutil.ErrInvalid:  ErrRuleInvalid,
+		errorutil.ErrConflict: ErrRuleConflict,
+		errorutil.ErrNotFound: ErrRuleNotFound,
+	}[errorutil.Category(errs[0])]
+
+	for _, err := range errs {
+		if errorutil.Category(err) != errorutil.Category(errs[0]) {
+			errType = nil
+		}
+
+		details = append(details, err.Error())
+	}
+
+	if errType == nil {
+		errType = ErrRule
+	}
+
+	return &GraphError{Type: errType, Detail: strings.Join(details, ";")}
+}
+
+/*
+NewWritingError wraps an error which occurred while writing graph information.
+Errors which were caused by an exceeded storage quota are reported as
+ErrQuotaExceeded so clients can tell them apart from other write errors.
+*/
+func NewWritingError(err error) *GraphError {
+	if errorutil.IsCategory(err, errorutil.ErrQuota) {
+		return &GraphError{Type: ErrQuotaExceeded, Detail: err.Error()}
+	}
+
+	return &GraphError{Type: ErrWriting, Detail: err.Error()}
+}
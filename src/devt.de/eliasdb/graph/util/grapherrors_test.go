@@ -13,6 +13,8 @@ package util
 import (
 	"errors"
 	"testing"
+
+	"devt.de/common/errorutil"
 )
 
 func TestGraphError(t *testing.T) {
@@ -29,4 +31,44 @@ func TestGraphError(t *testing.T) {
 		t.Error("Unexpected result", err.Error())
 		return
 	}
+
+	if err.Category() != errorutil.ErrInternal {
+		t.Error("Unexpected category:", err.Category())
+		return
+	}
+
+	err = GraphError{ErrInvalidData, "SomeDetail"}
+
+	if !errorutil.IsCategory(&err, errorutil.ErrInvalid) {
+		t.Error("Unexpected category:", err.Category())
+		return
+	}
+}
+
+func TestRuleError(t *testing.T) {
+	invalid := &GraphError{ErrInvalidData, "a"}
+	schema := &GraphError{ErrSchemaViolation, "b"}
+	internal := &GraphError{ErrReading, "c"}
+
+	err := NewRuleError([]error{invalid, schema})
+
+	if err.Type != ErrRuleInvalid || !errorutil.IsCategory(err, errorutil.ErrInvalid) ||
+		err.Error() != "GraphError: Graph rule error (GraphError: Invalid data (a);GraphError: Schema violation (b))" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Errors which were not caused by a client are internal errors
+
+	if err = NewRuleError([]error{invalid, internal}); err.Type != ErrRule ||
+		!errorutil.IsCategory(err, errorutil.ErrInternal) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err = NewRuleError([]error{errors.New("test")}); err.Type != ErrRule ||
+		err.Error() != "GraphError: Graph rule error (test)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
 }
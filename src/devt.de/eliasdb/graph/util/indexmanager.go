@@ -18,11 +18,9 @@ import (
 	"math"
 	"sort"
 	"strings"
-	"unicode"
 
 	"devt.de/common/bitutil"
 	"devt.de/common/sortutil"
-	"devt.de/common/stringutil"
 	"devt.de/eliasdb/hash"
 )
 
@@ -46,11 +44,17 @@ PrefixAttrHash is the prefix used for hashes of attribute values
 */
 const PrefixAttrHash = string(0x02)
 
+/*
+PrefixAttrVocab is the prefix used for the vocabulary entries of attributes
+*/
+const PrefixAttrVocab = string(0x03)
+
 /*
 IndexManager data structure
 */
 type IndexManager struct {
-	htree *hash.HTree // Persistent HTree which stores this index
+	htree *hash.HTree   // Persistent HTree which stores this index
+	opts  *IndexOptions // Options for the extraction of words
 }
 
 /*
@@ -71,7 +75,16 @@ func init() {
 NewIndexManager creates a new index manager instance.
 */
 func NewIndexManager(htree *hash.HTree) *IndexManager {
-	return &IndexManager{htree}
+	return &IndexManager{htree, nil}
+}
+
+/*
+NewIndexManagerWithOptions creates a new index manager instance which extracts
+words with the given options. An index must always be used with the same
+options. The default options are used if no options are given.
+*/
+func NewIndexManagerWithOptions(htree *hash.HTree, opts *IndexOptions) *IndexManager {
+	return &IndexManager{htree, opts}
 }
 
 /*
@@ -105,9 +118,12 @@ func (im *IndexManager) LookupPhrase(attr, phrase string) ([]string, error) {
 
 	// Chop up the phrase into words
 
-	phraseWords := strings.FieldsFunc(phrase, func(r rune) bool {
-		return !stringutil.IsAlphaNumeric(string(r)) && (unicode.IsSpace(r) || unicode.IsControl(r) || unicode.IsPunct(r))
-	})
+	tokenizer, err := im.tokenizer()
+	if err != nil {
+		return nil, err
+	}
+
+	phraseWords := tokenizer(phrase)
 
 	// Lookup every phrase word
 
@@ -233,14 +249,99 @@ LookupWord finds all nodes where an attribute contains a certain word. This call
 a map which maps node key to a list of word positions.
 */
 func (im *IndexManager) LookupWord(attr, word string) (map[string][]uint64, error) {
-	var s string
+	return im.lookupIndexWord(attr, im.normalizeWord(word))
+}
 
-	if CaseSensitiveWordIndex {
-		s = word
-	} else {
-		s = strings.ToLower(word)
+/*
+LookupFuzzy finds all nodes where an attribute contains a word which differs
+by at most a given number of edits (inserted, deleted, changed or swapped
+characters) from a certain word. This call returns a map which maps node key
+to a list of word positions. The index must have been created with the Fuzzy
+option. The lookup visits all entries of the index.
+*/
+func (im *IndexManager) LookupFuzzy(attr, word string, distance int) (map[string][]uint64, error) {
+
+	if im.opts == nil || !im.opts.Fuzzy {
+		return nil, &GraphError{ErrInvalidData, "Index does not support fuzzy lookups"}
+	} else if distance < 0 || distance > MaxFuzzyDistance {
+		return nil, &GraphError{ErrInvalidData,
+			fmt.Sprintf("Edit distance must be between 0 and %v", MaxFuzzyDistance)}
+	}
+
+	// A misspelled word might not be reduced to the right stem - words are
+	// compared with the stem and the unstemmed word
+
+	terms := []string{im.normalizeWord(word)}
+
+	if !im.caseSensitive() {
+		word = strings.ToLower(word)
+	}
+
+	if word != terms[0] {
+		terms = append(terms, word)
+	}
+
+	prefix := vocabPrefix(attr)
+
+	// Collect all similar words of the attribute
+
+	var words []string
+
+	it := im.htree.IteratorPrefix([]byte(prefix))
+
+	for it.HasNext() {
+		k, _ := it.Next()
+
+		if it.LastError != nil {
+			break
+		}
+
+		w := string(k[len(prefix):])
+
+		for _, term := range terms {
+			if editDistance(term, w, distance) <= distance {
+				words = append(words, w)
+				break
+			}
+		}
+	}
+
+	if it.LastError != nil {
+		return nil, &GraphError{ErrIndexError, it.LastError.Error()}
+	}
+
+	// Merge the positions of all found words
+
+	var ret map[string][]uint64
+
+	for _, w := range words {
+
+		res, err := im.lookupIndexWord(attr, w)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, pos := range res {
+			if ret == nil {
+				ret = make(map[string][]uint64)
+			}
+			ret[k] = append(ret[k], pos...)
+		}
+	}
+
+	for k, pos := range ret {
+		sortutil.UInt64s(pos)
+		ret[k] = removeDuplicates(pos)
 	}
 
+	return ret, nil
+}
+
+/*
+lookupIndexWord looks up the index entry of a normalized word.
+*/
+func (im *IndexManager) lookupIndexWord(attr, s string) (map[string][]uint64, error) {
+
 	entry, err := im.htree.Get([]byte(PrefixAttrWord + attr + s))
 
 	if err != nil {
@@ -264,15 +365,8 @@ returns a list of node keys.
 */
 func (im *IndexManager) LookupValue(attr, value string) ([]string, error) {
 	var entry *indexEntry
-	var sum [16]byte
-
-	if CaseSensitiveWordIndex {
-		sum = md5.Sum([]byte(value))
-	} else {
-		sum = md5.Sum([]byte(strings.ToLower(value)))
-	}
 
-	indexkey := []byte(PrefixAttrHash + attr + string(sum[:16]))
+	indexkey := im.hashKey(attr, value)
 
 	// Retrieve index entry
 
@@ -303,15 +397,8 @@ func (im *IndexManager) LookupValue(attr, value string) ([]string, error) {
 Count returns the number of found nodes for a given word in a given attribute.
 */
 func (im *IndexManager) Count(attr, word string) (int, error) {
-	var s string
 
-	if CaseSensitiveWordIndex {
-		s = word
-	} else {
-		s = strings.ToLower(word)
-	}
-
-	entry, err := im.htree.Get([]byte(PrefixAttrWord + attr + s))
+	entry, err := im.htree.Get([]byte(PrefixAttrWord + attr + im.normalizeWord(word)))
 
 	if err != nil {
 		return 0, &GraphError{ErrIndexError, err.Error()}
@@ -363,6 +450,11 @@ func (im *IndexManager) updateIndex(key string, newObj map[string]string,
 
 	emptyws := newWordSet(1)
 
+	tokenizer, err := im.tokenizer()
+	if err != nil {
+		return err
+	}
+
 	for attr := range attrMap {
 		var newwords, toadd, oldwords, toremove *wordSet
 
@@ -375,7 +467,7 @@ func (im *IndexManager) updateIndex(key string, newObj map[string]string,
 		oldwords = emptyws
 
 		if newok {
-			newwords = extractWords(newval)
+			newwords = im.extractWords(newval, tokenizer)
 		}
 
 		// At this point we have only words to add
@@ -384,7 +476,7 @@ func (im *IndexManager) updateIndex(key string, newObj map[string]string,
 		toremove = emptyws
 
 		if oldok {
-			oldwords = extractWords(oldval)
+			oldwords = im.extractWords(oldval, tokenizer)
 
 			if !oldwords.Empty() && !newwords.Empty() {
 
@@ -457,15 +549,8 @@ value as MD5 sum.
 */
 func (im *IndexManager) addIndexHashEntry(key string, attr string, value string) error {
 	var entry *indexEntry
-	var sum [16]byte
 
-	if CaseSensitiveWordIndex {
-		sum = md5.Sum([]byte(value))
-	} else {
-		sum = md5.Sum([]byte(strings.ToLower(value)))
-	}
-
-	indexkey := []byte(PrefixAttrHash + attr + string(sum[:16]))
+	indexkey := im.hashKey(attr, value)
 
 	// Retrieve index entry
 
@@ -493,15 +578,8 @@ value as MD5 sum.
 */
 func (im *IndexManager) removeIndexHashEntry(key string, attr string, value string) error {
 	var entry *indexEntry
-	var sum [16]byte
-
-	if CaseSensitiveWordIndex {
-		sum = md5.Sum([]byte(value))
-	} else {
-		sum = md5.Sum([]byte(strings.ToLower(value)))
-	}
 
-	indexkey := []byte(PrefixAttrHash + attr + string(sum[:16]))
+	indexkey := im.hashKey(attr, value)
 
 	// Retrieve index entry
 
@@ -575,7 +653,12 @@ func (im *IndexManager) removeIndexEntry(key string, attr string, word string, p
 	}
 
 	if len(entry.WordPos) == 0 {
-		_, err = im.htree.Remove(indexkey)
+		if _, err = im.htree.Remove(indexkey); err == nil && im.opts != nil && im.opts.Fuzzy {
+
+			// The word is no longer used by the attribute
+
+			_, err = im.htree.Remove([]byte(vocabPrefix(attr) + word))
+		}
 	} else {
 		_, err = im.htree.Put(indexkey, entry)
 	}
@@ -600,6 +683,16 @@ func (im *IndexManager) addIndexEntry(key string, attr string, word string, pos
 
 	if obj == nil {
 		entry = &indexEntry{make(map[string]string)}
+
+		if im.opts != nil && im.opts.Fuzzy {
+
+			// Add the word to the vocabulary of the attribute
+
+			if _, err := im.htree.Put([]byte(vocabPrefix(attr)+word), &indexEntry{}); err != nil {
+				return err
+			}
+		}
+
 	} else {
 		entry = obj.(*indexEntry)
 	}
@@ -684,17 +777,9 @@ func (im *IndexManager) String() string {
 extractWords extracts all words from a given string and return a wordSet which contains
 all words and their positions.
 */
-func extractWords(s string) *wordSet {
+func (im *IndexManager) extractWords(s string, tokenizer Tokenizer) *wordSet {
 
-	var text string
-
-	if CaseSensitiveWordIndex {
-		text = s
-	} else {
-		text = strings.ToLower(s)
-	}
-
-	initArrCap := int(math.Ceil(float64(len(text)) * 0.01))
+	initArrCap := int(math.Ceil(float64(len(s)) * 0.01))
 	if initArrCap < 4 {
 		initArrCap = 4
 	}
@@ -702,28 +787,78 @@ func extractWords(s string) *wordSet {
 	ws := newWordSet(initArrCap)
 
 	var pos uint64
-	wstart := -1
 
-	for i, rune := range text {
+	for _, word := range tokenizer(s) {
+		if word = im.normalizeWord(word); word != "" {
+			pos++
+			ws.Add(word, pos)
+		}
+	}
 
-		if !stringutil.IsAlphaNumeric(string(rune)) && (unicode.IsSpace(rune) || unicode.IsControl(rune) || unicode.IsPunct(rune)) {
+	return ws
+}
 
-			if wstart >= 0 {
-				ws.Add(text[wstart:i], pos+1)
-				pos++
-				wstart = -1
-			}
+/*
+tokenizer returns the tokenizer of this index manager.
+*/
+func (im *IndexManager) tokenizer() (Tokenizer, error) {
+	if im.opts == nil {
+		return defaultTokenizer, nil
+	}
 
-		} else if wstart == -1 {
-			wstart = i
-		}
+	return LookupTokenizer(im.opts.Tokenizer)
+}
+
+/*
+caseSensitive returns if this index manager is case sensitive.
+*/
+func (im *IndexManager) caseSensitive() bool {
+	if im.opts == nil {
+		return CaseSensitiveWordIndex
 	}
 
-	if wstart >= 0 {
-		ws.Add(text[wstart:], pos+1)
+	return im.opts.CaseSensitive
+}
+
+/*
+normalizeWord folds the case of a given word and reduces it to its stem
+depending on the options of this index manager.
+*/
+func (im *IndexManager) normalizeWord(word string) string {
+
+	if !im.caseSensitive() {
+		word = strings.ToLower(word)
 	}
 
-	return ws
+	if im.opts != nil && im.opts.Stemming {
+		word = StemWord(word)
+	}
+
+	return word
+}
+
+/*
+hashKey returns the key of the hash entry of an attribute value.
+*/
+func (im *IndexManager) hashKey(attr string, value string) []byte {
+	var sum [16]byte
+
+	if im.caseSensitive() {
+		sum = md5.Sum([]byte(value))
+	} else {
+		sum = md5.Sum([]byte(strings.ToLower(value)))
+	}
+
+	return []byte(PrefixAttrHash + attr + string(sum[:16]))
+}
+
+/*
+vocabPrefix returns the key prefix of the vocabulary entries of an attribute.
+The length of the attribute name is part of the prefix so the vocabulary of
+one attribute never contains entries of another attribute.
+*/
+func vocabPrefix(attr string) string {
+	return fmt.Sprintf("%v%v:%v", PrefixAttrVocab, len(attr), attr)
 }
 
 /*
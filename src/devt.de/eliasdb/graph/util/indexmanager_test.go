@@ -592,8 +592,10 @@ func TestExtractWords(t *testing.T) {
 
 	CaseSensitiveWordIndex = false
 
-	ws := extractWords("   aaa BBB  ;,   ccc...aaa ddd-bbb xxxx aaaa    test1\n" +
-		"test2 xxxx bbb")
+	im := NewIndexManager(nil)
+
+	ws := im.extractWords("   aaa BBB  ;,   ccc...aaa ddd-bbb xxxx aaaa    test1\n"+
+		"test2 xxxx bbb", defaultTokenizer)
 
 	if res := ws.String(); res != "WordSet:\n"+
 		"    aaa [1 4]\n"+
@@ -609,8 +611,8 @@ func TestExtractWords(t *testing.T) {
 
 	CaseSensitiveWordIndex = true
 
-	ws = extractWords("   aaa BBB     ccc aaa ddd bbb xxxx aaaa    test1\n" +
-		"test2 xxxx bbb")
+	ws = im.extractWords("   aaa BBB     ccc aaa ddd bbb xxxx aaaa    test1\n"+
+		"test2 xxxx bbb", defaultTokenizer)
 
 	if res := ws.String(); res != "WordSet:\n"+
 		"    BBB [2]\n"+
@@ -0,0 +1,196 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"devt.de/common/stringutil"
+)
+
+/*
+DefaultTokenizer is the name of the tokenizer which is used if no tokenizer
+is specified.
+*/
+const DefaultTokenizer = "default"
+
+/*
+MaxFuzzyDistance is the maximum edit distance for fuzzy word lookups.
+*/
+const MaxFuzzyDistance = 2
+
+/*
+IndexOptions controls how words are extracted from attribute values and
+lookup terms of a full text index.
+*/
+type IndexOptions struct {
+	CaseSensitive bool   // Flag if words are case sensitive (otherwise they are case folded)
+	Stemming      bool   // Flag if words are reduced to their stem (see StemWord)
+	Tokenizer     string // Name of the tokenizer which splits values into words
+	Fuzzy         bool   // Flag if the words of each attribute are recorded for fuzzy lookups
+}
+
+/*
+DefaultIndexOptions returns the options which are used if an index manager
+has no options.
+*/
+func DefaultIndexOptions() *IndexOptions {
+	return &IndexOptions{CaseSensitive: CaseSensitiveWordIndex, Tokenizer: DefaultTokenizer}
+}
+
+/*
+Tokenizer splits a text into a list of words. The position of a word in the
+list is its position in the text.
+*/
+type Tokenizer func(text string) []string
+
+/*
+tokenizers holds all registered tokenizers
+*/
+var tokenizers = map[string]Tokenizer{
+	DefaultTokenizer: defaultTokenizer,
+	"whitespace":     strings.Fields,
+}
+
+/*
+tokenizersLock protects the tokenizer registry
+*/
+var tokenizersLock = &sync.RWMutex{}
+
+/*
+RegisterTokenizer registers a tokenizer under a given name. A registered
+tokenizer must produce the same words for the same text for as long as an
+index uses it.
+*/
+func RegisterTokenizer(name string, tokenizer Tokenizer) {
+	tokenizersLock.Lock()
+	defer tokenizersLock.Unlock()
+
+	tokenizers[name] = tokenizer
+}
+
+/*
+Tokenizers returns the names of all registered tokenizers.
+*/
+func Tokenizers() []string {
+	tokenizersLock.RLock()
+	defer tokenizersLock.RUnlock()
+
+	ret := make([]string, 0, len(tokenizers))
+
+	for name := range tokenizers {
+		ret = append(ret, name)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+/*
+LookupTokenizer returns a registered tokenizer. The default tokenizer is
+returned for an empty name.
+*/
+func LookupTokenizer(name string) (Tokenizer, error) {
+
+	if name == "" {
+		name = DefaultTokenizer
+	}
+
+	tokenizersLock.RLock()
+	defer tokenizersLock.RUnlock()
+
+	tokenizer, ok := tokenizers[name]
+	if !ok {
+		return nil, &GraphError{ErrInvalidData, fmt.Sprintf("Unknown tokenizer %v", name)}
+	}
+
+	return tokenizer, nil
+}
+
+/*
+defaultTokenizer splits a text at spaces, control characters and punctuation.
+*/
+func defaultTokenizer(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !stringutil.IsAlphaNumeric(string(r)) && (unicode.IsSpace(r) || unicode.IsControl(r) || unicode.IsPunct(r))
+	})
+}
+
+/*
+editDistance calculates the edit distance between two words. Insertions,
+deletions, substitutions and transpositions of adjacent characters count as
+one edit. The calculation stops early if the distance exceeds a given maximum
+- in this case max + 1 is returned.
+*/
+func editDistance(s1 string, s2 string, max int) int {
+	r1, r2 := []rune(s1), []rune(s2)
+
+	if d := len(r1) - len(r2); d > max || -d > max {
+		return max + 1
+	}
+
+	// Keep the last three rows of the distance matrix
+
+	prev2 := make([]int, len(r2)+1)
+	prev := make([]int, len(r2)+1)
+	cur := make([]int, len(r2)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(r1); i++ {
+		cur[0] = i
+		rowMin := i
+
+		for j := 1; j <= len(r2); j++ {
+			cost := 1
+			if r1[i-1] == r2[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost
+
+			if v := prev[j] + 1; v < cur[j] {
+				cur[j] = v
+			}
+			if v := cur[j-1] + 1; v < cur[j] {
+				cur[j] = v
+			}
+			if i > 1 && j > 1 && r1[i-1] == r2[j-2] && r1[i-2] == r2[j-1] {
+				if v := prev2[j-2] + 1; v < cur[j] {
+					cur[j] = v
+				}
+			}
+
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+
+		if rowMin > max {
+			return max + 1
+		}
+
+		prev2, prev, cur = prev, cur, prev2
+	}
+
+	if prev[len(r2)] > max {
+		return max + 1
+	}
+
+	return prev[len(r2)]
+}
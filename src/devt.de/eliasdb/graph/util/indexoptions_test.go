@@ -0,0 +1,216 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/storage"
+)
+
+func TestIndexOptions(t *testing.T) {
+	htree, _ := hash.NewHTree(storage.NewMemoryStorageManager("testsm"))
+
+	im := NewIndexManagerWithOptions(htree, &IndexOptions{Stemming: true, Fuzzy: true})
+
+	obj := map[string]string{
+		"text": "The Connected nodes are connecting; a Connection connects",
+		"name": "Running runners",
+	}
+
+	if err := im.Index("key1", obj); err != nil {
+		t.Error(err)
+		return
+	}
+
+	im.Index("key2", map[string]string{"text": "connate"})
+
+	// Words and lookup terms are case folded and stemmed
+
+	if res, err := im.LookupWord("text", "CONNECTIONS"); err != nil ||
+		fmt.Sprint(res) != "map[key1:[2 5 7 8]]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, _ := im.Count("text", "connect"); res != 1 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupPhrase("text", "connecting node"); fmt.Sprint(res) != "[key1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupWord("name", "runs"); fmt.Sprint(res) != "map[key1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Values are not stemmed
+
+	if res, _ := im.LookupValue("name", "running RUNNERS"); fmt.Sprint(res) != "[key1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupValue("name", "run runner"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Fuzzy lookups find words with typos
+
+	if res, err := im.LookupFuzzy("text", "conect", 1); err != nil ||
+		fmt.Sprint(res) != "map[key1:[2 5 7 8]]" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, _ := im.LookupFuzzy("text", "conect", 2); fmt.Sprint(res) != "map[key1:[2 5 7 8] key2:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupFuzzy("text", "noed", 1); fmt.Sprint(res) != "map[key1:[3]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupFuzzy("name", "noed", 1); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if _, err := im.LookupFuzzy("text", "noed", 3); err == nil || err.Error() !=
+		"GraphError: Invalid data (Edit distance must be between 0 and 2)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// The vocabulary of an attribute is updated when words are removed
+
+	if err := im.Reindex("key1", map[string]string{"text": "nodes"}, obj); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := im.LookupFuzzy("text", "conect", 2); fmt.Sprint(res) != "map[key2:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	im.Deindex("key1", map[string]string{"text": "nodes"})
+	im.Deindex("key2", map[string]string{"text": "connate"})
+
+	if res := countChildren(htree); res != 0 {
+		t.Error("Unexpected number of children:", res)
+		return
+	}
+
+	// Indexes without the fuzzy option do not support fuzzy lookups
+
+	if _, err := NewIndexManager(htree).LookupFuzzy("text", "noed", 1); err == nil || err.Error() !=
+		"GraphError: Invalid data (Index does not support fuzzy lookups)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestIndexOptionsTokenizer(t *testing.T) {
+	htree, _ := hash.NewHTree(storage.NewMemoryStorageManager("testsm"))
+
+	RegisterTokenizer("comma", func(text string) []string {
+		return strings.Split(text, ",")
+	})
+
+	if res := Tokenizers(); fmt.Sprint(res) != "[comma default whitespace]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	im := NewIndexManagerWithOptions(htree, &IndexOptions{CaseSensitive: true, Tokenizer: "whitespace"})
+
+	im.Index("key1", map[string]string{"text": "e-mail is Case-Sensitive"})
+
+	if res, _ := im.LookupWord("text", "e-mail"); fmt.Sprint(res) != "map[key1:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupWord("text", "case-sensitive"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupPhrase("text", "is Case-Sensitive"); fmt.Sprint(res) != "[key1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	im = NewIndexManagerWithOptions(htree, &IndexOptions{Tokenizer: "comma"})
+
+	im.Index("key2", map[string]string{"tags": "New York,,Berlin"})
+
+	if res, _ := im.LookupWord("tags", "new york"); fmt.Sprint(res) != "map[key2:[1]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := im.LookupWord("tags", "berlin"); fmt.Sprint(res) != "map[key2:[2]]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	im = NewIndexManagerWithOptions(htree, &IndexOptions{Tokenizer: "unknown"})
+
+	if err := im.Index("key3", map[string]string{"text": "abc"}); err == nil || err.Error() !=
+		"GraphError: Invalid data (Unknown tokenizer unknown)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := im.LookupPhrase("text", "abc"); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+
+	tests := []struct {
+		s1, s2 string
+		max    int
+		res    int
+	}{
+		{"", "", 2, 0},
+		{"abc", "abc", 2, 0},
+		{"abc", "abd", 2, 1},
+		{"abc", "ab", 2, 1},
+		{"abc", "xabc", 2, 1},
+		{"abc", "acb", 2, 1},
+		{"abcd", "badc", 2, 2},
+		{"kitten", "sitting", 2, 3},
+		{"kitten", "sitting", 3, 3},
+		{"abc", "", 2, 3},
+		{"größe", "grösse", 2, 2},
+	}
+
+	for _, test := range tests {
+		if res := editDistance(test.s1, test.s2, test.max); res != test.res {
+			t.Error("Unexpected distance between", test.s1, "and", test.s2, ":", res)
+		}
+	}
+}
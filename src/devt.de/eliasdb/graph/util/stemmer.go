@@ -0,0 +1,343 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+/*
+StemWord reduces an english word to its stem using the Porter stemming
+algorithm (e.g. connections, connected and connecting become connect). Only
+lower case words which consist of the letters a-z are stemmed - all other
+words are returned unchanged.
+*/
+func StemWord(word string) string {
+
+	if len(word) <= 2 {
+		return word
+	}
+
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	s := &stemmer{[]byte(word), len(word) - 1, 0}
+
+	s.step1ab()
+
+	if s.k > 0 {
+		s.step1c()
+		s.step2()
+		s.step3()
+		s.step4()
+		s.step5()
+	}
+
+	return string(s.b[:s.k+1])
+}
+
+/*
+stemmer data structure
+*/
+type stemmer struct {
+	b []byte // Buffer which holds the word
+	k int    // Offset to the end of the word
+	j int    // General offset into the word
+}
+
+/*
+cons checks if b[i] is a consonant.
+*/
+func (s *stemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.cons(i-1)
+	}
+	return true
+}
+
+/*
+m measures the number of consonant sequences between the start of the word
+and j. With <c> being a consonant sequence and <v> being a vowel sequence:
+
+	<c><v>       gives 0
+	<c>vc<v>     gives 1
+	<c>vcvc<v>   gives 2
+*/
+func (s *stemmer) m() int {
+	n := 0
+	i := 0
+
+	for {
+		if i > s.j {
+			return n
+		}
+		if !s.cons(i) {
+			break
+		}
+		i++
+	}
+
+	i++
+
+	for {
+		for {
+			if i > s.j {
+				return n
+			}
+			if s.cons(i) {
+				break
+			}
+			i++
+		}
+
+		i++
+		n++
+
+		for {
+			if i > s.j {
+				return n
+			}
+			if !s.cons(i) {
+				break
+			}
+			i++
+		}
+
+		i++
+	}
+}
+
+/*
+vowelInStem checks if the word up to j contains a vowel.
+*/
+func (s *stemmer) vowelInStem() bool {
+	for i := 0; i <= s.j; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+doublec checks if j and j-1 contain the same consonant.
+*/
+func (s *stemmer) doublec(j int) bool {
+	return j >= 1 && s.b[j] == s.b[j-1] && s.cons(j)
+}
+
+/*
+cvc checks if i-2, i-1, i has the form consonant - vowel - consonant and the
+second consonant is not w, x or y (e.g. hop but not snow, box or tray).
+*/
+func (s *stemmer) cvc(i int) bool {
+	if i < 2 || !s.cons(i) || s.cons(i-1) || !s.cons(i-2) {
+		return false
+	}
+	ch := s.b[i]
+	return ch != 'w' && ch != 'x' && ch != 'y'
+}
+
+/*
+ends checks if the word ends with a given suffix and sets j to the offset
+before the suffix.
+*/
+func (s *stemmer) ends(suffix string) bool {
+	l := len(suffix)
+
+	if l > s.k+1 || string(s.b[s.k-l+1:s.k+1]) != suffix {
+		return false
+	}
+
+	s.j = s.k - l
+
+	return true
+}
+
+/*
+setTo replaces the word after j with a given string.
+*/
+func (s *stemmer) setTo(str string) {
+	s.b = append(s.b[:s.j+1], str...)
+	s.k = s.j + len(str)
+}
+
+/*
+replace replaces the word after j with a given string if m() > 0.
+*/
+func (s *stemmer) replace(str string) {
+	if s.m() > 0 {
+		s.setTo(str)
+	}
+}
+
+/*
+step1ab removes plurals and -ed or -ing. For example:
+
+	caresses  ->  caress
+	ponies    ->  poni
+	feed      ->  feed
+	agreed    ->  agree
+	motoring  ->  motor
+	hopping   ->  hop
+	filing    ->  file
+*/
+func (s *stemmer) step1ab() {
+
+	if s.b[s.k] == 's' {
+		if s.ends("sses") {
+			s.k -= 2
+		} else if s.ends("ies") {
+			s.setTo("i")
+		} else if s.b[s.k-1] != 's' {
+			s.k--
+		}
+	}
+
+	if s.ends("eed") {
+		if s.m() > 0 {
+			s.k--
+		}
+	} else if (s.ends("ed") || s.ends("ing")) && s.vowelInStem() {
+		s.k = s.j
+
+		if s.ends("at") {
+			s.setTo("ate")
+		} else if s.ends("bl") {
+			s.setTo("ble")
+		} else if s.ends("iz") {
+			s.setTo("ize")
+		} else if s.doublec(s.k) {
+			s.k--
+			if ch := s.b[s.k]; ch == 'l' || ch == 's' || ch == 'z' {
+				s.k++
+			}
+		} else if s.m() == 1 && s.cvc(s.k) {
+			s.setTo("e")
+		}
+	}
+}
+
+/*
+step1c turns a terminal y to i when there is another vowel in the word.
+*/
+func (s *stemmer) step1c() {
+	if s.ends("y") && s.vowelInStem() {
+		s.b[s.k] = 'i'
+	}
+}
+
+/*
+step2 maps double suffixes to single ones (e.g. -ization becomes -ize).
+*/
+func (s *stemmer) step2() {
+	for _, r := range stemmerStep2Rules[s.b[s.k-1]] {
+		if s.ends(r[0]) {
+			s.replace(r[1])
+			return
+		}
+	}
+}
+
+/*
+step3 handles -ic-, -full, -ness etc.
+*/
+func (s *stemmer) step3() {
+	for _, r := range stemmerStep3Rules[s.b[s.k]] {
+		if s.ends(r[0]) {
+			s.replace(r[1])
+			return
+		}
+	}
+}
+
+/*
+step4 removes -ant, -ence etc. if the word has a measure greater than 1.
+*/
+func (s *stemmer) step4() {
+	var found bool
+
+	for _, suffix := range stemmerStep4Suffixes[s.b[s.k-1]] {
+		if s.ends(suffix) {
+			found = suffix != "ion" || (s.j >= 0 && (s.b[s.j] == 's' || s.b[s.j] == 't'))
+			break
+		}
+	}
+
+	if found && s.m() > 1 {
+		s.k = s.j
+	}
+}
+
+/*
+step5 removes a final -e and changes -ll to -l if the word has a measure
+greater than 1.
+*/
+func (s *stemmer) step5() {
+	s.j = s.k
+
+	if s.b[s.k] == 'e' {
+		if a := s.m(); a > 1 || a == 1 && !s.cvc(s.k-1) {
+			s.k--
+		}
+	}
+
+	if s.b[s.k] == 'l' && s.doublec(s.k) && s.m() > 1 {
+		s.k--
+	}
+}
+
+/*
+stemmerStep2Rules are the suffix replacements of step 2 by the penultimate
+letter of the word.
+*/
+var stemmerStep2Rules = map[byte][][2]string{
+	'a': {{"ational", "ate"}, {"tional", "tion"}},
+	'c': {{"enci", "ence"}, {"anci", "ance"}},
+	'e': {{"izer", "ize"}},
+	'l': {{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}},
+	'o': {{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}},
+	's': {{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"}},
+	't': {{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}},
+	'g': {{"logi", "log"}},
+}
+
+/*
+stemmerStep3Rules are the suffix replacements of step 3 by the last letter
+of the word.
+*/
+var stemmerStep3Rules = map[byte][][2]string{
+	'e': {{"icate", "ic"}, {"ative", ""}, {"alize", "al"}},
+	'i': {{"iciti", "ic"}},
+	'l': {{"ical", "ic"}, {"ful", ""}},
+	's': {{"ness", ""}},
+}
+
+/*
+stemmerStep4Suffixes are the suffixes which are removed in step 4 by the
+penultimate letter of the word.
+*/
+var stemmerStep4Suffixes = map[byte][]string{
+	'a': {"al"},
+	'c': {"ance", "ence"},
+	'e': {"er"},
+	'i': {"ic"},
+	'l': {"able", "ible"},
+	'n': {"ant", "ement", "ment", "ent"},
+	'o': {"ion", "ou"},
+	's': {"ism"},
+	't': {"ate", "iti"},
+	'u': {"ous"},
+	'v': {"ive"},
+	'z': {"ize"},
+}
@@ -0,0 +1,74 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package util
+
+import "testing"
+
+func TestStemWord(t *testing.T) {
+
+	words := map[string]string{
+		"caresses":       "caress",
+		"ponies":         "poni",
+		"ties":           "ti",
+		"caress":         "caress",
+		"cats":           "cat",
+		"feed":           "feed",
+		"agreed":         "agre",
+		"plastered":      "plaster",
+		"bled":           "bled",
+		"motoring":       "motor",
+		"sing":           "sing",
+		"conflated":      "conflat",
+		"troubled":       "troubl",
+		"sized":          "size",
+		"hopping":        "hop",
+		"tanned":         "tan",
+		"falling":        "fall",
+		"hissing":        "hiss",
+		"fizzed":         "fizz",
+		"failing":        "fail",
+		"filing":         "file",
+		"happy":          "happi",
+		"sky":            "sky",
+		"relational":     "relat",
+		"conditional":    "condit",
+		"valenci":        "valenc",
+		"digitizer":      "digit",
+		"vietnamization": "vietnam",
+		"generalization": "gener",
+		"triplicate":     "triplic",
+		"hopeful":        "hope",
+		"goodness":       "good",
+		"revival":        "reviv",
+		"allowance":      "allow",
+		"adjustment":     "adjust",
+		"adoption":       "adopt",
+		"connection":     "connect",
+		"connections":    "connect",
+		"connected":      "connect",
+		"connecting":     "connect",
+		"probate":        "probat",
+		"rate":           "rate",
+		"controll":       "control",
+		"roll":           "roll",
+		"running":        "run",
+		"runs":           "run",
+		"go":             "go",
+		"Running":        "Running",
+		"naïve":          "naïve",
+	}
+
+	for word, stem := range words {
+		if res := StemWord(word); res != stem {
+			t.Error("Unexpected stem for", word, ":", res, "expected:", stem)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"sort"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/util"
+	"devt.de/eliasdb/hash"
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+CreateValueIndex creates a value index for an attribute of a node kind. A
+value index maps the exact values of an attribute to the keys of the nodes
+which have them. Values are compared by their string representation - unlike
+the full text index the comparison is case sensitive. The index is built from
+the existing nodes of all partitions and is maintained whenever nodes are
+stored or removed.
+*/
+func (gm *Manager) CreateValueIndex(kind string, attr string) error {
+	return gm.changeIndexedAttr(gm.valueIdx, kind, attr, true, gm.buildValueIndex)
+}
+
+/*
+DropValueIndex removes the value index of an attribute of a node kind.
+*/
+func (gm *Manager) DropValueIndex(kind string, attr string) error {
+	return gm.changeIndexedAttr(gm.valueIdx, kind, attr, false, gm.buildValueIndex)
+}
+
+/*
+ValueIndexes returns the sorted attributes of a node kind which have a value
+index.
+*/
+func (gm *Manager) ValueIndexes(kind string) []string {
+	return gm.valueIdx.sorted(kind)
+}
+
+/*
+NodeKeysByValue returns the sorted keys of all nodes of a kind in a partition
+whose attribute has a given value. The attribute must have a value index.
+*/
+func (gm *Manager) NodeKeysByValue(part string, kind string, attr string,
+	value interface{}) ([]string, error) {
+
+	if err := gm.valueIdx.checkIndexed(kind, attr); err != nil {
+		return nil, err
+	}
+
+	tree, err := gm.getValueIndexTree(part, kind, false)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	// Take reader lock
+
+	lockprof.RLock(gm.mutex, lockprof.LockGraph, "NodeKeysByValue")
+	defer gm.mutex.RUnlock()
+
+	vals, err := tree.Get(gm.valueIndexKey(attr, value))
+	if err != nil {
+		return nil, &util.GraphError{Type: util.ErrReading, Detail: err.Error()}
+	}
+
+	ret := make([]string, 0, len(vals))
+
+	for _, val := range vals {
+		ret = append(ret, val.(string))
+	}
+
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+/*
+buildValueIndex adds the value index entries of an attribute for all
+existing nodes of a kind or removes them. It is assumed that the caller holds
+the writer lock.
+*/
+func (gm *Manager) buildValueIndex(kind string, attr string, add bool) error {
+	var parts []string
+
+	err := func() error {
+
+		for _, part := range gm.Partitions() {
+
+			sm := gm.gs.StorageManager(part+kind+StorageSuffixNodes, false)
+			if sm == nil {
+				continue
+			}
+
+			attrTree, err := gm.getHTree(sm, RootIDNodeHTree)
+			if err != nil {
+				return err
+			}
+
+			valTree, err := gm.getHTree(sm, RootIDNodeHTreeSecond)
+			if err != nil {
+				return err
+			}
+
+			tree, err := gm.getValueIndexTree(part, kind, true)
+			if err != nil {
+				return err
+			}
+
+			parts = append(parts, part)
+
+			// Collect the keys of all values first - existing entries of a
+			// value are replaced
+
+			entries := make(map[string][]string)
+
+			it := attrTree.IteratorPrefix([]byte(PrefixNSAttrs))
+
+			for it.HasNext() {
+				k, _ := it.Next()
+
+				if it.LastError != nil {
+					break
+				}
+
+				key := string(k[len(PrefixNSAttrs):])
+
+				node, err := gm.readNode(key, kind, []string{attr}, attrTree, valTree)
+				if err != nil {
+					return err
+				} else if node == nil || node.Attr(attr) == nil {
+					continue
+				}
+
+				ikey := string(gm.valueIndexKey(attr, node.Attr(attr)))
+				entries[ikey] = append(entries[ikey], key)
+			}
+
+			if it.LastError != nil {
+				return &util.GraphError{Type: util.ErrReading, Detail: it.LastError.Error()}
+			}
+
+			for ikey, keys := range entries {
+				if _, err := tree.RemoveAll([]byte(ikey)); err != nil {
+					return util.NewWritingError(err)
+				} else if !add {
+					continue
+				}
+
+				for _, key := range keys {
+					if err := tree.Add([]byte(ikey), key); err != nil {
+						return util.NewWritingError(err)
+					}
+				}
+			}
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		for _, part := range parts {
+			gm.rollbackNodeIndex(part, kind)
+		}
+
+		return err
+	}
+
+	for _, part := range parts {
+		if err := gm.flushNodeIndex(part, kind); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+updateValueIndex updates the value indexes of a node kind after a node was
+written or deleted (the node is nil). Attributes which are missing on a
+partial node keep their value. It is assumed that the caller holds the writer
+lock.
+*/
+func (gm *Manager) updateValueIndex(part string, key string, kind string, node data.Node,
+	oldnode data.Node, partial bool) error {
+
+	attrs := gm.valueIdx.forKind(kind)
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	tree, err := gm.getValueIndexTree(part, kind, true)
+	if err != nil {
+		return err
+	}
+
+	for _, attr := range attrs {
+		var val, oldval interface{}
+
+		if node != nil {
+			if val = node.Attr(attr); val == nil && partial {
+				continue
+			}
+		}
+
+		if oldnode != nil {
+			oldval = oldnode.Attr(attr)
+		}
+
+		if val != nil && oldval != nil && data.ValueString(val) == data.ValueString(oldval) {
+			continue
+		}
+
+		if oldval != nil {
+			if _, err := tree.Remove(gm.valueIndexKey(attr, oldval), key); err != nil {
+				return util.NewWritingError(err)
+			}
+		}
+
+		if val != nil {
+			if err := tree.Add(gm.valueIndexKey(attr, val), key); err != nil {
+				return util.NewWritingError(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+valueIndexKey returns the key of the value index entry of an attribute value.
+*/
+func (gm *Manager) valueIndexKey(attr string, val interface{}) []byte {
+	return []byte(gm.nm.Encode32(attr, true) + string(hash.ValueHash(data.ValueString(val))))
+}
+
+/*
+getValueIndexTree gets the tree which stores the value indexes of a node kind.
+The tree is kept in the storage of the full text index so both indexes are
+flushed and rolled back together.
+*/
+func (gm *Manager) getValueIndexTree(part string, kind string, create bool) (*hash.MultiHTree, error) {
+
+	// Check if the partition name is valid
+
+	if err := gm.checkPartitionName(part); err != nil {
+		return nil, err
+	}
+
+	sm := gm.gs.StorageManager(part+kind+StorageSuffixNodesIndex, create)
+	if sm == nil {
+		return nil, nil
+	}
+
+	return gm.getMultiHTree(sm, RootIDNodeHTreeSecond)
+}
@@ -0,0 +1,180 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package graph
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/graph/data"
+	"devt.de/eliasdb/graph/graphstorage"
+)
+
+func TestValueIndex(t *testing.T) {
+	mgs := graphstorage.NewMemoryGraphStorage("mystorage")
+	gm := NewGraphManager(mgs)
+
+	newTask := func(key string, status interface{}) data.Node {
+		node := data.NewGraphNode()
+		node.SetAttr(data.NodeKey, key)
+		node.SetAttr(data.NodeKind, "Task")
+		node.SetAttr("status", status)
+		return node
+	}
+
+	lookup := func(gm *Manager, part string, value interface{}) string {
+		res, err := gm.NodeKeysByValue(part, "Task", "status", value)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprint(res)
+	}
+
+	// The index is built from existing nodes
+
+	gm.StoreNode("main", newTask("t1", "active"))
+	gm.StoreNode("main", newTask("t2", "active"))
+	gm.StoreNode("main", newTask("t3", "Active"))
+	gm.StoreNode("main", newTask("t4", nil))
+	gm.StoreNode("other", newTask("t1", "active"))
+
+	if res := lookup(gm, "main", "active"); res !=
+		"GraphError: Invalid data (Attribute status of node kind Task has no value index)" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := gm.CreateValueIndex("Task", "status"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := lookup(gm, "main", "active"); res != "[t1 t2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm, "other", "active"); res != "[t1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm, "third", "active"); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The index is updated when nodes are stored, updated and removed
+
+	gm.StoreNode("main", newTask("t4", 5))
+	gm.StoreNode("main", newTask("t2", "done"))
+
+	update := data.NewGraphNode()
+	update.SetAttr(data.NodeKey, "t1")
+	update.SetAttr(data.NodeKind, "Task")
+	update.SetAttr("name", "Task 1")
+
+	gm.UpdateNode("main", update)
+	gm.UpdateNode("main", newTask("t3", "active"))
+
+	if res := lookup(gm, "main", "active"); res != "[t1 t3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Values are compared by their string representation
+
+	if res := lookup(gm, "main", "5"); res != "[t4]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	gm.RemoveNode("main", "t1", "Task")
+
+	if res := lookup(gm, "main", "active"); res != "[t3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Transactions update the index
+
+	trans := NewGraphTrans(gm)
+	trans.StoreNode("main", newTask("t5", "active"))
+	trans.RemoveNode("main", "t3", "Task")
+	trans.UpdateNode("main", newTask("t2", "active"))
+
+	if err := trans.Commit(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := lookup(gm, "main", "active"); res != "[t2 t5]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm, "main", "done"); res != "[]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Indexes are persisted
+
+	gm2 := NewGraphManager(mgs)
+
+	if res := gm2.ValueIndexes("Task"); fmt.Sprint(res) != "[status]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := lookup(gm2, "main", "active"); res != "[t2 t5]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Dropping the index removes all entries
+
+	if err := gm2.DropValueIndex("Task", "status"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := gm2.ValueIndexes("Task"); len(res) != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	tree, _ := gm2.getValueIndexTree("main", "Task", false)
+
+	if res, _ := tree.Count(gm2.valueIndexKey("status", "active")); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := NewGraphManager(mgs).ValueIndexes("Task"); len(res) != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Check errors
+
+	if err := gm.CreateValueIndex("Ta sk", "status"); err == nil || err.Error() !=
+		"GraphError: Invalid data (Node kind Ta sk is not alphanumeric - can only contain [a-zA-Z0-9_])" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := gm.CreateValueIndex("Task", data.NodeKey); err == nil || err.Error() !=
+		"GraphError: Invalid data (Attribute key cannot have a value index)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
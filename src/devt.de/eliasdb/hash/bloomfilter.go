@@ -0,0 +1,385 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+BloomFilterBlockBits is the number of bits of a Bloom filter block
+*/
+const BloomFilterBlockBits = 512
+
+/*
+BloomFilterBlockKeys is the number of keys which can be added to a Bloom filter
+block before the filter is rebuilt (about 10 bits per key)
+*/
+const BloomFilterBlockKeys = 48
+
+/*
+BloomFilterHashes is the number of bits which are set in a Bloom filter block
+for each key
+*/
+const BloomFilterHashes = 7
+
+/*
+htreeFilter data structure - this object models a Bloom filter of all keys
+of a HTree on disk. The filter is split into blocks - all bits of a key are
+in the same block so adding a key changes only a single block.
+*/
+type htreeFilter struct {
+	Blocks []uint64 // Storage locations of the filter blocks
+}
+
+/*
+htreeFilterBlock data structure - a block of a Bloom filter
+*/
+type htreeFilterBlock struct {
+	Bits  []uint64 // Bits of this block
+	Count int      // Number of keys which were added to this block
+}
+
+/*
+EnableBloomFilter adds a Bloom filter of all keys to this tree. Lookups of keys
+which do not exist can then be answered (in most cases) without reading the
+tree. The filter is stored with the tree and is updated once a new key is put
+into the tree. The filter is rebuilt once it contains too many keys - removed
+keys are only dropped from the filter when it is rebuilt.
+*/
+func (t *HTree) EnableBloomFilter() error {
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "EnableBloomFilter")
+	defer t.mutex.Unlock()
+
+	if t.Root.Filter != 0 {
+		return nil
+	}
+
+	return t.rebuildFilter()
+}
+
+/*
+DisableBloomFilter removes the Bloom filter of this tree.
+*/
+func (t *HTree) DisableBloomFilter() error {
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "DisableBloomFilter")
+	defer t.mutex.Unlock()
+
+	old := t.Root.Filter
+
+	if old == 0 {
+		return nil
+	}
+
+	t.Root.Filter = 0
+
+	if err := t.Root.sm.Update(t.Root.loc, t.Root.htreeNode); err != nil {
+		t.Root.Filter = old
+		return err
+	}
+
+	t.freeFilter(old)
+
+	return nil
+}
+
+/*
+HasBloomFilter returns if this tree has a Bloom filter.
+*/
+func (t *HTree) HasBloomFilter() bool {
+	lockprof.RLock(t.mutex, lockprof.LockHTree, "HasBloomFilter")
+	defer t.mutex.RUnlock()
+
+	return t.Root.Filter != 0
+}
+
+/*
+filterMayContain checks if a key may be in this tree. Returns true if the tree
+has no filter or the filter cannot be read.
+*/
+func (t *HTree) filterMayContain(key []byte) bool {
+	if t.Root.Filter == 0 {
+		return true
+	}
+
+	filter, err := t.fetchFilter(t.Root.Filter)
+	if err != nil {
+		return true
+	}
+
+	blockIndex, bits := filterBits(key, len(filter.Blocks))
+	blockLoc := filter.Blocks[blockIndex]
+
+	l := pageLatches.acquire(t.Root.sm, blockLoc, false, "filterMayContain")
+	defer pageLatches.release(t.Root.sm, blockLoc, l, false)
+
+	block, err := t.fetchFilterBlock(blockLoc)
+	if err != nil {
+		return true
+	}
+
+	for _, bit := range bits {
+		if block.Bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+filterAdd adds a key to the filter of this tree. Only the block of the key is
+latched so keys can be added in parallel. Returns if the filter contains too
+many keys and should be rebuilt (see fixFilter).
+*/
+func (t *HTree) filterAdd(key []byte) (bool, error) {
+	if t.Root.Filter == 0 {
+		return false, nil
+	}
+
+	filter, err := t.fetchFilter(t.Root.Filter)
+	if err != nil {
+		return false, err
+	}
+
+	blockIndex, bits := filterBits(key, len(filter.Blocks))
+	blockLoc := filter.Blocks[blockIndex]
+
+	l := pageLatches.acquire(t.Root.sm, blockLoc, true, "filterAdd")
+	defer pageLatches.release(t.Root.sm, blockLoc, l, true)
+
+	block, err := t.fetchFilterBlock(blockLoc)
+	if err != nil {
+		return false, err
+	}
+
+	if !block.add(bits) {
+
+		// Nothing needs to be written if all bits were already set
+
+		return false, nil
+	}
+
+	if err = t.Root.sm.Update(blockLoc, block); err != nil {
+		return false, err
+	}
+
+	return block.Count > BloomFilterBlockKeys, nil
+}
+
+/*
+fixFilter rebuilds the filter of this tree after it became too full or drops
+it after it could not be updated (err is not nil). Nothing is done if the
+filter was replaced in the meantime. The tree must be locked exclusively.
+*/
+func (t *HTree) fixFilter(filter uint64, err error) error {
+	if t.Root.Filter != filter {
+		return nil
+	}
+
+	if err == nil {
+		err = t.rebuildFilter()
+	}
+
+	if err != nil {
+		t.dropFilter()
+	}
+
+	return err
+}
+
+/*
+dropFilter removes the filter of this tree after it could not be updated. A
+filter which misses keys must not be used for lookups.
+*/
+func (t *HTree) dropFilter() {
+	old := t.Root.Filter
+
+	t.Root.Filter = 0
+
+	if err := t.Root.sm.Update(t.Root.loc, t.Root.htreeNode); err != nil {
+		t.Root.Filter = old
+		return
+	}
+
+	t.freeFilter(old)
+}
+
+/*
+rebuildFilter builds a new filter from all keys of this tree and replaces the
+current filter.
+*/
+func (t *HTree) rebuildFilter() error {
+	var keys [][]byte
+
+	sm := t.Root.sm
+
+	it := NewHTreeIterator(t)
+
+	for it.HasNext() {
+		key, _ := it.Next()
+		keys = append(keys, key)
+	}
+
+	if it.LastError != nil {
+		return it.LastError
+	}
+
+	// New filters are filled up to half of their capacity
+
+	filter := &htreeFilter{make([]uint64, len(keys)/(BloomFilterBlockKeys/2)+1)}
+	blocks := make([]*htreeFilterBlock, len(filter.Blocks))
+
+	for i := range blocks {
+		blocks[i] = &htreeFilterBlock{make([]uint64, BloomFilterBlockBits/64), 0}
+	}
+
+	for _, key := range keys {
+		blockIndex, bits := filterBits(key, len(blocks))
+		blocks[blockIndex].add(bits)
+	}
+
+	freeNew := func() {
+		for _, loc := range filter.Blocks {
+			if loc != 0 {
+				sm.Free(loc)
+			}
+		}
+	}
+
+	for i, block := range blocks {
+		loc, err := sm.Insert(block)
+		if err != nil {
+			freeNew()
+			return err
+		}
+
+		filter.Blocks[i] = loc
+	}
+
+	loc, err := sm.Insert(filter)
+	if err != nil {
+		freeNew()
+		return err
+	}
+
+	old := t.Root.Filter
+	t.Root.Filter = loc
+
+	if err := sm.Update(t.Root.loc, t.Root.htreeNode); err != nil {
+		t.Root.Filter = old
+		freeNew()
+		sm.Free(loc)
+		return err
+	}
+
+	if old != 0 {
+		t.freeFilter(old)
+	}
+
+	return nil
+}
+
+/*
+freeFilter removes a filter from the storage. Errors are ignored since the
+filter is no longer referenced.
+*/
+func (t *HTree) freeFilter(loc uint64) {
+	sm := t.Root.sm
+
+	if filter, err := t.fetchFilter(loc); err == nil {
+		for _, blockLoc := range filter.Blocks {
+			sm.Free(blockLoc)
+		}
+	}
+
+	sm.Free(loc)
+}
+
+/*
+fetchFilter fetches a filter from the storage.
+*/
+func (t *HTree) fetchFilter(loc uint64) (*htreeFilter, error) {
+	if obj, _ := t.Root.sm.FetchCached(loc); obj != nil {
+		return obj.(*htreeFilter), nil
+	}
+
+	var res htreeFilter
+
+	if err := t.Root.sm.Fetch(loc, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+/*
+fetchFilterBlock fetches a filter block from the storage.
+*/
+func (t *HTree) fetchFilterBlock(loc uint64) (*htreeFilterBlock, error) {
+	if obj, _ := t.Root.sm.FetchCached(loc); obj != nil {
+		return obj.(*htreeFilterBlock), nil
+	}
+
+	var res htreeFilterBlock
+
+	if err := t.Root.sm.Fetch(loc, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+/*
+add sets the given bits in this block. Returns false if all bits were
+already set.
+*/
+func (b *htreeFilterBlock) add(bits []uint32) bool {
+	changed := false
+
+	for _, bit := range bits {
+		if mask := uint64(1) << (bit % 64); b.Bits[bit/64]&mask == 0 {
+			b.Bits[bit/64] |= mask
+			changed = true
+		}
+	}
+
+	if changed {
+		b.Count++
+	}
+
+	return changed
+}
+
+/*
+filterBits returns the block and the bits of a key in a filter with a given
+number of blocks.
+*/
+func filterBits(key []byte, blocks int) (int, []uint32) {
+	// MurMurHashData needs data beyond the hashed bytes
+
+	data := append(key[:len(key):len(key)], 0)
+
+	h1, _ := MurMurHashData(data, 0, len(key), 1)
+	h2, _ := MurMurHashData(data, 0, len(key), 2)
+
+	// Use double hashing to calculate the bits - the step is odd so all
+	// bits of a block can be reached
+
+	bits := make([]uint32, BloomFilterHashes)
+	step := h2>>16 | 1
+
+	for i := range bits {
+		bits[i] = (h2 + uint32(i)*step) % BloomFilterBlockBits
+	}
+
+	return int(h1 % uint32(blocks)), bits
+}
@@ -0,0 +1,254 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/storage"
+	"devt.de/eliasdb/storage/file"
+)
+
+func TestBloomFilter(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	htree, _ := NewHTree(sm)
+
+	for i := 0; i < 1000; i++ {
+		htree.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	if htree.HasBloomFilter() || htree.DisableBloomFilter() != nil {
+		t.Error("Tree should have no filter")
+		return
+	}
+
+	if err := htree.EnableBloomFilter(); err != nil || !htree.HasBloomFilter() {
+		t.Error("Tree should have a filter:", err)
+		return
+	}
+
+	if err := htree.EnableBloomFilter(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	filterLocs := func() map[uint64]bool {
+		filter, _ := htree.fetchFilter(htree.Root.Filter)
+
+		res := map[uint64]bool{htree.Root.Filter: true}
+		for _, loc := range filter.Blocks {
+			res[loc] = true
+		}
+
+		return res
+	}
+
+	if res := len(filterLocs()); res != 43 {
+		t.Error("Unexpected number of filter blocks:", res-1)
+		return
+	}
+
+	// Make the tree unreadable - lookups which are answered by the filter
+	// do not read the tree
+
+	locs := filterLocs()
+
+	for loc := range sm.Data {
+		if !locs[loc] && loc != htree.Location() {
+			sm.AccessMap[loc] = storage.AccessCacheAndFetchError
+		}
+	}
+
+	falsePositives := 0
+
+	for i := 1000; i < 2000; i++ {
+		if _, err := htree.Get([]byte(fmt.Sprint("key", i))); err != nil {
+			falsePositives++
+		}
+	}
+
+	if falsePositives > 30 {
+		t.Error("Unexpected number of false positives:", falsePositives)
+		return
+	}
+
+	if res, err := htree.Exists([]byte("nokey")); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, loc, err := htree.GetValueAndLocation([]byte("nokey")); res != nil || loc != 0 || err != nil {
+		t.Error("Unexpected result:", res, loc, err)
+		return
+	}
+
+	if _, err := htree.Get([]byte("key1")); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sm.AccessMap = make(map[uint64]int)
+
+	// Adding keys updates the filter - it is rebuilt once it is too full
+
+	for i := 1000; i < 5000; i++ {
+		if _, err := htree.Put([]byte(fmt.Sprint("key", i)), i); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if res := len(filterLocs()); res < 100 {
+		t.Error("Unexpected number of filter blocks:", res-1)
+		return
+	}
+
+	var keys [][]byte
+	var values []interface{}
+
+	for i := 5000; i < 6000; i++ {
+		keys = append(keys, []byte(fmt.Sprint("key", i)))
+		values = append(values, i)
+	}
+
+	if err := htree.PutBatch(keys, values); err != nil {
+		t.Error(err)
+		return
+	}
+
+	htree.Remove([]byte("key0"))
+
+	// The filter is stored with the tree
+
+	htree2, _ := LoadHTree(sm, htree.Location())
+
+	if !htree2.HasBloomFilter() {
+		t.Error("Tree should have a filter")
+		return
+	}
+
+	for i := 0; i < 6000; i++ {
+		if res, err := htree2.Get([]byte(fmt.Sprint("key", i))); (i != 0 && res != i) || (i == 0 && res != nil) || err != nil {
+			t.Error("Unexpected result:", i, res, err)
+			return
+		}
+	}
+
+	locs = filterLocs()
+
+	if err := htree.DisableBloomFilter(); err != nil || htree.HasBloomFilter() {
+		t.Error("Tree should have no filter:", err)
+		return
+	}
+
+	for loc := range locs {
+		if _, ok := sm.Data[loc]; ok {
+			t.Error("Filter was not removed:", loc)
+			return
+		}
+	}
+
+	if res, err := htree.Get([]byte("key42")); res != 42 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
+
+func TestBloomFilterErrors(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	htree, _ := NewHTree(sm)
+
+	htree.Put([]byte("key1"), 1)
+
+	sm.AccessMap[sm.LocCount] = storage.AccessInsertError
+
+	if err := htree.EnableBloomFilter(); !errors.Is(err, file.ErrAlreadyInUse) || htree.HasBloomFilter() {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, sm.LocCount)
+
+	loc := sm.LocCount + 1
+
+	sm.AccessMap[loc] = storage.AccessInsertError
+
+	if err := htree.EnableBloomFilter(); !errors.Is(err, file.ErrAlreadyInUse) || htree.HasBloomFilter() {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, loc)
+
+	sm.AccessMap[htree.Location()] = storage.AccessUpdateError
+
+	if err := htree.EnableBloomFilter(); err != storage.ErrSlotNotFound || htree.HasBloomFilter() {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, htree.Location())
+
+	htree.EnableBloomFilter()
+
+	sm.AccessMap[htree.Location()] = storage.AccessUpdateError
+
+	if err := htree.DisableBloomFilter(); err != storage.ErrSlotNotFound || !htree.HasBloomFilter() {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, htree.Location())
+
+	// Lookups fall back to the tree if the filter cannot be read
+
+	filter, _ := htree.fetchFilter(htree.Root.Filter)
+
+	sm.AccessMap[filter.Blocks[0]] = storage.AccessCacheAndFetchError
+
+	if res, err := htree.Get([]byte("key1")); res != 1 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// The filter is dropped if it cannot be updated
+
+	if _, err := htree.Put([]byte("key2"), 2); err != storage.ErrSlotNotFound || htree.HasBloomFilter() {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, filter.Blocks[0])
+
+	if res, err := htree.Get([]byte("key2")); res != 2 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	htree.EnableBloomFilter()
+
+	sm.AccessMap[htree.Root.Filter] = storage.AccessCacheAndFetchError
+
+	if res, err := htree.Exists([]byte("key2")); !res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := htree.PutBatch([][]byte{[]byte("key3")}, []interface{}{3}); err != storage.ErrSlotNotFound ||
+		htree.HasBloomFilter() {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
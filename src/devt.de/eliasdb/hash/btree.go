@@ -0,0 +1,635 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"devt.de/common/stringutil"
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/storage"
+)
+
+/*
+MaxBTreeNodeElements is the maximum number of keys of a leaf and the maximum
+number of children of an inner node before the node is split
+*/
+const MaxBTreeNodeElements = 64
+
+/*
+BTree data structure
+*/
+type BTree struct {
+	Root  *btreeNode  // Root node of the BTree
+	mutex *sync.Mutex // Mutex to protect tree operations
+}
+
+/*
+btreeNode data structure - this object models the BTree storage structure
+on disk. An inner node with n children has n-1 keys - child i contains all
+keys k with Keys[i-1] <= k < Keys[i].
+*/
+type btreeNode struct {
+	tree *BTree          // Reference to the BTree which owns this node (not persisted)
+	loc  uint64          // Storage location of this node (not persisted)
+	sm   storage.Manager // StorageManager instance which stores the tree data (not persisted)
+
+	Keys     [][]byte      // Stored keys (leaves) or separator keys (inner nodes)
+	Values   []interface{} // Stored values (only used for leaves)
+	Children []uint64      // Storage locations of children (only used for inner nodes)
+}
+
+/*
+NewBTree creates a new BTree.
+*/
+func NewBTree(sm storage.Manager) (*BTree, error) {
+	tree := &BTree{}
+
+	tree.Root = &btreeNode{tree, 0, sm, nil, nil, nil}
+
+	loc, err := sm.Insert(tree.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Root.loc = loc
+	tree.mutex = &sync.Mutex{}
+
+	return tree, nil
+}
+
+/*
+LoadBTree fetches a BTree from storage.
+*/
+func LoadBTree(sm storage.Manager, loc uint64) (*BTree, error) {
+	tree := &BTree{nil, &sync.Mutex{}}
+
+	root, err := (&btreeNode{tree, 0, sm, nil, nil, nil}).fetchNode(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Root = root
+
+	return tree, nil
+}
+
+/*
+Location returns the BTree location on disk. The location does not change
+as the tree grows.
+*/
+func (t *BTree) Location() uint64 {
+	return t.Root.loc
+}
+
+/*
+Get gets a value for a given key.
+*/
+func (t *BTree) Get(key []byte) (interface{}, error) {
+	lockprof.Lock(t.mutex, lockprof.LockBTree, "Get")
+	defer t.mutex.Unlock()
+
+	leaf, err := t.Root.findLeaf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if i, ok := leaf.search(key); ok {
+		return leaf.Values[i], nil
+	}
+
+	return nil, nil
+}
+
+/*
+Exists checks if an element exists.
+*/
+func (t *BTree) Exists(key []byte) (bool, error) {
+	lockprof.Lock(t.mutex, lockprof.LockBTree, "Exists")
+	defer t.mutex.Unlock()
+
+	leaf, err := t.Root.findLeaf(key)
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := leaf.search(key)
+
+	return ok, nil
+}
+
+/*
+Put adds or updates a new key / value pair. Putting a nil value removes the
+key.
+*/
+func (t *BTree) Put(key []byte, value interface{}) (interface{}, error) {
+	if value == nil {
+		return t.Remove(key)
+	}
+
+	lockprof.Lock(t.mutex, lockprof.LockBTree, "Put")
+	defer t.mutex.Unlock()
+
+	root := t.Root
+
+	old, sep, right, err := root.put(key, value)
+	if err != nil || right == nil {
+		return old, err
+	}
+
+	// The root node was split - move its left half to a new node so the
+	// root can stay at its location
+
+	left := &btreeNode{t, 0, root.sm, root.Keys, root.Values, root.Children}
+
+	loc, err := root.sm.Insert(left)
+	if err != nil {
+		return nil, err
+	}
+
+	left.loc = loc
+
+	root.Keys = [][]byte{sep}
+	root.Values = nil
+	root.Children = []uint64{left.loc, right.loc}
+
+	return old, root.sm.Update(root.loc, root)
+}
+
+/*
+Remove removes a key / value pair. Nodes are not merged with their siblings
+if they become sparsely populated - only empty nodes are removed from the
+tree.
+*/
+func (t *BTree) Remove(key []byte) (interface{}, error) {
+	lockprof.Lock(t.mutex, lockprof.LockBTree, "Remove")
+	defer t.mutex.Unlock()
+
+	return t.Root.remove(key)
+}
+
+/*
+GetRange returns all keys k (and their values) with from <= k < to in
+ascending order. A nil value for from or to means that the range is not
+limited in this direction.
+*/
+func (t *BTree) GetRange(from []byte, to []byte) ([][]byte, []interface{}, error) {
+	var keys [][]byte
+	var values []interface{}
+
+	lockprof.Lock(t.mutex, lockprof.LockBTree, "GetRange")
+	defer t.mutex.Unlock()
+
+	_, err := t.Root.walk(from, to, func(key []byte, value interface{}) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		return true
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return keys, values, nil
+}
+
+/*
+String returns a string representation of this tree.
+*/
+func (t *BTree) String() string {
+	lockprof.Lock(t.mutex, lockprof.LockBTree, "String")
+	defer t.mutex.Unlock()
+
+	return fmt.Sprintf("BTree: %v (%v)\n%v", t.Root.sm.Name(), t.Root.loc, t.Root.String(0))
+}
+
+/*
+fetchNode fetches a BTree node from the storage.
+*/
+func (n *btreeNode) fetchNode(loc uint64) (*btreeNode, error) {
+	var node *btreeNode
+
+	if obj, _ := n.sm.FetchCached(loc); obj == nil {
+		var res btreeNode
+		if err := n.sm.Fetch(loc, &res); err != nil {
+			return nil, err
+		}
+		node = &res
+	} else {
+		node = obj.(*btreeNode)
+	}
+
+	node.tree = n.tree
+	node.loc = loc
+	node.sm = n.sm
+
+	return node, nil
+}
+
+/*
+isLeaf returns if this node is a leaf.
+*/
+func (n *btreeNode) isLeaf() bool {
+	return n.Children == nil
+}
+
+/*
+search returns the position of a key in a leaf and if the key was found. If
+the key was not found the position is where the key would be inserted.
+*/
+func (n *btreeNode) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.Keys), func(i int) bool {
+		return bytes.Compare(n.Keys[i], key) >= 0
+	})
+
+	return i, i < len(n.Keys) && bytes.Equal(n.Keys[i], key)
+}
+
+/*
+childIndex returns the index of the child of an inner node which contains a
+given key.
+*/
+func (n *btreeNode) childIndex(key []byte) int {
+	return sort.Search(len(n.Keys), func(i int) bool {
+		return bytes.Compare(n.Keys[i], key) > 0
+	})
+}
+
+/*
+findLeaf returns the leaf which contains a given key.
+*/
+func (n *btreeNode) findLeaf(key []byte) (*btreeNode, error) {
+	var err error
+
+	node := n
+
+	for err == nil && !node.isLeaf() {
+		node, err = node.fetchNode(node.Children[node.childIndex(key)])
+	}
+
+	return node, err
+}
+
+/*
+put adds or updates a key / value pair in the subtree of this node. If the
+node had to be split the separator key and the new right sibling are
+returned.
+*/
+func (n *btreeNode) put(key []byte, value interface{}) (interface{}, []byte, *btreeNode, error) {
+
+	if n.isLeaf() {
+		i, ok := n.search(key)
+
+		if ok {
+			old := n.Values[i]
+			n.Values[i] = value
+
+			return old, nil, nil, n.sm.Update(n.loc, n)
+		}
+
+		n.Keys = append(n.Keys, nil)
+		copy(n.Keys[i+1:], n.Keys[i:])
+		n.Keys[i] = key
+
+		n.Values = append(n.Values, nil)
+		copy(n.Values[i+1:], n.Values[i:])
+		n.Values[i] = value
+
+		if len(n.Keys) <= MaxBTreeNodeElements {
+			return nil, nil, nil, n.sm.Update(n.loc, n)
+		}
+
+		mid := len(n.Keys) / 2
+
+		right := &btreeNode{n.tree, 0, n.sm, append([][]byte(nil), n.Keys[mid:]...),
+			append([]interface{}(nil), n.Values[mid:]...), nil}
+
+		n.Keys = n.Keys[:mid]
+		n.Values = n.Values[:mid]
+
+		sep, right, err := n.split(right, right.Keys[0])
+
+		return nil, sep, right, err
+	}
+
+	i := n.childIndex(key)
+
+	child, err := n.fetchNode(n.Children[i])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	old, sep, childRight, err := child.put(key, value)
+	if err != nil || childRight == nil {
+		return old, nil, nil, err
+	}
+
+	// The child was split - add the new sibling after the child
+
+	n.Keys = append(n.Keys, nil)
+	copy(n.Keys[i+1:], n.Keys[i:])
+	n.Keys[i] = sep
+
+	n.Children = append(n.Children, 0)
+	copy(n.Children[i+2:], n.Children[i+1:])
+	n.Children[i+1] = childRight.loc
+
+	if len(n.Children) <= MaxBTreeNodeElements {
+		return old, nil, nil, n.sm.Update(n.loc, n)
+	}
+
+	mid := len(n.Children) / 2
+
+	right := &btreeNode{n.tree, 0, n.sm, append([][]byte(nil), n.Keys[mid:]...),
+		nil, append([]uint64(nil), n.Children[mid:]...)}
+
+	sep = n.Keys[mid-1]
+
+	n.Keys = n.Keys[:mid-1]
+	n.Children = n.Children[:mid]
+
+	sep, right, err = n.split(right, sep)
+
+	return old, sep, right, err
+}
+
+/*
+split stores the right half of this node after the node was split.
+*/
+func (n *btreeNode) split(right *btreeNode, sep []byte) ([]byte, *btreeNode, error) {
+	loc, err := n.sm.Insert(right)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	right.loc = loc
+
+	if err := n.sm.Update(n.loc, n); err != nil {
+
+		// Try to clean up
+
+		n.sm.Free(loc)
+
+		return nil, nil, err
+	}
+
+	return sep, right, nil
+}
+
+/*
+remove removes a key / value pair from the subtree of this node.
+*/
+func (n *btreeNode) remove(key []byte) (interface{}, error) {
+
+	if n.isLeaf() {
+		i, ok := n.search(key)
+
+		if !ok {
+			return nil, nil
+		}
+
+		old := n.Values[i]
+
+		n.Keys = append(n.Keys[:i], n.Keys[i+1:]...)
+		n.Values = append(n.Values[:i], n.Values[i+1:]...)
+
+		return old, n.sm.Update(n.loc, n)
+	}
+
+	i := n.childIndex(key)
+	loc := n.Children[i]
+
+	child, err := n.fetchNode(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := child.remove(key)
+	if err != nil || len(child.Keys) > 0 || len(child.Children) > 0 {
+		return old, err
+	}
+
+	// Remove the child if it is empty - the key range of the child is
+	// taken over by one of its siblings
+
+	if i > 0 {
+		n.Keys = append(n.Keys[:i-1], n.Keys[i:]...)
+	} else if len(n.Keys) > 0 {
+		n.Keys = n.Keys[1:]
+	}
+
+	n.Children = append(n.Children[:i], n.Children[i+1:]...)
+
+	if len(n.Children) == 0 {
+
+		// An inner node without children is an empty leaf
+
+		n.Keys = nil
+		n.Children = nil
+	}
+
+	if err := n.sm.Update(n.loc, n); err != nil {
+		return nil, err
+	}
+
+	return old, n.sm.Free(loc)
+}
+
+/*
+walk visits all key / value pairs with from <= key < to of the subtree of
+this node in ascending order until the visit function returns false.
+Returns false if the walk was stopped.
+*/
+func (n *btreeNode) walk(from []byte, to []byte, visit func([]byte, interface{}) bool) (bool, error) {
+
+	if n.isLeaf() {
+		start := 0
+
+		if from != nil {
+			start, _ = n.search(from)
+		}
+
+		for i := start; i < len(n.Keys); i++ {
+			if to != nil && bytes.Compare(n.Keys[i], to) >= 0 {
+				return false, nil
+			}
+			if !visit(n.Keys[i], n.Values[i]) {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	}
+
+	start := 0
+
+	if from != nil {
+		start = n.childIndex(from)
+	}
+
+	for i := start; i < len(n.Children); i++ {
+
+		if to != nil && i > 0 && bytes.Compare(n.Keys[i-1], to) >= 0 {
+			return false, nil
+		}
+
+		child, err := n.fetchNode(n.Children[i])
+		if err != nil {
+			return false, err
+		}
+
+		if cont, err := child.walk(from, to, visit); !cont || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+/*
+String returns a string representation of this node.
+*/
+func (n *btreeNode) String(depth int) string {
+	buf := new(bytes.Buffer)
+	indent := func(d int) {
+		for j := 0; j < d; j++ {
+			buf.WriteString("  ")
+		}
+	}
+
+	indent(depth)
+
+	if n.isLeaf() {
+		buf.WriteString(fmt.Sprintf("Leaf %v (%v element%s)\n", n.loc,
+			len(n.Keys), stringutil.Plural(len(n.Keys))))
+
+		for i, key := range n.Keys {
+			indent(depth + 1)
+			buf.WriteString(fmt.Sprintf("%v - %v\n", key, n.Values[i]))
+		}
+
+		return buf.String()
+	}
+
+	buf.WriteString(fmt.Sprintf("Node %v (keys: %v)\n", n.loc, n.Keys))
+
+	for _, loc := range n.Children {
+
+		child, err := n.fetchNode(loc)
+		if err != nil {
+			indent(depth + 1)
+			buf.WriteString(err.Error())
+			buf.WriteString("\n")
+			continue
+		}
+
+		buf.WriteString(child.String(depth + 1))
+	}
+
+	return buf.String()
+}
+
+/*
+BTreeIterator data structure
+*/
+type BTreeIterator struct {
+	tree      *BTree        // Tree to iterate
+	from      []byte        // Key from which the next keys are read
+	to        []byte        // Upper bound of the iterated keys (exclusive)
+	skip      bool          // Flag if the from key was already returned
+	keys      [][]byte      // Read keys which were not returned yet
+	values    []interface{} // Read values which were not returned yet
+	LastError error         // Last encountered error
+}
+
+/*
+NewBTreeIterator creates a new BTreeIterator which visits all keys in
+ascending order.
+*/
+func NewBTreeIterator(tree *BTree) *BTreeIterator {
+	return NewBTreeRangeIterator(tree, nil, nil)
+}
+
+/*
+NewBTreeRangeIterator creates a new BTreeIterator which visits all keys k
+with from <= k < to in ascending order. A nil value for from or to means that
+the range is not limited in this direction. The tree may change behind the
+iterator's back - the iterator always continues after the last read key.
+*/
+func NewBTreeRangeIterator(tree *BTree, from []byte, to []byte) *BTreeIterator {
+	it := &BTreeIterator{tree, from, to, false, nil, nil, nil}
+
+	it.read()
+
+	return it
+}
+
+/*
+HasNext returns if there is a next key / value pair.
+*/
+func (it *BTreeIterator) HasNext() bool {
+	return len(it.keys) > 0
+}
+
+/*
+Next returns the next key / value pair.
+*/
+func (it *BTreeIterator) Next() ([]byte, interface{}) {
+	if len(it.keys) == 0 {
+		return nil, nil
+	}
+
+	key, value := it.keys[0], it.values[0]
+
+	it.keys = it.keys[1:]
+	it.values = it.values[1:]
+
+	if len(it.keys) == 0 {
+		it.read()
+	}
+
+	return key, value
+}
+
+/*
+read reads the next batch of key / value pairs from the tree.
+*/
+func (it *BTreeIterator) read() {
+	lockprof.Lock(it.tree.mutex, lockprof.LockBTree, "Iterator")
+	defer it.tree.mutex.Unlock()
+
+	_, err := it.tree.Root.walk(it.from, it.to, func(key []byte, value interface{}) bool {
+		if it.skip && bytes.Equal(key, it.from) {
+			return true
+		}
+
+		it.keys = append(it.keys, key)
+		it.values = append(it.values, value)
+
+		return len(it.keys) < MaxBTreeNodeElements
+	})
+
+	if err != nil {
+
+		// There was a serious error terminate the iterator
+
+		it.LastError = err
+		it.keys = nil
+		it.values = nil
+
+	} else if len(it.keys) > 0 {
+		it.from = it.keys[len(it.keys)-1]
+		it.skip = true
+	}
+}
@@ -0,0 +1,349 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"devt.de/eliasdb/storage"
+	"devt.de/eliasdb/storage/file"
+)
+
+func TestBTreeSerialization(t *testing.T) {
+	sm := storage.NewDiskStorageManager(DBDIR+"/btree1", false, false, false, false)
+
+	btree, err := NewBTree(sm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc := btree.Location()
+
+	for i := 0; i < 1000; i++ {
+		btree.Put([]byte(fmt.Sprintf("key%04d", i)), fmt.Sprint("value", i))
+	}
+
+	if btree.Location() != loc {
+		t.Error("Unexpected location:", btree.Location())
+		return
+	}
+
+	sm.Close()
+
+	sm2 := storage.NewDiskStorageManager(DBDIR+"/btree1", false, false, false, false)
+
+	btree2, _ := LoadBTree(sm2, loc)
+
+	if res, err := btree2.Get([]byte("key0512")); res != "value512" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if keys, values, err := btree2.GetRange([]byte("key0998"), nil); fmt.Sprintf("%s", keys) != "[key0998 key0999]" ||
+		fmt.Sprint(values) != "[value998 value999]" || err != nil {
+		t.Error("Unexpected result:", keys, values, err)
+		return
+	}
+
+	sm2.Close()
+}
+
+func TestBTree(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	sm.AccessMap[1] = storage.AccessInsertError
+
+	if _, err := NewBTree(sm); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected new tree result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, 1)
+
+	btree, _ := NewBTree(sm)
+
+	if res, err := btree.Get([]byte("test")); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Add keys in random order
+
+	perm := rand.Perm(2000)
+
+	for _, i := range perm {
+		if res, err := btree.Put([]byte(fmt.Sprintf("key%04d", i)), i); res != nil || err != nil {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+
+	if !strings.HasPrefix(btree.String(), "BTree: testsm (1)\nNode 1 (keys: ") {
+		t.Error("Unexpected tree:", btree.String())
+		return
+	}
+
+	if res, err := btree.Put([]byte("key0042"), 4242); res != 42 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := btree.Exists([]byte("key0042")); !res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := btree.Exists([]byte("key2000")); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Check ranges
+
+	keys, values, err := btree.GetRange([]byte("key0040"), []byte("key0045"))
+
+	if fmt.Sprintf("%s", keys) != "[key0040 key0041 key0042 key0043 key0044]" ||
+		fmt.Sprint(values) != "[40 41 4242 43 44]" || err != nil {
+		t.Error("Unexpected result:", keys, values, err)
+		return
+	}
+
+	keys, _, _ = btree.GetRange([]byte("key00405"), []byte("key0042"))
+
+	if fmt.Sprintf("%s", keys) != "[key0041]" {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	keys, _, _ = btree.GetRange(nil, []byte("key0002"))
+
+	if fmt.Sprintf("%s", keys) != "[key0000 key0001]" {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	if keys, _, _ = btree.GetRange(nil, nil); len(keys) != 2000 {
+		t.Error("Unexpected result:", len(keys))
+		return
+	}
+
+	if keys, _, _ = btree.GetRange([]byte("key1"), []byte("key0")); len(keys) != 0 {
+		t.Error("Unexpected result:", keys)
+		return
+	}
+
+	// Iterate the tree
+
+	it := NewBTreeIterator(btree)
+
+	for i := 0; i < 2000; i++ {
+
+		if !it.HasNext() {
+			t.Error("Iterator stopped early:", i)
+			return
+		}
+
+		if key, _ := it.Next(); string(key) != fmt.Sprintf("key%04d", i) {
+			t.Error("Unexpected key:", string(key))
+			return
+		}
+	}
+
+	if key, value := it.Next(); it.HasNext() || key != nil || value != nil || it.LastError != nil {
+		t.Error("Unexpected iterator state:", key, value, it.LastError)
+		return
+	}
+
+	// Iterator continues after the last read key if the tree changes
+
+	it = NewBTreeRangeIterator(btree, nil, []byte("key0130"))
+
+	it.Next()
+
+	btree.Put([]byte("key0063a"), 1)
+	btree.Remove([]byte("key0100"))
+
+	var itkeys []string
+
+	for it.HasNext() {
+		key, _ := it.Next()
+		itkeys = append(itkeys, string(key))
+	}
+
+	if len(itkeys) != 129 || itkeys[63] != "key0063a" || itkeys[99] != "key0099" || itkeys[100] != "key0101" {
+		t.Error("Unexpected iterated keys:", itkeys)
+		return
+	}
+
+	btree.Remove([]byte("key0063a"))
+	btree.Put([]byte("key0100"), 100)
+
+	// Remove all keys in random order
+
+	for _, i := range rand.Perm(2000) {
+		expected := i
+
+		if i == 42 {
+			expected = 4242
+		}
+
+		if res, err := btree.Remove([]byte(fmt.Sprintf("key%04d", i))); res != expected || err != nil {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+
+	if res, err := btree.Remove([]byte("key0001")); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := btree.String(); res != "BTree: testsm (1)\nLeaf 1 (0 element)\n" {
+		t.Error("Unexpected tree:", res)
+		return
+	}
+
+	// Check that removing a nil value removes a key
+
+	btree.Put([]byte("a"), "b")
+
+	if res, err := btree.Put([]byte("a"), nil); res != "b" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := btree.Exists([]byte("a")); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
+
+func TestBTreeErrors(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	btree, _ := NewBTree(sm)
+
+	for i := 0; i < 80; i++ {
+		btree.Put([]byte(fmt.Sprintf("key%04d", i)), i)
+	}
+
+	// The root node was split into two leaves
+
+	if res := btree.Root.Children; len(res) != 2 || res[0] != 3 || res[1] != 2 {
+		t.Error("Unexpected children:", res)
+		return
+	}
+
+	sm.AccessMap[2] = storage.AccessCacheAndFetchError
+
+	if res, err := btree.Get([]byte("key0079")); res != nil || err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := btree.Exists([]byte("key0079")); res || err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := btree.Put([]byte("key0079"), 1); res != nil || err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := btree.Remove([]byte("key0079")); res != nil || err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if keys, _, err := btree.GetRange([]byte("key0010"), nil); keys != nil || err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", keys, err)
+		return
+	}
+
+	if res := btree.String(); !strings.Contains(res, "Slot not found (testsm - Location:2)") {
+		t.Error("Unexpected tree:", res)
+		return
+	}
+
+	// Iterators stop on errors
+
+	it := NewBTreeRangeIterator(btree, []byte("key0040"), nil)
+
+	if it.HasNext() || it.LastError != storage.ErrSlotNotFound {
+		t.Error("Unexpected iterator state:", it.HasNext(), it.LastError)
+		return
+	}
+
+	if _, err := LoadBTree(sm, 2); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, 2)
+
+	// Errors during splits
+
+	putUntilError := func(prefix string) error {
+		for i := 0; i < 100; i++ {
+			if _, err := btree.Put([]byte(fmt.Sprintf("%v%04d", prefix, i)), i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	sm.AccessMap[sm.LocCount] = storage.AccessInsertError
+
+	if err := putUntilError("key0050a"); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, sm.LocCount)
+
+	sm.AccessMap[sm.LocCount] = storage.AccessInsertError
+
+	if err := putUntilError("key0051a"); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, sm.LocCount)
+
+	sm.AccessMap[3] = storage.AccessUpdateError
+
+	if err := putUntilError("key0010a"); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := btree.Remove([]byte("key0010")); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, 3)
+
+	sm.AccessMap[1] = storage.AccessUpdateError
+
+	if err := putUntilError("key0020a"); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, 1)
+}
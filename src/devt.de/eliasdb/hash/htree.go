@@ -22,13 +22,44 @@ The last link is always to a bucket. The default tree has 4 levels each with
 
 Hash buckets are on the lowest level of the tree and contain actual keys and
 values. The object stores multiple keys and values if there are hash collisions.
+The common prefix of all keys in a bucket is stored only once (keys of graph
+indices share long prefixes).
 In a sparsely populated tree buckets can also be found on the upper levels.
 
 Iterator
 
 Entries in the HTree can be iterated by using an HTreeIterator. The HTree may
 change behind the iterator's back. The iterator will try to cope with best
-effort and only report an error as a last resort.
+effort and only report an error as a last resort. An iterator which is created
+with IteratorPrefix only returns keys with a certain prefix.
+
+Concurrency
+
+Operations on single keys of a HTree can run in parallel. Pages are latched
+while the tree is traversed (lock coupling) so only operations which change the
+same page wait for each other. Operations on the whole tree (e.g. PutBatch or
+Stats) lock the tree exclusively. A tree can be rebuilt (see Rebuild) while
+readers are using it.
+
+Bloom filter
+
+A HTree can have a Bloom filter of all its keys (see EnableBloomFilter). Most
+lookups of keys which do not exist are then answered without reading the tree.
+
+Multiple values
+
+A MultiHTree stores any number of values under the same key. Each value is a
+separate entry of a HTree so a single value can be added or removed without
+rewriting the other values of the key.
+An IndexedHTree is a HTree with a reverse index which maps values back
+to their keys.
+
+Ordered index
+
+The BTree is a persistent B+tree which keeps its keys sorted. It supports
+range queries via GetRange and ordered iteration via a BTreeIterator. Keys
+are compared byte by byte - numbers need to be encoded so that their byte
+order matches their numeric order.
 
 Hash function
 
@@ -41,8 +72,11 @@ package hash
 
 import (
 	"fmt"
+	"reflect"
 	"sync"
 
+	"devt.de/common/errorutil"
+	"devt.de/eliasdb/lockprof"
 	"devt.de/eliasdb/storage"
 )
 
@@ -72,8 +106,9 @@ const MaxBucketElements = 8
 HTree data structure
 */
 type HTree struct {
-	Root  *htreePage  // Root page of the HTree
-	mutex *sync.Mutex // Mutex to protect tree operations
+	Root         *htreePage    // Root page of the HTree
+	mutex        *sync.RWMutex // Lock to protect tree operations (operations on single keys share it)
+	rebuildMutex *sync.RWMutex // Lock which is shared by writers and held exclusively during a rebuild
 }
 
 /*
@@ -87,9 +122,11 @@ type htreeNode struct {
 
 	Depth      byte          // Depth of this node
 	Children   []uint64      // Storage locations of children (only used for pages)
-	Keys       [][]byte      // Stored keys (only used for buckets)
+	Keys       [][]byte      // Stored keys without their common prefix (only used for buckets)
+	Prefix     []byte        // Common prefix of all stored keys (only used for buckets)
 	Values     []interface{} // Stored values (only used for buckets)
 	BucketSize byte          // Bucket size (only used for buckets)
+	Filter     uint64        // Storage location of the Bloom filter (only used for the root page)
 }
 
 /*
@@ -133,7 +170,8 @@ func NewHTree(sm storage.Manager) (*HTree, error) {
 	tree.Root.loc = loc
 	tree.Root.sm = sm
 
-	tree.mutex = &sync.Mutex{}
+	tree.mutex = &sync.RWMutex{}
+	tree.rebuildMutex = &sync.RWMutex{}
 
 	return tree, nil
 }
@@ -155,15 +193,16 @@ func LoadHTree(sm storage.Manager, loc uint64) (*HTree, error) {
 		if err := sm.Fetch(loc, &res); err != nil {
 			return nil, err
 		}
-		tree = &HTree{&htreePage{&res}, nil}
+		tree = &HTree{&htreePage{&res}, nil, nil}
 	} else {
-		tree = &HTree{&htreePage{obj.(*htreeNode)}, nil}
+		tree = &HTree{&htreePage{obj.(*htreeNode)}, nil, nil}
 	}
 
 	tree.Root.loc = loc
 	tree.Root.sm = sm
 
-	tree.mutex = &sync.Mutex{}
+	tree.mutex = &sync.RWMutex{}
+	tree.rebuildMutex = &sync.RWMutex{}
 
 	return tree, nil
 }
@@ -179,10 +218,14 @@ func (t *HTree) Location() uint64 {
 Get gets a value for a given key.
 */
 func (t *HTree) Get(key []byte) (interface{}, error) {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
+	lockprof.RLock(t.mutex, lockprof.LockHTree, "Get")
+	defer t.mutex.RUnlock()
+
+	if !t.filterMayContain(key) {
+		return nil, nil
+	}
 
-	res, _, err := t.Root.Get(key)
+	res, _, err := t.get(key, "Get")
 
 	return res, err
 }
@@ -191,10 +234,14 @@ func (t *HTree) Get(key []byte) (interface{}, error) {
 GetValueAndLocation returns the value and the storage location for a given key.
 */
 func (t *HTree) GetValueAndLocation(key []byte) (interface{}, uint64, error) {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
+	lockprof.RLock(t.mutex, lockprof.LockHTree, "GetValueAndLocation")
+	defer t.mutex.RUnlock()
 
-	res, bucket, err := t.Root.Get(key)
+	if !t.filterMayContain(key) {
+		return nil, 0, nil
+	}
+
+	res, bucket, err := t.get(key, "GetValueAndLocation")
 
 	if bucket != nil {
 		return res, bucket.loc, err
@@ -203,41 +250,234 @@ func (t *HTree) GetValueAndLocation(key []byte) (interface{}, uint64, error) {
 	return res, 0, err
 }
 
+/*
+get looks up a key while holding the read latch of the page which holds its bucket.
+*/
+func (t *HTree) get(key []byte, op string) (interface{}, *htreeBucket, error) {
+	lp, err := t.latchKeyPath(key, false, nil, op)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer lp.releaseAll()
+
+	return lp.bottom().Get(key)
+}
+
 /*
 Exists checks if an element exists.
 */
 func (t *HTree) Exists(key []byte) (bool, error) {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
+	lockprof.RLock(t.mutex, lockprof.LockHTree, "Exists")
+	defer t.mutex.RUnlock()
+
+	if !t.filterMayContain(key) {
+		return false, nil
+	}
 
-	return t.Root.Exists(key)
+	lp, err := t.latchKeyPath(key, false, nil, "Exists")
+	if err != nil {
+		return false, err
+	}
+
+	defer lp.releaseAll()
+
+	return lp.bottom().Exists(key)
 }
 
 /*
-Put adds or updates a new key / value pair.
+Put adds or updates a new key / value pair. Puts of keys in different buckets
+can run in parallel.
 */
 func (t *HTree) Put(key []byte, value interface{}) (interface{}, error) {
-	t.mutex.Lock()
+
+	// Putting a nil values will remove the element
+
+	if value == nil {
+		return t.Remove(key)
+	}
+
+	var existing interface{}
+
+	err := t.update(key, false, "Put", func(page *htreePage) (bool, error) {
+		var err error
+
+		existing, err = page.Put(key, value)
+
+		return existing == nil, err
+	})
+
+	return existing, err
+}
+
+/*
+PutIf replaces the value of a key only if its current value is equal to a
+given old value. The old value must be nil if the key should not exist yet.
+Putting a nil value removes the key. Values are compared by their content.
+Returns if the value was replaced.
+*/
+func (t *HTree) PutIf(key []byte, old interface{}, value interface{}) (bool, error) {
+	var replaced bool
+
+	err := t.update(key, value == nil, "PutIf", func(page *htreePage) (bool, error) {
+		current, _, err := page.Get(key)
+
+		if err != nil || !reflect.DeepEqual(current, old) {
+			return false, err
+		}
+
+		_, err = page.Put(key, value)
+
+		replaced = err == nil
+
+		return current == nil && value != nil, err
+	})
+
+	return replaced, err
+}
+
+/*
+PutBatch adds or updates a list of key / value pairs. The pairs are grouped by
+the page or bucket which stores them and every touched node is written only
+once. If a key appears more than once the last value is stored. Putting a nil
+value removes the key. If an error occurs some of the pairs may have been
+written.
+*/
+func (t *HTree) PutBatch(keys [][]byte, values []interface{}) error {
+	if len(keys) != len(values) {
+		return errorutil.NewCategorizedError(errorutil.ErrInvalid,
+			fmt.Sprintf("Number of keys (%v) and values (%v) differ", len(keys), len(values)))
+	}
+
+	lockprof.RLock(t.rebuildMutex, lockprof.LockHTree, "PutBatch")
+	defer t.rebuildMutex.RUnlock()
+
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "PutBatch")
 	defer t.mutex.Unlock()
 
-	return t.Root.Put(key, value)
+	// Only the last value of a key is relevant
+
+	last := make(map[string]int, len(keys))
+
+	for i, key := range keys {
+		last[string(key)] = i
+	}
+
+	var putKeys, removeKeys [][]byte
+	var putValues []interface{}
+
+	for i, key := range keys {
+		if last[string(key)] != i {
+			continue
+		} else if values[i] == nil {
+			removeKeys = append(removeKeys, key)
+		} else {
+			putKeys = append(putKeys, key)
+			putValues = append(putValues, values[i])
+		}
+	}
+
+	if err := t.Root.putBatch(putKeys, putValues); err != nil {
+		return err
+	}
+
+	for _, key := range putKeys {
+		if rebuild, err := t.filterAdd(key); rebuild || err != nil {
+			if err := t.fixFilter(t.Root.Filter, err); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range removeKeys {
+		if _, err := t.Root.Remove(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 /*
 Remove removes a key / value pair.
 */
 func (t *HTree) Remove(key []byte) (interface{}, error) {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
+	var ret interface{}
+
+	err := t.update(key, true, "Remove", func(page *htreePage) (bool, error) {
+		var err error
+
+		ret, err = page.Remove(key)
+
+		return false, err
+	})
+
+	return ret, err
+}
+
+/*
+update runs an operation which changes the bucket of a given key. Operations
+which might remove the bucket (remove is set) get the highest page which might
+change, other operations get the page which holds the bucket. The operation
+returns if it added the key to the tree.
+*/
+func (t *HTree) update(key []byte, remove bool, op string,
+	f func(page *htreePage) (bool, error)) error {
+
+	lockprof.RLock(t.rebuildMutex, lockprof.LockHTree, op)
+	defer t.rebuildMutex.RUnlock()
+
+	lockprof.RLock(t.mutex, lockprof.LockHTree, op)
+
+	// Inserts only change the page which holds the bucket of the key - new
+	// pages are created below it. Pages which might become empty through a
+	// removal are removed from their parent - the latches of the parents
+	// need to be kept.
+
+	var keep func(page *htreePage) bool
+
+	if remove {
+		keep = func(page *htreePage) bool {
+			return page.usedChildren() < 2
+		}
+	}
+
+	lp, err := t.latchKeyPath(key, true, keep, op)
+	if err != nil {
+		t.mutex.RUnlock()
+		return err
+	}
+
+	added, err := f(lp.top())
+
+	lp.releaseAll()
+
+	var rebuild bool
+	var ferr error
+
+	filter := t.Root.Filter
+
+	if err == nil && added {
+		rebuild, ferr = t.filterAdd(key)
+	}
+
+	t.mutex.RUnlock()
+
+	if rebuild || ferr != nil {
+		lockprof.Lock(t.mutex, lockprof.LockHTree, op)
+		defer t.mutex.Unlock()
+
+		err = t.fixFilter(filter, ferr)
+	}
 
-	return t.Root.Remove(key)
+	return err
 }
 
 /*
 String returns a string representation of this tree.
 */
 func (t *HTree) String() string {
-	t.mutex.Lock()
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "String")
 	defer t.mutex.Unlock()
 
 	return fmt.Sprintf("HTree: %v (%v)\n%v", t.Root.sm.Name(), t.Root.loc, t.Root.String())
@@ -11,9 +11,11 @@
 package hash
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 
 	"devt.de/common/fileutil"
@@ -61,6 +63,7 @@ func TestHTreeSerialization(t *testing.T) {
 	loc := htree.Location()
 
 	htree.Put([]byte("test"), "testvalue1")
+	htree.EnableBloomFilter()
 
 	sm.Close()
 
@@ -73,6 +76,11 @@ func TestHTreeSerialization(t *testing.T) {
 		return
 	}
 
+	if res, err := htree2.Exists([]byte("test2")); res || err != nil || !htree2.HasBloomFilter() {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
 	sm2.Close()
 }
 
@@ -81,7 +89,7 @@ func TestHTree(t *testing.T) {
 
 	sm.AccessMap[1] = storage.AccessInsertError
 
-	if _, err := NewHTree(sm); err != file.ErrAlreadyInUse {
+	if _, err := NewHTree(sm); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected new tree result:", err)
 		return
 	}
@@ -179,3 +187,279 @@ func TestHTree(t *testing.T) {
 		return
 	}
 }
+
+/*
+writeCountingStorageManager counts all writes to a storage manager.
+*/
+type writeCountingStorageManager struct {
+	*storage.MemoryStorageManager
+	writes int
+}
+
+func (sm *writeCountingStorageManager) Insert(o interface{}) (uint64, error) {
+	sm.writes++
+	return sm.MemoryStorageManager.Insert(o)
+}
+
+func (sm *writeCountingStorageManager) Update(loc uint64, o interface{}) error {
+	sm.writes++
+	return sm.MemoryStorageManager.Update(loc, o)
+}
+
+/*
+compareHTrees checks that two trees contain the same key / value pairs.
+*/
+func compareHTrees(htree1 *HTree, htree2 *HTree) error {
+	count := 0
+
+	for it := NewHTreeIterator(htree1); it.HasNext(); count++ {
+		k, v := it.Next()
+
+		if v2, _ := htree2.Get(k); v != v2 {
+			return fmt.Errorf("Unexpected value for %s: %v (expected %v)", k, v, v2)
+		}
+	}
+
+	for it := NewHTreeIterator(htree2); it.HasNext(); it.Next() {
+		count--
+	}
+
+	if count != 0 {
+		return fmt.Errorf("Trees have a different number of keys")
+	}
+
+	return nil
+}
+
+func TestHTreePutBatch(t *testing.T) {
+	sm := &writeCountingStorageManager{storage.NewMemoryStorageManager("testsm"), 0}
+
+	htree, _ := NewHTree(sm)
+
+	if err := htree.PutBatch(make([][]byte, 2), nil); err == nil ||
+		err.Error() != "Number of keys (2) and values (0) differ" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	var keys [][]byte
+	var values []interface{}
+
+	for i := 0; i < 5000; i++ {
+		keys = append(keys, []byte(fmt.Sprint("key", i)))
+		values = append(values, i)
+	}
+
+	sm.writes = 0
+
+	if err := htree.PutBatch(keys, values); err != nil {
+		t.Error(err)
+		return
+	}
+
+	writes := sm.writes
+
+	// Compare with a tree which was filled one key at a time
+
+	sm2 := &writeCountingStorageManager{storage.NewMemoryStorageManager("testsm"), 0}
+
+	htree2, _ := NewHTree(sm2)
+
+	for i, key := range keys {
+		htree2.Put(key, values[i])
+	}
+
+	if writes >= sm2.writes/2 {
+		t.Error("Unexpected number of writes:", writes, sm2.writes)
+		return
+	}
+
+	if err := compareHTrees(htree, htree2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Update existing keys, add new keys and remove keys - the last value of
+	// a key is stored
+
+	keys = [][]byte{[]byte("key1"), []byte("key2"), []byte("new1"), []byte("key1"), []byte("key3"), []byte("new2")}
+	values = []interface{}{"a", "b", "c", "d", nil, nil}
+
+	if err := htree.PutBatch(keys, values); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, key := range keys {
+		htree2.Put(key, values[i])
+	}
+
+	for _, key := range []string{"key1", "key2", "new1", "key3", "new2", "key4"} {
+		res, _ := htree.Get([]byte(key))
+
+		if res2, _ := htree2.Get([]byte(key)); res != res2 {
+			t.Error("Unexpected result:", key, res, res2)
+			return
+		}
+	}
+
+	if res, _ := htree.Get([]byte("key1")); res != "d" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Fill buckets until they are replaced by pages
+
+	for i := 0; i < 10; i++ {
+		keys, values = nil, nil
+
+		for j := 0; j < 2000; j++ {
+			keys = append(keys, []byte(fmt.Sprint("batch", i, "key", j)))
+			values = append(values, j)
+		}
+
+		if err := htree.PutBatch(keys, values); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for j, key := range keys {
+			htree2.Put(key, values[j])
+		}
+	}
+
+	if err := compareHTrees(htree, htree2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Test errors
+
+	sm.AccessMap[sm.LocCount] = storage.AccessInsertError
+
+	if err := htree.PutBatch([][]byte{[]byte("error")}, []interface{}{1}); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, sm.LocCount)
+
+	_, loc, _ := htree.GetValueAndLocation([]byte("key1"))
+
+	sm.AccessMap[loc] = storage.AccessUpdateError
+
+	if err := htree.PutBatch([][]byte{[]byte("key1")}, []interface{}{1}); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := htree.PutBatch([][]byte{[]byte("key1")}, []interface{}{nil}); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, loc)
+}
+
+func TestHTreePutIf(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	htree, _ := NewHTree(sm)
+	htree.EnableBloomFilter()
+
+	if res, err := htree.PutIf([]byte("a"), 1, 2); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := htree.PutIf([]byte("a"), nil, 1); !res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := htree.PutIf([]byte("a"), nil, 2); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := htree.Get([]byte("a")); res != 1 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Values are compared by content
+
+	htree.Put([]byte("b"), []string{"x"})
+
+	if res, err := htree.PutIf([]byte("b"), []string{"y"}, []string{"z"}); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := htree.PutIf([]byte("b"), []string{"x"}, nil); !res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := htree.Exists([]byte("b")); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Concurrent counters do not lose updates
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				for {
+					current, _ := htree.Get([]byte("counter"))
+
+					next := 1
+
+					if current != nil {
+						next = current.(int) + 1
+					}
+
+					if ok, _ := htree.PutIf([]byte("counter"), current, next); ok {
+						break
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if res, err := htree.Get([]byte("counter")); res != 800 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Test errors
+
+	_, loc, _ := htree.GetValueAndLocation([]byte("a"))
+
+	sm.AccessMap[loc] = storage.AccessUpdateError
+
+	if res, err := htree.PutIf([]byte("a"), 1, 2); res || err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	delete(sm.AccessMap, loc)
+
+	sm.AccessMap[loc] = storage.AccessCacheAndFetchError
+
+	if res, err := htree.PutIf([]byte("a"), 1, 2); res || err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	delete(sm.AccessMap, loc)
+}
@@ -29,8 +29,8 @@ htreeBucket creates a new bucket for the HTree.
 */
 func newHTreeBucket(tree *HTree, depth byte) *htreeBucket {
 	return &htreeBucket{&htreeNode{tree, 0, nil, depth, nil,
-		make([][]byte, MaxBucketElements),
-		make([]interface{}, MaxBucketElements), 0}}
+		make([][]byte, MaxBucketElements), nil,
+		make([]interface{}, MaxBucketElements), 0, 0}}
 }
 
 /*
@@ -58,7 +58,35 @@ func (b *htreeBucket) HasRoom() bool {
 }
 
 /*
-Put adds or updates a new key / value pair to the bucket.
+Key returns the key of an element of this bucket.
+*/
+func (b *htreeBucket) Key(i int) []byte {
+	if len(b.Prefix) == 0 {
+		return b.Keys[i]
+	}
+
+	key := make([]byte, 0, len(b.Prefix)+len(b.Keys[i]))
+
+	return append(append(key, b.Prefix...), b.Keys[i]...)
+}
+
+/*
+allKeys returns all keys of this bucket.
+*/
+func (b *htreeBucket) allKeys() [][]byte {
+	keys := make([][]byte, b.BucketSize)
+
+	for i := range keys {
+		keys[i] = b.Key(i)
+	}
+
+	return keys
+}
+
+/*
+Put adds or updates a new key / value pair to the bucket. The bucket stores
+the common prefix of its keys only once - the prefix is shortened if a new
+key does not share it.
 */
 func (b *htreeBucket) Put(key []byte, value interface{}) interface{} {
 	if key == nil {
@@ -67,79 +95,101 @@ func (b *htreeBucket) Put(key []byte, value interface{}) interface{} {
 
 	// Check if this is an update
 
-	for i, skey := range b.Keys {
-
-		if bytes.Compare(key, skey) == 0 {
-			old := b.Values[i]
-			b.Values[i] = value
+	if i := b.index(key); i != -1 {
+		old := b.Values[i]
+		b.Values[i] = value
 
-			return old
-		}
+		return old
 	}
 
 	if !b.HasRoom() {
 		panic("Bucket has no more room")
 	}
 
+	if b.BucketSize == 0 {
+		b.Prefix = key
+	} else {
+		b.shortenPrefix(key)
+	}
+
+	suffix := key[len(b.Prefix):]
+
 	if b.BucketSize >= MaxBucketElements {
-		b.Keys = append(b.Keys, key)
+		b.Keys = append(b.Keys, suffix)
 		b.Values = append(b.Values, value)
 		b.BucketSize++
 		return nil
 	}
 
-	b.Keys[b.BucketSize] = key
+	b.Keys[b.BucketSize] = suffix
 	b.Values[b.BucketSize] = value
 	b.BucketSize++
 
 	return nil
 }
 
+/*
+shortenPrefix shortens the common key prefix of this bucket so it is also a
+prefix of a given key. The removed part of the prefix is added to all stored
+keys.
+*/
+func (b *htreeBucket) shortenPrefix(key []byte) {
+	var l int
+
+	for l < len(b.Prefix) && l < len(key) && b.Prefix[l] == key[l] {
+		l++
+	}
+
+	if l == len(b.Prefix) {
+		return
+	}
+
+	removed := b.Prefix[l:]
+
+	for i := 0; i < int(b.BucketSize); i++ {
+		skey := make([]byte, 0, len(removed)+len(b.Keys[i]))
+		b.Keys[i] = append(append(skey, removed...), b.Keys[i]...)
+	}
+
+	b.Prefix = b.Prefix[:l]
+}
+
 /*
 Remove removes a key / value pair from the bucket.
 */
 func (b *htreeBucket) Remove(key []byte) interface{} {
-	if key == nil || b.BucketSize == 0 {
-		return nil
-	}
 
 	// Look for the key
 
-	for i, skey := range b.Keys {
+	i := b.index(key)
 
-		if bytes.Compare(key, skey) == 0 {
-			old := b.Values[i]
+	if i == -1 {
+		return nil
+	}
 
-			b.Keys[i] = b.Keys[b.BucketSize-1]
-			b.Values[i] = b.Values[b.BucketSize-1]
+	old := b.Values[i]
 
-			b.Keys[b.BucketSize-1] = nil
-			b.Values[b.BucketSize-1] = nil
+	b.Keys[i] = b.Keys[b.BucketSize-1]
+	b.Values[i] = b.Values[b.BucketSize-1]
 
-			b.BucketSize--
+	b.Keys[b.BucketSize-1] = nil
+	b.Values[b.BucketSize-1] = nil
 
-			return old
-		}
+	b.BucketSize--
+
+	if b.BucketSize == 0 {
+		b.Prefix = nil
 	}
 
-	return nil
+	return old
 }
 
 /*
 Get gets the value for a given key.
 */
 func (b *htreeBucket) Get(key []byte) interface{} {
-	if key == nil || b.BucketSize == 0 {
-		return nil
-	}
-
-	// Look for the key
-
-	for i, skey := range b.Keys {
-
-		if bytes.Compare(key, skey) == 0 {
-			return b.Values[i]
-		}
+	if i := b.index(key); i != -1 {
+		return b.Values[i]
 	}
 
 	return nil
@@ -149,20 +199,28 @@ func (b *htreeBucket) Get(key []byte) interface{} {
 Exists checks if an element exists.
 */
 func (b *htreeBucket) Exists(key []byte) bool {
-	if key == nil || b.BucketSize == 0 {
-		return false
+	return b.index(key) != -1
+}
+
+/*
+index returns the index of a given key in this bucket or -1 if the key does
+not exist.
+*/
+func (b *htreeBucket) index(key []byte) int {
+	if key == nil || b.BucketSize == 0 || !bytes.HasPrefix(key, b.Prefix) {
+		return -1
 	}
 
-	// Look for the key
+	suffix := key[len(b.Prefix):]
 
-	for _, skey := range b.Keys {
+	for i, skey := range b.Keys[:b.BucketSize] {
 
-		if bytes.Compare(key, skey) == 0 {
-			return true
+		if bytes.Compare(suffix, skey) == 0 {
+			return i
 		}
 	}
 
-	return false
+	return -1
 }
 
 /*
@@ -180,6 +238,10 @@ func (b *htreeBucket) String() string {
 
 	for i, key := range b.Keys {
 
+		if i < int(b.BucketSize) {
+			key = b.Key(i)
+		}
+
 		for j = 0; j < b.Depth; j++ {
 			buf.WriteString("  ")
 		}
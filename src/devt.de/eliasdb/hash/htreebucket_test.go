@@ -68,8 +68,16 @@ func TestHashBucket(t *testing.T) {
 		return
 	}
 
-	if fmt.Sprint(treebucket.Keys) != "[[1 2 3] [1 2 4] [1 2 5] [] [] [] [] []]" {
-		t.Error("Unexpected keys content:", treebucket.Keys)
+	// The common prefix of all keys is stored only once
+
+	if fmt.Sprint(treebucket.Prefix) != "[1 2]" ||
+		fmt.Sprint(treebucket.Keys) != "[[3] [4] [5] [] [] [] [] []]" {
+		t.Error("Unexpected keys content:", treebucket.Prefix, treebucket.Keys)
+		return
+	}
+
+	if fmt.Sprint(treebucket.allKeys()) != "[[1 2 3] [1 2 4] [1 2 5]]" {
+		t.Error("Unexpected keys:", treebucket.allKeys())
 		return
 	}
 
@@ -90,7 +98,7 @@ func TestHashBucket(t *testing.T) {
 		return
 	}
 
-	if fmt.Sprint(treebucket.Keys) != "[[1 2 5] [1 2 4] [] [] [] [] [] []]" {
+	if fmt.Sprint(treebucket.Keys) != "[[5] [4] [] [] [] [] [] []]" {
 		t.Error("Unexpected keys content:", treebucket.Keys)
 		return
 	}
@@ -104,8 +112,11 @@ func TestHashBucket(t *testing.T) {
 
 	treebucket.Put([]byte{1, 1, 1}, "test4")
 
-	if fmt.Sprint(treebucket.Keys) != "[[1 2 5] [1 2 4] [1 1 1] [] [] [] [] []]" {
-		t.Error("Unexpected keys content:", treebucket.Keys)
+	// The prefix is shortened if a key does not share it
+
+	if fmt.Sprint(treebucket.Prefix) != "[1]" ||
+		fmt.Sprint(treebucket.Keys) != "[[2 5] [2 4] [1 1] [] [] [] [] []]" {
+		t.Error("Unexpected keys content:", treebucket.Prefix, treebucket.Keys)
 		return
 	}
 	if fmt.Sprint(treebucket.Values) != "[test3 test2 test4 <nil> <nil> <nil> <nil> <nil>]" {
@@ -117,7 +128,7 @@ func TestHashBucket(t *testing.T) {
 
 	treebucket.Put([]byte{1, 1, 1}, "test5")
 
-	if fmt.Sprint(treebucket.Keys) != "[[1 2 5] [1 2 4] [1 1 1] [] [] [] [] []]" {
+	if fmt.Sprint(treebucket.Keys) != "[[2 5] [2 4] [1 1] [] [] [] [] []]" {
 		t.Error("Unexpected keys content:", treebucket.Keys)
 		return
 	}
@@ -181,7 +192,7 @@ func TestHashBucket(t *testing.T) {
 
 	treebucket.Put([]byte{1, 3, 6}, "test6")
 
-	if fmt.Sprint(treebucket.Keys) != "[[1 2 5] [1 2 4] [1 1 1] [1 3 1] [1 3 2] [1 3 3] [1 3 4] [1 3 5] [1 3 6]]" {
+	if fmt.Sprint(treebucket.Keys) != "[[2 5] [2 4] [1 1] [3 1] [3 2] [3 3] [3 4] [3 5] [3 6]]" {
 		t.Error("Unexpected keys content:", treebucket.Keys)
 		return
 	}
@@ -204,6 +215,32 @@ func TestHashBucket(t *testing.T) {
 		"        [1 3 6] - test6\n" {
 		t.Error("Unexpected string output:", res)
 	}
+
+	// Keys which are a prefix of other keys
+
+	treebucket = newHTreeBucket(tree, 1)
+
+	treebucket.Put([]byte("abc"), 1)
+	treebucket.Put([]byte("ab"), 2)
+
+	if string(treebucket.Prefix) != "ab" || fmt.Sprint(treebucket.Keys[:2]) != "[[99] []]" {
+		t.Error("Unexpected keys content:", treebucket.Prefix, treebucket.Keys)
+		return
+	}
+
+	if treebucket.Get([]byte("ab")) != 2 || treebucket.Get([]byte("abc")) != 1 ||
+		treebucket.Exists([]byte("a")) || treebucket.Exists([]byte("abd")) {
+		t.Error("Unexpected bucket content:", treebucket.String())
+		return
+	}
+
+	treebucket.Remove([]byte("ab"))
+	treebucket.Remove([]byte("abc"))
+
+	if treebucket.Prefix != nil || treebucket.Size() != 0 {
+		t.Error("Unexpected bucket content:", treebucket.Prefix, treebucket.Size())
+		return
+	}
 }
 
 func testOverflowPanic(t *testing.T, treebucket *htreeBucket) {
@@ -26,7 +26,7 @@ type htreePage struct {
 newHTreePage creates a new page for the HTree.
 */
 func newHTreePage(tree *HTree, depth byte) *htreePage {
-	return &htreePage{&htreeNode{tree, 0, nil, depth, make([]uint64, MaxPageChildren), nil, nil, 0}}
+	return &htreePage{&htreeNode{tree, 0, nil, depth, make([]uint64, MaxPageChildren), nil, nil, nil, 0, 0}}
 }
 
 /*
@@ -42,6 +42,21 @@ func (p *htreePage) IsEmpty() bool {
 	return true
 }
 
+/*
+usedChildren returns the number of children of this page.
+*/
+func (p *htreePage) usedChildren() int {
+	var res int
+
+	for _, child := range p.Children {
+		if child != 0 {
+			res++
+		}
+	}
+
+	return res
+}
+
 /*
 Location returns the location of this HTree page.
 */
@@ -75,9 +90,11 @@ func (p *htreePage) Get(key []byte) (interface{}, *htreeBucket, error) {
 
 		}
 
-		// If a Bucket was found return the value
+		// If a Bucket was found return the value - concurrent readers
+		// share the bucket so its location is set on a copy
 
-		bucket := &htreeBucket{node}
+		bnode := *node
+		bucket := &htreeBucket{&bnode}
 
 		bucket.loc = loc
 		bucket.sm = p.sm
@@ -235,8 +252,8 @@ func (p *htreePage) Put(key []byte, value interface{}) (interface{}, error) {
 	// steps are too eloborate with little chance of success they
 	// might also damage the now intact tree
 
-	for i, key := range bucket.Keys {
-		page.Put(key, bucket.Values[i])
+	for i := 0; i < int(bucket.BucketSize); i++ {
+		page.Put(bucket.Key(i), bucket.Values[i])
 	}
 
 	// Remove old bucket from file
@@ -248,6 +265,209 @@ func (p *htreePage) Put(key []byte, value interface{}) (interface{}, error) {
 	return page.Put(key, value)
 }
 
+/*
+putBatch adds or updates a list of key / value pairs with unique keys. The
+page is written once after all its children were updated.
+*/
+func (p *htreePage) putBatch(keys [][]byte, values []interface{}) error {
+	changed, replaced, err := p.putChildren(keys, values)
+
+	if err == nil && changed {
+		err = p.sm.Update(p.loc, p.htreeNode)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Remove buckets which were replaced by pages once the page is updated
+
+	for _, loc := range replaced {
+		p.sm.Free(loc)
+	}
+
+	return nil
+}
+
+/*
+putChildren adds or updates a list of key / value pairs with unique keys in
+the children of this page. The page itself is not written. Returns if the
+children of the page have changed and the locations of buckets which are no
+longer part of the tree once the page is written.
+*/
+func (p *htreePage) putChildren(keys [][]byte, values []interface{}) (bool, []uint64, error) {
+	var hashes []uint32
+	var replaced []uint64
+
+	changed := false
+	groups := make(map[uint32][]int)
+
+	for i, key := range keys {
+		hash := p.hashKey(key)
+
+		if _, ok := groups[hash]; !ok {
+			hashes = append(hashes, hash)
+		}
+
+		groups[hash] = append(groups[hash], i)
+	}
+
+	for _, hash := range hashes {
+		var gkeys [][]byte
+		var gvalues []interface{}
+
+		for _, i := range groups[hash] {
+			gkeys = append(gkeys, keys[i])
+			gvalues = append(gvalues, values[i])
+		}
+
+		loc := p.Children[hash]
+
+		if loc == 0 {
+
+			// If nothing exists yet for the hash code then create a new child
+
+			newLoc, err := p.newChild(gkeys, gvalues)
+			if err != nil {
+				return changed, replaced, err
+			}
+
+			p.Children[hash] = newLoc
+			changed = true
+
+			continue
+		}
+
+		node, err := p.fetchNode(loc)
+		if err != nil {
+			return changed, replaced, err
+		}
+
+		if node.Children != nil {
+
+			// If another page was found deligate the request
+
+			page := &htreePage{node}
+
+			page.loc = loc
+			page.sm = p.sm
+
+			if err := page.putBatch(gkeys, gvalues); err != nil {
+				return changed, replaced, err
+			}
+
+			continue
+		}
+
+		bucket := &htreeBucket{node}
+
+		bucket.loc = loc
+		bucket.sm = p.sm
+
+		newKeys := 0
+
+		for _, key := range gkeys {
+			if !bucket.Exists(key) {
+				newKeys++
+			}
+		}
+
+		if bucket.IsLeaf() || int(bucket.BucketSize)+newKeys <= MaxBucketElements {
+
+			// If the bucket has enough room put all values on it
+
+			for i, key := range gkeys {
+				bucket.Put(key, gvalues[i])
+			}
+
+			if err := p.sm.Update(bucket.loc, bucket.htreeNode); err != nil {
+				return changed, replaced, err
+			}
+
+			continue
+		}
+
+		// If the bucket is too full replace it with a new page which contains
+		// the existing and the new values
+
+		newLoc, err := p.newChild(append(bucket.allKeys(), gkeys...),
+			append(bucket.Values[:bucket.BucketSize:bucket.BucketSize], gvalues...))
+
+		if err != nil {
+			return changed, replaced, err
+		}
+
+		p.Children[hash] = newLoc
+		changed = true
+		replaced = append(replaced, loc)
+	}
+
+	return changed, replaced, nil
+}
+
+/*
+newChild creates a new child for this page which contains a given list of
+key / value pairs. Keys which appear more than once get the last value.
+Returns the location of the new child.
+*/
+func (p *htreePage) newChild(keys [][]byte, values []interface{}) (uint64, error) {
+	depth := p.Depth + 1
+
+	if depth > MaxTreeDepth || len(keys) <= MaxBucketElements {
+		bucket := newHTreeBucket(p.tree, depth)
+
+		for i, key := range keys {
+			bucket.Put(key, values[i])
+		}
+
+		return p.sm.Insert(bucket.htreeNode)
+	}
+
+	page := newHTreePage(p.tree, depth)
+
+	page.sm = p.sm
+
+	_, _, err := page.putChildren(keys, values)
+
+	if err == nil {
+		var loc uint64
+
+		if loc, err = p.sm.Insert(page.htreeNode); err == nil {
+			return loc, nil
+		}
+	}
+
+	// Remove the children of the page which was not inserted
+
+	page.freeChildren()
+
+	return 0, err
+}
+
+/*
+freeChildren removes all children of this page from the storage. Errors are
+ignored.
+*/
+func (p *htreePage) freeChildren() {
+	for _, loc := range p.Children {
+
+		if loc == 0 {
+			continue
+		}
+
+		if node, err := p.fetchNode(loc); err == nil && node.Children != nil {
+			page := &htreePage{node}
+
+			page.loc = loc
+			page.sm = p.sm
+
+			page.freeChildren()
+		}
+
+		p.sm.Free(loc)
+	}
+}
+
 /*
 Remove removes a key / value pair.
 */
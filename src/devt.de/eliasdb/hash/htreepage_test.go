@@ -11,6 +11,7 @@
 package hash
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -95,7 +96,7 @@ func TestHTreePageInsert(t *testing.T) {
 
 	sm.AccessMap[2] = storage.AccessInsertError
 
-	if _, err := page.Put([]byte("testkey1"), "test1"); err != file.ErrAlreadyInUse {
+	if _, err := page.Put([]byte("testkey1"), "test1"); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected put result:", err)
 		return
 	}
@@ -147,7 +148,7 @@ func TestHTreePageInsert(t *testing.T) {
 
 	sm.AccessMap[3] = storage.AccessInsertError
 
-	if _, err := page.Put([]byte("testkey9"), "test9"); err != file.ErrAlreadyInUse {
+	if _, err := page.Put([]byte("testkey9"), "test9"); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected put result:", err)
 		return
 	}
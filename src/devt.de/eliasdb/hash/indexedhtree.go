@@ -0,0 +1,206 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"sync"
+
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/storage"
+)
+
+/*
+IndexedHTree data structure - a HTree with a reverse index which maps the
+hash of every value to the keys which have this value. Both trees are stored
+in the same storage manager so pending changes of both trees are flushed or
+rolled back together. If an update of the reverse index fails the primary
+tree is restored.
+*/
+type IndexedHTree struct {
+	Tree    *HTree      // Primary tree which maps keys to values
+	Reverse *MultiHTree // Reverse index which maps value hashes to keys
+	loc     uint64      // Storage location of the locations of both trees
+	mutex   *sync.Mutex // Mutex to protect updates of both trees
+}
+
+/*
+NewIndexedHTree creates a new IndexedHTree.
+*/
+func NewIndexedHTree(sm storage.Manager) (*IndexedHTree, error) {
+	tree, err := NewHTree(sm)
+	if err != nil {
+		return nil, err
+	}
+
+	reverse, err := NewMultiHTree(sm)
+	if err != nil {
+		tree.free()
+		return nil, err
+	}
+
+	loc, err := sm.Insert([]uint64{tree.Location(), reverse.Location()})
+	if err != nil {
+		tree.free()
+		reverse.Tree.free()
+		return nil, err
+	}
+
+	return &IndexedHTree{tree, reverse, loc, &sync.Mutex{}}, nil
+}
+
+/*
+LoadIndexedHTree fetches an IndexedHTree from storage.
+*/
+func LoadIndexedHTree(sm storage.Manager, loc uint64) (*IndexedHTree, error) {
+	var locs []uint64
+
+	if obj, _ := sm.FetchCached(loc); obj != nil {
+		locs = obj.([]uint64)
+	} else if err := sm.Fetch(loc, &locs); err != nil {
+		return nil, err
+	}
+
+	tree, err := LoadHTree(sm, locs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	reverse, err := LoadMultiHTree(sm, locs[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &IndexedHTree{tree, reverse, loc, &sync.Mutex{}}, nil
+}
+
+/*
+Location returns the IndexedHTree location on disk.
+*/
+func (t *IndexedHTree) Location() uint64 {
+	return t.loc
+}
+
+/*
+Get gets a value for a given key.
+*/
+func (t *IndexedHTree) Get(key []byte) (interface{}, error) {
+	return t.Tree.Get(key)
+}
+
+/*
+Keys returns all keys which have a given value.
+*/
+func (t *IndexedHTree) Keys(value interface{}) ([][]byte, error) {
+	var keys [][]byte
+
+	it := t.Reverse.Iterator(ValueHash(value))
+
+	for it.HasNext() {
+		keys = append(keys, it.Next().([]byte))
+	}
+
+	return keys, it.LastError
+}
+
+/*
+Put adds or updates a new key / value pair. Putting a nil value removes the key.
+*/
+func (t *IndexedHTree) Put(key []byte, value interface{}) (interface{}, error) {
+	if value == nil {
+		return t.Remove(key)
+	}
+
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "Put")
+	defer t.mutex.Unlock()
+
+	old, err := t.Tree.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	newHash := ValueHash(value)
+
+	if old != nil && bytes.Equal(ValueHash(old), newHash) {
+		return t.Tree.Put(key, value)
+	}
+
+	// Add the new entry to the reverse index before the old entry is
+	// removed - failures can be undone by removing entries
+
+	if err := t.Reverse.Add(newHash, key); err != nil {
+		return nil, err
+	}
+
+	if _, err := t.Tree.Put(key, value); err != nil {
+		t.Reverse.Remove(newHash, key)
+		return nil, err
+	}
+
+	if old != nil {
+		if _, err := t.Reverse.Remove(ValueHash(old), key); err != nil {
+			t.Tree.Put(key, old)
+			t.Reverse.Remove(newHash, key)
+			return nil, err
+		}
+	}
+
+	return old, nil
+}
+
+/*
+Remove removes a key / value pair.
+*/
+func (t *IndexedHTree) Remove(key []byte) (interface{}, error) {
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "Remove")
+	defer t.mutex.Unlock()
+
+	old, err := t.Tree.Remove(key)
+	if err != nil || old == nil {
+		return old, err
+	}
+
+	if _, err := t.Reverse.Remove(ValueHash(old), key); err != nil {
+		t.Tree.Put(key, old)
+		return nil, err
+	}
+
+	return old, nil
+}
+
+/*
+String returns a string representation of this tree.
+*/
+func (t *IndexedHTree) String() string {
+	return fmt.Sprintf("IndexedHTree: %v\n%v\n%v", t.loc, t.Tree.String(), t.Reverse.String())
+}
+
+/*
+ValueHash returns the hash of a value which is used as key in the reverse
+index. Strings and byte slices are hashed by their content, other values by
+their type and Go-syntax representation.
+*/
+func ValueHash(value interface{}) []byte {
+	var sum [16]byte
+
+	switch v := value.(type) {
+	case []byte:
+		sum = md5.Sum(v)
+	case string:
+		sum = md5.Sum([]byte(v))
+	default:
+		sum = md5.Sum([]byte(fmt.Sprintf("%T %#v", v, v)))
+	}
+
+	return sum[:]
+}
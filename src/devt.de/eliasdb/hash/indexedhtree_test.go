@@ -0,0 +1,214 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"devt.de/eliasdb/storage"
+	"devt.de/eliasdb/storage/file"
+)
+
+func TestIndexedHTree(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	itree, _ := NewIndexedHTree(sm)
+
+	itree.Put([]byte("a"), "red")
+	itree.Put([]byte("b"), "red")
+	itree.Put([]byte("c"), "blue")
+
+	if res, err := itree.Keys("red"); fmt.Sprintf("%s", res) != "[a b]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Changing a value moves the key in the reverse index
+
+	if res, err := itree.Put([]byte("a"), "blue"); res != "red" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := itree.Put([]byte("a"), "blue"); res != "blue" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, _ := itree.Keys("red"); fmt.Sprintf("%s", res) != "[b]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := itree.Keys("blue"); fmt.Sprintf("%s", res) != "[c a]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Removing a key removes it from the reverse index
+
+	if res, err := itree.Put([]byte("b"), nil); res != "red" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := itree.Remove([]byte("b")); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, _ := itree.Keys("red"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Other values are hashed by their representation
+
+	itree.Put([]byte("d"), 5)
+
+	if res, _ := itree.Keys(5); fmt.Sprintf("%s", res) != "[d]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res, _ := itree.Keys(int64(5)); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Load the tree again
+
+	itree2, err := LoadIndexedHTree(sm, itree.Location())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := itree2.Get([]byte("a")); res != "blue" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, _ := itree2.Keys("blue"); fmt.Sprintf("%s", res) != "[c a]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := itree2.String(); !strings.HasPrefix(res, fmt.Sprintf("IndexedHTree: %v\n", itree.Location())) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestIndexedHTreeErrors(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	for i := uint64(1); i < 4; i++ {
+		sm.AccessMap[i] = storage.AccessInsertError
+
+		if _, err := NewIndexedHTree(sm); !errors.Is(err, file.ErrAlreadyInUse) {
+			t.Error("Unexpected result:", err)
+			return
+		}
+
+		delete(sm.AccessMap, i)
+
+		if len(sm.Data) != 0 {
+			t.Error("Unexpected stored objects:", sm.Data)
+			return
+		}
+
+		sm.LocCount = 1
+	}
+
+	itree, _ := NewIndexedHTree(sm)
+
+	if _, err := LoadIndexedHTree(sm, 42); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	itree.Put([]byte("a"), "red")
+
+	checkState := func() error {
+		if res, err := itree.Get([]byte("a")); res != "red" || err != nil {
+			return fmt.Errorf("Unexpected result: %v %v", res, err)
+		}
+
+		if res, err := itree.Keys("red"); fmt.Sprintf("%s", res) != "[a]" || err != nil {
+			return fmt.Errorf("Unexpected result: %s %v", res, err)
+		}
+
+		return nil
+	}
+
+	// The reverse index cannot be read
+
+	hkey := multiHeaderKey(ValueHash("red"))
+
+	_, loc, _ := itree.Reverse.Tree.GetValueAndLocation(hkey)
+
+	sm.AccessMap[loc] = storage.AccessCacheAndFetchError
+
+	if _, err := itree.Put([]byte("a"), "blue"); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := itree.Remove([]byte("a")); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := itree.Keys("red"); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, loc)
+
+	if err := checkState(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The new value cannot be added to the reverse index
+
+	sm.AccessMap[sm.LocCount] = storage.AccessInsertError
+
+	if _, err := itree.Put([]byte("a"), "green"); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, sm.LocCount)
+
+	if err := checkState(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The primary tree cannot be written
+
+	_, loc, _ = itree.Tree.GetValueAndLocation([]byte("a"))
+
+	sm.AccessMap[loc] = storage.AccessUpdateError
+
+	if _, err := itree.Put([]byte("a"), "green"); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, loc)
+}
@@ -11,9 +11,12 @@
 package hash
 
 import (
-	"errors"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 
+	"devt.de/common/errorutil"
 	"devt.de/eliasdb/storage"
 )
 
@@ -21,29 +24,135 @@ import (
 ErrNoMoreItems is assigned to LastError when Next() is called and there are no
 more items to iterate.
 */
-var ErrNoMoreItems = errors.New("No more items to iterate")
+var ErrNoMoreItems = errorutil.NewCategorizedError(errorutil.ErrInternal, "No more items to iterate")
+
+/*
+ErrInvalidToken is returned if an iterator token cannot be parsed.
+*/
+var ErrInvalidToken = errorutil.NewCategorizedError(errorutil.ErrInvalid, "Invalid iterator token")
+
+/*
+iteratorTokenVersion is the version of the encoding of iterator tokens
+*/
+const iteratorTokenVersion = 1
+
+/*
+HTreeIteratorPosition is the position of an iterator after the last returned
+key. A position stays valid if the tree is changed or loaded again.
+*/
+type HTreeIteratorPosition struct {
+	Path []byte   // Page children which lead to the bucket of the last returned key
+	Keys [][]byte // Keys which were returned from this bucket
+}
 
 /*
 HTreeIterator data structure
 */
 type HTreeIterator struct {
-	tree      *HTree      // Tree to iterate
-	nodePath  []uint64    // Path in the tree we currently traversing
-	indices   []int       // List of the current indices in the current path
-	nextKey   []byte      // Next iterator key (overwritten by nextItem)
-	nextValue interface{} // Next iterator value
-	LastError error       // Last encountered error
+	tree       *HTree                 // Tree to iterate
+	nodePath   []uint64               // Path in the tree we currently traversing
+	indices    []int                  // List of the current indices in the current path
+	nextKey    []byte                 // Next iterator key (overwritten by nextItem)
+	nextValue  interface{}            // Next iterator value
+	nextPath   []byte                 // Page children which lead to the next key
+	pos        *HTreeIteratorPosition // Position after the last returned key
+	resume     *HTreeIteratorPosition // Position from which the iterator was resumed
+	resumeKeys map[string]bool        // Keys which were returned before the iterator was resumed
+	prefix     []byte                 // Prefix of all returned keys (nil for all keys)
+	LastError  error                  // Last encountered error
 }
 
 /*
 NewHTreeIterator creates a new HTreeIterator.
 */
 func NewHTreeIterator(tree *HTree) *HTreeIterator {
-	it := &HTreeIterator{tree, make([]uint64, 0), make([]int, 0), nil, nil, nil}
+	return newHTreeIterator(tree, nil, nil)
+}
+
+/*
+NewHTreeIteratorAt creates a new HTreeIterator which continues after a given
+position of a previous iterator. Keys which were added or removed since the
+position was taken may or may not be visited.
+*/
+func NewHTreeIteratorAt(tree *HTree, pos *HTreeIteratorPosition) *HTreeIterator {
+	return newHTreeIterator(tree, pos, nil)
+}
+
+/*
+IteratorPrefix creates a new HTreeIterator which only returns keys which
+start with a given prefix. The hash of a key does not preserve its prefix -
+all buckets are visited but keys without the prefix are skipped inside the
+iterator.
+*/
+func (t *HTree) IteratorPrefix(prefix []byte) *HTreeIterator {
+	return newHTreeIterator(t, nil, prefix)
+}
+
+/*
+IteratorPrefixAt creates a new HTreeIterator which only returns keys which
+start with a given prefix and continues after a given position of a previous
+iterator.
+*/
+func (t *HTree) IteratorPrefixAt(prefix []byte, pos *HTreeIteratorPosition) *HTreeIterator {
+	return newHTreeIterator(t, pos, prefix)
+}
+
+/*
+newHTreeIterator creates a new HTreeIterator which returns all keys with a
+given prefix after a given position.
+*/
+func newHTreeIterator(tree *HTree, pos *HTreeIteratorPosition, prefix []byte) *HTreeIterator {
+
+	if pos == nil || len(pos.Path) == 0 {
+		it := &HTreeIterator{tree, make([]uint64, 0), make([]int, 0), nil, nil, nil,
+			nil, nil, nil, prefix, nil}
+
+		it.nodePath = append(it.nodePath, tree.Root.Location())
+		it.indices = append(it.indices, -1)
+
+		// Set the nextKey and nextValue properties
+
+		it.Next()
+
+		return it
+	}
+
+	it := &HTreeIterator{tree, make([]uint64, 0), make([]int, 0), nil, nil, nil,
+		pos.copy(), pos, make(map[string]bool), prefix, nil}
+
+	for _, k := range pos.Keys {
+		it.resumeKeys[string(k)] = true
+	}
 
 	it.nodePath = append(it.nodePath, tree.Root.Location())
 	it.indices = append(it.indices, -1)
 
+	// Follow the path of the position as far as it still exists - all
+	// keys which are visited from here on are checked against the position
+
+	for _, child := range pos.Path {
+		node, err := tree.Root.fetchNode(it.nodePath[len(it.nodePath)-1])
+
+		if err != nil {
+			it.LastError = err
+			it.nodePath = make([]uint64, 0)
+			it.indices = make([]int, 0)
+
+			return it
+		} else if node.Children == nil {
+			break
+		}
+
+		it.indices[len(it.indices)-1] = int(child)
+
+		if node.Children[child] == 0 {
+			break
+		}
+
+		it.nodePath = append(it.nodePath, node.Children[child])
+		it.indices = append(it.indices, -1)
+	}
+
 	// Set the nextKey and nextValue properties
 
 	it.Next()
@@ -51,6 +160,136 @@ func NewHTreeIterator(tree *HTree) *HTreeIterator {
 	return it
 }
 
+/*
+Position returns the position after the last returned key. Returns an empty
+position if no key was returned yet.
+*/
+func (it *HTreeIterator) Position() *HTreeIteratorPosition {
+	if it.pos == nil {
+		return &HTreeIteratorPosition{}
+	}
+	return it.pos.copy()
+}
+
+/*
+Token returns the position after the last returned key as an opaque URL safe
+string (see HTreeIteratorPosition.Token).
+*/
+func (it *HTreeIterator) Token() string {
+	return it.Position().Token()
+}
+
+/*
+NewHTreeIteratorFromToken creates a new HTreeIterator which continues after
+the position of a given token. An empty token starts a new iteration.
+*/
+func NewHTreeIteratorFromToken(tree *HTree, token string) (*HTreeIterator, error) {
+	pos, err := ParseHTreeIteratorToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHTreeIteratorAt(tree, pos), nil
+}
+
+/*
+Token encodes this position as an opaque URL safe string. The token can be
+handed to a client which continues an iteration later without the need to
+keep the iterator around.
+*/
+func (pos *HTreeIteratorPosition) Token() string {
+	buf := []byte{iteratorTokenVersion}
+	num := make([]byte, binary.MaxVarintLen64)
+
+	putBytes := func(b []byte) {
+		buf = append(buf, num[:binary.PutUvarint(num, uint64(len(b)))]...)
+		buf = append(buf, b...)
+	}
+
+	putBytes(pos.Path)
+
+	buf = append(buf, num[:binary.PutUvarint(num, uint64(len(pos.Keys)))]...)
+
+	for _, key := range pos.Keys {
+		putBytes(key)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+/*
+ParseHTreeIteratorToken parses a token which was created by
+HTreeIteratorPosition.Token. An empty token is an empty position.
+*/
+func ParseHTreeIteratorToken(token string) (*HTreeIteratorPosition, error) {
+	if token == "" {
+		return &HTreeIteratorPosition{}, nil
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) == 0 || buf[0] != iteratorTokenVersion {
+		return nil, ErrInvalidToken
+	}
+
+	buf = buf[1:]
+
+	getLen := func() (int, bool) {
+		l, n := binary.Uvarint(buf)
+		if n <= 0 || l > uint64(len(buf)-n) {
+			return 0, false
+		}
+		buf = buf[n:]
+		return int(l), true
+	}
+
+	getBytes := func() ([]byte, bool) {
+		l, ok := getLen()
+		if !ok {
+			return nil, false
+		}
+		b := buf[:l:l]
+		buf = buf[l:]
+		return b, true
+	}
+
+	pos := &HTreeIteratorPosition{}
+
+	path, ok := getBytes()
+	if !ok || len(path) > MaxTreeDepth+1 {
+		return nil, ErrInvalidToken
+	}
+
+	if len(path) > 0 {
+		pos.Path = path
+	}
+
+	count, ok := getLen()
+
+	for i := 0; ok && i < count; i++ {
+		var key []byte
+
+		if key, ok = getBytes(); ok {
+			pos.Keys = append(pos.Keys, key)
+		}
+	}
+
+	if !ok || len(buf) > 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return pos, nil
+}
+
+/*
+copy returns a copy of this position.
+*/
+func (pos *HTreeIteratorPosition) copy() *HTreeIteratorPosition {
+	keys := make([][]byte, len(pos.Keys))
+	copy(keys, pos.Keys)
+
+	return &HTreeIteratorPosition{append([]byte(nil), pos.Path...), keys}
+}
+
 /*
 HasNext returns if there is a next key / value pair.
 */
@@ -65,7 +304,19 @@ func (it *HTreeIterator) Next() ([]byte, interface{}) {
 	key := it.nextKey
 	value := it.nextValue
 
-	if err := it.nextItem(); err != ErrNoMoreItems && err != nil {
+	if key != nil {
+		it.updatePosition(key, it.nextPath)
+	}
+
+	err := it.nextItem()
+
+	// Skip keys which do not have the requested prefix
+
+	for err == nil && it.prefix != nil && !bytes.HasPrefix(it.nextKey, it.prefix) {
+		err = it.nextItem()
+	}
+
+	if err != ErrNoMoreItems && err != nil {
 
 		it.LastError = err
 
@@ -121,6 +372,12 @@ func (it *HTreeIterator) nextItem() error {
 		return err
 	}
 
+	// Nodes are shared with concurrent readers - the storage location is
+	// set on a copy
+
+	nodeCopy := *node
+	node = &nodeCopy
+
 	if node.Children != nil {
 
 		// If the current path element is a page get the next child and delegate
@@ -170,8 +427,19 @@ func (it *HTreeIterator) nextItem() error {
 
 		it.indices[len(it.indices)-1] = nextElement
 
-		it.nextKey = bucket.Keys[nextElement]
+		it.nextKey = bucket.Key(nextElement)
 		it.nextValue = bucket.Values[nextElement]
+		it.nextPath = make([]byte, len(it.indices)-1)
+
+		for i := range it.nextPath {
+			it.nextPath[i] = byte(it.indices[i])
+		}
+
+		// Skip keys which were returned before the iterator was resumed
+
+		if it.resume != nil && it.returnedBeforeResume(it.nextKey) {
+			return it.nextItem()
+		}
 
 		return nil
 	}
@@ -185,6 +453,54 @@ func (it *HTreeIterator) nextItem() error {
 	return it.nextItem()
 }
 
+/*
+updatePosition updates the position of the iterator after a key was returned.
+*/
+func (it *HTreeIterator) updatePosition(key []byte, path []byte) {
+
+	// Keys of the same bucket are collected - a path which is the prefix of
+	// the other path means that the bucket was split or merged since the
+	// iterator was resumed
+
+	if it.pos != nil {
+		l := len(path)
+		if len(it.pos.Path) < l {
+			l = len(it.pos.Path)
+		}
+
+		if bytes.Equal(it.pos.Path[:l], path[:l]) {
+			it.pos.Path = path
+			it.pos.Keys = append(it.pos.Keys, key)
+			return
+		}
+	}
+
+	it.pos = &HTreeIteratorPosition{path, [][]byte{key}}
+}
+
+/*
+returnedBeforeResume checks if a key was returned before the iterator was
+resumed. These are all keys which were returned from the bucket of the resume
+position and all keys whose hash path is before the resume position.
+*/
+func (it *HTreeIterator) returnedBeforeResume(key []byte) bool {
+
+	if it.resumeKeys[string(key)] {
+		return true
+	}
+
+	page := &htreePage{&htreeNode{}}
+
+	for _, child := range it.resume.Path {
+		if h := byte(page.hashKey(key)); h != child {
+			return h < child
+		}
+		page.Depth++
+	}
+
+	return false
+}
+
 /*
 searchNextChild searches for the index of the next available page child from a given index.
 */
@@ -11,6 +11,7 @@
 package hash
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -207,7 +208,7 @@ func TestIterator(t *testing.T) {
 		return
 	}
 
-	if it.LastError != file.ErrAlreadyInUse {
+	if !errors.Is(it.LastError, file.ErrAlreadyInUse) {
 		t.Error("Unexpected last error pointer of iterator")
 		return
 	}
@@ -222,3 +223,201 @@ func TestIterator(t *testing.T) {
 		return
 	}
 }
+
+func TestIteratorPosition(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+	htree, _ := NewHTree(sm)
+
+	for i := 0; i < 2000; i++ {
+		htree.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	seen := make(map[string]bool)
+
+	// Iterate in chunks - every chunk uses a new iterator
+
+	it := NewHTreeIterator(htree)
+
+	if pos := it.Position(); len(pos.Path) != 0 || len(pos.Keys) != 0 {
+		t.Error("Unexpected position:", pos)
+		return
+	}
+
+	for chunk := 0; it.HasNext(); chunk++ {
+
+		for i := 0; i < 150 && it.HasNext(); i++ {
+			k, _ := it.Next()
+
+			if seen[string(k)] {
+				t.Error("Key was returned twice:", string(k))
+				return
+			}
+
+			seen[string(k)] = true
+		}
+
+		// Add keys which cause buckets to be split
+
+		if chunk == 3 {
+			for i := 2000; i < 4000; i++ {
+				htree.Put([]byte(fmt.Sprint("key", i)), i)
+			}
+		}
+
+		// Reload the tree
+
+		tree, _ := LoadHTree(sm, htree.Location())
+
+		it = NewHTreeIteratorAt(tree, it.Position())
+
+		if it.LastError != nil {
+			t.Error(it.LastError)
+			return
+		}
+	}
+
+	for i := 0; i < 2000; i++ {
+		if !seen[fmt.Sprint("key", i)] {
+			t.Error("Key was not returned:", i)
+			return
+		}
+	}
+
+	// Resuming at the start and at the end of an iteration
+
+	if it := NewHTreeIteratorAt(htree, nil); !it.HasNext() {
+		t.Error("Iterator should have more items")
+		return
+	}
+
+	it = NewHTreeIterator(htree)
+	for it.HasNext() {
+		it.Next()
+	}
+
+	if it := NewHTreeIteratorAt(htree, it.Position()); it.HasNext() {
+		t.Error("Iterator should be finished")
+		return
+	}
+}
+
+func TestIteratorPrefix(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+	htree, _ := NewHTree(sm)
+
+	for i := 0; i < 5000; i++ {
+		htree.Put([]byte(fmt.Sprintf("%v%v", []string{"a", "b", "c"}[i%3], i)), i)
+	}
+
+	keys := make(map[string]bool)
+
+	it := htree.IteratorPrefix([]byte("b"))
+
+	for it.HasNext() {
+		k, v := it.Next()
+
+		if k[0] != 'b' || string(k) != fmt.Sprint("b", v) || keys[string(k)] {
+			t.Error("Unexpected next result:", string(k), v)
+			return
+		}
+
+		keys[string(k)] = true
+
+		// Continue the iteration at the current position
+
+		if len(keys) == 500 {
+			it = htree.IteratorPrefixAt([]byte("b"), it.Position())
+		}
+	}
+
+	if len(keys) != 1667 || it.LastError != nil {
+		t.Error("Unexpected result:", len(keys), it.LastError)
+		return
+	}
+
+	if it := htree.IteratorPrefix([]byte("d")); it.HasNext() || it.LastError != nil {
+		t.Error("Unexpected iterator state:", it.HasNext(), it.LastError)
+		return
+	}
+
+	// An empty prefix matches all keys
+
+	count := 0
+
+	for it := htree.IteratorPrefix([]byte{}); it.HasNext(); it.Next() {
+		count++
+	}
+
+	if count != 5000 {
+		t.Error("Unexpected result:", count)
+		return
+	}
+}
+
+func TestIteratorToken(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+	htree, _ := NewHTree(sm)
+
+	for i := 0; i < 1000; i++ {
+		htree.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	// Page through the tree with tokens
+
+	keys := make(map[string]bool)
+	token := ""
+
+	for page := 0; page < 100; page++ {
+		it, err := NewHTreeIteratorFromToken(htree, token)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		for i := 0; i < 30 && it.HasNext(); i++ {
+			k, _ := it.Next()
+
+			if keys[string(k)] {
+				t.Error("Key was returned twice:", string(k))
+				return
+			}
+
+			keys[string(k)] = true
+		}
+
+		if !it.HasNext() {
+			break
+		}
+
+		token = it.Token()
+	}
+
+	if len(keys) != 1000 {
+		t.Error("Unexpected number of keys:", len(keys))
+		return
+	}
+
+	// Tokens contain the complete position
+
+	pos := &HTreeIteratorPosition{[]byte{1, 255, 3}, [][]byte{[]byte("a"), {}, []byte("ccc")}}
+
+	if res, err := ParseHTreeIteratorToken(pos.Token()); err != nil || fmt.Sprint(res) != fmt.Sprint(pos) {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := ParseHTreeIteratorToken((&HTreeIteratorPosition{}).Token()); err != nil ||
+		res.Path != nil || res.Keys != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Test invalid tokens
+
+	for _, token := range []string{"!", "AA", "AQ", "AQMBAgM", "AQEBAQ", "AQEBAQEAAA", "AQUBAgMEBQA"} {
+		if _, err := NewHTreeIteratorFromToken(htree, token); err != ErrInvalidToken {
+			t.Error("Unexpected result:", token, err)
+			return
+		}
+	}
+}
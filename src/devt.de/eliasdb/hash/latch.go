@@ -0,0 +1,226 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"sync"
+
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/storage"
+)
+
+/*
+latchKey identifies a latched storage location
+*/
+type latchKey struct {
+	sm  storage.Manager // StorageManager which stores the location
+	loc uint64          // Storage location
+}
+
+/*
+latch is a read / write lock for a single storage location
+*/
+type latch struct {
+	sync.RWMutex
+	refs int // Number of holders and waiters of this latch
+}
+
+/*
+latchTable data structure - latches are created on demand and removed once
+they are no longer in use. The table is shared by all trees so different tree
+objects which were loaded from the same location use the same latches.
+*/
+type latchTable struct {
+	mutex   *sync.Mutex         // Mutex to protect the table
+	latches map[latchKey]*latch // Latches which are in use
+}
+
+/*
+pageLatches contains the latches of all HTree pages and Bloom filter blocks
+*/
+var pageLatches = &latchTable{&sync.Mutex{}, make(map[latchKey]*latch)}
+
+/*
+acquire acquires the latch of a storage location.
+*/
+func (lt *latchTable) acquire(sm storage.Manager, loc uint64, write bool, op string) *latch {
+	key := latchKey{sm, loc}
+
+	lt.mutex.Lock()
+
+	l, ok := lt.latches[key]
+	if !ok {
+		l = &latch{}
+		lt.latches[key] = l
+	}
+
+	l.refs++
+
+	lt.mutex.Unlock()
+
+	if write {
+		lockprof.Lock(l, lockprof.LockHTreePage, op)
+	} else {
+		lockprof.RLock(&l.RWMutex, lockprof.LockHTreePage, op)
+	}
+
+	return l
+}
+
+/*
+release releases the latch of a storage location.
+*/
+func (lt *latchTable) release(sm storage.Manager, loc uint64, l *latch, write bool) {
+	if write {
+		l.Unlock()
+	} else {
+		l.RUnlock()
+	}
+
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	if l.refs--; l.refs == 0 {
+		delete(lt.latches, latchKey{sm, loc})
+	}
+}
+
+/*
+size returns the number of latches which are in use.
+*/
+func (lt *latchTable) size() int {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	return len(lt.latches)
+}
+
+/*
+latchedPage is a page whose latch is held
+*/
+type latchedPage struct {
+	page  *htreePage // Latched page
+	latch *latch     // Held latch
+}
+
+/*
+latchPath is a list of latched pages from a tree level down to the page which
+holds the bucket of a key.
+*/
+type latchPath struct {
+	write bool          // Flag if the latches are held for writing
+	pages []latchedPage // Latched pages (the last page is the deepest)
+}
+
+/*
+releaseAbove releases all latches above the deepest page.
+*/
+func (lp *latchPath) releaseAbove() {
+	last := len(lp.pages) - 1
+
+	for _, lpage := range lp.pages[:last] {
+		pageLatches.release(lpage.page.sm, lpage.page.loc, lpage.latch, lp.write)
+	}
+
+	lp.pages = lp.pages[last:]
+}
+
+/*
+releaseAll releases all latches of this path.
+*/
+func (lp *latchPath) releaseAll() {
+	for _, lpage := range lp.pages {
+		pageLatches.release(lpage.page.sm, lpage.page.loc, lpage.latch, lp.write)
+	}
+
+	lp.pages = nil
+}
+
+/*
+top returns the highest latched page.
+*/
+func (lp *latchPath) top() *htreePage {
+	return lp.pages[0].page
+}
+
+/*
+bottom returns the deepest latched page.
+*/
+func (lp *latchPath) bottom() *htreePage {
+	return lp.pages[len(lp.pages)-1].page
+}
+
+/*
+latchKeyPath descends the tree to the page which holds the bucket of a given
+key (lock coupling). The latch of a child page is acquired before the latch of
+its parent is released. The parent latch is kept if the operation on the child
+might change the parent - the keep function decides this for a child page
+(parent latches are never kept if keep is nil). On success the returned path holds all kept latches, on error no latches are held.
+*/
+func (t *HTree) latchKeyPath(key []byte, write bool, keep func(*htreePage) bool,
+	op string) (*latchPath, error) {
+
+	lp := &latchPath{write, nil}
+	lp.pages = append(lp.pages, latchedPage{t.Root,
+		pageLatches.acquire(t.Root.sm, t.Root.loc, write, op)})
+
+	for {
+		page := lp.bottom()
+		loc := page.Children[page.hashKey(key)]
+
+		if loc == 0 {
+			return lp, nil
+		}
+
+		// The type of a child cannot change while the parent latch is held
+
+		node, err := page.fetchNode(loc)
+		if err != nil {
+			lp.releaseAll()
+			return nil, err
+		}
+
+		if node.Children == nil {
+			return lp, nil
+		}
+
+		// Fetch the child page again once its latch is held - a writer
+		// might have changed it in the meantime
+
+		l := pageLatches.acquire(page.sm, loc, write, op)
+
+		if node, err = page.fetchNode(loc); err != nil {
+			pageLatches.release(page.sm, loc, l, write)
+			lp.releaseAll()
+			return nil, err
+		}
+
+		if !write {
+
+			// Readers share nodes - use a copy so the storage location
+			// can be set
+
+			c := *node
+			node = &c
+		}
+
+		child := &htreePage{node}
+
+		child.loc = loc
+		child.sm = page.sm
+
+		lp.pages = append(lp.pages, latchedPage{child, l})
+
+		if keep == nil || !keep(child) {
+			lp.releaseAbove()
+		}
+	}
+}
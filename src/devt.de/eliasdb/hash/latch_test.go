@@ -0,0 +1,88 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"devt.de/eliasdb/storage"
+)
+
+func TestHTreeConcurrency(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	htree, _ := NewHTree(sm)
+	htree.EnableBloomFilter()
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 8)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(worker int) {
+			defer wg.Done()
+
+			for j := 0; j < 500; j++ {
+				key := []byte(fmt.Sprint("key", worker, "-", j))
+
+				if _, err := htree.Put(key, j); err != nil {
+					errs <- err
+					return
+				}
+
+				if res, err := htree.Get(key); res != j || err != nil {
+					errs <- fmt.Errorf("Unexpected result for %s: %v %v", key, res, err)
+					return
+				}
+
+				if j%3 == 0 {
+					if res, err := htree.Remove(key); res != j || err != nil {
+						errs <- fmt.Errorf("Unexpected remove result for %s: %v %v", key, res, err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+		return
+	}
+
+	if res := pageLatches.size(); res != 0 {
+		t.Error("Unexpected number of latches:", res)
+		return
+	}
+
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 500; j++ {
+			key := []byte(fmt.Sprint("key", i, "-", j))
+
+			if res, err := htree.Exists(key); res != (j%3 != 0) || err != nil {
+				t.Error("Unexpected result:", string(key), res, err)
+				return
+			}
+		}
+	}
+
+	if stats, _ := htree.Stats(); stats.Keys != 8*333 {
+		t.Error("Unexpected number of keys:", stats.Keys)
+		return
+	}
+}
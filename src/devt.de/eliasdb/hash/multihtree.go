@@ -0,0 +1,297 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"encoding/binary"
+	"reflect"
+	"sync"
+
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/storage"
+)
+
+/*
+MultiHTree data structure - a HTree which stores any number of values under
+the same key. Every value is stored as a separate entry of the underlying
+tree so adding or removing a value does not rewrite the other values of a key.
+*/
+type MultiHTree struct {
+	Tree  *HTree      // Tree which stores the values
+	mutex *sync.Mutex // Mutex to protect the value lists of keys
+}
+
+/*
+NewMultiHTree creates a new MultiHTree.
+*/
+func NewMultiHTree(sm storage.Manager) (*MultiHTree, error) {
+	tree, err := NewHTree(sm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultiHTree{tree, &sync.Mutex{}}, nil
+}
+
+/*
+LoadMultiHTree fetches a MultiHTree from storage.
+*/
+func LoadMultiHTree(sm storage.Manager, loc uint64) (*MultiHTree, error) {
+	tree, err := LoadHTree(sm, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultiHTree{tree, &sync.Mutex{}}, nil
+}
+
+/*
+Location returns the MultiHTree location on disk.
+*/
+func (t *MultiHTree) Location() uint64 {
+	return t.Tree.Location()
+}
+
+/*
+Add adds a value to the values of a given key. A value can be added more
+than once.
+*/
+func (t *MultiHTree) Add(key []byte, value interface{}) error {
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "Add")
+	defer t.mutex.Unlock()
+
+	hkey := multiHeaderKey(key)
+
+	count, next, err := t.header(hkey)
+	if err != nil {
+		return err
+	}
+
+	if _, err := t.Tree.Put(multiValueKey(hkey, next), value); err != nil {
+		return err
+	}
+
+	if _, err := t.Tree.Put(hkey, []uint64{count + 1, next + 1}); err != nil {
+		t.Tree.Remove(multiValueKey(hkey, next))
+		return err
+	}
+
+	return nil
+}
+
+/*
+Get returns all values of a given key in the order they were added.
+*/
+func (t *MultiHTree) Get(key []byte) ([]interface{}, error) {
+	var ret []interface{}
+
+	it := t.Iterator(key)
+
+	for it.HasNext() {
+		ret = append(ret, it.Next())
+	}
+
+	return ret, it.LastError
+}
+
+/*
+Count returns the number of values of a given key.
+*/
+func (t *MultiHTree) Count(key []byte) (int, error) {
+	count, _, err := t.header(multiHeaderKey(key))
+
+	return int(count), err
+}
+
+/*
+Remove removes the first (oldest) occurrence of a value from the values of a
+given key. Values are compared by their content. Returns if a value was removed.
+*/
+func (t *MultiHTree) Remove(key []byte, value interface{}) (bool, error) {
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "Remove")
+	defer t.mutex.Unlock()
+
+	hkey := multiHeaderKey(key)
+
+	count, next, err := t.header(hkey)
+	if err != nil || count == 0 {
+		return false, err
+	}
+
+	for seq := uint64(0); seq < next; seq++ {
+		vkey := multiValueKey(hkey, seq)
+
+		existing, err := t.Tree.Get(vkey)
+		if err != nil {
+			return false, err
+		}
+
+		if existing == nil || !reflect.DeepEqual(existing, value) {
+			continue
+		}
+
+		if _, err := t.Tree.Remove(vkey); err != nil {
+			return false, err
+		}
+
+		// Remove the header with the last value so sequence numbers start
+		// again from 0
+
+		if count == 1 {
+			_, err = t.Tree.Remove(hkey)
+		} else {
+			_, err = t.Tree.Put(hkey, []uint64{count - 1, next})
+		}
+
+		return true, err
+	}
+
+	return false, nil
+}
+
+/*
+RemoveAll removes all values of a given key. Returns the number of removed values.
+*/
+func (t *MultiHTree) RemoveAll(key []byte) (int, error) {
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "RemoveAll")
+	defer t.mutex.Unlock()
+
+	hkey := multiHeaderKey(key)
+
+	count, next, err := t.header(hkey)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	for seq := uint64(0); seq < next; seq++ {
+		if _, err := t.Tree.Remove(multiValueKey(hkey, seq)); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = t.Tree.Remove(hkey)
+
+	return int(count), err
+}
+
+/*
+Iterator returns an iterator over all values of a given key. The values are
+returned in the order they were added. Values which are added after the
+iterator was created are not returned.
+*/
+func (t *MultiHTree) Iterator(key []byte) *MultiHTreeIterator {
+	hkey := multiHeaderKey(key)
+
+	_, next, err := t.header(hkey)
+
+	return &MultiHTreeIterator{t, hkey, 0, next, nil, false, err}
+}
+
+/*
+String returns a string representation of this tree.
+*/
+func (t *MultiHTree) String() string {
+	return "Multi" + t.Tree.String()
+}
+
+/*
+header returns the number of values of a key and the sequence number of the
+next value.
+*/
+func (t *MultiHTree) header(hkey []byte) (uint64, uint64, error) {
+	obj, err := t.Tree.Get(hkey)
+	if err != nil || obj == nil {
+		return 0, 0, err
+	}
+
+	header := obj.([]uint64)
+
+	return header[0], header[1], nil
+}
+
+/*
+multiHeaderKey returns the key of the header entry of a key. The length of
+the key is encoded in front so the header key is never a prefix of the header
+key of another key.
+*/
+func multiHeaderKey(key []byte) []byte {
+	hkey := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(key))
+
+	return append(hkey[:binary.PutUvarint(hkey, uint64(len(key)))], key...)
+}
+
+/*
+multiValueKey returns the key of the entry of a value. The sequence number is
+stored little endian - the hash function does not use the last byte of a key.
+*/
+func multiValueKey(hkey []byte, seq uint64) []byte {
+	vkey := make([]byte, len(hkey)+8)
+
+	copy(vkey, hkey)
+	binary.LittleEndian.PutUint64(vkey[len(hkey):], seq)
+
+	return vkey
+}
+
+/*
+MultiHTreeIterator data structure - an iterator over the values of a key
+*/
+type MultiHTreeIterator struct {
+	tree      *MultiHTree // Tree to iterate
+	hkey      []byte      // Header key of the iterated key
+	seq       uint64      // Sequence number of the next value which is looked up
+	next      uint64      // Sequence number after the last value
+	value     interface{} // Next value
+	fetched   bool        // Flag if the next value has been looked up
+	LastError error       // Last encountered error
+}
+
+/*
+HasNext returns if there is a next value.
+*/
+func (it *MultiHTreeIterator) HasNext() bool {
+	if it.LastError != nil {
+		return false
+	}
+
+	for !it.fetched && it.seq < it.next {
+		value, err := it.tree.Tree.Get(multiValueKey(it.hkey, it.seq))
+		if err != nil {
+			it.LastError = err
+			return false
+		}
+
+		it.seq++
+
+		if value != nil {
+			it.value = value
+			it.fetched = true
+		}
+	}
+
+	return it.fetched
+}
+
+/*
+Next returns the next value. Returns nil if there are no more values.
+*/
+func (it *MultiHTreeIterator) Next() interface{} {
+	if !it.HasNext() {
+		return nil
+	}
+
+	value := it.value
+
+	it.value = nil
+	it.fetched = false
+
+	return value
+}
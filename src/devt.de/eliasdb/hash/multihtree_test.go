@@ -0,0 +1,267 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/storage"
+	"devt.de/eliasdb/storage/file"
+)
+
+func TestMultiHTreeSerialization(t *testing.T) {
+	sm := storage.NewDiskStorageManager(DBDIR+"/multihtree1", false, false, false, false)
+
+	mtree, err := NewMultiHTree(sm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		mtree.Add([]byte(fmt.Sprint("key", i%10)), fmt.Sprint("value", i))
+	}
+
+	loc := mtree.Location()
+
+	sm.Close()
+
+	sm2 := storage.NewDiskStorageManager(DBDIR+"/multihtree1", false, false, false, false)
+
+	mtree2, _ := LoadMultiHTree(sm2, loc)
+
+	if res, err := mtree2.Get([]byte("key3")); fmt.Sprint(res) !=
+		"[value3 value13 value23 value33 value43 value53 value63 value73 value83 value93]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := mtree2.Count([]byte("key3")); res != 10 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	sm2.Close()
+}
+
+func TestMultiHTree(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	sm.AccessMap[1] = storage.AccessInsertError
+
+	if _, err := NewMultiHTree(sm); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, 1)
+
+	mtree, _ := NewMultiHTree(sm)
+
+	if res, err := mtree.Get([]byte("a")); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Keys which are prefixes of each other have separate values
+
+	mtree.Add([]byte("a"), 1)
+	mtree.Add([]byte("a"), 2)
+	mtree.Add([]byte("a"), 1)
+	mtree.Add([]byte("ab"), 3)
+	mtree.Add([]byte("a\x00\x00\x00\x00\x00\x00\x00\x00"), 4)
+
+	if res, err := mtree.Get([]byte("a")); fmt.Sprint(res) != "[1 2 1]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := mtree.Get([]byte("ab")); fmt.Sprint(res) != "[3]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Remove single values
+
+	if res, err := mtree.Remove([]byte("a"), 1); !res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := mtree.Remove([]byte("a"), 5); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := mtree.Remove([]byte("b"), 5); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := mtree.Get([]byte("a")); fmt.Sprint(res) != "[2 1]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := mtree.Count([]byte("a")); res != 2 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Values are compared by content
+
+	mtree.Add([]byte("c"), []string{"x", "y"})
+
+	if res, err := mtree.Remove([]byte("c"), []string{"x", "y"}); !res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Removing the last value removes the key
+
+	mtree.Remove([]byte("ab"), 3)
+
+	if res, err := mtree.Tree.Exists(multiHeaderKey([]byte("ab"))); res || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := mtree.RemoveAll([]byte("a")); res != 2 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := mtree.RemoveAll([]byte("a")); res != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if stats, _ := mtree.Tree.Stats(); stats.Keys != 2 {
+		t.Error("Unexpected number of entries:", stats)
+		return
+	}
+
+	// Iterate many values
+
+	for i := 0; i < 1000; i++ {
+		mtree.Add([]byte("many"), i)
+	}
+
+	it := mtree.Iterator([]byte("many"))
+
+	mtree.Add([]byte("many"), 1000)
+
+	for i := 0; i < 1000; i++ {
+		if !it.HasNext() {
+			t.Error("Iterator stopped early:", i)
+			return
+		}
+
+		if res := it.Next(); res != i {
+			t.Error("Unexpected value:", res, i)
+			return
+		}
+	}
+
+	if it.HasNext() || it.Next() != nil || it.LastError != nil {
+		t.Error("Unexpected iterator state:", it.LastError)
+		return
+	}
+
+	// Values of the same key are spread over the tree
+
+	if stats, _ := mtree.Tree.Stats(); stats.MaxBucketSize > MaxBucketElements {
+		t.Error("Unexpected bucket size:", stats)
+		return
+	}
+}
+
+func TestMultiHTreeErrors(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	mtree, _ := NewMultiHTree(sm)
+
+	if _, err := LoadMultiHTree(sm, 5); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	mtree.Add([]byte("a"), 1)
+
+	_, hloc, _ := mtree.Tree.GetValueAndLocation(multiHeaderKey([]byte("a")))
+	_, vloc, _ := mtree.Tree.GetValueAndLocation(multiValueKey(multiHeaderKey([]byte("a")), 0))
+
+	sm.AccessMap[hloc] = storage.AccessCacheAndFetchError
+
+	if err := mtree.Add([]byte("a"), 2); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := mtree.Remove([]byte("a"), 2); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := mtree.RemoveAll([]byte("a")); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := mtree.Count([]byte("a")); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := mtree.Get([]byte("a")); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, hloc)
+
+	sm.AccessMap[vloc] = storage.AccessCacheAndFetchError
+
+	if _, err := mtree.Get([]byte("a")); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := mtree.Remove([]byte("a"), 1); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, vloc)
+
+	// The value cannot be written
+
+	sm.AccessMap[sm.LocCount] = storage.AccessInsertError
+
+	if err := mtree.Add([]byte("b"), 1); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, sm.LocCount)
+
+	if res, err := mtree.Count([]byte("b")); res != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res := mtree.String(); res[:16] != "MultiHTree: test" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
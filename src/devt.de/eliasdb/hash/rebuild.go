@@ -0,0 +1,174 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+RebuildBatchSize is the number of key / value pairs which are written at once
+into a rebuilt tree
+*/
+const RebuildBatchSize = 1000
+
+/*
+Rebuild copies all key / value pairs of this tree into a new tree and then
+replaces the content of this tree with the content of the new tree. The
+location of the tree does not change. Rebuilding removes pages which are
+left over from removed keys. Readers can use the tree while it is rebuilt -
+writers wait until the rebuild is finished.
+*/
+func (t *HTree) Rebuild() error {
+	lockprof.Lock(t.rebuildMutex, lockprof.LockHTree, "Rebuild")
+	defer t.rebuildMutex.Unlock()
+
+	// Build the new tree while readers can still use this tree
+
+	lockprof.RLock(t.mutex, lockprof.LockHTree, "Rebuild")
+
+	newTree, oldLocs, err := t.copyTree()
+
+	t.mutex.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Swap the content of the root page - the new tree is visible once
+	// the root page was written
+
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "Rebuild")
+	defer t.mutex.Unlock()
+
+	sm := t.Root.sm
+	oldChildren := t.Root.Children
+
+	t.Root.Children = newTree.Root.Children
+
+	if err := sm.Update(t.Root.loc, t.Root.htreeNode); err != nil {
+		t.Root.Children = oldChildren
+		newTree.free()
+		return err
+	}
+
+	// The old nodes and the root page of the new tree are no longer
+	// referenced - errors are ignored
+
+	for _, loc := range oldLocs {
+		sm.Free(loc)
+	}
+
+	sm.Free(newTree.Root.loc)
+
+	return nil
+}
+
+/*
+copyTree copies all key / value pairs of this tree into a new tree. Returns
+the new tree and the locations of all nodes of this tree except the root page.
+*/
+func (t *HTree) copyTree() (*HTree, []uint64, error) {
+	oldLocs, err := t.nodeLocations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	newTree, err := NewHTree(t.Root.sm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([][]byte, 0, RebuildBatchSize)
+	values := make([]interface{}, 0, RebuildBatchSize)
+
+	flush := func() error {
+		err := newTree.PutBatch(keys, values)
+
+		keys = keys[:0]
+		values = values[:0]
+
+		return err
+	}
+
+	it := NewHTreeIterator(t)
+
+	for err == nil && it.HasNext() {
+		key, value := it.Next()
+
+		keys = append(keys, key)
+		values = append(values, value)
+
+		if len(keys) == RebuildBatchSize {
+			err = flush()
+		}
+	}
+
+	if err == nil {
+		if err = it.LastError; err == nil {
+			err = flush()
+		}
+	}
+
+	if err != nil {
+		newTree.free()
+		return nil, nil, err
+	}
+
+	return newTree, oldLocs, nil
+}
+
+/*
+nodeLocations returns the locations of all pages and buckets of this tree
+except the root page.
+*/
+func (t *HTree) nodeLocations() ([]uint64, error) {
+	var locs []uint64
+	var walk func(node *htreeNode) error
+
+	walk = func(node *htreeNode) error {
+
+		for _, loc := range node.Children {
+
+			if loc == 0 {
+				continue
+			}
+
+			locs = append(locs, loc)
+
+			// Nodes are shared with readers - the root page is used to
+			// fetch them so they are not changed
+
+			child, err := t.Root.fetchNode(loc)
+			if err != nil {
+				return err
+			}
+
+			if child.Children != nil {
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return locs, walk(t.Root.htreeNode)
+}
+
+/*
+free removes all nodes of this tree from the storage. Errors are ignored.
+*/
+func (t *HTree) free() {
+	t.Root.freeChildren()
+	t.Root.sm.Free(t.Root.loc)
+}
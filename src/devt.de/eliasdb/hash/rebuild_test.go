@@ -0,0 +1,236 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"devt.de/eliasdb/storage"
+	"devt.de/eliasdb/storage/file"
+)
+
+func TestHTreeRebuild(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	htree, _ := NewHTree(sm)
+
+	for i := 0; i < 3000; i++ {
+		htree.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	htree.EnableBloomFilter()
+
+	for i := 0; i < 3000; i++ {
+		if i%10 != 0 {
+			htree.Remove([]byte(fmt.Sprint("key", i)))
+		}
+	}
+
+	before, _ := htree.Stats()
+
+	// Build a reference tree which only ever contained the remaining keys
+
+	sm2 := storage.NewMemoryStorageManager("testsm2")
+
+	reference, _ := NewHTree(sm2)
+
+	for i := 0; i < 3000; i += 10 {
+		reference.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	loc := htree.Location()
+	filter := htree.Root.Filter
+
+	// Readers can use the tree during the rebuild
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 4)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 3000; j += 10 {
+				if res, err := htree.Get([]byte(fmt.Sprint("key", j))); res != j || err != nil {
+					errs <- fmt.Errorf("Unexpected result: %v %v %v", j, res, err)
+					return
+				}
+			}
+		}()
+	}
+
+	err := htree.Rebuild()
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+		return
+	}
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	after, _ := htree.Stats()
+	expected, _ := reference.Stats()
+
+	if htree.Location() != loc || htree.Root.Filter != filter {
+		t.Error("Unexpected location:", htree.Location(), htree.Root.Filter)
+		return
+	}
+
+	if after.Keys != 300 || after.Pages >= before.Pages || after.String() != expected.String() {
+		t.Error("Unexpected stats:", before, after, expected)
+		return
+	}
+
+	if err := compareHTrees(htree, reference); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Only the nodes of the rebuilt tree and the filter are stored
+
+	filterLocs := 1
+
+	if f, err := htree.fetchFilter(filter); err == nil {
+		filterLocs += len(f.Blocks)
+	}
+
+	if res := len(sm.Data); res != after.Pages+after.Buckets+filterLocs {
+		t.Error("Unexpected number of stored objects:", res, after.Pages, after.Buckets, filterLocs)
+		return
+	}
+
+	htree2, _ := LoadHTree(sm, loc)
+
+	if res, err := htree2.Get([]byte("key2990")); res != 2990 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := htree2.Get([]byte("key2991")); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Rebuild an empty tree
+
+	htree3, _ := NewHTree(sm2)
+
+	if err := htree3.Rebuild(); err != nil || !htree3.Root.IsEmpty() {
+		t.Error("Unexpected result:", err, htree3)
+		return
+	}
+}
+
+func TestHTreeRebuildErrors(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	htree, _ := NewHTree(sm)
+
+	for i := 0; i < 1000; i++ {
+		htree.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	objects := len(sm.Data)
+
+	checkUnchanged := func() error {
+		if res := len(sm.Data); res != objects {
+			return fmt.Errorf("Unexpected number of stored objects: %v %v", res, objects)
+		}
+
+		for i := 0; i < 1000; i++ {
+			if res, err := htree.Get([]byte(fmt.Sprint("key", i))); res != i || err != nil {
+				return fmt.Errorf("Unexpected result: %v %v", res, err)
+			}
+		}
+
+		return nil
+	}
+
+	// The new tree cannot be created
+
+	sm.AccessMap[sm.LocCount] = storage.AccessInsertError
+
+	if err := htree.Rebuild(); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, sm.LocCount)
+
+	if err := checkUnchanged(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The new tree cannot be filled
+
+	loc := sm.LocCount + 10
+
+	sm.AccessMap[loc] = storage.AccessInsertError
+
+	if err := htree.Rebuild(); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, loc)
+
+	if err := checkUnchanged(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The root page cannot be updated
+
+	sm.AccessMap[htree.Location()] = storage.AccessUpdateError
+
+	if err := htree.Rebuild(); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, htree.Location())
+
+	if err := checkUnchanged(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The tree cannot be read
+
+	_, loc, _ = htree.GetValueAndLocation([]byte("key1"))
+
+	sm.AccessMap[loc] = storage.AccessCacheAndFetchError
+
+	if err := htree.Rebuild(); err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	delete(sm.AccessMap, loc)
+
+	if err := checkUnchanged(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"fmt"
+
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+HTreeStats contains statistics about the structure of a HTree. A tree with a
+well distributed set of keys has few large leaf buckets and a short average
+chain length.
+*/
+type HTreeStats struct {
+	Keys             int     // Number of keys
+	Depth            int     // Depth of the deepest bucket (the root page has depth 0)
+	Pages            int     // Number of pages (including the root page)
+	Buckets          int     // Number of buckets
+	LeafBuckets      int     // Number of buckets on the lowest level which can hold any number of keys
+	MaxBucketSize    int     // Largest number of keys in a bucket
+	PageFillFactor   float64 // Average ratio of used children per page
+	BucketFillFactor float64 // Average ratio of keys per bucket to MaxBucketElements
+	AvgChainLength   float64 // Average number of keys in the bucket of a key (compared keys per lookup)
+}
+
+/*
+String returns a string representation of HTreeStats.
+*/
+func (s *HTreeStats) String() string {
+	return fmt.Sprintf("Keys: %v, Depth: %v, Pages: %v (fill: %.2f), Buckets: %v "+
+		"(leaf: %v, fill: %.2f, max size: %v), Avg chain length: %.2f",
+		s.Keys, s.Depth, s.Pages, s.PageFillFactor, s.Buckets, s.LeafBuckets,
+		s.BucketFillFactor, s.MaxBucketSize, s.AvgChainLength)
+}
+
+/*
+Stats collects statistics about the structure of this tree. All pages and
+buckets are visited so this operation can be expensive on large trees.
+*/
+func (t *HTree) Stats() (*HTreeStats, error) {
+	lockprof.Lock(t.mutex, lockprof.LockHTree, "Stats")
+	defer t.mutex.Unlock()
+
+	var usedChildren, chainLength int
+
+	stats := &HTreeStats{}
+
+	var visit func(node *htreeNode) error
+
+	visit = func(node *htreeNode) error {
+
+		if node.Children != nil {
+			stats.Pages++
+
+			for _, loc := range node.Children {
+
+				if loc == 0 {
+					continue
+				}
+
+				usedChildren++
+
+				child, err := node.fetchNode(loc)
+				if err != nil {
+					return err
+				}
+
+				child.sm = node.sm
+
+				if err := visit(child); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		bucket := &htreeBucket{node}
+		size := int(bucket.Size())
+
+		stats.Buckets++
+		stats.Keys += size
+		chainLength += size * size
+
+		if bucket.IsLeaf() {
+			stats.LeafBuckets++
+		}
+
+		if size > stats.MaxBucketSize {
+			stats.MaxBucketSize = size
+		}
+
+		if depth := int(bucket.Depth); depth > stats.Depth {
+			stats.Depth = depth
+		}
+
+		return nil
+	}
+
+	if err := visit(t.Root.htreeNode); err != nil {
+		return nil, err
+	}
+
+	stats.PageFillFactor = float64(usedChildren) / float64(stats.Pages*MaxPageChildren)
+
+	if stats.Buckets > 0 {
+		stats.BucketFillFactor = float64(stats.Keys) / float64(stats.Buckets*MaxBucketElements)
+	}
+
+	if stats.Keys > 0 {
+		stats.AvgChainLength = float64(chainLength) / float64(stats.Keys)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,75 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package hash
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/storage"
+)
+
+func TestHTreeStats(t *testing.T) {
+	sm := storage.NewMemoryStorageManager("testsm")
+
+	htree, _ := NewHTree(sm)
+
+	if res, err := htree.Stats(); err != nil || res.String() != "Keys: 0, Depth: 0, Pages: 1 (fill: 0.00), "+
+		"Buckets: 0 (leaf: 0, fill: 0.00, max size: 0), Avg chain length: 0.00" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	htree.Put([]byte("testkey1"), 1)
+	htree.Put([]byte("testkey2"), 2)
+
+	if res, err := htree.Stats(); err != nil || res.String() != "Keys: 2, Depth: 1, Pages: 1 (fill: 0.00), "+
+		"Buckets: 1 (leaf: 0, fill: 0.25, max size: 2), Avg chain length: 2.00" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// The hash of a key does not include its last byte - keys which differ
+	// only in the last byte end up in the same leaf bucket
+
+	for i := 0; i < 10000; i++ {
+		htree.Put([]byte(fmt.Sprint("key", i)), i)
+	}
+
+	if res, err := htree.Stats(); err != nil || res.String() != "Keys: 10002, Depth: 4, Pages: 2247 (fill: 0.01), "+
+		"Buckets: 1001 (leaf: 1000, fill: 1.25, max size: 10), Avg chain length: 10.00" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	htree2, _ := NewHTree(sm)
+
+	for i := 0; i < 10000; i++ {
+		htree2.Put([]byte(fmt.Sprint(i, "key")), i)
+	}
+
+	res, err := htree2.Stats()
+
+	if err != nil || res.Keys != 10000 || res.Depth > 3 || res.LeafBuckets != 0 ||
+		res.MaxBucketSize > MaxBucketElements || res.AvgChainLength > 4 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Test errors
+
+	sm.AccessMap[htree.Root.Children[0]] = storage.AccessCacheAndFetchError
+
+	if res, err := htree.Stats(); res != nil || err != storage.ErrSlotNotFound {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package lockprof contains a global profile of lock contention.
+
+Components acquire their locks through this package and name the operation
+which needs the lock:
+
+	lockprof.Lock(gm.mutex, lockprof.LockGraph, "StoreNode")
+	defer gm.mutex.Unlock()
+
+While profiling is enabled the package records for each lock and operation
+how often the lock was acquired, how often it was already held by someone
+else and how long the operation had to wait for it. Profiling is disabled by
+default - locks are then acquired directly.
+*/
+package lockprof
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Names of the profiled locks
+*/
+const (
+	LockGraph     = "graph"     // Lock of the graph manager
+	LockStorage   = "storage"   // Lock of a disk storage manager
+	LockHTree     = "htree"     // Lock of a HTree
+	LockHTreePage = "htreepage" // Latch of a HTree page
+	LockBTree     = "btree"     // Lock of a BTree
+)
+
+/*
+Locker is a lock which can be tried without blocking.
+*/
+type Locker interface {
+	Lock()
+	TryLock() bool
+}
+
+/*
+Stats contains the contention of a lock for a single operation.
+*/
+type Stats struct {
+	Lock         string        // Name of the lock
+	Op           string        // Operation which acquired the lock
+	Acquisitions uint64        // Number of times the lock was acquired
+	Contended    uint64        // Number of times the lock was held by someone else
+	Wait         time.Duration // Total time spent waiting for the lock
+	MaxWait      time.Duration // Longest time spent waiting for the lock
+}
+
+/*
+enabled is 1 if lock contention is profiled
+*/
+var enabled int32
+
+/*
+profile holds the stats of all locks and operations
+*/
+var profile = make(map[string]*Stats)
+
+/*
+lock protects the profile
+*/
+var lock = &sync.Mutex{}
+
+/*
+Enable enables or disables the profiling of lock contention.
+*/
+func Enable(enable bool) {
+	var val int32
+
+	if enable {
+		val = 1
+	}
+
+	atomic.StoreInt32(&enabled, val)
+}
+
+/*
+Enabled checks if lock contention is profiled.
+*/
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+/*
+Lock acquires a given lock on behalf of an operation.
+*/
+func Lock(l Locker, name string, op string) {
+	if !Enabled() {
+		l.Lock()
+		return
+	}
+
+	if l.TryLock() {
+		record(name, op, false, 0)
+		return
+	}
+
+	start := time.Now()
+	l.Lock()
+	record(name, op, true, time.Since(start))
+}
+
+/*
+RLock acquires the read lock of a given lock on behalf of an operation.
+*/
+func RLock(l *sync.RWMutex, name string, op string) {
+	if !Enabled() {
+		l.RLock()
+		return
+	}
+
+	if l.TryRLock() {
+		record(name, op, false, 0)
+		return
+	}
+
+	start := time.Now()
+	l.RLock()
+	record(name, op, true, time.Since(start))
+}
+
+/*
+record adds an acquisition of a lock to the profile.
+*/
+func record(name string, op string, contended bool, wait time.Duration) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	key := name + "/" + op
+
+	s, ok := profile[key]
+	if !ok {
+		s = &Stats{Lock: name, Op: op}
+		profile[key] = s
+	}
+
+	s.Acquisitions++
+
+	if contended {
+		s.Contended++
+		s.Wait += wait
+
+		if wait > s.MaxWait {
+			s.MaxWait = wait
+		}
+	}
+}
+
+/*
+Profile returns the recorded stats of all locks and operations. The stats are
+sorted by the total waiting time (longest first).
+*/
+func Profile() []Stats {
+	lock.Lock()
+	ret := make([]Stats, 0, len(profile))
+
+	for _, s := range profile {
+		ret = append(ret, *s)
+	}
+	lock.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Wait == ret[j].Wait {
+			if ret[i].Lock == ret[j].Lock {
+				return ret[i].Op < ret[j].Op
+			}
+			return ret[i].Lock < ret[j].Lock
+		}
+		return ret[i].Wait > ret[j].Wait
+	})
+
+	return ret
+}
+
+/*
+Totals returns the recorded stats summed up for each lock.
+*/
+func Totals() map[string]Stats {
+	ret := make(map[string]Stats)
+
+	for _, s := range Profile() {
+		t := ret[s.Lock]
+
+		t.Lock = s.Lock
+		t.Acquisitions += s.Acquisitions
+		t.Contended += s.Contended
+		t.Wait += s.Wait
+
+		if s.MaxWait > t.MaxWait {
+			t.MaxWait = s.MaxWait
+		}
+
+		ret[s.Lock] = t
+	}
+
+	return ret
+}
+
+/*
+Reset removes all recorded stats.
+*/
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	profile = make(map[string]*Stats)
+}
@@ -0,0 +1,111 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package lockprof
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLockProfile(t *testing.T) {
+	defer func() {
+		Enable(false)
+		Reset()
+	}()
+
+	m := &sync.Mutex{}
+	rw := &sync.RWMutex{}
+
+	// Nothing is recorded if profiling is disabled
+
+	Lock(m, LockStorage, "Insert")
+	m.Unlock()
+
+	RLock(rw, LockGraph, "FetchNode")
+	rw.RUnlock()
+
+	if Enabled() || len(Profile()) != 0 {
+		t.Error("Unexpected profile:", Profile())
+		return
+	}
+
+	Enable(true)
+
+	// Uncontended locks are only counted
+
+	Lock(m, LockStorage, "Insert")
+	m.Unlock()
+
+	Lock(m, LockStorage, "Insert")
+	m.Unlock()
+
+	RLock(rw, LockGraph, "FetchNode")
+
+	// Readers do not wait for each other
+
+	RLock(rw, LockGraph, "FetchNode")
+	rw.RUnlock()
+
+	// A writer waits for the reader
+
+	done := make(chan bool)
+
+	go func() {
+		Lock(rw, LockGraph, "StoreNode")
+		rw.Unlock()
+		done <- true
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	rw.RUnlock()
+	<-done
+
+	p := Profile()
+
+	if len(p) != 3 {
+		t.Error("Unexpected profile:", p)
+		return
+	}
+
+	if s := p[0]; s.Lock != LockGraph || s.Op != "StoreNode" || s.Acquisitions != 1 ||
+		s.Contended != 1 || s.Wait < 10*time.Millisecond || s.MaxWait != s.Wait {
+		t.Error("Unexpected stats:", s)
+		return
+	}
+
+	if s := p[1]; s.Lock != LockGraph || s.Op != "FetchNode" || s.Acquisitions != 2 ||
+		s.Contended != 0 || s.Wait != 0 {
+		t.Error("Unexpected stats:", s)
+		return
+	}
+
+	if s := p[2]; s.Lock != LockStorage || s.Op != "Insert" || s.Acquisitions != 2 ||
+		s.Contended != 0 {
+		t.Error("Unexpected stats:", s)
+		return
+	}
+
+	totals := Totals()
+
+	if s := totals[LockGraph]; len(totals) != 2 || s.Acquisitions != 3 || s.Contended != 1 ||
+		s.Wait != p[0].Wait || s.Op != "" {
+		t.Error("Unexpected totals:", totals)
+		return
+	}
+
+	Reset()
+
+	if len(Profile()) != 0 {
+		t.Error("Unexpected profile:", Profile())
+		return
+	}
+}
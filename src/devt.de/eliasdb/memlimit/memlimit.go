@@ -0,0 +1,261 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+/*
+Package memlimit contains a global soft memory limit.
+
+A monitor checks the heap size of the process in regular intervals. Once the
+heap exceeds the limit all registered shrinkers are called so caches can
+release their memory and the memory is returned to the operating system.
+While the limit is exceeded expensive operations should be rejected:
+
+	if err := memlimit.Check(); err != nil {
+		return err
+	}
+
+The returned error is retryable - it belongs to the error category
+errorutil.ErrUnavailable.
+*/
+package memlimit
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"devt.de/common/errorutil"
+)
+
+/*
+ErrMemoryLimit is returned by Check if the soft memory limit is exceeded
+*/
+var ErrMemoryLimit = errorutil.NewCategorizedError(errorutil.ErrUnavailable,
+	"Soft memory limit exceeded - try again later")
+
+/*
+RetryAfter is the suggested time which clients should wait before retrying
+a rejected operation
+*/
+var RetryAfter = 10 * time.Second
+
+/*
+Logger is a function which processes log messages of the memory monitor
+*/
+type Logger func(v ...interface{})
+
+/*
+LogLimit is called when the soft memory limit is exceeded or when the memory
+usage drops below the limit again
+*/
+var LogLimit Logger = func(v ...interface{}) {}
+
+/*
+HeapSize returns the current heap size of the process
+*/
+var HeapSize = func() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+/*
+Shrinker is a function which releases memory (e.g. by emptying a cache)
+*/
+type Shrinker func()
+
+/*
+limit is the soft memory limit in bytes (0 if there is no limit)
+*/
+var limit uint64
+
+/*
+exceeded is 1 if the soft memory limit is currently exceeded
+*/
+var exceeded int32
+
+/*
+shrinkers holds all registered shrinkers
+*/
+var shrinkers = make(map[int]Shrinker)
+
+/*
+shrinkerID is the id of the last registered shrinker
+*/
+var shrinkerID int
+
+/*
+monitorStop is used to stop a running monitor (nil if no monitor is running)
+*/
+var monitorStop chan bool
+
+/*
+monitorWg is used to wait for a running monitor
+*/
+var monitorWg = &sync.WaitGroup{}
+
+/*
+lock protects the shrinkers and the monitor
+*/
+var lock = &sync.Mutex{}
+
+/*
+SetLimit sets the soft memory limit in bytes. A limit of 0 removes the limit.
+*/
+func SetLimit(bytes uint64) {
+	atomic.StoreUint64(&limit, bytes)
+
+	if bytes == 0 {
+		atomic.StoreInt32(&exceeded, 0)
+	}
+}
+
+/*
+Limit returns the soft memory limit in bytes (0 if there is no limit).
+*/
+func Limit() uint64 {
+	return atomic.LoadUint64(&limit)
+}
+
+/*
+Exceeded checks if the soft memory limit was exceeded when the memory usage
+was last checked.
+*/
+func Exceeded() bool {
+	return atomic.LoadInt32(&exceeded) == 1
+}
+
+/*
+Check returns ErrMemoryLimit if the soft memory limit is exceeded.
+*/
+func Check() error {
+	if Exceeded() {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+/*
+AddShrinker registers a function which is called to release memory while the
+soft memory limit is exceeded. Returns an id which can be used to remove the
+shrinker.
+*/
+func AddShrinker(s Shrinker) int {
+	lock.Lock()
+	defer lock.Unlock()
+
+	shrinkerID++
+	shrinkers[shrinkerID] = s
+
+	return shrinkerID
+}
+
+/*
+RemoveShrinker removes a registered shrinker.
+*/
+func RemoveShrinker(id int) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	delete(shrinkers, id)
+}
+
+/*
+Update checks the memory usage against the soft memory limit. If the limit is
+exceeded all shrinkers are called and unused memory is returned to the
+operating system. Returns if the limit is exceeded.
+*/
+func Update() bool {
+	l := Limit()
+
+	if l == 0 {
+		return false
+	}
+
+	heap := HeapSize()
+
+	if heap <= l {
+		if atomic.SwapInt32(&exceeded, 0) == 1 {
+			LogLimit(fmt.Sprintf("Memory usage is below the soft memory limit again: %v of %v bytes",
+				heap, l))
+		}
+		return false
+	}
+
+	if atomic.SwapInt32(&exceeded, 1) == 0 {
+		LogLimit(fmt.Sprintf("Soft memory limit exceeded: %v of %v bytes - shedding load",
+			heap, l))
+	}
+
+	lock.Lock()
+	toCall := make([]Shrinker, 0, len(shrinkers))
+	for _, s := range shrinkers {
+		toCall = append(toCall, s)
+	}
+	lock.Unlock()
+
+	for _, s := range toCall {
+		s()
+	}
+
+	debug.FreeOSMemory()
+
+	return true
+}
+
+/*
+StartMonitor starts a background goroutine which calls Update in a given
+interval. A running monitor is replaced.
+*/
+func StartMonitor(interval time.Duration) {
+	StopMonitor()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	stop := make(chan bool)
+	monitorStop = stop
+
+	monitorWg.Add(1)
+
+	go func() {
+		defer monitorWg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+
+			case <-ticker.C:
+				Update()
+			}
+		}
+	}()
+}
+
+/*
+StopMonitor stops the background monitor.
+*/
+func StopMonitor() {
+	lock.Lock()
+	stop := monitorStop
+	monitorStop = nil
+	lock.Unlock()
+
+	if stop != nil {
+		close(stop)
+		monitorWg.Wait()
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package memlimit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"devt.de/common/errorutil"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	var heap uint64 = 100
+	var logs []string
+
+	oldHeapSize := HeapSize
+	HeapSize = func() uint64 {
+		return heap
+	}
+	LogLimit = func(v ...interface{}) {
+		logs = append(logs, v[0].(string))
+	}
+	defer func() {
+		HeapSize = oldHeapSize
+		LogLimit = func(v ...interface{}) {}
+		SetLimit(0)
+	}()
+
+	shrunk := 0
+
+	id := AddShrinker(func() {
+		shrunk++
+	})
+
+	// No limit is set
+
+	if Update() || Exceeded() || Check() != nil || Limit() != 0 {
+		t.Error("Limit should not be exceeded")
+		return
+	}
+
+	SetLimit(150)
+
+	if Update() || Check() != nil || Limit() != 150 {
+		t.Error("Limit should not be exceeded")
+		return
+	}
+
+	heap = 200
+
+	if !Update() || !Exceeded() || shrunk != 1 {
+		t.Error("Limit should be exceeded", shrunk)
+		return
+	}
+
+	if err := Check(); err != ErrMemoryLimit || !errorutil.IsCategory(err, errorutil.ErrUnavailable) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Shrinkers are called for every update while the limit is exceeded
+
+	Update()
+
+	if shrunk != 2 || len(logs) != 1 {
+		t.Error("Unexpected result:", shrunk, logs)
+		return
+	}
+
+	RemoveShrinker(id)
+
+	heap = 100
+
+	if Update() || Exceeded() || shrunk != 2 {
+		t.Error("Limit should not be exceeded", shrunk)
+		return
+	}
+
+	if len(logs) != 2 || !strings.HasPrefix(logs[0], "Soft memory limit exceeded: 200 of 150 bytes") ||
+		!strings.HasPrefix(logs[1], "Memory usage is below the soft memory limit again") {
+		t.Error("Unexpected logs:", logs)
+		return
+	}
+
+	// Removing the limit resets the state
+
+	heap = 200
+	Update()
+
+	SetLimit(0)
+
+	if Exceeded() {
+		t.Error("Limit should not be exceeded")
+		return
+	}
+}
+
+func TestMemoryMonitor(t *testing.T) {
+	oldHeapSize := HeapSize
+	HeapSize = func() uint64 {
+		return 1000
+	}
+	defer func() {
+		HeapSize = oldHeapSize
+		SetLimit(0)
+	}()
+
+	SetLimit(10)
+
+	called := make(chan bool, 10)
+
+	id := AddShrinker(func() {
+		called <- true
+	})
+	defer RemoveShrinker(id)
+
+	StartMonitor(time.Millisecond)
+	StartMonitor(time.Millisecond)
+
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Error("Monitor did not call the shrinker")
+	}
+
+	StopMonitor()
+	StopMonitor()
+
+	if !Exceeded() {
+		t.Error("Limit should be exceeded")
+		return
+	}
+}
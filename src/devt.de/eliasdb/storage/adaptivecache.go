@@ -0,0 +1,210 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"devt.de/eliasdb/memlimit"
+)
+
+/*
+DefaultAdaptInterval is the default time between two adjustments of an
+adaptive cache size
+*/
+var DefaultAdaptInterval = 10 * time.Second
+
+/*
+AdaptMinHitRate is the hit rate below which a full cache is grown
+*/
+var AdaptMinHitRate = 0.9
+
+/*
+AdaptMemoryPressure is the fraction of the soft memory limit (see
+memlimit.SetLimit) above which caches are shrunk
+*/
+var AdaptMemoryPressure = 0.8
+
+/*
+AdaptStep is the fraction by which the size of a cache is changed in one
+adjustment
+*/
+var AdaptStep = 0.25
+
+/*
+adaptiveSize holds the state of an adaptive cache size.
+*/
+type adaptiveSize struct {
+	min  int             // Min number of cached objects
+	max  int             // Max number of cached objects
+	last CacheStats      // Counters at the last adjustment
+	stop chan bool       // Channel to stop the background adjustment
+	wg   *sync.WaitGroup // Waitgroup for the background adjustment
+}
+
+/*
+MaxObjects returns the current max number of objects in the cache. Returns
+the limit of the shared cache if the storage manager is attached to one.
+*/
+func (cdsm *CachedDiskStorageManager) MaxObjects() int {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	if cdsm.shared != nil {
+		return cdsm.shared.maxObjects
+	}
+
+	return cdsm.maxObjects
+}
+
+/*
+StartAdaptiveSize adjusts the max number of cached objects in a given interval
+(DefaultAdaptInterval if the interval is 0) between given bounds. A full cache
+is grown if its hit rate is below AdaptMinHitRate. A cache is shrunk if it is
+mostly unused or if the heap has reached AdaptMemoryPressure of the soft
+memory limit. Storage managers which are attached to a shared cache keep the
+size of the shared cache. A running adjustment is replaced.
+*/
+func (cdsm *CachedDiskStorageManager) StartAdaptiveSize(min int, max int, interval time.Duration) {
+	cdsm.StopAdaptiveSize()
+
+	if cdsm.shared != nil {
+		return
+	}
+
+	if interval == 0 {
+		interval = DefaultAdaptInterval
+	}
+
+	as := &adaptiveSize{min, max, CacheStats{}, make(chan bool), &sync.WaitGroup{}}
+
+	cdsm.mutex.Lock()
+
+	as.last = cdsm.stats
+	cdsm.adaptive = as
+	cdsm.resize(cdsm.maxObjects)
+
+	cdsm.mutex.Unlock()
+
+	as.wg.Add(1)
+
+	go func() {
+		defer as.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-as.stop:
+				return
+
+			case <-ticker.C:
+				cdsm.AdaptSize()
+			}
+		}
+	}()
+}
+
+/*
+StopAdaptiveSize stops the adjustment of the cache size. The cache keeps its
+current size.
+*/
+func (cdsm *CachedDiskStorageManager) StopAdaptiveSize() {
+	cdsm.mutex.Lock()
+	as := cdsm.adaptive
+	cdsm.adaptive = nil
+	cdsm.mutex.Unlock()
+
+	if as != nil {
+		close(as.stop)
+		as.wg.Wait()
+	}
+}
+
+/*
+AdaptSize adjusts the cache size once based on the counters since the last
+adjustment and returns the new max number of cached objects. This function
+is called by the background adjustment (see StartAdaptiveSize).
+*/
+func (cdsm *CachedDiskStorageManager) AdaptSize() int {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	as := cdsm.adaptive
+	if as == nil {
+		return cdsm.maxObjects
+	}
+
+	// Counters might have been reset since the last adjustment
+
+	if cdsm.stats.Hits < as.last.Hits || cdsm.stats.Misses < as.last.Misses ||
+		cdsm.stats.Evictions < as.last.Evictions {
+
+		as.last = CacheStats{}
+	}
+
+	hits := cdsm.stats.Hits - as.last.Hits
+	misses := cdsm.stats.Misses - as.last.Misses
+	evictions := cdsm.stats.Evictions - as.last.Evictions
+
+	as.last = cdsm.stats
+
+	step := int(float64(cdsm.maxObjects)*AdaptStep) + 1
+	size := cdsm.maxObjects
+
+	if limit := memlimit.Limit(); limit > 0 &&
+		float64(memlimit.HeapSize()) >= float64(limit)*AdaptMemoryPressure {
+
+		// Give memory back under memory pressure
+
+		size -= step
+
+	} else if evictions > 0 && hits+misses > 0 &&
+		float64(hits)/float64(hits+misses) < AdaptMinHitRate {
+
+		// Grow a full cache which misses too many requests
+
+		size += step
+
+	} else if evictions == 0 && len(cdsm.cache) < cdsm.maxObjects/2 {
+
+		// Shrink a cache which is mostly unused
+
+		size -= step
+	}
+
+	cdsm.resize(size)
+
+	return cdsm.maxObjects
+}
+
+/*
+resize sets the max number of cached objects within the bounds of the
+adaptive size and evicts objects if necessary. It is assumed that the caller
+holds the mutex.
+*/
+func (cdsm *CachedDiskStorageManager) resize(size int) {
+	as := cdsm.adaptive
+
+	if size < as.min {
+		size = as.min
+	} else if size > as.max {
+		size = as.max
+	}
+
+	cdsm.maxObjects = size
+
+	for len(cdsm.cache) > cdsm.maxObjects {
+		entryPool.Put(cdsm.removeVictimFromCache())
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"devt.de/eliasdb/memlimit"
+)
+
+func TestCachedDiskStorageManagerAdaptiveSize(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/ctest12", false, false, true, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 100)
+
+	// The size is kept within the bounds
+
+	cdsm.StartAdaptiveSize(4, 40, time.Hour)
+
+	if res := cdsm.MaxObjects(); res != 40 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	cdsm.StopAdaptiveSize()
+	cdsm.StartAdaptiveSize(4, 10, time.Hour)
+
+	if res := cdsm.MaxObjects(); res != 10 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	cdsm.StopAdaptiveSize()
+	cdsm.StartAdaptiveSize(4, 40, time.Hour)
+
+	var locs []uint64
+
+	for i := 0; i < 20; i++ {
+		loc, _ := cdsm.Insert(fmt.Sprint("test", i))
+		locs = append(locs, loc)
+	}
+
+	// A full cache which misses too many requests is grown
+
+	for _, loc := range locs {
+		cdsm.FetchCached(loc)
+	}
+
+	if res := cdsm.AdaptSize(); res != 13 {
+		t.Error("Unexpected result:", res, cdsm.Stats())
+		return
+	}
+
+	// Nothing changes without new requests
+
+	if res := cdsm.AdaptSize(); res != 13 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The cache is shrunk under memory pressure
+
+	oldHeapSize := memlimit.HeapSize
+	memlimit.HeapSize = func() uint64 {
+		return 100
+	}
+	memlimit.SetLimit(100)
+
+	res := cdsm.AdaptSize()
+
+	memlimit.HeapSize = oldHeapSize
+	memlimit.SetLimit(0)
+
+	if res != 9 || len(cdsm.cache) != 9 {
+		t.Error("Unexpected result:", res, len(cdsm.cache))
+		return
+	}
+
+	// A mostly unused cache is shrunk down to the lower bound
+
+	cdsm.shrink()
+	cdsm.ResetStats()
+
+	for i := 0; i < 5; i++ {
+		res = cdsm.AdaptSize()
+	}
+
+	if res != 4 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Storage managers of a shared cache are not adapted
+
+	scdsm := NewSharedCachedDiskStorageManager(NewDiskStorageManager(DBDIR+"/ctest12a",
+		false, false, true, true), NewSharedCache(5))
+
+	scdsm.StartAdaptiveSize(1, 2, time.Millisecond)
+
+	if res := scdsm.AdaptSize(); res != 5 || scdsm.MaxObjects() != 5 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// The background adjustment is stopped on close
+
+	cdsm.StartAdaptiveSize(4, 40, time.Millisecond)
+
+	if err := cdsm.Close(); err != nil || cdsm.adaptive != nil {
+		t.Error("Unexpected result:", err, cdsm.adaptive)
+		return
+	}
+
+	scdsm.Close()
+}
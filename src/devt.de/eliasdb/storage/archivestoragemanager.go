@@ -0,0 +1,555 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"devt.de/common/fileutil"
+	"devt.de/eliasdb/compress"
+	"devt.de/eliasdb/storage/util"
+)
+
+/*
+FileSuffixArchive is the file ending for an archive segment
+*/
+const FileSuffixArchive = "arc"
+
+/*
+ArchiveMagic is the magic number which starts an archive segment
+*/
+var ArchiveMagic = []byte{0x45, 0x41}
+
+/*
+ArchiveBlockSize is the number of uncompressed bytes which are collected into
+a single compressed block of an archive segment.
+*/
+var ArchiveBlockSize = 64 * 1024
+
+/*
+ArchiveCodec is the name of the compression codec which is used for new
+archive segments.
+*/
+var ArchiveCodec = "flate"
+
+/*
+archiveIndex is the index of an archive segment.
+*/
+type archiveIndex struct {
+	Roots   []uint64                 // Root values of the archived storage
+	Blocks  []int64                  // File offsets of all compressed blocks
+	Records map[uint64]archiveRecord // Location of all records
+}
+
+/*
+archiveRecord is the location of a record inside an archive segment.
+*/
+type archiveRecord struct {
+	Block  uint32 // Block of the record
+	Offset uint32 // Offset of the record in the uncompressed block
+	Size   uint32 // Size of the record
+}
+
+/*
+ArchiveStorageManager is a read-only storage manager which serves objects from
+a compressed archive segment. Archive segments are created from a
+DiskStorageManager with CreateArchive.
+*/
+type ArchiveStorageManager struct {
+	filename    string        // Filename for the archive segment
+	file        *os.File      // Archive segment file
+	mutex       *sync.Mutex   // Mutex to protect the file and the block cache
+	index       *archiveIndex // Index of the archive segment
+	indexOffset int64         // File offset of the index (end of the last block)
+	fileSize    int64         // Size of the archive segment file
+	block       int           // Number of the currently decompressed block (-1 for none)
+	blockData   []byte        // Data of the currently decompressed block
+}
+
+/*
+ArchiveFileExist checks if an archive segment exists for a given filename.
+*/
+func ArchiveFileExist(filename string) bool {
+	ret, err := fileutil.PathExists(fmt.Sprintf("%v.%v", filename, FileSuffixArchive))
+
+	if err != nil {
+		return false
+	}
+
+	return ret
+}
+
+/*
+CreateArchive writes all stored records of a DiskStorageManager into a
+compressed archive segment next to its data files. The segment is written to
+a temporary file first and only appears once it is complete. Pending changes
+should be flushed before calling this function.
+*/
+func CreateArchive(dsm *DiskStorageManager) error {
+	bdsm := dsm.ByteDiskStorageManager
+
+	bdsm.checkFileOpen()
+
+	// Continue single threaded from here on
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	filename := fmt.Sprintf("%v.%v", bdsm.filename, FileSuffixArchive)
+
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	header := bdsm.physicalSlotsPager.Header()
+
+	index := &archiveIndex{make([]uint64, header.Roots()), nil, make(map[uint64]archiveRecord)}
+
+	for i := range index.Roots {
+		index.Roots[i] = header.Root(i)
+	}
+
+	var block bytes.Buffer
+	var offset int64
+
+	w := bufio.NewWriter(f)
+
+	if _, err = w.Write(ArchiveMagic); err != nil {
+		f.Close()
+		return err
+	}
+
+	offset = int64(len(ArchiveMagic))
+
+	// writeBlock compresses the collected records into a new block
+
+	writeBlock := func() error {
+		if block.Len() == 0 {
+			return nil
+		}
+
+		cw := &countingWriter{w, 0}
+
+		zw, err := compress.NewWriter(cw, ArchiveCodec, compress.DefaultLevel)
+		if err == nil {
+			if _, err = zw.Write(block.Bytes()); err == nil {
+				err = zw.Close()
+			}
+		}
+
+		index.Blocks = append(index.Blocks, offset)
+		offset += cw.count
+
+		block.Reset()
+
+		return err
+	}
+
+	err = bdsm.logicalSlotManager.ForEach(func(loc uint64, ploc uint64) error {
+		start := block.Len()
+
+		if err := bdsm.physicalSlotManager.Fetch(ploc, &block); err != nil {
+			return err
+		}
+
+		index.Records[loc] = archiveRecord{uint32(len(index.Blocks)), uint32(start),
+			uint32(block.Len() - start)}
+
+		if block.Len() >= ArchiveBlockSize {
+			return writeBlock()
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		err = writeBlock()
+	}
+
+	// Write the index followed by its offset
+
+	if err == nil {
+		if err = gob.NewEncoder(w).Encode(index); err == nil {
+			if err = binary.Write(w, binary.BigEndian, offset); err == nil {
+				err = w.Flush()
+			}
+		}
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+
+	return err
+}
+
+/*
+RemoveDataFiles removes all data files of a disk storage manager. The storage
+manager must be closed.
+*/
+func RemoveDataFiles(filename string) error {
+	suffixes := []string{FileSuffixLogicalSlots, FileSuffixLogicalFreeSlots,
+		FileSuffixPhysicalSlots, FileSuffixPhysicalFreeSlots, FileSuffixBlobSlots}
+
+	for _, suffix := range suffixes {
+		files, err := filepath.Glob(fmt.Sprintf("%v.%v.*", filename, suffix))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			if err := os.Remove(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	if AccessProfileExist(filename) {
+		if err := os.Remove(profileFilename(filename)); err != nil {
+			return err
+		}
+	}
+
+	lockfile := fmt.Sprintf("%v.%v", filename, FileSiffixLockfile)
+
+	if ok, _ := fileutil.PathExists(lockfile); ok {
+		return os.Remove(lockfile)
+	}
+
+	return nil
+}
+
+/*
+CopyDataFiles copies all data files and transaction logs of a disk storage
+manager to a given directory. The files must not be changed while they are
+copied.
+*/
+func CopyDataFiles(filename string, targetDir string) error {
+	suffixes := []string{FileSuffixLogicalSlots, FileSuffixLogicalFreeSlots,
+		FileSuffixPhysicalSlots, FileSuffixPhysicalFreeSlots, FileSuffixBlobSlots}
+
+	for _, suffix := range suffixes {
+		files, err := filepath.Glob(fmt.Sprintf("%v.%v.*", filename, suffix))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			if err := CopyFile(f, filepath.Join(targetDir, filepath.Base(f))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+CopyFile copies a single file. The copy is synced to disk.
+*/
+func CopyFile(src string, dst string) error {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+
+	if err == nil {
+		err = out.Sync()
+	}
+
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+/*
+NewArchiveStorageManager opens an existing archive segment.
+*/
+func NewArchiveStorageManager(filename string) (*ArchiveStorageManager, error) {
+
+	f, err := os.Open(fmt.Sprintf("%v.%v", filename, FileSuffixArchive))
+	if err != nil {
+		return nil, err
+	}
+
+	asm := &ArchiveStorageManager{filename, f, &sync.Mutex{}, nil, 0, 0, -1, nil}
+
+	if err = asm.readIndex(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return asm, nil
+}
+
+/*
+readIndex reads the index of the archive segment.
+*/
+func (asm *ArchiveStorageManager) readIndex() error {
+	magic := make([]byte, len(ArchiveMagic))
+
+	info, err := asm.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	asm.fileSize = info.Size()
+
+	if _, err = asm.file.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, ArchiveMagic) ||
+		asm.fileSize < int64(len(ArchiveMagic))+8 {
+		return ErrInvalidArchive.fireError(asm, "Invalid header")
+	}
+
+	// The offset of the index is stored at the end of the file
+
+	if _, err = asm.file.Seek(asm.fileSize-8, io.SeekStart); err == nil {
+		err = binary.Read(asm.file, binary.BigEndian, &asm.indexOffset)
+	}
+
+	if err != nil || asm.indexOffset < int64(len(ArchiveMagic)) || asm.indexOffset > asm.fileSize-8 {
+		return ErrInvalidArchive.fireError(asm, "Invalid index offset")
+	}
+
+	index := &archiveIndex{}
+
+	r := io.NewSectionReader(asm.file, asm.indexOffset, asm.fileSize-8-asm.indexOffset)
+
+	if err := gob.NewDecoder(r).Decode(index); err != nil {
+		return ErrInvalidArchive.fireError(asm, fmt.Sprint("Invalid index: ", err))
+	}
+
+	asm.index = index
+
+	return nil
+}
+
+/*
+Name returns the name of the StorageManager instance.
+*/
+func (asm *ArchiveStorageManager) Name() string {
+	return fmt.Sprint("ArchiveStorageFile:", asm.filename)
+}
+
+/*
+Root returns a root value.
+*/
+func (asm *ArchiveStorageManager) Root(root int) uint64 {
+	if root < len(asm.index.Roots) {
+		return asm.index.Roots[root]
+	}
+	return 0
+}
+
+/*
+SetRoot is a NOP since an archive is readonly.
+*/
+func (asm *ArchiveStorageManager) SetRoot(root int, val uint64) {
+}
+
+/*
+Insert returns an error since an archive is readonly.
+*/
+func (asm *ArchiveStorageManager) Insert(o interface{}) (uint64, error) {
+	return 0, ErrReadonly
+}
+
+/*
+Update returns an error since an archive is readonly.
+*/
+func (asm *ArchiveStorageManager) Update(loc uint64, o interface{}) error {
+	return ErrReadonly
+}
+
+/*
+Free returns an error since an archive is readonly.
+*/
+func (asm *ArchiveStorageManager) Free(loc uint64) error {
+	return ErrReadonly
+}
+
+/*
+Fetch fetches an object from a given storage location and writes it to
+a given data container. The block which contains the object needs to be
+decompressed if it was not the block of the previous request.
+*/
+func (asm *ArchiveStorageManager) Fetch(loc uint64, o interface{}) error {
+
+	rec, ok := asm.index.Records[loc]
+	if !ok {
+		return ErrSlotNotFound.fireError(asm, fmt.Sprint("Location:",
+			util.LocationRecord(loc), util.LocationOffset(loc)))
+	}
+
+	asm.mutex.Lock()
+
+	data, err := asm.fetchBlock(int(rec.Block))
+	if err == nil && uint64(rec.Offset)+uint64(rec.Size) > uint64(len(data)) {
+		err = ErrInvalidArchive.fireError(asm, fmt.Sprint("Invalid record location: ", loc))
+	}
+
+	if err != nil {
+		asm.mutex.Unlock()
+		return err
+	}
+
+	// Copy the record so the block can be replaced while decoding
+
+	b := make([]byte, rec.Size)
+	copy(b, data[rec.Offset:])
+
+	asm.mutex.Unlock()
+
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(o)
+}
+
+/*
+fetchBlock returns the uncompressed data of a block.
+*/
+func (asm *ArchiveStorageManager) fetchBlock(block int) ([]byte, error) {
+
+	if block == asm.block {
+		return asm.blockData, nil
+	}
+
+	if block >= len(asm.index.Blocks) {
+		return nil, ErrInvalidArchive.fireError(asm, fmt.Sprint("Unknown block: ", block))
+	}
+
+	end := asm.indexOffset
+	if block+1 < len(asm.index.Blocks) {
+		end = asm.index.Blocks[block+1]
+	}
+
+	start := asm.index.Blocks[block]
+
+	zr, err := compress.NewReader(io.NewSectionReader(asm.file, start, end-start))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	asm.block = block
+	asm.blockData = data
+
+	return data, nil
+}
+
+/*
+FetchCached is not implemented for an ArchiveStorageManager.
+Only defined to satisfy the StorageManager interface.
+*/
+func (asm *ArchiveStorageManager) FetchCached(loc uint64) (interface{}, error) {
+	return nil, ErrNotInCache
+}
+
+/*
+Flush is a NOP since an archive is readonly.
+*/
+func (asm *ArchiveStorageManager) Flush() error {
+	return nil
+}
+
+/*
+Rollback is a NOP since an archive is readonly.
+*/
+func (asm *ArchiveStorageManager) Rollback() error {
+	return nil
+}
+
+/*
+Close closes the archive segment.
+*/
+func (asm *ArchiveStorageManager) Close() error {
+	asm.mutex.Lock()
+	defer asm.mutex.Unlock()
+
+	asm.block = -1
+	asm.blockData = nil
+
+	return asm.file.Close()
+}
+
+/*
+MemoryUsage returns the memory which is currently held.
+*/
+func (asm *ArchiveStorageManager) MemoryUsage() *MemoryUsage {
+	asm.mutex.Lock()
+	defer asm.mutex.Unlock()
+
+	return &MemoryUsage{Records: uint64(len(asm.blockData))}
+}
+
+/*
+SizeReport returns the size of all stored data. The allocated bytes are the
+compressed size of the archive segment.
+*/
+func (asm *ArchiveStorageManager) SizeReport() (*SizeReport, error) {
+	ret := &SizeReport{Slots: uint64(len(asm.index.Records)), SlotBytes: uint64(asm.fileSize)}
+
+	for _, rec := range asm.index.Records {
+		ret.DataBytes += uint64(rec.Size)
+	}
+
+	return ret, nil
+}
+
+/*
+countingWriter is a writer which counts the written bytes.
+*/
+type countingWriter struct {
+	w     io.Writer // Wrapped writer
+	count int64     // Number of written bytes
+}
+
+/*
+Write writes to the wrapped writer.
+*/
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count += int64(n)
+	return n, err
+}
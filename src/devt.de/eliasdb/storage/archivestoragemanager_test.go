@@ -0,0 +1,213 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestArchiveStorageManager(t *testing.T) {
+	filename := DBDIR + "/atest1"
+
+	oldBlockSize := ArchiveBlockSize
+	ArchiveBlockSize = 1024
+	defer func() {
+		ArchiveBlockSize = oldBlockSize
+	}()
+
+	dsm := NewDiskStorageManager(filename, false, false, true, true)
+
+	var locs []uint64
+	var values []string
+
+	for i := 0; i < 100; i++ {
+		value := fmt.Sprint("value", i, strings.Repeat("x", i))
+
+		loc, err := dsm.Insert(value)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		locs = append(locs, loc)
+		values = append(values, value)
+	}
+
+	dsm.SetRoot(2, locs[5])
+
+	if err := dsm.Free(locs[10]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if ArchiveFileExist(filename) {
+		t.Error("Archive should not exist yet")
+		return
+	}
+
+	if err := CreateArchive(dsm); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := RemoveDataFiles(filename); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !ArchiveFileExist(filename) || DataFileExist(filename) {
+		t.Error("Unexpected files:", ArchiveFileExist(filename), DataFileExist(filename))
+		return
+	}
+
+	asm, err := NewArchiveStorageManager(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if asm.Name() != "ArchiveStorageFile:"+filename {
+		t.Error("Unexpected name:", asm.Name())
+		return
+	}
+
+	if asm.Root(2) != locs[5] || asm.Root(1000) != 0 {
+		t.Error("Unexpected root:", asm.Root(2))
+		return
+	}
+
+	if len(asm.index.Blocks) < 2 {
+		t.Error("Records should be stored in several blocks:", len(asm.index.Blocks))
+		return
+	}
+
+	// Fetch all records in reverse order so every block is decompressed again
+
+	for i := len(locs) - 1; i >= 0; i-- {
+		var res string
+
+		err := asm.Fetch(locs[i], &res)
+
+		if i == 10 {
+			if err == nil || !strings.HasPrefix(err.Error(), "Slot not found") {
+				t.Error("Unexpected result:", err)
+				return
+			}
+		} else if err != nil || res != values[i] {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+
+	// All write operations are rejected
+
+	if _, err := asm.Insert("test"); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := asm.Update(locs[0], "test"); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := asm.Free(locs[0]); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	asm.SetRoot(2, 0)
+
+	if asm.Root(2) != locs[5] {
+		t.Error("Unexpected root:", asm.Root(2))
+		return
+	}
+
+	if _, err := asm.FetchCached(locs[0]); err != ErrNotInCache {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if asm.Flush() != nil || asm.Rollback() != nil {
+		t.Error("Flush and rollback should succeed")
+		return
+	}
+
+	sr, err := asm.SizeReport()
+	if err != nil || sr.Slots != 99 || sr.DataBytes == 0 || sr.SlotBytes >= sr.DataBytes {
+		t.Error("Unexpected size report:", sr, err)
+		return
+	}
+
+	if mu := asm.MemoryUsage(); mu.Records == 0 {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	if err := asm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestArchiveStorageManagerErrors(t *testing.T) {
+	filename := DBDIR + "/atest2"
+
+	if _, err := NewArchiveStorageManager(filename); err == nil {
+		t.Error("Opening a non-existing archive should fail")
+		return
+	}
+
+	f, _ := os.Create(filename + "." + FileSuffixArchive)
+	f.Write([]byte("test"))
+	f.Close()
+
+	if _, err := NewArchiveStorageManager(filename); err == nil ||
+		err.Error() != "Invalid archive segment (ArchiveStorageFile:"+filename+" - Invalid header)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	f, _ = os.Create(filename + "." + FileSuffixArchive)
+	f.Write(ArchiveMagic)
+	f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0xff})
+	f.Close()
+
+	if _, err := NewArchiveStorageManager(filename); err == nil ||
+		err.Error() != "Invalid archive segment (ArchiveStorageFile:"+filename+" - Invalid index offset)" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	f, _ = os.Create(filename + "." + FileSuffixArchive)
+	f.Write(ArchiveMagic)
+	f.Write([]byte{0, 0, 0, 0, 0, 0, 0, 2})
+	f.Close()
+
+	if _, err := NewArchiveStorageManager(filename); err == nil ||
+		!strings.HasPrefix(err.Error(), "Invalid archive segment (ArchiveStorageFile:"+filename+" - Invalid index:") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
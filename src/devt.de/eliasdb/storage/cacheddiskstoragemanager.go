@@ -11,7 +11,7 @@
 /*
 Package storage contains the low-level API for data storage. Data is stored
 in slots. The interface defines methods to store, retrieve, update and delete
-a given object to and from the disk. There are 3 main implementations:
+a given object to and from the disk. There are 4 main implementations:
 
 DiskStorageManager
 
@@ -27,17 +27,53 @@ CachedDiskStorageManager
 
 The CachedDiskStorageManager is a cache wrapper for the DiskStorageManager. Its
 purpose is to intercept calls and to maintain a cache of stored objects. The cache
-is limited in size by the number of total objects it references. Once the cache
-is full it will forget the objects which have been requested the least.
+is limited in size by the number of total objects it references and optionally
+by the total size of the stored records of these objects. Once the cache
+is full it will forget objects according to its cache policy. By default it
+forgets the objects which have been requested the least recently (LRU). The
+LFU and 2Q policies are alternatives for workloads where large scans would
+otherwise push frequently used objects out of the cache. The Stats() function
+returns counters of cache hits, misses and evictions which help to choose a
+suitable size for the cache. Alternatively the size of the cache can be adapted
+between bounds based on its hit rate and the memory pressure. The most frequently accessed locations can be
+persisted as an access profile when the storage manager is closed. The records
+of a persisted profile can be preloaded into the cache with WarmUp() to avoid
+slow requests after a restart. Several CachedDiskStorageManager objects can
+share a SharedCache which limits the number of objects of all their caches.
+In write-back mode updates are only written to the cache. Modified records
+are written to disk in batches once too many records were modified, when they
+are evicted from the cache or when Sync() or Flush() is called.
+
+ArchiveStorageManager
+
+A readonly storage manager which serves the records of a DiskStorageManager
+from a compressed archive segment. Records are compressed in blocks - fetching
+a record requires the decompression of its block.
 
 MemoryStorageManager
 
 A storage manager which keeps all its data in memory and provides several
-error simulation facilities.
+error simulation facilities. Its data can be saved to a file and loaded again.
 */
 package storage
 
-import "sync"
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"devt.de/eliasdb/memlimit"
+)
+
+/*
+FileSuffixProfile is the file ending for a persisted access profile of a cache
+*/
+const FileSuffixProfile = "prf"
 
 /*
 CachedDiskStorageManager data structure
@@ -47,8 +83,17 @@ type CachedDiskStorageManager struct {
 	mutex              *sync.Mutex            // Mutex to protect list and map operations
 	cache              map[uint64]*cacheEntry // Map of stored cacheEntry objects
 	maxObjects         int                    // Max number of objects which should be held in the cache
-	firstentry         *cacheEntry            // Pointer to first entry in cacheEntry linked list
-	lastentry          *cacheEntry            // Pointer to last entry in cacheEntry linked list
+	maxBytes           uint64                 // Max size of the records of all cached objects (0 for no limit)
+	bytes              uint64                 // Size of the records of all cached objects
+	policy             CachePolicy            // Policy which decides which objects are evicted
+	stats              CacheStats             // Counters of the cache
+	profileSize        int                    // Number of locations which are persisted in the access profile
+	shrinkerID         int                    // Id of the shrinker which empties the cache
+	shared             *SharedCache           // Shared cache which limits the number of objects (may be nil)
+	maxDirty           int                    // Max number of modified entries which are not written (0 for write-through)
+	dirty              int                    // Number of modified entries which are not written
+	writeBackErr       error                  // Error of writing a modified entry which was evicted
+	adaptive           *adaptiveSize          // Bounds of an adaptive cache size (nil if the size is fixed)
 }
 
 /*
@@ -57,8 +102,10 @@ cacheEntry data structure
 type cacheEntry struct {
 	location uint64      // Slot (logical) of the entry
 	object   interface{} // Object of the entry
-	prev     *cacheEntry // Pointer to previous entry in cacheEntry linked list
-	next     *cacheEntry // Pointer to next entry in cacheEntry linked list
+	size     uint64      // Size of the record of the entry
+	hits     uint64      // Number of accesses of the entry
+	raw      []byte      // Record of the entry if the object was not decoded yet
+	dirty    []byte      // Modified record of the entry which was not written yet
 }
 
 /*
@@ -70,8 +117,128 @@ var entryPool = &sync.Pool{New: func() interface{} { return &cacheEntry{} }}
 NewCachedDiskStorageManager creates a new cache wrapper for a DiskStorageManger.
 */
 func NewCachedDiskStorageManager(diskstoragemanager *DiskStorageManager, maxObjects int) *CachedDiskStorageManager {
-	return &CachedDiskStorageManager{diskstoragemanager, &sync.Mutex{}, make(map[uint64]*cacheEntry),
-		maxObjects, nil, nil}
+	cdsm := &CachedDiskStorageManager{diskstoragemanager, &sync.Mutex{}, make(map[uint64]*cacheEntry),
+		maxObjects, 0, 0, NewLRUCachePolicy(), CacheStats{}, 0, 0, nil, 0, 0, nil, nil}
+
+	// Empty the cache while the soft memory limit is exceeded
+
+	cdsm.shrinkerID = memlimit.AddShrinker(cdsm.shrink)
+
+	return cdsm
+}
+
+/*
+NewSharedCachedDiskStorageManager creates a new cache wrapper for a
+DiskStorageManger which is attached to a shared cache. The number of cached
+objects is limited by the shared cache.
+*/
+func NewSharedCachedDiskStorageManager(diskstoragemanager *DiskStorageManager, sc *SharedCache) *CachedDiskStorageManager {
+	cdsm := &CachedDiskStorageManager{diskstoragemanager, sc.mutex, make(map[uint64]*cacheEntry),
+		sc.maxObjects, 0, 0, NewLRUCachePolicy(), CacheStats{}, 0, 0, sc, 0, 0, nil, nil}
+
+	sc.attach(cdsm)
+
+	// Empty the cache while the soft memory limit is exceeded
+
+	cdsm.shrinkerID = memlimit.AddShrinker(cdsm.shrink)
+
+	return cdsm
+}
+
+/*
+SharedCache returns the shared cache of this storage manager. Returns nil if
+the storage manager has its own cache.
+*/
+func (cdsm *CachedDiskStorageManager) SharedCache() *SharedCache {
+	return cdsm.shared
+}
+
+/*
+SetMaxBytes limits the cache by the total size of the records of all cached
+objects. The size of a record is the size of the serialized object. Objects
+are still limited by their number. A limit of 0 removes the size limit.
+*/
+func (cdsm *CachedDiskStorageManager) SetMaxBytes(maxBytes uint64) {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	cdsm.maxBytes = maxBytes
+
+	for cdsm.maxBytes > 0 && cdsm.bytes > cdsm.maxBytes {
+		entryPool.Put(cdsm.removeVictimFromCache())
+	}
+}
+
+/*
+SetWriteBack switches the cache into write-back mode. Updates are then only
+written to the cache until more than a given number of records were modified.
+All modified records are then written in one batch. A value of 0 switches
+back to write-through mode and writes all modified records.
+*/
+func (cdsm *CachedDiskStorageManager) SetWriteBack(maxDirty int) error {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	cdsm.maxDirty = maxDirty
+
+	if cdsm.dirty > maxDirty {
+		return cdsm.writeDirtyEntries()
+	}
+
+	return nil
+}
+
+/*
+Dirty returns the number of modified records which were not written yet.
+*/
+func (cdsm *CachedDiskStorageManager) Dirty() int {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	return cdsm.dirty
+}
+
+/*
+Sync writes all modified records of the cache to the wrapped storage manager.
+Also returns errors which occurred when modified records were written because
+they were evicted from the cache.
+*/
+func (cdsm *CachedDiskStorageManager) Sync() error {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	return cdsm.writeDirtyEntries()
+}
+
+/*
+SetCachePolicy replaces the policy which decides which objects are evicted
+from the cache. The cache is emptied.
+*/
+func (cdsm *CachedDiskStorageManager) SetCachePolicy(policy CachePolicy) {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	cdsm.policy = policy
+
+	cdsm.syncAndEmptyCache()
+}
+
+/*
+CachePolicy returns the policy which decides which objects are evicted from
+the cache.
+*/
+func (cdsm *CachedDiskStorageManager) CachePolicy() CachePolicy {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	return cdsm.policy
+}
+
+/*
+DiskStorageManager returns the wrapped DiskStorageManager.
+*/
+func (cdsm *CachedDiskStorageManager) DiskStorageManager() *DiskStorageManager {
+	return cdsm.diskstoragemanager
 }
 
 /*
@@ -102,14 +269,14 @@ func (cdsm *CachedDiskStorageManager) Insert(o interface{}) (uint64, error) {
 
 	// Cannot cache inserts since the calling code needs a location
 
-	loc, err := cdsm.diskstoragemanager.Insert(o)
+	loc, size, err := cdsm.diskstoragemanager.insert(o)
 
 	if loc != 0 && err == nil {
 
 		cdsm.mutex.Lock()
 		defer cdsm.mutex.Unlock()
 
-		cdsm.addToCache(loc, o)
+		cdsm.addToCache(loc, o, uint64(size))
 	}
 
 	return loc, err
@@ -120,20 +287,66 @@ Update updates a storage location.
 */
 func (cdsm *CachedDiskStorageManager) Update(loc uint64, o interface{}) error {
 
+	b, err := cdsm.diskstoragemanager.Serialize(o)
+	if err != nil {
+		return err
+	}
+
+	size := uint64(len(b))
+
 	// Store the update in the cache
 
 	cdsm.mutex.Lock()
 
 	if entry, ok := cdsm.cache[loc]; !ok {
-		cdsm.addToCache(loc, o)
+		cdsm.addToCache(loc, o, size)
+	} else if cdsm.maxBytes > 0 && cdsm.bytes-entry.size+size > cdsm.maxBytes {
+
+		// The entry needs to be added again if the cache would
+		// exceed its size limit
+
+		cdsm.removeFromCache(entry)
+		cdsm.addToCache(loc, o, size)
+
 	} else {
+		cdsm.bytes = cdsm.bytes - entry.size + size
 		entry.object = o
-		cdsm.llTouchEntry(entry)
+		entry.size = size
+		entry.raw = nil
+		entry.hits++
+		cdsm.policy.Touch(loc)
+	}
+
+	// Only modify the cache in write-back mode - records which could not be
+	// added to the cache must still be written
+
+	if entry, ok := cdsm.cache[loc]; ok && cdsm.maxDirty > 0 && !cdsm.diskstoragemanager.readonly {
+		defer cdsm.mutex.Unlock()
+
+		if entry.dirty == nil {
+			cdsm.dirty++
+		}
+
+		// The serialized record is held by a pooled buffer
+
+		entry.dirty = append([]byte(nil), b...)
+
+		if cdsm.dirty > cdsm.maxDirty {
+			return cdsm.writeDirtyEntries()
+		}
+
+		return nil
 	}
 
 	cdsm.mutex.Unlock()
 
-	return cdsm.diskstoragemanager.Update(loc, o)
+	if err = cdsm.diskstoragemanager.ByteDiskStorageManager.Update(loc, b); err == nil {
+		cdsm.mutex.Lock()
+		cdsm.stats.Writebacks++
+		cdsm.mutex.Unlock()
+	}
+
+	return err
 }
 
 /*
@@ -151,8 +364,7 @@ func (cdsm *CachedDiskStorageManager) Free(loc uint64) error {
 	// Remove location entry from the cache
 
 	if entry, ok := cdsm.cache[loc]; ok {
-		delete(cdsm.cache, entry.location)
-		cdsm.llRemoveEntry(entry)
+		cdsm.removeFromCache(entry)
 	}
 
 	return nil
@@ -164,7 +376,13 @@ a given data container.
 */
 func (cdsm *CachedDiskStorageManager) Fetch(loc uint64, o interface{}) error {
 
-	err := cdsm.diskstoragemanager.Fetch(loc, o)
+	// Decode preloaded records without accessing the disk
+
+	if ok, err := cdsm.fetchPreloaded(loc, o); ok {
+		return err
+	}
+
+	size, err := cdsm.diskstoragemanager.fetch(loc, o)
 	if err != nil {
 		return err
 	}
@@ -175,14 +393,57 @@ func (cdsm *CachedDiskStorageManager) Fetch(loc uint64, o interface{}) error {
 	// Put the retrieved value into the cache
 
 	if entry, ok := cdsm.cache[loc]; !ok {
-		cdsm.addToCache(loc, o)
+		cdsm.addToCache(loc, o, uint64(size))
 	} else {
-		cdsm.llTouchEntry(entry)
+		entry.hits++
+		cdsm.policy.Touch(entry.location)
 	}
 
 	return nil
 }
 
+/*
+fetchPreloaded decodes a preloaded or modified record of the cache into a
+given data container. Returns false if the record was neither preloaded nor
+modified.
+*/
+func (cdsm *CachedDiskStorageManager) fetchPreloaded(loc uint64, o interface{}) (bool, error) {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	entry, ok := cdsm.cache[loc]
+	if !ok || (entry.raw == nil && entry.dirty == nil) {
+		return false, nil
+	}
+
+	// The record on disk is outdated if the record was modified
+
+	if entry.dirty != nil {
+		if err := gob.NewDecoder(bytes.NewBuffer(entry.dirty)).Decode(o); err != nil {
+			return true, err
+		}
+
+		entry.hits++
+		cdsm.policy.Touch(loc)
+
+		return true, nil
+	}
+
+	raw := entry.raw
+	entry.raw = nil
+
+	if err := gob.NewDecoder(bytes.NewBuffer(raw)).Decode(o); err != nil {
+		cdsm.removeFromCache(entry)
+		return true, err
+	}
+
+	entry.object = o
+	entry.hits++
+	cdsm.policy.Touch(loc)
+
+	return true, nil
+}
+
 /*
 FetchCached fetches an object from a cache and returns its reference.
 Returns a storage.ErrNotInCache error if the entry is not in the cache.
@@ -192,10 +453,14 @@ func (cdsm *CachedDiskStorageManager) FetchCached(loc uint64) (interface{}, erro
 	cdsm.mutex.Lock()
 	defer cdsm.mutex.Unlock()
 
-	if entry, ok := cdsm.cache[loc]; ok {
+	if entry, ok := cdsm.cache[loc]; ok && entry.raw == nil {
+		cdsm.stats.Hits++
+		entry.hits++
 		return entry.object, nil
 	}
 
+	cdsm.stats.Misses++
+
 	return nil, ErrNotInCache
 }
 
@@ -215,9 +480,7 @@ func (cdsm *CachedDiskStorageManager) Rollback() error {
 
 	// Cache is emptied in any case
 
-	cdsm.cache = make(map[uint64]*cacheEntry)
-	cdsm.firstentry = nil
-	cdsm.lastentry = nil
+	cdsm.emptyCache()
 
 	return err
 }
@@ -226,118 +489,459 @@ func (cdsm *CachedDiskStorageManager) Rollback() error {
 Close the StorageManager and write all pending changes to disk.
 */
 func (cdsm *CachedDiskStorageManager) Close() error {
-	return cdsm.diskstoragemanager.Close()
+	memlimit.RemoveShrinker(cdsm.shrinkerID)
+
+	cdsm.StopAdaptiveSize()
+
+	// Give the space in a shared cache to the other storage managers
+
+	if cdsm.shared != nil {
+		defer func() {
+			cdsm.mutex.Lock()
+			defer cdsm.mutex.Unlock()
+
+			cdsm.emptyCache()
+			cdsm.shared.detach(cdsm)
+		}()
+	}
+
+	perr := cdsm.Sync()
+
+	if cdsm.profileSize > 0 && !cdsm.diskstoragemanager.readonly && perr == nil {
+		perr = cdsm.saveAccessProfile()
+	}
+
+	if err := cdsm.diskstoragemanager.Close(); err != nil {
+		return err
+	}
+
+	return perr
 }
 
 /*
 Flush writes all pending changes to disk.
 */
 func (cdsm *CachedDiskStorageManager) Flush() error {
+	if err := cdsm.Sync(); err != nil {
+		return err
+	}
+
 	return cdsm.diskstoragemanager.Flush()
 }
 
 /*
-addToCache adds an entry to the cache.
+Backup writes a consistent copy of all data files to a given directory (see
+ByteDiskStorageManager.Backup). Modified records which are held in the cache
+are written before the files are copied.
 */
-func (cdsm *CachedDiskStorageManager) addToCache(loc uint64, o interface{}) {
+func (cdsm *CachedDiskStorageManager) Backup(targetDir string) error {
+	if err := cdsm.Sync(); err != nil {
+		return err
+	}
 
-	var entry *cacheEntry
+	return cdsm.diskstoragemanager.Backup(targetDir)
+}
 
-	// Get an entry from the pool or recycle an entry from the cacheEntry
-	// linked list if the list is full
+/*
+IncrementalBackup writes all records which were modified since the last backup
+into an increment file (see ByteDiskStorageManager.IncrementalBackup). Modified
+records which are held in the cache are written first.
+*/
+func (cdsm *CachedDiskStorageManager) IncrementalBackup(targetFile string) error {
+	if err := cdsm.Sync(); err != nil {
+		return err
+	}
 
-	if len(cdsm.cache) >= cdsm.maxObjects {
-		entry = cdsm.removeOldestFromCache()
-	} else {
-		entry = entryPool.Get().(*cacheEntry)
+	return cdsm.diskstoragemanager.IncrementalBackup(targetFile)
+}
+
+/*
+MemoryUsage returns the memory which is held by the wrapped storage manager
+and the number of cached objects.
+*/
+func (cdsm *CachedDiskStorageManager) MemoryUsage() *MemoryUsage {
+	ret := cdsm.diskstoragemanager.MemoryUsage()
+
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	ret.CacheObjects = uint64(len(cdsm.cache))
+	ret.CacheBytes = cdsm.bytes
+
+	return ret
+}
+
+/*
+Stats returns the counters of the cache. Cache hits and misses are counted
+for requests to FetchCached.
+*/
+func (cdsm *CachedDiskStorageManager) Stats() CacheStats {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	return cdsm.stats
+}
+
+/*
+ResetStats resets all counters of the cache.
+*/
+func (cdsm *CachedDiskStorageManager) ResetStats() {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	cdsm.stats = CacheStats{}
+}
+
+/*
+SetAccessProfileSize sets the number of the most frequently accessed
+locations which are persisted as access profile when the storage manager is
+closed. No profile is written if the size is 0.
+*/
+func (cdsm *CachedDiskStorageManager) SetAccessProfileSize(size int) {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	cdsm.profileSize = size
+}
+
+/*
+AccessProfile returns up to a given number of cached locations. Locations are
+ordered by the number of accesses (most frequently accessed first).
+*/
+func (cdsm *CachedDiskStorageManager) AccessProfile(size int) []uint64 {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	entries := make([]*cacheEntry, 0, len(cdsm.cache))
+
+	for _, entry := range cdsm.cache {
+		entries = append(entries, entry)
 	}
 
-	// Fill the entry
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].hits == entries[j].hits {
+			return entries[i].location < entries[j].location
+		}
+		return entries[i].hits > entries[j].hits
+	})
 
-	entry.location = loc
-	entry.object = o
+	if len(entries) > size {
+		entries = entries[:size]
+	}
 
-	// Insert entry into the cacheEntry linked list (this will set the entries
-	// prev and next pointer)
+	ret := make([]uint64, len(entries))
 
-	cdsm.llAppendEntry(entry)
+	for i, entry := range entries {
+		ret[i] = entry.location
+	}
 
-	// Insert into the map of stored cacheEntry objects
+	return ret
+}
 
-	cdsm.cache[loc] = entry
+/*
+saveAccessProfile writes the access profile to disk.
+*/
+func (cdsm *CachedDiskStorageManager) saveAccessProfile() error {
+	profile := cdsm.AccessProfile(cdsm.profileSize)
+
+	b := make([]byte, 8*len(profile))
+
+	for i, loc := range profile {
+		binary.BigEndian.PutUint64(b[i*8:], loc)
+	}
+
+	return ioutil.WriteFile(profileFilename(cdsm.diskstoragemanager.filename), b, 0660)
 }
 
 /*
-removeOldestFromCache removes the oldest entry from the cache and return it.
+WarmUp preloads the records of the persisted access profile into the cache.
+Records are decoded once they are fetched. Locations which no longer exist
+are skipped and no records are preloaded once the cache is full. Returns the
+number of preloaded records.
 */
-func (cdsm *CachedDiskStorageManager) removeOldestFromCache() *cacheEntry {
-	entry := cdsm.firstentry
+func (cdsm *CachedDiskStorageManager) WarmUp() (int, error) {
 
-	// If no entries were stored yet just return an entry from the pool
+	b, err := ioutil.ReadFile(profileFilename(cdsm.diskstoragemanager.filename))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
 
-	if entry == nil {
-		return entryPool.Get().(*cacheEntry)
+	if len(b)%8 != 0 {
+		return 0, fmt.Errorf("Invalid access profile of %v", cdsm.Name())
 	}
 
-	// Remove entry from the cacheEntry linked list (this will set the entries
-	// prev and next pointer)
+	count := 0
 
-	cdsm.llRemoveEntry(entry)
+	for i := 0; i < len(b); i += 8 {
+		loc := binary.BigEndian.Uint64(b[i:])
 
-	// Remove entry from the map of stored cacheEntry objects
+		var buf bytes.Buffer
 
-	delete(cdsm.cache, entry.location)
+		if err := cdsm.diskstoragemanager.ByteDiskStorageManager.Fetch(loc, &buf); err != nil {
+			continue
+		}
 
-	return entry
+		cdsm.mutex.Lock()
+
+		if cdsm.isFull() {
+			cdsm.mutex.Unlock()
+			break
+		}
+
+		if _, ok := cdsm.cache[loc]; !ok {
+			cdsm.addToCache(loc, nil, uint64(buf.Len()))
+
+			if entry, ok := cdsm.cache[loc]; ok {
+				entry.raw = buf.Bytes()
+				count++
+			}
+		}
+
+		cdsm.mutex.Unlock()
+	}
+
+	return count, nil
+}
+
+/*
+AccessProfileExist checks if a persisted access profile exists for a given
+storage file.
+*/
+func AccessProfileExist(filename string) bool {
+	_, err := os.Stat(profileFilename(filename))
+	return err == nil
+}
+
+/*
+profileFilename returns the name of the access profile file of a given
+storage file.
+*/
+func profileFilename(filename string) string {
+	return fmt.Sprintf("%v.%v", filename, FileSuffixProfile)
 }
 
 /*
-llTouchEntry puts an entry to the last position of the cacheEntry linked list.
-Calling llTouchEntry on all requested items ensures that the oldest used
-entry is at the beginning of the list.
+SizeReport returns the size of all data which is stored by the wrapped
+storage manager.
 */
-func (cdsm *CachedDiskStorageManager) llTouchEntry(entry *cacheEntry) {
-	if cdsm.lastentry == entry {
+func (cdsm *CachedDiskStorageManager) SizeReport() (*SizeReport, error) {
+	return cdsm.diskstoragemanager.SizeReport()
+}
+
+/*
+shrink empties the cache.
+*/
+func (cdsm *CachedDiskStorageManager) shrink() {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	cdsm.syncAndEmptyCache()
+}
+
+/*
+syncAndEmptyCache writes all modified records and removes all entries from the
+cache. Modified records which could not be written stay in the cache and are
+written again by the next call to Sync() which also returns the error.
+*/
+func (cdsm *CachedDiskStorageManager) syncAndEmptyCache() {
+	if err := cdsm.writeDirtyEntries(); err != nil {
+		cdsm.writeBackErr = err
+		cdsm.emptyCleanEntries()
 		return
 	}
 
-	cdsm.llRemoveEntry(entry)
-	cdsm.llAppendEntry(entry)
+	cdsm.emptyCache()
+}
+
+/*
+emptyCleanEntries removes all entries from the cache which do not hold a
+modified record. The remaining entries are tracked again by the cache policy.
+*/
+func (cdsm *CachedDiskStorageManager) emptyCleanEntries() {
+	cdsm.policy.Clear()
+
+	for loc, entry := range cdsm.cache {
+		if entry.dirty == nil {
+			delete(cdsm.cache, loc)
+			cdsm.bytes -= entry.size
+			entryPool.Put(entry)
+		} else {
+			cdsm.policy.Add(loc)
+		}
+	}
+}
+
+/*
+emptyCache removes all entries from the cache. Modified records which were
+not written are discarded.
+*/
+func (cdsm *CachedDiskStorageManager) emptyCache() {
+	cdsm.cache = make(map[uint64]*cacheEntry)
+	cdsm.policy.Clear()
+	cdsm.bytes = 0
+	cdsm.dirty = 0
+}
+
+/*
+writeDirtyEntries writes all modified records to the wrapped storage manager.
+Records are written in the order of their locations. Returns the first error
+which occurred - also errors of writing evicted records.
+*/
+func (cdsm *CachedDiskStorageManager) writeDirtyEntries() error {
+	err := cdsm.writeBackErr
+	cdsm.writeBackErr = nil
+
+	if cdsm.dirty == 0 {
+		return err
+	}
+
+	entries := make([]*cacheEntry, 0, cdsm.dirty)
+
+	for _, entry := range cdsm.cache {
+		if entry.dirty != nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].location < entries[j].location
+	})
+
+	for _, entry := range entries {
+		if werr := cdsm.writeDirtyEntry(entry); werr != nil {
+			return werr
+		}
+	}
+
+	return err
 }
 
 /*
-llAppendEntry appends a cacheEntry to the end of the cacheEntry linked list.
+writeDirtyEntry writes the modified record of an entry to the wrapped storage
+manager.
 */
-func (cdsm *CachedDiskStorageManager) llAppendEntry(entry *cacheEntry) {
-	if cdsm.firstentry == nil {
-		cdsm.firstentry = entry
-		cdsm.lastentry = entry
-		entry.prev = nil
+func (cdsm *CachedDiskStorageManager) writeDirtyEntry(entry *cacheEntry) error {
+	if err := cdsm.diskstoragemanager.ByteDiskStorageManager.Update(entry.location, entry.dirty); err != nil {
+		return err
+	}
+
+	entry.dirty = nil
+	cdsm.dirty--
+	cdsm.stats.Writebacks++
+
+	return nil
+}
+
+/*
+addToCache adds an entry to the cache. No entries are added while the soft
+memory limit is exceeded or if the record of the entry is bigger than the
+size limit of the cache.
+*/
+func (cdsm *CachedDiskStorageManager) addToCache(loc uint64, o interface{}, size uint64) {
+
+	var entry *cacheEntry
+
+	if memlimit.Exceeded() || (cdsm.maxBytes > 0 && size > cdsm.maxBytes) {
+		return
+	}
+
+	// Make room if the new record would exceed the size limit
+
+	for cdsm.maxBytes > 0 && cdsm.bytes+size > cdsm.maxBytes {
+		entryPool.Put(cdsm.removeVictimFromCache())
+	}
+
+	// Get an entry from the pool or recycle an evicted entry if the cache
+	// is full - the entry of a shared cache might be evicted from another
+	// storage manager
+
+	if cdsm.isFull() {
+		if cdsm.shared != nil {
+			entry = cdsm.shared.victim(cdsm).removeVictimFromCache()
+		} else {
+			entry = cdsm.removeVictimFromCache()
+		}
 	} else {
-		cdsm.lastentry.next = entry
-		entry.prev = cdsm.lastentry
-		cdsm.lastentry = entry
+		entry = entryPool.Get().(*cacheEntry)
 	}
-	entry.next = nil
+
+	// Fill the entry
+
+	entry.location = loc
+	entry.object = o
+	entry.size = size
+	entry.hits = 1
+	entry.raw = nil
+	entry.dirty = nil
+
+	cdsm.bytes += size
+
+	// Let the policy track the new entry and insert it into the map of
+	// stored cacheEntry objects
+
+	cdsm.policy.Add(loc)
+
+	cdsm.cache[loc] = entry
 }
 
 /*
-llRemoveEntry removes a cacheEntry from the cacheEntry linked list.
+isFull checks if the cache (or the shared cache) holds its max number of
+objects.
 */
-func (cdsm *CachedDiskStorageManager) llRemoveEntry(entry *cacheEntry) {
-	if entry == cdsm.firstentry {
-		cdsm.firstentry = entry.next
+func (cdsm *CachedDiskStorageManager) isFull() bool {
+	if cdsm.shared != nil {
+		return cdsm.shared.objects() >= cdsm.shared.maxObjects
 	}
-	if cdsm.lastentry == entry {
-		cdsm.lastentry = entry.prev
+	return len(cdsm.cache) >= cdsm.maxObjects
+}
+
+/*
+removeVictimFromCache removes the entry which was chosen by the cache policy
+from the cache and returns it.
+*/
+func (cdsm *CachedDiskStorageManager) removeVictimFromCache() *cacheEntry {
+	loc, ok := cdsm.policy.Evict()
+	entry := cdsm.cache[loc]
+
+	// If no entries were stored yet just return an entry from the pool
+
+	if !ok || entry == nil {
+		return entryPool.Get().(*cacheEntry)
 	}
 
-	if entry.prev != nil {
-		entry.prev.next = entry.next
-		entry.prev = nil
+	// Write the record of a modified entry before it is forgotten
+
+	if entry.dirty != nil {
+		if err := cdsm.writeDirtyEntry(entry); err != nil {
+			cdsm.writeBackErr = err
+			entry.dirty = nil
+			cdsm.dirty--
+		}
 	}
-	if entry.next != nil {
-		entry.next.prev = entry.prev
-		entry.next = nil
+
+	// Remove entry from the map of stored cacheEntry objects
+
+	delete(cdsm.cache, entry.location)
+
+	cdsm.bytes -= entry.size
+	cdsm.stats.Evictions++
+
+	return entry
+}
+
+/*
+removeFromCache removes a given entry from the cache. A modified record of the
+entry is discarded.
+*/
+func (cdsm *CachedDiskStorageManager) removeFromCache(entry *cacheEntry) {
+	if entry.dirty != nil {
+		entry.dirty = nil
+		cdsm.dirty--
 	}
+
+	cdsm.policy.Remove(entry.location)
+	delete(cdsm.cache, entry.location)
+	cdsm.bytes -= entry.size
 }
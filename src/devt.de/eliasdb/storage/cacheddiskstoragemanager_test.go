@@ -11,8 +11,13 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
 	"testing"
 
+	"devt.de/eliasdb/memlimit"
 	"devt.de/eliasdb/storage/file"
 	"devt.de/eliasdb/storage/slotting/pageview"
 )
@@ -181,12 +186,12 @@ func TestCachedDiskStorageManager(t *testing.T) {
 	}
 
 	err = cdsm.Fetch(loc, &ret3)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected fetch result:", ret3, err)
 		return
 	}
 
-	if err := cdsm.Update(loc, "test99"); err != file.ErrAlreadyInUse {
+	if err := cdsm.Update(loc, "test99"); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected update result:", err)
 		return
 	}
@@ -266,7 +271,7 @@ func TestCachedDiskStorageManagerTransactions(t *testing.T) {
 		return
 	}
 
-	if err := cdsm.Free(loc2); err != file.ErrAlreadyInUse {
+	if err := cdsm.Free(loc2); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected free result:", err)
 		return
 	}
@@ -305,9 +310,9 @@ func TestCachedDiskStorageManagerCacheManagement(t *testing.T) {
 
 	// Event though the cache is empty make sure we can still retrieve empty entries
 
-	entry := cdsm.removeOldestFromCache()
+	entry := cdsm.removeVictimFromCache()
 	if entry == nil {
-		t.Error("Unexpected removeOldestFromCache result:", entry)
+		t.Error("Unexpected removeVictimFromCache result:", entry)
 		return
 	}
 
@@ -352,20 +357,20 @@ func TestCachedDiskStorageManagerCacheManagement(t *testing.T) {
 
 	// Check that the last accessed entry is on the last position in the list
 
-	if cdsm.lastentry.location != loc4 {
-		t.Error("Unexpected last entry:", cdsm.firstentry.location)
+	if lruLast(cdsm) != loc4 {
+		t.Error("Unexpected last entry:", lruFirst(cdsm))
 		return
 	}
 
 	cdsm.Fetch(loc2, &ret)
 
-	if cdsm.lastentry.location != loc2 {
-		t.Error("Unexpected last entry:", cdsm.firstentry.location)
+	if lruLast(cdsm) != loc2 {
+		t.Error("Unexpected last entry:", lruFirst(cdsm))
 		return
 	}
 
-	if cdsm.firstentry.location != loc3 {
-		t.Error("Unexpected first entry:", cdsm.firstentry.location)
+	if lruFirst(cdsm) != loc3 {
+		t.Error("Unexpected first entry:", lruFirst(cdsm))
 		return
 	}
 
@@ -382,16 +387,16 @@ func TestCachedDiskStorageManagerCacheManagement(t *testing.T) {
 	cdsm.Update(loc2, "test9")
 	cdsm.Update(loc4, "test9")
 
-	if cdsm.firstentry.location != loc3 {
-		t.Error("Unexpected first entry:", cdsm.firstentry.location)
+	if lruFirst(cdsm) != loc3 {
+		t.Error("Unexpected first entry:", lruFirst(cdsm))
 		return
 	}
 
 	dsm.physicalSlotsSf.ReleaseInUse(record)
 
-	entry = cdsm.removeOldestFromCache()
+	entry = cdsm.removeVictimFromCache()
 	if entry.location != loc3 {
-		t.Error("Unexpected removeOldestFromCache result:", entry, err)
+		t.Error("Unexpected removeVictimFromCache result:", entry, err)
 		return
 	}
 
@@ -399,3 +404,628 @@ func TestCachedDiskStorageManagerCacheManagement(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestCachedDiskStorageManagerMemoryUsage(t *testing.T) {
+
+	var ret string
+
+	dsm := NewDiskStorageManager(DBDIR+"/ctest4", false, false, false, true)
+
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	loc1, _ := cdsm.Insert("test1")
+	loc2, _ := cdsm.Insert("test2")
+
+	cdsm.Fetch(loc1, &ret)
+	cdsm.Fetch(loc2, &ret)
+
+	mu := cdsm.MemoryUsage()
+
+	if mu.CacheObjects != 2 || mu.Records == 0 || mu.TransRecords != 0 {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	if err := cdsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Flushed records are held until the transaction log is written
+
+	if mu = cdsm.MemoryUsage(); mu.CacheObjects != 2 || mu.TransRecords == 0 {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	// The wrapped storage manager does not cache objects
+
+	if mu = dsm.MemoryUsage(); mu.CacheObjects != 0 || mu.TransRecords == 0 {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestCachedDiskStorageManagerSizeReport(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/ctest5", false, false, true, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	if _, err := cdsm.Insert("test"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sr, err := cdsm.SizeReport()
+	if err != nil || sr.Slots != 1 || sr.DataBytes == 0 {
+		t.Error("Unexpected size report:", sr, err)
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestCachedDiskStorageManagerMemoryLimit(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/ctest6", false, false, true, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	oldHeapSize := memlimit.HeapSize
+	memlimit.HeapSize = func() uint64 {
+		return 1000
+	}
+	defer func() {
+		memlimit.HeapSize = oldHeapSize
+		memlimit.SetLimit(0)
+	}()
+
+	loc, err := cdsm.Insert("test1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(cdsm.cache) != 1 {
+		t.Error("Unexpected cache size:", len(cdsm.cache))
+		return
+	}
+
+	// The cache is emptied and not filled while the limit is exceeded
+
+	memlimit.SetLimit(10)
+	memlimit.Update()
+
+	if len(cdsm.cache) != 0 {
+		t.Error("Unexpected cache size:", len(cdsm.cache))
+		return
+	}
+
+	if _, err := cdsm.Insert("test2"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res string
+
+	if err := cdsm.Fetch(loc, &res); err != nil || res != "test1" || len(cdsm.cache) != 0 {
+		t.Error("Unexpected result:", res, err, len(cdsm.cache))
+		return
+	}
+
+	memlimit.SetLimit(0)
+
+	if err := cdsm.Fetch(loc, &res); err != nil || res != "test1" || len(cdsm.cache) != 1 {
+		t.Error("Unexpected result:", res, err, len(cdsm.cache))
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestCachedDiskStorageManagerMaxBytes(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/ctest7", false, false, true, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	small, _ := dsm.Serialize("test")
+	smallSize := uint64(len(small))
+
+	var locs []uint64
+
+	for i := 0; i < 4; i++ {
+		loc, err := cdsm.Insert("test")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	if mu := cdsm.MemoryUsage(); mu.CacheObjects != 4 || mu.CacheBytes != 4*smallSize {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	// Lowering the limit evicts the oldest entries
+
+	cdsm.SetMaxBytes(2 * smallSize)
+
+	if mu := cdsm.MemoryUsage(); mu.CacheObjects != 2 || mu.CacheBytes != 2*smallSize ||
+		lruFirst(cdsm) != locs[2] {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	// Records which are bigger than the limit are not cached
+
+	large := strings.Repeat("x", int(3*smallSize))
+
+	if err := cdsm.Update(locs[3], large); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, ok := cdsm.cache[locs[3]]; ok || len(cdsm.cache) != 1 || cdsm.bytes != smallSize {
+		t.Error("Unexpected cache state:", len(cdsm.cache), cdsm.bytes)
+		return
+	}
+
+	var res string
+
+	if err := cdsm.Fetch(locs[3], &res); err != nil || res != large {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Updates which grow a record evict other entries
+
+	cdsm.SetMaxBytes(3 * smallSize)
+
+	cdsm.Fetch(locs[0], &res)
+	cdsm.Fetch(locs[1], &res)
+
+	if len(cdsm.cache) != 3 || cdsm.bytes != 3*smallSize {
+		t.Error("Unexpected cache state:", len(cdsm.cache), cdsm.bytes)
+		return
+	}
+
+	medium := "test" + strings.Repeat("x", int(smallSize))
+
+	if err := cdsm.Update(locs[0], medium); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, ok := cdsm.cache[locs[2]]; ok || len(cdsm.cache) != 2 || cdsm.bytes > 3*smallSize ||
+		lruLast(cdsm) != locs[0] {
+		t.Error("Unexpected cache state:", len(cdsm.cache), cdsm.bytes)
+		return
+	}
+
+	// Freeing an entry releases its bytes
+
+	if err := cdsm.Free(locs[0]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if mu := cdsm.MemoryUsage(); mu.CacheObjects != 1 || mu.CacheBytes != smallSize {
+		t.Error("Unexpected memory usage:", mu)
+		return
+	}
+
+	// Removing the limit caches everything again
+
+	cdsm.SetMaxBytes(0)
+
+	cdsm.Fetch(locs[3], &res)
+
+	if _, ok := cdsm.cache[locs[3]]; !ok {
+		t.Error("Large record should be cached")
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestCachedDiskStorageManagerStats(t *testing.T) {
+	var ret string
+
+	dsm := NewDiskStorageManager(DBDIR+"/ctest9", false, false, true, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 2)
+
+	loc1, _ := cdsm.Insert("test1")
+	loc2, _ := cdsm.Insert("test2")
+	loc3, _ := dsm.Insert("test3")
+
+	cdsm.FetchCached(loc1)
+	cdsm.FetchCached(loc2)
+	cdsm.FetchCached(loc3)
+
+	// Fetching a record which is not in the cache evicts another record
+
+	cdsm.Fetch(loc3, &ret)
+	cdsm.FetchCached(loc1)
+
+	if err := cdsm.Update(loc2, "test4"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if s := cdsm.Stats(); s != (CacheStats{2, 2, 1, 1}) {
+		t.Error("Unexpected stats:", s)
+		return
+	}
+
+	cdsm.ResetStats()
+
+	if s := cdsm.Stats(); s != (CacheStats{}) {
+		t.Error("Unexpected stats:", s)
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestCachedDiskStorageManagerWarmUp(t *testing.T) {
+	var ret string
+
+	filename := DBDIR + "/ctest10"
+
+	dsm := NewDiskStorageManager(filename, false, false, true, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	var locs []uint64
+
+	for i := 0; i < 5; i++ {
+		loc, _ := cdsm.Insert(fmt.Sprint("test", i))
+		locs = append(locs, loc)
+	}
+
+	// Access some records more often than others
+
+	for i := 0; i < 3; i++ {
+		cdsm.Fetch(locs[3], &ret)
+		cdsm.FetchCached(locs[1])
+	}
+	cdsm.Fetch(locs[1], &ret)
+	cdsm.Fetch(locs[4], &ret)
+
+	if res := fmt.Sprint(cdsm.AccessProfile(3)); res != fmt.Sprint([]uint64{locs[1], locs[3], locs[4]}) {
+		t.Error("Unexpected profile:", res)
+		return
+	}
+
+	cdsm.SetAccessProfileSize(3)
+
+	if err := cdsm.Free(locs[4]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !AccessProfileExist(filename) {
+		t.Error("Access profile should have been written")
+		return
+	}
+
+	// Records of the profile are preloaded after a restart
+
+	dsm = NewDiskStorageManager(filename, false, false, true, true)
+	cdsm = NewCachedDiskStorageManager(dsm, 10)
+
+	dsm.Free(locs[3])
+
+	if n, err := cdsm.WarmUp(); n != 2 || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if _, err := cdsm.FetchCached(locs[1]); err != ErrNotInCache || len(cdsm.cache) != 2 {
+		t.Error("Preloaded records should not be decoded yet:", err)
+		return
+	}
+
+	if err := cdsm.Fetch(locs[1], &ret); err != nil || ret != "test1" {
+		t.Error("Unexpected result:", ret, err)
+		return
+	}
+
+	if obj, err := cdsm.FetchCached(locs[1]); err != nil || *obj.(*string) != "test1" {
+		t.Error("Unexpected result:", obj, err)
+		return
+	}
+
+	// Preloading stops once the cache is full
+
+	cdsm.emptyCache()
+	cdsm.maxObjects = 1
+
+	if n, err := cdsm.WarmUp(); n != 1 || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Test error cases
+
+	ioutil.WriteFile(filename+"."+FileSuffixProfile, []byte{1, 2, 3}, 0660)
+
+	dsm = NewDiskStorageManager(filename, false, false, true, true)
+	cdsm = NewCachedDiskStorageManager(dsm, 10)
+
+	if _, err := cdsm.WarmUp(); err == nil || err.Error() != "Invalid access profile of DiskStorageFile:"+filename {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := RemoveDataFiles(filename); err != nil || AccessProfileExist(filename) {
+		t.Error("Access profile should have been removed:", err)
+		return
+	}
+
+	dsm = NewDiskStorageManager(filename, false, false, true, true)
+	cdsm = NewCachedDiskStorageManager(dsm, 10)
+
+	if n, err := cdsm.WarmUp(); n != 0 || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	cdsm.Close()
+}
+
+func TestCachedDiskStorageManagerWriteBack(t *testing.T) {
+	var ret string
+
+	filename := DBDIR + "/ctest12"
+
+	dsm := NewDiskStorageManager(filename, false, false, true, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 3)
+
+	var locs []uint64
+
+	for i := 0; i < 4; i++ {
+		loc, _ := cdsm.Insert(fmt.Sprint("test", i))
+		locs = append(locs, loc)
+	}
+
+	if err := cdsm.SetWriteBack(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Updates are only written to the cache
+
+	cdsm.Update(locs[2], "upd2")
+	cdsm.Update(locs[3], "upd3")
+	cdsm.Update(locs[3], "upd3a")
+
+	if cdsm.Dirty() != 2 || cdsm.Stats().Writebacks != 0 {
+		t.Error("Unexpected state:", cdsm.Dirty(), cdsm.Stats())
+		return
+	}
+
+	if err := dsm.Fetch(locs[3], &ret); err != nil || ret != "test3" {
+		t.Error("Unexpected result:", ret, err)
+		return
+	}
+
+	if err := cdsm.Fetch(locs[3], &ret); err != nil || ret != "upd3a" {
+		t.Error("Unexpected result:", ret, err)
+		return
+	}
+
+	if o, err := cdsm.FetchCached(locs[2]); err != nil || o != "upd2" {
+		t.Error("Unexpected result:", o, err)
+		return
+	}
+
+	// Too many modified records are written in one batch
+
+	cdsm.Update(locs[1], "upd1")
+
+	if cdsm.Dirty() != 0 || cdsm.Stats().Writebacks != 3 {
+		t.Error("Unexpected state:", cdsm.Dirty(), cdsm.Stats())
+		return
+	}
+
+	if err := dsm.Fetch(locs[1], &ret); err != nil || ret != "upd1" {
+		t.Error("Unexpected result:", ret, err)
+		return
+	}
+
+	// Modified records are written if they are evicted
+
+	cdsm.Update(locs[2], "upd2a")
+
+	if cdsm.Dirty() != 1 {
+		t.Error("Unexpected state:", cdsm.Dirty())
+		return
+	}
+
+	cdsm.Fetch(locs[0], &ret)
+	cdsm.Fetch(locs[1], &ret)
+	cdsm.Fetch(locs[3], &ret)
+
+	if _, err := cdsm.FetchCached(locs[2]); err != ErrNotInCache || cdsm.Dirty() != 0 {
+		t.Error("Unexpected result:", err, cdsm.Dirty())
+		return
+	}
+
+	if err := dsm.Fetch(locs[2], &ret); err != nil || ret != "upd2a" {
+		t.Error("Unexpected result:", ret, err)
+		return
+	}
+
+	// Modified records can be written explicitly
+
+	cdsm.Update(locs[0], "upd0")
+
+	if err := cdsm.Sync(); err != nil || cdsm.Dirty() != 0 {
+		t.Error("Unexpected result:", err, cdsm.Dirty())
+		return
+	}
+
+	// Switching back to write-through writes all modified records
+
+	cdsm.Update(locs[0], "upd0a")
+
+	if err := cdsm.SetWriteBack(0); err != nil || cdsm.Dirty() != 0 {
+		t.Error("Unexpected result:", err, cdsm.Dirty())
+		return
+	}
+
+	cdsm.Update(locs[1], "upd1a")
+
+	if cdsm.Dirty() != 0 {
+		t.Error("Unexpected state:", cdsm.Dirty())
+		return
+	}
+
+	// Closing the storage manager writes all modified records
+
+	cdsm.SetWriteBack(10)
+	cdsm.Update(locs[3], "upd3b")
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewDiskStorageManager(filename, false, false, true, true)
+
+	var res []string
+
+	for _, loc := range locs {
+		dsm.Fetch(loc, &ret)
+		res = append(res, ret)
+	}
+
+	if fmt.Sprint(res) != "[upd0a upd1a upd2a upd3b]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	dsm.Close()
+}
+
+func TestCachedDiskStorageManagerWriteBackError(t *testing.T) {
+	var ret string
+
+	filename := DBDIR + "/ctest13"
+
+	dsm := NewDiskStorageManager(filename, false, false, true, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	var locs []uint64
+
+	for i := 0; i < 3; i++ {
+		loc, _ := cdsm.Insert(fmt.Sprint("test", i))
+		locs = append(locs, loc)
+	}
+
+	cdsm.SetWriteBack(10)
+
+	cdsm.Update(locs[0], "upd0")
+	cdsm.Update(locs[2], "upd2")
+	cdsm.Fetch(locs[1], &ret)
+
+	// Modified records which cannot be written stay in the cache when the
+	// cache is emptied - other entries are removed
+
+	dsm.readonly = true
+
+	cdsm.shrink()
+
+	if len(cdsm.cache) != 2 || cdsm.Dirty() != 2 || cdsm.bytes != cdsm.cache[locs[0]].size+cdsm.cache[locs[2]].size {
+		t.Error("Unexpected state:", len(cdsm.cache), cdsm.Dirty(), cdsm.bytes)
+		return
+	}
+
+	if o, err := cdsm.FetchCached(locs[2]); err != nil || o != "upd2" {
+		t.Error("Unexpected result:", o, err)
+		return
+	}
+
+	// The records are written by the next sync which reports the error
+
+	dsm.readonly = false
+
+	if err := cdsm.Sync(); err != ErrReadonly || cdsm.Dirty() != 0 {
+		t.Error("Unexpected result:", err, cdsm.Dirty())
+		return
+	}
+
+	if err := cdsm.Sync(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Remaining entries are tracked by the cache policy and can be removed
+
+	cdsm.shrink()
+
+	if len(cdsm.cache) != 0 || cdsm.bytes != 0 {
+		t.Error("Unexpected state:", len(cdsm.cache), cdsm.bytes)
+		return
+	}
+
+	var res []string
+
+	for _, loc := range locs {
+		dsm.Fetch(loc, &ret)
+		res = append(res, ret)
+	}
+
+	if fmt.Sprint(res) != "[upd0 test1 upd2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+/*
+lruFirst returns the least recently used location of a cache with an LRU policy.
+*/
+func lruFirst(cdsm *CachedDiskStorageManager) uint64 {
+	return cdsm.policy.(*LRUCachePolicy).entries.Front().Value.(uint64)
+}
+
+/*
+lruLast returns the most recently used location of a cache with an LRU policy.
+*/
+func lruLast(cdsm *CachedDiskStorageManager) uint64 {
+	return cdsm.policy.(*LRUCachePolicy).entries.Back().Value.(uint64)
+}
@@ -0,0 +1,396 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+/*
+CachePolicy decides which entry should be removed from the object cache of a
+CachedDiskStorageManager once the cache is full. A policy only keeps track of
+the locations of cached objects. Policies are always called while the cache
+is locked and do not need to be thread safe.
+*/
+type CachePolicy interface {
+
+	/*
+		Name returns the name of the policy.
+	*/
+	Name() string
+
+	/*
+		Add is called when a location has been added to the cache.
+	*/
+	Add(loc uint64)
+
+	/*
+		Touch is called when a cached location has been requested again.
+	*/
+	Touch(loc uint64)
+
+	/*
+		Remove is called when a location has been removed from the cache
+		without being evicted (e.g. because its slot was freed).
+	*/
+	Remove(loc uint64)
+
+	/*
+		Evict chooses a location which should be removed from the cache and
+		stops tracking it. Returns false if no location is tracked.
+	*/
+	Evict() (uint64, bool)
+
+	/*
+		Clear stops tracking all locations.
+	*/
+	Clear()
+}
+
+/*
+CachePolicies is a map of all known cache policies. Each entry is a
+constructor function which gets the max number of objects in the cache.
+*/
+var CachePolicies = map[string]func(maxObjects int) CachePolicy{
+	"lru": func(maxObjects int) CachePolicy { return NewLRUCachePolicy() },
+	"lfu": func(maxObjects int) CachePolicy { return NewLFUCachePolicy() },
+	"2q":  New2QCachePolicy,
+}
+
+// LRU policy
+// ==========
+
+/*
+LRUCachePolicy evicts the least recently used entry.
+*/
+type LRUCachePolicy struct {
+	entries *list.List               // List of locations (least recently used first)
+	lookup  map[uint64]*list.Element // Lookup of list elements
+}
+
+/*
+NewLRUCachePolicy creates a new LRU cache policy.
+*/
+func NewLRUCachePolicy() CachePolicy {
+	return &LRUCachePolicy{list.New(), make(map[uint64]*list.Element)}
+}
+
+/*
+Name returns the name of the policy.
+*/
+func (p *LRUCachePolicy) Name() string {
+	return "lru"
+}
+
+/*
+Add is called when a location has been added to the cache.
+*/
+func (p *LRUCachePolicy) Add(loc uint64) {
+	if e, ok := p.lookup[loc]; ok {
+		p.entries.MoveToBack(e)
+		return
+	}
+
+	p.lookup[loc] = p.entries.PushBack(loc)
+}
+
+/*
+Touch is called when a cached location has been requested again.
+*/
+func (p *LRUCachePolicy) Touch(loc uint64) {
+	if e, ok := p.lookup[loc]; ok {
+		p.entries.MoveToBack(e)
+	}
+}
+
+/*
+Remove is called when a location has been removed from the cache.
+*/
+func (p *LRUCachePolicy) Remove(loc uint64) {
+	if e, ok := p.lookup[loc]; ok {
+		p.entries.Remove(e)
+		delete(p.lookup, loc)
+	}
+}
+
+/*
+Evict chooses the least recently used location.
+*/
+func (p *LRUCachePolicy) Evict() (uint64, bool) {
+	e := p.entries.Front()
+	if e == nil {
+		return 0, false
+	}
+
+	loc := p.entries.Remove(e).(uint64)
+	delete(p.lookup, loc)
+
+	return loc, true
+}
+
+/*
+Clear stops tracking all locations.
+*/
+func (p *LRUCachePolicy) Clear() {
+	p.entries.Init()
+	p.lookup = make(map[uint64]*list.Element)
+}
+
+// LFU policy
+// ==========
+
+/*
+LFUCachePolicy evicts the least frequently used entry. The least recently
+used entry is evicted if several entries have been used equally often.
+*/
+type LFUCachePolicy struct {
+	entries *lfuHeap             // Heap of entries (least frequently used first)
+	lookup  map[uint64]*lfuEntry // Lookup of heap entries
+	clock   uint64               // Counter which orders accesses
+}
+
+/*
+lfuEntry is a tracked location of the LFU policy.
+*/
+type lfuEntry struct {
+	loc   uint64 // Location of the entry
+	count uint64 // Number of accesses
+	last  uint64 // Clock value of the last access
+	index int    // Index in the heap
+}
+
+/*
+NewLFUCachePolicy creates a new LFU cache policy.
+*/
+func NewLFUCachePolicy() CachePolicy {
+	return &LFUCachePolicy{&lfuHeap{}, make(map[uint64]*lfuEntry), 0}
+}
+
+/*
+Name returns the name of the policy.
+*/
+func (p *LFUCachePolicy) Name() string {
+	return "lfu"
+}
+
+/*
+Add is called when a location has been added to the cache.
+*/
+func (p *LFUCachePolicy) Add(loc uint64) {
+	if _, ok := p.lookup[loc]; ok {
+		p.Touch(loc)
+		return
+	}
+
+	p.clock++
+
+	e := &lfuEntry{loc, 1, p.clock, 0}
+	p.lookup[loc] = e
+
+	heap.Push(p.entries, e)
+}
+
+/*
+Touch is called when a cached location has been requested again.
+*/
+func (p *LFUCachePolicy) Touch(loc uint64) {
+	if e, ok := p.lookup[loc]; ok {
+		p.clock++
+
+		e.count++
+		e.last = p.clock
+
+		heap.Fix(p.entries, e.index)
+	}
+}
+
+/*
+Remove is called when a location has been removed from the cache.
+*/
+func (p *LFUCachePolicy) Remove(loc uint64) {
+	if e, ok := p.lookup[loc]; ok {
+		heap.Remove(p.entries, e.index)
+		delete(p.lookup, loc)
+	}
+}
+
+/*
+Evict chooses the least frequently used location.
+*/
+func (p *LFUCachePolicy) Evict() (uint64, bool) {
+	if p.entries.Len() == 0 {
+		return 0, false
+	}
+
+	e := heap.Pop(p.entries).(*lfuEntry)
+	delete(p.lookup, e.loc)
+
+	return e.loc, true
+}
+
+/*
+Clear stops tracking all locations.
+*/
+func (p *LFUCachePolicy) Clear() {
+	p.entries = &lfuHeap{}
+	p.lookup = make(map[uint64]*lfuEntry)
+}
+
+/*
+lfuHeap is a min heap of LFU entries which implements heap.Interface.
+*/
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count == h[j].count {
+		return h[i].last < h[j].last
+	}
+	return h[i].count < h[j].count
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// 2Q policy
+// =========
+
+/*
+TwoQCachePolicy is a scan resistant policy which keeps new entries in a FIFO
+queue. Entries are only moved into the main LRU queue if they are requested
+again while they are in the FIFO queue or shortly after they have been
+evicted from it. Locations which are requested only once (e.g. during a
+full scan) therefore cannot push frequently used entries out of the cache.
+*/
+type TwoQCachePolicy struct {
+	in       *LRUCachePolicy // FIFO queue of new entries
+	out      *LRUCachePolicy // Queue of recently evicted locations (no objects)
+	main     *LRUCachePolicy // LRU queue of frequently used entries
+	maxIn    int             // Max size of the FIFO queue before it is preferred for eviction
+	maxGhost int             // Max size of the queue of evicted locations
+}
+
+/*
+New2QCachePolicy creates a new 2Q cache policy for a cache which holds a
+given number of objects.
+*/
+func New2QCachePolicy(maxObjects int) CachePolicy {
+	maxIn := maxObjects / 4
+	if maxIn < 1 {
+		maxIn = 1
+	}
+
+	maxGhost := maxObjects / 2
+	if maxGhost < 1 {
+		maxGhost = 1
+	}
+
+	return &TwoQCachePolicy{NewLRUCachePolicy().(*LRUCachePolicy), NewLRUCachePolicy().(*LRUCachePolicy),
+		NewLRUCachePolicy().(*LRUCachePolicy), maxIn, maxGhost}
+}
+
+/*
+Name returns the name of the policy.
+*/
+func (p *TwoQCachePolicy) Name() string {
+	return "2q"
+}
+
+/*
+Add is called when a location has been added to the cache.
+*/
+func (p *TwoQCachePolicy) Add(loc uint64) {
+	if _, ok := p.out.lookup[loc]; ok {
+
+		// Location was evicted recently - it is used frequently
+
+		p.out.Remove(loc)
+		p.main.Add(loc)
+
+	} else if _, ok := p.main.lookup[loc]; ok {
+		p.main.Touch(loc)
+
+	} else if _, ok := p.in.lookup[loc]; !ok {
+		p.in.Add(loc)
+	}
+}
+
+/*
+Touch is called when a cached location has been requested again.
+*/
+func (p *TwoQCachePolicy) Touch(loc uint64) {
+	if _, ok := p.in.lookup[loc]; ok {
+		p.in.Remove(loc)
+		p.main.Add(loc)
+	} else {
+		p.main.Touch(loc)
+	}
+}
+
+/*
+Remove is called when a location has been removed from the cache.
+*/
+func (p *TwoQCachePolicy) Remove(loc uint64) {
+	p.in.Remove(loc)
+	p.main.Remove(loc)
+}
+
+/*
+Evict chooses a location from the FIFO queue if it is too big or from the
+main queue otherwise.
+*/
+func (p *TwoQCachePolicy) Evict() (uint64, bool) {
+	if p.in.entries.Len() > p.maxIn || p.main.entries.Len() == 0 {
+		if loc, ok := p.in.Evict(); ok {
+
+			// Remember the location in case it is requested again
+
+			p.out.Add(loc)
+
+			if p.out.entries.Len() > p.maxGhost {
+				p.out.Evict()
+			}
+
+			return loc, true
+		}
+	}
+
+	return p.main.Evict()
+}
+
+/*
+Clear stops tracking all locations.
+*/
+func (p *TwoQCachePolicy) Clear() {
+	p.in.Clear()
+	p.out.Clear()
+	p.main.Clear()
+}
@@ -0,0 +1,193 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+/*
+evictAll evicts all locations from a given policy.
+*/
+func evictAll(p CachePolicy) []uint64 {
+	var ret []uint64
+
+	for loc, ok := p.Evict(); ok; loc, ok = p.Evict() {
+		ret = append(ret, loc)
+	}
+
+	return ret
+}
+
+func TestCachePolicies(t *testing.T) {
+
+	for _, name := range []string{"lru", "lfu", "2q"} {
+		p := CachePolicies[name](10)
+
+		if p.Name() != name {
+			t.Error("Unexpected name:", p.Name())
+			return
+		}
+
+		if loc, ok := p.Evict(); ok || loc != 0 {
+			t.Error("Empty policy should not evict anything:", loc)
+			return
+		}
+
+		p.Add(1)
+		p.Add(2)
+		p.Add(3)
+		p.Remove(2)
+		p.Remove(99)
+		p.Touch(99)
+
+		if res := fmt.Sprint(evictAll(p)); res != "[1 3]" {
+			t.Error("Unexpected result:", name, res)
+			return
+		}
+
+		p.Add(1)
+		p.Clear()
+
+		if res := evictAll(p); len(res) != 0 {
+			t.Error("Unexpected result:", name, res)
+			return
+		}
+	}
+
+	// LRU evicts the least recently used location
+
+	p := NewLRUCachePolicy()
+
+	p.Add(1)
+	p.Add(2)
+	p.Add(3)
+	p.Touch(1)
+	p.Add(2)
+
+	if res := fmt.Sprint(evictAll(p)); res != "[3 1 2]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// LFU evicts the least frequently used location and the least recently
+	// used one if locations were used equally often
+
+	p = NewLFUCachePolicy()
+
+	p.Add(1)
+	p.Add(2)
+	p.Add(3)
+	p.Add(4)
+	p.Touch(1)
+	p.Touch(1)
+	p.Touch(3)
+	p.Touch(2)
+
+	if res := fmt.Sprint(evictAll(p)); res != "[4 3 2 1]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// 2Q evicts new locations while there are too many of them and promotes
+	// locations which are used again or were evicted recently
+
+	p = New2QCachePolicy(4)
+
+	p.Add(1)
+	p.Add(2)
+	p.Add(3)
+	p.Touch(2)
+
+	if loc, _ := p.Evict(); loc != 1 {
+		t.Error("Unexpected result:", loc)
+		return
+	}
+
+	p.Add(1)
+
+	if res := fmt.Sprint(evictAll(p)); res != "[2 1 3]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
+func TestCachedDiskStorageManagerCachePolicy(t *testing.T) {
+
+	var ret string
+
+	// A scan through many records should not evict frequently used records
+	// from the cache
+
+	scan := func(policy CachePolicy) int {
+		dsm := NewDiskStorageManager(DBDIR+"/ctest8"+policy.Name(), false, false, true, true)
+		defer dsm.Close()
+
+		cdsm := NewCachedDiskStorageManager(dsm, 10)
+		cdsm.SetCachePolicy(policy)
+
+		if cdsm.CachePolicy() != policy {
+			t.Error("Unexpected policy:", cdsm.CachePolicy())
+		}
+
+		var hot, cold []uint64
+
+		for i := 0; i < 5; i++ {
+			loc, _ := dsm.Insert(fmt.Sprint("hot", i))
+			hot = append(hot, loc)
+		}
+
+		for i := 0; i < 50; i++ {
+			loc, _ := dsm.Insert(fmt.Sprint("cold", i))
+			cold = append(cold, loc)
+		}
+
+		for i := 0; i < 3; i++ {
+			for _, loc := range hot {
+				cdsm.Fetch(loc, &ret)
+			}
+		}
+
+		for _, loc := range cold {
+			cdsm.Fetch(loc, &ret)
+		}
+
+		if len(cdsm.cache) != 10 {
+			t.Error("Unexpected cache size:", len(cdsm.cache))
+		}
+
+		cached := 0
+
+		for _, loc := range hot {
+			if _, err := cdsm.FetchCached(loc); err == nil {
+				cached++
+			}
+		}
+
+		return cached
+	}
+
+	if res := scan(NewLRUCachePolicy()); res != 0 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := scan(NewLFUCachePolicy()); res != 5 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := scan(New2QCachePolicy(10)); res != 5 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
@@ -13,7 +13,6 @@ package storage
 import (
 	"bytes"
 	"encoding/gob"
-	"errors"
 	"fmt"
 	"io"
 	"sync"
@@ -22,6 +21,7 @@ import (
 	"devt.de/common/errorutil"
 	"devt.de/common/fileutil"
 	"devt.de/common/lockutil"
+	"devt.de/eliasdb/lockprof"
 	"devt.de/eliasdb/storage/file"
 	"devt.de/eliasdb/storage/paging"
 	"devt.de/eliasdb/storage/slotting"
@@ -58,6 +58,11 @@ FileSuffixPhysicalFreeSlots is the file ending for a free physical slot storage
 */
 const FileSuffixPhysicalFreeSlots = "dbf"
 
+/*
+FileSuffixBlobSlots is the file ending for a large object storage
+*/
+const FileSuffixBlobSlots = "dbb"
+
 /*
 BlockSizePhysicalSlots is the block for a physical slot file. Physical slots will
 contain actual data they need to have fairly large block sizes.
@@ -76,10 +81,16 @@ pointers will always be small. They only need tiny blocks.
 */
 const BlockSizeFreeSlots = 1024
 
+/*
+DefaultBlobThreshold is the default size in bytes above which data is stored
+as a large object in a dedicated file instead of the normal data pages.
+*/
+var DefaultBlobThreshold uint32 = 64 * 1024
+
 /*
 ErrReadonly is returned when attempting a write operation on a readonly datastore.
 */
-var ErrReadonly = errors.New("Storage is readonly")
+var ErrReadonly = errorutil.NewCategorizedError(errorutil.ErrReadOnly, "Storage is readonly")
 
 /*
 DiskStorageManager is a storage manager which can store any gob serializable datastructure.
@@ -136,14 +147,45 @@ func (dsm *DiskStorageManager) Serialize(o interface{}) ([]byte, error) {
 Insert inserts an object and return its storage location.
 */
 func (dsm *DiskStorageManager) Insert(o interface{}) (uint64, error) {
+	loc, _, err := dsm.insert(o)
+	return loc, err
+}
+
+/*
+insert inserts an object and returns its storage location and its size in bytes.
+*/
+func (dsm *DiskStorageManager) insert(o interface{}) (uint64, int, error) {
 
 	b, err := dsm.Serialize(o)
 
 	if err != nil {
-		return 0, err
+		return 0, 0, err
+	}
+
+	loc, err := dsm.ByteDiskStorageManager.Insert(b)
+
+	return loc, len(b), err
+}
+
+/*
+InsertBatch inserts a list of objects and returns their storage locations.
+*/
+func (dsm *DiskStorageManager) InsertBatch(objs []interface{}) ([]uint64, error) {
+	bs := make([][]byte, len(objs))
+
+	for i, o := range objs {
+		b, err := dsm.Serialize(o)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// The serialized bytes are only valid until the next Serialize call
+
+		bs[i] = append([]byte(nil), b...)
 	}
 
-	return dsm.ByteDiskStorageManager.Insert(b)
+	return dsm.ByteDiskStorageManager.InsertBatch(bs)
 }
 
 /*
@@ -165,6 +207,14 @@ Fetch fetches an object from a given storage location and writes it to
 a given data container.
 */
 func (dsm *DiskStorageManager) Fetch(loc uint64, o interface{}) error {
+	_, err := dsm.fetch(loc, o)
+	return err
+}
+
+/*
+fetch fetches an object from a given storage location and returns its size in bytes.
+*/
+func (dsm *DiskStorageManager) fetch(loc uint64, o interface{}) (int, error) {
 
 	// Request a buffer from the buffer pool
 
@@ -175,12 +225,14 @@ func (dsm *DiskStorageManager) Fetch(loc uint64, o interface{}) error {
 	}()
 
 	if err := dsm.ByteDiskStorageManager.Fetch(loc, bb); err != nil {
-		return err
+		return 0, err
 	}
 
+	size := bb.Len()
+
 	//  Deserialize the object from a gob bytes stream
 
-	return gob.NewDecoder(bb).Decode(o)
+	return size, gob.NewDecoder(bb).Decode(o)
 }
 
 /*
@@ -197,6 +249,8 @@ type ByteDiskStorageManager struct {
 	physicalSlotsPager     *paging.PagedStorageFile // Pager for physical slots StorageFile
 	physicalFreeSlotsSf    *file.StorageFile        // StorageFile for free physical slots
 	physicalFreeSlotsPager *paging.PagedStorageFile // Pager for free physical slots StorageFile
+	blobSlotsSf            *file.StorageFile        // StorageFile for large objects
+	blobSlotsPager         *paging.PagedStorageFile // Pager for large objects StorageFile
 
 	physicalSlotManager *slotting.PhysicalSlotManager // Manager for physical slots
 
@@ -208,6 +262,12 @@ type ByteDiskStorageManager struct {
 	logicalSlotManager *slotting.LogicalSlotManager // Manager for physical slots
 
 	lockfile *lockutil.LockFile // Lockfile manager
+	scrubber *scrubber          // Background consistency scrubber (nil if not running)
+	versions *versionStore      // Previous versions of records and root values
+	metrics  *storageMetrics    // Operation metrics (published with expvar)
+	expiries *expiryStore       // Expiry times of storage locations
+	quota    *Quota             // Limit for the disk usage (nil if there is none)
+	recovery *RecoveryReport    // Automatic recovery on open (nil after a clean shutdown)
 }
 
 /*
@@ -230,13 +290,19 @@ func NewByteDiskStorageManager(filename string, readonly bool, onlyAppend bool,
 	}
 
 	bdsm := &ByteDiskStorageManager{filename, readonly, onlyAppend, transDisabled, &sync.Mutex{}, nil, nil,
-		nil, nil, nil, nil, nil, nil, nil, nil, lf}
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, lf, nil, nil, nil, nil, nil, nil}
 
 	err := initByteDiskStorageManager(bdsm)
 	if err != nil {
 		panic(fmt.Sprintf("Could not initialize DiskStroageManager: %v", filename))
 	}
 
+	bdsm.metrics = registerMetrics(filename)
+
+	if bdsm.recovery != nil {
+		bdsm.finishRecovery()
+	}
+
 	return bdsm
 }
 
@@ -265,7 +331,7 @@ func (bdsm *ByteDiskStorageManager) Name() string {
 Root returns a root value.
 */
 func (bdsm *ByteDiskStorageManager) Root(root int) uint64 {
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Root")
 	defer bdsm.mutex.Unlock()
 
 	bdsm.checkFileOpen()
@@ -283,19 +349,216 @@ func (bdsm *ByteDiskStorageManager) SetRoot(root int, val uint64) {
 		return
 	}
 
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetRoot")
 	defer bdsm.mutex.Unlock()
 
 	bdsm.checkFileOpen()
+
+	// Keep the current root value for readers of previous versions - the
+	// root is set in any case
+
+	if err := bdsm.preserveRoot(root); err != nil {
+		LogVersions(fmt.Sprintf("Could not keep version of root %v of %v: %v",
+			root, bdsm.filename, err))
+	}
+
 	bdsm.physicalSlotsPager.Header().SetRoot(root, val)
 }
 
+/*
+UserData returns a value from the user data area of the storage. Returns nil
+if the value does not exist.
+*/
+func (bdsm *ByteDiskStorageManager) UserData(key string) []byte {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "UserData")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.checkFileOpen()
+	return bdsm.physicalSlotsPager.Header().UserData(key)
+}
+
+/*
+SetUserData writes a value to the user data area of the storage. A nil value
+removes the key from the area. Values are stored alongside the data and can
+be used to store format versions, application ids or similar information.
+*/
+func (bdsm *ByteDiskStorageManager) SetUserData(key string, value []byte) error {
+
+	// Fail operation if readonly
+
+	if bdsm.readonly {
+		return ErrReadonly
+	}
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetUserData")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.checkFileOpen()
+	return bdsm.physicalSlotsPager.Header().SetUserData(key, value)
+}
+
+/*
+SetBlobThreshold sets the size in bytes above which new data is stored as a
+large object. Large objects are kept in a dedicated file as a chain of chunk
+pages so big values do not fill up the normal data pages. A threshold of 0
+stores all new data in the normal data pages.
+*/
+func (bdsm *ByteDiskStorageManager) SetBlobThreshold(threshold uint32) {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetBlobThreshold")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.checkFileOpen()
+	bdsm.physicalSlotManager.SetBlobStorage(slotting.NewBlobManager(bdsm.blobSlotsPager), threshold)
+}
+
+/*
+SetChecksums enables or disables page checksums for all managed files.
+Checksums are written for every page which is modified after this call.
+*/
+func (bdsm *ByteDiskStorageManager) SetChecksums(enabled bool) {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetChecksums")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.checkFileOpen()
+	bdsm.physicalSlotsPager.SetChecksums(enabled)
+	bdsm.physicalFreeSlotsPager.SetChecksums(enabled)
+	bdsm.blobSlotsPager.SetChecksums(enabled)
+	bdsm.logicalSlotsPager.SetChecksums(enabled)
+	bdsm.logicalFreeSlotsPager.SetChecksums(enabled)
+}
+
+/*
+SetReadAhead sets the number of records which are read ahead in one large read
+once a sequential scan of a data file is detected. This avoids many small
+random reads on storage with a high latency. A value of 0 disables the read
+ahead.
+*/
+func (bdsm *ByteDiskStorageManager) SetReadAhead(records int) {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetReadAhead")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.checkFileOpen()
+	bdsm.physicalSlotsSf.SetReadAhead(records)
+	bdsm.blobSlotsSf.SetReadAhead(records)
+	bdsm.logicalSlotsSf.SetReadAhead(records)
+}
+
+/*
+SetWasteMargins sets the allocation waste margins which are used when free
+space is reused for new data. Smaller margins waste less space in reused slots
+while bigger margins reuse free space more often instead of growing the
+storage files. A margin of 0 restores the default.
+*/
+func (bdsm *ByteDiskStorageManager) SetWasteMargins(optimal uint32, maxAcceptable uint32) {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetWasteMargins")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.checkFileOpen()
+	bdsm.physicalSlotManager.SetWasteMargins(optimal, maxAcceptable)
+}
+
+/*
+SetAllocationStrategy sets how free space is picked for new data (see the
+Alloc constants in the slotting/pageview package). First-fit is fast for
+ingest-heavy workloads while best-fit packs long-lived data better.
+*/
+func (bdsm *ByteDiskStorageManager) SetAllocationStrategy(strategy int) {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetAllocationStrategy")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.checkFileOpen()
+	bdsm.physicalSlotManager.SetAllocationStrategy(strategy)
+}
+
+/*
+PageAccessStats returns the page access statistics of all managed files for
+each page type.
+*/
+func (bdsm *ByteDiskStorageManager) PageAccessStats() map[int16]*paging.PageAccessStats {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "PageAccessStats")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.checkFileOpen()
+
+	ret := make(map[int16]*paging.PageAccessStats)
+
+	for _, pager := range []*paging.PagedStorageFile{bdsm.physicalSlotsPager,
+		bdsm.physicalFreeSlotsPager, bdsm.blobSlotsPager, bdsm.logicalSlotsPager,
+		bdsm.logicalFreeSlotsPager} {
+
+		for pagetype, stats := range pager.AccessStats() {
+			if rs, ok := ret[pagetype]; ok {
+				rs.Hits += stats.Hits
+				rs.Misses += stats.Misses
+				rs.Frequency += stats.Frequency
+			} else {
+				ret[pagetype] = stats
+			}
+		}
+	}
+
+	return ret
+}
+
+/*
+MemoryUsage returns the memory which is held by the managed files.
+*/
+func (bdsm *ByteDiskStorageManager) MemoryUsage() *MemoryUsage {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "MemoryUsage")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.checkFileOpen()
+
+	ret := &MemoryUsage{}
+
+	for _, sf := range []*file.StorageFile{bdsm.physicalSlotsSf, bdsm.physicalFreeSlotsSf,
+		bdsm.blobSlotsSf, bdsm.logicalSlotsSf, bdsm.logicalFreeSlotsSf} {
+
+		records, transRecords := sf.MemoryUsage()
+		ret.Records += records
+		ret.TransRecords += transRecords
+	}
+
+	return ret
+}
+
+/*
+SizeReport returns the size of all stored data. All storage slots are visited
+so this operation can be expensive on large files.
+*/
+func (bdsm *ByteDiskStorageManager) SizeReport() (*SizeReport, error) {
+	bdsm.checkFileOpen()
+
+	// Continue single threaded from here on
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SizeReport")
+	defer bdsm.mutex.Unlock()
+
+	ret := &SizeReport{}
+
+	err := bdsm.logicalSlotManager.ForEach(func(loc uint64, ploc uint64) error {
+		current, available, err := bdsm.physicalSlotManager.SlotSize(ploc)
+
+		if err == nil {
+			ret.Slots++
+			ret.DataBytes += uint64(current)
+			ret.SlotBytes += uint64(available) + util.SizeInfoSize
+		}
+
+		return err
+	})
+
+	return ret, err
+}
+
 /*
 Insert inserts an object and return its storage location.
 */
-func (bdsm *ByteDiskStorageManager) Insert(o interface{}) (uint64, error) {
+func (bdsm *ByteDiskStorageManager) Insert(o interface{}) (_ uint64, err error) {
 	bdsm.checkFileOpen()
 
+	defer bdsm.metrics.record(MetricInsert, time.Now(), &err)
+
 	// Fail operation if readonly
 
 	if bdsm.readonly {
@@ -304,12 +567,25 @@ func (bdsm *ByteDiskStorageManager) Insert(o interface{}) (uint64, error) {
 
 	// Continue single threaded from here on
 
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Insert")
 	defer bdsm.mutex.Unlock()
 
-	// Store the data in a physical slot
+	// Fail operation if the disk usage has reached the quota
 
-	b := o.([]byte)
+	if err := bdsm.quota.check(bdsm); err != nil {
+		return 0, err
+	}
+
+	return bdsm.insert(o.([]byte))
+}
+
+/*
+insert inserts a byte slice and returns its storage location. It is assumed
+that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) insert(b []byte) (uint64, error) {
+
+	// Store the data in a physical slot
 
 	ploc, err := bdsm.physicalSlotManager.Insert(b, 0, uint32(len(b)))
 	if err != nil {
@@ -320,18 +596,101 @@ func (bdsm *ByteDiskStorageManager) Insert(o interface{}) (uint64, error) {
 
 	loc, err := bdsm.logicalSlotManager.Insert(ploc)
 	if err != nil {
+		bdsm.physicalSlotManager.Free(ploc)
+		return 0, err
+	}
+
+	// Record that the location did not exist in previous versions - give
+	// the allocated slots back if this fails
+
+	if err := bdsm.preserveRecord(loc, 0); err != nil {
+		bdsm.physicalSlotManager.Free(ploc)
+		bdsm.logicalSlotManager.Free(loc)
 		return 0, err
 	}
 
 	return loc, nil
 }
 
+/*
+InsertBatch inserts a list of byte slices and returns their storage locations.
+Physical and logical slots for all items are allocated in one pass which is
+considerably faster than individual Insert calls for bulk imports.
+*/
+func (bdsm *ByteDiskStorageManager) InsertBatch(bs [][]byte) (_ []uint64, err error) {
+	bdsm.checkFileOpen()
+
+	defer bdsm.metrics.record(MetricInsert, time.Now(), &err)
+
+	// Fail operation if readonly
+
+	if bdsm.readonly {
+		return nil, ErrReadonly
+	}
+
+	// Continue single threaded from here on
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "InsertBatch")
+	defer bdsm.mutex.Unlock()
+
+	// Fail operation if the disk usage has reached the quota
+
+	if err := bdsm.quota.check(bdsm); err != nil {
+		return nil, err
+	}
+
+	sizes := make([]uint32, len(bs))
+
+	for i, b := range bs {
+		sizes[i] = uint32(len(b))
+	}
+
+	plocs, err := bdsm.physicalSlotManager.AllocateBatch(sizes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the data in the allocated physical slots - large objects
+	// have no allocated slot and are inserted individually
+
+	for i, b := range bs {
+		if plocs[i] == 0 {
+			plocs[i], err = bdsm.physicalSlotManager.Insert(b, 0, sizes[i])
+		} else {
+			plocs[i], err = bdsm.physicalSlotManager.Update(plocs[i], b, 0, sizes[i])
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Get logical slots for the physical slots
+
+	locs, err := bdsm.logicalSlotManager.AllocateBatch(plocs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record that the locations did not exist in previous versions
+
+	for _, loc := range locs {
+		if err := bdsm.preserveRecord(loc, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return locs, nil
+}
+
 /*
 Update updates a storage location.
 */
-func (bdsm *ByteDiskStorageManager) Update(loc uint64, o interface{}) error {
+func (bdsm *ByteDiskStorageManager) Update(loc uint64, o interface{}) (err error) {
 	bdsm.checkFileOpen()
 
+	defer bdsm.metrics.record(MetricUpdate, time.Now(), &err)
+
 	// Fail operation if readonly
 
 	if bdsm.readonly {
@@ -340,7 +699,7 @@ func (bdsm *ByteDiskStorageManager) Update(loc uint64, o interface{}) error {
 
 	// Get the physical slot for the given logical slot
 
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Update")
 	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
 	bdsm.mutex.Unlock()
 	if err != nil {
@@ -354,12 +713,25 @@ func (bdsm *ByteDiskStorageManager) Update(loc uint64, o interface{}) error {
 
 	// Continue single threaded from here on
 
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Update")
 	defer bdsm.mutex.Unlock()
 
-	// Update the physical record
+	return bdsm.update(loc, ploc, o.([]byte))
+}
+
+/*
+update writes a byte slice to a storage location which is stored in a given
+physical slot. It is assumed that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) update(loc uint64, ploc uint64, b []byte) error {
 
-	b := o.([]byte)
+	// Keep the current version of the record for readers of previous versions
+
+	if err := bdsm.preserveRecord(loc, ploc); err != nil {
+		return err
+	}
+
+	// Update the physical record
 
 	newPloc, err := bdsm.physicalSlotManager.Update(ploc, b, 0, uint32(len(b)))
 	if err != nil {
@@ -379,12 +751,14 @@ func (bdsm *ByteDiskStorageManager) Update(loc uint64, o interface{}) error {
 Fetch fetches an object from a given storage location and writes it to
 a given data container.
 */
-func (bdsm *ByteDiskStorageManager) Fetch(loc uint64, o interface{}) error {
+func (bdsm *ByteDiskStorageManager) Fetch(loc uint64, o interface{}) (err error) {
 	bdsm.checkFileOpen()
 
+	defer bdsm.metrics.record(MetricFetch, time.Now(), &err)
+
 	// Get the physical slot for the given logical slot
 
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Fetch")
 	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
 	bdsm.mutex.Unlock()
 
@@ -399,7 +773,7 @@ func (bdsm *ByteDiskStorageManager) Fetch(loc uint64, o interface{}) error {
 
 	// Request the stored bytes
 
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Fetch")
 
 	if w, ok := o.(io.Writer); ok {
 		err = bdsm.physicalSlotManager.Fetch(ploc, w)
@@ -425,9 +799,11 @@ func (bdsm *ByteDiskStorageManager) FetchCached(loc uint64) (interface{}, error)
 /*
 Free frees a storage location.
 */
-func (bdsm *ByteDiskStorageManager) Free(loc uint64) error {
+func (bdsm *ByteDiskStorageManager) Free(loc uint64) (err error) {
 	bdsm.checkFileOpen()
 
+	defer bdsm.metrics.record(MetricFree, time.Now(), &err)
+
 	// Fail operation if readonly
 
 	if bdsm.readonly {
@@ -436,9 +812,17 @@ func (bdsm *ByteDiskStorageManager) Free(loc uint64) error {
 
 	// Continue single threaded from here on
 
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Free")
 	defer bdsm.mutex.Unlock()
 
+	return bdsm.free(loc)
+}
+
+/*
+free frees a storage location. It is assumed that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) free(loc uint64) error {
+
 	// Get the physical slot for the given logical slot
 
 	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
@@ -451,6 +835,12 @@ func (bdsm *ByteDiskStorageManager) Free(loc uint64) error {
 			util.LocationRecord(loc), util.LocationOffset(loc)))
 	}
 
+	// Keep the current version of the record for readers of previous versions
+
+	if err := bdsm.preserveRecord(loc, ploc); err != nil {
+		return err
+	}
+
 	// First try to free the physical slot since here is the data
 	// if this fails we don't touch the logical slot
 
@@ -459,6 +849,10 @@ func (bdsm *ByteDiskStorageManager) Free(loc uint64) error {
 		return err
 	}
 
+	// The location can no longer expire
+
+	bdsm.expiries.remove(loc)
+
 	// This is very unlikely to fail - either way we can't do anything
 	// at this point since the physical slot has already gone away
 
@@ -466,47 +860,208 @@ func (bdsm *ByteDiskStorageManager) Free(loc uint64) error {
 }
 
 /*
-Flush writes all pending changes to disk.
+SlotFlags returns the flags of a storage location (see the SlotInfoFlag
+constants of the pageview package).
 */
-func (bdsm *ByteDiskStorageManager) Flush() error {
+func (bdsm *ByteDiskStorageManager) SlotFlags(loc uint64) (byte, error) {
 	bdsm.checkFileOpen()
 
-	// When readonly this operation becomes a NOP
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SlotFlags")
+	defer bdsm.mutex.Unlock()
+
+	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
+	if err != nil {
+		return 0, err
+	}
+
+	if ploc == 0 {
+		return 0, ErrSlotNotFound.fireError(bdsm, fmt.Sprint("Location:",
+			util.LocationRecord(loc), util.LocationOffset(loc)))
+	}
+
+	return bdsm.logicalSlotManager.Flags(loc)
+}
+
+/*
+SetSlotFlags sets the flags of a storage location. This allows marking a
+location as logically deleted without freeing it immediately - the location
+can be reclaimed later with a Free call.
+*/
+func (bdsm *ByteDiskStorageManager) SetSlotFlags(loc uint64, flags byte) error {
+	bdsm.checkFileOpen()
+
+	// Fail operation if readonly
 
 	if bdsm.readonly {
+		return ErrReadonly
+	}
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetSlotFlags")
+	defer bdsm.mutex.Unlock()
+
+	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
+	if err != nil {
+		return err
+	}
+
+	if ploc == 0 {
+		return ErrSlotNotFound.fireError(bdsm, fmt.Sprint("Location:",
+			util.LocationRecord(loc), util.LocationOffset(loc)))
+	}
+
+	return bdsm.logicalSlotManager.SetFlags(loc, flags)
+}
+
+/*
+Compact relocates all stored data towards the beginning of the physical slot
+storage file so there is no unused space between stored objects. The logical
+storage locations stay the same - only the translation entries are rewritten.
+Pages which are no longer needed are returned to the free list of the
+physical slot storage file. All changes are part of the current transaction.
+*/
+func (bdsm *ByteDiskStorageManager) Compact() error {
+	bdsm.checkFileOpen()
+
+	// Fail operation if readonly
+
+	if bdsm.readonly {
+		return ErrReadonly
+	}
+
+	// Continue single threaded from here on
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Compact")
+	defer bdsm.mutex.Unlock()
+
+	// Write pending free slot information so it can be discarded
+
+	if err := bdsm.physicalSlotManager.Flush(); err != nil {
+		return err
+	}
+
+	// Collect all used logical slots
+
+	var locs, plocs []uint64
+
+	err := bdsm.logicalSlotManager.ForEach(func(loc uint64, ploc uint64) error {
+		locs = append(locs, loc)
+		plocs = append(plocs, ploc)
 		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	ce := errorutil.NewCompositeError()
+	// Move the physical slots
+
+	newPlocs, err := bdsm.physicalSlotManager.Compact(plocs)
+	if err != nil {
+		return err
+	}
+
+	// Update the translation entries of all moved slots
+
+	for i, loc := range locs {
+		if newPlocs[i] != plocs[i] {
+			if err := bdsm.logicalSlotManager.Update(loc, newPlocs[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+Truncate releases all free pages at the end of the storage files so their
+space is returned to the file system. All pending changes are written to disk.
+Calling Truncate after Compact reclaims the space of all removed data.
+*/
+func (bdsm *ByteDiskStorageManager) Truncate() error {
+	bdsm.checkFileOpen()
+
+	// Fail operation if readonly
+
+	if bdsm.readonly {
+		return ErrReadonly
+	}
 
 	// Continue single threaded from here on
 
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Truncate")
 	defer bdsm.mutex.Unlock()
 
-	// Write pending changes
+	// Write pending manager changes
 
 	if err := bdsm.physicalSlotManager.Flush(); err != nil {
-		ce.Add(err)
+		return err
 	}
 
 	if err := bdsm.logicalSlotManager.Flush(); err != nil {
-		ce.Add(err)
+		return err
+	}
+
+	for _, pager := range []*paging.PagedStorageFile{bdsm.physicalSlotsPager,
+		bdsm.physicalFreeSlotsPager, bdsm.blobSlotsPager, bdsm.logicalSlotsPager,
+		bdsm.logicalFreeSlotsPager} {
+
+		if _, err := pager.Truncate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Flush writes all pending changes to disk.
+*/
+func (bdsm *ByteDiskStorageManager) Flush() (err error) {
+	bdsm.checkFileOpen()
+
+	defer bdsm.metrics.record(MetricFlush, time.Now(), &err)
+
+	// When readonly this operation becomes a NOP
+
+	if bdsm.readonly {
+		return nil
 	}
 
-	if err := bdsm.physicalSlotsPager.Flush(); err != nil {
+	// Continue single threaded from here on
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Flush")
+	defer bdsm.mutex.Unlock()
+
+	return bdsm.flush()
+}
+
+/*
+flush writes all pending changes to disk. It is assumed that the caller
+holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) flush() error {
+	ce := errorutil.NewCompositeError()
+
+	// Write pending changes
+
+	if err := bdsm.writeExpiries(); err != nil {
 		ce.Add(err)
 	}
 
-	if err := bdsm.physicalFreeSlotsPager.Flush(); err != nil {
+	if err := bdsm.physicalSlotManager.Flush(); err != nil {
 		ce.Add(err)
 	}
 
-	if err := bdsm.logicalSlotsPager.Flush(); err != nil {
+	if err := bdsm.logicalSlotManager.Flush(); err != nil {
 		ce.Add(err)
 	}
 
-	if err := bdsm.logicalFreeSlotsPager.Flush(); err != nil {
+	// The files are independent of each other and can be written concurrently
+
+	if err := FlushConcurrently(bdsm.physicalSlotsPager.Flush, bdsm.physicalFreeSlotsPager.Flush,
+		bdsm.blobSlotsPager.Flush, bdsm.logicalSlotsPager.Flush,
+		bdsm.logicalFreeSlotsPager.Flush); err != nil {
+
 		ce.Add(err)
 	}
 
@@ -516,13 +1071,48 @@ func (bdsm *ByteDiskStorageManager) Flush() error {
 		return ce
 	}
 
+	// The flushed changes are a new commit
+
+	bdsm.versions.commitVersions()
+
 	return nil
 }
 
+/*
+Backup writes a consistent copy of all data files to a given directory while
+the storage stays writable. All pending changes are written before the files
+are copied. Changes are blocked while the files are copied. The copy contains
+the transaction logs - a storage manager which is opened on the copied files
+recovers all committed transactions. Later changes can be saved with
+IncrementalBackup.
+*/
+func (bdsm *ByteDiskStorageManager) Backup(targetDir string) error {
+	bdsm.checkFileOpen()
+
+	// Continue single threaded from here on
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Backup")
+	defer bdsm.mutex.Unlock()
+
+	if !bdsm.readonly {
+		if err := bdsm.flush(); err != nil {
+			return err
+		}
+	}
+
+	err := CopyDataFiles(bdsm.filename, targetDir)
+
+	if err == nil {
+		bdsm.resetModified()
+	}
+
+	return err
+}
+
 /*
 Rollback cancels all pending changes which have not yet been written to disk.
 */
-func (bdsm *ByteDiskStorageManager) Rollback() error {
+func (bdsm *ByteDiskStorageManager) Rollback() (err error) {
 
 	// Rollback has no effect if transactions are disabled or when readonly
 
@@ -532,11 +1122,13 @@ func (bdsm *ByteDiskStorageManager) Rollback() error {
 
 	bdsm.checkFileOpen()
 
+	defer bdsm.metrics.record(MetricRollback, time.Now(), &err)
+
 	ce := errorutil.NewCompositeError()
 
 	// Continue single threaded from here on
 
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Rollback")
 	defer bdsm.mutex.Unlock()
 
 	// Write pending manager changes to transaction log
@@ -559,6 +1151,10 @@ func (bdsm *ByteDiskStorageManager) Rollback() error {
 		ce.Add(err)
 	}
 
+	if err := bdsm.blobSlotsPager.Rollback(); err != nil {
+		ce.Add(err)
+	}
+
 	if err := bdsm.logicalSlotsPager.Rollback(); err != nil {
 		ce.Add(err)
 	}
@@ -567,6 +1163,20 @@ func (bdsm *ByteDiskStorageManager) Rollback() error {
 		ce.Add(err)
 	}
 
+	// Discard cached free slot information of the rolled back transaction
+
+	bdsm.physicalSlotManager.ResetFreeSlotCache()
+
+	// Drop the versions which were kept for the rolled back changes
+
+	bdsm.versions.rollbackVersions()
+
+	// Restore the expiry times of the last commit
+
+	if err := bdsm.loadExpiries(); err != nil {
+		ce.Add(err)
+	}
+
 	// Return errors if there were any
 
 	if ce.HasErrors() {
@@ -582,13 +1192,27 @@ Close closes the StorageManager and write all pending changes to disk.
 func (bdsm *ByteDiskStorageManager) Close() error {
 	bdsm.checkFileOpen()
 
+	// Stop the scrubber before taking the lock since a running scrub
+	// holds it as well
+
+	bdsm.StopScrubber()
+	bdsm.StopReaper()
+
 	ce := errorutil.NewCompositeError()
 
 	// Continue single threaded from here on
 
-	bdsm.mutex.Lock()
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Close")
 	defer bdsm.mutex.Unlock()
 
+	// Write changed expiry times
+
+	if !bdsm.readonly {
+		if err := bdsm.writeExpiries(); err != nil {
+			ce.Add(err)
+		}
+	}
+
 	// Try to close all files and collect any errors which are returned
 
 	if err := bdsm.physicalSlotsPager.Close(); err != nil {
@@ -604,6 +1228,15 @@ func (bdsm *ByteDiskStorageManager) Close() error {
 		ce.Add(err)
 	}
 
+	// The large object file is closed last and only if all other files
+	// could be closed so a failed close leaves it usable
+
+	if !ce.HasErrors() {
+		if err := bdsm.blobSlotsPager.Close(); err != nil {
+			ce.Add(err)
+		}
+	}
+
 	// Return errors if there were any
 
 	if ce.HasErrors() {
@@ -616,6 +1249,8 @@ func (bdsm *ByteDiskStorageManager) Close() error {
 	bdsm.physicalSlotsPager = nil
 	bdsm.physicalFreeSlotsSf = nil
 	bdsm.physicalFreeSlotsPager = nil
+	bdsm.blobSlotsSf = nil
+	bdsm.blobSlotsPager = nil
 	bdsm.physicalSlotManager = nil
 	bdsm.logicalSlotsSf = nil
 	bdsm.logicalSlotsPager = nil
@@ -623,6 +1258,8 @@ func (bdsm *ByteDiskStorageManager) Close() error {
 	bdsm.logicalFreeSlotsPager = nil
 	bdsm.logicalSlotManager = nil
 
+	unregisterMetrics(bdsm.filename, bdsm.metrics)
+
 	if bdsm.lockfile != nil {
 		return bdsm.lockfile.Finish()
 	}
@@ -648,6 +1285,10 @@ initByteDiskStorageManager initialises the file managers of a given ByteDiskStor
 */
 func initByteDiskStorageManager(bdsm *ByteDiskStorageManager) error {
 
+	// Check if a previous process left its lockfile behind
+
+	rr := bdsm.newRecoveryReport()
+
 	// Kick off the lockfile watcher
 
 	if bdsm.lockfile != nil {
@@ -657,6 +1298,10 @@ func initByteDiskStorageManager(bdsm *ByteDiskStorageManager) error {
 		}
 	}
 
+	// Discard incomplete transactions once the files are owned
+
+	bdsm.repairTransactionLogs(rr)
+
 	// Try to open all files and collect all errors
 
 	ce := errorutil.NewCompositeError()
@@ -683,9 +1328,23 @@ func initByteDiskStorageManager(bdsm *ByteDiskStorageManager) error {
 	bdsm.physicalFreeSlotsSf = sf
 	bdsm.physicalFreeSlotsPager = pager
 
+	sf, pager, err = createFileAndPager(
+		fmt.Sprintf("%v.%v", bdsm.filename, FileSuffixBlobSlots),
+		BlockSizePhysicalSlots, bdsm)
+
+	if err != nil {
+		ce.Add(err)
+	}
+
+	bdsm.blobSlotsSf = sf
+	bdsm.blobSlotsPager = pager
+
 	if !ce.HasErrors() {
 		bdsm.physicalSlotManager = slotting.NewPhysicalSlotManager(bdsm.physicalSlotsPager,
 			bdsm.physicalFreeSlotsPager, bdsm.onlyAppend)
+
+		bdsm.physicalSlotManager.SetBlobStorage(slotting.NewBlobManager(bdsm.blobSlotsPager),
+			DefaultBlobThreshold)
 	}
 
 	sf, pager, err = createFileAndPager(
@@ -728,6 +1387,12 @@ func initByteDiskStorageManager(bdsm *ByteDiskStorageManager) error {
 		return ce
 	}
 
+	bdsm.versions = newVersionStore()
+
+	if err := bdsm.loadExpiries(); err != nil {
+		return err
+	}
+
 	// Check version
 
 	version := bdsm.Root(RootIDVersion)
@@ -746,6 +1411,10 @@ func initByteDiskStorageManager(bdsm *ByteDiskStorageManager) error {
 		bdsm.SetRoot(RootIDVersion, VERSION)
 	}
 
+	if rr.Lockfile || len(rr.Discarded) > 0 {
+		bdsm.recovery = rr
+	}
+
 	return nil
 }
 
@@ -13,7 +13,11 @@ package storage
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -21,6 +25,7 @@ import (
 	"devt.de/common/lockutil"
 	"devt.de/common/testutil"
 	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging/view"
 	"devt.de/eliasdb/storage/slotting/pageview"
 	"devt.de/eliasdb/storage/util"
 )
@@ -156,7 +161,7 @@ func TestDiskStorageManager1(t *testing.T) {
 	}
 
 	_, err = dsm.Insert(&testutil.GobTestObject{"test", false, false})
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -170,7 +175,7 @@ func TestDiskStorageManager1(t *testing.T) {
 	}
 
 	_, err = dsm.Insert(&testutil.GobTestObject{"test", false, false})
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err, loc)
 	}
 
@@ -264,7 +269,7 @@ func TestDiskStorageManager2(t *testing.T) {
 	record, err := dsm.logicalSlotsSf.Get(2)
 
 	_, err = dsm.Insert("This is a test")
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -286,13 +291,13 @@ func TestDiskStorageManager2(t *testing.T) {
 	record, err = dsm.logicalSlotsSf.Get(1)
 
 	err = dsm.Update(loc, "test")
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
 
 	err = dsm.Fetch(loc, &res)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -328,7 +333,7 @@ func TestDiskStorageManager2(t *testing.T) {
 
 	var testres2 testutil.GobTestObject
 	err = dsm.Fetch(loc, &testres2)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -336,7 +341,7 @@ func TestDiskStorageManager2(t *testing.T) {
 	// Test a normal update
 
 	err = dsm.Update(loc, "tree")
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -471,7 +476,7 @@ func TestDiskStorageManager3(t *testing.T) {
 
 	record, _ := dsm.physicalSlotsSf.Get(1)
 
-	if dsm.Free(loc) != file.ErrAlreadyInUse {
+	if !errors.Is(dsm.Free(loc), file.ErrAlreadyInUse) {
 		t.Error("Unexpected free result")
 		return
 	}
@@ -480,7 +485,7 @@ func TestDiskStorageManager3(t *testing.T) {
 
 	record, _ = dsm.logicalSlotsSf.Get(1)
 
-	if dsm.Free(loc) != file.ErrAlreadyInUse {
+	if !errors.Is(dsm.Free(loc), file.ErrAlreadyInUse) {
 		t.Error("Unexpected free result")
 		return
 	}
@@ -594,7 +599,7 @@ const InvalidFileName = "**" + string(0x0)
 func TestDiskStorageManagerInit(t *testing.T) {
 	lockfile := lockutil.NewLockFile(DBDIR+"/"+"lock0.lck", time.Duration(50)*time.Millisecond)
 	dsm := &DiskStorageManager{&ByteDiskStorageManager{DBDIR + "/" + InvalidFileName, false, true, true, &sync.Mutex{},
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, lockfile}}
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, lockfile, nil, nil, nil, nil, nil, nil}}
 
 	err := initByteDiskStorageManager(dsm.ByteDiskStorageManager)
 	if err == nil {
@@ -605,7 +610,7 @@ func TestDiskStorageManagerInit(t *testing.T) {
 	testCannotInitPanic(t)
 
 	dsm = &DiskStorageManager{&ByteDiskStorageManager{DBDIR + "/test999", false, true, true, &sync.Mutex{},
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
 
 	err = initByteDiskStorageManager(dsm.ByteDiskStorageManager)
 	if err != nil {
@@ -646,7 +651,7 @@ func testClosedPanic(t *testing.T, dsm *DiskStorageManager) {
 
 func testVersionCheckPanic(t *testing.T) {
 	dsm := &DiskStorageManager{&ByteDiskStorageManager{DBDIR + "/test999", false, true, true, &sync.Mutex{},
-		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}}
 
 	defer func() {
 		if r := recover(); r == nil {
@@ -664,3 +669,806 @@ func checkLocation(t *testing.T, loc uint64, record uint64, offset uint16) {
 		t.Error("Unexpected location. Expected:", record, offset, "Got:", lrecord, loffset)
 	}
 }
+
+func TestDiskStorageManagerCompact(t *testing.T) {
+	dsm := NewByteDiskStorageManager(DBDIR+"/test5", false, false, true, true)
+
+	// Store objects of different sizes - some span several pages
+
+	data := make(map[uint64][]byte)
+
+	var locs []uint64
+
+	for i := 0; i < 200; i++ {
+		size := 100 + i*7
+		if i%25 == 0 {
+			size = BlockSizePhysicalSlots*2 + i
+		}
+
+		b := bytes.Repeat([]byte{byte(i)}, size)
+
+		loc, err := dsm.Insert(b)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		data[loc] = b
+		locs = append(locs, loc)
+	}
+
+	// Free most of the objects
+
+	for i, loc := range locs {
+		if i%4 != 0 {
+			if err := dsm.Free(loc); err != nil {
+				t.Error(err)
+				return
+			}
+			delete(data, loc)
+		}
+	}
+
+	checkData := func() {
+		for loc, b := range data {
+			var res bytes.Buffer
+
+			if err := dsm.Fetch(loc, &res); err != nil {
+				t.Error(err)
+				return
+			}
+
+			if !bytes.Equal(res.Bytes(), b) {
+				t.Error("Unexpected data for location:", loc)
+				return
+			}
+		}
+	}
+
+	stats, _ := dsm.physicalSlotsPager.Stats()
+	dataPages := stats.DataPages
+
+	if err := dsm.Compact(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkData()
+
+	stats, _ = dsm.physicalSlotsPager.Stats()
+
+	if stats.DataPages >= dataPages || stats.FreePhysicalSlotPages != 0 {
+		t.Error("Unexpected page stats after compaction:", dataPages, stats)
+		return
+	}
+
+	if stats.FreePages != dataPages-stats.DataPages {
+		t.Error("Unused pages should have been returned to the free list:", stats)
+		return
+	}
+
+	// New data should be stored after the compacted data
+
+	loc, err := dsm.Insert([]byte("new data"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	data[loc] = []byte("new data")
+
+	loc, err = dsm.Insert(bytes.Repeat([]byte("x"), BlockSizePhysicalSlots*3))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	data[loc] = bytes.Repeat([]byte("x"), BlockSizePhysicalSlots*3)
+
+	checkData()
+
+	// Check that everything can be read after the files have been reopened
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewByteDiskStorageManager(DBDIR+"/test5", false, false, true, true)
+
+	checkData()
+
+	// Compacting an empty store should release all data pages
+
+	for loc := range data {
+		if err := dsm.Free(loc); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	data = make(map[uint64][]byte)
+
+	if err := dsm.Compact(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats, _ = dsm.physicalSlotsPager.Stats(); stats.DataPages != 0 {
+		t.Error("Unexpected page stats after compaction:", stats)
+		return
+	}
+
+	// The released pages can now be removed from disk
+
+	if err := dsm.Truncate(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats, _ = dsm.physicalSlotsPager.Stats(); stats.TotalPages != 0 {
+		t.Error("Unexpected page stats after truncation:", stats)
+		return
+	}
+
+	if info, err := os.Stat(DBDIR + "/test5.db.0"); err != nil || info.Size() != BlockSizePhysicalSlots {
+		t.Error("Unexpected file size after truncation:", info, err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewByteDiskStorageManager(DBDIR+"/test5", true, false, true, true)
+
+	if err := dsm.Compact(); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm.Truncate(); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskStorageManagerUserData(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/test6", false, false, true, true)
+
+	if res := dsm.UserData("format"); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := dsm.SetUserData("format", []byte("v2")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewDiskStorageManager(DBDIR+"/test6", true, false, true, true)
+
+	if res := string(dsm.UserData("format")); res != "v2" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := dsm.SetUserData("format", []byte("v3")); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskStorageManagerChecksums(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/test7", false, false, false, true)
+
+	dsm.SetChecksums(true)
+
+	loc, err := dsm.Insert("This is a test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewDiskStorageManager(DBDIR+"/test7", false, false, false, true)
+
+	var res string
+
+	if err := dsm.Fetch(loc, &res); err != nil || res != "This is a test" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Checksums are not written by default but existing ones are still validated
+
+	if dsm.physicalSlotsPager.Checksums() {
+		t.Error("Checksums should not be enabled")
+		return
+	}
+
+	if err := dsm.Update(loc, "This is another test"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewDiskStorageManager(DBDIR+"/test7", false, false, false, true)
+
+	if err := dsm.Fetch(loc, &res); err != nil || res != "This is another test" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskStorageManagerPageAccessStats(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/test8", false, false, false, true)
+
+	loc, err := dsm.Insert("This is a test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewDiskStorageManager(DBDIR+"/test8", false, false, false, true)
+
+	var res string
+
+	for i := 0; i < 2; i++ {
+		if err := dsm.Fetch(loc, &res); err != nil || res != "This is a test" {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+
+	stats := dsm.PageAccessStats()
+
+	// Both the translation page and the data page were read from disk
+	// and then accessed again from memory
+
+	if ts := stats[view.TypeTranslationPage]; ts == nil || ts.Misses != 1 || ts.Hits != 1 {
+		t.Error("Unexpected translation page stats:", ts)
+		return
+	}
+
+	if ds := stats[view.TypeDataPage]; ds == nil || ds.Misses != 1 || ds.Hits != 1 {
+		t.Error("Unexpected data page stats:", ds)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskStorageManagerSizeReport(t *testing.T) {
+	dsm := NewByteDiskStorageManager(DBDIR+"/test9", false, false, true, true)
+
+	var locs []uint64
+
+	for _, size := range []int{100, 2000, 20000} {
+		loc, err := dsm.Insert(make([]byte, size))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	if err := dsm.Free(locs[1]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sr, err := dsm.SizeReport()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if sr.Slots != 2 || sr.DataBytes != 20100 || sr.SlotBytes < sr.DataBytes {
+		t.Error("Unexpected size report:", sr)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskStorageManagerWasteMargins(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/test10", false, false, false, true)
+
+	physicalLocation := func(loc uint64) uint64 {
+		ploc, err := dsm.logicalSlotManager.Fetch(loc)
+		if err != nil {
+			t.Error(err)
+		}
+		return ploc
+	}
+
+	loc, err := dsm.Insert(strings.Repeat("a", 1000))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	freePloc := physicalLocation(loc)
+
+	if err := dsm.Free(loc); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// With tight margins the free slot is too big for small data
+
+	dsm.SetWasteMargins(1, 1)
+
+	if loc, err = dsm.Insert("x"); err != nil || physicalLocation(loc) == freePloc {
+		t.Error("Unexpected result:", loc, err)
+		return
+	}
+
+	// With default margins the free slot is reused
+
+	dsm.SetWasteMargins(0, 0)
+
+	if loc, err = dsm.Insert(strings.Repeat("b", 800)); err != nil || physicalLocation(loc) != freePloc {
+		t.Error("Unexpected result:", loc, err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskStorageManagerBlobs(t *testing.T) {
+	dsm := NewByteDiskStorageManager(DBDIR+"/test12", false, false, false, true)
+
+	large := bytes.Repeat([]byte("abcdefgh"), int(DefaultBlobThreshold)/4)
+
+	fetch := func(loc uint64) []byte {
+		var buf bytes.Buffer
+
+		if err := dsm.Fetch(loc, &buf); err != nil {
+			t.Error(err)
+		}
+
+		return buf.Bytes()
+	}
+
+	loc, err := dsm.Insert(large)
+	if err != nil || !bytes.Equal(fetch(loc), large) {
+		t.Error("Unexpected result:", loc, err)
+		return
+	}
+
+	// The data is not stored on the normal data pages
+
+	if stats, _ := dsm.physicalSlotsPager.Stats(); stats.DataPages != 0 {
+		t.Error("Unexpected stats:", stats)
+		return
+	} else if stats, _ := dsm.blobSlotsPager.Stats(); stats.DataPages == 0 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Changes to large objects can be rolled back
+
+	if err := dsm.Update(loc, append(large, large...)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Rollback(); err != nil || !bytes.Equal(fetch(loc), large) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Large objects survive a restart
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewByteDiskStorageManager(DBDIR+"/test12", false, false, false, true)
+
+	if !bytes.Equal(fetch(loc), large) {
+		return
+	}
+
+	if err := dsm.Free(loc); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats, _ := dsm.blobSlotsPager.Stats(); stats.DataPages != 0 || stats.FreePages == 0 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	// Large object storage can be switched off for new data
+
+	dsm.SetBlobThreshold(0)
+
+	if loc, err = dsm.Insert(large); err != nil || !bytes.Equal(fetch(loc), large) {
+		t.Error("Unexpected result:", loc, err)
+		return
+	}
+
+	if stats, _ := dsm.physicalSlotsPager.Stats(); stats.DataPages == 0 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskStorageManagerSlotFlags(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/test13", false, false, false, true)
+
+	loc, err := dsm.Insert("test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if flags, err := dsm.SlotFlags(loc); flags != 0 || err != nil {
+		t.Error("Unexpected result:", flags, err)
+		return
+	}
+
+	if err := dsm.SetSlotFlags(loc, pageview.SlotInfoFlagTombstone|pageview.SlotInfoFlagPinned); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Flags do not change the location and survive updates and compaction
+
+	if err := dsm.Update(loc, strings.Repeat("test", 100)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Compact(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res string
+
+	if err := dsm.Fetch(loc, &res); err != nil || res != strings.Repeat("test", 100) {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewDiskStorageManager(DBDIR+"/test13", false, false, false, true)
+
+	if flags, err := dsm.SlotFlags(loc); flags != pageview.SlotInfoFlagTombstone|pageview.SlotInfoFlagPinned ||
+		err != nil {
+		t.Error("Unexpected result:", flags, err)
+		return
+	}
+
+	// Freeing a location clears its flags
+
+	if err := dsm.Free(loc); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := dsm.SlotFlags(loc); err == nil || !strings.Contains(err.Error(), "Slot not found") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm.SetSlotFlags(loc, pageview.SlotInfoFlagDeleted); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if flags, err := dsm.logicalSlotManager.Flags(loc); flags != 0 || err != nil {
+		t.Error("Unexpected result:", flags, err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskStorageManagerInsertBatch(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/test14", false, false, false, true)
+
+	var objs []interface{}
+
+	for i := 0; i < 1000; i++ {
+		objs = append(objs, fmt.Sprint("test", i))
+	}
+
+	objs = append(objs, strings.Repeat("x", int(DefaultBlobThreshold)*2))
+
+	locs, err := dsm.InsertBatch(objs)
+	if err != nil || len(locs) != len(objs) {
+		t.Error("Unexpected result:", len(locs), err)
+		return
+	}
+
+	check := func() bool {
+		for i, loc := range locs {
+			var res string
+
+			if err := dsm.Fetch(loc, &res); err != nil || res != objs[i] {
+				t.Error("Unexpected result:", loc, err)
+				return false
+			}
+		}
+		return true
+	}
+
+	if !check() {
+		return
+	}
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewDiskStorageManager(DBDIR+"/test14", false, false, false, true)
+
+	if !check() {
+		return
+	}
+
+	dsm.readonly = true
+
+	if _, err := dsm.InsertBatch(objs); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dsm.readonly = false
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDiskStorageManagerBackup(t *testing.T) {
+	backupDir := DBDIR + "/backup15"
+
+	os.MkdirAll(backupDir, 0770)
+
+	dsm := NewDiskStorageManager(DBDIR+"/test15", false, false, false, true)
+
+	loc1, _ := dsm.Insert("test1")
+	dsm.Flush()
+
+	// Pending changes are part of the backup
+
+	loc2, _ := dsm.Insert(strings.Repeat("x", int(DefaultBlobThreshold)*2))
+
+	if err := dsm.Backup(backupDir); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The storage stays writable and later changes are not in the backup
+
+	loc3, err := dsm.Insert("test3")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	bdsm := NewDiskStorageManager(backupDir+"/test15", false, false, false, true)
+
+	var res string
+
+	if err := bdsm.Fetch(loc1, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := bdsm.Fetch(loc2, &res); err != nil || len(res) != int(DefaultBlobThreshold)*2 {
+		t.Error("Unexpected result:", len(res), err)
+		return
+	}
+
+	if err := bdsm.Fetch(loc3, &res); err == nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := bdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Test error case
+
+	dsm = NewDiskStorageManager(DBDIR+"/test15", false, false, false, true)
+
+	if err := dsm.Backup(DBDIR + "/backup15/" + InvalidFileName); err == nil {
+		t.Error("Backup into an invalid directory should fail")
+		return
+	}
+
+	dsm.Close()
+}
+
+func TestDiskStorageManagerIncrementalBackup(t *testing.T) {
+	backupDir := DBDIR + "/backup16"
+	restoreDir := DBDIR + "/restore16"
+
+	os.MkdirAll(backupDir, 0770)
+	os.MkdirAll(restoreDir, 0770)
+
+	dsm := NewDiskStorageManager(DBDIR+"/test16", false, false, false, true)
+
+	loc1, _ := dsm.Insert("test1")
+	loc2, _ := dsm.Insert("test2")
+
+	if err := dsm.Backup(backupDir); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// First increment
+
+	dsm.Update(loc1, "test1 changed")
+	loc3, _ := dsm.Insert(strings.Repeat("x", int(DefaultBlobThreshold)*2))
+
+	if err := dsm.IncrementalBackup(backupDir + "/test16.inc1"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Second increment
+
+	dsm.Free(loc2)
+	loc4, _ := dsm.Insert("test4")
+
+	if err := dsm.IncrementalBackup(backupDir + "/test16.inc2"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Restore the backup
+
+	if err := CopyDataFiles(backupDir+"/test16", restoreDir); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, inc := range []string{"test16.inc1", "test16.inc2"} {
+		if err := ApplyIncrement(restoreDir+"/test16", backupDir+"/"+inc); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	rdsm := NewDiskStorageManager(restoreDir+"/test16", false, false, false, true)
+
+	var res string
+
+	if err := rdsm.Fetch(loc1, &res); err != nil || res != "test1 changed" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := rdsm.Fetch(loc3, &res); err != nil || len(res) != int(DefaultBlobThreshold)*2 {
+		t.Error("Unexpected result:", len(res), err)
+		return
+	}
+
+	if err := rdsm.Fetch(loc4, &res); err != nil || res != "test4" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := rdsm.Fetch(loc2, &res); err == nil && loc2 != loc4 {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if err := rdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Test error cases
+
+	ioutil.WriteFile(backupDir+"/test16.bad", []byte("xxxx"), 0660)
+
+	if err := ApplyIncrement(restoreDir+"/test16", backupDir+"/test16.bad"); err == nil ||
+		err.Error() != "Invalid increment file: "+backupDir+"/test16.bad" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := ApplyIncrement(restoreDir+"/test16", backupDir+"/test16.foo"); err == nil {
+		t.Error("Applying a missing increment should fail")
+		return
+	}
+}
@@ -0,0 +1,268 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/storage/paging/view"
+	"devt.de/eliasdb/storage/slotting/pageview"
+	"devt.de/eliasdb/storage/util"
+)
+
+/*
+DumpMagic is the magic number which starts a dump stream
+*/
+var DumpMagic = []byte{0x45, 0x44}
+
+/*
+DumpVersion is the version of the dump format which is written by ExportDump
+*/
+const DumpVersion = 1
+
+/*
+dumpHeader is the first entry of a dump stream.
+*/
+type dumpHeader struct {
+	Version  int               // Version of the dump format
+	Name     string            // Name of the dumped storage manager
+	Roots    map[int]uint64    // Root values (without the storage version)
+	UserData map[string][]byte // User data of the storage file header
+}
+
+/*
+dumpRecord is a single record of a dump stream. The stream is terminated by
+a record with location 0.
+*/
+type dumpRecord struct {
+	Loc   uint64 // Storage location of the record
+	Flags byte   // Flags of the storage location
+	Data  []byte // Data of the record
+}
+
+/*
+ExportDump writes all records, root values and user data of this storage
+manager as a dump stream. The stream only contains the stored data and is
+independent of the page layout of the storage files. Pending changes should
+be flushed before calling this function.
+*/
+func (bdsm *ByteDiskStorageManager) ExportDump(w io.Writer) error {
+	bdsm.checkFileOpen()
+
+	// Continue single threaded from here on
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "ExportDump")
+	defer bdsm.mutex.Unlock()
+
+	header := bdsm.physicalSlotsPager.Header()
+
+	dh := &dumpHeader{DumpVersion, bdsm.filename, make(map[int]uint64), make(map[string][]byte)}
+
+	for i := 0; i < header.Roots(); i++ {
+		if val := header.Root(i); val != 0 && i != RootIDVersion {
+			dh.Roots[i] = val
+		}
+	}
+
+	for _, key := range header.UserDataKeys() {
+		dh.UserData[key] = header.UserData(key)
+	}
+
+	bw := bufio.NewWriter(w)
+
+	if _, err := bw.Write(DumpMagic); err != nil {
+		return err
+	}
+
+	enc := gob.NewEncoder(bw)
+
+	if err := enc.Encode(dh); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	err := bdsm.logicalSlotManager.ForEach(func(loc uint64, ploc uint64) error {
+		buf.Reset()
+
+		if err := bdsm.physicalSlotManager.Fetch(ploc, &buf); err != nil {
+			return err
+		}
+
+		flags, err := bdsm.logicalSlotManager.Flags(loc)
+		if err != nil {
+			return err
+		}
+
+		return enc.Encode(&dumpRecord{loc, flags, buf.Bytes()})
+	})
+
+	if err == nil {
+		if err = enc.Encode(&dumpRecord{0, 0, nil}); err == nil {
+			err = bw.Flush()
+		}
+	}
+
+	return err
+}
+
+/*
+ImportDump reads a dump stream which was written by ExportDump. All records
+keep their storage locations. The storage manager must not have been used
+before. The imported data is part of the current transaction.
+*/
+func (bdsm *ByteDiskStorageManager) ImportDump(r io.Reader) error {
+	bdsm.checkFileOpen()
+
+	// Fail operation if readonly
+
+	if bdsm.readonly {
+		return ErrReadonly
+	}
+
+	// Continue single threaded from here on
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "ImportDump")
+	defer bdsm.mutex.Unlock()
+
+	if bdsm.logicalSlotsPager.First(view.TypeTranslationPage) != 0 {
+		return ErrInvalidDump.fireError(bdsm, "Storage is not empty")
+	}
+
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(DumpMagic))
+
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, DumpMagic) {
+		return ErrInvalidDump.fireError(bdsm, "Invalid header")
+	}
+
+	dec := gob.NewDecoder(br)
+
+	var dh dumpHeader
+
+	if err := dec.Decode(&dh); err != nil {
+		return ErrInvalidDump.fireError(bdsm, fmt.Sprint("Invalid header: ", err))
+	} else if dh.Version > DumpVersion {
+		return ErrInvalidDump.fireError(bdsm, fmt.Sprint("Unsupported version: ", dh.Version))
+	}
+
+	// Slots can only be placed if their offset exists in the translation
+	// pages of this storage
+
+	maxOffset := uint16(pageview.OffsetTransData) +
+		bdsm.logicalSlotManager.ElementsPerPage()*util.LocationSize
+
+	for {
+		var rec dumpRecord
+
+		if err := dec.Decode(&rec); err != nil {
+			return ErrInvalidDump.fireError(bdsm, fmt.Sprint("Invalid record: ", err))
+		} else if rec.Loc == 0 {
+			break
+		}
+
+		offset := util.LocationOffset(rec.Loc)
+
+		if util.LocationRecord(rec.Loc) == 0 || offset < pageview.OffsetTransData || offset >= maxOffset ||
+			(offset-pageview.OffsetTransData)%util.LocationSize != 0 {
+
+			return ErrInvalidDump.fireError(bdsm, fmt.Sprint("Location cannot be stored: ",
+				util.LocationRecord(rec.Loc), util.LocationOffset(rec.Loc)))
+		}
+
+		if ploc, err := bdsm.logicalSlotManager.Fetch(rec.Loc); err != nil {
+			return err
+		} else if ploc != 0 {
+			return ErrInvalidDump.fireError(bdsm, fmt.Sprint("Duplicate location: ",
+				util.LocationRecord(rec.Loc), util.LocationOffset(rec.Loc)))
+		}
+
+		ploc, err := bdsm.physicalSlotManager.Insert(rec.Data, 0, uint32(len(rec.Data)))
+		if err != nil {
+			return err
+		}
+
+		if err := bdsm.logicalSlotManager.ForceInsert(rec.Loc, ploc); err != nil {
+			return err
+		}
+
+		if rec.Flags != 0 {
+			if err := bdsm.logicalSlotManager.SetFlags(rec.Loc, rec.Flags); err != nil {
+				return err
+			}
+		}
+
+		if err := bdsm.preserveRecord(rec.Loc, 0); err != nil {
+			return err
+		}
+	}
+
+	// Slots which were skipped in the translation pages can be used for
+	// new records
+
+	if err := bdsm.logicalSlotManager.AddUnusedSlots(); err != nil {
+		return err
+	}
+
+	header := bdsm.physicalSlotsPager.Header()
+
+	for root, val := range dh.Roots {
+		if root < header.Roots() && root != RootIDVersion {
+			if err := bdsm.preserveRoot(root); err != nil {
+				return err
+			}
+
+			header.SetRoot(root, val)
+		}
+	}
+
+	for key, val := range dh.UserData {
+		if err := header.SetUserData(key, val); err != nil {
+			return err
+		}
+	}
+
+	// The expiry table might have been part of the dump
+
+	return bdsm.loadExpiries()
+}
+
+/*
+ExportDump writes all records, root values and user data as a dump stream
+(see ByteDiskStorageManager.ExportDump). Modified records which are held in
+the cache are written first.
+*/
+func (cdsm *CachedDiskStorageManager) ExportDump(w io.Writer) error {
+	if err := cdsm.Sync(); err != nil {
+		return err
+	}
+
+	return cdsm.diskstoragemanager.ExportDump(w)
+}
+
+/*
+ImportDump reads a dump stream (see ByteDiskStorageManager.ImportDump). The
+cache is emptied.
+*/
+func (cdsm *CachedDiskStorageManager) ImportDump(r io.Reader) error {
+	cdsm.mutex.Lock()
+	defer cdsm.mutex.Unlock()
+
+	cdsm.emptyCache()
+
+	return cdsm.diskstoragemanager.ImportDump(r)
+}
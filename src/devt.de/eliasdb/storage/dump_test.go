@@ -0,0 +1,171 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"devt.de/eliasdb/storage/util"
+)
+
+func TestDiskStorageManagerDump(t *testing.T) {
+	var res string
+
+	dsm := NewDiskStorageManager(DBDIR+"/test21a", false, false, false, true)
+
+	var locs []uint64
+
+	for i := 0; i < 10; i++ {
+		loc, _ := dsm.Insert(fmt.Sprint("test", i))
+		locs = append(locs, loc)
+	}
+
+	// Freed slots leave gaps in the translation pages
+
+	dsm.Free(locs[2])
+	dsm.Free(locs[5])
+
+	dsm.SetRoot(2, 42)
+	dsm.SetSlotFlags(locs[3], 5)
+
+	future := time.Now().Add(time.Hour)
+	dsm.SetExpiry(locs[4], future)
+
+	dsm.Flush()
+
+	var buf bytes.Buffer
+
+	if err := dsm.ExportDump(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dump := buf.Bytes()
+
+	dsm2 := NewDiskStorageManager(DBDIR+"/test21b", false, false, false, true)
+
+	if err := dsm2.ImportDump(bytes.NewBuffer(dump)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, loc := range locs {
+		err := dsm2.Fetch(loc, &res)
+
+		if i == 2 || i == 5 {
+			if err != ErrSlotNotFound {
+				t.Error("Unexpected result:", err)
+				return
+			}
+		} else if err != nil || res != fmt.Sprint("test", i) {
+			t.Error("Unexpected result:", res, err)
+			return
+		}
+	}
+
+	if res := dsm2.Root(2); res != 42 {
+		t.Error("Unexpected root:", res)
+		return
+	}
+
+	if res := dsm2.Root(RootIDVersion); res != VERSION {
+		t.Error("Unexpected version:", res)
+		return
+	}
+
+	if flags, err := dsm2.SlotFlags(locs[3]); flags != 5 || err != nil {
+		t.Error("Unexpected flags:", flags, err)
+		return
+	}
+
+	if ts, ok := dsm2.Expiry(locs[4]); !ok || ts.UnixNano() != future.UnixNano() {
+		t.Error("Unexpected expiry:", ts, ok)
+		return
+	}
+
+	// New records use the skipped slots
+
+	loc, err := dsm2.Insert("new")
+	if err != nil || (loc != locs[2] && loc != locs[5]) {
+		t.Error("Unexpected location:", util.LocationRecord(loc), util.LocationOffset(loc), err)
+		return
+	}
+
+	if err := dsm2.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Only empty storages can be imported into
+
+	if err := dsm2.ImportDump(bytes.NewBuffer(dump)); err == nil ||
+		!strings.HasPrefix(err.Error(), "Invalid dump") || !strings.Contains(err.Error(), "Storage is not empty") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dsm2.Close()
+
+	// Test invalid streams
+
+	dsm3 := NewDiskStorageManager(DBDIR+"/test21c", false, false, false, true)
+
+	if err := dsm3.ImportDump(bytes.NewBufferString("xx")); err == nil ||
+		!strings.Contains(err.Error(), "Invalid header") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm3.ImportDump(bytes.NewBuffer(dump[:len(dump)-10])); err == nil ||
+		!strings.Contains(err.Error(), "Invalid record") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dsm3.Rollback()
+	dsm3.Close()
+
+	// Cached storage managers write pending changes before the export
+
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	if err := cdsm.Update(locs[0], "updated"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	buf.Reset()
+
+	if err := cdsm.ExportDump(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cdsm4 := NewCachedDiskStorageManager(NewDiskStorageManager(DBDIR+"/test21d",
+		false, false, false, true), 10)
+
+	if err := cdsm4.ImportDump(&buf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := cdsm4.Fetch(locs[0], &res); err != nil || res != "updated" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	cdsm.Close()
+	cdsm4.Close()
+}
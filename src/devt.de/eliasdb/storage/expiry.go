@@ -0,0 +1,340 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/storage/util"
+)
+
+/*
+LogReap is called with the errors of the background reaper
+*/
+var LogReap ScrubLogger = func(v ...interface{}) {}
+
+/*
+DefaultReapInterval is the default time between two runs of the background
+reaper
+*/
+var DefaultReapInterval = time.Minute
+
+/*
+userDataExpiries is the user data key which holds the storage location of
+the expiry table
+*/
+const userDataExpiries = "expiries"
+
+/*
+expiryStore holds the expiry times of storage locations. The table is written
+to the storage with the next flush - it is part of the same transaction as
+the records which it refers to.
+*/
+type expiryStore struct {
+	entries map[uint64]int64 // Expiry times of storage locations (Unix time in nanoseconds)
+	loc     uint64           // Storage location of the stored table (0 if it was not stored yet)
+	dirty   bool             // Flag if the table was changed since it was stored
+	reaper  *reaper          // Background reaper (nil if not running)
+}
+
+/*
+reaper data structure
+*/
+type reaper struct {
+	stop chan bool       // Channel to stop the reaper
+	wg   *sync.WaitGroup // Waitgroup for the reaper goroutine
+}
+
+/*
+remove removes the expiry time of a storage location.
+*/
+func (es *expiryStore) remove(loc uint64) {
+	if _, ok := es.entries[loc]; ok {
+		delete(es.entries, loc)
+		es.dirty = true
+	}
+}
+
+/*
+InsertWithExpiry inserts an object which expires at a given time and returns
+its storage location. Expired locations are freed by ReapExpired.
+*/
+func (dsm *DiskStorageManager) InsertWithExpiry(o interface{}, expiry time.Time) (uint64, error) {
+
+	b, err := dsm.Serialize(o)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return dsm.ByteDiskStorageManager.InsertWithExpiry(b, expiry)
+}
+
+/*
+InsertWithExpiry inserts a byte slice which expires at a given time and
+returns its storage location. Expired locations are freed by ReapExpired.
+*/
+func (bdsm *ByteDiskStorageManager) InsertWithExpiry(o interface{}, expiry time.Time) (uint64, error) {
+
+	loc, err := bdsm.Insert(o)
+
+	if err == nil {
+		err = bdsm.SetExpiry(loc, expiry)
+	}
+
+	return loc, err
+}
+
+/*
+SetExpiry sets the time at which a storage location expires. A zero time
+removes the expiry time.
+*/
+func (bdsm *ByteDiskStorageManager) SetExpiry(loc uint64, expiry time.Time) error {
+	bdsm.checkFileOpen()
+
+	// Fail operation if readonly
+
+	if bdsm.readonly {
+		return ErrReadonly
+	}
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetExpiry")
+	defer bdsm.mutex.Unlock()
+
+	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
+	if err != nil {
+		return err
+	}
+
+	if ploc == 0 {
+		return ErrSlotNotFound.fireError(bdsm, fmt.Sprint("Location:",
+			util.LocationRecord(loc), util.LocationOffset(loc)))
+	}
+
+	if expiry.IsZero() {
+		bdsm.expiries.remove(loc)
+	} else {
+		bdsm.expiries.entries[loc] = expiry.UnixNano()
+		bdsm.expiries.dirty = true
+	}
+
+	return nil
+}
+
+/*
+Expiry returns the time at which a storage location expires. Returns false
+if the location has no expiry time.
+*/
+func (bdsm *ByteDiskStorageManager) Expiry(loc uint64) (time.Time, bool) {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Expiry")
+	defer bdsm.mutex.Unlock()
+
+	ts, ok := bdsm.expiries.entries[loc]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, ts), true
+}
+
+/*
+ReapExpired frees all storage locations which have expired. Returns the
+number of freed locations. The removals are part of the current transaction.
+*/
+func (bdsm *ByteDiskStorageManager) ReapExpired() (int, error) {
+	bdsm.checkFileOpen()
+
+	// When readonly this operation becomes a NOP
+
+	if bdsm.readonly {
+		return 0, nil
+	}
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "ReapExpired")
+	defer bdsm.mutex.Unlock()
+
+	now := time.Now().UnixNano()
+	count := 0
+
+	for loc, ts := range bdsm.expiries.entries {
+		if ts > now {
+			continue
+		}
+
+		if err := bdsm.free(loc); err == ErrSlotNotFound {
+
+			// The location was already freed
+
+			bdsm.expiries.remove(loc)
+
+		} else if err != nil {
+			return count, err
+
+		} else {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+/*
+StartReaper starts a background goroutine which frees expired storage
+locations in a given interval (DefaultReapInterval if the interval is 0).
+Errors are given to LogReap. The freed locations are written to disk with
+the next flush. A running reaper is replaced.
+*/
+func (bdsm *ByteDiskStorageManager) StartReaper(interval time.Duration) {
+	bdsm.StopReaper()
+
+	if interval == 0 {
+		interval = DefaultReapInterval
+	}
+
+	r := &reaper{make(chan bool), &sync.WaitGroup{}}
+
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+
+			case <-ticker.C:
+				if _, err := bdsm.ReapExpired(); err != nil {
+					LogReap(fmt.Sprintf("Reaping %v failed: %v", bdsm.filename, err))
+				}
+			}
+		}
+	}()
+
+	bdsm.mutex.Lock()
+	bdsm.expiries.reaper = r
+	bdsm.mutex.Unlock()
+}
+
+/*
+StopReaper stops the background reaper. Waits until a running reap has
+finished.
+*/
+func (bdsm *ByteDiskStorageManager) StopReaper() {
+	bdsm.mutex.Lock()
+	r := bdsm.expiries.reaper
+	bdsm.expiries.reaper = nil
+	bdsm.mutex.Unlock()
+
+	if r != nil {
+		close(r.stop)
+		r.wg.Wait()
+	}
+}
+
+/*
+loadExpiries reads the expiry table from the storage. It is assumed that the
+caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) loadExpiries() error {
+	var reaper *reaper
+
+	if bdsm.expiries != nil {
+		reaper = bdsm.expiries.reaper
+	}
+
+	bdsm.expiries = &expiryStore{make(map[uint64]int64), 0, false, reaper}
+
+	locBytes := bdsm.physicalSlotsPager.Header().UserData(userDataExpiries)
+	if len(locBytes) != 8 {
+		return nil
+	}
+
+	loc := binary.BigEndian.Uint64(locBytes)
+
+	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
+	if err != nil {
+		return err
+	} else if ploc == 0 {
+		return ErrSlotNotFound.fireError(bdsm, "Expiry table not found")
+	}
+
+	var buf bytes.Buffer
+
+	if err := bdsm.physicalSlotManager.Fetch(ploc, &buf); err != nil {
+		return err
+	}
+
+	if err := gob.NewDecoder(&buf).Decode(&bdsm.expiries.entries); err != nil {
+		return err
+	}
+
+	bdsm.expiries.loc = loc
+
+	return nil
+}
+
+/*
+writeExpiries writes the expiry table to the storage if it was changed. It is
+assumed that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) writeExpiries() error {
+	var buf bytes.Buffer
+
+	if !bdsm.expiries.dirty {
+		return nil
+	}
+
+	if err := gob.NewEncoder(&buf).Encode(bdsm.expiries.entries); err != nil {
+		return err
+	}
+
+	if bdsm.expiries.loc == 0 {
+
+		loc, err := bdsm.insert(buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		locBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(locBytes, loc)
+
+		if err := bdsm.physicalSlotsPager.Header().SetUserData(userDataExpiries, locBytes); err != nil {
+			return err
+		}
+
+		bdsm.expiries.loc = loc
+
+	} else {
+
+		ploc, err := bdsm.logicalSlotManager.Fetch(bdsm.expiries.loc)
+		if err != nil {
+			return err
+		}
+
+		if err := bdsm.update(bdsm.expiries.loc, ploc, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	bdsm.expiries.dirty = false
+
+	return nil
+}
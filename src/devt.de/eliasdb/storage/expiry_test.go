@@ -0,0 +1,132 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"devt.de/eliasdb/storage/util"
+)
+
+func TestDiskStorageManagerExpiry(t *testing.T) {
+	var res string
+
+	filename := DBDIR + "/test20"
+
+	dsm := NewDiskStorageManager(filename, false, false, false, true)
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	loc1, err := dsm.InsertWithExpiry("expired", past)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loc2, _ := dsm.InsertWithExpiry("valid", future)
+	loc3, _ := dsm.Insert("forever")
+
+	if ts, ok := dsm.Expiry(loc2); !ok || ts.UnixNano() != future.UnixNano() {
+		t.Error("Unexpected expiry:", ts, ok)
+		return
+	}
+
+	if _, ok := dsm.Expiry(loc3); ok {
+		t.Error("Location should not expire")
+		return
+	}
+
+	if err := dsm.SetExpiry(util.PackLocation(3, 18), future); err != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if n, err := dsm.ReapExpired(); n != 1 || err != nil {
+		t.Error("Unexpected result:", n, err)
+		return
+	}
+
+	if err := dsm.Fetch(loc1, &res); err != ErrSlotNotFound {
+		t.Error("Expired location should have been freed:", err)
+		return
+	}
+
+	if dsm.Fetch(loc2, &res); res != "valid" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	dsm.Flush()
+
+	// Expiry times of rolled back changes are discarded
+
+	dsm.SetExpiry(loc3, past)
+
+	if err := dsm.Rollback(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, ok := dsm.Expiry(loc3); ok {
+		t.Error("Expiry time should have been rolled back")
+		return
+	}
+
+	// Expiry times are stored
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm = NewDiskStorageManager(filename, false, false, false, true)
+
+	if ts, ok := dsm.Expiry(loc2); !ok || ts.UnixNano() != future.UnixNano() {
+		t.Error("Unexpected expiry:", ts, ok)
+		return
+	}
+
+	// A zero time removes the expiry time
+
+	dsm.SetExpiry(loc2, time.Time{})
+
+	if _, ok := dsm.Expiry(loc2); ok {
+		t.Error("Location should not expire")
+		return
+	}
+
+	// Test the background reaper
+
+	dsm.SetExpiry(loc3, past)
+
+	dsm.StartReaper(10 * time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		if _, ok := dsm.Expiry(loc3); !ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	dsm.StopReaper()
+
+	if err := dsm.Fetch(loc3, &res); err != ErrSlotNotFound {
+		t.Error("Expired location should have been freed:", err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,249 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+RecoveryLogger is a function which processes recovery log messages
+*/
+type RecoveryLogger func(v ...interface{})
+
+/*
+LogRecovery is called to report the progress of a transaction log recovery
+*/
+var LogRecovery RecoveryLogger = func(v ...interface{}) {}
+
+/*
+RecoveryLogInterval is the minimum time between two progress log messages
+during a recovery
+*/
+var RecoveryLogInterval = 5 * time.Second
+
+/*
+RecoveryProgress holds the progress of a transaction log recovery.
+*/
+type RecoveryProgress struct {
+	Name         string    // Name of the transaction log
+	Transactions int       // Number of replayed transactions
+	BytesRead    int64     // Number of bytes read from the transaction log
+	BytesTotal   int64     // Size of the transaction log
+	Started      time.Time // Start time of the recovery
+}
+
+/*
+Elapsed returns the time which has been spent on the recovery.
+*/
+func (rp *RecoveryProgress) Elapsed() time.Duration {
+	return time.Since(rp.Started)
+}
+
+/*
+Remaining returns the estimated time until the recovery is finished.
+*/
+func (rp *RecoveryProgress) Remaining() time.Duration {
+	if rp.BytesRead == 0 || rp.BytesRead >= rp.BytesTotal {
+		return 0
+	}
+
+	elapsed := rp.Elapsed()
+
+	return time.Duration(float64(elapsed) *
+		float64(rp.BytesTotal-rp.BytesRead) / float64(rp.BytesRead))
+}
+
+/*
+String returns a string representation of a RecoveryProgress.
+*/
+func (rp *RecoveryProgress) String() string {
+	var percent int64 = 100
+
+	if rp.BytesTotal > 0 {
+		percent = rp.BytesRead * 100 / rp.BytesTotal
+	}
+
+	return fmt.Sprintf("Recovering %v: %v transactions replayed (%v%%, %v of %v bytes) "+
+		"estimated remaining: %v", rp.Name, rp.Transactions, percent, rp.BytesRead,
+		rp.BytesTotal, rp.Remaining().Round(time.Second))
+}
+
+/*
+recoveries holds the progress of all running recoveries
+*/
+var recoveries = make(map[string]*RecoveryProgress)
+
+/*
+recoveriesLock protects the recoveries map
+*/
+var recoveriesLock = &sync.Mutex{}
+
+/*
+RecoveryStatus returns the progress of all running transaction log recoveries.
+*/
+func RecoveryStatus() []RecoveryProgress {
+	recoveriesLock.Lock()
+	defer recoveriesLock.Unlock()
+
+	ret := make([]RecoveryProgress, 0, len(recoveries))
+
+	for _, rp := range recoveries {
+		ret = append(ret, *rp)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+
+	return ret
+}
+
+/*
+recoveryTracker tracks the progress of a single recovery.
+*/
+type recoveryTracker struct {
+	reader   io.Reader         // Reader for the transaction log
+	progress *RecoveryProgress // Progress of the recovery
+	lastLog  time.Time         // Time of the last progress log message
+}
+
+/*
+newRecoveryTracker registers a new recovery of a transaction log with a given
+size.
+*/
+func newRecoveryTracker(name string, reader io.Reader, offset int64, size int64) *recoveryTracker {
+	now := time.Now()
+
+	rp := &RecoveryProgress{name, 0, offset, size, now}
+
+	recoveriesLock.Lock()
+	recoveries[name] = rp
+	recoveriesLock.Unlock()
+
+	LogRecovery(fmt.Sprintf("Recovering transaction log %v (%v bytes)", name, size))
+
+	return &recoveryTracker{reader, rp, now}
+}
+
+/*
+Read reads from the transaction log and records the number of read bytes.
+*/
+func (rt *recoveryTracker) Read(p []byte) (int, error) {
+	n, err := rt.reader.Read(p)
+
+	recoveriesLock.Lock()
+	rt.progress.BytesRead += int64(n)
+	recoveriesLock.Unlock()
+
+	return n, err
+}
+
+/*
+replayed records a replayed transaction and logs the progress if the log
+interval has passed.
+*/
+func (rt *recoveryTracker) replayed() {
+	recoveriesLock.Lock()
+	rt.progress.Transactions++
+	rp := *rt.progress
+	recoveriesLock.Unlock()
+
+	if time.Since(rt.lastLog) >= RecoveryLogInterval {
+		rt.lastLog = time.Now()
+		LogRecovery(rp.String())
+	}
+}
+
+/*
+finish unregisters the recovery.
+*/
+func (rt *recoveryTracker) finish() {
+	recoveriesLock.Lock()
+	delete(recoveries, rt.progress.Name)
+	recoveriesLock.Unlock()
+
+	LogRecovery(fmt.Sprintf("Recovered %v transactions from %v in %v",
+		rt.progress.Transactions, rt.progress.Name,
+		rt.progress.Elapsed().Round(time.Millisecond)))
+}
+
+/*
+RepairTransactionLog truncates the transaction log of a given storage file
+after its last complete transaction. The log of a storage file which was not
+closed properly might end with an incomplete transaction which would otherwise
+prevent the storage file from being opened. Returns the number of discarded
+bytes.
+*/
+func RepairTransactionLog(name string) (int64, error) {
+	file, err := os.OpenFile(fmt.Sprintf("%s.%s", name, LogFileSuffix), os.O_RDWR, 0660)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	// Logs with a bad magic are overwritten when the storage file is opened
+
+	magic := make([]byte, 2)
+	i, _ := file.Read(magic)
+
+	if i != 2 || magic[0] != TransactionLogHeader[0] ||
+		magic[1] != TransactionLogHeader[1] {
+		return 0, nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// Read complete transactions - records are read directly from the file
+	// so the file offset is the end of the last complete transaction
+
+	var complete int64 = int64(i)
+
+	for complete < info.Size() {
+		var numRecords int64
+
+		if err := binary.Read(file, binary.LittleEndian, &numRecords); err != nil || numRecords < 0 {
+			break
+		}
+
+		var err error
+
+		for j := int64(0); j < numRecords && err == nil; j++ {
+			_, err = ReadRecord(file)
+		}
+
+		if err != nil {
+			break
+		}
+
+		if complete, err = file.Seek(0, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+
+	if discarded := info.Size() - complete; discarded > 0 {
+		return discarded, file.Truncate(complete)
+	}
+
+	return 0, nil
+}
@@ -15,23 +15,25 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 
+	"devt.de/common/errorutil"
 	"devt.de/common/sortutil"
 )
 
 /*
-Common storage file related errors. Having these global definitions
-makes the error comparison easier but has potential race-conditions.
-If two StorageFile objects throw an error at the same time both errors
-will appear to come from the same instance.
+Common storage file related errors. A StorageFile returns a new error value
+for every error which carries the name of the file and the details of the
+error. Errors can be compared with these values using errors.Is. All errors
+describe states of the storage file and are internal errors.
 */
 var (
-	ErrAlreadyInUse  = newStorageFileError("Record is already in-use")
-	ErrNotInUse      = newStorageFileError("Record was not in-use")
-	ErrInUse         = newStorageFileError("Records are still in-use")
-	ErrTransDisabled = newStorageFileError("Transactions are disabled")
-	ErrInTrans       = newStorageFileError("Records are still in a transaction")
-	ErrNilData       = newStorageFileError("Record has nil data")
+	ErrAlreadyInUse  = newStorageFileError("Record is already in-use", errorutil.ErrInternal)
+	ErrNotInUse      = newStorageFileError("Record was not in-use", errorutil.ErrInternal)
+	ErrInUse         = newStorageFileError("Records are still in-use", errorutil.ErrInternal)
+	ErrTransDisabled = newStorageFileError("Transactions are disabled", errorutil.ErrInternal)
+	ErrInTrans       = newStorageFileError("Records are still in a transaction", errorutil.ErrInternal)
+	ErrNilData       = newStorageFileError("Record has nil data", errorutil.ErrInternal)
 )
 
 /*
@@ -44,6 +46,18 @@ DefaultFileSize is the default size of a physical file (10GB)
 */
 const DefaultFileSize = 0x2540BE401 // 10000000001 Bytes
 
+/*
+MaxPrefetchedRecords is the maximum number of records which are held in
+memory after being read ahead
+*/
+const MaxPrefetchedRecords = 256
+
+/*
+ReadAheadThreshold is the number of consecutive records which have to be read
+from disk before the following records are read ahead in one large read
+*/
+const ReadAheadThreshold = 3
+
 /*
 StorageFile data structure
 */
@@ -61,6 +75,19 @@ type StorageFile struct {
 	files []*os.File // List of storage files
 
 	tm *TransactionManager // Manager object for transactions
+
+	flushHook  func(*Record)       // Hook which is called for every record before it is flushed
+	accessHook func(*Record, bool) // Hook which is called for every record which is requested
+
+	prefetched   map[uint64][]byte // Record data which has been read ahead from disk
+	prefetchLock *sync.Mutex       // Mutex to protect the prefetched data and file handles
+	prefetchWg   *sync.WaitGroup   // Waitgroup for running read ahead operations
+
+	readAhead  int    // Number of records which are read ahead during sequential scans (0 disables)
+	lastRead   uint64 // Id of the last record which was read from disk
+	sequential int    // Number of consecutive records which were read from disk
+
+	modified map[uint64]bool // Records which were flushed since the last backup
 }
 
 /*
@@ -79,7 +106,9 @@ func NewStorageFile(name string, recordSize uint32, transDisabled bool) (*Storag
 
 	ret := &StorageFile{name, transDisabled, recordSize, maxFileSize,
 		make(map[uint64]*Record), make(map[uint64]*Record), make(map[uint64]*Record),
-		make(map[uint64]*Record), make([]*os.File, 0), nil}
+		make(map[uint64]*Record), make([]*os.File, 0), nil, nil, nil,
+		make(map[uint64][]byte), &sync.Mutex{}, &sync.WaitGroup{}, 0, 0, 0,
+		make(map[uint64]bool)}
 
 	if !transDisabled {
 		tm, err := NewTransactionManager(ret, true)
@@ -123,22 +152,13 @@ func (s *StorageFile) Get(id uint64) (*Record, error) {
 
 	// Check if the record is in one of the caches
 
-	if record, ok := s.inTrans[id]; ok {
-		delete(s.inTrans, id)
-		s.inUse[id] = record
-		return record, nil
-	}
-
-	if record, ok := s.dirty[id]; ok {
-		delete(s.dirty, id)
-		s.inUse[id] = record
-		return record, nil
-	}
-
-	if record, ok := s.free[id]; ok {
-		delete(s.free, id)
-		s.inUse[id] = record
-		return record, nil
+	for _, cache := range []map[uint64]*Record{s.inTrans, s.dirty, s.free} {
+		if record, ok := cache[id]; ok {
+			delete(cache, id)
+			s.inUse[id] = record
+			s.recordAccess(record, true)
+			return record, nil
+		}
 	}
 
 	// Error if a record which is in-use is requested again before it is released.
@@ -156,12 +176,23 @@ func (s *StorageFile) Get(id uint64) (*Record, error) {
 	}
 
 	s.inUse[id] = record
+	s.recordAccess(record, false)
 
 	return record, nil
 }
 
 /*
-getFile gets a physical file for a specific offset.
+recordAccess calls the access hook for a requested record.
+*/
+func (s *StorageFile) recordAccess(record *Record, hit bool) {
+	if s.accessHook != nil {
+		s.accessHook(record, hit)
+	}
+}
+
+/*
+getFile gets a physical file for a specific offset. The prefetch lock must
+be held if read ahead operations might be running.
 */
 func (s *StorageFile) getFile(offset uint64) (*os.File, error) {
 
@@ -238,6 +269,13 @@ func (s *StorageFile) writeRecord(record *Record) error {
 
 		offset := record.ID() * uint64(s.recordSize)
 
+		s.prefetchLock.Lock()
+		defer s.prefetchLock.Unlock()
+
+		// Prefetched data of the record is now outdated
+
+		delete(s.prefetched, record.ID())
+
 		file, err := s.getFile(offset)
 		if err != nil {
 			return err
@@ -262,11 +300,38 @@ func (s *StorageFile) readRecord(record *Record) error {
 
 	offset := record.ID() * uint64(s.recordSize)
 
+	s.prefetchLock.Lock()
+	defer s.prefetchLock.Unlock()
+
+	// Use prefetched data if available
+
+	if data, ok := s.prefetched[record.ID()]; ok {
+		copy(record.Data(), data)
+		delete(s.prefetched, record.ID())
+		return nil
+	}
+
 	file, err := s.getFile(offset)
 	if err != nil {
 		return err
 	}
 
+	// Read the following records in one go if the file is scanned
+
+	if s.readAhead > 0 {
+		if record.ID() == s.lastRead+1 {
+			s.sequential++
+		} else {
+			s.sequential = 1
+		}
+
+		s.lastRead = record.ID()
+
+		if s.sequential >= ReadAheadThreshold {
+			s.readAheadRecords(file, record.ID()+1, offset+uint64(s.recordSize))
+		}
+	}
+
 	n, err := file.ReadAt(record.Data(), int64(offset%s.maxFileSize))
 
 	if n > 0 && uint32(n) != s.recordSize {
@@ -285,6 +350,149 @@ func (s *StorageFile) readRecord(record *Record) error {
 	return err
 }
 
+/*
+SetReadAhead sets the number of records which are read ahead in one large read
+once a sequential scan is detected. A scan is detected if ReadAheadThreshold
+consecutive records were read from disk. A value of 0 disables the read ahead.
+*/
+func (s *StorageFile) SetReadAhead(n int) {
+	s.prefetchLock.Lock()
+	defer s.prefetchLock.Unlock()
+
+	s.readAhead = n
+	s.sequential = 0
+}
+
+/*
+Prefetch reads up to n records from disk in the background starting with
+the given record. The next function is called with the data of each record
+and should return the id of the following record or 0 to stop. Read ahead
+records are used by subsequent Get calls.
+*/
+func (s *StorageFile) Prefetch(id uint64, n int, next func(data []byte) uint64) {
+
+	// Skip all records which are already in memory
+
+	for ; id != 0 && n > 0; n-- {
+		record, ok := s.inUse[id]
+		if !ok {
+			if record, ok = s.free[id]; !ok {
+				if record, ok = s.dirty[id]; !ok {
+					record, ok = s.inTrans[id]
+				}
+			}
+		}
+
+		if !ok {
+			break
+		}
+
+		id = next(record.Data())
+	}
+
+	if id == 0 || n == 0 {
+		return
+	}
+
+	s.prefetchWg.Add(1)
+
+	go func() {
+		defer s.prefetchWg.Done()
+
+		for ; id != 0 && n > 0; n-- {
+			data, ok := s.prefetchRecord(id)
+			if !ok {
+				return
+			}
+			id = next(data)
+		}
+	}()
+}
+
+/*
+readAheadRecords reads a number of records following a requested record with
+a single read into the prefetch cache. Records which are already in memory
+are not replaced. Assumes that the prefetch lock is held.
+*/
+func (s *StorageFile) readAheadRecords(file *os.File, id uint64, offset uint64) {
+	n := s.readAhead
+
+	if free := MaxPrefetchedRecords - len(s.prefetched); n > free {
+		n = free
+	}
+
+	// Do not read beyond the end of the physical file
+
+	if left := (s.maxFileSize - offset%s.maxFileSize) / uint64(s.recordSize); uint64(n) > left {
+		n = int(left)
+	}
+
+	if n <= 0 {
+		return
+	}
+
+	buf := make([]byte, n*int(s.recordSize))
+
+	read, _ := file.ReadAt(buf, int64(offset%s.maxFileSize))
+	records := read / int(s.recordSize)
+
+	for i := 0; i < records; i++ {
+		rid := id + uint64(i)
+
+		if _, ok := s.prefetched[rid]; !ok && !s.inMemory(rid) {
+			s.prefetched[rid] = buf[i*int(s.recordSize) : (i+1)*int(s.recordSize)]
+		}
+	}
+
+	// The scan continues after the records which were read ahead
+
+	s.lastRead += uint64(records)
+}
+
+/*
+inMemory checks if a record is held in memory.
+*/
+func (s *StorageFile) inMemory(id uint64) bool {
+	for _, cache := range []map[uint64]*Record{s.inUse, s.inTrans, s.dirty, s.free} {
+		if _, ok := cache[id]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+prefetchRecord reads the data of a single record into the prefetch cache.
+*/
+func (s *StorageFile) prefetchRecord(id uint64) ([]byte, bool) {
+	s.prefetchLock.Lock()
+	defer s.prefetchLock.Unlock()
+
+	if data, ok := s.prefetched[id]; ok {
+		return data, true
+	} else if len(s.prefetched) >= MaxPrefetchedRecords {
+		return nil, false
+	}
+
+	offset := id * uint64(s.recordSize)
+
+	file, err := s.getFile(offset)
+	if err != nil {
+		return nil, false
+	}
+
+	data := make([]byte, s.recordSize)
+
+	if n, _ := file.ReadAt(data, int64(offset%s.maxFileSize)); uint32(n) != s.recordSize {
+		return nil, false
+	}
+
+	s.prefetched[id] = data
+
+	return data, true
+}
+
 /*
 Discard a given record.
 */
@@ -364,6 +572,23 @@ func (s *StorageFile) ReleaseInUse(record *Record) {
 	}
 }
 
+/*
+SetFlushHook sets a function which is called for every dirty record before
+it is flushed. The hook may modify the record data.
+*/
+func (s *StorageFile) SetFlushHook(hook func(*Record)) {
+	s.flushHook = hook
+}
+
+/*
+SetAccessHook sets a hook function which is called for every record which is
+requested. The hit flag is true if the record was already held in memory and
+false if it had to be read from disk.
+*/
+func (s *StorageFile) SetAccessHook(hook func(record *Record, hit bool)) {
+	s.accessHook = hook
+}
+
 /*
 Flush commits the current transaction by flushing all dirty records to the
 transaction log on disk. If transactions are disabled it simply
@@ -378,11 +603,25 @@ func (s *StorageFile) Flush() error {
 		return nil
 	}
 
+	// Records are written in the order of their ids so the disk is accessed
+	// in a single sequential pass
+
+	ids := sortedRecordIDs(s.dirty)
+
+	if s.flushHook != nil {
+		for _, id := range ids {
+			s.flushHook(s.dirty[id])
+		}
+	}
+
 	if !s.transDisabled {
 		s.tm.start()
 	}
 
-	for id, record := range s.dirty {
+	for _, id := range ids {
+		record := s.dirty[id]
+
+		s.modified[id] = true
 
 		if s.transDisabled {
 			err := s.writeRecord(record)
@@ -405,6 +644,53 @@ func (s *StorageFile) Flush() error {
 	return nil
 }
 
+/*
+ModifiedRecords returns the ids of all records which were flushed since the
+file was opened or since ResetModified was called. The ids are sorted in
+ascending order.
+*/
+func (s *StorageFile) ModifiedRecords() []uint64 {
+	keys := make([]uint64, 0, len(s.modified))
+
+	for k := range s.modified {
+		keys = append(keys, k)
+	}
+
+	sortutil.UInt64s(keys)
+
+	return keys
+}
+
+/*
+RecordData returns a copy of the current data of a record. Unlike Get this
+function does not change the state of the record - it can also be used for
+records which are in-use.
+*/
+func (s *StorageFile) RecordData(id uint64) ([]byte, error) {
+
+	for _, cache := range []map[uint64]*Record{s.inUse, s.inTrans, s.dirty, s.free} {
+		if record, ok := cache[id]; ok {
+			return append([]byte(nil), record.Data()...), nil
+		}
+	}
+
+	record := NewRecord(id, make([]byte, s.recordSize, s.recordSize))
+
+	if err := s.readRecord(record); err != nil {
+		return nil, err
+	}
+
+	return record.Data(), nil
+}
+
+/*
+ResetModified forgets all records which were flushed so far. It should be
+called once a backup of the file has been written.
+*/
+func (s *StorageFile) ResetModified() {
+	s.modified = make(map[uint64]bool)
+}
+
 /*
 Rollback cancels the current transaction by discarding all dirty records.
 */
@@ -431,6 +717,92 @@ func (s *StorageFile) Rollback() error {
 	return nil
 }
 
+/*
+Truncate removes all records starting from a given record id from disk. The
+current transaction is committed and the transaction log is written to disk
+before the physical files are truncated. Truncate fails if any records are
+still in-use.
+*/
+func (s *StorageFile) Truncate(id uint64) error {
+
+	if err := s.Flush(); err != nil {
+		return err
+	}
+
+	// Make sure that no removed record can be restored from the transaction log
+
+	if !s.transDisabled {
+		if err := s.tm.syncLogFromMemory(); err != nil {
+			return err
+		}
+	}
+
+	// Wait for all read ahead operations to finish
+
+	s.prefetchWg.Wait()
+
+	s.prefetchLock.Lock()
+	defer s.prefetchLock.Unlock()
+
+	for rid := range s.prefetched {
+		if rid >= id {
+			delete(s.prefetched, rid)
+		}
+	}
+
+	for rid := range s.free {
+		if rid >= id {
+			delete(s.free, rid)
+		}
+	}
+
+	size := id * uint64(s.recordSize)
+
+	for i := 0; ; i++ {
+		offset := uint64(i) * s.maxFileSize
+
+		if offset+s.maxFileSize <= size {
+			continue
+		}
+
+		filename := fmt.Sprintf("%s.%d", s.name, i)
+
+		info, err := os.Stat(filename)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if i > 0 && offset >= size {
+
+			// Remove files which are no longer needed
+
+			if i < len(s.files) && s.files[i] != nil {
+				s.files[i].Close()
+				s.files[i] = nil
+			}
+
+			if err := os.Remove(filename); err != nil {
+				return err
+			}
+
+		} else if uint64(info.Size()) > size-offset {
+
+			file, err := s.getFile(offset)
+			if err != nil {
+				return err
+			}
+
+			if err := file.Truncate(int64(size - offset)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 /*
 Sync syncs all physical files.
 */
@@ -448,6 +820,10 @@ Close commits all data and closes all physical files.
 */
 func (s *StorageFile) Close() error {
 
+	// Wait for all read ahead operations to finish
+
+	s.prefetchWg.Wait()
+
 	if len(s.dirty) > 0 {
 		if err := s.Flush(); err != nil {
 			return err
@@ -477,6 +853,7 @@ func (s *StorageFile) Close() error {
 
 	s.free = make(map[uint64]*Record)
 	s.files = make([]*os.File, 0)
+	s.prefetched = make(map[uint64][]byte)
 
 	// If transactions are enabled then a StorageFile cannot be
 	// reused after it was closed.
@@ -486,6 +863,23 @@ func (s *StorageFile) Close() error {
 	return nil
 }
 
+/*
+MemoryUsage returns the number of bytes of record data which are held in
+memory. The first value is the size of cached, in-use, dirty and read ahead
+records. The second value is the size of records which are in the transaction
+log but not yet written to disk.
+*/
+func (s *StorageFile) MemoryUsage() (uint64, uint64) {
+	s.prefetchLock.Lock()
+	prefetched := len(s.prefetched)
+	s.prefetchLock.Unlock()
+
+	records := len(s.free) + len(s.inUse) + len(s.dirty) + prefetched
+
+	return uint64(records) * uint64(s.recordSize),
+		uint64(len(s.inTrans)) * uint64(s.recordSize)
+}
+
 /*
 String returns a string representation of a StorageFile.
 */
@@ -535,11 +929,7 @@ func printRecordIDMap(buf *bytes.Buffer, recordMap *map[uint64]*Record, name str
 	buf.WriteString(name)
 	buf.WriteString(" Records: ")
 
-	var keys []uint64
-	for k := range *recordMap {
-		keys = append(keys, k)
-	}
-	sortutil.UInt64s(keys)
+	keys := sortedRecordIDs(*recordMap)
 
 	l := len(*recordMap)
 
@@ -551,11 +941,26 @@ func printRecordIDMap(buf *bytes.Buffer, recordMap *map[uint64]*Record, name str
 	}
 }
 
+/*
+sortedRecordIDs returns the ids of a record map in ascending order.
+*/
+func sortedRecordIDs(recordMap map[uint64]*Record) []uint64 {
+	keys := make([]uint64, 0, len(recordMap))
+
+	for k := range recordMap {
+		keys = append(keys, k)
+	}
+
+	sortutil.UInt64s(keys)
+
+	return keys
+}
+
 /*
 newStorageFileError returns a new StorageFile specific error.
 */
-func newStorageFileError(text string) *storagefileError {
-	return &storagefileError{text, "?", ""}
+func newStorageFileError(text string, category error) *storagefileError {
+	return &storagefileError{text, category, "?", "", nil}
 }
 
 /*
@@ -563,17 +968,24 @@ StorageFile specific error datastructure
 */
 type storagefileError struct {
 	msg      string
+	category error
 	filename string
 	info     string
+	base     *storagefileError // Common error of this error (nil for common errors)
 }
 
 /*
-fireError returns the error instance from a specific StorageFile instance.
+fireError returns a new error value for a specific StorageFile instance.
 */
 func (e *storagefileError) fireError(s *StorageFile, info string) error {
-	e.filename = s.name
-	e.info = info
-	return e
+	return &storagefileError{e.msg, e.category, s.name, info, e}
+}
+
+/*
+Is checks if this error was created from a given common error.
+*/
+func (e *storagefileError) Is(target error) bool {
+	return e.base != nil && e.base == target
 }
 
 /*
@@ -582,3 +994,10 @@ Error returns a string representation of the error.
 func (e *storagefileError) Error() string {
 	return fmt.Sprintf("%s (%s - %s)", e.msg, e.filename, e.info)
 }
+
+/*
+Category returns the category of the error.
+*/
+func (e *storagefileError) Category() error {
+	return e.category
+}
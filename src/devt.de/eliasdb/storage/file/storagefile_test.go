@@ -11,9 +11,12 @@
 package file
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"sync"
 	"testing"
 
 	"devt.de/common/fileutil"
@@ -95,7 +98,8 @@ func TestStorageFileInitialisation(t *testing.T) {
 
 func TestGetFile(t *testing.T) {
 	sf := &StorageFile{DBDir + "/test2", true, 10, 10, nil, nil, nil, nil,
-		make([]*os.File, 0), nil}
+		make([]*os.File, 0), nil, nil, nil, make(map[uint64][]byte), &sync.Mutex{},
+		&sync.WaitGroup{}, 0, 0, 0, make(map[uint64]bool)}
 	defer sf.Close()
 
 	file, err := sf.getFile(0)
@@ -246,7 +250,7 @@ func TestLowLevelReadWrite(t *testing.T) {
 
 	err = sf.readRecord(record)
 
-	if err != ErrNilData {
+	if !errors.Is(err, ErrNilData) {
 		t.Error("Nil pointer in record data should cause an error")
 		return
 	}
@@ -383,7 +387,7 @@ func TestHighLevelGetRelease(t *testing.T) {
 	checkMap(t, &sf.dirty, record3.ID(), false, "Record3", "dirty")
 	checkMap(t, &sf.inUse, record3.ID(), true, "Record3", "in use")
 
-	if err = sf.Flush(); err != ErrInUse {
+	if err = sf.Flush(); !errors.Is(err, ErrInUse) {
 		t.Error("StorageFile should complain about records being in use")
 	}
 
@@ -467,7 +471,7 @@ func TestHighLevelGetRelease(t *testing.T) {
 	}
 
 	// Test that requesting a record twice without releasing it causes an error.
-	if _, err = sf.Get(1); err != ErrAlreadyInUse {
+	if _, err = sf.Get(1); !errors.Is(err, ErrAlreadyInUse) {
 		t.Error("Requesting a record which is already in use should cause an error")
 	}
 
@@ -514,7 +518,7 @@ func TestHighLevelGetRelease(t *testing.T) {
 	// An attempt to close the file should return an error
 
 	err = sf.Close()
-	if err != ErrInUse {
+	if !errors.Is(err, ErrInUse) {
 		t.Error("Attempting to close a StorageFile with records in use should " +
 			"return an error")
 		return
@@ -571,13 +575,13 @@ func TestFlushingClosing(t *testing.T) {
 	}
 	record.WriteSingleByte(0, 0)
 
-	if sf.Flush() != ErrInUse {
+	if !errors.Is(sf.Flush(), ErrInUse) {
 		t.Error("Flushing should not be allowed while records are in use")
 		return
 	}
 
 	sf.ReleaseInUse(nil) // This should not cause a panic
-	if sf.ReleaseInUseID(5000, true) != ErrNotInUse {
+	if !errors.Is(sf.ReleaseInUseID(5000, true), ErrNotInUse) {
 		t.Error("It should not be possible to release records which are not in use")
 		return
 	}
@@ -596,7 +600,7 @@ func TestFlushingClosing(t *testing.T) {
 	record.data = nil
 
 	err = sf.Flush()
-	if err != ErrNilData {
+	if !errors.Is(err, ErrNilData) {
 		t.Error("It should not be possible to flush a record with an invalid id to disk")
 		return
 	}
@@ -638,7 +642,7 @@ func TestFlushingClosing(t *testing.T) {
 	recordData := record.data
 	record.data = nil
 
-	if sf.Close() != ErrNilData {
+	if !errors.Is(sf.Close(), ErrNilData) {
 		t.Error("Closing with a dirty record with negative id should not be possible", err)
 		return
 	}
@@ -665,3 +669,458 @@ func testReleasePanic(t *testing.T, sf *StorageFile, r *Record) {
 	}()
 	sf.ReleaseInUse(r)
 }
+
+func TestPrefetch(t *testing.T) {
+	sf, err := NewDefaultStorageFile(DBDir+"/test6", true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// Build a chain of records 1 -> 2 -> ... -> 10
+
+	for i := uint64(1); i <= 10; i++ {
+		record, _ := sf.Get(i)
+		record.WriteUInt64(0, (i+1)%11)
+		record.WriteUInt64(8, i*100)
+		sf.ReleaseInUse(record)
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sf, err = NewDefaultStorageFile(DBDir+"/test6", true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	next := func(data []byte) uint64 {
+		return NewRecord(0, data).ReadUInt64(0)
+	}
+
+	record, _ := sf.Get(1)
+	sf.ReleaseInUse(record)
+
+	// Records which are in memory are skipped
+
+	sf.Prefetch(1, 5, next)
+	sf.prefetchWg.Wait()
+
+	if len(sf.prefetched) != 4 {
+		t.Error("Unexpected prefetched records:", len(sf.prefetched))
+		return
+	}
+
+	if _, ok := sf.prefetched[5]; !ok {
+		t.Error("Record 5 should have been read ahead")
+		return
+	}
+
+	record, _ = sf.Get(3)
+
+	if record.ReadUInt64(8) != 300 || record.ReadUInt64(0) != 4 {
+		t.Error("Unexpected record:", record)
+		return
+	}
+
+	if _, ok := sf.prefetched[3]; ok {
+		t.Error("Record 3 should no longer be read ahead")
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	// Writing a record discards read ahead data
+
+	record, _ = sf.Get(4)
+	record.WriteUInt64(8, 401)
+	sf.ReleaseInUse(record)
+
+	if err := sf.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, ok := sf.prefetched[4]; ok {
+		t.Error("Record 4 should no longer be read ahead")
+		return
+	}
+
+	sf.Prefetch(6, 10, next)
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(sf.prefetched) != 0 {
+		t.Error("Unexpected prefetched records:", len(sf.prefetched))
+		return
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	sf, err := NewStorageFile(DBDir+"/test7", 10, false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if cached, trans := sf.MemoryUsage(); cached != 0 || trans != 0 {
+		t.Error("Unexpected memory usage:", cached, trans)
+		return
+	}
+
+	for i := uint64(1); i <= 3; i++ {
+		record, _ := sf.Get(i)
+		record.WriteSingleByte(0, byte(i))
+		sf.ReleaseInUse(record)
+	}
+
+	record, _ := sf.Get(4)
+
+	if cached, trans := sf.MemoryUsage(); cached != 40 || trans != 0 {
+		t.Error("Unexpected memory usage:", cached, trans)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	if err := sf.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Flushed records are held in memory until the transaction log is
+	// written to disk
+
+	if cached, trans := sf.MemoryUsage(); cached != 10 || trans != 30 {
+		t.Error("Unexpected memory usage:", cached, trans)
+		return
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestAccessHook(t *testing.T) {
+	sf, err := NewDefaultStorageFile(DBDir+"/test8", true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	var accesses []string
+
+	sf.SetAccessHook(func(record *Record, hit bool) {
+		accesses = append(accesses, fmt.Sprint(record.ID(), ":", hit))
+	})
+
+	for _, id := range []uint64{1, 1, 2} {
+		record, _ := sf.Get(id)
+		sf.ReleaseInUse(record)
+	}
+
+	if res := fmt.Sprint(accesses); res != "[1:false 1:true 2:false]" {
+		t.Error("Unexpected accesses:", res)
+		return
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	sf, err := NewStorageFile(DBDir+"/test9", 10, false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// Store 3 records in each physical file
+
+	sf.maxFileSize = 30
+
+	for i := uint64(1); i <= 7; i++ {
+		record, _ := sf.Get(i)
+		record.WriteSingleByte(0, byte(i))
+		sf.ReleaseInUseID(i, true)
+	}
+
+	record, _ := sf.Get(1)
+
+	if err := sf.Truncate(4); !errors.Is(err, ErrInUse) {
+		t.Error("Unexpected truncate result:", err)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	if err := sf.Truncate(4); err != nil {
+		t.Error(err)
+		return
+	}
+
+	checkFileSize := func(name string, size int64) {
+		info, err := os.Stat(DBDir + "/" + name)
+		if size < 0 {
+			if !os.IsNotExist(err) {
+				t.Error("File should not exist:", name)
+			}
+		} else if err != nil || info.Size() != size {
+			t.Error("Unexpected file size:", name, info, err)
+		}
+	}
+
+	checkFileSize("test9.0", 30)
+	checkFileSize("test9.1", 10)
+	checkFileSize("test9.2", -1)
+
+	// Remaining records are still available - removed records are empty
+
+	record, _ = sf.Get(3)
+	if res := record.ReadSingleByte(0); res != 3 {
+		t.Error("Unexpected record data:", res)
+		return
+	}
+	sf.ReleaseInUse(record)
+
+	record, _ = sf.Get(6)
+	if res := record.ReadSingleByte(0); res != 0 {
+		t.Error("Unexpected record data:", res)
+		return
+	}
+	sf.ReleaseInUse(record)
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Removed records must not be restored from the transaction log
+
+	sf, err = NewStorageFile(DBDir+"/test9", 10, false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	checkFileSize("test9.0", 30)
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestFlushOrder(t *testing.T) {
+	sf, err := NewStorageFile(DBDir+"/test10", 10, true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	var flushed []uint64
+
+	sf.SetFlushHook(func(record *Record) {
+		flushed = append(flushed, record.ID())
+	})
+
+	for _, id := range []uint64{5, 3, 9, 1, 7} {
+		record, _ := sf.Get(id)
+		record.WriteSingleByte(0, byte(id))
+		sf.ReleaseInUseID(id, true)
+	}
+
+	if err := sf.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res := fmt.Sprint(flushed); res != "[1 3 5 7 9]" {
+		t.Error("Records should be flushed in order:", res)
+		return
+	}
+
+	if res := fmt.Sprint(sortedRecordIDs(sf.free)); res != "[1 3 5 7 9]" {
+		t.Error("Unexpected free records:", res)
+		return
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestReadAhead(t *testing.T) {
+	sf, err := NewStorageFile(DBDir+"/test11", 10, true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for i := uint64(1); i <= 20; i++ {
+		record, _ := sf.Get(i)
+		record.WriteSingleByte(0, byte(i))
+		sf.ReleaseInUseID(i, true)
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sf, err = NewStorageFile(DBDir+"/test11", 10, true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	sf.SetReadAhead(5)
+
+	// Records which are already in memory are not read ahead
+
+	record6, _ := sf.Get(6)
+
+	for i := uint64(1); i <= 3; i++ {
+		record, _ := sf.Get(i)
+		sf.ReleaseInUse(record)
+	}
+
+	if res := fmt.Sprint(sortedPrefetchedIDs(sf)); res != "[4 5 7 8]" {
+		t.Error("Unexpected prefetched records:", res)
+		return
+	}
+
+	sf.ReleaseInUse(record6)
+
+	// Read ahead records are used and the scan continues after them
+
+	for _, i := range []uint64{4, 5, 7, 8, 9} {
+		record, _ := sf.Get(i)
+
+		if record.ReadSingleByte(0) != byte(i) {
+			t.Error("Unexpected record:", record)
+			return
+		}
+
+		sf.ReleaseInUse(record)
+	}
+
+	if res := fmt.Sprint(sortedPrefetchedIDs(sf)); res != "[10 11 12 13 14]" {
+		t.Error("Unexpected prefetched records:", res)
+		return
+	}
+
+	// Random reads do not trigger a read ahead
+
+	sf.prefetched = make(map[uint64][]byte)
+
+	for _, i := range []uint64{18, 12, 19, 15} {
+		record, _ := sf.Get(i)
+		sf.ReleaseInUse(record)
+	}
+
+	if len(sf.prefetched) != 0 {
+		t.Error("Unexpected prefetched records:", len(sf.prefetched))
+		return
+	}
+
+	// Records beyond the end of the file are not read ahead
+
+	for i := uint64(16); i <= 17; i++ {
+		record, _ := sf.Get(i)
+		sf.ReleaseInUse(record)
+	}
+
+	if ids := sortedPrefetchedIDs(sf); len(ids) == 0 || ids[len(ids)-1] != 20 {
+		t.Error("Unexpected prefetched records:", ids)
+		return
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestModifiedRecords(t *testing.T) {
+	sf, err := NewStorageFile(DBDir+"/test12", 10, false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for _, i := range []uint64{5, 2, 7} {
+		record, _ := sf.Get(i)
+		record.WriteSingleByte(0, byte(i))
+		sf.ReleaseInUseID(i, true)
+	}
+
+	// Only flushed records are reported
+
+	if ids := sf.ModifiedRecords(); len(ids) != 0 {
+		t.Error("Unexpected modified records:", ids)
+		return
+	}
+
+	sf.Flush()
+
+	if ids := fmt.Sprint(sf.ModifiedRecords()); ids != "[2 5 7]" {
+		t.Error("Unexpected modified records:", ids)
+		return
+	}
+
+	// Record data can be read while a record is in-use
+
+	record, _ := sf.Get(5)
+
+	if data, err := sf.RecordData(5); err != nil || data[0] != 5 {
+		t.Error("Unexpected result:", data, err)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	if data, err := sf.RecordData(99); err != nil || len(data) != 10 || data[0] != 0 {
+		t.Error("Unexpected result:", data, err)
+		return
+	}
+
+	sf.ResetModified()
+
+	if ids := sf.ModifiedRecords(); len(ids) != 0 {
+		t.Error("Unexpected modified records:", ids)
+		return
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+/*
+sortedPrefetchedIDs returns the sorted ids of all read ahead records.
+*/
+func sortedPrefetchedIDs(sf *StorageFile) []uint64 {
+	var ids []uint64
+
+	for id := range sf.prefetched {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids
+}
@@ -13,16 +13,19 @@ package file
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+
+	"devt.de/common/errorutil"
 )
 
 /*
 Common TransactionManager related errors
 */
 var (
-	ErrBadMagic = newStorageFileError("Bad magic for transaction log")
+	ErrBadMagic = newStorageFileError("Bad magic for transaction log", errorutil.ErrCorruption)
 )
 
 /*
@@ -107,8 +110,8 @@ func NewTransactionManager(owner *StorageFile, doRecover bool) (*TransactionMana
 		DefaultTransInLog, owner}
 
 	if doRecover {
-		err := ret.recover()
-		if err != nil && err != ErrBadMagic {
+		err := ret.recover(true)
+		if err != nil && !errors.Is(err, ErrBadMagic) {
 			return nil, err
 		}
 
@@ -123,8 +126,9 @@ func NewTransactionManager(owner *StorageFile, doRecover bool) (*TransactionMana
 
 /*
 recover tries to recover pending transactions from the physical transaction log.
+The progress of the recovery is reported if the report flag is set.
 */
-func (t *TransactionManager) recover() error {
+func (t *TransactionManager) recover(report bool) error {
 	file, err := os.OpenFile(t.name, os.O_RDONLY, 0660)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -144,9 +148,19 @@ func (t *TransactionManager) recover() error {
 		return ErrBadMagic.fireError(t.owner, "")
 	}
 
+	var reader io.Reader = file
+	var tracker *recoveryTracker
+
+	if info, err := file.Stat(); report && err == nil && info.Size() > int64(i) {
+		tracker = newRecoveryTracker(t.name, file, int64(i), info.Size())
+		reader = tracker
+
+		defer tracker.finish()
+	}
+
 	for true {
 		var numRecords int64
-		if err := binary.Read(file, binary.LittleEndian, &numRecords); err != nil {
+		if err := binary.Read(reader, binary.LittleEndian, &numRecords); err != nil {
 			if err == io.EOF {
 				break
 			}
@@ -156,7 +170,7 @@ func (t *TransactionManager) recover() error {
 		recMap := make(map[uint64]*Record)
 
 		for i := int64(0); i < numRecords; i++ {
-			record, err := ReadRecord(file)
+			record, err := ReadRecord(reader)
 			if err != nil {
 				return err
 			}
@@ -170,6 +184,10 @@ func (t *TransactionManager) recover() error {
 		// If something goes wrong here ignore and try to do the rest
 
 		t.syncRecords(recMap, false)
+
+		if tracker != nil {
+			tracker.replayed()
+		}
 	}
 
 	return nil
@@ -330,7 +348,7 @@ func (t *TransactionManager) syncLogFromDisk() error {
 		t.transList[i] = nil
 	}
 
-	if err := t.recover(); err != nil {
+	if err := t.recover(false); err != nil {
 		return err
 	}
 
@@ -338,10 +356,13 @@ func (t *TransactionManager) syncLogFromDisk() error {
 }
 
 /*
-syncRecords writes a list of records to the pysical disk file.
+syncRecords writes a list of records to the pysical disk file. Records are
+written in the order of their ids.
 */
 func (t *TransactionManager) syncRecords(records map[uint64]*Record, clearMemTransLog bool) error {
-	for _, record := range records {
+	for _, id := range sortedRecordIDs(records) {
+		record := records[id]
+
 		if err := t.owner.writeRecord(record); err != nil {
 			return err
 		}
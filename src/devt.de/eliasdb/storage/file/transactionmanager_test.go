@@ -11,9 +11,13 @@
 package file
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"devt.de/common/fileutil"
@@ -423,7 +427,7 @@ func TestRecover(t *testing.T) {
 
 	sf.transDisabled = true
 
-	if err = sf.Close(); err != ErrInTrans {
+	if err = sf.Close(); !errors.Is(err, ErrInTrans) {
 		t.Error(err)
 		return
 	}
@@ -443,12 +447,45 @@ func TestRecover(t *testing.T) {
 
 	// Open the StorageFile again and hope that recover() does the right thing
 
+	var recoveryLog []string
+	var recoveryStatus []RecoveryProgress
+
+	oldLogRecovery, oldRecoveryLogInterval := LogRecovery, RecoveryLogInterval
+	LogRecovery = func(v ...interface{}) {
+		recoveryLog = append(recoveryLog, fmt.Sprint(v...))
+		recoveryStatus = append(recoveryStatus, RecoveryStatus()...)
+	}
+	RecoveryLogInterval = 0
+
+	defer func() {
+		LogRecovery, RecoveryLogInterval = oldLogRecovery, oldRecoveryLogInterval
+	}()
+
 	sf, err = NewDefaultStorageFile(DBDir+"/trans_test4", false)
 	if err != nil {
 		t.Error(err.Error())
 		return
 	}
 
+	if len(recoveryLog) != 3 ||
+		!strings.HasPrefix(recoveryLog[0], "Recovering transaction log storagefiletest/trans_test4.tlg (") ||
+		!strings.HasPrefix(recoveryLog[1], "Recovering storagefiletest/trans_test4.tlg: 1 transactions replayed (100%") ||
+		!strings.HasPrefix(recoveryLog[2], "Recovered 1 transactions from storagefiletest/trans_test4.tlg in ") {
+		t.Error("Unexpected recovery log:", recoveryLog)
+		return
+	}
+
+	if len(recoveryStatus) != 2 || recoveryStatus[1].Transactions != 1 ||
+		recoveryStatus[1].BytesRead != recoveryStatus[1].BytesTotal {
+		t.Error("Unexpected recovery status:", recoveryStatus)
+		return
+	}
+
+	if res := RecoveryStatus(); len(res) != 0 {
+		t.Error("Unexpected recovery status:", res)
+		return
+	}
+
 	record, err = sf.Get(1)
 	if err != nil {
 		t.Error(err)
@@ -575,7 +612,7 @@ func TestRollbackFail(t *testing.T) {
 	}
 	record.WriteSingleByte(5, 0x42)
 
-	if err = sf.Rollback(); err != ErrInUse {
+	if err = sf.Rollback(); !errors.Is(err, ErrInUse) {
 		t.Error("It should not be possible to rollback while records are still in use")
 	}
 
@@ -599,7 +636,7 @@ func TestRollbackFail(t *testing.T) {
 	}
 
 	sf.inTrans[record.ID()] = record
-	if err = sf.Rollback(); err != ErrInTrans {
+	if err = sf.Rollback(); !errors.Is(err, ErrInTrans) {
 		t.Error("It should not be possible to rollback while records are still in transaction")
 		return
 	}
@@ -608,3 +645,81 @@ func TestRollbackFail(t *testing.T) {
 
 	sf.Close()
 }
+
+func TestRepairTransactionLog(t *testing.T) {
+	name := DBDir + "/trans_test7"
+
+	// Logs which do not exist or which have a bad magic are not touched
+
+	if res, err := RepairTransactionLog(name); res != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	sf, err := NewDefaultStorageFile(name, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err = sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Write a complete transaction and the beginning of a second one to
+	// simulate a crash while writing the log
+
+	logFile, err := os.OpenFile(name+"."+LogFileSuffix, os.O_APPEND|os.O_WRONLY, 0660)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	record := NewRecord(1, make([]byte, DefaultRecordSize))
+	record.WriteSingleByte(5, 0x42)
+
+	binary.Write(logFile, binary.LittleEndian, int64(1))
+	record.WriteRecord(logFile)
+
+	binary.Write(logFile, binary.LittleEndian, int64(2))
+	record.WriteRecord(logFile)
+	logFile.Write([]byte{0x01, 0x02, 0x03})
+	logFile.Close()
+
+	if res, err := RepairTransactionLog(name); res != 8+int64(25+DefaultRecordSize)+3 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if res, err := RepairTransactionLog(name); res != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// The complete transaction is recovered when the file is opened
+
+	sf, err = NewDefaultStorageFile(name, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	record, err = sf.Get(1)
+	if err != nil || record.ReadSingleByte(5) != 0x42 {
+		t.Error("Unexpected result:", record, err)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+	sf.Close()
+
+	logFile, _ = os.OpenFile(name+"."+LogFileSuffix, os.O_TRUNC|os.O_WRONLY, 0660)
+	logFile.WriteString("**")
+	logFile.Close()
+
+	if res, err := RepairTransactionLog(name); res != 0 || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+}
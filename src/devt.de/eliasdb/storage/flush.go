@@ -0,0 +1,75 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"runtime"
+	"sync"
+
+	"devt.de/common/errorutil"
+)
+
+/*
+FlushParallelism is the max number of files which are flushed concurrently.
+Files are flushed one after another if the value is 1.
+*/
+var FlushParallelism = runtime.NumCPU()
+
+/*
+FlushConcurrently calls the given flush functions concurrently. At most
+FlushParallelism functions run at the same time. Returns a composite error
+of all failed functions (in the order of the functions).
+*/
+func FlushConcurrently(funcs ...func() error) error {
+	errs := make([]error, len(funcs))
+
+	if FlushParallelism < 2 || len(funcs) < 2 {
+
+		for i, f := range funcs {
+			errs[i] = f()
+		}
+
+	} else {
+		var wg sync.WaitGroup
+
+		sem := make(chan bool, FlushParallelism)
+
+		for i, f := range funcs {
+			sem <- true
+			wg.Add(1)
+
+			go func(i int, f func() error) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				errs[i] = f()
+			}(i, f)
+		}
+
+		wg.Wait()
+	}
+
+	ce := errorutil.NewCompositeError()
+
+	for _, err := range errs {
+		if err != nil {
+			ce.Add(err)
+		}
+	}
+
+	if ce.HasErrors() {
+		return ce
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlushConcurrently(t *testing.T) {
+	var running, maxRunning, calls int32
+
+	oldParallelism := FlushParallelism
+	defer func() {
+		FlushParallelism = oldParallelism
+	}()
+
+	var funcs []func() error
+
+	for i := 0; i < 10; i++ {
+		i := i
+
+		funcs = append(funcs, func() error {
+			atomic.AddInt32(&calls, 1)
+
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+
+			for max := atomic.LoadInt32(&maxRunning); n > max; max = atomic.LoadInt32(&maxRunning) {
+				if atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+
+			if i%4 == 1 {
+				return errors.New(fmt.Sprint("error", i))
+			}
+
+			return nil
+		})
+	}
+
+	// Errors are returned in the order of the functions and every function
+	// is called once
+
+	FlushParallelism = 3
+
+	if err := FlushConcurrently(funcs...); err == nil || err.Error() != "error1; error5; error9" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if calls != 10 {
+		t.Error("Unexpected number of calls:", calls)
+		return
+	}
+
+	if maxRunning < 2 || maxRunning > 3 {
+		t.Error("Unexpected number of concurrent functions:", maxRunning)
+		return
+	}
+
+	// Functions are called one after another
+
+	maxRunning = 0
+	FlushParallelism = 1
+
+	if err := FlushConcurrently(funcs[:3]...); err == nil || err.Error() != "error1" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if maxRunning != 1 {
+		t.Error("Unexpected number of concurrent functions:", maxRunning)
+		return
+	}
+
+	if err := FlushConcurrently(); err != nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Storage files are flushed concurrently
+
+	FlushParallelism = 5
+
+	dsm := NewDiskStorageManager(DBDIR+"/test22", false, false, false, true)
+
+	loc, _ := dsm.Insert("test")
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm.Close()
+
+	dsm = NewDiskStorageManager(DBDIR+"/test22", false, false, false, true)
+
+	var res string
+
+	if err := dsm.Fetch(loc, &res); err != nil || res != "test" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	dsm.Close()
+}
@@ -13,6 +13,7 @@ package storage
 import (
 	"fmt"
 
+	"devt.de/common/errorutil"
 	"devt.de/common/pools"
 )
 
@@ -26,17 +27,26 @@ Common storage manager related errors. Having these global definitions
 makes the error comparison easier but has potential race-conditions.
 If two storage manager objects throw an error at the same time both errors
 will appear to come from the same instance.
+
+Missing slots and cache entries are internal errors - the storage locations
+are managed by the datastore itself and not requested by clients.
 */
 var (
-	ErrSlotNotFound = newStorageManagerError("Slot not found")
-	ErrNotInCache   = newStorageManagerError("No entry in cache")
+	ErrSlotNotFound = newStorageManagerError("Slot not found", errorutil.ErrInternal)
+	ErrNotInCache   = newStorageManagerError("No entry in cache", errorutil.ErrInternal)
+
+	ErrInvalidArchive  = newStorageManagerError("Invalid archive segment", errorutil.ErrInternal)
+	ErrInvalidDump     = newStorageManagerError("Invalid dump", errorutil.ErrInvalid)
+	ErrSnapshotClosed  = newStorageManagerError("Snapshot is closed", errorutil.ErrInternal)
+	ErrVersionNotFound = newStorageManagerError("Version not found", errorutil.ErrNotFound)
+	ErrQuotaExceeded   = newStorageManagerError("Quota exceeded", errorutil.ErrQuota)
 )
 
 /*
 newStorageManagerError returns a new StorageManager specific error.
 */
-func newStorageManagerError(text string) *storagemanagerError {
-	return &storagemanagerError{text, "?", ""}
+func newStorageManagerError(text string, category error) *storagemanagerError {
+	return &storagemanagerError{text, category, "?", ""}
 }
 
 /*
@@ -44,6 +54,7 @@ StorageManager specific error datastructure
 */
 type storagemanagerError struct {
 	msg      string
+	category error
 	filename string
 	info     string
 }
@@ -63,3 +74,10 @@ Error returns a string representation of the error.
 func (e *storagemanagerError) Error() string {
 	return fmt.Sprintf("%s (%s - %s)", e.msg, e.filename, e.info)
 }
+
+/*
+Category returns the category of the error.
+*/
+func (e *storagemanagerError) Category() error {
+	return e.category
+}
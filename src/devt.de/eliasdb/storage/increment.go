@@ -0,0 +1,280 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"devt.de/eliasdb/compress"
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/storage/file"
+)
+
+/*
+FileSuffixIncrement is the file ending for an incremental backup
+*/
+const FileSuffixIncrement = "inc"
+
+/*
+IncrementMagic is the magic number which starts an incremental backup
+*/
+var IncrementMagic = []byte{0x45, 0x49}
+
+/*
+incrementFile is a storage file which is part of an incremental backup.
+*/
+type incrementFile struct {
+	suffix     string            // File ending of the storage file
+	recordSize uint32            // Record size of the storage file
+	sf         *file.StorageFile // Storage file
+}
+
+/*
+incrementFiles returns all storage files of the storage manager.
+*/
+func (bdsm *ByteDiskStorageManager) incrementFiles() []incrementFile {
+	return []incrementFile{
+		{FileSuffixPhysicalSlots, BlockSizePhysicalSlots, bdsm.physicalSlotsSf},
+		{FileSuffixPhysicalFreeSlots, BlockSizeFreeSlots, bdsm.physicalFreeSlotsSf},
+		{FileSuffixBlobSlots, BlockSizePhysicalSlots, bdsm.blobSlotsSf},
+		{FileSuffixLogicalSlots, BlockSizeLogicalSlots, bdsm.logicalSlotsSf},
+		{FileSuffixLogicalFreeSlots, BlockSizeFreeSlots, bdsm.logicalFreeSlotsSf},
+	}
+}
+
+/*
+resetModified starts the tracking of modified records for the next
+incremental backup. It is assumed that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) resetModified() {
+	for _, f := range bdsm.incrementFiles() {
+		f.sf.ResetModified()
+	}
+}
+
+/*
+IncrementalBackup writes all records which were modified since the last backup
+(or since the storage manager was opened) into a compressed increment file.
+Pending changes are written before the increment is created. An increment can
+be applied to a copy of the data files with ApplyIncrement. The caller must make
+sure that the last backup was made after the storage manager was opened -
+records which were modified before are not tracked.
+*/
+func (bdsm *ByteDiskStorageManager) IncrementalBackup(targetFile string) error {
+	bdsm.checkFileOpen()
+
+	// Continue single threaded from here on
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "IncrementalBackup")
+	defer bdsm.mutex.Unlock()
+
+	if !bdsm.readonly {
+		if err := bdsm.flush(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(targetFile + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+
+	if _, err = w.Write(IncrementMagic); err == nil {
+		var zw io.WriteCloser
+
+		if zw, err = compress.NewWriter(w, ArchiveCodec, compress.DefaultLevel); err == nil {
+			for _, incf := range bdsm.incrementFiles() {
+				if err = writeIncrementFile(zw, incf); err != nil {
+					break
+				}
+			}
+
+			if cerr := zw.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+
+	if err == nil {
+		if err = w.Flush(); err == nil {
+			err = f.Sync()
+		}
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		if err = os.Rename(f.Name(), targetFile); err == nil {
+			bdsm.resetModified()
+		}
+	}
+
+	return err
+}
+
+/*
+writeIncrementFile writes all modified records of a storage file.
+*/
+func writeIncrementFile(w io.Writer, incf incrementFile) error {
+	ids := incf.sf.ModifiedRecords()
+
+	if err := writeIncrementHeader(w, incf.suffix, incf.recordSize, uint64(len(ids))); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		data, err := incf.sf.RecordData(id)
+
+		if err == nil {
+			if err = binary.Write(w, binary.LittleEndian, id); err == nil {
+				_, err = w.Write(data)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+writeIncrementHeader writes the header of a storage file in an increment.
+*/
+func writeIncrementHeader(w io.Writer, suffix string, recordSize uint32, count uint64) error {
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(suffix))); err != nil {
+		return err
+	}
+
+	if _, err := w.Write([]byte(suffix)); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, recordSize); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, count)
+}
+
+/*
+ApplyIncrement applies an increment file to the data files of a disk storage
+manager. The data files are usually a copy from a previous backup - the
+storage manager must not be open. Increments have to be applied in the order
+in which they were created.
+*/
+func ApplyIncrement(filename string, incFile string) error {
+
+	f, err := os.Open(incFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(IncrementMagic))
+
+	if _, err = io.ReadFull(r, magic); err != nil {
+		return err
+	} else if !bytes.Equal(magic, IncrementMagic) {
+		return fmt.Errorf("Invalid increment file: %v", incFile)
+	}
+
+	zr, err := compress.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for err == nil {
+		err = applyIncrementFile(zr, filename)
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+
+	return err
+}
+
+/*
+applyIncrementFile writes the records of a storage file from an increment.
+Returns io.EOF if the increment contains no further storage files.
+*/
+func applyIncrementFile(r io.Reader, filename string) error {
+	var suffixLen uint16
+	var recordSize uint32
+	var count uint64
+
+	if err := binary.Read(r, binary.LittleEndian, &suffixLen); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, suffixLen)
+
+	if _, err := io.ReadFull(r, suffix); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &recordSize); err != nil {
+		return err
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return err
+	}
+
+	sf, err := file.NewStorageFile(fmt.Sprintf("%v.%v", filename, string(suffix)), recordSize, false)
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < count && err == nil; i++ {
+		var id uint64
+		var record *file.Record
+
+		if err = binary.Read(r, binary.LittleEndian, &id); err == nil {
+			if record, err = sf.Get(id); err == nil {
+				if _, err = io.ReadFull(r, record.Data()); err == nil {
+					record.SetDirty()
+				}
+				sf.ReleaseInUse(record)
+			}
+		}
+
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+
+	if err == nil {
+		err = sf.Flush()
+	}
+
+	if cerr := sf.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,141 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+)
+
+/*
+FileSuffixMemory is the file ending for a saved MemoryStorageManager
+*/
+const FileSuffixMemory = "msm"
+
+/*
+memoryFileVersion is the version of the file format which is written by Save
+*/
+const memoryFileVersion = 1
+
+/*
+memoryFile is the content of a saved MemoryStorageManager.
+*/
+type memoryFile struct {
+	Version  int               // Version of the file format
+	Roots    map[int]uint64    // Root values
+	LocCount uint64            // Counter for locations
+	Records  map[uint64][]byte // Serialized objects
+}
+
+/*
+memoryRecord is a serialized object which was loaded from a file. The object
+is decoded when it is fetched. Loaded objects are not available via
+FetchCached until they are updated.
+*/
+type memoryRecord []byte
+
+/*
+Save writes all objects and root values of this storage manager to a file.
+Objects are serialized with gob - the same as objects of a disk storage
+manager. The file is replaced once it was written completely.
+*/
+func (msm *MemoryStorageManager) Save(filename string) error {
+	msm.mutex.Lock()
+	defer msm.mutex.Unlock()
+
+	mf := &memoryFile{memoryFileVersion, msm.Roots, msm.LocCount,
+		make(map[uint64][]byte, len(msm.Data))}
+
+	for loc, obj := range msm.Data {
+
+		if rec, ok := obj.(memoryRecord); ok {
+			mf.Records[loc] = rec
+			continue
+		}
+
+		var buf bytes.Buffer
+
+		if err := gob.NewEncoder(&buf).Encode(obj); err != nil {
+			return err
+		}
+
+		mf.Records[loc] = buf.Bytes()
+	}
+
+	f, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	if err = gob.NewEncoder(w).Encode(mf); err == nil {
+		if err = w.Flush(); err == nil {
+			err = f.Sync()
+		}
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+/*
+Load replaces all objects and root values of this storage manager with the
+content of a file which was written by Save.
+*/
+func (msm *MemoryStorageManager) Load(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	var mf memoryFile
+
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&mf); err != nil {
+		return fmt.Errorf("Could not load %v: %v", filename, err)
+	} else if mf.Version > memoryFileVersion {
+		return fmt.Errorf("Could not load %v: Unsupported version %v", filename, mf.Version)
+	}
+
+	msm.mutex.Lock()
+	defer msm.mutex.Unlock()
+
+	msm.Roots = make(map[int]uint64)
+	msm.Data = make(map[uint64]interface{}, len(mf.Records))
+
+	for root, val := range mf.Roots {
+		msm.Roots[root] = val
+	}
+
+	for loc, rec := range mf.Records {
+		msm.Data[loc] = memoryRecord(rec)
+	}
+
+	msm.LocCount = mf.LocCount
+
+	return nil
+}
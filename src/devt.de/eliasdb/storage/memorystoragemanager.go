@@ -12,6 +12,7 @@ package storage
 
 import (
 	"bytes"
+	"encoding/gob"
 	"fmt"
 	"sync"
 
@@ -171,7 +172,15 @@ func (msm *MemoryStorageManager) Fetch(loc uint64, o interface{}) error {
 	}
 
 	if obj, ok := msm.Data[loc]; ok {
-		err = datautil.CopyObject(obj, o)
+		if rec, ok := obj.(memoryRecord); ok {
+
+			// Decode an object which was loaded from a file
+
+			err = gob.NewDecoder(bytes.NewReader(rec)).Decode(o)
+
+		} else {
+			err = datautil.CopyObject(obj, o)
+		}
 	} else {
 		err = ErrSlotNotFound.fireError(msm, fmt.Sprint("Location:", loc))
 	}
@@ -191,7 +200,14 @@ func (msm *MemoryStorageManager) FetchCached(loc uint64) (interface{}, error) {
 	} else if msm.AccessMap[loc] == AccessCacheAndFetchSeriousError {
 		return nil, file.ErrAlreadyInUse
 	}
-	return msm.Data[loc], nil
+
+	obj := msm.Data[loc]
+
+	if _, ok := obj.(memoryRecord); ok {
+		return nil, ErrNotInCache
+	}
+
+	return obj, nil
 }
 
 /*
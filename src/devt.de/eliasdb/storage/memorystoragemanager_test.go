@@ -11,6 +11,9 @@
 package storage
 
 import (
+	"errors"
+	"io/ioutil"
+	"strings"
 	"testing"
 
 	"devt.de/eliasdb/storage/file"
@@ -84,12 +87,12 @@ func TestMemoryStorageManager(t *testing.T) {
 
 	msm.AccessMap[loc] = AccessCacheAndFetchSeriousError
 
-	if _, err := msm.FetchCached(loc); err != file.ErrAlreadyInUse {
+	if _, err := msm.FetchCached(loc); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected fetchcached result:", err)
 		return
 	}
 
-	if err := msm.Fetch(loc, &ret); err != file.ErrAlreadyInUse {
+	if err := msm.Fetch(loc, &ret); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected fetch result:", err)
 		return
 	}
@@ -117,7 +120,7 @@ func TestMemoryStorageManager(t *testing.T) {
 
 	msm.AccessMap[msm.LocCount] = AccessInsertError
 
-	if _, err := msm.Insert(""); err != file.ErrAlreadyInUse {
+	if _, err := msm.Insert(""); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected insert result:", err)
 		return
 	}
@@ -128,3 +131,103 @@ func TestMemoryStorageManager(t *testing.T) {
 	msm.Rollback()
 	msm.Close()
 }
+
+func TestMemoryStorageManagerSaveLoad(t *testing.T) {
+	var res string
+	var resMap map[string]string
+
+	filename := DBDIR + "/msmtest." + FileSuffixMemory
+
+	msm := NewMemoryStorageManager("test")
+
+	loc1, _ := msm.Insert("test1")
+	loc2, _ := msm.Insert(map[string]string{"a": "b"})
+	loc3, _ := msm.Insert("test3")
+
+	msm.Free(loc3)
+	msm.SetRoot(2, 42)
+
+	if err := msm.Save(filename); err != nil {
+		t.Error(err)
+		return
+	}
+
+	msm2 := NewMemoryStorageManager("test2")
+	msm2.Insert("other")
+
+	if err := msm2.Load(filename); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if msm2.Root(2) != 42 || msm2.LocCount != msm.LocCount || len(msm2.Data) != 2 {
+		t.Error("Unexpected state:", msm2.Roots, msm2.LocCount, msm2.Data)
+		return
+	}
+
+	// Loaded objects are decoded when they are fetched
+
+	if obj, err := msm2.FetchCached(loc1); obj != nil || err != ErrNotInCache {
+		t.Error("Unexpected result:", obj, err)
+		return
+	}
+
+	if err := msm2.Fetch(loc1, &res); err != nil || res != "test1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := msm2.Fetch(loc2, &resMap); err != nil || resMap["a"] != "b" {
+		t.Error("Unexpected result:", resMap, err)
+		return
+	}
+
+	if err := msm2.Fetch(loc3, &res); err != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Updated objects are available in the cache again
+
+	msm2.Update(loc1, "test4")
+
+	if obj, err := msm2.FetchCached(loc1); obj != "test4" || err != nil {
+		t.Error("Unexpected result:", obj, err)
+		return
+	}
+
+	// Loaded objects are saved as they are
+
+	msm2.Save(filename)
+
+	msm3 := NewMemoryStorageManager("test3")
+
+	if err := msm3.Load(filename); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := msm3.Fetch(loc2, &resMap); err != nil || resMap["a"] != "b" {
+		t.Error("Unexpected result:", resMap, err)
+		return
+	}
+
+	// Test error cases
+
+	if err := msm3.Load(DBDIR + "/msmtest.missing"); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	ioutil.WriteFile(filename, []byte("xx"), 0660)
+
+	if err := msm3.Load(filename); err == nil || !strings.HasPrefix(err.Error(), "Could not load") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := msm3.Save(DBDIR + "/missing/msmtest"); err == nil {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
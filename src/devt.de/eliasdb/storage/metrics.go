@@ -0,0 +1,181 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Operations of a disk storage manager which are recorded in the metrics
+*/
+const (
+	MetricFetch = iota
+	MetricInsert
+	MetricUpdate
+	MetricFree
+	MetricFlush
+	MetricRollback
+	numMetrics
+)
+
+/*
+MetricNames are the names of the recorded operations
+*/
+var MetricNames = []string{"fetch", "insert", "update", "free", "flush", "rollback"}
+
+/*
+MetricsVar is the name under which the metrics of all disk storage managers
+are published (the expvar handler serves them at /debug/vars)
+*/
+const MetricsVar = "storage"
+
+/*
+OperationMetrics contains the recorded metrics of a single operation.
+*/
+type OperationMetrics struct {
+	Count  uint64        // Number of operations
+	Errors uint64        // Number of failed operations
+	Total  time.Duration // Total time spent in the operation
+	Max    time.Duration // Longest single operation
+}
+
+/*
+storageMetrics holds the operation counters of a disk storage manager.
+*/
+type storageMetrics struct {
+	ops [numMetrics]struct {
+		count  uint64
+		errors uint64
+		total  int64
+		max    int64
+	}
+}
+
+/*
+metricsRegistry holds the metrics of all open disk storage managers
+*/
+var metricsRegistry = make(map[string]*storageMetrics)
+
+/*
+metricsLock protects the metrics registry
+*/
+var metricsLock = &sync.Mutex{}
+
+func init() {
+	expvar.Publish(MetricsVar, expvar.Func(func() interface{} {
+		return Metrics()
+	}))
+}
+
+/*
+registerMetrics creates the metrics of a disk storage manager.
+*/
+func registerMetrics(filename string) *storageMetrics {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	m := &storageMetrics{}
+	metricsRegistry[filename] = m
+
+	return m
+}
+
+/*
+unregisterMetrics removes the metrics of a disk storage manager.
+*/
+func unregisterMetrics(filename string, m *storageMetrics) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	if metricsRegistry[filename] == m {
+		delete(metricsRegistry, filename)
+	}
+}
+
+/*
+Metrics returns the recorded operation metrics of all open disk storage
+managers. The metrics are keyed by the filename of the storage manager and
+the name of the operation (see MetricNames).
+*/
+func Metrics() map[string]map[string]OperationMetrics {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	ret := make(map[string]map[string]OperationMetrics)
+
+	for filename, m := range metricsRegistry {
+		ret[filename] = m.snapshot()
+	}
+
+	return ret
+}
+
+/*
+Metrics returns the recorded operation metrics of this storage manager.
+*/
+func (bdsm *ByteDiskStorageManager) Metrics() map[string]OperationMetrics {
+	return bdsm.metrics.snapshot()
+}
+
+/*
+record records a finished operation. The error of the operation is given as
+a pointer so the function can be deferred.
+*/
+func (m *storageMetrics) record(op int, start time.Time, err *error) {
+
+	if m == nil {
+		return
+	}
+
+	d := int64(time.Since(start))
+	c := &m.ops[op]
+
+	atomic.AddUint64(&c.count, 1)
+	atomic.AddInt64(&c.total, d)
+
+	if err != nil && *err != nil {
+		atomic.AddUint64(&c.errors, 1)
+	}
+
+	for max := atomic.LoadInt64(&c.max); d > max; max = atomic.LoadInt64(&c.max) {
+		if atomic.CompareAndSwapInt64(&c.max, max, d) {
+			break
+		}
+	}
+}
+
+/*
+snapshot returns the current values of all operation counters.
+*/
+func (m *storageMetrics) snapshot() map[string]OperationMetrics {
+	ret := make(map[string]OperationMetrics)
+
+	if m == nil {
+		return ret
+	}
+
+	for i, name := range MetricNames {
+		c := &m.ops[i]
+
+		ret[name] = OperationMetrics{
+			atomic.LoadUint64(&c.count),
+			atomic.LoadUint64(&c.errors),
+			time.Duration(atomic.LoadInt64(&c.total)),
+			time.Duration(atomic.LoadInt64(&c.max)),
+		}
+	}
+
+	return ret
+}
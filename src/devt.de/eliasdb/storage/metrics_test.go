@@ -0,0 +1,90 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"devt.de/eliasdb/storage/util"
+)
+
+func TestDiskStorageManagerMetrics(t *testing.T) {
+	filename := DBDIR + "/test19"
+
+	dsm := NewDiskStorageManager(filename, false, false, false, true)
+
+	loc, err := dsm.Insert("test1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm.Update(loc, "test2")
+
+	var res string
+	dsm.Fetch(loc, &res)
+
+	if err := dsm.Fetch(util.PackLocation(3, 18), &res); err != ErrSlotNotFound {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	dsm.Flush()
+	dsm.Free(loc)
+	dsm.Rollback()
+
+	m := dsm.Metrics()
+
+	for op, count := range map[string]uint64{"fetch": 2, "insert": 1, "update": 1,
+		"free": 1, "flush": 1, "rollback": 1} {
+
+		if m[op].Count != count {
+			t.Error("Unexpected count for", op, ":", m[op])
+			return
+		}
+	}
+
+	if m["fetch"].Errors != 1 || m["insert"].Errors != 0 {
+		t.Error("Unexpected error counts:", m)
+		return
+	}
+
+	if m["fetch"].Total < m["fetch"].Max || m["fetch"].Max <= 0 {
+		t.Error("Unexpected latencies:", m["fetch"])
+		return
+	}
+
+	// Check the published metrics
+
+	var published map[string]map[string]OperationMetrics
+
+	if err := json.Unmarshal([]byte(expvar.Get(MetricsVar).String()), &published); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if published[filename]["insert"].Count != 1 {
+		t.Error("Unexpected published metrics:", published[filename])
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, ok := Metrics()[filename]; ok {
+		t.Error("Metrics of a closed storage manager should be removed")
+		return
+	}
+}
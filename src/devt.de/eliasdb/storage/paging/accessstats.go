@@ -0,0 +1,109 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package paging
+
+import (
+	"time"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging/view"
+)
+
+/*
+PageAccessStats contains access statistics for the pages of a certain type.
+*/
+type PageAccessStats struct {
+	Hits      uint64  // Number of accesses to pages which were held in memory
+	Misses    uint64  // Number of accesses to pages which had to be read from disk
+	Frequency float64 // Accesses per second since the statistics were reset
+}
+
+/*
+Accesses returns the number of all page accesses.
+*/
+func (pas *PageAccessStats) Accesses() uint64 {
+	return pas.Hits + pas.Misses
+}
+
+/*
+HitRatio returns the ratio of accesses to pages which were held in memory.
+*/
+func (pas *PageAccessStats) HitRatio() float64 {
+	if pas.Accesses() == 0 {
+		return 0
+	}
+	return float64(pas.Hits) / float64(pas.Accesses())
+}
+
+/*
+AccessStats returns the access statistics for each page type which has been
+accessed since the statistics were last reset.
+*/
+func (psf *PagedStorageFile) AccessStats() map[int16]*PageAccessStats {
+	psf.accessLock.Lock()
+	defer psf.accessLock.Unlock()
+
+	ret := make(map[int16]*PageAccessStats)
+	elapsed := time.Since(psf.accessSince).Seconds()
+
+	for pagetype, stats := range psf.access {
+		s := *stats
+
+		if elapsed > 0 {
+			s.Frequency = float64(s.Accesses()) / elapsed
+		}
+
+		ret[pagetype] = &s
+	}
+
+	return ret
+}
+
+/*
+ResetAccessStats resets the access statistics of all page types.
+*/
+func (psf *PagedStorageFile) ResetAccessStats() {
+	psf.accessLock.Lock()
+	defer psf.accessLock.Unlock()
+
+	psf.access = make(map[int16]*PageAccessStats)
+	psf.accessSince = time.Now()
+}
+
+/*
+recordAccess records an access to a page record. Accesses to the header
+record and to records which do not hold a page yet are ignored.
+*/
+func (psf *PagedStorageFile) recordAccess(record *file.Record, hit bool) {
+	magic := view.PageMagic(record)
+
+	if record.ID() == 0 || magic < view.ViewPageHeader ||
+		magic > view.ViewPageHeader+view.TypeFreePhysicalSlotPage {
+		return
+	}
+
+	pagetype := magic - view.ViewPageHeader
+
+	psf.accessLock.Lock()
+	defer psf.accessLock.Unlock()
+
+	stats, ok := psf.access[pagetype]
+	if !ok {
+		stats = &PageAccessStats{}
+		psf.access[pagetype] = stats
+	}
+
+	if hit {
+		stats.Hits++
+	} else {
+		stats.Misses++
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package paging
+
+import (
+	"testing"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging/view"
+)
+
+func TestPagedStorageFileAccessStats(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test10", true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	psf.AllocatePage(view.TypeDataPage)
+	psf.AllocatePage(view.TypeDataPage)
+	psf.AllocatePage(view.TypeTranslationPage)
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sf, err = file.NewDefaultStorageFile(DBDIR+"/test10", true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err = NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats := psf.AccessStats(); len(stats) != 0 {
+		t.Error("Unexpected access stats:", stats)
+		return
+	}
+
+	// A page is a hit if its record is still held in memory. Released
+	// records are recycled when another page is read from disk.
+
+	for _, id := range []uint64{1, 1, 2, 3, 3} {
+		record, err := sf.Get(id)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sf.ReleaseInUse(record)
+	}
+
+	stats := psf.AccessStats()
+
+	if len(stats) != 2 {
+		t.Error("Unexpected access stats:", stats)
+		return
+	}
+
+	if ds := stats[view.TypeDataPage]; ds.Hits != 1 || ds.Misses != 2 ||
+		ds.Accesses() != 3 || ds.HitRatio() != float64(1)/3 || ds.Frequency <= 0 {
+		t.Error("Unexpected data page stats:", ds)
+		return
+	}
+
+	if ts := stats[view.TypeTranslationPage]; ts.Hits != 1 || ts.Misses != 1 {
+		t.Error("Unexpected translation page stats:", ts)
+		return
+	}
+
+	// Returned statistics are copies
+
+	stats[view.TypeDataPage].Hits = 100
+
+	if ds := psf.AccessStats()[view.TypeDataPage]; ds.Hits != 1 {
+		t.Error("Unexpected data page stats:", ds)
+		return
+	}
+
+	psf.ResetAccessStats()
+
+	if stats := psf.AccessStats(); len(stats) != 0 {
+		t.Error("Unexpected access stats:", stats)
+		return
+	}
+
+	if hr := (&PageAccessStats{}).HitRatio(); hr != 0 {
+		t.Error("Unexpected hit ratio:", hr)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package paging
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*
+pageLatch is the latch of a single page.
+*/
+type pageLatch struct {
+	lock *sync.RWMutex // Lock which is held by the owners of the latch
+	refs int           // Number of owners and waiters of this latch
+}
+
+/*
+PageLatches is a table of shared/exclusive latches for pages. Any number of
+shared latches can be held on a page at the same time while an exclusive
+latch excludes all other latches. Latches are created on demand and removed
+once they are no longer held.
+*/
+type PageLatches struct {
+	lock    *sync.Mutex           // Mutex to protect the latch table
+	latches map[uint64]*pageLatch // Latches which are currently used
+}
+
+/*
+NewPageLatches creates a new empty latch table.
+*/
+func NewPageLatches() *PageLatches {
+	return &PageLatches{&sync.Mutex{}, make(map[uint64]*pageLatch)}
+}
+
+/*
+LatchShared takes a shared latch on a given page. Blocks while the page is
+latched exclusively.
+*/
+func (pl *PageLatches) LatchShared(id uint64) {
+	pl.acquire(id).lock.RLock()
+}
+
+/*
+UnlatchShared releases a shared latch on a given page.
+*/
+func (pl *PageLatches) UnlatchShared(id uint64) {
+	pl.release(id).RUnlock()
+}
+
+/*
+LatchExclusive takes an exclusive latch on a given page. Blocks while the page
+is latched by anyone else.
+*/
+func (pl *PageLatches) LatchExclusive(id uint64) {
+	pl.acquire(id).lock.Lock()
+}
+
+/*
+UnlatchExclusive releases an exclusive latch on a given page.
+*/
+func (pl *PageLatches) UnlatchExclusive(id uint64) {
+	pl.release(id).Unlock()
+}
+
+/*
+Len returns the number of pages which are currently latched or waited for.
+*/
+func (pl *PageLatches) Len() int {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	return len(pl.latches)
+}
+
+/*
+acquire gets the latch of a given page and registers a new user.
+*/
+func (pl *PageLatches) acquire(id uint64) *pageLatch {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	latch, ok := pl.latches[id]
+	if !ok {
+		latch = &pageLatch{&sync.RWMutex{}, 0}
+		pl.latches[id] = latch
+	}
+
+	latch.refs++
+
+	return latch
+}
+
+/*
+release removes a user from the latch of a given page and returns the lock
+which should be unlocked. Panics if the page is not latched.
+*/
+func (pl *PageLatches) release(id uint64) *sync.RWMutex {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	latch, ok := pl.latches[id]
+	if !ok {
+		panic(fmt.Sprintf("Released page latch %d was not held", id))
+	}
+
+	if latch.refs--; latch.refs == 0 {
+		delete(pl.latches, id)
+	}
+
+	return latch.lock
+}
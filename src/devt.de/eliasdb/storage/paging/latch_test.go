@@ -0,0 +1,80 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package paging
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPageLatches(t *testing.T) {
+	pl := NewPageLatches()
+
+	// Shared latches can be held at the same time
+
+	pl.LatchShared(1)
+	pl.LatchShared(1)
+	pl.LatchShared(2)
+
+	if res := pl.Len(); res != 2 {
+		t.Error("Unexpected number of latches:", res)
+		return
+	}
+
+	// An exclusive latch waits for all shared latches
+
+	var order []string
+	var orderLock sync.Mutex
+
+	addOrder := func(s string) {
+		orderLock.Lock()
+		order = append(order, s)
+		orderLock.Unlock()
+	}
+
+	done := make(chan bool)
+
+	go func() {
+		pl.LatchExclusive(1)
+		addOrder("exclusive")
+		pl.UnlatchExclusive(1)
+		done <- true
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	addOrder("shared")
+	pl.UnlatchShared(1)
+	pl.UnlatchShared(1)
+
+	<-done
+
+	if len(order) != 2 || order[0] != "shared" || order[1] != "exclusive" {
+		t.Error("Unexpected latch order:", order)
+		return
+	}
+
+	pl.UnlatchShared(2)
+
+	if res := pl.Len(); res != 0 {
+		t.Error("Unexpected number of latches:", res)
+		return
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Releasing a latch which is not held should cause a panic")
+		}
+	}()
+
+	pl.UnlatchExclusive(5)
+}
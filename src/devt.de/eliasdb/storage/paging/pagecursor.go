@@ -30,23 +30,46 @@ PagedStorageFileHeader
 
 PagedStorageFileHeader is a wrapper object for the header record of a StorageFile.
 The header record stores information about linked lists and root values.
+
+PageLatches
+
+PageLatches is a table of shared/exclusive latches for pages. A PagedStorageFile
+uses latches so several readers can traverse its page lists concurrently while
+operations which modify the lists have exclusive access to the modified pages.
 */
 package paging
 
+/*
+DefaultReadAhead is the default number of pages which are read ahead during
+sequential scans
+*/
+const DefaultReadAhead = 8
+
 /*
 PageCursor data structure
 */
 type PageCursor struct {
-	psf     *PagedStorageFile // Pager to be used
-	ptype   int16             // Page type which will be traversed
-	current uint64            // Current page
+	psf       *PagedStorageFile // Pager to be used
+	ptype     int16             // Page type which will be traversed
+	current   uint64            // Current page
+	readAhead int               // Number of pages which should be read ahead
+	ahead     int               // Number of pages which are still read ahead
 }
 
 /*
 NewPageCursor creates a new cursor object which can be used to traverse a set of pages.
 */
 func NewPageCursor(psf *PagedStorageFile, ptype int16, current uint64) *PageCursor {
-	return &PageCursor{psf, ptype, current}
+	return &PageCursor{psf, ptype, current, 0, 0}
+}
+
+/*
+SetReadAhead sets the number of pages which are read ahead in the background
+when the cursor moves forward. A value of 0 disables read ahead.
+*/
+func (pc *PageCursor) SetReadAhead(pages int) {
+	pc.readAhead = pages
+	pc.ahead = 0
 }
 
 /*
@@ -75,6 +98,19 @@ func (pc *PageCursor) Next() (uint64, error) {
 
 	if page != 0 {
 		pc.current = page
+
+		// Read the following pages ahead once all previously read
+		// pages have been passed
+
+		if pc.readAhead > 0 {
+			if pc.ahead > 0 {
+				pc.ahead--
+			}
+			if pc.ahead == 0 {
+				pc.psf.prefetch(page, pc.readAhead+1)
+				pc.ahead = pc.readAhead
+			}
+		}
 	}
 
 	return page, nil
@@ -11,6 +11,8 @@
 package paging
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"devt.de/eliasdb/storage/file"
@@ -86,13 +88,13 @@ func TestPageCursor(t *testing.T) {
 	sf.Get(4)
 
 	_, err = pc.Prev()
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Operation should fail as the required record is in use")
 		return
 	}
 
 	_, err = pc.Next()
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Operation should fail as the required record is in use")
 		return
 	}
@@ -123,3 +125,57 @@ func checkPrev(t *testing.T, pc *PageCursor, expected uint64) {
 		t.Error("Unexpected previous page", prev, "expected", expected)
 	}
 }
+
+func TestPageCursorReadAhead(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test9", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	psf.AllocatePages(view.TypeDataPage, 20)
+	psf.FreePage(5)
+	psf.AllocatePage(view.TypeDataPage)
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sf, err = file.NewDefaultStorageFile(DBDIR+"/test9", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err = NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	pc := NewPageCursor(psf, view.TypeDataPage, 0)
+	pc.SetReadAhead(3)
+
+	var pages []uint64
+
+	for page, _ := pc.Next(); page != 0; page, _ = pc.Next() {
+		pages = append(pages, page)
+	}
+
+	if fmt.Sprint(pages) != "[1 2 3 4 6 7 8 9 10 11 12 13 14 15 16 17 18 19 20 5]" {
+		t.Error("Unexpected pages:", pages)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -11,8 +11,10 @@
 package paging
 
 import (
-	"errors"
+	"sync"
+	"time"
 
+	"devt.de/common/errorutil"
 	"devt.de/eliasdb/storage/file"
 	"devt.de/eliasdb/storage/paging/view"
 )
@@ -21,8 +23,8 @@ import (
 Common paged storage file related errors
 */
 var (
-	ErrFreePage = errors.New("Cannot allocate/free a free page")
-	ErrHeader   = errors.New("Cannot modify header record")
+	ErrFreePage = errorutil.NewCategorizedError(errorutil.ErrInvalid, "Cannot allocate/free a free page")
+	ErrHeader   = errorutil.NewCategorizedError(errorutil.ErrInvalid, "Cannot modify header record")
 )
 
 /*
@@ -31,6 +33,14 @@ PagedStorageFile data structure
 type PagedStorageFile struct {
 	storagefile *file.StorageFile       // StorageFile which is wrapped
 	header      *PagedStorageFileHeader // Header object
+	checksums   bool                    // Flag if page checksums should be written
+
+	access      map[int16]*PageAccessStats // Access statistics for each page type
+	accessSince time.Time                  // Time when access statistics were last reset
+	accessLock  *sync.Mutex                // Mutex to protect the access statistics
+
+	latches  *PageLatches // Shared/exclusive latches of all pages
+	fileLock *sync.Mutex  // Mutex to protect the wrapped StorageFile
 }
 
 /*
@@ -50,7 +60,39 @@ func NewPagedStorageFile(storagefile *file.StorageFile) (*PagedStorageFile, erro
 
 	header = NewPagedStorageFileHeader(record, isnew)
 
-	return &PagedStorageFile{storagefile, header}, nil
+	psf := &PagedStorageFile{storagefile, header, false,
+		make(map[int16]*PageAccessStats), time.Now(), &sync.Mutex{},
+		NewPageLatches(), &sync.Mutex{}}
+
+	storagefile.SetFlushHook(psf.updateChecksum)
+	storagefile.SetAccessHook(psf.recordAccess)
+
+	return psf, nil
+}
+
+/*
+SetChecksums enables or disables the writing of page checksums. Existing
+checksums are added or removed once a page is written the next time. Pages
+with a checksum are always validated when they are read.
+*/
+func (psf *PagedStorageFile) SetChecksums(enabled bool) {
+	psf.checksums = enabled
+}
+
+/*
+Checksums returns if page checksums are written.
+*/
+func (psf *PagedStorageFile) Checksums() bool {
+	return psf.checksums
+}
+
+/*
+updateChecksum updates the checksum of a page record before it is written.
+*/
+func (psf *PagedStorageFile) updateChecksum(record *file.Record) {
+	if record.ID() != 0 {
+		view.UpdateChecksum(record, psf.checksums)
+	}
 }
 
 /*
@@ -67,6 +109,115 @@ func (psf *PagedStorageFile) Header() *PagedStorageFileHeader {
 	return psf.header
 }
 
+/*
+Latches returns the page latches of this PagedStorageFile. Page lists can be
+traversed by several readers concurrently - each reader takes a shared latch
+on a page while it is read. Operations which modify page lists take an
+exclusive latch on the header (page 0) and on every page which they modify.
+Code which accesses records of the wrapped StorageFile directly should latch
+the respective pages as well. Page groups latch their pages exclusively and
+should not be used while page lists are modified by other goroutines.
+*/
+func (psf *PagedStorageFile) Latches() *PageLatches {
+	return psf.latches
+}
+
+/*
+getRecord gets a record from the wrapped StorageFile.
+*/
+func (psf *PagedStorageFile) getRecord(id uint64) (*file.Record, error) {
+	psf.fileLock.Lock()
+	defer psf.fileLock.Unlock()
+
+	return psf.storagefile.Get(id)
+}
+
+/*
+releaseRecord releases a record of the wrapped StorageFile.
+*/
+func (psf *PagedStorageFile) releaseRecord(record *file.Record) {
+	psf.fileLock.Lock()
+	defer psf.fileLock.Unlock()
+
+	psf.storagefile.ReleaseInUse(record)
+}
+
+/*
+readPage calls a given function with the page view of a page while holding a
+shared latch on the page.
+*/
+func (psf *PagedStorageFile) readPage(id uint64, read func(pageview *view.PageView)) error {
+	psf.latches.LatchShared(id)
+	defer psf.latches.UnlatchShared(id)
+
+	// Several readers may hold a shared latch - the record needs to be
+	// released before the next reader can get it
+
+	psf.fileLock.Lock()
+	defer psf.fileLock.Unlock()
+
+	record, err := psf.storagefile.Get(id)
+	if err != nil {
+		return err
+	}
+	defer psf.storagefile.ReleaseInUse(record)
+
+	read(view.GetPageView(record))
+
+	return nil
+}
+
+/*
+prefetch reads a number of pages of a list ahead.
+*/
+func (psf *PagedStorageFile) prefetch(id uint64, n int) {
+	psf.fileLock.Lock()
+	defer psf.fileLock.Unlock()
+
+	psf.storagefile.Prefetch(id, n, view.NextPageID)
+}
+
+/*
+pageWrite holds the exclusive latches of an operation which modifies page lists.
+*/
+type pageWrite struct {
+	psf     *PagedStorageFile // PagedStorageFile which is modified
+	latched map[uint64]bool   // Pages which are latched
+}
+
+/*
+startWrite starts an operation which modifies page lists by taking an
+exclusive latch on the header.
+*/
+func (psf *PagedStorageFile) startWrite() *pageWrite {
+	psf.latches.LatchExclusive(0)
+	return &pageWrite{psf, make(map[uint64]bool)}
+}
+
+/*
+get latches a page exclusively and gets its record. The page stays latched
+until the operation is finished.
+*/
+func (pw *pageWrite) get(id uint64) (*file.Record, error) {
+	if !pw.latched[id] {
+		pw.psf.latches.LatchExclusive(id)
+		pw.latched[id] = true
+	}
+
+	return pw.psf.getRecord(id)
+}
+
+/*
+finish releases all latches of the operation.
+*/
+func (pw *pageWrite) finish() {
+	for id := range pw.latched {
+		pw.psf.latches.UnlatchExclusive(id)
+	}
+
+	pw.psf.latches.UnlatchExclusive(0)
+}
+
 /*
 AllocatePage allocates a new page of a specific type.
 */
@@ -79,6 +230,9 @@ func (psf *PagedStorageFile) AllocatePage(pagetype int16) (uint64, error) {
 		return 0, ErrFreePage
 	}
 
+	pw := psf.startWrite()
+	defer pw.finish()
+
 	// Check first the free list
 
 	ptr := psf.header.FirstListElement(view.TypeFreePage)
@@ -98,7 +252,7 @@ func (psf *PagedStorageFile) AllocatePage(pagetype int16) (uint64, error) {
 		// Get the record - error checking already done in the
 		// previous psf.Next call
 
-		record, _ = psf.storagefile.Get(ptr)
+		record, _ = pw.get(ptr)
 
 		psf.header.SetFirstListElement(view.TypeFreePage, nextptr)
 
@@ -115,7 +269,7 @@ func (psf *PagedStorageFile) AllocatePage(pagetype int16) (uint64, error) {
 		// Get the record - if it fails we need to return before
 		// increasing the last list element pointer
 
-		record, err = psf.storagefile.Get(ptr)
+		record, err = pw.get(ptr)
 		if err != nil {
 			return 0, err
 		}
@@ -158,18 +312,18 @@ func (psf *PagedStorageFile) AllocatePage(pagetype int16) (uint64, error) {
 
 	// We can release the record now
 
-	psf.storagefile.ReleaseInUse(record)
+	psf.releaseRecord(record)
 
 	// Need to fix up the pointer of the former previous element
 
 	if oldtail != 0 {
-		record, err = psf.storagefile.Get(oldtail)
+		record, err = pw.get(oldtail)
 		if err != nil {
 			return 0, err
 		}
 		pageview = view.GetPageView(record)
 		pageview.SetNextPage(ptr)
-		psf.storagefile.ReleaseInUse(record)
+		psf.releaseRecord(record)
 	}
 
 	// Remove temp. page view
@@ -179,6 +333,137 @@ func (psf *PagedStorageFile) AllocatePage(pagetype int16) (uint64, error) {
 	return ptr, nil
 }
 
+/*
+AllocatePages allocates a batch of new pages in one pass. Pages are taken from
+the free list first - all further pages are allocated as a contiguous run at
+the end of the file. The new pages are linked in the order in which they are
+returned and appended to the list of the given type.
+*/
+func (psf *PagedStorageFile) AllocatePages(pagetype int16, n int) ([]uint64, error) {
+
+	if pagetype == view.TypeFreePage {
+		return nil, ErrFreePage
+	}
+
+	if n < 1 {
+		return nil, nil
+	}
+
+	pw := psf.startWrite()
+	defer pw.finish()
+
+	ptrs := make([]uint64, 0, n)
+
+	// Collect pages from the free list
+
+	freeptr := psf.header.FirstListElement(view.TypeFreePage)
+
+	for freeptr != 0 && len(ptrs) < n {
+		nextptr, err := psf.Next(freeptr)
+		if err != nil {
+			return nil, err
+		}
+
+		ptrs = append(ptrs, freeptr)
+		freeptr = nextptr
+	}
+
+	numFree := len(ptrs)
+
+	// Allocate all remaining pages as new records
+
+	newptr := psf.header.LastListElement(view.TypeFreePage)
+	if newptr == 0 {
+		// If the file is new the first pointer is 1
+		newptr = 1
+	}
+
+	for i := uint64(0); len(ptrs) < n; i++ {
+		ptrs = append(ptrs, newptr+i)
+	}
+
+	// Get all records before anything is modified
+
+	records := make([]*file.Record, 0, n)
+
+	for _, ptr := range ptrs {
+		record, err := pw.get(ptr)
+
+		if err != nil {
+			for _, record := range records {
+				psf.releaseRecord(record)
+			}
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	oldtail := psf.header.LastListElement(pagetype)
+
+	for i, record := range records {
+		var pageview *view.PageView
+
+		// Add a temp. page view so we can modify the record
+
+		if i >= numFree {
+			pageview = view.NewPageView(record, pagetype)
+		} else {
+			pageview = view.GetPageView(record)
+		}
+
+		record.ClearData()
+
+		pageview.SetType(pagetype)
+
+		if i == 0 {
+			pageview.SetPrevPage(oldtail)
+		} else {
+			pageview.SetPrevPage(ptrs[i-1])
+		}
+
+		if i == n-1 {
+			pageview.SetNextPage(0)
+		} else {
+			pageview.SetNextPage(ptrs[i+1])
+		}
+
+		psf.releaseRecord(record)
+
+		// Remove temp. page view
+
+		record.SetPageView(nil)
+	}
+
+	// Update the header
+
+	psf.header.SetFirstListElement(view.TypeFreePage, freeptr)
+
+	if numFree < n {
+		psf.header.SetLastListElement(view.TypeFreePage, newptr+uint64(n-numFree))
+	}
+
+	if oldtail == 0 {
+		psf.header.SetFirstListElement(pagetype, ptrs[0])
+	}
+
+	psf.header.SetLastListElement(pagetype, ptrs[n-1])
+
+	// Need to fix up the pointer of the former last element
+
+	if oldtail != 0 {
+		record, err := pw.get(oldtail)
+		if err != nil {
+			return nil, err
+		}
+		view.GetPageView(record).SetNextPage(ptrs[0])
+		psf.releaseRecord(record)
+		record.SetPageView(nil)
+	}
+
+	return ptrs, nil
+}
+
 /*
 FreePage frees a given page and adds it to the free list.
 */
@@ -188,7 +473,10 @@ func (psf *PagedStorageFile) FreePage(id uint64) error {
 		return ErrHeader
 	}
 
-	record, err := psf.storagefile.Get(id)
+	pw := psf.startWrite()
+	defer pw.finish()
+
+	record, err := pw.get(id)
 	if err != nil {
 		return err
 	}
@@ -197,7 +485,7 @@ func (psf *PagedStorageFile) FreePage(id uint64) error {
 	pagetype := pageview.Type()
 
 	if pagetype == view.TypeFreePage {
-		psf.storagefile.ReleaseInUse(record)
+		psf.releaseRecord(record)
 		return ErrFreePage
 	}
 
@@ -213,31 +501,31 @@ func (psf *PagedStorageFile) FreePage(id uint64) error {
 	// NOTE The prev pointers will always point to 0 for records in the
 	// free list. There is no need to update them.
 
-	psf.storagefile.ReleaseInUse(record)
+	psf.releaseRecord(record)
 
 	// Remove page from its old list - an error in the below leaves
 	// the lists in an inconsistent state.
 
 	if prev != 0 {
-		record, err = psf.storagefile.Get(prev)
+		record, err = pw.get(prev)
 		if err != nil {
 			return err
 		}
 		pageview := view.GetPageView(record)
 		pageview.SetNextPage(next)
-		psf.storagefile.ReleaseInUse(record)
+		psf.releaseRecord(record)
 	} else {
 		psf.header.SetFirstListElement(pagetype, next)
 	}
 
 	if next != 0 {
-		record, err = psf.storagefile.Get(next)
+		record, err = pw.get(next)
 		if err != nil {
 			return err
 		}
 		pageview := view.GetPageView(record)
 		pageview.SetPrevPage(prev)
-		psf.storagefile.ReleaseInUse(record)
+		psf.releaseRecord(record)
 	} else {
 		psf.header.SetLastListElement(pagetype, prev)
 	}
@@ -249,6 +537,9 @@ func (psf *PagedStorageFile) FreePage(id uint64) error {
 First returns the first page of a list of a given type.
 */
 func (psf *PagedStorageFile) First(pagetype int16) uint64 {
+	psf.latches.LatchShared(0)
+	defer psf.latches.UnlatchShared(0)
+
 	return psf.header.FirstListElement(pagetype)
 }
 
@@ -256,6 +547,9 @@ func (psf *PagedStorageFile) First(pagetype int16) uint64 {
 Last returns the first page of a list of a given type.
 */
 func (psf *PagedStorageFile) Last(pagetype int16) uint64 {
+	psf.latches.LatchShared(0)
+	defer psf.latches.UnlatchShared(0)
+
 	return psf.header.LastListElement(pagetype)
 }
 
@@ -263,36 +557,198 @@ func (psf *PagedStorageFile) Last(pagetype int16) uint64 {
 Next returns the next page of a given page in a list.
 */
 func (psf *PagedStorageFile) Next(id uint64) (uint64, error) {
-	record, err := psf.storagefile.Get(id)
-	if err != nil {
-		return 0, err
-	}
-	defer psf.storagefile.ReleaseInUse(record)
+	var next uint64
 
-	pageview := view.GetPageView(record)
+	err := psf.readPage(id, func(pageview *view.PageView) {
+		next = pageview.NextPage()
+	})
 
-	return pageview.NextPage(), nil
+	return next, err
 }
 
 /*
 Prev returns the previous page of a given page in a list.
 */
 func (psf *PagedStorageFile) Prev(id uint64) (uint64, error) {
-	record, err := psf.storagefile.Get(id)
-	if err != nil {
+	var prev uint64
+
+	err := psf.readPage(id, func(pageview *view.PageView) {
+		prev = pageview.PrevPage()
+	})
+
+	return prev, err
+}
+
+/*
+PagedStorageFileStats contains page statistics of a PagedStorageFile.
+*/
+type PagedStorageFileStats struct {
+	DataPages             int // Number of data pages
+	TranslationPages      int // Number of translation pages
+	FreeLogicalSlotPages  int // Number of free logical slot pages
+	FreePhysicalSlotPages int // Number of free physical slot pages
+	FreePages             int // Number of free pages waiting to be reallocated
+	TotalPages            int // Number of all pages in the file (excluding the header)
+}
+
+/*
+Stats returns statistics about the pages of this PagedStorageFile. All
+page lists are traversed so this operation can be expensive on large files.
+*/
+func (psf *PagedStorageFile) Stats() (*PagedStorageFileStats, error) {
+	var err error
+
+	stats := &PagedStorageFileStats{}
+
+	counters := []struct {
+		pagetype int16
+		count    *int
+	}{
+		{view.TypeDataPage, &stats.DataPages},
+		{view.TypeTranslationPage, &stats.TranslationPages},
+		{view.TypeFreeLogicalSlotPage, &stats.FreeLogicalSlotPages},
+		{view.TypeFreePhysicalSlotPage, &stats.FreePhysicalSlotPages},
+		{view.TypeFreePage, &stats.FreePages},
+	}
+
+	for _, c := range counters {
+		if *c.count, err = CountPages(psf, c.pagetype); err != nil {
+			return nil, err
+		}
+	}
+
+	// The last element pointer of the free list points to the next
+	// record which has never been allocated
+
+	if next := psf.Last(view.TypeFreePage); next > 0 {
+		stats.TotalPages = int(next - 1)
+	}
+
+	return stats, nil
+}
+
+/*
+Truncate releases all free pages at the end of the file and truncates the
+underlying StorageFile. All pending changes are written to disk. Returns the
+number of released pages.
+*/
+func (psf *PagedStorageFile) Truncate() (uint64, error) {
+
+	// Write all pending changes first - this fails if any pages are still in use
+
+	if err := psf.Flush(); err != nil {
 		return 0, err
 	}
-	defer psf.storagefile.ReleaseInUse(record)
 
-	pageview := view.GetPageView(record)
+	var err error
+
+	pw := psf.startWrite()
+	defer pw.finish()
 
-	return pageview.PrevPage(), nil
+	next := psf.header.LastListElement(view.TypeFreePage)
+
+	// Collect all free pages
+
+	var freePages []uint64
+
+	isFree := make(map[uint64]bool)
+
+	for ptr := psf.header.FirstListElement(view.TypeFreePage); ptr != 0; {
+		freePages = append(freePages, ptr)
+		isFree[ptr] = true
+
+		if ptr, err = psf.Next(ptr); err != nil {
+			return 0, err
+		}
+	}
+
+	// Determine the free pages at the end of the file
+
+	end := next
+	for end > 1 && isFree[end-1] {
+		end--
+	}
+
+	if end == next {
+		return 0, nil
+	}
+
+	// Remove the released pages from the free list
+
+	var last uint64
+
+	for _, ptr := range freePages {
+		if ptr >= end {
+			continue
+		}
+
+		if last == 0 {
+			psf.header.SetFirstListElement(view.TypeFreePage, ptr)
+		} else if err := pw.setNext(last, ptr); err != nil {
+			return 0, err
+		}
+
+		last = ptr
+	}
+
+	if last == 0 {
+		psf.header.SetFirstListElement(view.TypeFreePage, 0)
+	} else if err := pw.setNext(last, 0); err != nil {
+		return 0, err
+	}
+
+	psf.header.SetLastListElement(view.TypeFreePage, end)
+
+	// Write all changes and truncate the file
+
+	psf.fileLock.Lock()
+	defer psf.fileLock.Unlock()
+
+	psf.storagefile.ReleaseInUse(psf.header.record)
+
+	if err := psf.storagefile.Truncate(end); err != nil {
+
+		// If an error happens try to recover by putting
+		// the header record back in use
+
+		psf.storagefile.Get(0)
+
+		return 0, err
+	}
+
+	record, _ := psf.storagefile.Get(0)
+	psf.header = NewPagedStorageFileHeader(record, false)
+
+	return next - end, nil
+}
+
+/*
+setNext sets the next pointer of a given page if it is different.
+*/
+func (pw *pageWrite) setNext(id uint64, next uint64) error {
+	record, err := pw.get(id)
+	if err != nil {
+		return err
+	}
+	defer pw.psf.releaseRecord(record)
+
+	if pageview := view.GetPageView(record); pageview.NextPage() != next {
+		pageview.SetNextPage(next)
+	}
+
+	return nil
 }
 
 /*
 Flush writes all pending data to disk.
 */
 func (psf *PagedStorageFile) Flush() error {
+	psf.latches.LatchExclusive(0)
+	defer psf.latches.UnlatchExclusive(0)
+
+	psf.fileLock.Lock()
+	defer psf.fileLock.Unlock()
+
 	psf.storagefile.ReleaseInUse(psf.header.record)
 
 	if err := psf.storagefile.Flush(); err != nil {
@@ -320,6 +776,12 @@ The PageStorageFile object should be discarded if something
 goes wrong during a rollback operation.
 */
 func (psf *PagedStorageFile) Rollback() error {
+	psf.latches.LatchExclusive(0)
+	defer psf.latches.UnlatchExclusive(0)
+
+	psf.fileLock.Lock()
+	defer psf.fileLock.Unlock()
+
 	psf.storagefile.Discard(psf.header.record)
 
 	if err := psf.storagefile.Rollback(); err != nil {
@@ -345,6 +807,11 @@ func (psf *PagedStorageFile) Rollback() error {
 Close commits all data and closes all physical files.
 */
 func (psf *PagedStorageFile) Close() error {
+	psf.latches.LatchExclusive(0)
+	defer psf.latches.UnlatchExclusive(0)
+
+	psf.fileLock.Lock()
+	defer psf.fileLock.Unlock()
 
 	if psf.header != nil {
 		psf.storagefile.ReleaseInUse(psf.header.record)
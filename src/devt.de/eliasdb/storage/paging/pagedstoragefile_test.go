@@ -11,9 +11,11 @@
 package paging
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 
 	"devt.de/common/fileutil"
@@ -68,7 +70,7 @@ func TestPagedStorageFileInitialisation(t *testing.T) {
 	}
 
 	_, err = NewPagedStorageFile(sf)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Init of PageStorageFile should fail if header record is not available")
 		return
 	}
@@ -160,7 +162,7 @@ func TestPagedStorageFilePageManagement(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	if err := psf.FreePage(3); err != file.ErrAlreadyInUse {
+	if err := psf.FreePage(3); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -261,7 +263,7 @@ func TestPagedStorageFilePageManagement(t *testing.T) {
 	}
 
 	_, err = psf.AllocatePage(view.TypeTranslationPage)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -275,7 +277,7 @@ func TestPagedStorageFilePageManagement(t *testing.T) {
 	}
 
 	_, err = psf.AllocatePage(view.TypeTranslationPage)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -295,7 +297,7 @@ func TestPagedStorageFilePageManagement(t *testing.T) {
 	}
 
 	_, err = psf.AllocatePage(view.TypeTranslationPage)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 	}
 
@@ -316,12 +318,12 @@ func TestPagedStorageFilePageManagement(t *testing.T) {
 
 	// Check we can't get Prev info when record is in use
 
-	if _, err := psf.Prev(4); err != file.ErrAlreadyInUse {
+	if _, err := psf.Prev(4); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
 
-	if err := psf.FreePage(2); err != file.ErrAlreadyInUse {
+	if err := psf.FreePage(2); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -336,7 +338,7 @@ func TestPagedStorageFilePageManagement(t *testing.T) {
 		return
 	}
 
-	if err := psf.FreePage(4); err != file.ErrAlreadyInUse {
+	if err := psf.FreePage(4); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -354,7 +356,7 @@ func TestPagedStorageFilePageManagement(t *testing.T) {
 		return
 	}
 
-	if err := psf.Close(); err != file.ErrInUse {
+	if err := psf.Close(); !errors.Is(err, file.ErrInUse) {
 		t.Error(err)
 		return
 	}
@@ -417,7 +419,7 @@ func TestPagedStorageFileTransactionPageManagement(t *testing.T) {
 		return
 	}
 
-	if err := psf.FreePage(3); err != file.ErrAlreadyInUse {
+	if err := psf.FreePage(3); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -465,7 +467,7 @@ func TestPagedStorageFileTransactionPageManagement(t *testing.T) {
 
 	record, err = sf.Get(4)
 
-	if err := psf.Flush(); err != file.ErrInUse {
+	if err := psf.Flush(); !errors.Is(err, file.ErrInUse) {
 		t.Error(err)
 		return
 	}
@@ -474,7 +476,7 @@ func TestPagedStorageFileTransactionPageManagement(t *testing.T) {
 
 	psf.header.record = nil
 
-	if err := psf.Flush(); err != file.ErrInUse {
+	if err := psf.Flush(); !errors.Is(err, file.ErrInUse) {
 		t.Error(err)
 		return
 	}
@@ -483,7 +485,7 @@ func TestPagedStorageFileTransactionPageManagement(t *testing.T) {
 
 	record, err = sf.Get(4)
 
-	if err := psf.Rollback(); err != file.ErrInUse {
+	if err := psf.Rollback(); !errors.Is(err, file.ErrInUse) {
 		t.Error(err)
 		return
 	}
@@ -524,3 +526,444 @@ func checkPrevAndNext(t *testing.T, psf *PagedStorageFile, rid uint64,
 	}
 
 }
+
+func TestPagedStorageFileStats(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test6", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats, err := psf.Stats()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if *stats != (PagedStorageFileStats{}) {
+		t.Error("Unexpected stats for empty file:", stats)
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		psf.AllocatePage(view.TypeDataPage)
+	}
+	psf.AllocatePage(view.TypeTranslationPage)
+	psf.AllocatePage(view.TypeFreeLogicalSlotPage)
+	psf.AllocatePage(view.TypeFreePhysicalSlotPage)
+	psf.AllocatePage(view.TypeFreePhysicalSlotPage)
+
+	if err := psf.FreePage(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	stats, err = psf.Stats()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if *stats != (PagedStorageFileStats{2, 1, 1, 2, 1, 7}) {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	record, err := sf.Get(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := psf.Stats(); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPagedStorageFileAllocatePages(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test8", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := psf.AllocatePages(view.TypeFreePage, 2); err != ErrFreePage {
+		t.Error("Unexpected error:", err)
+		return
+	}
+
+	if res, err := psf.AllocatePages(view.TypeDataPage, 0); res != nil || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	res, err := psf.AllocatePages(view.TypeDataPage, 3)
+	if fmt.Sprint(res) != "[1 2 3]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	psf.AllocatePage(view.TypeTranslationPage)
+
+	psf.FreePage(2)
+	psf.FreePage(1)
+
+	// Free pages are used first and the rest is allocated at the end
+
+	res, err = psf.AllocatePages(view.TypeDataPage, 4)
+	if fmt.Sprint(res) != "[1 2 5 6]" || err != nil {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if psf.First(view.TypeFreePage) != 0 || psf.Last(view.TypeFreePage) != 7 {
+		t.Error("Unexpected free list:", psf.First(view.TypeFreePage),
+			psf.Last(view.TypeFreePage))
+		return
+	}
+
+	// Check the data page list in both directions
+
+	var forward, backward []uint64
+
+	for ptr := psf.First(view.TypeDataPage); ptr != 0; ptr, _ = psf.Next(ptr) {
+		forward = append(forward, ptr)
+	}
+
+	for ptr := psf.Last(view.TypeDataPage); ptr != 0; ptr, _ = psf.Prev(ptr) {
+		backward = append(backward, ptr)
+	}
+
+	if fmt.Sprint(forward) != "[3 1 2 5 6]" || fmt.Sprint(backward) != "[6 5 2 1 3]" {
+		t.Error("Unexpected page list:", forward, backward)
+		return
+	}
+
+	stats, err := psf.Stats()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if *stats != (PagedStorageFileStats{5, 1, 0, 0, 0, 6}) {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPagedStorageFileChecksums(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test7", true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	psf.SetChecksums(true)
+
+	if !psf.Checksums() {
+		t.Error("Checksums should be enabled")
+		return
+	}
+
+	psf.AllocatePage(view.TypeDataPage)
+	psf.AllocatePage(view.TypeDataPage)
+
+	record, err := sf.Get(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	record.WriteSingleByte(100, 0x42)
+	sf.ReleaseInUse(record)
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Read pages back - checksums should be valid
+
+	sf, err = file.NewDefaultStorageFile(DBDIR+"/test7", true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err = NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	next, _ := psf.Next(1)
+	prev, _ := psf.Prev(2)
+
+	if psf.First(view.TypeDataPage) != 1 || next != 2 || prev != 1 {
+		t.Error("Unexpected page list:", psf.First(view.TypeDataPage), next, prev)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Corrupt the payload of the first page on disk
+
+	f, err := os.OpenFile(DBDIR+"/test7.0", os.O_RDWR, 0660)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	f.WriteAt([]byte{0x43}, file.DefaultRecordSize+100)
+	f.Close()
+
+	sf, err = file.NewDefaultStorageFile(DBDIR+"/test7", true)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err = NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil || r.(error).Error() != "Checksum mismatch in page 1" {
+				t.Error("Unexpected panic:", r)
+			}
+		}()
+
+		psf.Next(1)
+	}()
+
+	// Page 2 was not touched
+
+	if prev, _ := psf.Prev(2); prev != 1 {
+		t.Error("Unexpected previous page")
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPagedStorageFileTruncate(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test12", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := psf.Truncate(); res != 0 || err != nil {
+		t.Error("Unexpected truncate result:", res, err)
+		return
+	}
+
+	for i := 0; i < 5; i++ {
+		psf.AllocatePage(view.TypeDataPage)
+	}
+
+	for _, id := range []uint64{5, 2, 4} {
+		if err := psf.FreePage(id); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := psf.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, err := psf.Truncate(); res != 2 || err != nil {
+		t.Error("Unexpected truncate result:", res, err)
+		return
+	}
+
+	if info, err := os.Stat(DBDIR + "/test12.0"); err != nil || info.Size() != 4*file.DefaultRecordSize {
+		t.Error("Unexpected file size:", info, err)
+		return
+	}
+
+	stats, err := psf.Stats()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if *stats != (PagedStorageFileStats{2, 0, 0, 0, 1, 3}) {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	// Released pages are allocated again at the end of the file
+
+	if res, _ := psf.AllocatePage(view.TypeDataPage); res != 2 {
+		t.Error("Unexpected allocated page:", res)
+		return
+	}
+
+	if res, _ := psf.AllocatePage(view.TypeDataPage); res != 4 {
+		t.Error("Unexpected allocated page:", res)
+		return
+	}
+
+	if res, err := psf.Truncate(); res != 0 || err != nil {
+		t.Error("Unexpected truncate result:", res, err)
+		return
+	}
+
+	// Truncation fails if a record is still in use
+
+	psf.FreePage(4)
+
+	record, _ := sf.Get(1)
+
+	if _, err := psf.Truncate(); !errors.Is(err, file.ErrInUse) {
+		t.Error("Unexpected truncate result:", err)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	if res, err := psf.Truncate(); res != 1 || err != nil {
+		t.Error("Unexpected truncate result:", res, err)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPagedStorageFileConcurrentReaders(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test13", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := psf.AllocatePages(view.TypeDataPage, 50); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Traverse the page list with several readers while a writer modifies
+	// another page list
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, 10)
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				pc := NewPageCursor(psf, view.TypeDataPage, 0)
+				pc.SetReadAhead(DefaultReadAhead)
+
+				count := 0
+
+				page, err := pc.Next()
+				for ; page != 0 && err == nil; page, err = pc.Next() {
+					count++
+				}
+
+				if err != nil {
+					errs <- err
+					return
+				} else if count != 50 {
+					errs <- fmt.Errorf("Unexpected number of pages: %v", count)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for j := 0; j < 20; j++ {
+			page, err := psf.AllocatePage(view.TypeTranslationPage)
+			if err == nil && j%2 == 0 {
+				err = psf.FreePage(page)
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if res := psf.Latches().Len(); res != 0 {
+		t.Error("Unexpected number of latches:", res)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -10,7 +10,10 @@
 
 package paging
 
-import "devt.de/eliasdb/storage/file"
+import (
+	"devt.de/common/errorutil"
+	"devt.de/eliasdb/storage/file"
+)
 
 /*
 PageHeader is the magic number to identify page headers
@@ -32,24 +35,45 @@ OffsetRoots is the number of lists which can be stored in this header
 */
 const OffsetRoots = OffsetLists + (2 * TotalLists * file.SizeLong)
 
+/*
+UserDataSize is the size of the user data area at the end of the header. The
+area is only reserved if the header record is big enough to hold at least one
+root value in addition to the user data.
+*/
+const UserDataSize = 512
+
+/*
+ErrUserDataFull is returned if a user data value does not fit into the user
+data area of the header.
+*/
+var ErrUserDataFull = errorutil.NewCategorizedError(errorutil.ErrQuota,
+	"Not enough space in user data area of header")
+
 /*
 PagedStorageFileHeader data structure
 */
 type PagedStorageFileHeader struct {
-	record     *file.Record // Record which is being used for the header information
-	totalRoots int          // Number of root values which can be stored
+	record         *file.Record // Record which is being used for the header information
+	totalRoots     int          // Number of root values which can be stored
+	offsetUserData int          // Offset of the user data area
 }
 
 /*
 NewPagedStorageFileHeader creates a new NewPagedStorageFileHeader.
 */
 func NewPagedStorageFileHeader(record *file.Record, isnew bool) *PagedStorageFileHeader {
-	totalRoots := (len(record.Data()) - OffsetRoots) / file.SizeLong
+	offsetUserData := len(record.Data())
+
+	if offsetUserData-UserDataSize-OffsetRoots >= file.SizeLong {
+		offsetUserData -= UserDataSize
+	}
+
+	totalRoots := (offsetUserData - OffsetRoots) / file.SizeLong
 	if totalRoots < 1 {
 		panic("Cannot store any roots - record is too small")
 	}
 
-	ret := &PagedStorageFileHeader{record, totalRoots}
+	ret := &PagedStorageFileHeader{record, totalRoots, offsetUserData}
 
 	if isnew {
 		record.WriteUInt16(0, PageHeader)
@@ -138,3 +162,120 @@ offsetLastListElement returns offset of the last element of a list.
 func offsetLastListElement(list int16) int {
 	return offsetFirstListElement(list) + file.SizeLong
 }
+
+/*
+UserData returns a value from the user data area. Returns nil if the value
+does not exist.
+*/
+func (psfh *PagedStorageFileHeader) UserData(key string) []byte {
+	keys, values := psfh.readUserData()
+
+	for i, k := range keys {
+		if k == key {
+			return values[i]
+		}
+	}
+
+	return nil
+}
+
+/*
+UserDataKeys returns all keys of the user data area.
+*/
+func (psfh *PagedStorageFileHeader) UserDataKeys() []string {
+	keys, _ := psfh.readUserData()
+	return keys
+}
+
+/*
+SetUserData sets a value in the user data area. A nil value removes the
+key from the area.
+*/
+func (psfh *PagedStorageFileHeader) SetUserData(key string, value []byte) error {
+	var newKeys []string
+	var newValues [][]byte
+
+	keys, values := psfh.readUserData()
+
+	for i, k := range keys {
+		if k != key {
+			newKeys = append(newKeys, k)
+			newValues = append(newValues, values[i])
+		}
+	}
+
+	if value != nil {
+		newKeys = append(newKeys, key)
+		newValues = append(newValues, value)
+	}
+
+	return psfh.writeUserData(newKeys, newValues)
+}
+
+/*
+readUserData reads all key / value pairs from the user data area. The area
+starts with its used size followed by the key / value pairs. Keys and values
+are both prefixed with their length.
+*/
+func (psfh *PagedStorageFileHeader) readUserData() ([]string, [][]byte) {
+	var keys []string
+	var values [][]byte
+
+	if psfh.offsetUserData == len(psfh.record.Data()) {
+		return keys, values
+	}
+
+	data := psfh.record.Data()
+	offset := psfh.offsetUserData + file.SizeUnsignedShort
+	end := offset + int(psfh.record.ReadUInt16(psfh.offsetUserData))
+
+	for offset < end {
+		klen := int(psfh.record.ReadUInt16(offset))
+		offset += file.SizeUnsignedShort
+		key := string(data[offset : offset+klen])
+		offset += klen
+
+		vlen := int(psfh.record.ReadUInt16(offset))
+		offset += file.SizeUnsignedShort
+		value := make([]byte, vlen)
+		copy(value, data[offset:offset+vlen])
+		offset += vlen
+
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	return keys, values
+}
+
+/*
+writeUserData writes the given key / value pairs to the user data area.
+*/
+func (psfh *PagedStorageFileHeader) writeUserData(keys []string, values [][]byte) error {
+	size := 0
+
+	for i, k := range keys {
+		size += 2*file.SizeUnsignedShort + len(k) + len(values[i])
+	}
+
+	if psfh.offsetUserData+file.SizeUnsignedShort+size > len(psfh.record.Data()) {
+		return ErrUserDataFull
+	}
+
+	data := psfh.record.Data()
+	offset := psfh.offsetUserData + file.SizeUnsignedShort
+
+	psfh.record.WriteUInt16(psfh.offsetUserData, uint16(size))
+
+	for i, k := range keys {
+		psfh.record.WriteUInt16(offset, uint16(len(k)))
+		offset += file.SizeUnsignedShort
+		offset += copy(data[offset:], k)
+
+		psfh.record.WriteUInt16(offset, uint16(len(values[i])))
+		offset += file.SizeUnsignedShort
+		offset += copy(data[offset:], values[i])
+	}
+
+	return nil
+}
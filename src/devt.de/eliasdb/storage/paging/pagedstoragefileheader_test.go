@@ -11,6 +11,7 @@
 package paging
 
 import (
+	"fmt"
 	"testing"
 
 	"devt.de/eliasdb/storage/file"
@@ -50,6 +51,97 @@ func TestPagedStorageFileHeader(t *testing.T) {
 	}
 }
 
+func TestPagedStorageFileHeaderUserData(t *testing.T) {
+
+	// Small records have no user data area
+
+	record := file.NewRecord(5, make([]byte, 100, 100))
+	psfh := NewPagedStorageFileHeader(record, true)
+
+	if err := psfh.SetUserData("a", []byte("b")); err != ErrUserDataFull {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if psfh.UserData("a") != nil || len(psfh.UserDataKeys()) != 0 {
+		t.Error("Unexpected user data")
+		return
+	}
+
+	record = file.NewRecord(5, make([]byte, 1024, 1024))
+	psfh = NewPagedStorageFileHeader(record, true)
+
+	if psfh.Roots() != (1024-OffsetRoots-UserDataSize)/file.SizeLong {
+		t.Error("Unexpected number of roots:", psfh.Roots())
+		return
+	}
+
+	psfh.SetRoot(psfh.Roots()-1, 0xFFFFFFFFFFFFFFFF)
+
+	if err := psfh.SetUserData("version", []byte("1.2")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := psfh.SetUserData("appid", []byte("myapp")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := psfh.SetUserData("version", []byte("1.3")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Read the values from a new header object
+
+	psfh = NewPagedStorageFileHeader(record, false)
+
+	if res := string(psfh.UserData("version")); res != "1.3" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := string(psfh.UserData("appid")); res != "myapp" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if res := fmt.Sprint(psfh.UserDataKeys()); res != "[appid version]" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if psfh.Root(psfh.Roots()-1) != 0xFFFFFFFFFFFFFFFF {
+		t.Error("Unexpected root value:", psfh.Root(psfh.Roots()-1))
+		return
+	}
+
+	// Remove a value
+
+	if err := psfh.SetUserData("appid", nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if psfh.UserData("appid") != nil || fmt.Sprint(psfh.UserDataKeys()) != "[version]" {
+		t.Error("Unexpected user data:", psfh.UserDataKeys())
+		return
+	}
+
+	// Try to store a value which is too big
+
+	if err := psfh.SetUserData("big", make([]byte, UserDataSize)); err != ErrUserDataFull {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if res := string(psfh.UserData("version")); res != "1.3" {
+		t.Error("Unexpected result:", res)
+		return
+	}
+}
+
 func testPagedStorageFileInitPanic1(t *testing.T, r *file.Record) {
 	defer func() {
 		if r := recover(); r == nil {
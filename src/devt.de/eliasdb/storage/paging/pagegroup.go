@@ -0,0 +1,122 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package paging
+
+import "devt.de/eliasdb/storage/file"
+
+/*
+PageGroup is a set of pages of a PagedStorageFile which are modified together.
+The pages of a group stay in-use and are latched exclusively until the group
+is committed or rolled back so the storage file cannot be flushed while the
+group is open. On commit all
+pages are marked dirty at the same time and become part of the same
+transaction. On rollback all pages are restored to the state they had when
+they were added to the group.
+*/
+type PageGroup struct {
+	psf       *PagedStorageFile // PagedStorageFile which contains the pages
+	records   []*file.Record    // Records of the group
+	originals map[uint64][]byte // Original data of each record
+	dirty     map[uint64]bool   // Original dirty flag of each record
+}
+
+/*
+NewPageGroup creates a new empty page group.
+*/
+func (psf *PagedStorageFile) NewPageGroup() *PageGroup {
+	return &PageGroup{psf, nil, make(map[uint64][]byte), make(map[uint64]bool)}
+}
+
+/*
+Get adds a page to the group and returns its record. The record must not be
+released by the caller. Requesting a page of the group again returns the same
+record.
+*/
+func (pg *PageGroup) Get(id uint64) (*file.Record, error) {
+
+	if id == 0 {
+		return nil, ErrHeader
+	}
+
+	if _, ok := pg.originals[id]; ok {
+		for _, record := range pg.records {
+			if record.ID() == id {
+				return record, nil
+			}
+		}
+	}
+
+	pg.psf.latches.LatchExclusive(id)
+
+	record, err := pg.psf.getRecord(id)
+	if err != nil {
+		pg.psf.latches.UnlatchExclusive(id)
+		return nil, err
+	}
+
+	original := make([]byte, len(record.Data()))
+	copy(original, record.Data())
+
+	pg.records = append(pg.records, record)
+	pg.originals[id] = original
+	pg.dirty[id] = record.Dirty()
+
+	return record, nil
+}
+
+/*
+Len returns the number of pages in the group.
+*/
+func (pg *PageGroup) Len() int {
+	return len(pg.records)
+}
+
+/*
+Commit marks all pages of the group as dirty and releases them. The group is
+empty afterwards.
+*/
+func (pg *PageGroup) Commit() {
+	for _, record := range pg.records {
+		record.SetDirty()
+		pg.psf.releaseRecord(record)
+		pg.psf.latches.UnlatchExclusive(record.ID())
+	}
+
+	pg.clear()
+}
+
+/*
+Rollback restores the original data of all pages of the group and releases
+them. The group is empty afterwards.
+*/
+func (pg *PageGroup) Rollback() {
+	for _, record := range pg.records {
+		copy(record.Data(), pg.originals[record.ID()])
+
+		if !pg.dirty[record.ID()] {
+			record.ClearDirty()
+		}
+
+		pg.psf.releaseRecord(record)
+		pg.psf.latches.UnlatchExclusive(record.ID())
+	}
+
+	pg.clear()
+}
+
+/*
+clear removes all pages from the group.
+*/
+func (pg *PageGroup) clear() {
+	pg.records = nil
+	pg.originals = make(map[uint64][]byte)
+	pg.dirty = make(map[uint64]bool)
+}
@@ -0,0 +1,122 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package paging
+
+import (
+	"testing"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging/view"
+)
+
+func TestPageGroup(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test11", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	psf.AllocatePage(view.TypeDataPage)
+	psf.AllocatePage(view.TypeDataPage)
+
+	if err := psf.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	group := psf.NewPageGroup()
+
+	if _, err := group.Get(0); err != ErrHeader {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	r1, _ := group.Get(1)
+	r2, _ := group.Get(2)
+
+	if r, _ := group.Get(1); r != r1 || group.Len() != 2 {
+		t.Error("Unexpected group:", r, group.Len())
+		return
+	}
+
+	r1.WriteSingleByte(100, 0x42)
+	r2.WriteSingleByte(100, 0x43)
+
+	// The file cannot be flushed while the group is open
+
+	if err := psf.Flush(); err == nil {
+		t.Error("Flush should fail while a page group is open")
+		return
+	}
+
+	// Rollback restores all pages
+
+	group.Rollback()
+
+	if group.Len() != 0 {
+		t.Error("Group should be empty")
+		return
+	}
+
+	for _, id := range []uint64{1, 2} {
+		record, _ := sf.Get(id)
+
+		if record.ReadSingleByte(100) != 0 || record.Dirty() {
+			t.Error("Unexpected record:", record)
+			return
+		}
+
+		sf.ReleaseInUse(record)
+	}
+
+	// Commit marks all pages dirty
+
+	r1, _ = group.Get(1)
+	r2, _ = group.Get(2)
+
+	r1.WriteSingleByte(100, 0x42)
+	r2.Data()[100] = 0x43
+
+	group.Commit()
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sf, err = file.NewDefaultStorageFile(DBDIR+"/test11", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	for id, val := range map[uint64]byte{1: 0x42, 2: 0x43} {
+		record, _ := sf.Get(id)
+
+		if record.ReadSingleByte(100) != val {
+			t.Error("Unexpected record:", record)
+			return
+		}
+
+		sf.ReleaseInUse(record)
+	}
+
+	if err := sf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -11,6 +11,7 @@
 package paging
 
 import (
+	"errors"
 	"testing"
 
 	"devt.de/eliasdb/storage/file"
@@ -54,7 +55,7 @@ func TestFreePhysicalSlotManagerScale(t *testing.T) {
 		return
 	}
 
-	if pc, err := CountPages(psf, view.TypeDataPage); pc != -1 || err != file.ErrAlreadyInUse {
+	if pc, err := CountPages(psf, view.TypeDataPage); pc != -1 || !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected page count result:", pc, err)
 		return
 	}
@@ -67,7 +68,7 @@ func TestFreePhysicalSlotManagerScale(t *testing.T) {
 		return
 	}
 
-	if pc, err := CountPages(psf, view.TypeDataPage); pc != -1 || err != file.ErrAlreadyInUse {
+	if pc, err := CountPages(psf, view.TypeDataPage); pc != -1 || !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected page count result:", pc, err)
 		return
 	}
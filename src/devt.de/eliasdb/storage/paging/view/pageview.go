@@ -21,8 +21,11 @@ NewPageView() to initialise or reassign a given record.
 package view
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 
+	"devt.de/common/errorutil"
 	"devt.de/eliasdb/storage/file"
 )
 
@@ -31,6 +34,18 @@ ViewPageHeader is the header magic number to identify view pages
 */
 const ViewPageHeader = 0x1990
 
+/*
+ViewPageChecksumFlag is set in the header magic number if the page carries a
+checksum. The checksum is stored in the otherwise unused upper 16 bits of the
+next page and previous page pointers and covers the whole page payload.
+*/
+const ViewPageChecksumFlag = 0x2000
+
+/*
+pageIDMask masks out the checksum bits from a next or previous page pointer
+*/
+const pageIDMask = 0x0000FFFFFFFFFFFF
+
 /*
 OffsetNextPage is the offset for next page id
 */
@@ -66,6 +81,7 @@ func GetPageView(record *file.Record) *PageView {
 
 	pv = &PageView{record}
 	pv.checkMagic()
+	pv.checkChecksum()
 	record.SetPageView(pv)
 
 	return pv
@@ -85,14 +101,15 @@ func NewPageView(record *file.Record, pagetype int16) *PageView {
 Type gets the type of this page view which is stored on the record.
 */
 func (pv *PageView) Type() int16 {
-	return pv.Record.ReadInt16(0) - ViewPageHeader
+	return PageMagic(pv.Record) - ViewPageHeader
 }
 
 /*
 SetType sets the type of this page view which is stored on the record.
 */
 func (pv *PageView) SetType(pagetype int16) {
-	pv.Record.WriteInt16(0, ViewPageHeader+pagetype)
+	pv.Record.WriteInt16(0, ViewPageHeader+pagetype|
+		pv.Record.ReadInt16(0)&ViewPageChecksumFlag)
 }
 
 /*
@@ -100,7 +117,7 @@ checkMagic checks if the magic number at the beginning of the wrapped record
 is valid.
 */
 func (pv *PageView) checkMagic() bool {
-	magic := pv.Record.ReadInt16(0)
+	magic := PageMagic(pv.Record)
 
 	if magic >= ViewPageHeader &&
 		magic <= ViewPageHeader+TypeFreePhysicalSlotPage {
@@ -109,12 +126,118 @@ func (pv *PageView) checkMagic() bool {
 	panic("Unexpected header found in PageView")
 }
 
+/*
+checkChecksum checks if the checksum of the wrapped record (if there is one)
+matches its payload. Records which have been modified in memory are not checked
+as their checksum is only updated once they are written.
+*/
+func (pv *PageView) checkChecksum() bool {
+	if pv.Record.Dirty() || pv.Record.ReadInt16(0)&ViewPageChecksumFlag == 0 {
+		return true
+	}
+
+	data := pv.Record.Data()
+
+	if storedChecksum(data) == pageChecksum(data) {
+		return true
+	}
+	panic(errorutil.NewCategorizedError(errorutil.ErrCorruption,
+		fmt.Sprint("Checksum mismatch in page ", pv.Record.ID())))
+}
+
+/*
+PageMagic returns the magic number of a given page record without the
+checksum flag.
+*/
+func PageMagic(record *file.Record) int16 {
+	return record.ReadInt16(0) &^ ViewPageChecksumFlag
+}
+
+/*
+UpdateChecksum updates the checksum of a given page record. If enabled is false
+then any existing checksum is removed from the record. Records which do not
+hold a page view are not touched.
+*/
+func UpdateChecksum(record *file.Record, enabled bool) {
+	data := record.Data()
+
+	if len(data) < OffsetData {
+		return
+	}
+
+	magic := record.ReadInt16(0)
+
+	if m := magic &^ ViewPageChecksumFlag; m < ViewPageHeader ||
+		m > ViewPageHeader+TypeFreePhysicalSlotPage {
+		return
+	}
+
+	// Write directly into the record data - the caller is expected
+	// to write the record anyway
+
+	if enabled {
+		data[0] = byte(uint16(magic|ViewPageChecksumFlag) >> 8)
+
+		checksum := pageChecksum(data)
+
+		data[OffsetNextPage] = byte(checksum >> 24)
+		data[OffsetNextPage+1] = byte(checksum >> 16)
+		data[OffsetPrevPage] = byte(checksum >> 8)
+		data[OffsetPrevPage+1] = byte(checksum)
+
+	} else if magic&ViewPageChecksumFlag != 0 {
+		data[0] = byte(uint16(magic&^ViewPageChecksumFlag) >> 8)
+
+		data[OffsetNextPage] = 0
+		data[OffsetNextPage+1] = 0
+		data[OffsetPrevPage] = 0
+		data[OffsetPrevPage+1] = 0
+	}
+}
+
+/*
+NextPageID returns the id of the next page from raw page data. Returns 0 if
+the data does not belong to a page.
+*/
+func NextPageID(data []byte) uint64 {
+	if len(data) < OffsetData {
+		return 0
+	}
+
+	magic := int16(binary.BigEndian.Uint16(data)) &^ ViewPageChecksumFlag
+
+	if magic < ViewPageHeader || magic > ViewPageHeader+TypeFreePhysicalSlotPage {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(data[OffsetNextPage:]) & pageIDMask
+}
+
+/*
+storedChecksum returns the checksum which is stored in the given page data.
+*/
+func storedChecksum(data []byte) uint32 {
+	return uint32(data[OffsetNextPage])<<24 | uint32(data[OffsetNextPage+1])<<16 |
+		uint32(data[OffsetPrevPage])<<8 | uint32(data[OffsetPrevPage+1])
+}
+
+/*
+pageChecksum calculates the checksum of the given page data. The checksum
+covers everything except the bytes which hold the checksum itself.
+*/
+func pageChecksum(data []byte) uint32 {
+	checksum := crc32.ChecksumIEEE(data[:OffsetNextPage])
+	checksum = crc32.Update(checksum, crc32.IEEETable, data[OffsetNextPage+2:OffsetPrevPage])
+	checksum = crc32.Update(checksum, crc32.IEEETable, data[OffsetPrevPage+2:])
+	return checksum
+}
+
 /*
 NextPage returns the id of the next page.
 */
 func (pv *PageView) NextPage() uint64 {
 	pv.checkMagic()
-	return pv.Record.ReadUInt64(OffsetNextPage)
+	return pv.Record.ReadUInt64(OffsetNextPage) & pageIDMask
 }
 
 /*
@@ -130,7 +253,7 @@ PrevPage returns the id of the previous page.
 */
 func (pv *PageView) PrevPage() uint64 {
 	pv.checkMagic()
-	return pv.Record.ReadUInt64(OffsetPrevPage)
+	return pv.Record.ReadUInt64(OffsetPrevPage) & pageIDMask
 }
 
 /*
@@ -91,3 +91,81 @@ func testCheckMagicPanic(t *testing.T, r *file.Record) {
 
 	GetPageView(r)
 }
+
+func TestPageViewChecksum(t *testing.T) {
+	r := file.NewRecord(123, make([]byte, 40))
+
+	pv := NewPageView(r, TypeTranslationPage)
+	pv.SetNextPage(5)
+	pv.SetPrevPage(3)
+	r.WriteSingleByte(30, 0x11)
+
+	UpdateChecksum(r, true)
+
+	if r.ReadInt16(0) != 0x3992 || PageMagic(r) != 0x1992 {
+		t.Error("Unexpected header value:", r.ReadInt16(0))
+		return
+	}
+
+	if pv.Type() != TypeTranslationPage || pv.NextPage() != 5 || pv.PrevPage() != 3 {
+		t.Error("Unexpected page view:", pv)
+		return
+	}
+
+	// Checksum is only validated on clean records
+
+	r.ClearDirty()
+	r.SetPageView(nil)
+
+	if pv = GetPageView(r); pv.NextPage() != 5 {
+		t.Error("Unexpected next page")
+		return
+	}
+
+	pv.SetType(TypeDataPage)
+
+	if r.ReadInt16(0) != 0x3991 {
+		t.Error("Unexpected header value:", r.ReadInt16(0))
+		return
+	}
+
+	UpdateChecksum(r, true)
+	r.Data()[30] = 0x12
+	r.ClearDirty()
+	r.SetPageView(nil)
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil || r.(error).Error() != "Checksum mismatch in page 123" {
+				t.Error("Unexpected panic:", r)
+			}
+		}()
+
+		GetPageView(r)
+	}()
+
+	// Remove the checksum
+
+	UpdateChecksum(r, false)
+
+	if r.ReadInt16(0) != 0x1991 || r.ReadUInt64(OffsetNextPage) != 5 ||
+		r.ReadUInt64(OffsetPrevPage) != 3 {
+		t.Error("Unexpected record:", r)
+		return
+	}
+
+	if pv = GetPageView(r); pv.Type() != TypeDataPage {
+		t.Error("Unexpected page type")
+		return
+	}
+
+	// Records which are not page views are ignored
+
+	r = file.NewRecord(124, make([]byte, 40))
+	UpdateChecksum(r, true)
+
+	if r.ReadInt16(0) != 0 {
+		t.Error("Unexpected header value:", r.ReadInt16(0))
+		return
+	}
+}
@@ -0,0 +1,137 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"devt.de/eliasdb/lockprof"
+)
+
+/*
+QuotaCheckInterval is the max age of the measured disk usage of a quota.
+Inserts may exceed the limit by the amount of data which is written in this
+time.
+*/
+var QuotaCheckInterval = time.Second
+
+/*
+Quota is a limit for the disk usage of all files in a directory. A quota can
+be shared by several disk storage managers (see SetQuota).
+*/
+type Quota struct {
+	dir      string      // Directory whose disk usage is limited
+	limit    uint64      // Max disk usage in bytes
+	usage    uint64      // Last measured disk usage in bytes
+	measured time.Time   // Time of the last measurement
+	mutex    *sync.Mutex // Mutex to protect the measurement
+}
+
+/*
+NewQuota creates a new quota for the files in a given directory.
+*/
+func NewQuota(dir string, limit uint64) *Quota {
+	return &Quota{dir, limit, 0, time.Time{}, &sync.Mutex{}}
+}
+
+/*
+Limit returns the max disk usage in bytes.
+*/
+func (q *Quota) Limit() uint64 {
+	return q.limit
+}
+
+/*
+Usage measures the current disk usage in bytes.
+*/
+func (q *Quota) Usage() (uint64, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.measure()
+}
+
+/*
+measure measures the disk usage of all files in the quota directory. It is
+assumed that the caller holds the mutex.
+*/
+func (q *Quota) measure() (uint64, error) {
+	var usage uint64
+
+	err := filepath.Walk(q.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Files can be removed during the walk
+			}
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			usage += uint64(info.Size())
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		q.usage = usage
+		q.measured = time.Now()
+	}
+
+	return usage, err
+}
+
+/*
+check returns an error if the disk usage has reached the limit.
+*/
+func (q *Quota) check(sm Manager) error {
+
+	if q == nil {
+		return nil
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	usage := q.usage
+
+	if time.Since(q.measured) >= QuotaCheckInterval {
+		var err error
+
+		if usage, err = q.measure(); err != nil {
+			return err
+		}
+	}
+
+	if usage >= q.limit {
+		return ErrQuotaExceeded.fireError(sm, fmt.Sprintf("Usage: %v bytes - Limit: %v bytes",
+			usage, q.limit))
+	}
+
+	return nil
+}
+
+/*
+SetQuota sets a quota for the disk usage of this storage manager. Inserts
+fail with ErrQuotaExceeded once the disk usage has reached the limit of the
+quota. Updates and removals are still possible so space can be freed. A nil
+value removes the quota.
+*/
+func (bdsm *ByteDiskStorageManager) SetQuota(q *Quota) {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetQuota")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.quota = q
+}
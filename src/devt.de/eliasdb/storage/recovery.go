@@ -0,0 +1,128 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"devt.de/common/fileutil"
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/slotting"
+)
+
+/*
+RecoveryReport describes the automatic recovery of a disk storage manager
+which was not closed properly.
+*/
+type RecoveryReport struct {
+	Lockfile  bool                  // Flag if the lockfile of a previous process was found
+	Discarded map[string]int64      // Discarded bytes of incomplete transactions per transaction log
+	Scrub     *slotting.ScrubReport // Result of the free slot check (nil if it failed)
+}
+
+/*
+String returns a string representation of a RecoveryReport.
+*/
+func (rr *RecoveryReport) String() string {
+	var ret []string
+
+	if rr.Lockfile {
+		ret = append(ret, "Found lockfile of a previous process")
+	}
+
+	logs := make([]string, 0, len(rr.Discarded))
+
+	for log := range rr.Discarded {
+		logs = append(logs, log)
+	}
+
+	sort.Strings(logs)
+
+	for _, log := range logs {
+		ret = append(ret, fmt.Sprintf("Discarded incomplete transactions of %v (%v bytes)",
+			log, rr.Discarded[log]))
+	}
+
+	if rr.Scrub != nil {
+		ret = append(ret, rr.Scrub.String())
+	}
+
+	return strings.Join(ret, "; ")
+}
+
+/*
+Recovery returns the report of the automatic recovery which was run when the
+storage was opened. Returns nil if the storage was closed properly.
+*/
+func (bdsm *ByteDiskStorageManager) Recovery() *RecoveryReport {
+	return bdsm.recovery
+}
+
+/*
+newRecoveryReport creates a new recovery report before the files are opened.
+The storage was not closed properly if the lockfile of a previous process was
+left behind.
+*/
+func (bdsm *ByteDiskStorageManager) newRecoveryReport() *RecoveryReport {
+	rr := &RecoveryReport{false, make(map[string]int64), nil}
+
+	if bdsm.lockfile != nil {
+		rr.Lockfile, _ = fileutil.PathExists(fmt.Sprintf("%v.%v", bdsm.filename, FileSiffixLockfile))
+	}
+
+	return rr
+}
+
+/*
+repairTransactionLogs discards incomplete transactions at the end of the
+transaction logs unless the storage is readonly. Logs end with an incomplete
+transaction if the storage was not closed properly - all complete
+transactions are recovered once the files are opened.
+*/
+func (bdsm *ByteDiskStorageManager) repairTransactionLogs(rr *RecoveryReport) {
+	if bdsm.transDisabled || bdsm.readonly {
+		return
+	}
+
+	for _, suffix := range []string{FileSuffixPhysicalSlots, FileSuffixPhysicalFreeSlots,
+		FileSuffixBlobSlots, FileSuffixLogicalSlots, FileSuffixLogicalFreeSlots} {
+
+		// Errors are ignored here - they are reported when the file is opened
+
+		name := fmt.Sprintf("%v.%v", bdsm.filename, suffix)
+
+		if discarded, _ := file.RepairTransactionLog(name); discarded > 0 {
+			rr.Discarded[fmt.Sprintf("%v.%v", name, file.LogFileSuffix)] = discarded
+		}
+	}
+}
+
+/*
+finishRecovery checks the free slot information of a storage which was not
+closed properly once its files are open. Stale free slots are removed unless
+the storage is readonly. The result of the recovery is given to
+file.LogRecovery.
+*/
+func (bdsm *ByteDiskStorageManager) finishRecovery() {
+	rr := bdsm.recovery
+
+	if report, err := bdsm.Scrub(true); err == nil {
+		rr.Scrub = report
+
+		if report.Fixed && report.HasStaleSlots() {
+			bdsm.Flush()
+		}
+	}
+
+	file.LogRecovery(fmt.Sprintf("Recovered %v after an unclean shutdown: %v", bdsm.filename, rr))
+}
@@ -0,0 +1,101 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"devt.de/eliasdb/storage/file"
+)
+
+func TestDiskStorageManagerRecovery(t *testing.T) {
+	var recoveryLog []string
+
+	oldLogRecovery := file.LogRecovery
+	file.LogRecovery = func(v ...interface{}) {
+		recoveryLog = append(recoveryLog, fmt.Sprint(v...))
+	}
+
+	defer func() {
+		file.LogRecovery = oldLogRecovery
+	}()
+
+	dsm := NewDiskStorageManager(DBDIR+"/test23", false, false, false, false)
+
+	if res := dsm.Recovery(); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	loc, _ := dsm.Insert("test")
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Simulate a crash which left the lockfile and an incomplete transaction
+	// behind
+
+	ioutil.WriteFile(DBDIR+"/test23.lck", []byte{0, 0, 0, 0, 0, 0, 0, 1}, 0660)
+
+	logFile, _ := os.OpenFile(DBDIR+"/test23.ix.tlg", os.O_APPEND|os.O_WRONLY, 0660)
+	logFile.Write([]byte{1, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3})
+	logFile.Close()
+
+	recoveryLog = nil
+
+	dsm = NewDiskStorageManager(DBDIR+"/test23", false, false, false, false)
+
+	rr := dsm.Recovery()
+
+	if rr == nil || !rr.Lockfile || rr.Scrub == nil || fmt.Sprint(rr.Discarded) !=
+		"map[storagemanagertest/test23.ix.tlg:11]" {
+		t.Error("Unexpected result:", rr)
+		return
+	}
+
+	if len(recoveryLog) != 1 || recoveryLog[0] != "Recovered storagemanagertest/test23 after an "+
+		"unclean shutdown: Found lockfile of a previous process; Discarded incomplete transactions "+
+		"of storagemanagertest/test23.ix.tlg (11 bytes); Checked 0 free physical slots (0 stale) "+
+		"and 252 free logical slots (0 stale) fixed:false" {
+		t.Error("Unexpected recovery log:", recoveryLog)
+		return
+	}
+
+	// All complete transactions are recovered
+
+	var res string
+
+	if err := dsm.Fetch(loc, &res); err != nil || res != "test" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Storages which were closed properly are opened without recovery
+
+	dsm = NewDiskStorageManager(DBDIR+"/test23", false, false, false, false)
+
+	if res := dsm.Recovery(); res != nil {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	dsm.Close()
+}
@@ -0,0 +1,122 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"devt.de/eliasdb/storage/slotting"
+)
+
+/*
+ScrubLogger is a function which processes scrubber log messages
+*/
+type ScrubLogger func(v ...interface{})
+
+/*
+LogScrub is called with the results of the background scrubber
+*/
+var LogScrub ScrubLogger = func(v ...interface{}) {}
+
+/*
+DefaultScrubInterval is the default time between two runs of the background
+scrubber
+*/
+var DefaultScrubInterval = time.Hour
+
+/*
+scrubber data structure
+*/
+type scrubber struct {
+	stop chan bool       // Channel to stop the scrubber
+	wg   *sync.WaitGroup // Waitgroup for the scrubber goroutine
+}
+
+/*
+Scrub cross-checks the free slot information against the stored data and
+returns a report of all stale free slots. Stale entries are removed if the fix
+flag is set and the storage is not readonly. Removals are part of the current
+transaction.
+*/
+func (bdsm *ByteDiskStorageManager) Scrub(fix bool) (*slotting.ScrubReport, error) {
+	bdsm.checkFileOpen()
+
+	// Continue single threaded from here on
+
+	bdsm.mutex.Lock()
+	defer bdsm.mutex.Unlock()
+
+	return slotting.Scrub(bdsm.logicalSlotManager, bdsm.physicalSlotManager,
+		fix && !bdsm.readonly)
+}
+
+/*
+StartScrubber starts a background goroutine which scrubs the free slot
+information in a given interval (DefaultScrubInterval if the interval is 0).
+The results of every run are given to LogScrub. A running scrubber is
+replaced.
+*/
+func (bdsm *ByteDiskStorageManager) StartScrubber(interval time.Duration, fix bool) {
+	bdsm.StopScrubber()
+
+	if interval == 0 {
+		interval = DefaultScrubInterval
+	}
+
+	s := &scrubber{make(chan bool), &sync.WaitGroup{}}
+
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+
+			case <-ticker.C:
+				report, err := bdsm.Scrub(fix)
+
+				if err != nil {
+					LogScrub(fmt.Sprintf("Scrubbing %v failed: %v", bdsm.filename, err))
+				} else if report.HasStaleSlots() {
+					LogScrub(fmt.Sprintf("Scrubbing %v: %v", bdsm.filename, report))
+				}
+			}
+		}
+	}()
+
+	bdsm.mutex.Lock()
+	bdsm.scrubber = s
+	bdsm.mutex.Unlock()
+}
+
+/*
+StopScrubber stops the background scrubber. Waits until a running scrub has
+finished.
+*/
+func (bdsm *ByteDiskStorageManager) StopScrubber() {
+	bdsm.mutex.Lock()
+	s := bdsm.scrubber
+	bdsm.scrubber = nil
+	bdsm.mutex.Unlock()
+
+	if s != nil {
+		close(s.stop)
+		s.wg.Wait()
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskStorageManagerScrubber(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/test11", false, false, false, true)
+
+	var locs []uint64
+
+	for i := 0; i < 3; i++ {
+		loc, err := dsm.Insert(fmt.Sprint("data", i))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	if report, err := dsm.Scrub(true); err != nil || report.HasStaleSlots() {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// Simulate a crash which left a free physical slot behind which is
+	// still in use
+
+	ploc, _ := dsm.logicalSlotManager.Fetch(locs[1])
+	dsm.physicalSlotManager.Free(ploc)
+
+	messages := make(chan string, 10)
+
+	LogScrub = func(v ...interface{}) {
+		messages <- fmt.Sprint(v...)
+	}
+	defer func() {
+		LogScrub = func(v ...interface{}) {}
+	}()
+
+	dsm.StartScrubber(10*time.Millisecond, true)
+
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "(1 stale)") || !strings.Contains(msg, "fixed:true") {
+			t.Error("Unexpected log message:", msg)
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Scrubber did not report the stale slot")
+		return
+	}
+
+	dsm.StopScrubber()
+	dsm.StopScrubber()
+
+	if report, err := dsm.Scrub(false); err != nil || report.HasStaleSlots() {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	// The slot is not reused for new data
+
+	loc, err := dsm.Insert("data1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if res, _ := dsm.logicalSlotManager.Fetch(loc); res == ploc {
+		t.Error("Slot which is in use should not be reused")
+		return
+	}
+
+	// Close stops a running scrubber
+
+	dsm.StartScrubber(0, false)
+
+	if err := dsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import "sync"
+
+/*
+SharedCache is an object cache which is shared by several
+CachedDiskStorageManager objects. The cache limits the total number of
+objects which are cached by all attached storage managers. Once the cache is
+full an object of the storage manager which holds the most objects is evicted.
+A storage manager which is used a lot can use all space which is not needed
+by the other storage managers but it cannot push their objects out of the
+cache once they hold less objects than itself. All attached storage managers
+use the lock of the shared cache for their cache operations.
+*/
+type SharedCache struct {
+	mutex      *sync.Mutex                 // Mutex which protects all attached caches
+	maxObjects int                         // Max number of objects in all attached caches
+	managers   []*CachedDiskStorageManager // Attached storage managers
+}
+
+/*
+NewSharedCache creates a new shared cache which holds a given number of
+objects.
+*/
+func NewSharedCache(maxObjects int) *SharedCache {
+	return &SharedCache{&sync.Mutex{}, maxObjects, make([]*CachedDiskStorageManager, 0)}
+}
+
+/*
+MaxObjects returns the max number of objects in the shared cache.
+*/
+func (sc *SharedCache) MaxObjects() int {
+	return sc.maxObjects
+}
+
+/*
+Objects returns the number of objects in the shared cache.
+*/
+func (sc *SharedCache) Objects() int {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	return sc.objects()
+}
+
+/*
+Managers returns the number of attached storage managers.
+*/
+func (sc *SharedCache) Managers() int {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	return len(sc.managers)
+}
+
+/*
+objects returns the number of objects in the shared cache. Assumes that the
+lock is held.
+*/
+func (sc *SharedCache) objects() int {
+	count := 0
+
+	for _, cdsm := range sc.managers {
+		count += len(cdsm.cache)
+	}
+
+	return count
+}
+
+/*
+attach adds a storage manager to the shared cache.
+*/
+func (sc *SharedCache) attach(cdsm *CachedDiskStorageManager) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.managers = append(sc.managers, cdsm)
+}
+
+/*
+detach removes a storage manager from the shared cache. Assumes that the lock
+is held.
+*/
+func (sc *SharedCache) detach(cdsm *CachedDiskStorageManager) {
+	for i, m := range sc.managers {
+		if m == cdsm {
+			sc.managers = append(sc.managers[:i], sc.managers[i+1:]...)
+			return
+		}
+	}
+}
+
+/*
+victim returns the storage manager whose cache should lose an object so a
+given storage manager can add a new object. This is the storage manager which
+holds the most objects. The requesting storage manager is preferred if several
+storage managers hold the same number of objects. Assumes that the lock is
+held.
+*/
+func (sc *SharedCache) victim(cdsm *CachedDiskStorageManager) *CachedDiskStorageManager {
+	ret := cdsm
+
+	for _, m := range sc.managers {
+		if len(m.cache) > len(ret.cache) {
+			ret = m
+		}
+	}
+
+	return ret
+}
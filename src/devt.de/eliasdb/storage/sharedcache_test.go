@@ -0,0 +1,107 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSharedCache(t *testing.T) {
+	var ret string
+
+	sc := NewSharedCache(4)
+
+	cdsm1 := NewSharedCachedDiskStorageManager(NewDiskStorageManager(DBDIR+"/ctest11a", false, false, true, true), sc)
+	cdsm2 := NewSharedCachedDiskStorageManager(NewDiskStorageManager(DBDIR+"/ctest11b", false, false, true, true), sc)
+
+	if cdsm1.SharedCache() != sc || sc.MaxObjects() != 4 || sc.Managers() != 2 {
+		t.Error("Unexpected state:", cdsm1.SharedCache(), sc.MaxObjects(), sc.Managers())
+		return
+	}
+
+	// The first storage manager can use the whole cache
+
+	var locs1 []uint64
+
+	for i := 0; i < 4; i++ {
+		loc, _ := cdsm1.Insert(fmt.Sprint("test", i))
+		locs1 = append(locs1, loc)
+	}
+
+	if len(cdsm1.cache) != 4 || sc.Objects() != 4 {
+		t.Error("Unexpected state:", len(cdsm1.cache), sc.Objects())
+		return
+	}
+
+	// The second storage manager takes space from the first one until both
+	// hold the same number of objects
+
+	for i := 0; i < 3; i++ {
+		cdsm2.Insert(fmt.Sprint("test", i))
+	}
+
+	if len(cdsm1.cache) != 2 || len(cdsm2.cache) != 2 || sc.Objects() != 4 {
+		t.Error("Unexpected state:", len(cdsm1.cache), len(cdsm2.cache), sc.Objects())
+		return
+	}
+
+	if s := cdsm1.Stats(); s.Evictions != 2 {
+		t.Error("Unexpected stats:", s)
+		return
+	}
+
+	if s := cdsm2.Stats(); s.Evictions != 1 {
+		t.Error("Unexpected stats:", s)
+		return
+	}
+
+	// The least recently used objects of the first storage manager were evicted
+
+	if _, err := cdsm1.FetchCached(locs1[1]); err != ErrNotInCache {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := cdsm1.Fetch(locs1[3], &ret); err != nil || ret != "test3" {
+		t.Error("Unexpected result:", ret, err)
+		return
+	}
+
+	// Closing a storage manager gives its space to the other storage managers
+
+	if err := cdsm2.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if sc.Objects() != 2 || sc.Managers() != 1 {
+		t.Error("Unexpected state:", sc.Objects(), sc.Managers())
+		return
+	}
+
+	for _, loc := range locs1 {
+		if err := cdsm1.Fetch(loc, &ret); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if len(cdsm1.cache) != 4 {
+		t.Error("Unexpected state:", len(cdsm1.cache))
+		return
+	}
+
+	if err := cdsm1.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"errors"
+	"io"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging"
+	"devt.de/eliasdb/storage/paging/view"
+	"devt.de/eliasdb/storage/slotting/pageview"
+	"devt.de/eliasdb/storage/util"
+)
+
+/*
+ErrNoBlobStorage is returned if a large object location is accessed without
+a storage for large objects
+*/
+var ErrNoBlobStorage = errors.New("No storage for large objects available")
+
+/*
+BlobManager data structure. A BlobManager stores large objects as chains of
+chunk pages in a dedicated PagedStorageFile. Keeping large objects out of the
+normal data pages avoids big slots which are hard to reuse. Pages of removed
+objects are put on the free list of the dedicated file and are only reused
+for other large objects.
+*/
+type BlobManager struct {
+	storagefile *file.StorageFile        // StorageFile which is wrapped
+	pager       *paging.PagedStorageFile // Pager for StorageFile
+}
+
+/*
+NewBlobManager creates a new object to manage large objects in a given
+PagedStorageFile.
+*/
+func NewBlobManager(bpsf *paging.PagedStorageFile) *BlobManager {
+	return &BlobManager{bpsf.StorageFile(), bpsf}
+}
+
+/*
+IsBlobLocation checks if a given physical location points to a large object.
+Large object locations point to the first chunk page and have no offset.
+*/
+func IsBlobLocation(location uint64) bool {
+	return location != 0 && util.LocationOffset(location) == 0
+}
+
+/*
+Insert stores a new large object and returns its location.
+*/
+func (bm *BlobManager) Insert(data []byte, start uint32, length uint32) (uint64, error) {
+
+	page, err := bm.pager.AllocatePage(view.TypeDataPage)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := bm.write(page, data[start:start+length]); err != nil {
+		bm.freeChain(page)
+		return 0, err
+	}
+
+	return util.PackLocation(page, 0), nil
+}
+
+/*
+Update replaces the data of a large object. The pages of the object are
+reused - missing pages are allocated and pages which are no longer needed
+are freed. The location of the object does not change.
+*/
+func (bm *BlobManager) Update(location uint64, data []byte, start uint32, length uint32) error {
+	return bm.write(util.LocationRecord(location), data[start:start+length])
+}
+
+/*
+Fetch writes the data of a large object to a given writer.
+*/
+func (bm *BlobManager) Fetch(location uint64, writer io.Writer) error {
+
+	for page := util.LocationRecord(location); page != 0; {
+
+		record, err := bm.storagefile.Get(page)
+		if err != nil {
+			return err
+		}
+
+		bp := pageview.NewBlobPage(record)
+
+		writer.Write(bp.ChunkData())
+
+		next := bp.NextChunk()
+
+		bm.storagefile.ReleaseInUseID(page, false)
+
+		page = next
+	}
+
+	return nil
+}
+
+/*
+Size returns the size of the data of a large object and the size which is
+allocated for it.
+*/
+func (bm *BlobManager) Size(location uint64) (uint32, uint32, error) {
+	var size, available uint32
+
+	for page := util.LocationRecord(location); page != 0; {
+
+		record, err := bm.storagefile.Get(page)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		bp := pageview.NewBlobPage(record)
+
+		size += bp.ChunkSize()
+		available += bp.ChunkSpace()
+
+		next := bp.NextChunk()
+
+		bm.storagefile.ReleaseInUseID(page, false)
+
+		page = next
+	}
+
+	return size, available, nil
+}
+
+/*
+Free frees all pages of a large object.
+*/
+func (bm *BlobManager) Free(location uint64) error {
+	return bm.freeChain(util.LocationRecord(location))
+}
+
+/*
+write writes data to the chain of chunk pages which starts with a given page.
+*/
+func (bm *BlobManager) write(page uint64, data []byte) error {
+
+	for {
+		record, err := bm.storagefile.Get(page)
+		if err != nil {
+			return err
+		}
+
+		bp := pageview.NewBlobPage(record)
+
+		toCopy := bp.ChunkSpace()
+		if uint32(len(data)) < toCopy {
+			toCopy = uint32(len(data))
+		}
+
+		bp.SetChunkData(data[:toCopy])
+		data = data[toCopy:]
+
+		next := bp.NextChunk()
+
+		if len(data) == 0 {
+			bp.SetNextChunk(0)
+		}
+
+		bm.storagefile.ReleaseInUseID(page, true)
+
+		if len(data) == 0 {
+
+			// Free the remaining pages if the data got smaller
+
+			return bm.freeChain(next)
+
+		} else if next == 0 {
+
+			// Allocate a new page if the data got bigger - the current page
+			// needs to be released at this point since the pager links the
+			// new page to it
+
+			if next, err = bm.pager.AllocatePage(view.TypeDataPage); err != nil {
+				return err
+			}
+
+			record, err = bm.storagefile.Get(page)
+			if err != nil {
+				return err
+			}
+
+			pageview.NewBlobPage(record).SetNextChunk(next)
+
+			bm.storagefile.ReleaseInUseID(page, true)
+		}
+
+		page = next
+	}
+}
+
+/*
+freeChain returns all pages of a chain of chunk pages to the free list.
+*/
+func (bm *BlobManager) freeChain(page uint64) error {
+
+	for page != 0 {
+
+		record, err := bm.storagefile.Get(page)
+		if err != nil {
+			return err
+		}
+
+		next := pageview.NewBlobPage(record).NextChunk()
+
+		bm.storagefile.ReleaseInUseID(page, false)
+
+		if err := bm.pager.FreePage(page); err != nil {
+			return err
+		}
+
+		page = next
+	}
+
+	return nil
+}
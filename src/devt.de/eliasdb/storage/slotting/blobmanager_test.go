@@ -0,0 +1,194 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"bytes"
+	"testing"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging"
+	"devt.de/eliasdb/storage/slotting/pageview"
+	"devt.de/eliasdb/storage/util"
+)
+
+func TestBlobManager(t *testing.T) {
+	var pagers []*paging.PagedStorageFile
+
+	for _, name := range []string{"test18_data", "test18_free", "test18_blob"} {
+		sf, err := file.NewDefaultStorageFile(DBDIR+"/"+name, false)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		psf, err := paging.NewPagedStorageFile(sf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		pagers = append(pagers, psf)
+	}
+
+	defer func() {
+		for _, psf := range pagers {
+			if err := psf.Close(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	psm := NewPhysicalSlotManager(pagers[0], pagers[1], false)
+
+	small := []byte("small")
+	large := bytes.Repeat([]byte("abcdefgh"), 5000)
+	chunkSpace := pagers[2].StorageFile().RecordSize() - pageview.OffsetChunkData
+
+	smallLoc, err := psm.Insert(small, 0, uint32(len(small)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Without a blob manager all data is stored in physical slots
+
+	loc, err := psm.Insert(large, 0, uint32(len(large)))
+	if err != nil || IsBlobLocation(loc) {
+		t.Error("Unexpected result:", loc, err)
+		return
+	}
+
+	if err := psm.Free(util.PackLocation(1, 0)); err != ErrNoBlobStorage {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	psm.Free(loc)
+
+	bm := NewBlobManager(pagers[2])
+	psm.SetBlobStorage(bm, 1000)
+
+	loc, err = psm.Insert(large, 0, uint32(len(large)))
+	if err != nil || !IsBlobLocation(loc) || IsBlobLocation(smallLoc) {
+		t.Error("Unexpected result:", loc, err)
+		return
+	}
+
+	checkData := func(loc uint64, expected []byte) bool {
+		var buf bytes.Buffer
+
+		if err := psm.Fetch(loc, &buf); err != nil || !bytes.Equal(buf.Bytes(), expected) {
+			t.Error("Unexpected data:", buf.Len(), len(expected), err)
+			return false
+		}
+
+		return true
+	}
+
+	if !checkData(loc, large) || !checkData(smallLoc, small) {
+		return
+	}
+
+	pages := (uint32(len(large)) + chunkSpace - 1) / chunkSpace
+
+	if size, available, err := psm.SlotSize(loc); err != nil || size != uint32(len(large)) ||
+		available != pages*chunkSpace {
+		t.Error("Unexpected size:", size, available, err)
+		return
+	}
+
+	// Large objects are not stored in the data file
+
+	if stats, _ := pagers[2].Stats(); stats.DataPages != int(pages) {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	// Updates keep the location and reuse the pages
+
+	larger := append(large, large...)
+
+	if newLoc, err := psm.Update(loc, larger, 0, uint32(len(larger))); err != nil || newLoc != loc ||
+		!checkData(loc, larger) {
+		t.Error("Unexpected result:", newLoc, err)
+		return
+	}
+
+	smaller := large[:2000]
+
+	if newLoc, err := psm.Update(loc, smaller, 0, uint32(len(smaller))); err != nil || newLoc != loc ||
+		!checkData(loc, smaller) {
+		t.Error("Unexpected result:", newLoc, err)
+		return
+	}
+
+	if stats, _ := pagers[2].Stats(); stats.DataPages != 1 || stats.FreePages == 0 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	// Data which becomes small is moved to a physical slot and back
+
+	newLoc, err := psm.Update(loc, small, 0, uint32(len(small)))
+	if err != nil || IsBlobLocation(newLoc) || !checkData(newLoc, small) {
+		t.Error("Unexpected result:", newLoc, err)
+		return
+	}
+
+	if newLoc, err = psm.Update(newLoc, large, 0, uint32(len(large))); err != nil ||
+		!IsBlobLocation(newLoc) || !checkData(newLoc, large) {
+		t.Error("Unexpected result:", newLoc, err)
+		return
+	}
+
+	// Freed pages are reused by new large objects
+
+	if err := psm.Free(newLoc); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if stats, _ := pagers[2].Stats(); stats.DataPages != 0 {
+		t.Error("Unexpected stats:", stats)
+		return
+	}
+
+	total := pagers[2].Last(0)
+
+	if loc, err = psm.Insert(large, 0, uint32(len(large))); err != nil || pagers[2].Last(0) != total {
+		t.Error("Unexpected result:", loc, err, total, pagers[2].Last(0))
+		return
+	}
+
+	// Large objects are not moved by compaction
+
+	newLocs, err := psm.Compact([]uint64{loc, smallLoc})
+	if err != nil || newLocs[0] != loc || !checkData(newLocs[0], large) || !checkData(newLocs[1], small) {
+		t.Error("Unexpected result:", newLocs, err)
+		return
+	}
+
+	if _, err := psm.NewSlotReader(loc); err != ErrBlobStream {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// A threshold of 0 stores new data in physical slots
+
+	psm.SetBlobStorage(bm, 0)
+
+	if newLoc, err = psm.Insert(large, 0, uint32(len(large))); err != nil || IsBlobLocation(newLoc) ||
+		!checkData(loc, large) {
+		t.Error("Unexpected result:", newLoc, err)
+		return
+	}
+}
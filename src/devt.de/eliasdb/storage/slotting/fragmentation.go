@@ -0,0 +1,186 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+
+	"devt.de/eliasdb/storage/paging"
+	"devt.de/eliasdb/storage/paging/view"
+	"devt.de/eliasdb/storage/slotting/pageview"
+)
+
+/*
+FragmentationBucket holds the number of free slots which have a size
+within a certain range.
+*/
+type FragmentationBucket struct {
+	MinSize uint32 // Minimum slot size of this bucket (inclusive)
+	MaxSize uint32 // Maximum slot size of this bucket (inclusive)
+	Count   uint64 // Number of free slots in this bucket
+	Bytes   uint64 // Combined size of all free slots in this bucket
+}
+
+/*
+FragmentationStats contains statistics about the free space of a
+PhysicalSlotManager.
+*/
+type FragmentationStats struct {
+	DataPages   uint64                 // Number of data pages
+	DataBytes   uint64                 // Space on all data pages which can hold slot data
+	FreeSlots   uint64                 // Number of free slots
+	FreeBytes   uint64                 // Combined size of all free slots
+	WasteBytes  uint64                 // Estimated free space which is unlikely to be reused
+	LargestFree uint32                 // Size of the biggest free slot
+	Histogram   []*FragmentationBucket // Free slot sizes in power of two buckets
+}
+
+/*
+FreeRatio returns the fraction of the data space which is held by free slots.
+*/
+func (fs *FragmentationStats) FreeRatio() float64 {
+	if fs.DataBytes == 0 {
+		return 0
+	}
+	return float64(fs.FreeBytes) / float64(fs.DataBytes)
+}
+
+/*
+String returns a string representation of the statistics.
+*/
+func (fs *FragmentationStats) String() string {
+	var buf bytes.Buffer
+
+	buf.WriteString(fmt.Sprintf("Data pages: %v (%v bytes)\n", fs.DataPages, fs.DataBytes))
+	buf.WriteString(fmt.Sprintf("Free slots: %v (%v bytes, %.2f%%)\n", fs.FreeSlots,
+		fs.FreeBytes, fs.FreeRatio()*100))
+	buf.WriteString(fmt.Sprintf("Largest free slot: %v bytes\n", fs.LargestFree))
+	buf.WriteString(fmt.Sprintf("Estimated waste: %v bytes\n", fs.WasteBytes))
+
+	for _, b := range fs.Histogram {
+		buf.WriteString(fmt.Sprintf("  %v - %v: %v (%v bytes)\n", b.MinSize,
+			b.MaxSize, b.Count, b.Bytes))
+	}
+
+	return buf.String()
+}
+
+/*
+add records a free slot of a given size. Slots smaller than the waste
+threshold are counted as waste.
+*/
+func (fs *FragmentationStats) add(size uint32, wasteThreshold uint32) {
+	fs.FreeSlots++
+	fs.FreeBytes += uint64(size)
+
+	if size < wasteThreshold {
+		fs.WasteBytes += uint64(size)
+	}
+
+	if size > fs.LargestFree {
+		fs.LargestFree = size
+	}
+
+	// Find the bucket for the size - bucket i holds sizes from 2^i to 2^(i+1)-1
+
+	i := bits.Len32(size) - 1
+
+	for len(fs.Histogram) <= i {
+		min := uint32(1) << uint(len(fs.Histogram))
+		fs.Histogram = append(fs.Histogram, &FragmentationBucket{min, min<<1 - 1, 0, 0})
+	}
+
+	fs.Histogram[i].Count++
+	fs.Histogram[i].Bytes += uint64(size)
+}
+
+/*
+FragmentationStats collects statistics about the free slots of this
+PhysicalSlotManager. The returned histogram groups free slot sizes into power
+of two buckets - empty buckets at the end are omitted. Free slots which are
+smaller than the optimal waste margin are counted as waste since allocations
+rarely fit into them; they can only be reclaimed by compaction.
+*/
+func (psm *PhysicalSlotManager) FragmentationStats() (*FragmentationStats, error) {
+	fs := &FragmentationStats{}
+
+	cursor := paging.NewPageCursor(psm.pager, view.TypeDataPage, 0)
+
+	page, err := cursor.Next()
+	for page != 0 {
+		fs.DataPages++
+
+		if page, err = cursor.Next(); err != nil {
+			return nil, err
+		}
+	}
+
+	fs.DataBytes = fs.DataPages * uint64(psm.availableRecordSize)
+
+	err = psm.freeManager.forEachFreeSlot(func(loc uint64, size uint32) {
+		fs.add(size, psm.freeManager.wasteThreshold())
+	})
+
+	return fs, err
+}
+
+/*
+forEachFreeSlot calls a given function with the location and size of every
+free slot. This includes slots which have not been flushed yet.
+*/
+func (fpsm *FreePhysicalSlotManager) forEachFreeSlot(f func(loc uint64, size uint32)) error {
+
+	for i, loc := range fpsm.slots {
+		f(loc, fpsm.sizes[i])
+	}
+
+	cursor := paging.NewPageCursor(fpsm.pager, view.TypeFreePhysicalSlotPage, 0)
+
+	// No need for error checking on cursor next since all pages will be opened
+	// via Get calls in the loop.
+
+	page, _ := cursor.Next()
+	for page != 0 {
+
+		record, err := fpsm.storagefile.Get(page)
+		if err != nil {
+			return err
+		}
+
+		fpsp := fpsm.freeSlotPage(page, record)
+
+		var i uint16
+
+		for i = 0; i < fpsp.MaxSlots(); i++ {
+			if size := fpsp.SlotInfoFreeSize(i); size > 0 {
+				f(fpsp.SlotInfoLocation(i), size)
+			}
+		}
+
+		fpsm.storagefile.ReleaseInUseID(page, false)
+
+		page, _ = cursor.Next()
+	}
+
+	return nil
+}
+
+/*
+wasteThreshold returns the size below which a free slot is considered waste.
+*/
+func (fpsm *FreePhysicalSlotManager) wasteThreshold() uint32 {
+	if fpsm.optimalWaste == 0 {
+		return pageview.OptimalWasteMargin
+	}
+	return fpsm.optimalWaste
+}
@@ -0,0 +1,142 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"testing"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging"
+)
+
+func TestFragmentationStats(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test16_data", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := paging.NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fsf, err := file.NewDefaultStorageFile(DBDIR+"/test16_free", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	fpsf, err := paging.NewPagedStorageFile(fsf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	psm := NewPhysicalSlotManager(psf, fpsf, false)
+
+	fs, err := psm.FragmentationStats()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fs.DataPages != 0 || fs.FreeSlots != 0 || fs.FreeRatio() != 0 || len(fs.Histogram) != 0 {
+		t.Error("Unexpected result:", fs)
+		return
+	}
+
+	var locs []uint64
+
+	for _, size := range []int{10, 20, 100, 20, 300, 20, 1000, 20} {
+		loc, err := psm.Insert(make([]byte, size), 0, uint32(size))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	// Free slots which are not adjacent - the first two are flushed the
+	// last two are still pending
+
+	psm.Free(locs[0])
+	psm.Free(locs[4])
+
+	if err := psm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	psm.Free(locs[2])
+	psm.Free(locs[6])
+
+	fs, err = psm.FragmentationStats()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fs.DataPages != 1 || fs.DataBytes != uint64(psm.availableRecordSize) {
+		t.Error("Unexpected result:", fs)
+		return
+	}
+
+	if fs.FreeSlots != 4 || fs.FreeBytes != 1410 || fs.LargestFree != 1000 {
+		t.Error("Unexpected result:", fs)
+		return
+	}
+
+	// Slots of 10 and 100 bytes are below the default optimal waste margin
+
+	if fs.WasteBytes != 110 {
+		t.Error("Unexpected result:", fs)
+		return
+	}
+
+	if len(fs.Histogram) != 10 {
+		t.Error("Unexpected result:", fs)
+		return
+	}
+
+	for i, expected := range map[int]uint64{3: 1, 6: 1, 8: 1, 9: 1} {
+		if b := fs.Histogram[i]; b.Count != expected || b.MinSize != 1<<uint(i) ||
+			b.MaxSize != 1<<uint(i+1)-1 {
+			t.Error("Unexpected bucket:", i, b)
+			return
+		}
+	}
+
+	if fs.Histogram[0].Count != 0 || fs.Histogram[9].Bytes != 1000 {
+		t.Error("Unexpected result:", fs)
+		return
+	}
+
+	// A bigger waste margin counts more slots as waste
+
+	psm.SetWasteMargins(500, 0)
+
+	if fs, _ = psm.FragmentationStats(); fs.WasteBytes != 410 {
+		t.Error("Unexpected result:", fs)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := fpsf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
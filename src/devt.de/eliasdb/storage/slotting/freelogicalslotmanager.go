@@ -46,16 +46,35 @@ import (
 FreeLogicalSlotManager data structure
 */
 type FreeLogicalSlotManager struct {
-	storagefile *file.StorageFile        // StorageFile which is wrapped
-	pager       *paging.PagedStorageFile // Pager for StorageFile
-	slots       []uint64                 // List of free slots
+	storagefile    *file.StorageFile        // StorageFile which is wrapped
+	pager          *paging.PagedStorageFile // Pager for StorageFile
+	slots          []uint64                 // List of free slots
+	slotsPerRecord uint16                   // Slots per translation page (0 if bitmaps are not used)
 }
 
 /*
 NewFreeLogicalSlotManager creates a new object to manage free logical slots.
 */
 func NewFreeLogicalSlotManager(psf *paging.PagedStorageFile) *FreeLogicalSlotManager {
-	return &FreeLogicalSlotManager{psf.StorageFile(), psf, make([]uint64, 0)}
+	return &FreeLogicalSlotManager{psf.StorageFile(), psf, make([]uint64, 0), 0}
+}
+
+/*
+UseBitmaps stores free slots as bitmaps if all free slots are on translation
+pages with a given number of slots. Pages with the original layout are
+migrated when they are read.
+*/
+func (flsm *FreeLogicalSlotManager) UseBitmaps(slotsPerRecord uint16) {
+	flsm.slotsPerRecord = slotsPerRecord
+}
+
+/*
+isBitmapPage checks if a given page record uses the bitmap layout. Pages with
+the original layout are migrated if bitmaps are used.
+*/
+func (flsm *FreeLogicalSlotManager) isBitmapPage(record *file.Record) bool {
+	return flsm.slotsPerRecord != 0 &&
+		pageview.MigrateFreeLogicalSlotPage(record, flsm.slotsPerRecord)
 }
 
 /*
@@ -85,6 +104,37 @@ func (flsm *FreeLogicalSlotManager) Get() (uint64, error) {
 			return 0, err
 		}
 
+		if flsm.isBitmapPage(record) {
+
+			bp := pageview.NewFreeLogicalSlotBitmapPage(record, flsm.slotsPerRecord)
+
+			if loc := bp.TakeFirstSlot(); loc != 0 {
+
+				if bp.FreeSlotCount() == 0 {
+
+					// Free the page if no free row id slot is left
+
+					flsm.storagefile.ReleaseInUseID(page, false)
+
+					flsm.pager.FreePage(page)
+
+				} else {
+
+					flsm.storagefile.ReleaseInUseID(page, true)
+				}
+
+				return loc, nil
+			}
+
+			// A migration may have changed the record
+
+			flsm.storagefile.ReleaseInUseID(page, record.Dirty())
+
+			page, _ = cursor.Next()
+
+			continue
+		}
+
 		flsp := pageview.NewFreeLogicalSlotPage(record)
 
 		slot := flsp.FirstAllocatedSlotInfo()
@@ -209,6 +259,30 @@ func (flsm *FreeLogicalSlotManager) doFlush(page uint64, index int) (int, error)
 		return index, err
 	}
 
+	if flsm.isBitmapPage(r) {
+
+		bp := pageview.NewFreeLogicalSlotBitmapPage(r, flsm.slotsPerRecord)
+
+		// Add slots until the page is full
+
+		for ; index < len(flsm.slots); index++ {
+			loc := flsm.slots[index]
+
+			if !pageview.IsBitmapSlot(loc, flsm.slotsPerRecord) {
+				flsm.storagefile.ReleaseInUseID(page, true)
+
+				return index, fmt.Errorf("Free logical slot %v is not on a translation page", loc)
+
+			} else if !bp.AddSlot(loc) {
+				break
+			}
+		}
+
+		flsm.storagefile.ReleaseInUseID(page, true)
+
+		return index, nil
+	}
+
 	flsp := pageview.NewFreeLogicalSlotPage(r)
 
 	// Iterate all page slots (stop if the page has no more available slots
@@ -11,6 +11,7 @@
 package slotting
 
 import (
+	"errors"
 	"testing"
 
 	"devt.de/eliasdb/storage/file"
@@ -126,7 +127,7 @@ func TestFreeLogicalSlotManager(t *testing.T) {
 		return
 	}
 
-	if err = flsm.Flush(); err != file.ErrAlreadyInUse {
+	if err = flsm.Flush(); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected Get result:", err)
 		return
 	}
@@ -181,7 +182,7 @@ func TestFreeLogicalSlotManager(t *testing.T) {
 	}
 
 	loc, err = flsm.Get()
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -310,12 +311,12 @@ func TestFreeLogiclaSlotManagerScale(t *testing.T) {
 		return
 	}
 
-	if err := flsm.Flush(); err != file.ErrAlreadyInUse {
+	if err := flsm.Flush(); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected flush result:", err)
 		return
 	}
 
-	if i, err := flsm.doFlush(1, 0); i != 0 || err != file.ErrAlreadyInUse {
+	if i, err := flsm.doFlush(1, 0); i != 0 || !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected doFlush result:", i, err)
 		return
 	}
@@ -334,7 +335,7 @@ func TestFreeLogiclaSlotManagerScale(t *testing.T) {
 		return
 	}
 
-	if err := flsm.Flush(); err != file.ErrAlreadyInUse {
+	if err := flsm.Flush(); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected flush result:", err)
 		return
 	}
@@ -439,3 +440,96 @@ func TestFreeLogiclaSlotManagerScale(t *testing.T) {
 		return
 	}
 }
+
+func TestFreeLogicalSlotManagerBitmaps(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test14", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := paging.NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	flsm := NewFreeLogicalSlotManager(psf)
+
+	transLoc := func(record uint64, slot uint16) uint64 {
+		return util.PackLocation(record, pageview.OffsetTransData+slot*util.LocationSize)
+	}
+
+	// Write a page with the original layout
+
+	flsm.Add(transLoc(5, 1))
+	flsm.Add(transLoc(5, 2))
+	flsm.Add(transLoc(6, 0))
+
+	if err := flsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The page is migrated once it is read
+
+	flsm.UseBitmaps(253)
+
+	for _, expected := range []uint64{transLoc(5, 1), transLoc(5, 2), transLoc(6, 0), 0} {
+		if res, err := flsm.Get(); res != expected || err != nil {
+			t.Error("Unexpected Get result", res, expected, err)
+			return
+		}
+	}
+
+	c, err := paging.CountPages(flsm.pager, view.TypeFreeLogicalSlotPage)
+	if c != 0 || err != nil {
+		t.Error("Unexpected counting result:", c, err)
+		return
+	}
+
+	// Add a lot of locations
+
+	for i := 0; i < 30000; i++ {
+		flsm.Add(transLoc(uint64(i/253+1), uint16(i%253)))
+	}
+
+	if err := flsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	c, err = paging.CountPages(flsm.pager, view.TypeFreeLogicalSlotPage)
+	if c != 2 || err != nil {
+		t.Error("Unexpected counting result:", c, err)
+		return
+	}
+
+	for i := 0; i < 30000; i++ {
+		if res, err := flsm.Get(); res != transLoc(uint64(i/253+1), uint16(i%253)) || err != nil {
+			t.Error("Unexpected Get result", util.LocationRecord(res), util.LocationOffset(res), i, err)
+			return
+		}
+	}
+
+	if res, err := flsm.Get(); res != 0 || err != nil {
+		t.Error("Unexpected final Get call result", res, err)
+		return
+	}
+
+	// Locations which are not on a translation page cannot be stored
+
+	flsm.Add(transLoc(7, 1))
+	flsm.Add(util.PackLocation(5, 22))
+
+	if err := flsm.Flush(); err == nil ||
+		err.Error() != "Free logical slot 327702 is not on a translation page" {
+		t.Error("Unexpected flush result:", err)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
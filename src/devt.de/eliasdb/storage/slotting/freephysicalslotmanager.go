@@ -28,20 +28,43 @@ type FreePhysicalSlotManager struct {
 	pager           *paging.PagedStorageFile // Pager for StorageFile
 	onlyAppend      bool                     // Flag for append-only mode
 	lastMaxSlotSize int                      // Last max slot size
+	optimalWaste    uint32                   // Waste which is always accepted (0 for default)
+	maxWaste        uint32                   // Max acceptable waste (0 for default)
+	strategy        int                      // Allocation strategy
+	nextIndex       int                      // Summary position of the last allocation (next-fit)
 	slots           []uint64                 // List of free slots
 	sizes           []uint32                 // List of free slot sizes
+
+	pages   map[uint64]*pageview.FreePhysicalSlotPage // Views of FreePhysicalSlotPages
+	summary *freeSlotSummary                          // Summary of all pages (nil if not built)
+}
+
+/*
+freeSlotSummary stores the size of the biggest free slot of each
+FreePhysicalSlotPage. This allows skipping pages which cannot satisfy a
+request without loading them. The summary also records which page stores
+a free slot.
+*/
+type freeSlotSummary struct {
+	pages     []uint64          // FreePhysicalSlotPages in list order
+	maxSize   map[uint64]uint32 // Biggest free slot size of each page
+	locations map[uint64]uint64 // Pages of all stored free slots
 }
 
 /*
 NewFreePhysicalSlotManager creates a new object to manage free physical slots.
 */
 func NewFreePhysicalSlotManager(psf *paging.PagedStorageFile, onlyAppend bool) *FreePhysicalSlotManager {
-	return &FreePhysicalSlotManager{psf.StorageFile(), psf, onlyAppend, 0,
-		make([]uint64, 0), make([]uint32, 0)}
+	return &FreePhysicalSlotManager{psf.StorageFile(), psf, onlyAppend, 0, 0, 0,
+		pageview.AllocHybrid, 0, make([]uint64, 0), make([]uint32, 0), make(map[uint64]*pageview.FreePhysicalSlotPage), nil}
 }
 
 /*
-Get searches for a free location with the given size.
+Get searches for a free location with the given size. Only pages whose
+biggest free slot is big enough are visited. Pages are searched in list
+order (next-fit starts at the page of the last allocation) and the first
+suitable slot is returned - only best-fit visits all pages to find the
+smallest suitable slot.
 */
 func (fpsm *FreePhysicalSlotManager) Get(size uint32) (uint64, error) {
 
@@ -57,13 +80,35 @@ func (fpsm *FreePhysicalSlotManager) Get(size uint32) (uint64, error) {
 		return 0, nil
 	}
 
-	cursor := paging.NewPageCursor(fpsm.pager, view.TypeFreePhysicalSlotPage, 0)
+	if fpsm.summary == nil {
+		if err := fpsm.buildSummary(); err != nil {
 
-	// No need for error checking on cursor next since all pages will be opened
-	// via Get calls in the loop.
+			// Reset the lastMaxSlotSize since we didn't visit all
+			// FreePhysicalSlotPages
 
-	page, _ := cursor.Next()
-	for page != 0 {
+			fpsm.lastMaxSlotSize = 0
+
+			return 0, err
+		}
+	}
+
+	pages := fpsm.summary.pages
+
+	if fpsm.strategy == pageview.AllocNextFit && fpsm.nextIndex > 0 && fpsm.nextIndex < len(pages) {
+		pages = append(append([]uint64{}, pages[fpsm.nextIndex:]...), pages[:fpsm.nextIndex]...)
+	}
+
+	var bestPage uint64
+	var bestSlot = -1
+	var bestSize uint32
+
+	for _, page := range pages {
+
+		// Skip pages which cannot hold the requested size
+
+		if fpsm.summary.maxSize[page] < size {
+			continue
+		}
 
 		record, err := fpsm.storagefile.Get(page)
 
@@ -77,7 +122,7 @@ func (fpsm *FreePhysicalSlotManager) Get(size uint32) (uint64, error) {
 			return 0, err
 		}
 
-		fpsp := pageview.NewFreePhysicalSlotPage(record)
+		fpsp := fpsm.freeSlotPage(page, record)
 
 		slot := fpsp.FindSlot(size)
 
@@ -85,32 +130,98 @@ func (fpsm *FreePhysicalSlotManager) Get(size uint32) (uint64, error) {
 
 		if slot >= 0 {
 
-			// Return a found slot and free the free page if necessary
+			if fpsm.strategy != pageview.AllocBestFit {
+				return fpsm.allocateSlot(page, fpsp, uint16(slot)), nil
+			}
+
+			// Remember the smallest slot - a slot which fits exactly cannot
+			// be improved on
+
+			if slotSize := fpsp.SlotInfoFreeSize(uint16(slot)); bestSlot == -1 || slotSize < bestSize {
+				bestPage, bestSlot, bestSize = page, slot, slotSize
+			}
+
+			fpsm.storagefile.ReleaseInUseID(page, false)
+
+			if bestSize == size {
+				break
+			}
+
+			continue
+		}
+
+		fpsm.summary.set(page, uint32(-slot))
 
+		fpsm.storagefile.ReleaseInUseID(page, false)
+	}
+
+	if bestSlot != -1 {
+
+		record, err := fpsm.storagefile.Get(bestPage)
+		if err != nil {
 			fpsm.lastMaxSlotSize = 0
-			loc := fpsp.SlotInfoLocation(uint16(slot))
+			return 0, err
+		}
 
-			// Release slot
+		return fpsm.allocateSlot(bestPage, fpsm.freeSlotPage(bestPage, record), uint16(bestSlot)), nil
+	}
 
-			fpsp.ReleaseSlotInfo(uint16(slot))
+	fpsm.lastMaxSlotSize = int(fpsm.summary.max())
 
-			if fpsp.FreeSlotCount() == 0 {
+	return 0, nil
+}
 
-				// Free the page if no free slot is stored
+/*
+allocateSlot removes a found slot from a FreePhysicalSlotPage and returns its
+location. The record of the page must be in use and is released by this function.
+*/
+func (fpsm *FreePhysicalSlotManager) allocateSlot(page uint64,
+	fpsp *pageview.FreePhysicalSlotPage, slot uint16) uint64 {
 
-				fpsm.storagefile.ReleaseInUseID(page, false)
-				fpsm.pager.FreePage(page)
+	fpsm.lastMaxSlotSize = 0
+	loc := fpsp.SlotInfoLocation(slot)
 
-			} else {
+	// Remember where the next search should start for next-fit
 
-				fpsm.storagefile.ReleaseInUseID(page, false)
-			}
+	for i, p := range fpsm.summary.pages {
+		if p == page {
+			fpsm.nextIndex = i
+			break
+		}
+	}
+
+	// Release slot and free the free page if necessary
+
+	fpsm.releaseSlot(page, fpsp, slot)
+
+	return loc
+}
+
+/*
+buildSummary builds the summary of all FreePhysicalSlotPages.
+*/
+func (fpsm *FreePhysicalSlotManager) buildSummary() error {
+	summary := &freeSlotSummary{nil, make(map[uint64]uint32), make(map[uint64]uint64)}
 
-			return loc, nil
+	cursor := paging.NewPageCursor(fpsm.pager, view.TypeFreePhysicalSlotPage, 0)
+
+	// No need for error checking on cursor next since all pages will be opened
+	// via Get calls in the loop.
+
+	page, _ := cursor.Next()
+	for page != 0 {
+
+		record, err := fpsm.storagefile.Get(page)
+		if err != nil {
+			return err
 		}
 
-		if fpsm.lastMaxSlotSize < -slot {
-			fpsm.lastMaxSlotSize = -slot
+		fpsp := fpsm.freeSlotPage(page, record)
+
+		summary.set(page, fpsp.MaxSlotSize())
+
+		for _, loc := range fpsp.FreeSlotLocations() {
+			summary.locations[loc] = page
 		}
 
 		fpsm.storagefile.ReleaseInUseID(page, false)
@@ -118,7 +229,88 @@ func (fpsm *FreePhysicalSlotManager) Get(size uint32) (uint64, error) {
 		page, _ = cursor.Next()
 	}
 
-	return 0, nil
+	fpsm.summary = summary
+
+	return nil
+}
+
+/*
+releaseSlot releases a slotinfo of a given FreePhysicalSlotPage. The page is
+freed if it no longer stores any free slot. The record of the page must be in
+use and is released by this function.
+*/
+func (fpsm *FreePhysicalSlotManager) releaseSlot(page uint64, fpsp *pageview.FreePhysicalSlotPage, slot uint16) {
+
+	delete(fpsm.summary.locations, fpsp.SlotInfoLocation(slot))
+
+	fpsp.ReleaseSlotInfo(slot)
+
+	if fpsp.FreeSlotCount() == 0 {
+
+		// Free the page if no free slot is stored
+
+		delete(fpsm.pages, page)
+		fpsm.summary.remove(page)
+
+		fpsm.storagefile.ReleaseInUseID(page, false)
+		fpsm.pager.FreePage(page)
+
+	} else {
+
+		fpsm.summary.set(page, fpsp.MaxSlotSize())
+
+		fpsm.storagefile.ReleaseInUseID(page, false)
+	}
+}
+
+/*
+Remove removes a given location from the free slot set. Returns the size of
+the removed free slot or 0 if the location is not a known free slot.
+*/
+func (fpsm *FreePhysicalSlotManager) Remove(loc uint64) (uint32, error) {
+
+	// Check slots which were not yet flushed
+
+	for i, slot := range fpsm.slots {
+		if slot == loc {
+			size := fpsm.sizes[i]
+
+			fpsm.slots = append(fpsm.slots[:i], fpsm.slots[i+1:]...)
+			fpsm.sizes = append(fpsm.sizes[:i], fpsm.sizes[i+1:]...)
+
+			return size, nil
+		}
+	}
+
+	if fpsm.summary == nil {
+		if err := fpsm.buildSummary(); err != nil {
+			return 0, err
+		}
+	}
+
+	page, ok := fpsm.summary.locations[loc]
+	if !ok {
+		return 0, nil
+	}
+
+	record, err := fpsm.storagefile.Get(page)
+	if err != nil {
+		return 0, err
+	}
+
+	fpsp := fpsm.freeSlotPage(page, record)
+
+	slot := fpsp.FindSlotInfo(loc)
+	if slot == -1 {
+		fpsm.storagefile.ReleaseInUseID(page, false)
+		return 0, nil
+	}
+
+	size := fpsp.SlotInfoFreeSize(uint16(slot))
+
+	fpsm.releaseSlot(page, fpsp, uint16(slot))
+
+	return size, nil
 }
 
 /*
@@ -204,7 +396,7 @@ func (fpsm *FreePhysicalSlotManager) doFlush(page uint64, index int) (int, error
 		return index, err
 	}
 
-	fpsp := pageview.NewFreePhysicalSlotPage(r)
+	fpsp := fpsm.freeSlotPage(page, r)
 
 	// Iterate all page slots (stop if the page has no more available slots
 	// or we reached the end of the page)
@@ -221,15 +413,102 @@ func (fpsm *FreePhysicalSlotManager) doFlush(page uint64, index int) (int, error
 			fpsp.SetSlotInfo(offset, util.LocationRecord(loc), util.LocationOffset(loc))
 			fpsp.SetFreeSlotSize(offset, size)
 
+			if fpsm.summary != nil {
+				fpsm.summary.locations[loc] = page
+			}
+
 			slot = fpsp.FirstFreeSlotInfo()
 		}
 	}
 
+	if fpsm.summary != nil {
+		fpsm.summary.set(page, fpsp.MaxSlotSize())
+	}
+
+	// Newly stored slots might be bigger than all previously found slots
+
+	fpsm.lastMaxSlotSize = 0
+
 	fpsm.storagefile.ReleaseInUseID(page, true)
 
 	return index, nil
 }
 
+/*
+freeSlotPage returns the view of a FreePhysicalSlotPage. Views are kept so the
+size index of a page can be reused as long as its record is not reloaded.
+*/
+func (fpsm *FreePhysicalSlotManager) freeSlotPage(page uint64, record *file.Record) *pageview.FreePhysicalSlotPage {
+
+	// A record gets a new page view whenever it is read from disk or reused
+
+	fpsp, ok := fpsm.pages[page]
+	if !ok || fpsp.PageView != view.GetPageView(record) {
+		fpsp = pageview.NewFreePhysicalSlotPage(record)
+		fpsp.SetWasteMargins(fpsm.optimalWaste, fpsm.maxWaste)
+		fpsp.SetAllocationStrategy(fpsm.strategy)
+		fpsm.pages[page] = fpsp
+	}
+
+	return fpsp
+}
+
+/*
+clear discards all free slot information and frees all pages which hold it.
+*/
+func (fpsm *FreePhysicalSlotManager) clear() error {
+
+	fpsm.slots = make([]uint64, 0)
+	fpsm.sizes = make([]uint32, 0)
+	fpsm.lastMaxSlotSize = 0
+	fpsm.pages = make(map[uint64]*pageview.FreePhysicalSlotPage)
+	fpsm.summary = nil
+
+	page := fpsm.pager.First(view.TypeFreePhysicalSlotPage)
+	for page != 0 {
+		if err := fpsm.pager.FreePage(page); err != nil {
+			return err
+		}
+		page = fpsm.pager.First(view.TypeFreePhysicalSlotPage)
+	}
+
+	return nil
+}
+
+/*
+setWasteMargins sets the allocation waste margins of all FreePhysicalSlotPages.
+*/
+func (fpsm *FreePhysicalSlotManager) setWasteMargins(optimal uint32, maxAcceptable uint32) {
+	fpsm.optimalWaste = optimal
+	fpsm.maxWaste = maxAcceptable
+
+	for _, fpsp := range fpsm.pages {
+		fpsp.SetWasteMargins(optimal, maxAcceptable)
+	}
+}
+
+/*
+setAllocationStrategy sets the allocation strategy of all FreePhysicalSlotPages.
+*/
+func (fpsm *FreePhysicalSlotManager) setAllocationStrategy(strategy int) {
+	fpsm.strategy = strategy
+	fpsm.nextIndex = 0
+
+	for _, fpsp := range fpsm.pages {
+		fpsp.SetAllocationStrategy(strategy)
+	}
+}
+
+/*
+reset discards all information which was derived from the stored
+FreePhysicalSlotPages.
+*/
+func (fpsm *FreePhysicalSlotManager) reset() {
+	fpsm.lastMaxSlotSize = 0
+	fpsm.pages = make(map[uint64]*pageview.FreePhysicalSlotPage)
+	fpsm.summary = nil
+}
+
 /*
 String returns a string representation of this FreePhysicalSlotManager.
 */
@@ -237,3 +516,42 @@ func (fpsm *FreePhysicalSlotManager) String() string {
 	return fmt.Sprintf("FreePhysicalSlotManager: %v (onlyAppend:%v lastMaxSlotSize:%v)\nIds  :%v\nSizes:%v",
 		fpsm.storagefile.Name(), fpsm.onlyAppend, fpsm.lastMaxSlotSize, fpsm.slots, fpsm.sizes)
 }
+
+/*
+set sets the biggest free slot size of a page. Unknown pages are added to the
+end of the page list.
+*/
+func (fss *freeSlotSummary) set(page uint64, size uint32) {
+	if _, ok := fss.maxSize[page]; !ok {
+		fss.pages = append(fss.pages, page)
+	}
+	fss.maxSize[page] = size
+}
+
+/*
+remove removes a page from the summary.
+*/
+func (fss *freeSlotSummary) remove(page uint64) {
+	for i, p := range fss.pages {
+		if p == page {
+			fss.pages = append(fss.pages[:i], fss.pages[i+1:]...)
+			break
+		}
+	}
+	delete(fss.maxSize, page)
+}
+
+/*
+max returns the biggest free slot size of all pages.
+*/
+func (fss *freeSlotSummary) max() uint32 {
+	var ret uint32
+
+	for _, size := range fss.maxSize {
+		if size > ret {
+			ret = size
+		}
+	}
+
+	return ret
+}
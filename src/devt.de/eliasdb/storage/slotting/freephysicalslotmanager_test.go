@@ -11,6 +11,7 @@
 package slotting
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -217,12 +218,12 @@ func TestFreePhysicalSlotManagerScale(t *testing.T) {
 		return
 	}
 
-	if err := fpsm.Flush(); err != file.ErrAlreadyInUse {
+	if err := fpsm.Flush(); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected flush result:", err)
 		return
 	}
 
-	if i, err := fpsm.doFlush(1, 0); i != 0 || err != file.ErrAlreadyInUse {
+	if i, err := fpsm.doFlush(1, 0); i != 0 || !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected doFlush result:", i, err)
 		return
 	}
@@ -241,7 +242,7 @@ func TestFreePhysicalSlotManagerScale(t *testing.T) {
 		return
 	}
 
-	if err := fpsm.Flush(); err != file.ErrAlreadyInUse {
+	if err := fpsm.Flush(); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected flush result:", err)
 		return
 	}
@@ -293,7 +294,7 @@ func TestFreePhysicalSlotManagerScale(t *testing.T) {
 		return
 	}
 
-	if loc, err := fpsm.Get(499); err != file.ErrAlreadyInUse {
+	if loc, err := fpsm.Get(499); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected Get result:", loc, err)
 		return
 	}
@@ -321,7 +322,7 @@ func TestFreePhysicalSlotManagerScale(t *testing.T) {
 		return
 	}
 
-	if loc, err := fpsm.Get(499); err != file.ErrAlreadyInUse {
+	if loc, err := fpsm.Get(499); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected Get result:", loc, err)
 		return
 	}
@@ -356,7 +357,7 @@ func TestFreePhysicalSlotManagerScale(t *testing.T) {
 		return
 	}
 
-	if err := fpsm.Flush(); err != file.ErrAlreadyInUse {
+	if err := fpsm.Flush(); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected Flush result:", err)
 		return
 	}
@@ -386,3 +387,291 @@ func TestFreePhysicalSlotManagerScale(t *testing.T) {
 		return
 	}
 }
+
+func TestFreePhysicalSlotManagerBestFit(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test10", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := paging.NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fpsm := NewFreePhysicalSlotManager(psf, false)
+
+	fpsm.Add(util.PackLocation(5, 20), 300)
+	fpsm.Add(util.PackLocation(6, 21), 200)
+	fpsm.Add(util.PackLocation(7, 22), 250)
+	fpsm.Add(util.PackLocation(8, 23), 200)
+
+	if err := fpsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	page := psf.First(view.TypeFreePhysicalSlotPage)
+	fpsp := fpsm.pages[page]
+
+	if len(fpsm.pages) != 1 || fpsp == nil {
+		t.Error("Unexpected page views:", fpsm.pages)
+		return
+	}
+
+	// The smallest slot which fits should be returned
+
+	if loc, err := fpsm.Get(190); loc != util.PackLocation(6, 21) || err != nil {
+		t.Error("Unexpected Get result:", util.LocationRecord(loc), util.LocationOffset(loc), err)
+		return
+	}
+
+	if loc, err := fpsm.Get(190); loc != util.PackLocation(8, 23) || err != nil {
+		t.Error("Unexpected Get result:", util.LocationRecord(loc), util.LocationOffset(loc), err)
+		return
+	}
+
+	if fpsm.pages[page] != fpsp {
+		t.Error("Page view should have been reused")
+		return
+	}
+
+	// A reloaded record should get a new page view
+
+	record, err := sf.Get(page)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	record.SetPageView(nil)
+	sf.ReleaseInUseID(page, false)
+
+	if loc, err := fpsm.Get(280); loc != util.PackLocation(5, 20) || err != nil {
+		t.Error("Unexpected Get result:", util.LocationRecord(loc), util.LocationOffset(loc), err)
+		return
+	}
+
+	if fpsm.pages[page] == fpsp {
+		t.Error("Page view should have been replaced")
+		return
+	}
+
+	// Views of freed pages are removed
+
+	if loc, err := fpsm.Get(250); loc != util.PackLocation(7, 22) || err != nil {
+		t.Error("Unexpected Get result:", util.LocationRecord(loc), util.LocationOffset(loc), err)
+		return
+	}
+
+	if len(fpsm.pages) != 0 || psf.First(view.TypeFreePhysicalSlotPage) != 0 {
+		t.Error("Unexpected page views:", fpsm.pages)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestFreePhysicalSlotManagerSummary(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test11", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := paging.NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fpsm := NewFreePhysicalSlotManager(psf, false)
+
+	for i := 0; i < 700; i++ {
+		fpsm.Add(util.PackLocation(uint64(i+1), 0), 100)
+	}
+
+	fpsm.Add(util.PackLocation(1000, 10), 2000)
+	fpsm.Add(util.PackLocation(1001, 10), 2000)
+
+	if err := fpsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fpsm.summary != nil {
+		t.Error("Summary should be built on demand")
+		return
+	}
+
+	if loc, err := fpsm.Get(2000); loc != util.PackLocation(1000, 10) || err != nil {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	if fmt.Sprint(fpsm.summary.pages, fpsm.summary.maxSize) != "[1 2 3] map[1:100 2:100 3:2000]" {
+		t.Error("Unexpected summary:", fpsm.summary.pages, fpsm.summary.maxSize)
+		return
+	}
+
+	// Pages which are too small should not be loaded
+
+	for _, page := range []uint64{1, 2} {
+		if _, err := sf.Get(page); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if loc, err := fpsm.Get(1990); loc != util.PackLocation(1001, 10) || err != nil {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	if loc, err := fpsm.Get(150); loc != 0 || err != nil || fpsm.lastMaxSlotSize != 100 {
+		t.Error("Unexpected Get result:", loc, err, fpsm.lastMaxSlotSize)
+		return
+	}
+
+	if loc, err := fpsm.Get(100); loc != 0 || !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	for _, page := range []uint64{1, 2} {
+		if err := sf.ReleaseInUseID(page, false); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Newly written slots are added to the summary
+
+	fpsm.Add(util.PackLocation(1002, 10), 3000)
+
+	if err := fpsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fmt.Sprint(fpsm.summary.pages, fpsm.summary.maxSize) != "[1 2 3] map[1:100 2:100 3:3000]" {
+		t.Error("Unexpected summary:", fpsm.summary.pages, fpsm.summary.maxSize)
+		return
+	}
+
+	if loc, err := fpsm.Get(3000); loc != util.PackLocation(1002, 10) || err != nil {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	// Reset discards the summary
+
+	fpsm.reset()
+
+	if fpsm.summary != nil || len(fpsm.pages) != 0 {
+		t.Error("Unexpected state after reset:", fpsm.summary, fpsm.pages)
+		return
+	}
+
+	if loc, err := fpsm.Get(100); loc == 0 || err != nil || len(fpsm.summary.pages) != 3 {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestFreePhysicalSlotManagerStrategies(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test13", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := paging.NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fpsm := NewFreePhysicalSlotManager(psf, false)
+
+	// The first page is filled with big slots
+
+	for i := 0; i < 339; i++ {
+		fpsm.Add(util.PackLocation(uint64(i+1), 0), 1000)
+	}
+
+	fpsm.Add(util.PackLocation(1000, 0), 260)
+	fpsm.Add(util.PackLocation(1001, 0), 1000)
+	fpsm.Add(util.PackLocation(1002, 0), 2000)
+
+	if err := fpsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The default accepts the first slot whose waste is acceptable
+
+	if loc, err := fpsm.Get(250); loc != util.PackLocation(1, 0) || err != nil {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	// Best-fit finds the smallest slot on all pages
+
+	fpsm.setAllocationStrategy(pageview.AllocBestFit)
+
+	if loc, err := fpsm.Get(250); loc != util.PackLocation(1000, 0) || err != nil {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	// Next-fit continues on the page of the last allocation
+
+	fpsm.setAllocationStrategy(pageview.AllocNextFit)
+
+	if loc, err := fpsm.Get(1500); loc != util.PackLocation(1002, 0) || err != nil {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	if loc, err := fpsm.Get(250); loc != util.PackLocation(1001, 0) || err != nil {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	// The last page was freed - next-fit wraps around
+
+	if loc, err := fpsm.Get(250); loc != util.PackLocation(2, 0) || err != nil {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	// First-fit always starts at the first page
+
+	fpsm.setAllocationStrategy(pageview.AllocFirstFit)
+
+	if loc, err := fpsm.Get(10); loc != util.PackLocation(3, 0) || err != nil {
+		t.Error("Unexpected Get result:", loc, err)
+		return
+	}
+
+	if loc, err := fpsm.Get(1001); loc != 0 || err != nil || fpsm.lastMaxSlotSize != 1000 {
+		t.Error("Unexpected Get result:", loc, err, fpsm.lastMaxSlotSize)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
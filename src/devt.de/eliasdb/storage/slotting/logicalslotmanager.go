@@ -43,9 +43,13 @@ func NewLogicalSlotManager(lsf *paging.PagedStorageFile,
 
 	freeManager := NewFreeLogicalSlotManager(flsf)
 	recordSize := sf.RecordSize()
+	elementsPerPage := uint16((recordSize - pageview.OffsetTransData) / util.LocationSize)
 
-	return &LogicalSlotManager{sf, lsf, freeManager,
-		recordSize, uint16((recordSize - pageview.OffsetTransData) / util.LocationSize)}
+	// All free slots are on translation pages and can be stored as bitmaps
+
+	freeManager.UseBitmaps(elementsPerPage)
+
+	return &LogicalSlotManager{sf, lsf, freeManager, recordSize, elementsPerPage}
 }
 
 /*
@@ -111,6 +115,81 @@ func (lsm *LogicalSlotManager) Insert(location uint64) (uint64, error) {
 	return slot, lsm.Update(slot, location)
 }
 
+/*
+AllocateBatch inserts a list of physical slot infos and returns a logical slot
+for each of them. Slots of newly allocated translation pages are used
+directly and the remaining free slots are written to the free slot pages
+once at the end of the batch.
+*/
+func (lsm *LogicalSlotManager) AllocateBatch(locations []uint64) ([]uint64, error) {
+	var newSlots []uint64
+
+	slots := make([]uint64, 0, len(locations))
+
+	// Give all taken slots back to the free manager if something goes wrong
+
+	fail := func(err error) ([]uint64, error) {
+		for _, slot := range slots {
+			lsm.freeManager.Add(slot)
+		}
+		return nil, err
+	}
+
+	for len(slots) < len(locations) {
+
+		slot, err := lsm.freeManager.Get()
+		if err != nil {
+			return fail(err)
+		}
+
+		if slot == 0 {
+
+			// Allocate a new page and use its rows for this batch
+
+			allocPage, err := lsm.pager.AllocatePage(view.TypeTranslationPage)
+			if err != nil {
+				return fail(err)
+			}
+
+			offset := uint16(pageview.OffsetTransData)
+
+			var i uint16
+			for i = 0; i < lsm.elementsPerPage; i++ {
+				if len(slots) < len(locations) {
+					slots = append(slots, util.PackLocation(allocPage, offset))
+				} else {
+					newSlots = append(newSlots, util.PackLocation(allocPage, offset))
+				}
+				offset += util.LocationSize
+			}
+
+			continue
+		}
+
+		slots = append(slots, slot)
+	}
+
+	// Give unused rows of new pages to the free manager
+
+	for i := len(newSlots) - 1; i >= 0; i-- {
+		lsm.freeManager.Add(newSlots[i])
+	}
+
+	if err := lsm.Flush(); err != nil {
+		return fail(err)
+	}
+
+	// Write physical slot data to translation pages
+
+	for i, slot := range slots {
+		if err := lsm.Update(slot, locations[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return slots, nil
+}
+
 /*
 ForceInsert inserts a given physical slot info at a given logical slot.
 */
@@ -143,6 +222,49 @@ func (lsm *LogicalSlotManager) ForceInsert(logicalSlot uint64, location uint64)
 	return lsm.Update(logicalSlot, location)
 }
 
+/*
+AddUnusedSlots gives all unused slots of all translation pages to the
+FreeLogicalSlotManager. This should be called after slots were placed with
+ForceInsert into a storage which had no free slots before - otherwise free
+slots are given twice to the FreeLogicalSlotManager.
+*/
+func (lsm *LogicalSlotManager) AddUnusedSlots() error {
+
+	cursor := paging.NewPageCursor(lsm.pager, view.TypeTranslationPage, 0)
+
+	page, err := cursor.Next()
+	for page != 0 && err == nil {
+		var record *file.Record
+
+		if record, err = lsm.storagefile.Get(page); err != nil {
+			return err
+		}
+
+		tp := pageview.NewTransPage(record)
+
+		offset := uint16(pageview.OffsetTransData)
+
+		var i uint16
+		for i = 0; i < lsm.elementsPerPage; i++ {
+			if tp.SlotInfoRecord(offset) == 0 && tp.SlotInfoOffset(offset) == 0 {
+				lsm.freeManager.Add(util.PackLocation(page, offset))
+			}
+
+			offset += util.LocationSize
+		}
+
+		lsm.storagefile.ReleaseInUseID(page, false)
+
+		page, err = cursor.Next()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return lsm.Flush()
+}
+
 /*
 Update updates a given logical slot with a physical slot info.
 */
@@ -179,6 +301,51 @@ func (lsm *LogicalSlotManager) Free(logicalSlot uint64) error {
 
 	page.SetSlotInfo(util.LocationOffset(logicalSlot), util.LocationRecord(0),
 		util.LocationOffset(0))
+	page.SetSlotInfoFlags(util.LocationOffset(logicalSlot), 0)
+
+	return lsm.storagefile.ReleaseInUseID(recordID, true)
+}
+
+/*
+Flags returns the flags of a given logical slot.
+*/
+func (lsm *LogicalSlotManager) Flags(logicalSlot uint64) (byte, error) {
+
+	recordID := util.LocationRecord(logicalSlot)
+
+	if lastPage := lsm.pager.Last(view.TypeTranslationPage); lastPage < recordID {
+
+		// Return if the requested page doesn't exist yet
+
+		return 0, nil
+	}
+
+	record, err := lsm.storagefile.Get(recordID)
+	if err != nil {
+		return 0, err
+	}
+
+	flags := pageview.NewTransPage(record).SlotInfoFlags(util.LocationOffset(logicalSlot))
+
+	lsm.storagefile.ReleaseInUseID(recordID, false)
+
+	return flags, nil
+}
+
+/*
+SetFlags sets the flags of a given logical slot. Flags can be used by higher
+layers to mark slots (e.g. as logically deleted) without freeing them. The
+flags are cleared once the slot is freed.
+*/
+func (lsm *LogicalSlotManager) SetFlags(logicalSlot uint64, flags byte) error {
+	recordID := util.LocationRecord(logicalSlot)
+
+	record, err := lsm.storagefile.Get(recordID)
+	if err != nil {
+		return err
+	}
+
+	pageview.NewTransPage(record).SetSlotInfoFlags(util.LocationOffset(logicalSlot), flags)
 
 	return lsm.storagefile.ReleaseInUseID(recordID, true)
 }
@@ -212,6 +379,60 @@ func (lsm *LogicalSlotManager) Fetch(logicalSlot uint64) (uint64, error) {
 	return slot, nil
 }
 
+/*
+ForEach calls a given function for every logical slot which points to a
+physical slot.
+*/
+func (lsm *LogicalSlotManager) ForEach(f func(logicalSlot uint64, location uint64) error) error {
+
+	cursor := paging.NewPageCursor(lsm.pager, view.TypeTranslationPage, 0)
+	cursor.SetReadAhead(paging.DefaultReadAhead)
+
+	// No need for error checking on cursor next since all pages will be opened
+	// via Get calls in the loop.
+
+	page, _ := cursor.Next()
+	for page != 0 {
+		var slots, locations []uint64
+
+		record, err := lsm.storagefile.Get(page)
+		if err != nil {
+			return err
+		}
+
+		tp := pageview.NewTransPage(record)
+
+		offset := uint16(pageview.OffsetTransData)
+
+		var i uint16
+		for i = 0; i < lsm.elementsPerPage; i++ {
+			location := util.PackLocation(tp.SlotInfoRecord(offset), tp.SlotInfoOffset(offset))
+
+			if location != 0 {
+				slots = append(slots, util.PackLocation(page, offset))
+				locations = append(locations, location)
+			}
+
+			offset += util.LocationSize
+		}
+
+		lsm.storagefile.ReleaseInUseID(page, false)
+
+		// Call the function once the page has been released so it can
+		// modify the page
+
+		for j, slot := range slots {
+			if err := f(slot, locations[j]); err != nil {
+				return err
+			}
+		}
+
+		page, _ = cursor.Next()
+	}
+
+	return nil
+}
+
 /*
 Flush writes all pending changes.
 */
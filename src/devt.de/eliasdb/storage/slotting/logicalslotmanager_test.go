@@ -11,6 +11,7 @@
 package slotting
 
 import (
+	"errors"
 	"testing"
 
 	"devt.de/eliasdb/storage/file"
@@ -66,7 +67,7 @@ func TestLogicalSlotManager(t *testing.T) {
 		return
 	}
 
-	if _, err := lsm.Insert(util.PackLocation(10, 11)); err != file.ErrAlreadyInUse {
+	if _, err := lsm.Insert(util.PackLocation(10, 11)); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -81,7 +82,7 @@ func TestLogicalSlotManager(t *testing.T) {
 		return
 	}
 
-	if _, err := lsm.Insert(util.PackLocation(10, 11)); err != file.ErrAlreadyInUse {
+	if _, err := lsm.Insert(util.PackLocation(10, 11)); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -106,7 +107,7 @@ func TestLogicalSlotManager(t *testing.T) {
 		return
 	}
 
-	if err = lsm.ForceInsert(util.PackLocation(2, 2), util.PackLocation(12, 13)); err != file.ErrAlreadyInUse {
+	if err = lsm.ForceInsert(util.PackLocation(2, 2), util.PackLocation(12, 13)); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -128,7 +129,7 @@ func TestLogicalSlotManager(t *testing.T) {
 		return
 	}
 
-	if err = lsm.ForceInsert(util.PackLocation(2, 2), util.PackLocation(12, 13)); err != file.ErrAlreadyInUse {
+	if err = lsm.ForceInsert(util.PackLocation(2, 2), util.PackLocation(12, 13)); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -150,7 +151,7 @@ func TestLogicalSlotManager(t *testing.T) {
 		return
 	}
 
-	if err = lsm.Free(util.PackLocation(2, 2)); err != file.ErrAlreadyInUse {
+	if err = lsm.Free(util.PackLocation(2, 2)); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected free result:", err)
 		return
 	}
@@ -171,7 +172,7 @@ func TestLogicalSlotManager(t *testing.T) {
 		return
 	}
 
-	if _, err = lsm.Insert(util.PackLocation(12, 13)); err != file.ErrAlreadyInUse {
+	if _, err = lsm.Insert(util.PackLocation(12, 13)); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected free result:", err)
 		return
 	}
@@ -186,7 +187,7 @@ func TestLogicalSlotManager(t *testing.T) {
 		return
 	}
 
-	if err = lsm.Update(util.PackLocation(1, 3), util.PackLocation(12, 13)); err != file.ErrAlreadyInUse {
+	if err = lsm.Update(util.PackLocation(1, 3), util.PackLocation(12, 13)); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected update result:", err)
 		return
 	}
@@ -235,3 +236,89 @@ func _testLogicalSlot(t *testing.T, sf *file.StorageFile,
 
 	sf.ReleaseInUse(record)
 }
+
+func TestLogicalSlotManagerAllocateBatch(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test20_data", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := paging.NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fsf, err := file.NewDefaultStorageFile(DBDIR+"/test20_free", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	fpsf, err := paging.NewPagedStorageFile(fsf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	lsm := NewLogicalSlotManager(psf, fpsf)
+
+	// Create a free slot which should be reused by the batch
+
+	freeSlot, err := lsm.Insert(util.PackLocation(5, 10))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := lsm.Free(freeSlot); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var locations []uint64
+
+	for i := 1; i <= int(lsm.ElementsPerPage())+10; i++ {
+		locations = append(locations, util.PackLocation(uint64(i), 20))
+	}
+
+	slots, err := lsm.AllocateBatch(locations)
+	if err != nil || len(slots) != len(locations) {
+		t.Error("Unexpected result:", len(slots), err)
+		return
+	}
+
+	seen := make(map[uint64]bool)
+
+	for i, slot := range slots {
+		if seen[slot] {
+			t.Error("Slot was allocated twice:", slot)
+			return
+		}
+		seen[slot] = true
+
+		if loc, err := lsm.Fetch(slot); loc != locations[i] || err != nil {
+			t.Error("Unexpected fetch result:", loc, err)
+			return
+		}
+	}
+
+	// Remaining rows of the new pages are available as free slots
+
+	slot, err := lsm.Insert(util.PackLocation(5, 10))
+	if err != nil || seen[slot] || util.LocationRecord(slot) != util.LocationRecord(slots[len(slots)-1]) {
+		t.Error("Unexpected result:", slot, err)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := fpsf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package pageview
+
+import (
+	"fmt"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging/view"
+)
+
+/*
+OffsetNextChunk is the offset of the pointer to the next chunk of a large object
+*/
+const OffsetNextChunk = view.OffsetData
+
+/*
+OffsetChunkSize is the offset of the size of the data which is stored in a chunk
+*/
+const OffsetChunkSize = OffsetNextChunk + file.SizeLong
+
+/*
+OffsetChunkData is the offset of the chunk data
+*/
+const OffsetChunkData = OffsetChunkSize + file.SizeUnsignedInt
+
+/*
+BlobPage data structure
+*/
+type BlobPage struct {
+	*view.PageView
+}
+
+/*
+NewBlobPage creates a new page which holds a chunk of a large object. Blob
+pages are data pages of a dedicated storage file.
+*/
+func NewBlobPage(record *file.Record) *BlobPage {
+	checkBlobPageMagic(record)
+	return &BlobPage{view.GetPageView(record)}
+}
+
+/*
+checkBlobPageMagic checks if the magic number at the beginning of
+the wrapped record is valid.
+*/
+func checkBlobPageMagic(record *file.Record) bool {
+	magic := view.PageMagic(record)
+
+	if magic == view.ViewPageHeader+view.TypeDataPage {
+		return true
+	}
+	panic("Unexpected header found in BlobPage")
+}
+
+/*
+ChunkSpace returns the available space for chunk data on this page.
+*/
+func (bp *BlobPage) ChunkSpace() uint32 {
+	return uint32(len(bp.Record.Data()) - OffsetChunkData)
+}
+
+/*
+NextChunk returns the page of the next chunk (0 if this is the last chunk).
+*/
+func (bp *BlobPage) NextChunk() uint64 {
+	return bp.Record.ReadUInt64(OffsetNextChunk)
+}
+
+/*
+SetNextChunk sets the page of the next chunk.
+*/
+func (bp *BlobPage) SetNextChunk(next uint64) {
+	bp.Record.WriteUInt64(OffsetNextChunk, next)
+}
+
+/*
+ChunkSize returns the size of the data which is stored on this page.
+*/
+func (bp *BlobPage) ChunkSize() uint32 {
+	return bp.Record.ReadUInt32(OffsetChunkSize)
+}
+
+/*
+ChunkData returns the data which is stored on this page.
+*/
+func (bp *BlobPage) ChunkData() []byte {
+	size := bp.ChunkSize()
+
+	if size > bp.ChunkSpace() {
+		size = bp.ChunkSpace()
+	}
+
+	return bp.Record.Data()[OffsetChunkData : OffsetChunkData+size]
+}
+
+/*
+SetChunkData stores a chunk of data on this page.
+*/
+func (bp *BlobPage) SetChunkData(data []byte) {
+	if uint32(len(data)) > bp.ChunkSpace() {
+		panic(fmt.Sprint("Chunk data exceeds the available space on BlobPage: ", bp.ChunkSpace()))
+	}
+
+	bp.Record.WriteUInt32(OffsetChunkSize, uint32(len(data)))
+	copy(bp.Record.Data()[OffsetChunkData:], data)
+}
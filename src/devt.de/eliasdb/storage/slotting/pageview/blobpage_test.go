@@ -0,0 +1,74 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package pageview
+
+import (
+	"testing"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging/view"
+)
+
+func TestBlobPage(t *testing.T) {
+	r := file.NewRecord(123, make([]byte, 44))
+
+	testCheckBlobPageMagicPanic(t, r)
+
+	// Make sure the record has a correct magic
+
+	view.NewPageView(r, view.TypeDataPage)
+
+	bp := NewBlobPage(r)
+
+	if space := bp.ChunkSpace(); space != 44-OffsetChunkData {
+		t.Error("Unexpected chunk space:", space)
+		return
+	}
+
+	bp.SetNextChunk(5)
+	bp.SetChunkData([]byte("test"))
+
+	if bp.NextChunk() != 5 || bp.ChunkSize() != 4 || string(bp.ChunkData()) != "test" {
+		t.Error("Unexpected chunk:", bp.NextChunk(), bp.ChunkSize(), string(bp.ChunkData()))
+		return
+	}
+
+	// A corrupted size does not read beyond the page
+
+	r.WriteUInt32(OffsetChunkSize, 500)
+
+	if len(bp.ChunkData()) != int(bp.ChunkSpace()) {
+		t.Error("Unexpected chunk data:", bp.ChunkData())
+		return
+	}
+
+	testSetChunkDataPanic(t, bp)
+}
+
+func testCheckBlobPageMagicPanic(t *testing.T, r *file.Record) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Checking magic should fail.")
+		}
+	}()
+
+	checkBlobPageMagic(r)
+}
+
+func testSetChunkDataPanic(t *testing.T, bp *BlobPage) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Writing too much data should fail.")
+		}
+	}()
+
+	bp.SetChunkData(make([]byte, 100))
+}
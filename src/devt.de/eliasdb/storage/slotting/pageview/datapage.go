@@ -11,6 +11,11 @@
 /*
 Package pageview contains object wrappers for different page types.
 
+BlobPage
+
+BlobPage is a page which holds a chunk of a large object. The chunks of an
+object form a chain - every page points to the page of the next chunk.
+
 DataPage
 
 DataPage is a page which holds actual data.
@@ -30,7 +35,9 @@ SlotInfoPage
 
 SlotInfoPage is the super-struct for all page views which manage slotinfos.
 Slotinfo are location (see util/location.go) pointers into the data store containing
-record id and offset.
+record id and offset. The most significant byte of a slotinfo is not used by
+locations and holds flags which allow higher layers to mark slots (e.g. as
+logically deleted) without freeing them.
 
 TransPage
 
@@ -74,7 +81,7 @@ checkDataPageMagic checks if the magic number at the beginning of
 the wrapped record is valid.
 */
 func checkDataPageMagic(record *file.Record) bool {
-	magic := record.ReadInt16(0)
+	magic := view.PageMagic(record)
 
 	if magic == view.ViewPageHeader+view.TypeDataPage {
 		return true
@@ -0,0 +1,322 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package pageview
+
+import (
+	"math/bits"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/util"
+)
+
+/*
+BitmapLayoutMarker is stored in the count field of a FreeLogicalSlotPage
+which uses the bitmap layout. The marker can never be a valid slot count of
+the original layout.
+*/
+const BitmapLayoutMarker = 0xFFFF
+
+/*
+OffsetBitmapCount is the offset for the number of free slots on a bitmap page
+*/
+const OffsetBitmapCount = OffsetCount + file.SizeUnsignedShort
+
+/*
+OffsetBitmapData is the offset for the bitmap groups on a bitmap page
+*/
+const OffsetBitmapData = OffsetBitmapCount + file.SizeUnsignedInt
+
+/*
+FreeLogicalSlotBitmapPage data structure. The page is a FreeLogicalSlotPage
+which stores free logical slots as bitmaps. Each bitmap group consists of the
+id of a translation page followed by one bit for each slot on this translation
+page.
+*/
+type FreeLogicalSlotBitmapPage struct {
+	*SlotInfoPage
+	slotsPerRecord uint16 // Number of slots on a translation page
+	groupSize      int    // Size of a bitmap group in bytes
+	maxGroups      int    // Max number of bitmap groups
+	prevFoundGroup int    // Previous found group which has free slots
+}
+
+/*
+NewFreeLogicalSlotBitmapPage creates a new page which stores free logical
+slots of translation pages with a given number of slots as bitmaps. An empty
+page is converted to the bitmap layout.
+*/
+func NewFreeLogicalSlotBitmapPage(record *file.Record, slotsPerRecord uint16) *FreeLogicalSlotBitmapPage {
+	checkFreeLogicalSlotPageMagic(record)
+
+	if record.ReadUInt16(OffsetCount) == 0 {
+		record.WriteUInt16(OffsetCount, BitmapLayoutMarker)
+		record.WriteUInt32(OffsetBitmapCount, 0)
+	}
+
+	if !IsFreeLogicalSlotBitmapPage(record) {
+		panic("FreeLogicalSlotPage does not use the bitmap layout")
+	}
+
+	groupSize := util.LocationSize + (int(slotsPerRecord)+7)/8
+
+	return &FreeLogicalSlotBitmapPage{NewSlotInfoPage(record), slotsPerRecord,
+		groupSize, (len(record.Data()) - OffsetBitmapData) / groupSize, 0}
+}
+
+/*
+IsFreeLogicalSlotBitmapPage checks if a given FreeLogicalSlotPage record uses
+the bitmap layout.
+*/
+func IsFreeLogicalSlotBitmapPage(record *file.Record) bool {
+	return record.ReadUInt16(OffsetCount) == BitmapLayoutMarker
+}
+
+/*
+MigrateFreeLogicalSlotPage converts a FreeLogicalSlotPage from the original
+layout to the bitmap layout. The page is not changed if one of its slots
+cannot be represented in a bitmap. Returns if the page uses the bitmap layout.
+*/
+func MigrateFreeLogicalSlotPage(record *file.Record, slotsPerRecord uint16) bool {
+	if IsFreeLogicalSlotBitmapPage(record) {
+		return true
+	}
+
+	flsp := NewFreeLogicalSlotPage(record)
+
+	var locs []uint64
+	records := make(map[uint64]bool)
+
+	for i := uint16(0); i < flsp.MaxSlots(); i++ {
+		if flsp.isAllocatedSlot(i) {
+			loc := flsp.SlotInfoLocation(i)
+
+			if _, ok := bitmapIndex(loc, slotsPerRecord); !ok {
+				return false
+			}
+
+			locs = append(locs, loc)
+			records[util.LocationRecord(loc)] = true
+		}
+	}
+
+	groupSize := util.LocationSize + (int(slotsPerRecord)+7)/8
+	if len(records) > (len(record.Data())-OffsetBitmapData)/groupSize {
+		return false
+	}
+
+	// Clear the old layout and write the slots as bitmaps
+
+	data := record.Data()
+	for i := OffsetCount; i < len(data); i++ {
+		record.WriteSingleByte(i, 0)
+	}
+
+	bp := NewFreeLogicalSlotBitmapPage(record, slotsPerRecord)
+
+	for _, loc := range locs {
+		bp.AddSlot(loc)
+	}
+
+	return true
+}
+
+/*
+MaxSlots returns the maximum number of slots which can be stored.
+*/
+func (bp *FreeLogicalSlotBitmapPage) MaxSlots() uint32 {
+	return uint32(bp.maxGroups) * uint32(bp.slotsPerRecord)
+}
+
+/*
+FreeSlotCount returns the number of free slots on this page.
+*/
+func (bp *FreeLogicalSlotBitmapPage) FreeSlotCount() uint32 {
+	return bp.Record.ReadUInt32(OffsetBitmapCount)
+}
+
+/*
+AddSlot stores a given location on this page. Returns false if the location
+cannot be stored.
+*/
+func (bp *FreeLogicalSlotBitmapPage) AddSlot(loc uint64) bool {
+	index, ok := bitmapIndex(loc, bp.slotsPerRecord)
+	if !ok {
+		return false
+	}
+
+	group, exists := bp.findGroup(util.LocationRecord(loc))
+	if group == -1 {
+		return false
+	}
+
+	offset := bp.groupOffset(group)
+
+	if !exists {
+		bp.Record.WriteUInt64(offset, util.LocationRecord(loc))
+	}
+
+	pos := offset + util.LocationSize + int(index/8)
+	b := bp.Record.ReadSingleByte(pos)
+
+	if mask := byte(1) << (index % 8); b&mask == 0 {
+		bp.Record.WriteSingleByte(pos, b|mask)
+		bp.Record.WriteUInt32(OffsetBitmapCount, bp.FreeSlotCount()+1)
+	}
+
+	if group < bp.prevFoundGroup {
+		bp.prevFoundGroup = group
+	}
+
+	return true
+}
+
+/*
+TakeFirstSlot removes the first stored location from this page and returns
+it. Returns 0 if the page is empty.
+*/
+func (bp *FreeLogicalSlotBitmapPage) TakeFirstSlot() uint64 {
+
+	if bp.FreeSlotCount() == 0 {
+		return 0
+	}
+
+	bitmapSize := bp.groupSize - util.LocationSize
+
+	for ; bp.prevFoundGroup < bp.maxGroups; bp.prevFoundGroup++ {
+		offset := bp.groupOffset(bp.prevFoundGroup)
+
+		recordID := bp.Record.ReadUInt64(offset)
+		if recordID == 0 {
+			continue
+		}
+
+		for i := 0; i < bitmapSize; i++ {
+			pos := offset + util.LocationSize + i
+
+			b := bp.Record.ReadSingleByte(pos)
+			if b == 0 {
+				continue
+			}
+
+			bit := bits.TrailingZeros8(b)
+
+			bp.Record.WriteSingleByte(pos, b&^(byte(1)<<uint(bit)))
+			bp.Record.WriteUInt32(OffsetBitmapCount, bp.FreeSlotCount()-1)
+
+			if b&^(byte(1)<<uint(bit)) == 0 && bp.isEmptyGroup(offset) {
+
+				// Release the group once it no longer has free slots
+
+				bp.Record.WriteUInt64(offset, 0)
+			}
+
+			index := uint16(i*8 + bit)
+
+			return util.PackLocation(recordID, OffsetTransData+index*util.LocationSize)
+		}
+	}
+
+	return 0
+}
+
+/*
+FreeSlotLocations returns the locations of all free slots on this page.
+*/
+func (bp *FreeLogicalSlotBitmapPage) FreeSlotLocations() []uint64 {
+	ret := make([]uint64, 0, bp.FreeSlotCount())
+
+	for group := 0; group < bp.maxGroups; group++ {
+		offset := bp.groupOffset(group)
+
+		recordID := bp.Record.ReadUInt64(offset)
+		if recordID == 0 {
+			continue
+		}
+
+		var index uint16
+
+		for index = 0; index < bp.slotsPerRecord; index++ {
+			b := bp.Record.ReadSingleByte(offset + util.LocationSize + int(index/8))
+
+			if b&(byte(1)<<(index%8)) != 0 {
+				ret = append(ret, util.PackLocation(recordID,
+					OffsetTransData+index*util.LocationSize))
+			}
+		}
+	}
+
+	return ret
+}
+
+/*
+findGroup finds the bitmap group of a given translation page id or the first
+unused group. Returns -1 if no group is available. The second return value
+indicates if the group already belongs to the translation page.
+*/
+func (bp *FreeLogicalSlotBitmapPage) findGroup(recordID uint64) (int, bool) {
+	unused := -1
+
+	for i := 0; i < bp.maxGroups; i++ {
+		id := bp.Record.ReadUInt64(bp.groupOffset(i))
+
+		if id == recordID {
+			return i, true
+		} else if id == 0 && unused == -1 {
+			unused = i
+		}
+	}
+
+	return unused, false
+}
+
+/*
+isEmptyGroup checks if a bitmap group at a given offset has no free slots.
+*/
+func (bp *FreeLogicalSlotBitmapPage) isEmptyGroup(offset int) bool {
+	for i := offset + util.LocationSize; i < offset+bp.groupSize; i++ {
+		if bp.Record.ReadSingleByte(i) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+groupOffset returns the offset of a bitmap group on the record.
+*/
+func (bp *FreeLogicalSlotBitmapPage) groupOffset(group int) int {
+	return OffsetBitmapData + group*bp.groupSize
+}
+
+/*
+IsBitmapSlot checks if a given logical slot location can be stored in a bitmap.
+*/
+func IsBitmapSlot(loc uint64, slotsPerRecord uint16) bool {
+	_, ok := bitmapIndex(loc, slotsPerRecord)
+	return ok
+}
+
+/*
+bitmapIndex returns the bit index of a logical slot location. Only locations
+which point to a slot on a translation page can be represented.
+*/
+func bitmapIndex(loc uint64, slotsPerRecord uint16) (uint16, bool) {
+	offset := util.LocationOffset(loc)
+
+	if util.LocationRecord(loc) == 0 || offset < OffsetTransData ||
+		(offset-OffsetTransData)%util.LocationSize != 0 {
+		return 0, false
+	}
+
+	index := (offset - OffsetTransData) / util.LocationSize
+
+	return index, index < slotsPerRecord
+}
@@ -0,0 +1,175 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package pageview
+
+import (
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging/view"
+	"devt.de/eliasdb/storage/util"
+)
+
+func TestFreeLogicalSlotBitmapPage(t *testing.T) {
+	r := file.NewRecord(123, make([]byte, 1024))
+
+	view.NewPageView(r, view.TypeFreeLogicalSlotPage)
+
+	transLoc := func(record uint64, slot uint16) uint64 {
+		return util.PackLocation(record, OffsetTransData+slot*util.LocationSize)
+	}
+
+	bp := NewFreeLogicalSlotBitmapPage(r, 253)
+
+	if !IsFreeLogicalSlotBitmapPage(r) {
+		t.Error("Page should use the bitmap layout")
+		return
+	}
+
+	if res := bp.MaxSlots(); res != 25*253 {
+		t.Error("Unexpected max slots:", res)
+		return
+	}
+
+	if loc := bp.TakeFirstSlot(); loc != 0 {
+		t.Error("Unexpected slot:", loc)
+		return
+	}
+
+	// Locations which are not on a translation page cannot be stored
+
+	if bp.AddSlot(util.PackLocation(5, OffsetTransData+1)) ||
+		bp.AddSlot(transLoc(5, 253)) || bp.AddSlot(transLoc(0, 1)) {
+		t.Error("Invalid location should not be stored")
+		return
+	}
+
+	for _, loc := range []uint64{transLoc(7, 252), transLoc(5, 9), transLoc(5, 3), transLoc(7, 0)} {
+		if !bp.AddSlot(loc) {
+			t.Error("Could not add location:", loc)
+			return
+		}
+	}
+
+	bp.AddSlot(transLoc(5, 3))
+
+	if res := bp.FreeSlotCount(); res != 4 {
+		t.Error("Unexpected free slot count:", res)
+		return
+	}
+
+	if res := fmt.Sprint(bp.FreeSlotLocations()); res != fmt.Sprint([]uint64{transLoc(7, 0),
+		transLoc(7, 252), transLoc(5, 3), transLoc(5, 9)}) {
+		t.Error("Unexpected free slot locations:", res)
+		return
+	}
+
+	for _, expected := range []uint64{transLoc(7, 0), transLoc(7, 252), transLoc(5, 3), transLoc(5, 9), 0} {
+		if loc := bp.TakeFirstSlot(); loc != expected {
+			t.Error("Unexpected slot:", loc, "expected:", expected)
+			return
+		}
+	}
+
+	// Groups are released once they are empty
+
+	for i := uint64(1); i <= 25; i++ {
+		if !bp.AddSlot(transLoc(i+100, 1)) {
+			t.Error("Could not add location for record:", i+100)
+			return
+		}
+	}
+
+	if bp.AddSlot(transLoc(200, 1)) {
+		t.Error("Page should be full")
+		return
+	}
+
+	if !bp.AddSlot(transLoc(101, 2)) {
+		t.Error("Existing group should accept new slots")
+		return
+	}
+
+	// A new view reads the same data
+
+	bp = NewFreeLogicalSlotBitmapPage(r, 253)
+
+	if loc := bp.TakeFirstSlot(); loc != transLoc(101, 1) {
+		t.Error("Unexpected slot:", loc)
+		return
+	}
+}
+
+func TestFreeLogicalSlotPageMigration(t *testing.T) {
+	r := file.NewRecord(123, make([]byte, 1024))
+
+	view.NewPageView(r, view.TypeFreeLogicalSlotPage)
+
+	flsp := NewFreeLogicalSlotPage(r)
+
+	for i, loc := range []uint64{util.PackLocation(3, OffsetTransData+16), util.PackLocation(2, OffsetTransData)} {
+		offset := flsp.AllocateSlotInfo(uint16(i))
+		flsp.SetSlotInfo(offset, util.LocationRecord(loc), util.LocationOffset(loc))
+	}
+
+	// A page with a slot which cannot be represented stays unchanged
+
+	r2 := file.NewRecord(124, make([]byte, 1024))
+	view.NewPageView(r2, view.TypeFreeLogicalSlotPage)
+
+	flsp2 := NewFreeLogicalSlotPage(r2)
+	flsp2.SetSlotInfo(flsp2.AllocateSlotInfo(0), 3, 5)
+
+	if MigrateFreeLogicalSlotPage(r2, 253) || IsFreeLogicalSlotBitmapPage(r2) {
+		t.Error("Page should not be migrated")
+		return
+	}
+
+	if !MigrateFreeLogicalSlotPage(r, 253) || !IsFreeLogicalSlotBitmapPage(r) {
+		t.Error("Page should be migrated")
+		return
+	}
+
+	if !MigrateFreeLogicalSlotPage(r, 253) {
+		t.Error("Migrated page should stay migrated")
+		return
+	}
+
+	bp := NewFreeLogicalSlotBitmapPage(r, 253)
+
+	if res := bp.FreeSlotCount(); res != 2 {
+		t.Error("Unexpected free slot count:", res)
+		return
+	}
+
+	if loc := bp.TakeFirstSlot(); loc != util.PackLocation(3, OffsetTransData+16) {
+		t.Error("Unexpected slot:", loc)
+		return
+	}
+
+	if loc := bp.TakeFirstSlot(); loc != util.PackLocation(2, OffsetTransData) {
+		t.Error("Unexpected slot:", loc)
+		return
+	}
+
+	testBitmapLayoutPanic(t, r2)
+}
+
+func testBitmapLayoutPanic(t *testing.T, r *file.Record) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Using a page with the original layout should fail.")
+		}
+	}()
+
+	NewFreeLogicalSlotBitmapPage(r, 253)
+}
@@ -44,7 +44,7 @@ checkFreeLogicalSlotPageMagic checks if the magic number at the beginning of
 the wrapped record is valid.
 */
 func checkFreeLogicalSlotPageMagic(record *file.Record) bool {
-	magic := record.ReadInt16(0)
+	magic := view.PageMagic(record)
 
 	if magic == view.ViewPageHeader+view.TypeFreeLogicalSlotPage {
 		return true
@@ -149,6 +149,23 @@ func (flsp *FreeLogicalSlotPage) FirstAllocatedSlotInfo() int {
 	return -1
 }
 
+/*
+FreeSlotLocations returns the locations of all free slots on this page.
+*/
+func (flsp *FreeLogicalSlotPage) FreeSlotLocations() []uint64 {
+	var i uint16
+
+	ret := make([]uint64, 0, flsp.FreeSlotCount())
+
+	for i = 0; i < flsp.maxSlots; i++ {
+		if flsp.isAllocatedSlot(i) {
+			ret = append(ret, flsp.SlotInfoLocation(i))
+		}
+	}
+
+	return ret
+}
+
 /*
 isAllocatedSlot checks if a given slotinfo is allocated.
 */
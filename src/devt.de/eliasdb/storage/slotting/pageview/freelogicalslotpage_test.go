@@ -74,6 +74,11 @@ func TestFreeLogicalSlotPage(t *testing.T) {
 		return
 	}
 
+	if res := flsp.FreeSlotLocations(); len(res) != 1 || res[0] != loc {
+		t.Error("Unexpected free slot locations:", res)
+		return
+	}
+
 	if !flsp.isAllocatedSlot(0) {
 		t.Error("Slot 0 not allocated")
 		return
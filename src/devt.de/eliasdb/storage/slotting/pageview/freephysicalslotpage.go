@@ -11,6 +11,8 @@
 package pageview
 
 import (
+	"sort"
+
 	"devt.de/eliasdb/storage/file"
 	"devt.de/eliasdb/storage/paging/view"
 	"devt.de/eliasdb/storage/util"
@@ -38,14 +40,28 @@ OptimalWasteMargin bytes
 */
 const OptimalWasteMargin = 128
 
+/*
+Allocation strategies which control how a free slot is picked among all
+slots which are big enough
+*/
+const (
+	AllocHybrid   = 0 // Smallest slot if its waste is within the waste margins (default)
+	AllocFirstFit = 1 // First slot which is big enough
+	AllocBestFit  = 2 // Smallest slot which is big enough regardless of the waste margins
+	AllocNextFit  = 3 // First slot which is big enough after the last allocation
+)
+
 /*
 FreePhysicalSlotPage data structure
 */
 type FreePhysicalSlotPage struct {
 	*SlotInfoPage
 	maxSlots           uint16   // Max number of slots
+	optimalWasteMargin uint32   // Waste which is always accepted for a slot allocation
 	maxAcceptableWaste uint32   // Max acceptable waste for a slot allocation
+	strategy           int      // Allocation strategy
 	sizeCache          []uint32 // Cache for slot sizes
+	sizeIndex          []uint16 // Allocated slotinfos ordered by size (built on demand)
 }
 
 /*
@@ -58,7 +74,7 @@ func NewFreePhysicalSlotPage(record *file.Record) *FreePhysicalSlotPage {
 	maxAcceptableWaste := len(record.Data()) / 4
 
 	return &FreePhysicalSlotPage{NewSlotInfoPage(record), uint16(maxSlots),
-		uint32(maxAcceptableWaste), make([]uint32, maxSlots, maxSlots)}
+		OptimalWasteMargin, uint32(maxAcceptableWaste), AllocHybrid, make([]uint32, maxSlots, maxSlots), nil}
 }
 
 /*
@@ -66,7 +82,7 @@ checkFreePhysicalSlotPageMagic checks if the magic number at the beginning of
 the wrapped record is valid.
 */
 func checkFreePhysicalSlotPageMagic(record *file.Record) bool {
-	magic := record.ReadInt16(0)
+	magic := view.PageMagic(record)
 
 	if magic == view.ViewPageHeader+view.TypeFreePhysicalSlotPage {
 		return true
@@ -74,6 +90,37 @@ func checkFreePhysicalSlotPageMagic(record *file.Record) bool {
 	panic("Unexpected header found in FreePhysicalSlotPage")
 }
 
+/*
+SetWasteMargins sets the allocation waste which is always accepted when
+searching a slot and the max acceptable waste of a slot allocation. Smaller
+margins pack data tighter while bigger margins find suitable slots more
+often. A margin of 0 restores the default (OptimalWasteMargin and a quarter
+of the record size). The waste of an allocation is always below
+util.MaxAvailableSizeDifference.
+*/
+func (fpsp *FreePhysicalSlotPage) SetWasteMargins(optimal uint32, maxAcceptable uint32) {
+
+	if optimal == 0 {
+		optimal = OptimalWasteMargin
+	}
+
+	if maxAcceptable == 0 {
+		maxAcceptable = uint32(len(fpsp.Record.Data()) / 4)
+	}
+
+	fpsp.optimalWasteMargin = optimal
+	fpsp.maxAcceptableWaste = maxAcceptable
+}
+
+/*
+SetAllocationStrategy sets how FindSlot picks a slot. First-fit and next-fit
+both return the first slot (in slotinfo order) which is big enough; the
+difference between them is only in the order in which pages are searched.
+*/
+func (fpsp *FreePhysicalSlotPage) SetAllocationStrategy(strategy int) {
+	fpsp.strategy = strategy
+}
+
 /*
 MaxSlots returns the maximum number of slots which can be allocated.
 */
@@ -97,6 +144,14 @@ func (fpsp *FreePhysicalSlotPage) SlotInfoLocation(slotinfo uint16) uint64 {
 	return util.PackLocation(fpsp.SlotInfoRecord(offset), fpsp.SlotInfoOffset(offset))
 }
 
+/*
+SlotInfoFreeSize returns the free slot size of a stored slotinfo. Lookup is via a
+given slotinfo id.
+*/
+func (fpsp *FreePhysicalSlotPage) SlotInfoFreeSize(slotinfo uint16) uint32 {
+	return fpsp.slotSize(slotinfo)
+}
+
 /*
 FreeSlotSize returns the size of a free slot. Lookup is via offset.
 */
@@ -113,6 +168,24 @@ SetFreeSlotSize sets the size of a free slot. Lookup is via offset.
 */
 func (fpsp *FreePhysicalSlotPage) SetFreeSlotSize(offset uint16, size uint32) {
 	slotinfo := fpsp.offsetToSlotinfo(offset)
+
+	// Keep the size index in order if it was already built
+
+	if fpsp.sizeIndex != nil {
+
+		if oldSize := fpsp.FreeSlotSize(offset); oldSize != 0 {
+			i := fpsp.searchSizeIndex(oldSize, slotinfo)
+			fpsp.sizeIndex = append(fpsp.sizeIndex[:i], fpsp.sizeIndex[i+1:]...)
+		}
+
+		if size != 0 {
+			i := fpsp.searchSizeIndex(size, slotinfo)
+			fpsp.sizeIndex = append(fpsp.sizeIndex, 0)
+			copy(fpsp.sizeIndex[i+1:], fpsp.sizeIndex[i:])
+			fpsp.sizeIndex[i] = slotinfo
+		}
+	}
+
 	fpsp.sizeCache[slotinfo] = size
 	fpsp.Record.WriteUInt32(int(offset+util.LocationSize), size)
 }
@@ -168,70 +241,145 @@ func (fpsp *FreePhysicalSlotPage) FirstFreeSlotInfo() int {
 }
 
 /*
-FindSlot finds a slot which is suitable for a given amount of data but which is also not
-too big to avoid wasting space.
+FindSlotInfo returns the id of the slotinfo which stores a given location or
+-1 if the location is not stored on this page.
 */
-func (fpsp *FreePhysicalSlotPage) FindSlot(minSize uint32) int {
-
+func (fpsp *FreePhysicalSlotPage) FindSlotInfo(loc uint64) int {
 	var i uint16
+	for i = 0; i < fpsp.maxSlots; i++ {
+		if fpsp.isAllocatedSlot(i) && fpsp.SlotInfoLocation(i) == loc {
+			return int(i)
+		}
+	}
+	return -1
+}
 
-	bestSlot := -1
-	bestSlotWaste := fpsp.maxAcceptableWaste + 1
+/*
+FreeSlotLocations returns the locations of all free slots on this page.
+*/
+func (fpsp *FreePhysicalSlotPage) FreeSlotLocations() []uint64 {
+	var i uint16
 
-	var maxSize uint32
+	ret := make([]uint64, 0, fpsp.FreeSlotCount())
 
 	for i = 0; i < fpsp.maxSlots; i++ {
+		if fpsp.isAllocatedSlot(i) {
+			ret = append(ret, fpsp.SlotInfoLocation(i))
+		}
+	}
+
+	return ret
+}
+
+/*
+FindSlot finds a slot which is suitable for a given amount of data but which is also not
+too big to avoid wasting space. The smallest slot which fits is found by a binary search
+on the size index of this page. The first-fit and next-fit strategies return instead the
+first slot which fits and the best-fit strategy ignores the waste margins. Returns the
+negative size of the biggest slot on this page if no suitable slot was found.
+*/
+func (fpsp *FreePhysicalSlotPage) FindSlot(minSize uint32) int {
 
-		slotinfoOffset := fpsp.slotinfoToOffset(i)
+	maxSize := fpsp.MaxSlotSize()
 
-		slotinfoSize := fpsp.FreeSlotSize(slotinfoOffset)
+	if fpsp.strategy == AllocFirstFit || fpsp.strategy == AllocNextFit {
+		var i uint16
 
-		if slotinfoSize > maxSize {
-			maxSize = slotinfoSize
-		}
+		for i = 0; i < fpsp.maxSlots; i++ {
+			if fpsp.isAllocatedSlot(i) {
+				if size := fpsp.slotSize(i); size >= minSize &&
+					size-minSize < util.MaxAvailableSizeDifference {
 
-		// Calculate the wasted space
+					return int(i)
+				}
+			}
+		}
 
-		waste := slotinfoSize - minSize
+	} else if n := len(fpsp.sizeIndex); n > 0 {
 
-		// Test if the block would fit
+		i := sort.Search(n, func(i int) bool {
+			return fpsp.slotSize(fpsp.sizeIndex[i]) >= minSize
+		})
 
-		if waste >= 0 {
+		if i < n {
+			slotinfo := fpsp.sizeIndex[i]
 
-			if waste < OptimalWasteMargin {
+			// Calculate the wasted space
 
-				// In the ideal case we can minimise the produced waste
+			waste := fpsp.slotSize(slotinfo) - minSize
 
-				return int(i)
+			// In the ideal case the produced waste is within the optimal waste
+			// margin otherwise check if it is still acceptable
 
-			} else if bestSlotWaste > waste {
+			// Note: It must be below the MAX_AVAILABLE_SIZE_DIFFERENCE as a row
+			// stores the current size as the difference to the available size.
+			// This difference must fit in an unsigned short.
 
-				// Too much for optimal waste margin but may still be OK if
-				// we don't find anything better
+			if (waste < fpsp.optimalWasteMargin || waste < fpsp.maxAcceptableWaste ||
+				fpsp.strategy == AllocBestFit) && waste < util.MaxAvailableSizeDifference {
 
-				bestSlot = int(i)
-				bestSlotWaste = waste
+				return int(slotinfo)
 			}
 		}
 	}
 
-	if bestSlot != -1 {
+	return -int(maxSize)
+}
 
-		// We found a block but its waste was above the optimal waste margin
-		// check if it is still acceptable
+/*
+MaxSlotSize returns the size of the biggest free slot on this page.
+*/
+func (fpsp *FreePhysicalSlotPage) MaxSlotSize() uint32 {
 
-		// Note: It must be below the MAX_AVAILABLE_SIZE_DIFFERENCE as a row
-		// stores the current size as the difference to the available size.
-		// This difference must fit in an unsigned short.
+	if fpsp.sizeIndex == nil {
+		fpsp.buildSizeIndex()
+	}
+
+	if n := len(fpsp.sizeIndex); n > 0 {
+		return fpsp.slotSize(fpsp.sizeIndex[n-1])
+	}
+
+	return 0
+}
 
-		if bestSlotWaste < fpsp.maxAcceptableWaste &&
-			bestSlotWaste < util.MaxAvailableSizeDifference {
+/*
+buildSizeIndex builds the index of all allocated slotinfos ordered by size.
+Slotinfos of the same size are ordered by their id.
+*/
+func (fpsp *FreePhysicalSlotPage) buildSizeIndex() {
+	var i uint16
 
-			return bestSlot
+	fpsp.sizeIndex = make([]uint16, 0, fpsp.FreeSlotCount())
+
+	for i = 0; i < fpsp.maxSlots; i++ {
+		if fpsp.isAllocatedSlot(i) {
+			fpsp.sizeIndex = append(fpsp.sizeIndex, i)
 		}
 	}
 
-	return -int(maxSize)
+	sort.Slice(fpsp.sizeIndex, func(i, j int) bool {
+		s1 := fpsp.slotSize(fpsp.sizeIndex[i])
+		s2 := fpsp.slotSize(fpsp.sizeIndex[j])
+		return s1 < s2 || (s1 == s2 && fpsp.sizeIndex[i] < fpsp.sizeIndex[j])
+	})
+}
+
+/*
+searchSizeIndex returns the position of a slotinfo with a given size in the
+size index.
+*/
+func (fpsp *FreePhysicalSlotPage) searchSizeIndex(size uint32, slotinfo uint16) int {
+	return sort.Search(len(fpsp.sizeIndex), func(i int) bool {
+		s := fpsp.slotSize(fpsp.sizeIndex[i])
+		return s > size || (s == size && fpsp.sizeIndex[i] >= slotinfo)
+	})
+}
+
+/*
+slotSize returns the size of a free slot. Lookup is via slotinfo id.
+*/
+func (fpsp *FreePhysicalSlotPage) slotSize(slotinfo uint16) uint32 {
+	return fpsp.FreeSlotSize(fpsp.slotinfoToOffset(slotinfo))
 }
 
 /*
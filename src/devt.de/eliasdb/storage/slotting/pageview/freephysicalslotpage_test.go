@@ -11,6 +11,7 @@
 package pageview
 
 import (
+	"fmt"
 	"testing"
 
 	"devt.de/eliasdb/storage/file"
@@ -179,6 +180,216 @@ func TestFreePhysicalSlotPageAllocation(t *testing.T) {
 	}
 }
 
+func TestFreePhysicalSlotPageWasteMargins(t *testing.T) {
+	r := file.NewRecord(123, make([]byte, 4096))
+
+	view.NewPageView(r, view.TypeFreePhysicalSlotPage)
+
+	fpsp := NewFreePhysicalSlotPage(r)
+
+	offset := fpsp.AllocateSlotInfo(0)
+	fpsp.SetSlotInfo(offset, 0x22, 0x22)
+	fpsp.SetFreeSlotSize(offset, 1000)
+
+	if slot := fpsp.FindSlot(100); slot != 0 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	// Tighter packing
+
+	fpsp.SetWasteMargins(10, 50)
+
+	if slot := fpsp.FindSlot(100); slot != -1000 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	if slot := fpsp.FindSlot(951); slot != 0 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	if slot := fpsp.FindSlot(950); slot != -1000 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	// The optimal margin is always accepted
+
+	fpsp.SetWasteMargins(900, 50)
+
+	if slot := fpsp.FindSlot(101); slot != 0 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	if slot := fpsp.FindSlot(100); slot != -1000 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	// Restore defaults
+
+	fpsp.SetWasteMargins(0, 0)
+
+	if fpsp.optimalWasteMargin != OptimalWasteMargin || fpsp.maxAcceptableWaste != 1024 {
+		t.Error("Unexpected margins:", fpsp.optimalWasteMargin, fpsp.maxAcceptableWaste)
+		return
+	}
+}
+
+func TestFreePhysicalSlotPageSizeIndex(t *testing.T) {
+	r := file.NewRecord(123, make([]byte, 4096))
+
+	view.NewPageView(r, view.TypeFreePhysicalSlotPage)
+
+	fpsp := NewFreePhysicalSlotPage(r)
+
+	fpsp.buildSizeIndex()
+
+	if fpsp.sizeIndex == nil || len(fpsp.sizeIndex) != 0 {
+		t.Error("Unexpected size index:", fpsp.sizeIndex)
+		return
+	}
+
+	// Allocate slots after the index was built
+
+	for i, size := range []uint32{900, 300, 600, 300, 1200} {
+		offset := fpsp.AllocateSlotInfo(uint16(i))
+		fpsp.SetSlotInfo(offset, 0x22, 0x22)
+		fpsp.SetFreeSlotSize(offset, size)
+	}
+
+	if fmt.Sprint(fpsp.sizeIndex) != "[1 3 2 0 4]" {
+		t.Error("Unexpected size index:", fpsp.sizeIndex)
+		return
+	}
+
+	// Smallest fitting slot should be found
+
+	if slot := fpsp.FindSlot(250); slot != 1 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	if slot := fpsp.FindSlot(301); slot != 2 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	if slot := fpsp.FindSlot(1201); slot != -1200 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	// Change and release slots
+
+	fpsp.SetFreeSlotSize(fpsp.slotinfoToOffset(0), 100)
+	fpsp.ReleaseSlotInfo(1)
+
+	if fmt.Sprint(fpsp.sizeIndex) != "[0 3 2 4]" {
+		t.Error("Unexpected size index:", fpsp.sizeIndex)
+		return
+	}
+
+	if slot := fpsp.FindSlot(250); slot != 3 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	// A new view should build the same index from the record
+
+	fpsp2 := NewFreePhysicalSlotPage(r)
+	fpsp2.buildSizeIndex()
+
+	if fmt.Sprint(fpsp2.sizeIndex) != "[0 3 2 4]" {
+		t.Error("Unexpected size index:", fpsp2.sizeIndex)
+		return
+	}
+}
+
+func TestFreePhysicalSlotPageLocations(t *testing.T) {
+	r := file.NewRecord(123, make([]byte, 4096))
+
+	view.NewPageView(r, view.TypeFreePhysicalSlotPage)
+
+	fpsp := NewFreePhysicalSlotPage(r)
+
+	for i, rec := range []uint64{5, 7, 9} {
+		offset := fpsp.AllocateSlotInfo(uint16(i * 2))
+		fpsp.SetSlotInfo(offset, rec, 0x20)
+		fpsp.SetFreeSlotSize(offset, 100)
+	}
+
+	fpsp.ReleaseSlotInfo(2)
+
+	if res := fmt.Sprint(fpsp.FreeSlotLocations()); res != "[327712 589856]" {
+		t.Error("Unexpected locations:", res)
+		return
+	}
+
+	if slot := fpsp.FindSlotInfo(util.PackLocation(9, 0x20)); slot != 4 {
+		t.Error("Unexpected slotinfo:", slot)
+		return
+	}
+
+	if slot := fpsp.FindSlotInfo(util.PackLocation(7, 0x20)); slot != -1 {
+		t.Error("Unexpected slotinfo:", slot)
+		return
+	}
+}
+
+func TestFreePhysicalSlotPageStrategies(t *testing.T) {
+	r := file.NewRecord(123, make([]byte, 4096))
+
+	view.NewPageView(r, view.TypeFreePhysicalSlotPage)
+
+	fpsp := NewFreePhysicalSlotPage(r)
+
+	for i, size := range []uint32{5000, 80000, 500, 300} {
+		offset := fpsp.AllocateSlotInfo(uint16(i))
+		fpsp.SetSlotInfo(offset, 0x22, 0x22)
+		fpsp.SetFreeSlotSize(offset, size)
+	}
+
+	if slot := fpsp.FindSlot(250); slot != 3 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	if slot := fpsp.FindSlot(3000); slot != -80000 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	fpsp.SetAllocationStrategy(AllocFirstFit)
+
+	if slot := fpsp.FindSlot(250); slot != 0 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	// The waste must always fit in a slot header
+
+	if slot := fpsp.FindSlot(5001); slot != -80000 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	fpsp.SetAllocationStrategy(AllocBestFit)
+
+	if slot := fpsp.FindSlot(3000); slot != 0 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+
+	if slot := fpsp.FindSlot(250); slot != 3 {
+		t.Error("Unexpected found slot:", slot)
+		return
+	}
+}
+
 func testCheckFreePhysicalSlotPageMagicPanic(t *testing.T, r *file.Record) {
 	defer func() {
 		if r := recover(); r == nil {
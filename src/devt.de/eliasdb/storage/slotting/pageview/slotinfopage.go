@@ -16,6 +16,26 @@ import (
 	"devt.de/eliasdb/storage/util"
 )
 
+/*
+SlotInfoFlagDeleted marks a slotinfo as logically deleted
+*/
+const SlotInfoFlagDeleted = byte(0x01)
+
+/*
+SlotInfoFlagTombstone marks a slotinfo as tombstone which is reclaimed later
+*/
+const SlotInfoFlagTombstone = byte(0x02)
+
+/*
+SlotInfoFlagPinned marks a slotinfo which must not be reclaimed
+*/
+const SlotInfoFlagPinned = byte(0x04)
+
+/*
+slotInfoLocationMask masks out the flags byte of a slotinfo
+*/
+const slotInfoLocationMask = uint64(0x00FFFFFFFFFFFFFF)
+
 /*
 SlotInfoPage data structure
 */
@@ -35,7 +55,7 @@ func NewSlotInfoPage(record *file.Record) *SlotInfoPage {
 SlotInfoRecord gets record id of a stored slotinfo.
 */
 func (lm *SlotInfoPage) SlotInfoRecord(offset uint16) uint64 {
-	return util.LocationRecord(lm.Record.ReadUInt64(int(offset)))
+	return util.LocationRecord(lm.Record.ReadUInt64(int(offset)) & slotInfoLocationMask)
 }
 
 /*
@@ -46,8 +66,24 @@ func (lm *SlotInfoPage) SlotInfoOffset(offset uint16) uint16 {
 }
 
 /*
-SetSlotInfo stores a slotinfo on the pageview's record.
+SetSlotInfo stores a slotinfo on the pageview's record. The flags of the
+slotinfo are kept.
 */
 func (lm *SlotInfoPage) SetSlotInfo(slotinfoOffset uint16, recordID uint64, offset uint16) {
-	lm.Record.WriteUInt64(int(slotinfoOffset), util.PackLocation(recordID, offset))
+	flags := uint64(lm.SlotInfoFlags(slotinfoOffset)) << 56
+	lm.Record.WriteUInt64(int(slotinfoOffset), util.PackLocation(recordID, offset)|flags)
+}
+
+/*
+SlotInfoFlags gets the flags of a stored slotinfo.
+*/
+func (lm *SlotInfoPage) SlotInfoFlags(offset uint16) byte {
+	return lm.Record.ReadSingleByte(int(offset))
+}
+
+/*
+SetSlotInfoFlags sets the flags of a stored slotinfo.
+*/
+func (lm *SlotInfoPage) SetSlotInfoFlags(offset uint16, flags byte) {
+	lm.Record.WriteSingleByte(int(offset), flags)
 }
@@ -35,4 +35,22 @@ func TestSlotInfoPage(t *testing.T) {
 	if si.SlotInfoRecord(2) != 99 {
 		t.Error("Unexpected record read back")
 	}
+
+	// Flags are stored independently of the location
+
+	si.SetSlotInfoFlags(2, SlotInfoFlagDeleted|SlotInfoFlagPinned)
+
+	if si.SlotInfoRecord(2) != 99 || si.SlotInfoOffset(2) != 45 {
+		t.Error("Unexpected location read back")
+	}
+
+	si.SetSlotInfo(2, 0xFFFFFF, 46)
+
+	if si.SlotInfoFlags(2) != SlotInfoFlagDeleted|SlotInfoFlagPinned {
+		t.Error("Unexpected flags read back")
+	}
+
+	if si.SlotInfoRecord(2) != 0xFFFFFF || si.SlotInfoOffset(2) != 46 {
+		t.Error("Unexpected location read back")
+	}
 }
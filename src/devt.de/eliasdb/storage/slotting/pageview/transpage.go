@@ -41,7 +41,7 @@ checkTransPageMagic checks if the magic number at the beginning of
 the wrapped record is valid.
 */
 func checkTransPageMagic(record *file.Record) bool {
-	magic := record.ReadInt16(0)
+	magic := view.PageMagic(record)
 
 	if magic == view.ViewPageHeader+view.TypeTranslationPage {
 		return true
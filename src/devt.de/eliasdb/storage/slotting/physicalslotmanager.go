@@ -11,7 +11,9 @@
 package slotting
 
 import (
+	"bytes"
 	"io"
+	"sort"
 
 	"devt.de/eliasdb/storage/file"
 	"devt.de/eliasdb/storage/paging"
@@ -36,6 +38,8 @@ type PhysicalSlotManager struct {
 	freeManager         *FreePhysicalSlotManager // Manager for free slots
 	recordSize          uint32                   // Size of records
 	availableRecordSize uint32                   // Available space on records
+	blobManager         *BlobManager             // Manager for large objects (nil if not available)
+	blobThreshold       uint32                   // Size above which data is stored as large object
 }
 
 /*
@@ -52,7 +56,35 @@ func NewPhysicalSlotManager(psf *paging.PagedStorageFile,
 	recordSize := sf.RecordSize()
 
 	return &PhysicalSlotManager{sf, psf, freeManager,
-		recordSize, recordSize - pageview.OffsetData}
+		recordSize, recordSize - pageview.OffsetData, nil, 0}
+}
+
+/*
+SetBlobStorage sets a manager for large objects. Data which is bigger than
+the given threshold is stored as a large object instead of a physical slot.
+A threshold of 0 stores all new data in physical slots - existing large
+objects can still be accessed.
+*/
+func (psm *PhysicalSlotManager) SetBlobStorage(bm *BlobManager, threshold uint32) {
+	psm.blobManager = bm
+	psm.blobThreshold = threshold
+}
+
+/*
+isBlob checks if data of a given length should be stored as a large object.
+*/
+func (psm *PhysicalSlotManager) isBlob(length uint32) bool {
+	return psm.blobManager != nil && psm.blobThreshold > 0 && length > psm.blobThreshold
+}
+
+/*
+checkBlobStorage checks that large objects can be accessed.
+*/
+func (psm *PhysicalSlotManager) checkBlobStorage() error {
+	if psm.blobManager == nil {
+		return ErrNoBlobStorage
+	}
+	return nil
 }
 
 /*
@@ -64,6 +96,10 @@ func (psm *PhysicalSlotManager) Insert(data []byte, start uint32, length uint32)
 		panic("Cannot insert 0 bytes of data")
 	}
 
+	if psm.isBlob(length) {
+		return psm.blobManager.Insert(data, start, length)
+	}
+
 	location, err := psm.allocate(length)
 	if err != nil {
 		return 0, err
@@ -88,6 +124,34 @@ Update updates the data in a slot.
 */
 func (psm *PhysicalSlotManager) Update(location uint64, data []byte, start uint32, length uint32) (uint64, error) {
 
+	if IsBlobLocation(location) {
+		if err := psm.checkBlobStorage(); err != nil {
+			return 0, err
+		}
+
+		if psm.isBlob(length) {
+			return location, psm.blobManager.Update(location, data, start, length)
+		}
+
+		// The data is now small enough for a physical slot
+
+		if err := psm.blobManager.Free(location); err != nil {
+			return 0, err
+		}
+
+		return psm.Insert(data, start, length)
+
+	} else if psm.isBlob(length) {
+
+		// The data is now too big for a physical slot
+
+		if err := psm.Free(location); err != nil {
+			return 0, err
+		}
+
+		return psm.blobManager.Insert(data, start, length)
+	}
+
 	record, err := psm.storagefile.Get(util.LocationRecord(location))
 
 	if err != nil {
@@ -130,6 +194,14 @@ Fetch fetches data from a specified location.
 */
 func (psm *PhysicalSlotManager) Fetch(location uint64, writer io.Writer) error {
 
+	if IsBlobLocation(location) {
+		if err := psm.checkBlobStorage(); err != nil {
+			return err
+		}
+
+		return psm.blobManager.Fetch(location, writer)
+	}
+
 	cursor := paging.NewPageCursor(psm.pager, view.TypeDataPage, util.LocationRecord(location))
 
 	record, err := psm.storagefile.Get(cursor.Current())
@@ -196,9 +268,46 @@ func (psm *PhysicalSlotManager) Fetch(location uint64, writer io.Writer) error {
 }
 
 /*
-Free frees a given physical slot. The given slot is given to the FreePhysicalSlotManager.
+SlotSize returns the size of the data which is stored in a given slot and the
+size which is allocated for the slot.
+*/
+func (psm *PhysicalSlotManager) SlotSize(location uint64) (uint32, uint32, error) {
+
+	if IsBlobLocation(location) {
+		if err := psm.checkBlobStorage(); err != nil {
+			return 0, 0, err
+		}
+
+		return psm.blobManager.Size(location)
+	}
+
+	slotRecord := util.LocationRecord(location)
+	slotOffset := int(util.LocationOffset(location))
+
+	record, err := psm.storagefile.Get(slotRecord)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer psm.storagefile.ReleaseInUseID(slotRecord, false)
+
+	return util.CurrentSize(record, slotOffset), util.AvailableSize(record, slotOffset), nil
+}
+
+/*
+Free frees a given physical slot. The given slot is merged with neighbouring
+free slots on the same record and then given to the FreePhysicalSlotManager.
+All pages of large objects are freed.
 */
 func (psm *PhysicalSlotManager) Free(location uint64) error {
+
+	if IsBlobLocation(location) {
+		if err := psm.checkBlobStorage(); err != nil {
+			return err
+		}
+
+		return psm.blobManager.Free(location)
+	}
+
 	slotRecord := util.LocationRecord(location)
 	slotOffset := int(util.LocationOffset(location))
 
@@ -209,13 +318,90 @@ func (psm *PhysicalSlotManager) Free(location uint64) error {
 
 	util.SetCurrentSize(record, slotOffset, 0)
 
+	slotOffset, size := psm.coalesce(slotRecord, record, slotOffset,
+		util.AvailableSize(record, slotOffset))
+
 	psm.storagefile.ReleaseInUseID(slotRecord, true)
 
-	psm.freeManager.Add(location, util.AvailableSize(record, slotOffset))
+	psm.freeManager.Add(util.PackLocation(slotRecord, uint16(slotOffset)), size)
 
 	return nil
 }
 
+/*
+coalesce merges a free slot with the free slots directly before and after it.
+Only slots which are fully contained in the given record are merged. Returns
+the offset and size of the merged slot.
+*/
+func (psm *PhysicalSlotManager) coalesce(recordID uint64, record *file.Record,
+	offset int, size uint32) (int, uint32) {
+
+	end := int(psm.recordSize)
+
+	// mergeFree removes a neighbouring slot from the free slot set and
+	// writes the merged size - errors of the FreePhysicalSlotManager just
+	// prevent the merge
+
+	mergeFree := func(header int, neighbour int, merged uint32) bool {
+		if util.NormalizeSlotSize(merged) != merged {
+			return false
+		}
+
+		fsize, err := psm.freeManager.Remove(util.PackLocation(recordID, uint16(neighbour)))
+		if err != nil || fsize == 0 {
+			return false
+		}
+
+		util.SetAvailableSize(record, header, merged)
+
+		return true
+	}
+
+	if offset+util.SizeInfoSize+int(size) > end {
+		return offset, size
+	}
+
+	// Merge with the following slot
+
+	next := offset + util.SizeInfoSize + int(size)
+
+	if next <= end-util.SizeInfoSize {
+		nsize := util.AvailableSize(record, next)
+
+		if nsize != 0 && next+util.SizeInfoSize+int(nsize) <= end &&
+			mergeFree(offset, next, size+util.SizeInfoSize+nsize) {
+
+			size += util.SizeInfoSize + nsize
+		}
+	}
+
+	// Find the preceding slot by walking the slots of the record
+
+	prev := -1
+	pos := int(pageview.NewDataPage(record).OffsetFirst())
+
+	for pos != 0 && pos < offset {
+		psize := util.AvailableSize(record, pos)
+		if psize == 0 {
+			break
+		}
+
+		prev = pos
+		pos += util.SizeInfoSize + int(psize)
+	}
+
+	if prev != -1 && pos == offset {
+		psize := util.AvailableSize(record, prev)
+
+		if mergeFree(prev, prev, psize+util.SizeInfoSize+size) {
+			offset = prev
+			size += psize + util.SizeInfoSize
+		}
+	}
+
+	return offset, size
+}
+
 /*
 Flush writes all pending changes.
 */
@@ -224,27 +410,282 @@ func (psm *PhysicalSlotManager) Flush() error {
 }
 
 /*
-write writes data to a location. Should an error occurs, then the already written data
-is not cleaned up.
+SetWasteMargins sets the allocation waste which is always accepted when a free
+slot is reused and the max acceptable waste of a reused slot. Smaller margins
+pack data tighter but free slots are reused less often. A margin of 0 restores
+the default.
 */
-func (psm *PhysicalSlotManager) write(location uint64, data []byte, start uint32, length uint32) error {
+func (psm *PhysicalSlotManager) SetWasteMargins(optimal uint32, maxAcceptable uint32) {
+	psm.freeManager.setWasteMargins(optimal, maxAcceptable)
+}
 
-	cursor := paging.NewPageCursor(psm.pager, view.TypeDataPage, util.LocationRecord(location))
+/*
+SetAllocationStrategy sets how a free slot is picked for new data. First-fit
+(pageview.AllocFirstFit) and next-fit (pageview.AllocNextFit) take the first
+free slot which is big enough and are fast for ingest-heavy workloads.
+Best-fit (pageview.AllocBestFit) searches the smallest free slot which is big
+enough and packs long-lived data better. The default (pageview.AllocHybrid)
+takes the first free slot whose waste is within the waste margins.
+*/
+func (psm *PhysicalSlotManager) SetAllocationStrategy(strategy int) {
+	psm.freeManager.setAllocationStrategy(strategy)
+}
 
-	record, err := psm.storagefile.Get(cursor.Current())
+/*
+ResetFreeSlotCache discards all cached information about free slots. This must
+be called after the storage files of this manager were rolled back.
+*/
+func (psm *PhysicalSlotManager) ResetFreeSlotCache() {
+	psm.freeManager.reset()
+}
+
+/*
+Compact moves all given physical slots towards the beginning of the data pages
+so there is no unused space between them. Data pages which are no longer
+needed are returned to the free list of the pager and all free slot
+information is discarded. The given locations must be all slots which are in
+use - the data of any other slot is lost. Large objects are not moved. Returns
+the new locations of the given slots in the same order as they were given.
+*/
+func (psm *PhysicalSlotManager) Compact(locations []uint64) ([]uint64, error) {
+
+	// Determine the position of each data page in the page list - slots are
+	// moved in the order in which they appear in this list
+
+	var pages []uint64
+
+	pageIndex := make(map[uint64]int)
+
+	cursor := paging.NewPageCursor(psm.pager, view.TypeDataPage, 0)
+	cursor.SetReadAhead(paging.DefaultReadAhead)
+
+	page, err := cursor.Next()
+	for page != 0 {
+		pageIndex[page] = len(pages)
+		pages = append(pages, page)
+
+		if page, err = cursor.Next(); err != nil {
+			return nil, err
+		}
+	}
+
+	newLocations := make([]uint64, len(locations))
+
+	sortedLocations := &compactLocations{locations, make([]int, 0, len(locations)), pageIndex}
+	for i, loc := range locations {
+
+		// Large objects keep their location
+
+		if IsBlobLocation(loc) {
+			newLocations[i] = loc
+			continue
+		}
+
+		sortedLocations.order = append(sortedLocations.order, i)
+	}
+	sort.Sort(sortedLocations)
+
+	// Move all slots - a slot can never be moved to a position after its old
+	// position so it is enough to read its data before writing it
+
+	var buf bytes.Buffer
+
+	wpage := 0
+	woffset := uint32(pageview.OffsetData)
+
+	if len(sortedLocations.order) > 0 {
+		if err := psm.setOffsetFirst(pages[wpage], uint16(woffset)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, i := range sortedLocations.order {
+
+		buf.Reset()
+
+		if err := psm.Fetch(locations[i], &buf); err != nil {
+			return nil, err
+		}
+
+		length := uint32(buf.Len())
+
+		size := util.NormalizeSlotSize(length)
+		if size == 0 {
+
+			// Empty slots still need some space otherwise they would mark
+			// the end of the data on the page
+
+			size = 1
+		}
+
+		// Go to the next page if there is no space left for a slot header
+
+		if woffset > psm.recordSize-util.SizeInfoSize {
+			wpage++
+			woffset = pageview.OffsetData
+
+			if err := psm.setOffsetFirst(pages[wpage], uint16(woffset)); err != nil {
+				return nil, err
+			}
+		}
+
+		// Write the slot header and the data
+
+		record, err := psm.storagefile.Get(pages[wpage])
+		if err != nil {
+			return nil, err
+		}
+
+		record.WriteUInt16(int(woffset)+util.OffetAvailableSize, 0)
+		util.SetCurrentSize(record, int(woffset), 0)
+		util.SetAvailableSize(record, int(woffset), size)
+
+		psm.storagefile.ReleaseInUseID(pages[wpage], true)
+
+		loc := util.PackLocation(pages[wpage], uint16(woffset))
+
+		if err := psm.write(loc, buf.Bytes(), 0, length); err != nil {
+			return nil, err
+		}
+
+		newLocations[i] = loc
+
+		// Calculate the next write position
+
+		rspace := psm.recordSize - woffset - util.SizeInfoSize
+
+		if size <= rspace {
+			woffset += util.SizeInfoSize + size
+			continue
+		}
+
+		allocSize := size - rspace
+
+		for allocSize >= psm.availableRecordSize {
+			wpage++
+
+			if err := psm.setOffsetFirst(pages[wpage], 0); err != nil {
+				return nil, err
+			}
+
+			allocSize -= psm.availableRecordSize
+		}
+
+		woffset = psm.recordSize
+
+		if allocSize > 0 {
+			wpage++
+			woffset = pageview.OffsetData + allocSize
+
+			if err := psm.setOffsetFirst(pages[wpage], uint16(woffset)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(sortedLocations.order) > 0 {
+
+		// Mark the end of the data on the last used page
+
+		if woffset <= psm.recordSize-util.SizeInfoSize {
+			record, err := psm.storagefile.Get(pages[wpage])
+			if err != nil {
+				return nil, err
+			}
+
+			record.WriteUInt16(int(woffset)+util.OffsetCurrentSize, 0)
+			record.WriteUInt16(int(woffset)+util.OffetAvailableSize, 0)
+
+			psm.storagefile.ReleaseInUseID(pages[wpage], true)
+		}
+
+		wpage++
+	}
+
+	// Return all pages which are no longer used to the pager
+
+	for _, page := range pages[wpage:] {
+		if err := psm.pager.FreePage(page); err != nil {
+			return nil, err
+		}
+	}
+
+	// All free space is now at the end of the data - previously recorded
+	// free slots are no longer valid
+
+	return newLocations, psm.freeManager.clear()
+}
+
+/*
+setOffsetFirst sets the pointer to the first element on a given data page.
+*/
+func (psm *PhysicalSlotManager) setOffsetFirst(page uint64, offset uint16) error {
+	record, err := psm.storagefile.Get(page)
 	if err != nil {
 		return err
 	}
 
-	util.SetCurrentSize(record, int(util.LocationOffset(location)), length)
-	if length == 0 {
+	pageview.NewDataPage(record).SetOffsetFirst(offset)
+
+	return psm.storagefile.ReleaseInUseID(page, true)
+}
 
-		// Return at this point if there is nothing to write
+/*
+compactLocations is used to sort locations by their position in the list
+of data pages.
+*/
+type compactLocations struct {
+	locations []uint64       // Locations to sort
+	order     []int          // Sorted indices of locations
+	pageIndex map[uint64]int // Positions of data pages
+}
 
-		psm.storagefile.ReleaseInUseID(cursor.Current(), true)
-		return nil
+/*
+Len returns the number of locations.
+*/
+func (cl *compactLocations) Len() int {
+	return len(cl.order)
+}
+
+/*
+Less returns if the location at index i is before the location at index j.
+*/
+func (cl *compactLocations) Less(i, j int) bool {
+	loc1 := cl.locations[cl.order[i]]
+	loc2 := cl.locations[cl.order[j]]
+
+	page1 := cl.pageIndex[util.LocationRecord(loc1)]
+	page2 := cl.pageIndex[util.LocationRecord(loc2)]
+
+	if page1 != page2 {
+		return page1 < page2
 	}
 
+	return util.LocationOffset(loc1) < util.LocationOffset(loc2)
+}
+
+/*
+Swap swaps the locations at index i and j.
+*/
+func (cl *compactLocations) Swap(i, j int) {
+	cl.order[i], cl.order[j] = cl.order[j], cl.order[i]
+}
+
+/*
+write writes data to a location. All pages of the slot are modified as a
+page group - should an error occur, then all pages are restored.
+*/
+func (psm *PhysicalSlotManager) write(location uint64, data []byte, start uint32, length uint32) error {
+
+	group := psm.pager.NewPageGroup()
+
+	record, err := group.Get(util.LocationRecord(location))
+	if err != nil {
+		return err
+	}
+
+	util.SetCurrentSize(record, int(util.LocationOffset(location)), length)
+
 	// Write now the bytes
 
 	restSize := length
@@ -277,18 +718,13 @@ func (psm *PhysicalSlotManager) write(location uint64, data []byte, start uint32
 		restSize -= toCopy
 		dataOffset += toCopy
 
-		psm.storagefile.ReleaseInUseID(cursor.Current(), true)
-
 		// Go to the next record
 
 		if restSize > 0 {
 
-			// Error handling is done by surrounding Get calls
-
-			next, _ := cursor.Next()
-
-			record, err = psm.storagefile.Get(next)
+			record, err = group.Get(view.GetPageView(record).NextPage())
 			if err != nil {
+				group.Rollback()
 				return err
 			}
 
@@ -296,9 +732,92 @@ func (psm *PhysicalSlotManager) write(location uint64, data []byte, start uint32
 		}
 	}
 
+	group.Commit()
+
 	return nil
 }
 
+/*
+AllocateBatch allocates empty slots for a list of sizes in one pass. Data can
+be written to the returned locations with Update calls. Consecutive new slots
+are placed without scanning the rows of the last data page again for every
+slot. Sizes which would be stored as large objects get the location 0 - their
+data needs to be stored with Insert calls. All slots are freed again if an
+error occurs.
+*/
+func (psm *PhysicalSlotManager) AllocateBatch(sizes []uint32) ([]uint64, error) {
+	var hintPage uint64
+	var hint uint16
+
+	locs := make([]uint64, 0, len(sizes))
+
+	for _, size := range sizes {
+
+		if psm.isBlob(size) {
+			locs = append(locs, 0)
+			continue
+		}
+
+		normalizedSize := util.NormalizeSlotSize(size)
+
+		loc, err := psm.allocateFree(normalizedSize)
+
+		if err == nil && loc == 0 {
+			lastpage := psm.pager.Last(view.TypeDataPage)
+
+			if lastpage != hintPage {
+				hint = 0
+			}
+
+			if loc, err = psm.allocateNewAt(normalizedSize, lastpage, hint); err == nil {
+				hintPage, hint, err = psm.nextFreeOffset(loc)
+			}
+		}
+
+		if err != nil {
+
+			// Free all slots of this batch - error handling is done by
+			// the allocation calls
+
+			for _, l := range locs {
+				if l != 0 {
+					psm.Free(l)
+				}
+			}
+
+			return nil, err
+		}
+
+		locs = append(locs, loc)
+	}
+
+	return locs, nil
+}
+
+/*
+nextFreeOffset returns the page and the offset after a given newly allocated
+slot. Returns 0 as offset if the slot reaches the end of its page.
+*/
+func (psm *PhysicalSlotManager) nextFreeOffset(location uint64) (uint64, uint16, error) {
+	slotRecord := util.LocationRecord(location)
+	slotOffset := uint32(util.LocationOffset(location))
+
+	record, err := psm.storagefile.Get(slotRecord)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	next := slotOffset + util.SizeInfoSize + util.AvailableSize(record, int(slotOffset))
+
+	psm.storagefile.ReleaseInUseID(slotRecord, false)
+
+	if next >= psm.recordSize {
+		return 0, 0, nil
+	}
+
+	return slotRecord, uint16(next), nil
+}
+
 /*
 allocate allocates a new slot of a given size.
 */
@@ -310,7 +829,7 @@ func (psm *PhysicalSlotManager) allocate(size uint32) (uint64, error) {
 
 	// Try to find a free slot which was previously allocated
 
-	loc, err := psm.freeManager.Get(normalizedSize)
+	loc, err := psm.allocateFree(normalizedSize)
 
 	if err != nil {
 		return 0, err
@@ -326,29 +845,43 @@ func (psm *PhysicalSlotManager) allocate(size uint32) (uint64, error) {
 		if err != nil {
 			return 0, err
 		}
-	} else {
+	}
 
-		// IF a location was found in the freeManager then try
-		// to access it to make sure it is available - revert otherwise
+	return loc, nil
+}
 
-		slotRecord := util.LocationRecord(loc)
-		slotOffset := int(util.LocationOffset(loc))
+/*
+allocateFree tries to allocate a previously freed slot of a given normalized
+size. Returns 0 if no suitable free slot was found.
+*/
+func (psm *PhysicalSlotManager) allocateFree(normalizedSize uint32) (uint64, error) {
 
-		record, err := psm.storagefile.Get(slotRecord)
-		if err != nil {
+	loc, err := psm.freeManager.Get(normalizedSize)
 
-			// Revert back - the size may now be wrong but this is
-			// still better than losing the whole record
+	if err != nil || loc == 0 {
+		return 0, err
+	}
 
-			psm.freeManager.Add(loc, normalizedSize)
-			return 0, err
-		}
+	// IF a location was found in the freeManager then try
+	// to access it to make sure it is available - revert otherwise
+
+	slotRecord := util.LocationRecord(loc)
+	slotOffset := int(util.LocationOffset(loc))
 
-		util.SetCurrentSize(record, slotOffset, 0)
+	record, err := psm.storagefile.Get(slotRecord)
+	if err != nil {
 
-		psm.storagefile.ReleaseInUseID(slotRecord, true)
+		// Revert back - the size may now be wrong but this is
+		// still better than losing the whole record
+
+		psm.freeManager.Add(loc, normalizedSize)
+		return 0, err
 	}
 
+	util.SetCurrentSize(record, slotOffset, 0)
+
+	psm.storagefile.ReleaseInUseID(slotRecord, true)
+
 	return loc, nil
 }
 
@@ -358,6 +891,15 @@ cause the allocation of empty pages. The last allocated page pointers might
 get out of sync with the actual data pages.
 */
 func (psm *PhysicalSlotManager) allocateNew(size uint32, startPage uint64) (uint64, error) {
+	return psm.allocateNewAt(size, startPage, 0)
+}
+
+/*
+allocateNewAt allocates a new slot in the PagedStorageFile. The search for
+free space on the start page begins at a given offset (0 to start at the first
+row of the page).
+*/
+func (psm *PhysicalSlotManager) allocateNewAt(size uint32, startPage uint64, startOffset uint16) (uint64, error) {
 
 	var record *file.Record
 	var pv *pageview.DataPage
@@ -385,6 +927,8 @@ func (psm *PhysicalSlotManager) allocateNew(size uint32, startPage uint64) (uint
 		util.SetCurrentSize(record, pageview.OffsetData, 0)
 		util.SetAvailableSize(record, pageview.OffsetData, 0)
 
+		startOffset = 0
+
 	} else {
 
 		record, err = psm.storagefile.Get(startPage)
@@ -397,6 +941,10 @@ func (psm *PhysicalSlotManager) allocateNew(size uint32, startPage uint64) (uint
 
 	offset = uint32(pv.OffsetFirst())
 
+	if startOffset != 0 && offset != 0 {
+		offset = uint32(startOffset)
+	}
+
 	if offset == 0 {
 
 		// Take care of the special case if the current page was filled
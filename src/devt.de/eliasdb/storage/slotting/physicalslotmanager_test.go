@@ -13,6 +13,7 @@ package slotting
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -136,14 +137,14 @@ func TestPhysicalSlotManager(t *testing.T) {
 	}
 
 	_, err = psm.Insert(make([]byte, 1), 0, 1)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected insert result:", err)
 		return
 	}
 
 	fsf.ReleaseInUse(record)
 
-	if err := psm.Free(util.PackLocation(0, 20)); err != file.ErrAlreadyInUse {
+	if err := psm.Free(util.PackLocation(0, 20)); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected free result:", err)
 		return
 	}
@@ -155,7 +156,7 @@ func TestPhysicalSlotManager(t *testing.T) {
 	}
 
 	_, err = psm.allocate(10)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected allocate result:", err)
 		return
 	}
@@ -175,7 +176,7 @@ func TestPhysicalSlotManager(t *testing.T) {
 	// The insert should have failed. The allocated space
 	// for it should have been send back to the free manager
 
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected insert result:", err)
 		return
 	}
@@ -194,7 +195,7 @@ func TestPhysicalSlotManager(t *testing.T) {
 	}
 
 	_, err = psm.Insert(arr2, 1, 8999)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected update result:", err)
 		return
 	}
@@ -223,7 +224,7 @@ func TestPhysicalSlotManager(t *testing.T) {
 	}
 
 	_, err = psm.Update(loc, arr2, 1, 8999)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected update result:", err)
 		return
 	}
@@ -237,7 +238,7 @@ func TestPhysicalSlotManager(t *testing.T) {
 	}
 
 	_, err = psm.Update(loc, arr2, 1, 8999)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected update result:", err)
 		return
 	}
@@ -251,7 +252,7 @@ func TestPhysicalSlotManager(t *testing.T) {
 	}
 
 	_, err = psm.Update(loc, arr2, 0, 9000)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected update result:", err)
 		return
 	}
@@ -265,7 +266,7 @@ func TestPhysicalSlotManager(t *testing.T) {
 	}
 
 	_, err = psm.Update(loc, arr2, 0, 9000)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected update result:", err)
 		return
 	}
@@ -470,10 +471,10 @@ func TestPhysicalSlotManagerReadWrite(t *testing.T) {
 		return
 	}
 
-	if err := psm.write(loc2, make([]byte, 0), 0, 0); err != file.ErrAlreadyInUse {
+	if err := psm.write(loc2, make([]byte, 0), 0, 0); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected write result:", err)
 	}
-	if err := psm.Fetch(loc2, buf); err != file.ErrAlreadyInUse {
+	if err := psm.Fetch(loc2, buf); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected read result:", err)
 		return
 	}
@@ -514,10 +515,10 @@ func TestPhysicalSlotManagerReadWrite(t *testing.T) {
 		return
 	}
 
-	if err := psm.write(loc2, make([]byte, 10000), 0, 9999); err != file.ErrAlreadyInUse {
+	if err := psm.write(loc2, make([]byte, 10000), 0, 9999); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected write result:", err)
 	}
-	if err := psm.Fetch(loc2, buf); err != file.ErrAlreadyInUse {
+	if err := psm.Fetch(loc2, buf); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected read result:", err)
 		return
 	}
@@ -536,14 +537,26 @@ func TestPhysicalSlotManagerReadWrite(t *testing.T) {
 		return
 	}
 
-	if err := psm.write(loc3, make([]byte, 10000), 0, 9999); err != file.ErrAlreadyInUse {
+	if err := psm.write(loc3, make([]byte, 10000), 0, 9999); !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error("Unexpected write result:", err)
 	}
-	if err := psm.Fetch(loc3, buf); err != file.ErrAlreadyInUse {
+
+	// The failed write was rolled back so there is nothing to read
+
+	var b3 bytes.Buffer
+	buf = bufio.NewWriter(&b3)
+
+	if err := psm.Fetch(loc3, buf); err != nil {
 		t.Error("Unexpected read result:", err)
 		return
 	}
 
+	buf.Flush()
+	if len(b3.Bytes()) != 0 {
+		t.Error("Nothing should have been read back")
+		return
+	}
+
 	sf.ReleaseInUse(record)
 
 	if err := psf.Close(); err != nil {
@@ -598,7 +611,7 @@ func TestPhysicalSlotManagerAllocateNew(t *testing.T) {
 	}
 
 	loc, err := psm.allocateNew(size, 0)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -624,7 +637,7 @@ func TestPhysicalSlotManagerAllocateNew(t *testing.T) {
 	}
 
 	loc, err = psm.allocateNew(10, 1)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -776,7 +789,7 @@ func TestPhysicalSlotManagerAllocateNew(t *testing.T) {
 	}
 
 	loc, err = psm.allocateNew(8147, 5)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -801,7 +814,7 @@ func TestPhysicalSlotManagerAllocateNew(t *testing.T) {
 	}
 
 	loc, err = psm.allocateNew(8147, 12)
-	if err != file.ErrAlreadyInUse {
+	if !errors.Is(err, file.ErrAlreadyInUse) {
 		t.Error(err)
 		return
 	}
@@ -837,3 +850,288 @@ func checkLocation(t *testing.T, loc uint64, record uint64, offset uint16) {
 		t.Error("Unexpected location. Expected:", record, offset, "Got:", lrecord, loffset)
 	}
 }
+
+func TestPhysicalSlotManagerSlotSize(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test9_data", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := paging.NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fsf, err := file.NewDefaultStorageFile(DBDIR+"/test9_free", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	fpsf, err := paging.NewPagedStorageFile(fsf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	psm := NewPhysicalSlotManager(psf, fpsf, false)
+
+	loc, err := psm.Insert(make([]byte, 100), 0, 100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if current, available, err := psm.SlotSize(loc); err != nil || current != 100 ||
+		available != util.NormalizeSlotSize(100) {
+		t.Error("Unexpected slot size:", current, available, err)
+		return
+	}
+
+	record, _ := sf.Get(util.LocationRecord(loc))
+
+	if _, _, err := psm.SlotSize(loc); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := fpsf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPhysicalSlotManagerCoalesce(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test12_data", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := paging.NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fsf, err := file.NewDefaultStorageFile(DBDIR+"/test12_free", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	fpsf, err := paging.NewPagedStorageFile(fsf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	psm := NewPhysicalSlotManager(psf, fpsf, false)
+
+	var locs []uint64
+
+	for i := 0; i < 4; i++ {
+		loc, err := psm.Insert([]byte(fmt.Sprint("data", i)), 0, 5)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		locs = append(locs, loc)
+	}
+
+	checkLocation(t, locs[0], 1, 20)
+	checkLocation(t, locs[1], 1, 29)
+
+	// A slot without free neighbours is not merged
+
+	if err := psm.Free(locs[1]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	psm.Flush()
+
+	// Freeing the first slot merges it with the flushed second slot
+
+	if err := psm.Free(locs[0]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fmt.Sprint(psm.freeManager.slots, psm.freeManager.sizes) != fmt.Sprint([]uint64{locs[0]}, []uint32{14}) {
+		t.Error("Unexpected free slots:", psm.freeManager)
+		return
+	}
+
+	// Freeing the third slot merges it with the unflushed merged slot
+
+	if err := psm.Free(locs[2]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fmt.Sprint(psm.freeManager.slots, psm.freeManager.sizes) != fmt.Sprint([]uint64{locs[0]}, []uint32{23}) {
+		t.Error("Unexpected free slots:", psm.freeManager)
+		return
+	}
+
+	if current, available, err := psm.SlotSize(locs[0]); err != nil || current != 0 || available != 23 {
+		t.Error("Unexpected slot size:", current, available, err)
+		return
+	}
+
+	psm.Flush()
+
+	if psm.freeManager.pager.First(view.TypeFreePhysicalSlotPage) == 0 {
+		t.Error("Free slot should have been stored")
+		return
+	}
+
+	// The merged slot can hold data which did not fit into any of the
+	// original slots
+
+	loc, err := psm.Insert(make([]byte, 20), 0, 20)
+	if err != nil || loc != locs[0] {
+		t.Error("Unexpected result:", loc, err)
+		return
+	}
+
+	// The last slot is not merged with its used neighbour
+
+	if err := psm.Free(locs[3]); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fmt.Sprint(psm.freeManager.slots, psm.freeManager.sizes) != fmt.Sprint([]uint64{locs[3]}, []uint32{5}) {
+		t.Error("Unexpected free slots:", psm.freeManager)
+		return
+	}
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := fpsf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestPhysicalSlotManagerAllocateBatch(t *testing.T) {
+	var pagers []*paging.PagedStorageFile
+
+	for _, name := range []string{"test19_data", "test19_free", "test19_blob"} {
+		sf, err := file.NewDefaultStorageFile(DBDIR+"/"+name, false)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		psf, err := paging.NewPagedStorageFile(sf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		pagers = append(pagers, psf)
+	}
+
+	defer func() {
+		for _, psf := range pagers {
+			if err := psf.Close(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	psm := NewPhysicalSlotManager(pagers[0], pagers[1], false)
+	psm.SetBlobStorage(NewBlobManager(pagers[2]), 10000)
+
+	// Create a free slot which should be reused by the batch
+
+	freeLoc, err := psm.Insert(make([]byte, 500), 0, 500)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := psm.Insert([]byte("test"), 0, 4); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := psm.Free(freeLoc); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := psm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	sizes := []uint32{500, 20000}
+
+	for i := 0; i < 200; i++ {
+		sizes = append(sizes, uint32(10+i*7))
+	}
+
+	locs, err := psm.AllocateBatch(sizes)
+	if err != nil || len(locs) != len(sizes) {
+		t.Error("Unexpected result:", len(locs), err)
+		return
+	}
+
+	if locs[0] != freeLoc || locs[1] != 0 {
+		t.Error("Unexpected locations:", locs[0], locs[1])
+		return
+	}
+
+	// Write data to all slots and make sure it can be read back
+
+	seen := make(map[uint64]bool)
+
+	for i, loc := range locs[2:] {
+		data := bytes.Repeat([]byte{byte(i)}, int(sizes[i+2]))
+
+		if seen[loc] {
+			t.Error("Location was allocated twice:", loc)
+			return
+		}
+		seen[loc] = true
+
+		if newLoc, err := psm.Update(loc, data, 0, uint32(len(data))); err != nil || newLoc != loc {
+			t.Error("Unexpected result:", newLoc, err)
+			return
+		}
+	}
+
+	for i, loc := range locs[2:] {
+		var buf bytes.Buffer
+
+		if err := psm.Fetch(loc, &buf); err != nil ||
+			!bytes.Equal(buf.Bytes(), bytes.Repeat([]byte{byte(i)}, int(sizes[i+2]))) {
+			t.Error("Unexpected data:", loc, err)
+			return
+		}
+	}
+
+	// Individual allocations continue after the batch
+
+	loc, err := psm.Insert([]byte("test"), 0, 4)
+	if err != nil || seen[loc] {
+		t.Error("Unexpected result:", loc, err)
+		return
+	}
+}
@@ -0,0 +1,259 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"fmt"
+
+	"devt.de/eliasdb/storage/paging"
+	"devt.de/eliasdb/storage/paging/view"
+	"devt.de/eliasdb/storage/slotting/pageview"
+	"devt.de/eliasdb/storage/util"
+)
+
+/*
+ScrubReport contains the result of a consistency check of the free slot
+information.
+*/
+type ScrubReport struct {
+	FreePhysicalSlots  int      // Number of checked free physical slots
+	FreeLogicalSlots   int      // Number of checked free logical slots
+	StalePhysicalSlots []uint64 // Free physical slots which do not point to free data
+	StaleLogicalSlots  []uint64 // Free logical slots which do not point to free translation entries
+	Fixed              bool     // Flag if stale entries have been removed
+}
+
+/*
+HasStaleSlots checks if stale free slots have been found.
+*/
+func (sr *ScrubReport) HasStaleSlots() bool {
+	return len(sr.StalePhysicalSlots) > 0 || len(sr.StaleLogicalSlots) > 0
+}
+
+/*
+String returns a string representation of a ScrubReport.
+*/
+func (sr *ScrubReport) String() string {
+	return fmt.Sprintf("Checked %v free physical slots (%v stale) and %v free "+
+		"logical slots (%v stale) fixed:%v", sr.FreePhysicalSlots,
+		len(sr.StalePhysicalSlots), sr.FreeLogicalSlots, len(sr.StaleLogicalSlots),
+		sr.Fixed)
+}
+
+/*
+Scrub cross-checks the free slot information of a LogicalSlotManager and its
+PhysicalSlotManager against the translation and data pages. A free physical
+slot is stale if it does not point to the header of a free slot of the same
+size on a data page or if it is still referenced by a logical slot. A free
+logical slot is stale if it does not point to an empty entry on a
+translation page or if it is listed more than once. Stale entries are
+removed from the free slot information if the fix flag is set. Stale entries
+can be left behind by a crash between writing slot data and writing free slot
+information.
+*/
+func Scrub(lsm *LogicalSlotManager, psm *PhysicalSlotManager, fix bool) (*ScrubReport, error) {
+	report := &ScrubReport{}
+
+	// Collect all physical slots which are in use
+
+	used := make(map[uint64]bool)
+
+	err := lsm.ForEach(func(loc uint64, ploc uint64) error {
+		used[ploc] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the free physical slots
+
+	var ferr error
+
+	err = psm.freeManager.forEachFreeSlot(func(loc uint64, size uint32) {
+		report.FreePhysicalSlots++
+
+		if ferr == nil {
+			var ok bool
+
+			if ok, ferr = psm.isFreeSlot(loc, size); !ok || used[loc] {
+				report.StalePhysicalSlots = append(report.StalePhysicalSlots, loc)
+			}
+		}
+	})
+	if err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the free logical slots
+
+	slots, err := lsm.freeManager.freeSlots()
+	if err != nil {
+		return nil, err
+	}
+
+	report.FreeLogicalSlots = len(slots)
+
+	valid := make([]uint64, 0, len(slots))
+	seen := make(map[uint64]bool)
+
+	for _, loc := range slots {
+		ok, err := lsm.isFreeSlot(loc)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok || seen[loc] {
+			report.StaleLogicalSlots = append(report.StaleLogicalSlots, loc)
+			continue
+		}
+
+		seen[loc] = true
+		valid = append(valid, loc)
+	}
+
+	if !fix || !report.HasStaleSlots() {
+		return report, nil
+	}
+
+	// Remove all stale entries
+
+	for _, loc := range report.StalePhysicalSlots {
+		if _, err := psm.freeManager.Remove(loc); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(report.StaleLogicalSlots) > 0 {
+		if err := lsm.freeManager.replace(valid); err != nil {
+			return nil, err
+		}
+	}
+
+	report.Fixed = true
+
+	return report, nil
+}
+
+/*
+isFreeSlot checks if a given location points to the header of a free slot
+with a given size on a data page.
+*/
+func (psm *PhysicalSlotManager) isFreeSlot(loc uint64, size uint32) (bool, error) {
+	recordID := util.LocationRecord(loc)
+	offset := int(util.LocationOffset(loc))
+
+	if recordID == 0 || offset < pageview.OffsetData ||
+		offset > int(psm.recordSize)-util.SizeInfoSize {
+		return false, nil
+	}
+
+	record, err := psm.storagefile.Get(recordID)
+	if err != nil {
+		return false, err
+	}
+
+	ok := view.PageMagic(record) == view.ViewPageHeader+view.TypeDataPage &&
+		util.CurrentSize(record, offset) == 0 &&
+		util.AvailableSize(record, offset) == size
+
+	psm.storagefile.ReleaseInUseID(recordID, false)
+
+	return ok, nil
+}
+
+/*
+isFreeSlot checks if a given location points to an empty entry on a
+translation page.
+*/
+func (lsm *LogicalSlotManager) isFreeSlot(loc uint64) (bool, error) {
+	recordID := util.LocationRecord(loc)
+
+	if !pageview.IsBitmapSlot(loc, lsm.elementsPerPage) {
+		return false, nil
+	}
+
+	record, err := lsm.storagefile.Get(recordID)
+	if err != nil {
+		return false, err
+	}
+
+	ok := view.PageMagic(record) == view.ViewPageHeader+view.TypeTranslationPage
+
+	if ok {
+		tp := pageview.NewTransPage(record)
+		offset := util.LocationOffset(loc)
+
+		ok = util.PackLocation(tp.SlotInfoRecord(offset), tp.SlotInfoOffset(offset)) == 0
+	}
+
+	lsm.storagefile.ReleaseInUseID(recordID, false)
+
+	return ok, nil
+}
+
+/*
+freeSlots returns all free slots. This includes slots which have not been
+flushed yet.
+*/
+func (flsm *FreeLogicalSlotManager) freeSlots() ([]uint64, error) {
+	ret := append([]uint64{}, flsm.slots...)
+
+	cursor := paging.NewPageCursor(flsm.pager, view.TypeFreeLogicalSlotPage, 0)
+
+	// No need for error checking on cursor next since all pages will be opened
+	// via Get calls in the loop.
+
+	page, _ := cursor.Next()
+	for page != 0 {
+
+		record, err := flsm.storagefile.Get(page)
+		if err != nil {
+			return nil, err
+		}
+
+		if flsm.isBitmapPage(record) {
+			ret = append(ret, pageview.NewFreeLogicalSlotBitmapPage(record,
+				flsm.slotsPerRecord).FreeSlotLocations()...)
+		} else {
+			ret = append(ret, pageview.NewFreeLogicalSlotPage(record).FreeSlotLocations()...)
+		}
+
+		// A migration may have changed the record
+
+		flsm.storagefile.ReleaseInUseID(page, record.Dirty())
+
+		page, _ = cursor.Next()
+	}
+
+	return ret, nil
+}
+
+/*
+replace replaces all free slots with a given list of free slots.
+*/
+func (flsm *FreeLogicalSlotManager) replace(slots []uint64) error {
+
+	page := flsm.pager.First(view.TypeFreeLogicalSlotPage)
+	for page != 0 {
+		if err := flsm.pager.FreePage(page); err != nil {
+			return err
+		}
+		page = flsm.pager.First(view.TypeFreeLogicalSlotPage)
+	}
+
+	flsm.slots = slots
+
+	return flsm.Flush()
+}
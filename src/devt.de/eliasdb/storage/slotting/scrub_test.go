@@ -0,0 +1,194 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging"
+	"devt.de/eliasdb/storage/slotting/pageview"
+	"devt.de/eliasdb/storage/util"
+)
+
+func TestScrub(t *testing.T) {
+	var pagers []*paging.PagedStorageFile
+
+	for _, name := range []string{"test17_data", "test17_free", "test17_trans", "test17_tfree"} {
+		sf, err := file.NewDefaultStorageFile(DBDIR+"/"+name, false)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		psf, err := paging.NewPagedStorageFile(sf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		pagers = append(pagers, psf)
+	}
+
+	psm := NewPhysicalSlotManager(pagers[0], pagers[1], false)
+	lsm := NewLogicalSlotManager(pagers[2], pagers[3])
+
+	var locs, plocs []uint64
+
+	for i := 0; i < 6; i++ {
+		data := []byte(fmt.Sprint("data", i))
+
+		ploc, err := psm.Insert(data, 0, uint32(len(data)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		loc, err := lsm.Insert(ploc)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		locs = append(locs, loc)
+		plocs = append(plocs, ploc)
+	}
+
+	// Free a slot properly
+
+	psm.Free(plocs[1])
+	lsm.Free(locs[1])
+	lsm.freeManager.Add(locs[1])
+
+	if err := psm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := lsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, err := Scrub(lsm, psm, true)
+	if err != nil || report.HasStaleSlots() || report.Fixed {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	if report.FreePhysicalSlots != 1 || report.FreeLogicalSlots != int(lsm.ElementsPerPage())-5 {
+		t.Error("Unexpected result:", report)
+		return
+	}
+
+	// Add stale entries - a slot which is in use, a slot with a wrong size
+	// and slots which are not on a data or translation page
+
+	psm.freeManager.Add(plocs[3], 6)
+	psm.freeManager.Add(plocs[4], 100)
+	psm.freeManager.Add(util.PackLocation(500, pageview.OffsetData), 10)
+
+	if err := psm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	lsm.freeManager.Add(locs[2])
+	lsm.freeManager.Add(locs[1]) // Bitmaps store duplicates only once
+
+	if err := lsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	lsm.freeManager.Add(util.PackLocation(1, pageview.OffsetTransData+1))
+
+	report, err = Scrub(lsm, psm, false)
+	if err != nil || report.Fixed {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	if res := fmt.Sprint(report.StalePhysicalSlots); res !=
+		fmt.Sprint([]uint64{plocs[3], plocs[4], util.PackLocation(500, pageview.OffsetData)}) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	if len(report.StaleLogicalSlots) != 2 {
+		t.Error("Unexpected result:", report.StaleLogicalSlots)
+		return
+	}
+
+	if res := report.String(); res != fmt.Sprintf("Checked 4 free physical slots (3 stale) and %v "+
+		"free logical slots (2 stale) fixed:false", lsm.ElementsPerPage()-3) {
+		t.Error("Unexpected result:", res)
+		return
+	}
+
+	// Fix the stale entries
+
+	report, err = Scrub(lsm, psm, true)
+	if err != nil || !report.Fixed {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	report, err = Scrub(lsm, psm, false)
+	if err != nil || report.HasStaleSlots() {
+		t.Error("Unexpected result:", report, err)
+		return
+	}
+
+	if report.FreePhysicalSlots != 1 || report.FreeLogicalSlots != int(lsm.ElementsPerPage())-5 {
+		t.Error("Unexpected result:", report)
+		return
+	}
+
+	// New data does not overwrite existing data
+
+	for i := 0; i < 3; i++ {
+		ploc, err := psm.Insert([]byte("new"), 0, 3)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := lsm.Insert(ploc); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	for i, loc := range locs {
+		if i == 1 {
+			continue
+		}
+
+		ploc, _ := lsm.Fetch(loc)
+
+		var buf bytes.Buffer
+
+		if err := psm.Fetch(ploc, &buf); err != nil || buf.String() != fmt.Sprint("data", i) {
+			t.Error("Unexpected result:", buf.String(), err)
+			return
+		}
+	}
+
+	for _, psf := range pagers {
+		if err := psf.Close(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
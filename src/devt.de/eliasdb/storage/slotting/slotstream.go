@@ -0,0 +1,259 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"devt.de/eliasdb/storage/paging"
+	"devt.de/eliasdb/storage/paging/view"
+	"devt.de/eliasdb/storage/slotting/pageview"
+	"devt.de/eliasdb/storage/util"
+)
+
+/*
+ErrSlotFull is returned if more data is written to a slot than was allocated
+*/
+var ErrSlotFull = errors.New("Data exceeds the size of the slot")
+
+/*
+ErrBlobStream is returned if a large object should be read as a slot stream
+*/
+var ErrBlobStream = errors.New("Large objects cannot be read as a slot stream")
+
+/*
+SlotReader data structure. A SlotReader reads the data of a physical slot
+chunk by chunk. Only the record which is currently read is held in memory.
+*/
+type SlotReader struct {
+	psm    *PhysicalSlotManager // Manager of the slot
+	cursor *paging.PageCursor   // Cursor over the pages of the slot
+	offset uint32               // Offset of the next byte on the current page
+	rest   uint32               // Number of bytes which have not been read
+	size   uint32               // Size of the slot data
+}
+
+/*
+NewSlotReader creates a new reader for the data at a specified location.
+*/
+func (psm *PhysicalSlotManager) NewSlotReader(location uint64) (*SlotReader, error) {
+
+	if IsBlobLocation(location) {
+		return nil, ErrBlobStream
+	}
+
+	slotRecord := util.LocationRecord(location)
+	slotOffset := util.LocationOffset(location)
+
+	record, err := psm.storagefile.Get(slotRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	size := util.CurrentSize(record, int(slotOffset))
+
+	psm.storagefile.ReleaseInUseID(slotRecord, false)
+
+	return &SlotReader{psm, paging.NewPageCursor(psm.pager, view.TypeDataPage, slotRecord),
+		uint32(slotOffset + util.SizeInfoSize), size, size}, nil
+}
+
+/*
+Size returns the size of the slot data.
+*/
+func (sr *SlotReader) Size() uint32 {
+	return sr.size
+}
+
+/*
+Read reads the next chunk of slot data. At most the remaining data of the
+current page is read.
+*/
+func (sr *SlotReader) Read(p []byte) (int, error) {
+	if sr.rest == 0 {
+		return 0, io.EOF
+	} else if len(p) == 0 {
+		return 0, nil
+	}
+
+	if sr.offset == sr.psm.recordSize {
+
+		// Go to the next page of the slot
+
+		next, err := sr.cursor.Next()
+		if err != nil {
+			return 0, err
+		} else if next == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		sr.offset = pageview.OffsetData
+	}
+
+	page := sr.cursor.Current()
+
+	record, err := sr.psm.storagefile.Get(page)
+	if err != nil {
+		return 0, err
+	}
+
+	toCopy := chunkSize(uint32(len(p)), sr.psm.recordSize-sr.offset, sr.rest)
+
+	copy(p, record.Data()[sr.offset:sr.offset+toCopy])
+
+	sr.psm.storagefile.ReleaseInUseID(page, false)
+
+	sr.offset += toCopy
+	sr.rest -= toCopy
+
+	return int(toCopy), nil
+}
+
+/*
+SlotWriter data structure. A SlotWriter writes the data of a newly allocated
+physical slot chunk by chunk. The size of the data must be known in advance.
+*/
+type SlotWriter struct {
+	psm      *PhysicalSlotManager // Manager of the slot
+	cursor   *paging.PageCursor   // Cursor over the pages of the slot
+	location uint64               // Location of the slot
+	offset   uint32               // Offset of the next byte on the current page
+	rest     uint32               // Number of bytes which have not been written
+}
+
+/*
+NewSlotWriter allocates a new slot for data of a given length and returns a
+writer for it.
+*/
+func (psm *PhysicalSlotManager) NewSlotWriter(length uint32) (*SlotWriter, error) {
+
+	if length == 0 {
+		panic("Cannot insert 0 bytes of data")
+	}
+
+	location, err := psm.allocate(length)
+	if err != nil {
+		return nil, err
+	}
+
+	slotRecord := util.LocationRecord(location)
+	slotOffset := util.LocationOffset(location)
+
+	record, err := psm.storagefile.Get(slotRecord)
+	if err != nil {
+		psm.freeManager.Add(location, length)
+		return nil, err
+	}
+
+	util.SetCurrentSize(record, int(slotOffset), length)
+
+	psm.storagefile.ReleaseInUseID(slotRecord, true)
+
+	return &SlotWriter{psm, paging.NewPageCursor(psm.pager, view.TypeDataPage, slotRecord),
+		location, uint32(slotOffset + util.SizeInfoSize), length}, nil
+}
+
+/*
+Location returns the location of the written slot.
+*/
+func (sw *SlotWriter) Location() uint64 {
+	return sw.location
+}
+
+/*
+Write writes the next chunk of slot data. Returns ErrSlotFull if the data
+exceeds the allocated size.
+*/
+func (sw *SlotWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+
+		if sw.rest == 0 {
+			return written, ErrSlotFull
+		}
+
+		if sw.offset == sw.psm.recordSize {
+
+			// Go to the next page of the slot
+
+			next, err := sw.cursor.Next()
+			if err != nil {
+				return written, err
+			} else if next == 0 {
+				return written, io.ErrShortWrite
+			}
+
+			sw.offset = pageview.OffsetData
+		}
+
+		page := sw.cursor.Current()
+
+		record, err := sw.psm.storagefile.Get(page)
+		if err != nil {
+			return written, err
+		}
+
+		toCopy := chunkSize(uint32(len(p)), sw.psm.recordSize-sw.offset, sw.rest)
+
+		copy(record.Data()[sw.offset:sw.offset+toCopy], p[:toCopy])
+
+		sw.psm.storagefile.ReleaseInUseID(page, true)
+
+		sw.offset += toCopy
+		sw.rest -= toCopy
+		written += int(toCopy)
+		p = p[toCopy:]
+	}
+
+	return written, nil
+}
+
+/*
+Close finishes the slot. An error is returned if not all data was written. The
+slot is freed in this case.
+*/
+func (sw *SlotWriter) Close() error {
+	if sw.rest > 0 {
+		rest := sw.rest
+
+		sw.Abort()
+
+		return fmt.Errorf("Slot data is incomplete: %v bytes are missing", rest)
+	}
+
+	return nil
+}
+
+/*
+Abort frees the slot of this writer.
+*/
+func (sw *SlotWriter) Abort() error {
+	sw.rest = 0
+
+	return sw.psm.Free(sw.location)
+}
+
+/*
+chunkSize returns the size of the next chunk which is the minimum of the
+requested size, the space left on the page and the remaining data.
+*/
+func chunkSize(requested uint32, pageRest uint32, rest uint32) uint32 {
+	if pageRest < requested {
+		requested = pageRest
+	}
+	if rest < requested {
+		requested = rest
+	}
+	return requested
+}
@@ -0,0 +1,198 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package slotting
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging"
+	"devt.de/eliasdb/storage/util"
+)
+
+func TestSlotStreams(t *testing.T) {
+	sf, err := file.NewDefaultStorageFile(DBDIR+"/test15_data", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	psf, err := paging.NewPagedStorageFile(sf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	fsf, err := file.NewDefaultStorageFile(DBDIR+"/test15_free", false)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	fpsf, err := paging.NewPagedStorageFile(fsf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	psm := NewPhysicalSlotManager(psf, fpsf, false)
+
+	// Data which spans several pages
+
+	data := make([]byte, 20000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	sw, err := psm.NewSlotWriter(uint32(len(data)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Write the data in uneven chunks
+
+	for i := 0; i < len(data); i += 777 {
+		end := i + 777
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if n, err := sw.Write(data[i:end]); n != end-i || err != nil {
+			t.Error("Unexpected write result:", n, err)
+			return
+		}
+	}
+
+	if n, err := sw.Write([]byte{1}); n != 0 || err != ErrSlotFull {
+		t.Error("Unexpected write result:", n, err)
+		return
+	}
+
+	if err := sw.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The data can be fetched as a whole
+
+	var buf bytes.Buffer
+
+	if err := psm.Fetch(sw.Location(), &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Error("Unexpected fetch result:", buf.Len(), err)
+		return
+	}
+
+	// Read the data chunk by chunk
+
+	sr, err := psm.NewSlotReader(sw.Location())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if sr.Size() != 20000 {
+		t.Error("Unexpected size:", sr.Size())
+		return
+	}
+
+	chunk := make([]byte, 5000)
+
+	if n, err := sr.Read(chunk); n >= 5000 || n == 0 || err != nil {
+		t.Error("Read should stop at the end of a page:", n, err)
+		return
+	} else if !bytes.Equal(chunk[:n], data[:n]) {
+		t.Error("Unexpected data")
+		return
+	}
+
+	sr, _ = psm.NewSlotReader(sw.Location())
+
+	if res, err := ioutil.ReadAll(sr); err != nil || !bytes.Equal(res, data) {
+		t.Error("Unexpected read result:", len(res), err)
+		return
+	}
+
+	if n, err := sr.Read(chunk); n != 0 || err != io.EOF {
+		t.Error("Unexpected read result:", n, err)
+		return
+	}
+
+	// Data written with Insert can be streamed
+
+	loc, err := psm.Insert(data, 100, 10000)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sr, _ = psm.NewSlotReader(loc)
+
+	if res, err := ioutil.ReadAll(sr); err != nil || !bytes.Equal(res, data[100:10100]) {
+		t.Error("Unexpected read result:", len(res), err)
+		return
+	}
+
+	// Incomplete slots are freed
+
+	sw, err = psm.NewSlotWriter(100)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	sw.Write(make([]byte, 10))
+
+	if err := sw.Close(); err == nil || err.Error() != "Slot data is incomplete: 90 bytes are missing" {
+		t.Error("Unexpected close result:", err)
+		return
+	}
+
+	if current, _, err := psm.SlotSize(sw.Location()); current != 0 || err != nil {
+		t.Error("Unexpected slot size:", current, err)
+		return
+	}
+
+	// Test error cases
+
+	record, _ := sf.Get(util.LocationRecord(loc))
+
+	if _, err := psm.NewSlotReader(loc); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	sr, _ = psm.NewSlotReader(loc)
+	record, _ = sf.Get(util.LocationRecord(loc))
+
+	if _, err := sr.Read(chunk); !errors.Is(err, file.ErrAlreadyInUse) {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	sf.ReleaseInUse(record)
+
+	if err := psf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := fpsf.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,393 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+
+	"devt.de/common/datautil"
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/storage/util"
+)
+
+/*
+Snapshotter is implemented by storage managers which can provide snapshots
+of their data.
+*/
+type Snapshotter interface {
+
+	/*
+		Snapshot returns a readonly storage manager which sees the data as it
+		was at the time of the call. Later changes are not visible in the
+		snapshot. A snapshot must be closed once it is no longer needed.
+	*/
+	Snapshot() (Manager, error)
+}
+
+// Snapshots of disk storage managers
+// ==================================
+
+/*
+byteDiskSnapshot is a snapshot of a ByteDiskStorageManager. The snapshot reads
+the records of a commit from the storage manager. The storage manager keeps
+previous versions of records while they are needed by a snapshot.
+*/
+type byteDiskSnapshot struct {
+	bdsm   *ByteDiskStorageManager // Storage manager of the snapshot
+	ts     uint64                  // Commit timestamp of the snapshot
+	closed bool                    // Flag if the snapshot was closed
+}
+
+/*
+Snapshot returns a readonly snapshot of the data of the last commit (flush).
+Readers of the snapshot do not block writers of the storage manager. Fails
+if there are uncommitted changes and the storage manager does not keep
+versions (see SetVersionRetention).
+*/
+func (bdsm *ByteDiskStorageManager) Snapshot() (Manager, error) {
+	bdsm.checkFileOpen()
+
+	// Continue single threaded from here on
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "Snapshot")
+	defer bdsm.mutex.Unlock()
+
+	return bdsm.snapshotAt(bdsm.versions.commit)
+}
+
+/*
+SnapshotAt returns a readonly snapshot of the data as it was at a given time.
+The time is a unix time in nanoseconds - the last commit before this time is
+read. Returns ErrVersionNotFound if the versions of the time were already
+removed.
+*/
+func (bdsm *ByteDiskStorageManager) SnapshotAt(ts uint64) (Manager, error) {
+	bdsm.checkFileOpen()
+
+	// Continue single threaded from here on
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SnapshotAt")
+	defer bdsm.mutex.Unlock()
+
+	return bdsm.snapshotAt(ts)
+}
+
+/*
+snapshotAt creates a snapshot of a given commit. It is assumed that the
+caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) snapshotAt(ts uint64) (Manager, error) {
+	ts, err := bdsm.versions.pin(ts)
+
+	if err == ErrVersionNotFound {
+		err = ErrVersionNotFound.fireError(bdsm, fmt.Sprint("Timestamp:", ts))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &byteDiskSnapshot{bdsm, ts, false}, nil
+}
+
+/*
+Name returns the name of the snapshot.
+*/
+func (s *byteDiskSnapshot) Name() string {
+	return fmt.Sprint("Snapshot:", s.bdsm.Name())
+}
+
+/*
+Root returns a root value at the time of the snapshot.
+*/
+func (s *byteDiskSnapshot) Root(root int) uint64 {
+	lockprof.Lock(s.bdsm.mutex, lockprof.LockStorage, "SnapshotRoot")
+	defer s.bdsm.mutex.Unlock()
+
+	if s.closed || s.bdsm.physicalSlotsSf == nil {
+		return 0
+	}
+
+	return s.bdsm.rootVersion(root, s.ts)
+}
+
+/*
+SetRoot is a NOP for a snapshot.
+*/
+func (s *byteDiskSnapshot) SetRoot(root int, val uint64) {
+}
+
+/*
+Insert is not supported by a snapshot.
+*/
+func (s *byteDiskSnapshot) Insert(o interface{}) (uint64, error) {
+	return 0, ErrReadonly
+}
+
+/*
+Update is not supported by a snapshot.
+*/
+func (s *byteDiskSnapshot) Update(loc uint64, o interface{}) error {
+	return ErrReadonly
+}
+
+/*
+Free is not supported by a snapshot.
+*/
+func (s *byteDiskSnapshot) Free(loc uint64) error {
+	return ErrReadonly
+}
+
+/*
+Fetch fetches an object as it was at the time of the snapshot and writes it
+to a given data container.
+*/
+func (s *byteDiskSnapshot) Fetch(loc uint64, o interface{}) error {
+
+	lockprof.Lock(s.bdsm.mutex, lockprof.LockStorage, "SnapshotFetch")
+	defer s.bdsm.mutex.Unlock()
+
+	if s.closed || s.bdsm.physicalSlotsSf == nil {
+		return ErrSnapshotClosed.fireError(s, fmt.Sprint("Location:",
+			util.LocationRecord(loc), util.LocationOffset(loc)))
+	}
+
+	return s.bdsm.fetchVersion(loc, s.ts, o)
+}
+
+/*
+FetchCached is not supported by a snapshot.
+*/
+func (s *byteDiskSnapshot) FetchCached(loc uint64) (interface{}, error) {
+	return nil, ErrNotInCache
+}
+
+/*
+Flush is a NOP for a snapshot.
+*/
+func (s *byteDiskSnapshot) Flush() error {
+	return nil
+}
+
+/*
+Rollback is a NOP for a snapshot.
+*/
+func (s *byteDiskSnapshot) Rollback() error {
+	return nil
+}
+
+/*
+Close closes the snapshot. Versions which are no longer needed are removed
+from the storage manager.
+*/
+func (s *byteDiskSnapshot) Close() error {
+	lockprof.Lock(s.bdsm.mutex, lockprof.LockStorage, "SnapshotClose")
+	defer s.bdsm.mutex.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.bdsm.versions.unpin(s.ts)
+	}
+
+	return nil
+}
+
+/*
+diskSnapshot is a snapshot of a DiskStorageManager.
+*/
+type diskSnapshot struct {
+	*byteDiskSnapshot
+}
+
+/*
+Snapshot returns a readonly snapshot of the stored data (see
+ByteDiskStorageManager.Snapshot).
+*/
+func (dsm *DiskStorageManager) Snapshot() (Manager, error) {
+	snap, err := dsm.ByteDiskStorageManager.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return &diskSnapshot{snap.(*byteDiskSnapshot)}, nil
+}
+
+/*
+SnapshotAt returns a readonly snapshot of the stored data as it was at a
+given time (see ByteDiskStorageManager.SnapshotAt).
+*/
+func (dsm *DiskStorageManager) SnapshotAt(ts uint64) (Manager, error) {
+	snap, err := dsm.ByteDiskStorageManager.SnapshotAt(ts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &diskSnapshot{snap.(*byteDiskSnapshot)}, nil
+}
+
+/*
+Fetch fetches an object as it was at the time of the snapshot and writes it
+to a given data container.
+*/
+func (s *diskSnapshot) Fetch(loc uint64, o interface{}) error {
+
+	// Request a buffer from the buffer pool
+
+	bb := BufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		bb.Reset()
+		BufferPool.Put(bb)
+	}()
+
+	if err := s.byteDiskSnapshot.Fetch(loc, bb); err != nil {
+		return err
+	}
+
+	//  Deserialize the object from a gob bytes stream
+
+	return gob.NewDecoder(bb).Decode(o)
+}
+
+/*
+Snapshot returns a readonly snapshot of the stored data (see
+ByteDiskStorageManager.Snapshot). Modified records which are held in the
+cache are not part of the last commit and are not visible in the snapshot.
+The snapshot does not use the cache.
+*/
+func (cdsm *CachedDiskStorageManager) Snapshot() (Manager, error) {
+	return cdsm.diskstoragemanager.Snapshot()
+}
+
+/*
+SnapshotAt returns a readonly snapshot of the stored data as it was at a
+given time (see ByteDiskStorageManager.SnapshotAt). The snapshot does not use
+the cache.
+*/
+func (cdsm *CachedDiskStorageManager) SnapshotAt(ts uint64) (Manager, error) {
+	return cdsm.diskstoragemanager.SnapshotAt(ts)
+}
+
+// Snapshots of memory storage managers
+// ====================================
+
+/*
+memorySnapshot is a snapshot of a MemoryStorageManager. It holds a copy of
+all stored objects.
+*/
+type memorySnapshot struct {
+	*MemoryStorageManager
+}
+
+/*
+Snapshot returns a readonly snapshot of the stored data. All stored objects
+are copied.
+*/
+func (msm *MemoryStorageManager) Snapshot() (Manager, error) {
+	msm.mutex.Lock()
+	defer msm.mutex.Unlock()
+
+	snap := NewMemoryStorageManager("Snapshot:" + msm.name)
+
+	for root, val := range msm.Roots {
+		snap.Roots[root] = val
+	}
+
+	for loc, obj := range msm.Data {
+		objCopy, err := copyObject(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		snap.Data[loc] = objCopy
+	}
+
+	snap.LocCount = msm.LocCount
+
+	return &memorySnapshot{snap}, nil
+}
+
+/*
+copyObject returns a deep copy of a stored object.
+*/
+func copyObject(obj interface{}) (interface{}, error) {
+	if obj == nil {
+		return nil, nil
+	}
+
+	t := reflect.TypeOf(obj)
+
+	if t.Kind() == reflect.Ptr {
+		ret := reflect.New(t.Elem())
+		err := datautil.CopyObject(obj, ret.Interface())
+		return ret.Interface(), err
+	}
+
+	ret := reflect.New(t)
+	err := datautil.CopyObject(obj, ret.Interface())
+
+	return ret.Elem().Interface(), err
+}
+
+/*
+SetRoot is a NOP for a snapshot.
+*/
+func (s *memorySnapshot) SetRoot(root int, val uint64) {
+}
+
+/*
+Insert is not supported by a snapshot.
+*/
+func (s *memorySnapshot) Insert(o interface{}) (uint64, error) {
+	return 0, ErrReadonly
+}
+
+/*
+Update is not supported by a snapshot.
+*/
+func (s *memorySnapshot) Update(loc uint64, o interface{}) error {
+	return ErrReadonly
+}
+
+/*
+Free is not supported by a snapshot.
+*/
+func (s *memorySnapshot) Free(loc uint64) error {
+	return ErrReadonly
+}
+
+/*
+Flush is a NOP for a snapshot.
+*/
+func (s *memorySnapshot) Flush() error {
+	return nil
+}
+
+/*
+Rollback is a NOP for a snapshot.
+*/
+func (s *memorySnapshot) Rollback() error {
+	return nil
+}
+
+/*
+Close releases the copied objects of the snapshot.
+*/
+func (s *memorySnapshot) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Data = make(map[uint64]interface{})
+
+	return nil
+}
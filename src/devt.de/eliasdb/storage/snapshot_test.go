@@ -0,0 +1,187 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiskStorageManagerSnapshot(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/test17", false, false, false, true)
+	cdsm := NewCachedDiskStorageManager(dsm, 10)
+
+	cdsm.SetWriteBack(10)
+
+	large := strings.Repeat("x", int(DefaultBlobThreshold)*2)
+
+	loc1, _ := cdsm.Insert("test1")
+	loc2, _ := cdsm.Insert(large)
+	cdsm.SetRoot(RootIDVersion+1, 5)
+
+	cdsm.Update(loc1, "test1a")
+
+	// Snapshots cannot be taken while there are uncommitted changes which
+	// are not tracked
+
+	if _, err := cdsm.Snapshot(); err == nil ||
+		err.Error() != "Cannot read versions while there are uncommitted changes" {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := cdsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	snap, err := cdsm.Snapshot()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Change everything after the snapshot was taken
+
+	cdsm.Update(loc1, "test1b")
+	cdsm.Free(loc2)
+	loc3, _ := cdsm.Insert("test3")
+	cdsm.SetRoot(RootIDVersion+1, 6)
+
+	if err := cdsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var res string
+
+	if err := snap.Fetch(loc1, &res); err != nil || res != "test1a" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := snap.Fetch(loc2, &res); err != nil || res != large {
+		t.Error("Unexpected result:", len(res), err)
+		return
+	}
+
+	if snap.Root(RootIDVersion+1) != 5 || cdsm.Root(RootIDVersion+1) != 6 {
+		t.Error("Unexpected result:", snap.Root(RootIDVersion+1))
+		return
+	}
+
+	if err := cdsm.Fetch(loc1, &res); err != nil || res != "test1b" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := snap.FetchCached(loc1); err != ErrNotInCache {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Snapshots are readonly
+
+	if _, err := snap.Insert("test"); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := snap.Update(loc3, "test"); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := snap.Free(loc3); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// A second snapshot sees the new version - modified records in the
+	// cache are not part of a commit
+
+	snap2, _ := dsm.Snapshot()
+
+	cdsm.Update(loc1, "test1c")
+
+	if err := snap2.Fetch(loc1, &res); err != nil || res != "test1b" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := snap.Fetch(loc1, &res); err != nil || res != "test1a" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := snap.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := snap.Fetch(loc1, &res); err == nil || !strings.HasPrefix(err.Error(), "Snapshot is closed") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Closing the storage manager closes all snapshots
+
+	if err := cdsm.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := snap2.Fetch(loc1, &res); err == nil || !strings.HasPrefix(err.Error(), "Snapshot is closed") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestMemoryStorageManagerSnapshot(t *testing.T) {
+	msm := NewMemoryStorageManager("test")
+
+	loc, _ := msm.Insert(map[string]string{"a": "1"})
+	msm.SetRoot(RootIDVersion, 5)
+
+	snap, err := msm.Snapshot()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Objects are copied - changes of the stored object are not visible
+
+	obj, _ := msm.FetchCached(loc)
+	obj.(map[string]string)["a"] = "2"
+	msm.SetRoot(RootIDVersion, 6)
+
+	var res map[string]string
+
+	if err := snap.Fetch(loc, &res); err != nil || res["a"] != "1" || snap.Root(RootIDVersion) != 5 {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if _, err := snap.Insert("test"); err != ErrReadonly {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := snap.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := snap.Fetch(loc, &res); err == nil {
+		t.Error("Fetching from a closed snapshot should fail")
+		return
+	}
+}
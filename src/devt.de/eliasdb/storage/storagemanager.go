@@ -10,11 +10,22 @@
 
 package storage
 
+import (
+	"devt.de/eliasdb/storage/file"
+	"devt.de/eliasdb/storage/paging"
+)
+
 /*
 RootIDVersion is the root id holding the version.
 */
 const RootIDVersion = 1
 
+/*
+TotalRoots is the number of root values of a disk storage manager which uses
+the default record size.
+*/
+const TotalRoots = (file.DefaultRecordSize - paging.UserDataSize - paging.OffsetRoots) / file.SizeLong
+
 /*
 Manager describes an abstract storage manager.
 */
@@ -77,3 +88,56 @@ type Manager interface {
 	*/
 	Close() error
 }
+
+/*
+MemoryUsage contains the memory which is held by a storage manager.
+*/
+type MemoryUsage struct {
+	Records      uint64 // Bytes of file records which are held in memory
+	TransRecords uint64 // Bytes of file records which are held for transactions
+	CacheObjects uint64 // Number of objects which are held in an object cache
+	CacheBytes   uint64 // Bytes of records of the objects which are held in an object cache
+}
+
+/*
+CacheStats contains counters of the object cache of a storage manager.
+*/
+type CacheStats struct {
+	Hits       uint64 // Number of requests which were served from the cache
+	Misses     uint64 // Number of requests for objects which were not in the cache
+	Evictions  uint64 // Number of objects which were removed to make room for other objects
+	Writebacks uint64 // Number of modified cached objects which were written to disk
+}
+
+/*
+SizeReport contains the size of the data which is stored by a storage manager.
+*/
+type SizeReport struct {
+	Slots     uint64 // Number of used storage slots
+	DataBytes uint64 // Bytes of stored data
+	SlotBytes uint64 // Bytes which are allocated by the used storage slots
+}
+
+/*
+SizeReporter is implemented by storage managers which can report the size of
+their stored data.
+*/
+type SizeReporter interface {
+
+	/*
+		SizeReport returns the size of all stored data.
+	*/
+	SizeReport() (*SizeReport, error)
+}
+
+/*
+MemoryReporter is implemented by storage managers which can report the
+memory they hold.
+*/
+type MemoryReporter interface {
+
+	/*
+		MemoryUsage returns the memory which is currently held.
+	*/
+	MemoryUsage() *MemoryUsage
+}
@@ -0,0 +1,486 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+
+	"devt.de/eliasdb/lockprof"
+	"devt.de/eliasdb/storage/util"
+)
+
+/*
+MaxVersionCount is the maximum number of versions which are kept by a disk
+storage manager. The oldest versions are removed if there are more versions -
+even if they are still within the version retention time. Versions which are
+needed by open snapshots are always kept.
+*/
+var MaxVersionCount = 100000
+
+/*
+LogVersions is called with errors which occurred while keeping versions
+*/
+var LogVersions ScrubLogger = func(v ...interface{}) {}
+
+/*
+rowVersion is a previous version of a record or a root value.
+*/
+type rowVersion struct {
+	until  uint64 // Timestamp of the commit which replaced the version (0 if not yet committed)
+	data   []byte // Data of the version
+	exists bool   // Flag if the record existed
+}
+
+/*
+versionRef references the versions of a record or a root value.
+*/
+type versionRef struct {
+	loc  uint64 // Location of a record
+	root int    // Id of a root value (-1 for records)
+}
+
+/*
+versionStore holds the previous versions of all records and root values of a
+disk storage manager which are still needed. Every flush of the storage
+manager is a commit which gets a unique timestamp. A version is kept until
+it is older than the version retention time and no reader needs it anymore.
+The number of kept versions is bounded by MaxVersionCount. Versions are only
+kept in memory - they are lost when the storage manager is closed.
+*/
+type versionStore struct {
+	commit    uint64                   // Timestamp of the last commit
+	collected uint64                   // Oldest commit timestamp which can still be read
+	retention time.Duration            // Time for which replaced versions are kept
+	rows      map[uint64][]*rowVersion // Previous versions of records (oldest first)
+	roots     map[int][]*rowVersion    // Previous versions of root values (oldest first)
+	pending   []versionRef             // Versions which were replaced by uncommitted changes
+	history   []versionRef             // Committed versions ordered by their commit
+	readers   map[uint64]int           // Number of readers of each commit
+	untracked bool                     // Flag if there are uncommitted changes without versions
+}
+
+/*
+newVersionStore creates a new version store.
+*/
+func newVersionStore() *versionStore {
+	now := uint64(time.Now().UnixNano())
+
+	return &versionStore{now, now, 0, make(map[uint64][]*rowVersion),
+		make(map[int][]*rowVersion), nil, nil, make(map[uint64]int), false}
+}
+
+/*
+active checks if changes need to be tracked.
+*/
+func (vs *versionStore) active() bool {
+	return vs.retention > 0 || len(vs.readers) > 0
+}
+
+/*
+chain returns the versions of a record or root value.
+*/
+func (vs *versionStore) chain(ref versionRef) []*rowVersion {
+	if ref.root == -1 {
+		return vs.rows[ref.loc]
+	}
+	return vs.roots[ref.root]
+}
+
+/*
+setChain sets the versions of a record or root value.
+*/
+func (vs *versionStore) setChain(ref versionRef, chain []*rowVersion) {
+	if ref.root == -1 {
+		if len(chain) == 0 {
+			delete(vs.rows, ref.loc)
+		} else {
+			vs.rows[ref.loc] = chain
+		}
+	} else {
+		if len(chain) == 0 {
+			delete(vs.roots, ref.root)
+		} else {
+			vs.roots[ref.root] = chain
+		}
+	}
+}
+
+/*
+preserve keeps the current version of a record or root value before it is
+changed for the first time in the current transaction. The current version is
+only requested if it is needed.
+*/
+func (vs *versionStore) preserve(ref versionRef, current func() ([]byte, bool, error)) error {
+
+	if !vs.active() {
+		vs.untracked = true
+		return nil
+	}
+
+	chain := vs.chain(ref)
+
+	if len(chain) > 0 && chain[len(chain)-1].until == 0 {
+		return nil // The version was already kept in this transaction
+	}
+
+	data, exists, err := current()
+	if err != nil {
+		return err
+	}
+
+	vs.setChain(ref, append(chain, &rowVersion{0, data, exists}))
+	vs.pending = append(vs.pending, ref)
+
+	return nil
+}
+
+/*
+commitVersions gives all versions which were replaced in the current
+transaction the timestamp of a new commit.
+*/
+func (vs *versionStore) commitVersions() {
+	ts := uint64(time.Now().UnixNano())
+
+	if ts <= vs.commit {
+		ts = vs.commit + 1
+	}
+
+	for _, ref := range vs.pending {
+		chain := vs.chain(ref)
+		chain[len(chain)-1].until = ts
+	}
+
+	vs.history = append(vs.history, vs.pending...)
+	vs.pending = nil
+	vs.untracked = false
+	vs.commit = ts
+
+	vs.collect()
+}
+
+/*
+rollbackVersions removes all versions which were replaced in the current
+transaction.
+*/
+func (vs *versionStore) rollbackVersions() {
+	for _, ref := range vs.pending {
+		chain := vs.chain(ref)
+		vs.setChain(ref, chain[:len(chain)-1])
+	}
+
+	vs.pending = nil
+	vs.untracked = false
+}
+
+/*
+collect removes all versions which are no longer needed. Returns the number
+of removed versions.
+*/
+func (vs *versionStore) collect() int {
+	horizon := vs.commit
+
+	if vs.retention > 0 {
+		horizon -= uint64(vs.retention.Nanoseconds())
+	}
+
+	readerHorizon := vs.commit
+
+	for ts := range vs.readers {
+		if ts < readerHorizon {
+			readerHorizon = ts
+		}
+	}
+
+	if readerHorizon < horizon {
+		horizon = readerHorizon
+	}
+
+	if horizon > vs.collected {
+		vs.collected = horizon
+	}
+
+	// Committed versions are removed in the order of their commits
+
+	removed := 0
+
+	for len(vs.history) > 0 {
+		ref := vs.history[0]
+		chain := vs.chain(ref)
+
+		if until := chain[0].until; until > vs.collected {
+
+			// Remove versions beyond the maximum version count if no
+			// reader needs them - commits before the removed version can
+			// no longer be read
+
+			if len(vs.history)+len(vs.pending) <= MaxVersionCount || until > readerHorizon {
+				break
+			}
+
+			vs.collected = until
+		}
+
+		vs.setChain(ref, chain[1:])
+		vs.history = vs.history[1:]
+		removed++
+	}
+
+	return removed
+}
+
+/*
+versionAt returns the version of a record or root value which was current at
+a given commit. Returns nil if the current version should be used.
+*/
+func (vs *versionStore) versionAt(ref versionRef, ts uint64) *rowVersion {
+	for _, v := range vs.chain(ref) {
+		if v.until == 0 || v.until > ts {
+			return v
+		}
+	}
+	return nil
+}
+
+/*
+pin registers a reader of a given commit. Returns the commit which is read.
+*/
+func (vs *versionStore) pin(ts uint64) (uint64, error) {
+
+	if vs.untracked {
+		return 0, fmt.Errorf("Cannot read versions while there are uncommitted changes")
+	} else if ts < vs.collected {
+		return 0, ErrVersionNotFound
+	} else if ts > vs.commit {
+		ts = vs.commit
+	}
+
+	vs.readers[ts]++
+
+	return ts, nil
+}
+
+/*
+unpin removes a reader of a given commit.
+*/
+func (vs *versionStore) unpin(ts uint64) {
+	if vs.readers[ts]--; vs.readers[ts] <= 0 {
+		delete(vs.readers, ts)
+	}
+
+	vs.collect()
+}
+
+// Versions of ByteDiskStorageManager
+// ==================================
+
+/*
+SetVersionRetention sets the time for which replaced versions of records are
+kept. Versions which are older than the retention time are removed on the
+next commit (flush). Versions are only kept for open snapshots if the
+retention time is 0. Versions are kept in memory and are bounded by
+MaxVersionCount - previous versions cannot be read after the storage manager
+was reopened.
+*/
+func (bdsm *ByteDiskStorageManager) SetVersionRetention(retention time.Duration) {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "SetVersionRetention")
+	defer bdsm.mutex.Unlock()
+
+	bdsm.versions.retention = retention
+	bdsm.versions.collect()
+}
+
+/*
+CommitTimestamp returns the timestamp of the last commit (flush). The
+timestamp is a unix time in nanoseconds.
+*/
+func (bdsm *ByteDiskStorageManager) CommitTimestamp() uint64 {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "CommitTimestamp")
+	defer bdsm.mutex.Unlock()
+
+	return bdsm.versions.commit
+}
+
+/*
+OldestTimestamp returns the oldest commit timestamp which can still be read.
+*/
+func (bdsm *ByteDiskStorageManager) OldestTimestamp() uint64 {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "OldestTimestamp")
+	defer bdsm.mutex.Unlock()
+
+	return bdsm.versions.collected
+}
+
+/*
+VersionCount returns the number of kept versions.
+*/
+func (bdsm *ByteDiskStorageManager) VersionCount() int {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "VersionCount")
+	defer bdsm.mutex.Unlock()
+
+	return len(bdsm.versions.pending) + len(bdsm.versions.history)
+}
+
+/*
+FetchVersion fetches a record as it was at a given time. The time is a unix
+time in nanoseconds - the last commit before this time is read. Returns
+ErrVersionNotFound if the versions of the time were already removed.
+*/
+func (bdsm *ByteDiskStorageManager) FetchVersion(loc uint64, ts uint64, o interface{}) error {
+	bdsm.checkFileOpen()
+
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "FetchVersion")
+	defer bdsm.mutex.Unlock()
+
+	if ts < bdsm.versions.collected {
+		return ErrVersionNotFound.fireError(bdsm, fmt.Sprint("Timestamp:", ts))
+	} else if ts > bdsm.versions.commit {
+		ts = bdsm.versions.commit
+	}
+
+	return bdsm.fetchVersion(loc, ts, o)
+}
+
+/*
+ChangedSince checks if a record was changed by a commit after a given time.
+Uncommitted changes are not considered. Returns ErrVersionNotFound if the
+versions of the time were already removed.
+*/
+func (bdsm *ByteDiskStorageManager) ChangedSince(loc uint64, ts uint64) (bool, error) {
+	lockprof.Lock(bdsm.mutex, lockprof.LockStorage, "ChangedSince")
+	defer bdsm.mutex.Unlock()
+
+	if ts < bdsm.versions.collected {
+		return false, ErrVersionNotFound.fireError(bdsm, fmt.Sprint("Timestamp:", ts))
+	}
+
+	for _, v := range bdsm.versions.rows[loc] {
+		if v.until > ts {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+FetchVersion fetches an object as it was at a given time (see
+ByteDiskStorageManager.FetchVersion).
+*/
+func (dsm *DiskStorageManager) FetchVersion(loc uint64, ts uint64, o interface{}) error {
+
+	// Request a buffer from the buffer pool
+
+	bb := BufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		bb.Reset()
+		BufferPool.Put(bb)
+	}()
+
+	if err := dsm.ByteDiskStorageManager.FetchVersion(loc, ts, bb); err != nil {
+		return err
+	}
+
+	//  Deserialize the object from a gob bytes stream
+
+	return gob.NewDecoder(bb).Decode(o)
+}
+
+/*
+fetchVersion fetches a record as it was at a given commit. It is assumed
+that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) fetchVersion(loc uint64, ts uint64, o interface{}) error {
+	var err error
+
+	if v := bdsm.versions.versionAt(versionRef{loc, -1}, ts); v != nil {
+
+		if !v.exists {
+			return ErrSlotNotFound.fireError(bdsm, fmt.Sprint("Location:",
+				util.LocationRecord(loc), util.LocationOffset(loc)))
+		}
+
+		if w, ok := o.(io.Writer); ok {
+			_, err = w.Write(v.data)
+		} else {
+			copy(o.([]byte), v.data)
+		}
+
+		return err
+	}
+
+	// The record was not changed since the commit
+
+	ploc, err := bdsm.logicalSlotManager.Fetch(loc)
+	if err != nil {
+		return err
+	}
+
+	if ploc == 0 {
+		return ErrSlotNotFound.fireError(bdsm, fmt.Sprint("Location:",
+			util.LocationRecord(loc), util.LocationOffset(loc)))
+	}
+
+	if w, ok := o.(io.Writer); ok {
+		return bdsm.physicalSlotManager.Fetch(ploc, w)
+	}
+
+	var b bytes.Buffer
+	err = bdsm.physicalSlotManager.Fetch(ploc, &b)
+	copy(o.([]byte), b.Bytes())
+
+	return err
+}
+
+/*
+rootVersion returns a root value as it was at a given commit. It is assumed
+that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) rootVersion(root int, ts uint64) uint64 {
+	if v := bdsm.versions.versionAt(versionRef{0, root}, ts); v != nil {
+		return binary.LittleEndian.Uint64(v.data)
+	}
+	return bdsm.physicalSlotsPager.Header().Root(root)
+}
+
+/*
+preserveRecord keeps the current version of a record before it is changed.
+It is assumed that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) preserveRecord(loc uint64, ploc uint64) error {
+	return bdsm.versions.preserve(versionRef{loc, -1}, func() ([]byte, bool, error) {
+		var b bytes.Buffer
+
+		if ploc == 0 {
+			return nil, false, nil // The record did not exist
+		}
+
+		err := bdsm.physicalSlotManager.Fetch(ploc, &b)
+
+		return b.Bytes(), true, err
+	})
+}
+
+/*
+preserveRoot keeps the current version of a root value before it is changed.
+It is assumed that the caller holds the mutex.
+*/
+func (bdsm *ByteDiskStorageManager) preserveRoot(root int) error {
+	return bdsm.versions.preserve(versionRef{0, root}, func() ([]byte, bool, error) {
+		data := make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, bdsm.physicalSlotsPager.Header().Root(root))
+		return data, true, nil
+	})
+}
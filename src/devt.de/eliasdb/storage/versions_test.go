@@ -0,0 +1,251 @@
+/*
+ * EliasDB
+ *
+ * Copyright 2016 Matthias Ladkau. All rights reserved.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskStorageManagerVersions(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/test18", false, false, false, true)
+	defer dsm.Close()
+
+	dsm.SetVersionRetention(time.Hour)
+
+	loc1, _ := dsm.Insert("v1")
+	dsm.SetRoot(RootIDVersion+1, 1)
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ts1 := dsm.CommitTimestamp()
+
+	dsm.Update(loc1, "v2")
+	loc2, _ := dsm.Insert("new")
+	dsm.SetRoot(RootIDVersion+1, 2)
+
+	if err := dsm.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ts2 := dsm.CommitTimestamp()
+
+	if ts2 <= ts1 {
+		t.Error("Unexpected commit timestamps:", ts1, ts2)
+		return
+	}
+
+	// Uncommitted changes are not visible in previous versions
+
+	dsm.Update(loc1, "v3")
+
+	var res string
+
+	if err := dsm.FetchVersion(loc1, ts1, &res); err != nil || res != "v1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dsm.FetchVersion(loc1, ts2, &res); err != nil || res != "v2" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dsm.Fetch(loc1, &res); err != nil || res != "v3" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if err := dsm.FetchVersion(loc2, ts1, &res); err == nil ||
+		!strings.HasPrefix(err.Error(), "Slot not found") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	// Changes are detected by commit
+
+	if changed, err := dsm.ChangedSince(loc1, ts1); !changed || err != nil {
+		t.Error("Unexpected result:", changed, err)
+		return
+	}
+
+	if changed, err := dsm.ChangedSince(loc1, ts2); changed || err != nil {
+		t.Error("Unexpected result:", changed, err)
+		return
+	}
+
+	// A rollback drops the versions of the rolled back changes
+
+	if count := dsm.VersionCount(); count != 6 {
+		t.Error("Unexpected version count:", count)
+		return
+	}
+
+	if err := dsm.Rollback(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if count := dsm.VersionCount(); count != 5 {
+		t.Error("Unexpected version count:", count)
+		return
+	}
+
+	// Time travel with snapshots
+
+	snap, err := dsm.SnapshotAt(ts1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := snap.Fetch(loc1, &res); err != nil || res != "v1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if snap.Root(RootIDVersion+1) != 1 || dsm.Root(RootIDVersion+1) != 2 {
+		t.Error("Unexpected result:", snap.Root(RootIDVersion+1))
+		return
+	}
+
+	// Versions which are needed by a snapshot are not collected
+
+	dsm.SetVersionRetention(0)
+
+	dsm.Free(loc2)
+	dsm.Flush()
+
+	if err := snap.Fetch(loc1, &res); err != nil || res != "v1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	if oldest := dsm.OldestTimestamp(); oldest != ts1 {
+		t.Error("Unexpected oldest timestamp:", oldest, ts1)
+		return
+	}
+
+	// Closing the snapshot collects all versions
+
+	snap.Close()
+
+	if count := dsm.VersionCount(); count != 0 {
+		t.Error("Unexpected version count:", count)
+		return
+	}
+
+	if oldest := dsm.OldestTimestamp(); oldest != dsm.CommitTimestamp() {
+		t.Error("Unexpected oldest timestamp:", oldest)
+		return
+	}
+
+	if err := dsm.FetchVersion(loc1, ts2, &res); err == nil ||
+		!strings.HasPrefix(err.Error(), "Version not found") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := dsm.ChangedSince(loc1, ts1); err == nil ||
+		!strings.HasPrefix(err.Error(), "Version not found") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if _, err := dsm.SnapshotAt(ts1); err == nil ||
+		!strings.HasPrefix(err.Error(), "Version not found") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+}
+
+func TestDiskStorageManagerVersionLimit(t *testing.T) {
+	dsm := NewDiskStorageManager(DBDIR+"/test18a", false, false, false, true)
+	defer dsm.Close()
+
+	oldMaxVersionCount := MaxVersionCount
+	MaxVersionCount = 3
+	defer func() {
+		MaxVersionCount = oldMaxVersionCount
+	}()
+
+	dsm.SetVersionRetention(time.Hour)
+
+	loc, _ := dsm.Insert("v0")
+	dsm.Flush()
+
+	var ts []uint64
+
+	for i := 1; i <= 4; i++ {
+		dsm.Update(loc, fmt.Sprint("v", i))
+		dsm.Flush()
+		ts = append(ts, dsm.CommitTimestamp())
+	}
+
+	// Only the newest versions are kept
+
+	if count := dsm.VersionCount(); count != 3 {
+		t.Error("Unexpected version count:", count)
+		return
+	}
+
+	if oldest := dsm.OldestTimestamp(); oldest != ts[0] {
+		t.Error("Unexpected oldest timestamp:", oldest, ts[0])
+		return
+	}
+
+	var res string
+
+	if err := dsm.FetchVersion(loc, ts[0]-1, &res); err == nil ||
+		!strings.HasPrefix(err.Error(), "Version not found") {
+		t.Error("Unexpected result:", err)
+		return
+	}
+
+	if err := dsm.FetchVersion(loc, ts[0], &res); err != nil || res != "v1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	// Versions which are needed by a snapshot are kept beyond the limit
+
+	snap, err := dsm.SnapshotAt(ts[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	dsm.Update(loc, "v5")
+	dsm.Flush()
+
+	if count := dsm.VersionCount(); count != 4 {
+		t.Error("Unexpected version count:", count)
+		return
+	}
+
+	if err := snap.Fetch(loc, &res); err != nil || res != "v1" {
+		t.Error("Unexpected result:", res, err)
+		return
+	}
+
+	snap.Close()
+
+	if count := dsm.VersionCount(); count != 3 {
+		t.Error("Unexpected version count:", count)
+		return
+	}
+}
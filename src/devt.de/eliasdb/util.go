@@ -50,6 +50,7 @@ func handleCommandLine(gm *graph.Manager) bool {
 	importFile := flag.String("import", "", "Import a graph from a JSON file to a partition (exit if storing on disk)")
 	exportFile := flag.String("dumpdb", "", "Dump the contents of a partition to a JSON file and exit")
 	part := flag.String("part", "", "Partition to operate on when importing or dumping data")
+	compat := flag.Bool("compat", false, "Dump data which can be imported by the previous major version")
 	showHelp := flag.Bool("?", false, "Show this help message")
 
 	flag.Usage = func() {
@@ -104,7 +105,14 @@ func handleCommandLine(gm *graph.Manager) bool {
 
 		print("Dumping into ", *exportFile)
 
-		err := handleJSONExport(gm, *part, *exportFile)
+		var err error
+
+		if *compat {
+			err = handleJSONCompatExport(gm, *part, *exportFile)
+		} else {
+			err = handleJSONExport(gm, *part, *exportFile)
+		}
+
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Could not dump graph: ", err)
 			return false
@@ -127,10 +135,6 @@ following format:
 */
 func handleJSONExport(gm *graph.Manager, part string, filename string) error {
 
-	// Use a map to unique found edge keys
-
-	edgeKeys := make(map[string]string)
-
 	// Open output file
 
 	outFile, err := os.Create(filename)
@@ -139,122 +143,31 @@ func handleJSONExport(gm *graph.Manager, part string, filename string) error {
 	}
 	defer outFile.Close()
 
-	writeData := func(data map[string]interface{}) {
-
-		nk := 0
-		for k, v := range data {
-
-			// JSON encode value - ignore values which cannot be JSON encoded
-
-			jv, err := json.Marshal(v)
-
-			// Encoding errors result in a null value
-
-			if err != nil {
-				jv = []byte("null")
-			}
-
-			// Write out the node attributes
-
-			outFile.WriteString(fmt.Sprintf("      \"%s\" : %s", k, jv))
-			if nk < len(data)-1 {
-				outFile.WriteString(",")
-			}
-			outFile.WriteString("\n")
-			nk++
-		}
-	}
-
-	// Iterate over all available node kinds
-
-	outFile.WriteString(`{
-  "nodes" : [
-`)
-
-	kinds := gm.NodeKinds()
-	for ik, kind := range kinds {
-
-		// Loop over all available kinds
-
-		it, err := gm.NodeKeyIterator(part, kind)
-		if err != nil {
-			return err
-		}
-
-		// Iterate over all node keys
-
-		for i := 0; it.HasNext(); i++ {
-			key := it.Next()
-
-			if it.LastError != nil {
-				return it.LastError
-			}
-
-			node, err := gm.FetchNode(part, key, kind)
-			if err != nil {
-				return err
-			}
-
-			// Fetch all connected relationships and store their key and kind
-
-			_, edges, err := gm.TraverseMulti(part, key, kind, ":::", false)
-			if err != nil {
-				return err
-			}
-
-			for _, edge := range edges {
-				edgeKeys[edge.Kind()+edge.Key()] = edge.Kind()
-			}
-
-			// Write out JSON object
+	return graph.ExportPartition(outFile, part, gm)
+}
 
-			outFile.WriteString("    {\n")
+/*
+handleJSONCompatExport dumps the contents of a partition to a JSON file which
+can be imported by the previous major version. Every attribute value which
+had to be mapped or dropped is reported.
+*/
+func handleJSONCompatExport(gm *graph.Manager, part string, filename string) error {
 
-			writeData(node.Data())
+	// Open output file
 
-			if it.HasNext() || ik < len(kinds)-1 {
-				outFile.WriteString("    },\n")
-			} else {
-				outFile.WriteString("    }\n")
-			}
-		}
+	outFile, err := os.Create(filename)
+	if err != nil {
+		return err
 	}
+	defer outFile.Close()
 
-	outFile.WriteString(`  ],
-  "edges" : [
-`)
-
-	// Iterate over all available edge kinds
-
-	ie := 0
-	for key, kind := range edgeKeys {
-		key = key[len(kind):]
-
-		edge, err := gm.FetchEdge(part, key, kind)
-		if err != nil {
-			return err
-		}
-
-		// Write out JSON object
-
-		outFile.WriteString("    {\n")
-
-		writeData(edge.Data())
-
-		if ie < len(edgeKeys)-1 {
-			outFile.WriteString("    },\n")
-		} else {
-			outFile.WriteString("    }\n")
-		}
+	warnings, err := graph.ExportPartitionCompat(outFile, part, gm)
 
-		ie++
+	for _, w := range warnings {
+		print("Warning: ", w)
 	}
 
-	outFile.WriteString(`  ]
-}
-`)
-
-	return nil
+	return err
 }
 
 /*
@@ -306,3 +219,22 @@ func handleJSONImport(gm *graph.Manager, part string, stringData []byte) error {
 
 	return trans.Commit()
 }
+
+/*
+applyDefinitionsFile applies a definitions document from a JSON file.
+*/
+func applyDefinitionsFile(gm *graph.Manager, filename string, prune bool) (*graph.DefinitionChanges, error) {
+
+	inFile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer inFile.Close()
+
+	defs, err := graph.ParseDefinitions(inFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return gm.ApplyDefinitions(defs, prune, false)
+}